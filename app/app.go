@@ -57,6 +57,11 @@ import (
 	ibctm "github.com/cosmos/ibc-go/v10/modules/light-clients/07-tendermint"
 	ibctesting "github.com/cosmos/ibc-go/v10/testing"
 	evmdconfig "github.com/mail-chat-chain/mailchatd/config"
+	"github.com/mail-chat-chain/mailchatd/internal/blockchain"
+	"github.com/mail-chat-chain/mailchatd/internal/chainreg"
+	"github.com/mail-chat-chain/mailchatd/x/mail"
+	mailkeeper "github.com/mail-chat-chain/mailchatd/x/mail/keeper"
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
 
 	autocliv1 "cosmossdk.io/api/cosmos/autocli/v1"
 	reflectionv1 "cosmossdk.io/api/cosmos/reflection/v1"
@@ -197,6 +202,9 @@ type EVMD struct {
 	PreciseBankKeeper precisebankkeeper.Keeper
 	EVMMempool        *evmmempool.ExperimentalEVMMempool
 
+	// MailChat keepers
+	MailKeeper mailkeeper.Keeper
+
 	// the module manager
 	ModuleManager      *module.Manager
 	BasicModuleManager module.BasicManager
@@ -284,6 +292,8 @@ func NewEVMApp(
 		ibcexported.StoreKey, ibctransfertypes.StoreKey,
 		// Cosmos EVM store keys
 		evmtypes.StoreKey, feemarkettypes.StoreKey, erc20types.StoreKey, precisebanktypes.StoreKey,
+		// MailChat store keys
+		mailtypes.StoreKey,
 	)
 
 	tkeys := storetypes.NewTransientStoreKeys(paramstypes.TStoreKey, evmtypes.TransientKey, feemarkettypes.TransientKey)
@@ -567,6 +577,15 @@ func NewEVMApp(
 	tmLightClientModule := ibctm.NewLightClientModule(appCodec, storeProvider)
 	clientKeeper.AddRoute(ibctm.ModuleName, &tmLightClientModule)
 
+	// Set up the on-chain mail server registry
+	app.MailKeeper = mailkeeper.NewKeeper(
+		appCodec,
+		runtime.NewKVStoreService(keys[mailtypes.StoreKey]),
+		authtypes.NewModuleAddress(govtypes.ModuleName),
+		app.BankKeeper,
+		app.StakingKeeper,
+	)
+
 	// Override the ICS20 app module
 	transferModule := transfer.NewAppModule(app.TransferKeeper)
 
@@ -583,6 +602,7 @@ func NewEVMApp(
 			app.EVMKeeper,
 			app.GovKeeper,
 			app.SlashingKeeper,
+			app.MailKeeper,
 			app.AppCodec(),
 		),
 	)
@@ -618,6 +638,8 @@ func NewEVMApp(
 		feemarket.NewAppModule(app.FeeMarketKeeper),
 		erc20.NewAppModule(app.Erc20Keeper, app.AccountKeeper),
 		precisebank.NewAppModule(app.PreciseBankKeeper, app.BankKeeper, app.AccountKeeper),
+		// MailChat modules
+		mail.NewAppModule(app.MailKeeper),
 	)
 
 	// BasicModuleManager defines the module BasicManager which is in charge of setting up basic,
@@ -670,6 +692,7 @@ func NewEVMApp(
 		paramstypes.ModuleName, consensusparamtypes.ModuleName,
 		precisebanktypes.ModuleName,
 		vestingtypes.ModuleName,
+		mailtypes.ModuleName,
 	)
 
 	// NOTE: the feemarket module should go last in order of end blockers that are actually doing something,
@@ -689,6 +712,7 @@ func NewEVMApp(
 		feegrant.ModuleName, paramstypes.ModuleName, upgradetypes.ModuleName, consensusparamtypes.ModuleName,
 		precisebanktypes.ModuleName,
 		vestingtypes.ModuleName,
+		mailtypes.ModuleName,
 	)
 
 	// NOTE: The genutils module must occur after staking so that pools are
@@ -708,6 +732,7 @@ func NewEVMApp(
 		feemarkettypes.ModuleName,
 		erc20types.ModuleName,
 		precisebanktypes.ModuleName,
+		mailtypes.ModuleName,
 
 		ibctransfertypes.ModuleName,
 		genutiltypes.ModuleName, evidencetypes.ModuleName, authz.ModuleName,
@@ -829,6 +854,11 @@ func NewEVMApp(
 		}
 	}
 
+	// expose the mail server registry and the EVM state to mail components
+	// running in the same process
+	chainreg.SetLocal(mailRegistry{app: app})
+	blockchain.SetLocal(evmBackend{app: app})
+
 	return app
 }
 
@@ -962,6 +992,9 @@ func (app *EVMD) DefaultGenesis() map[string]json.RawMessage {
 	erc20GenState := NewErc20GenesisState()
 	genesis[erc20types.ModuleName] = app.appCodec.MustMarshalJSON(erc20GenState)
 
+	mailGenState := NewMailGenesisState()
+	genesis[mailtypes.ModuleName] = app.appCodec.MustMarshalJSON(mailGenState)
+
 	return genesis
 }
 
@@ -0,0 +1,80 @@
+package app
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+
+	evmserverconfig "github.com/cosmos/evm/server/config"
+	evmtypes "github.com/cosmos/evm/x/vm/types"
+
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/blockchain"
+)
+
+// evmBackend implements blockchain.Backend on top of the latest committed
+// EVM state. Transactions and receipts need the mempool and the indexer of
+// the JSON-RPC server, so these calls are left to other endpoints, as is the
+// pending nonce that depends on the mempool.
+type evmBackend struct {
+	app *EVMD
+}
+
+func (b evmBackend) ChainID(_ context.Context) (*big.Int, error) {
+	return evmtypes.GetEthChainConfig().ChainID, nil
+}
+
+func (b evmBackend) SendRawTx(_ context.Context, _ []byte) error {
+	return blockchain.ErrUnsupported
+}
+
+func (b evmBackend) CallContract(_ context.Context, to common.Address, data []byte) ([]byte, error) {
+	ctx, err := b.app.CreateQueryContext(0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	input := hexutil.Bytes(data)
+	args, err := json.Marshal(evmtypes.TransactionArgs{To: &to, Input: &input})
+	if err != nil {
+		return nil, err
+	}
+	res, err := b.app.EVMKeeper.EthCall(ctx, &evmtypes.EthCallRequest{
+		Args:    args,
+		GasCap:  evmserverconfig.DefaultGasCap,
+		ChainId: evmtypes.GetEthChainConfig().ChainID.Int64(),
+	})
+	if err != nil {
+		return nil, err
+	}
+	if res.Failed() {
+		return nil, fmt.Errorf("%w: %s", blockchain.ErrReverted, res.VmError)
+	}
+	return res.Ret, nil
+}
+
+func (b evmBackend) PendingNonce(_ context.Context, _ common.Address) (uint64, error) {
+	return 0, blockchain.ErrUnsupported
+}
+
+func (b evmBackend) SuggestGasPrice(_ context.Context) (*big.Int, error) {
+	ctx, err := b.app.CreateQueryContext(0, false)
+	if err != nil {
+		return nil, err
+	}
+	baseFee := b.app.EVMKeeper.GetBaseFee(ctx)
+	if baseFee == nil || baseFee.Sign() == 0 {
+		// Without the fee market the gas price depends on the node
+		// configuration.
+		return nil, blockchain.ErrUnsupported
+	}
+	return baseFee, nil
+}
+
+func (b evmBackend) TransactionReceipt(_ context.Context, _ common.Hash) (*module.TxReceipt, error) {
+	return nil, blockchain.ErrUnsupported
+}
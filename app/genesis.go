@@ -2,12 +2,15 @@ package app
 
 import (
 	"encoding/json"
+	"slices"
 
 	testconstants "github.com/cosmos/evm/testutil/constants"
 	erc20types "github.com/cosmos/evm/x/erc20/types"
 	feemarkettypes "github.com/cosmos/evm/x/feemarket/types"
 	evmtypes "github.com/cosmos/evm/x/vm/types"
 	"github.com/mail-chat-chain/mailchatd/config"
+	mailprecompile "github.com/mail-chat-chain/mailchatd/precompiles/mail"
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
 
 	minttypes "github.com/cosmos/cosmos-sdk/x/mint/types"
 )
@@ -24,10 +27,12 @@ type GenesisState map[string]json.RawMessage
 // NewEVMGenesisState returns the default genesis state for the EVM module.
 //
 // NOTE: for the example chain implementation we need to set the default EVM denomination,
-// enable ALL precompiles, and include default preinstalls.
+// enable ALL precompiles (including the mail registry precompile), and include default preinstalls.
 func NewEVMGenesisState() *evmtypes.GenesisState {
 	evmGenState := evmtypes.DefaultGenesisState()
-	evmGenState.Params.ActiveStaticPrecompiles = evmtypes.AvailableStaticPrecompiles
+	activePrecompiles := append(slices.Clone(evmtypes.AvailableStaticPrecompiles), mailprecompile.PrecompileAddress)
+	slices.Sort(activePrecompiles)
+	evmGenState.Params.ActiveStaticPrecompiles = activePrecompiles
 	evmGenState.Preinstalls = evmtypes.DefaultPreinstalls
 
 	return evmGenState
@@ -64,3 +69,15 @@ func NewFeeMarketGenesisState() *feemarkettypes.GenesisState {
 
 	return feeMarketGenState
 }
+
+// NewMailGenesisState returns the default genesis state for the mail module.
+//
+// NOTE: domain bonds are denominated in the chain's base denomination. The
+// minimum amount stays zero, which leaves bond verification disabled until
+// governance raises it.
+func NewMailGenesisState() *mailtypes.GenesisState {
+	mailGenState := mailtypes.DefaultGenesisState()
+	mailGenState.Params.MinBond.Denom = config.BaseDenom
+
+	return mailGenState
+}
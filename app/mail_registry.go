@@ -0,0 +1,29 @@
+package app
+
+import (
+	"context"
+
+	"github.com/mail-chat-chain/mailchatd/internal/chainreg"
+)
+
+// mailRegistry implements chainreg.Registry on top of the latest committed
+// state of the x/mail module.
+type mailRegistry struct {
+	app *EVMD
+}
+
+func (r mailRegistry) Domain(_ context.Context, name string) (*chainreg.Domain, error) {
+	ctx, err := r.app.CreateQueryContext(0, false)
+	if err != nil {
+		return nil, err
+	}
+
+	d, found, err := r.app.MailKeeper.GetDomain(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	if !found {
+		return nil, chainreg.ErrNotRegistered
+	}
+	return chainreg.FromModule(d)
+}
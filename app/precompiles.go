@@ -32,6 +32,8 @@ import (
 
 	// Account Abstraction precompile
 	aaprecompile "github.com/mail-chat-chain/mailchatd/precompiles/account_abstraction"
+	mailprecompile "github.com/mail-chat-chain/mailchatd/precompiles/mail"
+	mailkeeper "github.com/mail-chat-chain/mailchatd/x/mail/keeper"
 )
 
 // Optionals define some optional params that can be applied to _some_ precompiles.
@@ -86,6 +88,7 @@ func NewAvailableStaticPrecompiles(
 	evmKeeper *evmkeeper.Keeper,
 	govKeeper govkeeper.Keeper,
 	slashingKeeper slashingkeeper.Keeper,
+	mailKeeper mailkeeper.Keeper,
 	codec codec.Codec,
 	opts ...Option,
 ) map[common.Address]vm.PrecompiledContract {
@@ -145,6 +148,11 @@ func NewAvailableStaticPrecompiles(
 		panic(fmt.Errorf("failed to instantiate slashing precompile: %w", err))
 	}
 
+	mailPrecompile, err := mailprecompile.NewPrecompile(mailKeeper)
+	if err != nil {
+		panic(fmt.Errorf("failed to instantiate mail precompile: %w", err))
+	}
+
 	// Account Abstraction precompile
 	accountAbstractionPrecompile := aaprecompile.NewPrecompile()
 
@@ -160,6 +168,7 @@ func NewAvailableStaticPrecompiles(
 	precompiles[bankPrecompile.Address()] = bankPrecompile
 	precompiles[govPrecompile.Address()] = govPrecompile
 	precompiles[slashingPrecompile.Address()] = slashingPrecompile
+	precompiles[mailPrecompile.Address()] = mailPrecompile
 
 	return precompiles
 }
@@ -1,5 +1,142 @@
 package app
 
-func (app EVMD) RegisterUpgradeHandlers() {
-	// No upgrades registered yet
+import (
+	"context"
+	"fmt"
+
+	evmtypes "github.com/cosmos/evm/x/vm/types"
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
+
+	storetypes "cosmossdk.io/store/types"
+	upgradetypes "cosmossdk.io/x/upgrade/types"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/types/module"
+)
+
+// Upgrade defines an on-chain upgrade handled by this binary.
+//
+// The upgrade is applied at the height of the software upgrade plan with the
+// same name. Module migrations run first, then the EVM params are updated and
+// finally the custom handler is called.
+type Upgrade struct {
+	// Name is the name of the upgrade plan.
+	Name string
+
+	// StoreUpgrades lists the stores added, renamed or deleted by the
+	// upgrade. They are applied when the new binary loads the state at the
+	// upgrade height.
+	StoreUpgrades storetypes.StoreUpgrades
+
+	// EVMParams, if set, modifies the EVM module params.
+	EVMParams func(params *evmtypes.Params)
+
+	// Handler, if set, runs custom state migrations.
+	Handler func(ctx sdk.Context, app *EVMD) error
+}
+
+// UpgradeNameMailRegistry is the upgrade that adds the x/mail module to
+// chains started before it existed.
+const UpgradeNameMailRegistry = "mail-registry"
+
+// Upgrades contains all upgrades handled by this binary. New upgrades should
+// be appended to the end, the entries for applied upgrades are kept so that
+// nodes syncing from an older height can replay them.
+var Upgrades = []Upgrade{
+	{
+		Name: UpgradeNameMailRegistry,
+		StoreUpgrades: storetypes.StoreUpgrades{
+			Added: []string{mailtypes.StoreKey},
+		},
+		// The module genesis is initialized by the module migrations with
+		// the default params, bonds use the chain denomination like in the
+		// genesis of new chains.
+		Handler: func(ctx sdk.Context, app *EVMD) error {
+			return app.MailKeeper.SetParams(ctx, NewMailGenesisState().Params)
+		},
+	},
+}
+
+// RegisterUpgradeHandlers registers the handlers and the store loader for
+// Upgrades.
+func (app *EVMD) RegisterUpgradeHandlers() {
+	for _, u := range Upgrades {
+		app.UpgradeKeeper.SetUpgradeHandler(u.Name, app.upgradeHandler(u))
+	}
+
+	app.setUpgradeStoreLoader()
+}
+
+func (app *EVMD) upgradeHandler(u Upgrade) upgradetypes.UpgradeHandler {
+	return func(ctx context.Context, plan upgradetypes.Plan, fromVM module.VersionMap) (module.VersionMap, error) {
+		sdkCtx := sdk.UnwrapSDKContext(ctx)
+		logger := sdkCtx.Logger().With("upgrade", plan.Name)
+
+		logger.Info("running module migrations")
+		vm, err := app.ModuleManager.RunMigrations(ctx, app.configurator, fromVM)
+		if err != nil {
+			return vm, err
+		}
+
+		if u.EVMParams != nil {
+			logger.Info("migrating EVM params")
+			if err := app.migrateEVMParams(sdkCtx, u.EVMParams); err != nil {
+				return vm, fmt.Errorf("failed to migrate EVM params: %w", err)
+			}
+		}
+
+		if u.Handler != nil {
+			if err := u.Handler(sdkCtx, app); err != nil {
+				return vm, err
+			}
+		}
+
+		logger.Info("upgrade complete")
+		return vm, nil
+	}
+}
+
+func (app *EVMD) migrateEVMParams(ctx sdk.Context, update func(params *evmtypes.Params)) error {
+	params := app.EVMKeeper.GetParams(ctx)
+	update(&params)
+	return app.EVMKeeper.SetParams(ctx, params)
+}
+
+// setUpgradeStoreLoader configures the store loader to apply StoreUpgrades
+// of the upgrade that stopped the previous binary. It should be called
+// before the state is loaded.
+func (app *EVMD) setUpgradeStoreLoader() {
+	hasStoreUpgrades := false
+	for _, u := range Upgrades {
+		if storeUpgradesSet(u.StoreUpgrades) {
+			hasStoreUpgrades = true
+			break
+		}
+	}
+	// Reading the upgrade info creates the data directory, skip it if
+	// there is nothing to apply.
+	if !hasStoreUpgrades {
+		return
+	}
+
+	upgradeInfo, err := app.UpgradeKeeper.ReadUpgradeInfoFromDisk()
+	if err != nil {
+		panic(fmt.Sprintf("failed to read upgrade info from disk: %s", err))
+	}
+	if upgradeInfo.Name == "" || app.UpgradeKeeper.IsSkipHeight(upgradeInfo.Height) {
+		return
+	}
+
+	for _, u := range Upgrades {
+		if u.Name != upgradeInfo.Name || !storeUpgradesSet(u.StoreUpgrades) {
+			continue
+		}
+		storeUpgrades := u.StoreUpgrades
+		app.SetStoreLoader(upgradetypes.UpgradeStoreLoader(upgradeInfo.Height, &storeUpgrades))
+		return
+	}
+}
+
+func storeUpgradesSet(u storetypes.StoreUpgrades) bool {
+	return len(u.Added) != 0 || len(u.Renamed) != 0 || len(u.Deleted) != 0
 }
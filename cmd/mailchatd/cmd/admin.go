@@ -0,0 +1,124 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/internal/endpoint/admin"
+	"github.com/spf13/cobra"
+)
+
+func NewAdminCmd() *cobra.Command {
+	adminCmd := &cobra.Command{
+		Use:   "admin",
+		Short: "Admin API helpers",
+		Long: `These subcommands help with the admin API endpoint of the running server.
+
+Management commands (creds, imap-acct, imap-mboxes, imap-msgs) use the admin
+API instead of opening storage directly if --admin-socket is set. The bearer
+token, if required, is read from the MAILCHAT_ADMIN_TOKEN environment variable.
+Unix socket clients are read-only unless unix_scopes is set in the endpoint
+configuration.`,
+	}
+
+	genTokenCmd := &cobra.Command{
+		Use:   "gen-token NAME [SCOPE|ROLE...]",
+		Short: "Generate an API token and the matching configuration directive",
+		Long: `Generates a random token and prints it along with the 'token' directive
+for the admin endpoint configuration. Only the hash of the token is stored in
+the configuration. If no scopes are specified, the admin role is used.`,
+		Args: cobra.MinimumNArgs(1),
+		RunE: adminGenToken,
+	}
+
+	queueCmd := &cobra.Command{
+		Use:   "queue [remove ID...]",
+		Short: "List or remove messages in the delivery queue",
+		RunE:  adminQueue,
+	}
+
+	adminCmd.AddCommand(genTokenCmd, queueCmd)
+	return adminCmd
+}
+
+func adminGenToken(cmd *cobra.Command, args []string) error {
+	raw := make([]byte, 32)
+	if _, err := rand.Read(raw); err != nil {
+		return err
+	}
+	secret := base64.RawURLEncoding.EncodeToString(raw)
+
+	scopes := args[1:]
+	if len(scopes) == 0 {
+		scopes = []string{"admin"}
+	}
+
+	fmt.Println("Token:", secret)
+	fmt.Println()
+	fmt.Println("Configuration directive:")
+	fmt.Printf("    token %s %s %s\n", args[0], admin.HashToken(secret), strings.Join(scopes, " "))
+	return nil
+}
+
+func adminQueue(cmd *cobra.Command, args []string) error {
+	if adminSocket == "" {
+		return errors.New("Error: --admin-socket is required")
+	}
+	c := admin.NewClient(adminSocket, adminToken)
+
+	if len(args) != 0 {
+		if args[0] != "remove" || len(args) < 2 {
+			return errors.New("Error: usage: admin queue [remove ID...]")
+		}
+		for _, id := range args[1:] {
+			if err := c.RemoveQueued(id); err != nil {
+				return fmt.Errorf("%s: %w", id, err)
+			}
+		}
+		return nil
+	}
+
+	msgs, err := c.Queue()
+	if err != nil {
+		return err
+	}
+	if len(msgs) == 0 {
+		fmt.Fprintln(os.Stderr, "Queue is empty.")
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tFROM\tRECIPIENTS\tTRIES\tFIRST ATTEMPT")
+	for _, msg := range msgs {
+		tries := 0
+		for _, n := range msg.TriesCount {
+			tries = max(tries, n)
+		}
+		fmt.Fprintf(w, "%s\t%s\t%d\t%d\t%s\n", msg.ID, msg.From, len(msg.To), tries, msg.FirstAttempt.Format(time.RFC3339))
+	}
+	return w.Flush()
+}
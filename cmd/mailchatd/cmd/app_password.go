@@ -0,0 +1,183 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/auth/app_passwords"
+	"github.com/spf13/cobra"
+)
+
+func NewAppPasswordCmd() *cobra.Command {
+	appPasswordCmd := &cobra.Command{
+		Use:   "app-password",
+		Short: "Per-device app passwords management",
+		Long: `These subcommands can be used to manage app passwords checked by the
+auth.app_passwords module.
+
+The corresponding module should be configured in mailchat.conf and be
+defined in a top-level configuration block. By default, the name of that
+block should be local_app_passwords but this can be changed using --cfg-block
+flag for subcommands.`,
+	}
+
+	// Add subcommand
+	addCmd := &cobra.Command{
+		Use:   "add USERNAME LABEL",
+		Short: "Generate an app password for the account",
+		Long: `The generated password is printed once and cannot be retrieved later.
+
+--protocol restricts the password to the protocol (imap or smtp) and can be
+specified multiple times. By default, the password can be used for any protocol.
+
+--expires accepts a duration (720h), an RFC 3339 timestamp
+(2006-01-02T15:04:05Z07:00) or a date (2006-01-02).`,
+		Args: cobra.ExactArgs(2),
+		RunE: appPasswordAdd,
+	}
+	addCmd.Flags().String("cfg-block", "local_app_passwords", "Module configuration block to use")
+	addCmd.Flags().StringSlice("protocol", nil, "Restrict the password to the protocol")
+	addCmd.Flags().String("expires", "", "Expiry time of the password")
+
+	// List subcommand
+	listCmd := &cobra.Command{
+		Use:   "list USERNAME",
+		Short: "List app passwords of the account",
+		Args:  cobra.ExactArgs(1),
+		RunE:  appPasswordList,
+	}
+	listCmd.Flags().String("cfg-block", "local_app_passwords", "Module configuration block to use")
+	listCmd.Flags().Bool("quiet", false, "Do not print 'No app passwords.' message")
+
+	// Revoke subcommand
+	revokeCmd := &cobra.Command{
+		Use:   "revoke USERNAME ID|LABEL",
+		Short: "Revoke the app password",
+		Args:  cobra.ExactArgs(2),
+		RunE:  appPasswordRevoke,
+	}
+	revokeCmd.Flags().String("cfg-block", "local_app_passwords", "Module configuration block to use")
+
+	appPasswordCmd.AddCommand(addCmd, listCmd, revokeCmd)
+	return appPasswordCmd
+}
+
+func openAppPasswords(cmd *cobra.Command) (*app_passwords.Auth, error) {
+	globals, mod, err := getCfgBlockModule(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	a, ok := mod.Instance.(*app_passwords.Auth)
+	if !ok {
+		cfgBlock, _ := cmd.Flags().GetString("cfg-block")
+		return nil, fmt.Errorf("configuration block %s is not auth.app_passwords", cfgBlock)
+	}
+
+	if err := mod.Instance.Init(config.NewMap(globals, mod.Cfg)); err != nil {
+		return nil, fmt.Errorf("Error: module initialization failed: %w", err)
+	}
+
+	return a, nil
+}
+
+func appPasswordAdd(cmd *cobra.Command, args []string) error {
+	a, err := openAppPasswords(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(a)
+
+	protocols, _ := cmd.Flags().GetStringSlice("protocol")
+	expiresFlag, _ := cmd.Flags().GetString("expires")
+	var expires time.Time
+	if d, err := time.ParseDuration(expiresFlag); err == nil {
+		expires = time.Now().Add(d)
+	} else if expires, err = parseVacationTime(expiresFlag); err != nil {
+		return fmt.Errorf("Error: invalid --expires value: %w", err)
+	}
+
+	password, _, err := a.Add(args[0], args[1], protocols, expires)
+	if err != nil {
+		return err
+	}
+	fmt.Println(password)
+	return nil
+}
+
+func appPasswordList(cmd *cobra.Command, args []string) error {
+	a, err := openAppPasswords(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(a)
+
+	pwds, err := a.List(args[0])
+	if err != nil {
+		return err
+	}
+
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	if len(pwds) == 0 {
+		if !quiet {
+			fmt.Fprintln(os.Stderr, "No app passwords.")
+		}
+		return nil
+	}
+
+	formatTime := func(t time.Time) string {
+		if t.IsZero() {
+			return "-"
+		}
+		return t.Local().Format(time.DateTime)
+	}
+
+	now := time.Now()
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tLABEL\tPROTOCOLS\tCREATED\tLAST USED\tEXPIRES")
+	for _, p := range pwds {
+		protocols := "any"
+		if len(p.Protocols) != 0 {
+			protocols = strings.Join(p.Protocols, ",")
+		}
+		expires := formatTime(p.Expires)
+		if p.Expired(now) {
+			expires += " (expired)"
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\t%s\n", p.ID, p.Label, protocols,
+			formatTime(p.Created), formatTime(p.LastUsed), expires)
+	}
+	return w.Flush()
+}
+
+func appPasswordRevoke(cmd *cobra.Command, args []string) error {
+	a, err := openAppPasswords(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(a)
+
+	return a.Revoke(args[0], args[1])
+}
@@ -0,0 +1,165 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/authguard"
+	"github.com/spf13/cobra"
+)
+
+func NewAuthGuardCmd() *cobra.Command {
+	authGuardCmd := &cobra.Command{
+		Use:   "auth-guard",
+		Short: "Authentication brute-force protection bans management",
+		Long: `These subcommands can be used to manage sources banned by the auth_guard
+module due to authentication failures.
+
+The corresponding module should be configured in mailchat.conf and be
+defined in a top-level configuration block with the bans table set. By default,
+the name of that block should be auth_guard but this can be changed using
+--cfg-block flag for subcommands.`,
+	}
+
+	// List subcommand
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List banned subnets",
+		RunE:  authGuardList,
+	}
+	listCmd.Flags().String("cfg-block", "auth_guard", "Module configuration block to use")
+	listCmd.Flags().Bool("quiet", false, "Do not print 'No bans.' message")
+
+	// Ban subcommand
+	banCmd := &cobra.Command{
+		Use:   "ban IP",
+		Short: "Ban the subnet of the IP address",
+		Long: `The subnet size is determined by ipv4_prefix and ipv6_prefix
+directives of the module. If --duration is not set, ban_time is used and
+doubled for repeated offenses.`,
+		Args: cobra.ExactArgs(1),
+		RunE: authGuardBan,
+	}
+	banCmd.Flags().String("cfg-block", "auth_guard", "Module configuration block to use")
+	banCmd.Flags().Duration("duration", 0, "Ban duration")
+	banCmd.Flags().String("reason", "banned manually", "Ban reason")
+
+	// Unban subcommand
+	unbanCmd := &cobra.Command{
+		Use:   "unban IP",
+		Short: "Lift the ban of the subnet of the IP address",
+		Args:  cobra.ExactArgs(1),
+		RunE:  authGuardUnban,
+	}
+	unbanCmd.Flags().String("cfg-block", "auth_guard", "Module configuration block to use")
+
+	authGuardCmd.AddCommand(listCmd, banCmd, unbanCmd)
+	return authGuardCmd
+}
+
+func openAuthGuard(cmd *cobra.Command) (*authguard.Guard, error) {
+	globals, mod, err := getCfgBlockModule(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	g, ok := mod.Instance.(*authguard.Guard)
+	if !ok {
+		cfgBlock, _ := cmd.Flags().GetString("cfg-block")
+		return nil, fmt.Errorf("configuration block %s is not auth_guard", cfgBlock)
+	}
+
+	if err := mod.Instance.Init(config.NewMap(globals, mod.Cfg)); err != nil {
+		return nil, fmt.Errorf("Error: module initialization failed: %w", err)
+	}
+	if !g.Persistent() {
+		return nil, fmt.Errorf("Error: bans table is not configured for auth_guard")
+	}
+
+	return g, nil
+}
+
+func authGuardList(cmd *cobra.Command, args []string) error {
+	g, err := openAuthGuard(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(g)
+
+	bans, err := g.Bans()
+	if err != nil {
+		return err
+	}
+
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	if len(bans) == 0 {
+		if !quiet {
+			fmt.Fprintln(os.Stderr, "No bans.")
+		}
+		return nil
+	}
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "SUBNET\tUNTIL\tOFFENSES\tREASON")
+	for _, b := range bans {
+		fmt.Fprintf(w, "%s\t%s\t%d\t%s\n", b.Prefix, b.Until.Local().Format(time.DateTime), b.Count, b.Reason)
+	}
+	return w.Flush()
+}
+
+func authGuardBan(cmd *cobra.Command, args []string) error {
+	g, err := openAuthGuard(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(g)
+
+	prefix, err := g.ParsePrefix(args[0])
+	if err != nil {
+		return err
+	}
+	duration, _ := cmd.Flags().GetDuration("duration")
+	reason, _ := cmd.Flags().GetString("reason")
+
+	b, err := g.Ban(prefix, duration, reason)
+	if err != nil {
+		return err
+	}
+	fmt.Printf("Banned %s until %s\n", b.Prefix, b.Until.Local().Format(time.DateTime))
+	return nil
+}
+
+func authGuardUnban(cmd *cobra.Command, args []string) error {
+	g, err := openAuthGuard(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(g)
+
+	prefix, err := g.ParsePrefix(args[0])
+	if err != nil {
+		return err
+	}
+	return g.Unban(prefix)
+}
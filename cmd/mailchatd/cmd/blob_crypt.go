@@ -0,0 +1,260 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/ecdh"
+	"fmt"
+	"os"
+
+	"github.com/mail-chat-chain/mailchatd/internal/storage/blob/encrypted"
+	"github.com/mail-chat-chain/mailchatd/internal/storage/imapsql"
+	"github.com/spf13/cobra"
+)
+
+func NewBlobCryptCmd() *cobra.Command {
+	blobCryptCmd := &cobra.Command{
+		Use:   "blob-crypt",
+		Short: "Message encryption keys management",
+		Long: `These subcommands can be used to manage keys of messages encrypted by
+the storage.blob.encrypted module used as msg_store of the IMAP storage.
+
+The account key is the X25519 key derived from the wallet signature used to
+log in. Messages of accounts without the key are encrypted using the server
+key-encryption key (KEK).
+
+The storage should be defined in a top-level configuration block. By default,
+the name of that block should be local_mailboxes but this can be changed
+using --cfg-block flag for subcommands.`,
+	}
+
+	// Derive-key subcommand
+	deriveKeyCmd := &cobra.Command{
+		Use:   "derive-key SIGNATURE",
+		Short: "Print the account key derived from the login signature",
+		Args:  cobra.ExactArgs(1),
+		RunE:  blobCryptDeriveKey,
+	}
+
+	// Enroll subcommand
+	enrollCmd := &cobra.Command{
+		Use:   "enroll USERNAME PUBKEY SIGNATURE",
+		Short: "Publish the account key and re-wrap messages of the account",
+		Long: `Publish the account key and re-wrap messages of the account.
+
+SIGNATURE is the signature of the "MailChat encryption key: PUBKEY" message
+made by the wallet of the account address.`,
+		Args: cobra.ExactArgs(3),
+		RunE: blobCryptEnroll,
+	}
+	enrollCmd.Flags().String("cfg-block", "local_mailboxes", "Module configuration block to use")
+	enrollCmd.Flags().String("password", "", "Login signature used to decrypt messages encrypted to the previous account key")
+
+	// Unenroll subcommand
+	unenrollCmd := &cobra.Command{
+		Use:   "unenroll USERNAME",
+		Short: "Remove the account key and re-wrap messages of the account to the KEK",
+		Args:  cobra.ExactArgs(1),
+		RunE:  blobCryptUnenroll,
+	}
+	unenrollCmd.Flags().String("cfg-block", "local_mailboxes", "Module configuration block to use")
+	unenrollCmd.Flags().String("password", "", "Login signature used to decrypt messages encrypted to the account key")
+	unenrollCmd.MarkFlagRequired("password")
+
+	// Rewrap subcommand
+	rewrapCmd := &cobra.Command{
+		Use:   "rewrap [USERNAME...]",
+		Short: "Re-wrap message keys to the current keys of their owners",
+		Long: `Re-wrap message keys to the current keys of their owners.
+
+Messages of the specified accounts or all messages are processed. Messages
+stored before the encryption was enabled are encrypted.`,
+		RunE: blobCryptRewrap,
+	}
+	rewrapCmd.Flags().String("cfg-block", "local_mailboxes", "Module configuration block to use")
+
+	// Rotate-kek subcommand
+	rotateKEKCmd := &cobra.Command{
+		Use:   "rotate-kek",
+		Short: "Generate the new KEK and re-wrap all messages",
+		Long: `Generate the new KEK and re-wrap all messages.
+
+Previous keys are kept in the key file to read messages written by the
+running server before it picked up the new key. They can be removed from
+the file after running rewrap again.`,
+		Args: cobra.NoArgs,
+		RunE: blobCryptRotateKEK,
+	}
+	rotateKEKCmd.Flags().String("cfg-block", "local_mailboxes", "Module configuration block to use")
+
+	blobCryptCmd.AddCommand(deriveKeyCmd, enrollCmd, unenrollCmd, rewrapCmd, rotateKEKCmd)
+	return blobCryptCmd
+}
+
+func openBlobCrypt(cmd *cobra.Command) (*imapsql.Storage, *encrypted.Store, error) {
+	be, err := openStorage(cmd)
+	if err != nil {
+		return nil, nil, err
+	}
+	st, ok := be.(*imapsql.Storage)
+	if !ok {
+		closeIfNeeded(be)
+		return nil, nil, fmt.Errorf("Error: blob-crypt requires direct access to the imapsql storage")
+	}
+	enc, ok := st.BlobStore().(*encrypted.Store)
+	if !ok {
+		closeIfNeeded(be)
+		return nil, nil, fmt.Errorf("Error: msg_store of the storage is not storage.blob.encrypted")
+	}
+	return st, enc, nil
+}
+
+func passwordKey(cmd *cobra.Command) ([]*ecdh.PrivateKey, error) {
+	password, _ := cmd.Flags().GetString("password")
+	if password == "" {
+		return nil, nil
+	}
+	key, err := encrypted.DeriveKey(password)
+	if err != nil {
+		return nil, err
+	}
+	return []*ecdh.PrivateKey{key}, nil
+}
+
+// rewrapBlobs re-wraps all specified blobs reporting failures, the error is
+// returned if any blob failed.
+func rewrapBlobs(ctx context.Context, st *imapsql.Storage, enc *encrypted.Store, keys []string, extra []*ecdh.PrivateKey) error {
+	failed := 0
+	for _, key := range keys {
+		owners, err := st.BlobOwners(ctx, key)
+		if err == nil {
+			err = enc.Rewrap(ctx, key, owners, extra...)
+		}
+		if err != nil {
+			fmt.Fprintf(os.Stderr, "%s: %v\n", key, err)
+			failed++
+		}
+	}
+	fmt.Printf("Re-wrapped %d messages, %d failed\n", len(keys)-failed, failed)
+	if failed != 0 {
+		return fmt.Errorf("Error: failed to re-wrap %d messages", failed)
+	}
+	return nil
+}
+
+func blobCryptDeriveKey(cmd *cobra.Command, args []string) error {
+	key, err := encrypted.DeriveKey(args[0])
+	if err != nil {
+		return err
+	}
+	pub := encrypted.EncodePublicKey(key.PublicKey())
+	fmt.Println(pub)
+	fmt.Printf("Sign %q with the wallet to enroll the key\n", encrypted.EnrollmentMessage(pub))
+	return nil
+}
+
+func blobCryptEnroll(cmd *cobra.Command, args []string) error {
+	extra, err := passwordKey(cmd)
+	if err != nil {
+		return err
+	}
+	st, enc, err := openBlobCrypt(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(st)
+
+	ctx := context.Background()
+	if err := enc.Enroll(ctx, args[0], args[1], args[2]); err != nil {
+		return err
+	}
+	keys, err := st.AccountBlobs(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return rewrapBlobs(ctx, st, enc, keys, extra)
+}
+
+func blobCryptUnenroll(cmd *cobra.Command, args []string) error {
+	extra, err := passwordKey(cmd)
+	if err != nil {
+		return err
+	}
+	st, enc, err := openBlobCrypt(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(st)
+
+	ctx := context.Background()
+	if err := enc.Unenroll(args[0]); err != nil {
+		return err
+	}
+	keys, err := st.AccountBlobs(ctx, args[0])
+	if err != nil {
+		return err
+	}
+	return rewrapBlobs(ctx, st, enc, keys, extra)
+}
+
+func blobCryptRewrap(cmd *cobra.Command, args []string) error {
+	st, enc, err := openBlobCrypt(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(st)
+
+	ctx := context.Background()
+	var keys []string
+	if len(args) == 0 {
+		keys, err = st.Blobs(ctx)
+		if err != nil {
+			return err
+		}
+	}
+	for _, username := range args {
+		accKeys, err := st.AccountBlobs(ctx, username)
+		if err != nil {
+			return err
+		}
+		keys = append(keys, accKeys...)
+	}
+	return rewrapBlobs(ctx, st, enc, keys, nil)
+}
+
+func blobCryptRotateKEK(cmd *cobra.Command, args []string) error {
+	st, enc, err := openBlobCrypt(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(st)
+
+	if err := enc.RotateKEK(); err != nil {
+		return err
+	}
+	fmt.Println("New KEK is written to", enc.KEKPath())
+
+	ctx := context.Background()
+	keys, err := st.Blobs(ctx)
+	if err != nil {
+		return err
+	}
+	return rewrapBlobs(ctx, st, enc, keys, nil)
+}
@@ -82,7 +82,7 @@ flag for subcommands.`,
 	passwordCmd.Flags().String("cfg-block", "local_authdb", "Module configuration block to use")
 	passwordCmd.Flags().StringP("password", "p", "", "Use PASSWORD instead of reading password from stdin")
 
-	credsCmd.AddCommand(listCmd, createCmd, removeCmd, passwordCmd)
+	credsCmd.AddCommand(listCmd, createCmd, removeCmd, passwordCmd, NewAppPasswordCmd())
 	return credsCmd
 }
 
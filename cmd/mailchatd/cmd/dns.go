@@ -2,10 +2,12 @@ package cmd
 
 import (
 	"bufio"
+	"context"
 	"crypto/rand"
 	"crypto/rsa"
 	"crypto/x509"
 	"encoding/base64"
+	"encoding/json"
 	"encoding/pem"
 	"fmt"
 	"io"
@@ -14,10 +16,12 @@ import (
 	"os"
 	"path/filepath"
 	"strings"
+	"text/tabwriter"
 	"time"
 
 	parser "github.com/mail-chat-chain/mailchatd/framework/cfgparser"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/internal/dnscheck"
 	"github.com/spf13/cobra"
 )
 
@@ -27,6 +31,10 @@ type DNSConfig struct {
 	ServerIP        string
 	ServerIPv6      string
 	DKIMPublicKey   string
+	// DKIMRecords are the DKIM records keyed by selector, as published by
+	// the configured modify.dkim. They are used by 'dns apply' instead of
+	// DKIMPublicKey.
+	DKIMRecords     map[string]string
 	PostmasterEmail string
 	DMARCPolicy     string // none, quarantine, reject
 }
@@ -42,6 +50,7 @@ func NewDNSCmd() *cobra.Command {
 		NewDNSGuideCmd(),
 		NewDNSCheckCmd(),
 		NewDNSExportCmd(),
+		NewDNSApplyCmd(),
 	)
 
 	return cmd
@@ -57,13 +66,21 @@ func NewDNSGuideCmd() *cobra.Command {
 }
 
 func NewDNSCheckCmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "check [domain]",
 		Short: "Check DNS configuration",
-		Long:  `Verify that DNS records are correctly configured for the mail server`,
-		Args:  cobra.MaximumNArgs(1),
-		RunE:  runDNSCheck,
+		Long: `Verify that DNS records are correctly configured for the mail server
+
+Checks MX, SPF, DKIM, DMARC, MTA-STS, TLSRPT, DANE (TLSA) and reverse DNS
+records and computes a deliverability score. Records are validated with
+DNSSEC if the system resolver supports it.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: runDNSCheck,
 	}
+	cmd.Flags().String("format", "table", "Output format (table or json)")
+	cmd.Flags().String("lang", "", "Output language (e.g. en, zh), defaults to $LANG")
+	cmd.Flags().StringArray("selector", nil, "DKIM selector to check (can be repeated, default: default)")
+	return cmd
 }
 
 func NewDNSExportCmd() *cobra.Command {
@@ -139,6 +156,23 @@ func ensureDKIMKeys(domain, selector string) (string, error) {
 }
 
 func loadDNSConfig() (*DNSConfig, error) {
+	cfg, err := readDNSConfig()
+	if err != nil {
+		return nil, err
+	}
+
+	// 确保DKIM密钥存在，使用默认选择器"default"
+	cfg.DKIMPublicKey, err = ensureDKIMKeys(cfg.PrimaryDomain, "default")
+	if err != nil {
+		log.Printf("Warning: Failed to ensure DKIM keys: %v", err)
+		cfg.DKIMPublicKey = "YOUR_DKIM_PUBLIC_KEY"
+	}
+	return cfg, nil
+}
+
+// readDNSConfig is the same as loadDNSConfig but does not generate DKIM keys,
+// DKIMPublicKey is left empty.
+func readDNSConfig() (*DNSConfig, error) {
 	
 	// 首先检查当前工作目录的配置文件
 	var configPath string
@@ -270,19 +304,11 @@ func loadDNSConfig() (*DNSConfig, error) {
 		postmasterEmail = fmt.Sprintf("postmaster@%s", primaryDomain)
 	}
 
-	// 确保DKIM密钥存在，使用默认选择器"default"
-	dkimPublicKey, err := ensureDKIMKeys(primaryDomain, "default")
-	if err != nil {
-		log.Printf("Warning: Failed to ensure DKIM keys: %v", err)
-		dkimPublicKey = "YOUR_DKIM_PUBLIC_KEY"
-	}
-
 	return &DNSConfig{
 		Hostname:        hostname,
 		PrimaryDomain:   primaryDomain,
 		ServerIP:        serverIP,
 		ServerIPv6:      serverIPv6,
-		DKIMPublicKey:   dkimPublicKey,
 		PostmasterEmail: postmasterEmail,
 	}, nil
 }
@@ -553,454 +579,65 @@ func runDNSGuide(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
-type DNSRecord struct {
-	RecordType string   // 记录类型: A, AAAA, MX, TXT等
-	Name       string   // 记录名称/主机名
-	Content    []string // 记录内容
-	Priority   uint16   // MX记录优先级
-	Status     string   // 查询状态: OK, NOT_FOUND, ERROR
-	Error      string   // 错误信息
-}
-
 func runDNSCheck(cmd *cobra.Command, args []string) error {
+	format, _ := cmd.Flags().GetString("format")
+	lang, _ := cmd.Flags().GetString("lang")
+	selectors, _ := cmd.Flags().GetStringArray("selector")
+	if format != "table" && format != "json" {
+		return fmt.Errorf("unknown output format: %s", format)
+	}
+
+	p := dnscheck.NewPrinter(lang)
+
 	var domain string
-	
+	var serverIPs []string
 	if len(args) > 0 {
 		domain = args[0]
 	} else {
 		cfg, err := loadDNSConfig()
 		if err != nil {
-			return fmt.Errorf("请指定域名或确保配置文件存在: %v", err)
+			return fmt.Errorf("%s: %w", p.Sprintf("Specify the domain or make sure the configuration file exists"), err)
 		}
 		domain = cfg.PrimaryDomain
-	}
-
-	fmt.Printf("\n正在检查域名 %s 的DNS配置...\n", domain)
-	fmt.Println("================================================================================")
-
-	var records []DNSRecord
-	
-	// 检查A记录
-	fmt.Printf("查询 A 记录...")
-	aIPs := []string{}
-	ips, err := net.LookupIP(domain)
-	if err != nil {
-		records = append(records, DNSRecord{
-			RecordType: "A",
-			Name:       domain,
-			Status:     "ERROR",
-			Error:      err.Error(),
-		})
-		fmt.Println(" ❌")
-	} else {
-		for _, ip := range ips {
-			if ip.To4() != nil {
-				aIPs = append(aIPs, ip.String())
+		for _, ip := range []string{cfg.ServerIP, cfg.ServerIPv6} {
+			if ip != "" {
+				serverIPs = append(serverIPs, ip)
 			}
 		}
-		if len(aIPs) > 0 {
-			records = append(records, DNSRecord{
-				RecordType: "A",
-				Name:       domain,
-				Content:    aIPs,
-				Status:     "OK",
-			})
-			fmt.Println(" ✅")
-		} else {
-			records = append(records, DNSRecord{
-				RecordType: "A",
-				Name:       domain,
-				Status:     "NOT_FOUND",
-				Error:      "未找到IPv4地址",
-			})
-			fmt.Println(" ❌")
-		}
-	}
-	
-	// 检查AAAA记录
-	fmt.Printf("查询 AAAA 记录...")
-	aaaaIPs := []string{}
-	for _, ip := range ips {
-		if ip.To4() == nil && ip.To16() != nil {
-			aaaaIPs = append(aaaaIPs, ip.String())
-		}
-	}
-	if len(aaaaIPs) > 0 {
-		records = append(records, DNSRecord{
-			RecordType: "AAAA",
-			Name:       domain,
-			Content:    aaaaIPs,
-			Status:     "OK",
-		})
-		fmt.Println(" ✅")
-	} else {
-		fmt.Println(" ⚪ (可选)")
 	}
 
-	// 检查MX记录
-	fmt.Printf("查询 MX 记录...")
-	mxHosts := []string{}
-	mxRecords, err := net.LookupMX(domain)
-	if err != nil {
-		records = append(records, DNSRecord{
-			RecordType: "MX",
-			Name:       domain,
-			Status:     "ERROR",
-			Error:      err.Error(),
-		})
-		fmt.Println(" ❌")
-	} else if len(mxRecords) == 0 {
-		records = append(records, DNSRecord{
-			RecordType: "MX",
-			Name:       domain,
-			Status:     "NOT_FOUND",
-			Error:      "未找到MX记录",
-		})
-		fmt.Println(" ❌")
-	} else {
-		for _, mx := range mxRecords {
-			mxHost := strings.TrimSuffix(mx.Host, ".")
-			mxHosts = append(mxHosts, mxHost)
-			records = append(records, DNSRecord{
-				RecordType: "MX",
-				Name:       domain,
-				Content:    []string{mxHost},
-				Priority:   mx.Pref,
-				Status:     "OK",
-			})
-		}
-		fmt.Println(" ✅")
-	}
-	
-	// 检查MX主机的A/AAAA记录
-	for _, mxHost := range mxHosts {
-		fmt.Printf("查询 %s 的 A/AAAA 记录...", mxHost)
-		mxIPs, err := net.LookupIP(mxHost)
-		if err != nil {
-			records = append(records, DNSRecord{
-				RecordType: "A (MX主机)",
-				Name:       mxHost,
-				Status:     "ERROR",
-				Error:      err.Error(),
-			})
-			fmt.Println(" ❌")
-		} else {
-			mxA := []string{}
-			mxAAAA := []string{}
-			for _, ip := range mxIPs {
-				if ip.To4() != nil {
-					mxA = append(mxA, ip.String())
-				} else if ip.To16() != nil {
-					mxAAAA = append(mxAAAA, ip.String())
-				}
-			}
-			if len(mxA) > 0 {
-				records = append(records, DNSRecord{
-					RecordType: "A (MX主机)",
-					Name:       mxHost,
-					Content:    mxA,
-					Status:     "OK",
-				})
-			}
-			if len(mxAAAA) > 0 {
-				records = append(records, DNSRecord{
-					RecordType: "AAAA (MX主机)",
-					Name:       mxHost,
-					Content:    mxAAAA,
-					Status:     "OK",
-				})
-			}
-			if len(mxA) > 0 || len(mxAAAA) > 0 {
-				fmt.Println(" ✅")
-			} else {
-				records = append(records, DNSRecord{
-					RecordType: "A/AAAA (MX主机)",
-					Name:       mxHost,
-					Status:     "NOT_FOUND",
-					Error:      "MX主机无IP地址",
-				})
-				fmt.Println(" ❌")
-			}
-		}
-		
-		// 检查MX主机的SPF记录
-		fmt.Printf("查询 %s 的 SPF 记录...", mxHost)
-		mxTxt, _ := net.LookupTXT(mxHost)
-		mxSpfFound := false
-		for _, txt := range mxTxt {
-			if strings.HasPrefix(txt, "v=spf1") {
-				records = append(records, DNSRecord{
-					RecordType: "TXT (MX SPF)",
-					Name:       mxHost,
-					Content:    []string{txt},
-					Status:     "OK",
-				})
-				mxSpfFound = true
-				break
-			}
-		}
-		if mxSpfFound {
-			fmt.Println(" ✅")
-		} else {
-			fmt.Println(" ⚪ (推荐)")
-		}
-	}
+	checker := dnscheck.NewChecker(p)
+	checker.DKIMSelectors = selectors
+	checker.ServerIPs = serverIPs
 
-	// 检查TXT记录（SPF）
-	fmt.Printf("查询 SPF 记录...")
-	txtRecords, _ := net.LookupTXT(domain)
-	spfFound := false
-	for _, txt := range txtRecords {
-		if strings.HasPrefix(txt, "v=spf1") {
-			records = append(records, DNSRecord{
-				RecordType: "TXT (SPF)",
-				Name:       domain,
-				Content:    []string{txt},
-				Status:     "OK",
-			})
-			spfFound = true
-		}
-	}
-	if spfFound {
-		fmt.Println(" ✅")
-	} else {
-		records = append(records, DNSRecord{
-			RecordType: "TXT (SPF)",
-			Name:       domain,
-			Status:     "NOT_FOUND",
-			Error:      "未找到SPF记录",
-		})
-		fmt.Println(" ❌")
-	}
-
-	// 检查DKIM记录
-	fmt.Printf("查询 DKIM 记录...")
-	dkimDomain := fmt.Sprintf("default._domainkey.%s", domain)
-	dkimTxt, err := net.LookupTXT(dkimDomain)
-	dkimFound := false
-	if err == nil && len(dkimTxt) > 0 {
-		for _, txt := range dkimTxt {
-			if strings.Contains(txt, "DKIM1") || strings.Contains(txt, "k=rsa") {
-				// 截断过长的DKIM公钥显示
-				displayTxt := txt
-				if len(txt) > 100 {
-					displayTxt = txt[:97] + "..."
-				}
-				records = append(records, DNSRecord{
-					RecordType: "TXT (DKIM)",
-					Name:       dkimDomain,
-					Content:    []string{displayTxt},
-					Status:     "OK",
-				})
-				dkimFound = true
-				break
-			}
-		}
-	}
-	if dkimFound {
-		fmt.Println(" ✅")
-	} else {
-		records = append(records, DNSRecord{
-			RecordType: "TXT (DKIM)",
-			Name:       dkimDomain,
-			Status:     "NOT_FOUND",
-			Error:      "未找到DKIM记录",
-		})
-		fmt.Println(" ❌")
-	}
-
-	// 检查DMARC记录
-	fmt.Printf("查询 DMARC 记录...")
-	dmarcDomain := fmt.Sprintf("_dmarc.%s", domain)
-	dmarcTxt, err := net.LookupTXT(dmarcDomain)
-	dmarcFound := false
-	if err == nil && len(dmarcTxt) > 0 {
-		for _, txt := range dmarcTxt {
-			if strings.Contains(txt, "DMARC1") {
-				records = append(records, DNSRecord{
-					RecordType: "TXT (DMARC)",
-					Name:       dmarcDomain,
-					Content:    []string{txt},
-					Status:     "OK",
-				})
-				dmarcFound = true
-				break
-			}
-		}
-	}
-	if dmarcFound {
-		fmt.Println(" ✅")
-	} else {
-		records = append(records, DNSRecord{
-			RecordType: "TXT (DMARC)",
-			Name:       dmarcDomain,
-			Status:     "NOT_FOUND",
-			Error:      "未找到DMARC记录",
-		})
-		fmt.Println(" ❌")
-	}
-
-	// 检查MTA-STS记录
-	fmt.Printf("查询 MTA-STS 记录...")
-	mtastsDomain := fmt.Sprintf("_mta-sts.%s", domain)
-	mtastsTxt, err := net.LookupTXT(mtastsDomain)
-	mtastsFound := false
-	if err == nil && len(mtastsTxt) > 0 {
-		for _, txt := range mtastsTxt {
-			if strings.Contains(txt, "STSv1") {
-				records = append(records, DNSRecord{
-					RecordType: "TXT (MTA-STS)",
-					Name:       mtastsDomain,
-					Content:    []string{txt},
-					Status:     "OK",
-				})
-				mtastsFound = true
-				break
-			}
-		}
-	}
-	if mtastsFound {
-		fmt.Println(" ✅")
-	} else {
-		fmt.Println(" ⚪ (推荐)")
-	}
-	
-	// 检查TLSRPT记录
-	fmt.Printf("查询 TLSRPT 记录...")
-	tlsrptDomain := fmt.Sprintf("_smtp._tls.%s", domain)
-	tlsrptTxt, err := net.LookupTXT(tlsrptDomain)
-	tlsrptFound := false
-	if err == nil && len(tlsrptTxt) > 0 {
-		for _, txt := range tlsrptTxt {
-			if strings.Contains(txt, "TLSRPTv1") {
-				records = append(records, DNSRecord{
-					RecordType: "TXT (TLSRPT)",
-					Name:       tlsrptDomain,
-					Content:    []string{txt},
-					Status:     "OK",
-				})
-				tlsrptFound = true
-				break
-			}
-		}
+	ctx, cancel := context.WithTimeout(cmd.Context(), 2*time.Minute)
+	defer cancel()
+
+	if format == "table" {
+		fmt.Println(p.Sprintf("Checking %s...", domain))
 	}
-	if tlsrptFound {
-		fmt.Println(" ✅")
-	} else {
-		fmt.Println(" ⚪ (推荐)")
-	}
-
-	// 检查PTR记录（反向DNS）
-	if len(aIPs) > 0 {
-		fmt.Printf("查询 PTR 记录...")
-		ptrFound := false
-		for _, ip := range aIPs {
-			names, err := net.LookupAddr(ip)
-			if err == nil && len(names) > 0 {
-				records = append(records, DNSRecord{
-					RecordType: "PTR",
-					Name:       ip,
-					Content:    names,
-					Status:     "OK",
-				})
-				ptrFound = true
-				break
-			}
-		}
-		if ptrFound {
-			fmt.Println(" ✅")
-		} else {
-			records = append(records, DNSRecord{
-				RecordType: "PTR",
-				Name:       aIPs[0],
-				Status:     "NOT_FOUND",
-				Error:      "未配置反向DNS",
-			})
-			fmt.Println(" ⚠️")
-		}
+	report := checker.Check(ctx, domain)
+
+	if format == "json" {
+		enc := json.NewEncoder(os.Stdout)
+		enc.SetIndent("", "  ")
+		return enc.Encode(report)
 	}
 
-	// 打印详细结果表格
-	fmt.Println("\n================================================================================")
-	fmt.Println("DNS查询详细结果:")
-	fmt.Println("================================================================================")
-	fmt.Printf("%-12s %-8s %-35s %s\n", "记录类型", "状态", "名称", "内容")
-	fmt.Println("--------------------------------------------------------------------------------")
-	
-	allPass := true
-	warningCount := 0
-	for _, record := range records {
-		statusSymbol := "✅"
-		statusText := "正常"
-		if record.Status == "NOT_FOUND" {
-			statusSymbol = "❌"
-			statusText = "缺失"
-			if record.RecordType != "PTR" {
-				allPass = false
-			} else {
-				warningCount++
-			}
-		} else if record.Status == "ERROR" {
-			statusSymbol = "❌"
-			statusText = "错误"
-			allPass = false
-		}
-		
-		// 格式化名称，截断过长的部分
-		displayName := record.Name
-		if len(displayName) > 33 {
-			displayName = displayName[:30] + "..."
-		}
-		
-		// 打印记录
-		if len(record.Content) > 0 {
-			for i, content := range record.Content {
-				if i == 0 {
-					if record.RecordType == "MX" && record.Priority > 0 {
-						fmt.Printf("%-12s %s %-6s %-35s 优先级:%d %s\n", 
-							record.RecordType, statusSymbol, statusText, displayName, record.Priority, content)
-					} else {
-						fmt.Printf("%-12s %s %-6s %-35s %s\n", 
-							record.RecordType, statusSymbol, statusText, displayName, content)
-					}
-				} else {
-					// 多个内容的记录，后续行缩进显示
-					fmt.Printf("%-12s %-8s %-35s %s\n", "", "", "", content)
-				}
-			}
-		} else {
-			// 没有内容的记录（错误或未找到）
-			errorMsg := record.Error
-			if errorMsg == "" {
-				errorMsg = "无数据"
-			}
-			fmt.Printf("%-12s %s %-6s %-35s %s\n", 
-				record.RecordType, statusSymbol, statusText, displayName, errorMsg)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", p.Sprintf("Check"), p.Sprintf("Status"), p.Sprintf("Name"), p.Sprintf("Details"))
+	for _, res := range report.Results {
+		status := dnscheck.StatusText(p, res.Status)
+		if res.DNSSEC {
+			status += " (DNSSEC)"
 		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\n", res.Check, status, res.Name, res.Message)
 	}
-	
-	fmt.Println("================================================================================")
-	
-	// 打印总结
-	fmt.Println("\n检查总结:")
-	fmt.Println("--------------------------------------------------------------------------------")
-	if allPass && warningCount == 0 {
-		fmt.Println("✅ 所有DNS记录配置正确！邮件服务器DNS配置完整。")
-	} else if allPass && warningCount > 0 {
-		fmt.Println("⚠️  基本DNS记录已配置，但建议配置反向DNS(PTR)记录以提高邮件送达率。")
-		fmt.Println("   请联系您的服务器提供商设置PTR记录。")
-	} else {
-		fmt.Println("❌ 部分必要的DNS记录缺失或配置错误。")
-		fmt.Println("   运行 'mailchatd dns guide' 查看完整配置指南。")
-		fmt.Println("\n缺失的记录:")
-		for _, record := range records {
-			if record.Status == "NOT_FOUND" && record.RecordType != "PTR" {
-				fmt.Printf("   - %s (%s)\n", record.RecordType, record.Name)
-			}
-		}
+	if err := w.Flush(); err != nil {
+		return err
 	}
-	fmt.Println("================================================================================")
-
+	fmt.Println()
+	fmt.Println(p.Sprintf("Score: %d/100", report.Score))
 	return nil
 }
 
@@ -0,0 +1,321 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/pem"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	parser "github.com/mail-chat-chain/mailchatd/framework/cfgparser"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	libdns2 "github.com/mail-chat-chain/mailchatd/internal/libdns"
+	"github.com/mail-chat-chain/mailchatd/internal/modify/dkim"
+	"github.com/spf13/cobra"
+)
+
+func NewDNSApplyCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "apply",
+		Short: "Create or update DNS records using a DNS provider",
+		Long: `Compute the records required for the mail server (the same ones printed by
+'dns guide') and reconcile them with the zone contents using the libdns provider
+configured in the top-level block specified by --provider, e.g.:
+
+    dns_provider libdns.cloudflare {
+        api_token ...
+    }
+
+Only records managed by the mail server are changed, other records in the zone
+(including unrelated TXT records) are left untouched.
+
+DKIM records are taken from the modify.dkim modifier that signs messages from
+the primary domain: the record of its selector, or the records of the selectors
+in the rotation state (rotate_state) if keys are rotated (rotate_interval). Keys
+are not generated by this command, start the server first if they do not exist.
+
+MTA-STS policy host mta-sts.<domain> is pointed to the mail server, which
+serves the policy using the HTTP endpoint.`,
+		RunE: runDNSApply,
+	}
+	cmd.Flags().String("provider", "", "Configuration block with the libdns provider to use")
+	cmd.Flags().String("zone", "", "DNS zone to update (defaults to the primary domain)")
+	cmd.Flags().String("tls-cert", "", "Certificate file used to generate TLSA record for the MX host")
+	cmd.Flags().Duration("ttl", time.Hour, "TTL for created records")
+	cmd.Flags().Bool("dry-run", false, "Only show changes that would be made")
+	cmd.Flags().BoolP("yes", "y", false, "Do not ask for confirmation")
+	_ = cmd.MarkFlagRequired("provider")
+	return cmd
+}
+
+// desiredDNSRecords returns the records required for the mail server with
+// names relative to the zone.
+func desiredDNSRecords(cfg *DNSConfig, zone string, ttl time.Duration, tlsaHash string) []libdns.Record {
+	rel := func(fqdn string) string {
+		name := libdns.RelativeName(fqdn, zone)
+		if name == "" {
+			return "@"
+		}
+		return name
+	}
+	inZone := func(fqdn string) bool {
+		fqdn = strings.TrimSuffix(fqdn, ".")
+		zone := strings.TrimSuffix(zone, ".")
+		return fqdn == zone || strings.HasSuffix(fqdn, "."+zone)
+	}
+
+	var recs []libdns.Record
+	add := func(fqdn, typ, value string, priority, weight uint) {
+		if !inZone(fqdn) || value == "" {
+			return
+		}
+		recs = append(recs, libdns.Record{
+			Type:     typ,
+			Name:     rel(fqdn),
+			Value:    value,
+			TTL:      ttl,
+			Priority: priority,
+			Weight:   weight,
+		})
+	}
+
+	domain, hostname := cfg.PrimaryDomain, cfg.Hostname
+	mxTarget := hostname + "."
+
+	if isValidIPv4(cfg.ServerIP) {
+		add(domain, "A", cfg.ServerIP, 0, 0)
+		add(hostname, "A", cfg.ServerIP, 0, 0)
+	}
+	if cfg.ServerIPv6 != "" && isValidIPv6(cfg.ServerIPv6) {
+		add(domain, "AAAA", cfg.ServerIPv6, 0, 0)
+		add(hostname, "AAAA", cfg.ServerIPv6, 0, 0)
+	}
+
+	add(domain, "MX", mxTarget, 10, 0)
+
+	add(domain, "TXT", "v=spf1 mx ~all", 0, 0)
+	add(hostname, "TXT", "v=spf1 a ~all", 0, 0)
+
+	selectors := make([]string, 0, len(cfg.DKIMRecords))
+	for selector := range cfg.DKIMRecords {
+		selectors = append(selectors, selector)
+	}
+	sort.Strings(selectors)
+	for _, selector := range selectors {
+		add(selector+"._domainkey."+domain, "TXT", cfg.DKIMRecords[selector], 0, 0)
+	}
+
+	dmarcPolicy := cfg.DMARCPolicy
+	if dmarcPolicy == "" {
+		dmarcPolicy = "quarantine"
+	}
+	add("_dmarc."+domain, "TXT", fmt.Sprintf("v=DMARC1; p=%s; ruf=mailto:%s", dmarcPolicy, cfg.PostmasterEmail), 0, 0)
+
+	add("_mta-sts."+domain, "TXT", "v=STSv1; id=1", 0, 0)
+	add("mta-sts."+domain, "CNAME", mxTarget, 0, 0)
+	add("_smtp._tls."+domain, "TXT", "v=TLSRPTv1; rua=mailto:"+cfg.PostmasterEmail, 0, 0)
+
+	if tlsaHash != "" {
+		// DANE-EE, SPKI, SHA-256 (RFC 7671).
+		add("_25._tcp."+hostname, "TLSA", "3 1 1 "+tlsaHash, 0, 0)
+	}
+
+	// Service discovery for mail clients (RFC 6186, RFC 8314).
+	add("_submissions._tcp."+domain, "SRV", "465 "+mxTarget, 0, 1)
+	add("_submission._tcp."+domain, "SRV", "587 "+mxTarget, 0, 1)
+	add("_imaps._tcp."+domain, "SRV", "993 "+mxTarget, 0, 1)
+	add("_imap._tcp."+domain, "SRV", "143 "+mxTarget, 0, 1)
+	add("_autodiscover._tcp."+domain, "SRV", "443 "+mxTarget, 0, 1)
+
+	return recs
+}
+
+// dkimKeyConfigs returns the key configurations of modify.dkim modifiers
+// defined in the configuration, both as top-level blocks and inline in
+// modify blocks.
+func dkimKeyConfigs(nodes []config.Node, inModify bool) ([]dkim.KeyConfig, error) {
+	var res []dkim.KeyConfig
+	for _, node := range nodes {
+		var (
+			isDKIM bool
+			args   []string
+		)
+		switch {
+		case inModify && (node.Name == "dkim" || node.Name == "modify.dkim"):
+			isDKIM, args = true, node.Args
+		case node.Name == "modify.dkim":
+			// Top-level block, arguments are the instance name and aliases.
+			isDKIM = true
+		}
+		if isDKIM {
+			kc, err := dkim.ReadKeyConfig(args, node)
+			if err != nil {
+				return nil, config.NodeErr(node, "%v", err)
+			}
+			res = append(res, kc)
+			continue
+		}
+
+		sub, err := dkimKeyConfigs(node.Children, node.Name == "modify")
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, sub...)
+	}
+	return res, nil
+}
+
+// configuredDKIMRecords returns the DKIM records published by the modify.dkim
+// modifier that signs messages from the domain, keyed by selector. It returns
+// nil if there is no such modifier.
+func configuredDKIMRecords(cfgPath, domain string) (map[string]string, error) {
+	f, err := os.Open(cfgPath)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+	nodes, err := parser.Read(f, cfgPath)
+	if err != nil {
+		return nil, err
+	}
+
+	configs, err := dkimKeyConfigs(nodes, false)
+	if err != nil {
+		return nil, err
+	}
+	for _, kc := range configs {
+		if kc.Signs(domain) {
+			return kc.Records(domain)
+		}
+	}
+	return nil, nil
+}
+
+// tlsaSPKIHash returns the SHA-256 hash of the SubjectPublicKeyInfo of the
+// first certificate in the PEM file.
+func tlsaSPKIHash(certPath string) (string, error) {
+	blob, err := os.ReadFile(certPath)
+	if err != nil {
+		return "", err
+	}
+	block, _ := pem.Decode(blob)
+	if block == nil || block.Type != "CERTIFICATE" {
+		return "", fmt.Errorf("%s: no PEM certificate found", certPath)
+	}
+	cert, err := x509.ParseCertificate(block.Bytes)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", certPath, err)
+	}
+	sum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	return hex.EncodeToString(sum[:]), nil
+}
+
+func openDNSProvider(cmd *cobra.Command, cfgBlock string) (libdns2.ZoneEditor, error) {
+	globals, mod, err := getBlockModule(cmd, cfgBlock)
+	if err != nil {
+		return nil, err
+	}
+
+	editor, ok := mod.Instance.(libdns2.ZoneEditor)
+	if !ok {
+		return nil, fmt.Errorf("configuration block %s is not a libdns provider", cfgBlock)
+	}
+
+	if err := mod.Instance.Init(config.NewMap(globals, mod.Cfg)); err != nil {
+		return nil, fmt.Errorf("Error: module initialization failed: %w", err)
+	}
+
+	return editor, nil
+}
+
+func runDNSApply(cmd *cobra.Command, args []string) error {
+	providerBlock, _ := cmd.Flags().GetString("provider")
+	zone, _ := cmd.Flags().GetString("zone")
+	tlsCert, _ := cmd.Flags().GetString("tls-cert")
+	ttl, _ := cmd.Flags().GetDuration("ttl")
+	dryRun, _ := cmd.Flags().GetBool("dry-run")
+	yes, _ := cmd.Flags().GetBool("yes")
+
+	cfg, err := readDNSConfig()
+	if err != nil {
+		return fmt.Errorf("failed to load configuration: %v", err)
+	}
+	if cfg.Hostname == cfg.PrimaryDomain || !strings.Contains(cfg.Hostname, ".") {
+		cfg.Hostname = fmt.Sprintf("mx1.%s", cfg.PrimaryDomain)
+	}
+	if zone == "" {
+		zone = cfg.PrimaryDomain
+	}
+	zone = strings.TrimSuffix(zone, ".") + "."
+
+	var tlsaHash string
+	if tlsCert != "" {
+		tlsaHash, err = tlsaSPKIHash(tlsCert)
+		if err != nil {
+			return err
+		}
+	}
+
+	editor, err := openDNSProvider(cmd, providerBlock)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(editor)
+
+	// openDNSProvider changes the working directory to the state directory,
+	// so DKIM key paths are resolved the same way the server does.
+	cfgPath, _ := cmd.Flags().GetString("config")
+	cfg.DKIMRecords, err = configuredDKIMRecords(cfgPath, cfg.PrimaryDomain)
+	if err != nil {
+		return fmt.Errorf("failed to read DKIM records: %w", err)
+	}
+	if cfg.DKIMRecords == nil {
+		fmt.Printf("No modify.dkim signs messages from %s, DKIM records are not managed.\n", cfg.PrimaryDomain)
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Minute)
+	defer cancel()
+
+	current, err := editor.GetRecords(ctx, zone)
+	if err != nil {
+		return fmt.Errorf("failed to list records in %s: %w", zone, err)
+	}
+
+	plan := libdns2.ComputePlan(zone, current, desiredDNSRecords(cfg, zone, ttl, tlsaHash))
+	if len(plan.Changes) == 0 {
+		fmt.Printf("Zone %s is up to date.\n", zone)
+		return nil
+	}
+
+	fmt.Printf("Changes for zone %s:\n\n", zone)
+	for _, c := range plan.Changes {
+		fmt.Println(c)
+	}
+	fmt.Println()
+
+	if dryRun {
+		fmt.Printf("%d change(s) not applied (--dry-run).\n", len(plan.Changes))
+		return nil
+	}
+	if !yes {
+		fmt.Printf("Apply %d change(s)? [y/N]: ", len(plan.Changes))
+		answer, _ := bufio.NewReader(os.Stdin).ReadString('\n')
+		if answer = strings.ToLower(strings.TrimSpace(answer)); answer != "y" && answer != "yes" {
+			fmt.Println("Aborted.")
+			return nil
+		}
+	}
+
+	if err := libdns2.Apply(ctx, editor, plan); err != nil {
+		return err
+	}
+	fmt.Printf("Applied %d change(s).\n", len(plan.Changes))
+	return nil
+}
@@ -26,7 +26,6 @@ import (
 	"time"
 
 	"github.com/emersion/go-imap"
-	imapsql "github.com/foxcpp/go-imap-sql"
 	"github.com/spf13/cobra"
 )
 
@@ -175,9 +174,7 @@ func msgsAddFlags(cmd *cobra.Command, args []string) error {
 	}
 
 	useUID, _ := cmd.Flags().GetBool("uid")
-	mboxB := mbox.(*imapsql.Mailbox)
-
-	return mboxB.UpdateMessagesFlags(useUID, seq, imap.AddFlags, false, flags)
+	return mbox.UpdateMessagesFlags(useUID, seq, imap.AddFlags, false, flags)
 }
 
 func msgsRemoveFlags(cmd *cobra.Command, args []string) error {
@@ -208,9 +205,7 @@ func msgsRemoveFlags(cmd *cobra.Command, args []string) error {
 	}
 
 	useUID, _ := cmd.Flags().GetBool("uid")
-	mboxB := mbox.(*imapsql.Mailbox)
-
-	return mboxB.UpdateMessagesFlags(useUID, seq, imap.RemoveFlags, false, flags)
+	return mbox.UpdateMessagesFlags(useUID, seq, imap.RemoveFlags, false, flags)
 }
 
 func msgsList(cmd *cobra.Command, args []string) error {
@@ -269,6 +264,10 @@ func msgsList(cmd *cobra.Command, args []string) error {
 	return <-done
 }
 
+type messageDeleter interface {
+	DelMessages(uid bool, seqset *imap.SeqSet) error
+}
+
 func msgsRemove(cmd *cobra.Command, args []string) error {
 	be, err := openStorage(cmd)
 	if err != nil {
@@ -307,6 +306,9 @@ func msgsRemove(cmd *cobra.Command, args []string) error {
 		}
 	}
 
-	mboxB := mbox.(*imapsql.Mailbox)
-	return mboxB.DelMessages(useUID, seq)
+	del, ok := mbox.(messageDeleter)
+	if !ok {
+		return fmt.Errorf("storage backend does not support messages removal")
+	}
+	return del.DelMessages(useUID, seq)
 }
\ No newline at end of file
@@ -0,0 +1,152 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"fmt"
+	"os"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/target/list"
+	"github.com/spf13/cobra"
+)
+
+func NewListCmd() *cobra.Command {
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "Distribution lists membership management",
+		Long: `These subcommands can be used to manage subscribers of distribution
+lists served by the target.list module.
+
+The corresponding module should be configured in mailchat.conf and be
+defined in a top-level configuration block. By default, the name of that
+block should be lists but this can be changed using --cfg-block
+flag for subcommands.`,
+	}
+
+	// Add subcommand
+	addCmd := &cobra.Command{
+		Use:   "add LIST ADDRESS...",
+		Short: "Subscribe addresses to the list",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  listAdd,
+	}
+	addCmd.Flags().String("cfg-block", "lists", "Module configuration block to use")
+
+	// Remove subcommand
+	removeCmd := &cobra.Command{
+		Use:   "remove LIST ADDRESS...",
+		Short: "Unsubscribe addresses from the list",
+		Args:  cobra.MinimumNArgs(2),
+		RunE:  listRemove,
+	}
+	removeCmd.Flags().String("cfg-block", "lists", "Module configuration block to use")
+
+	// Members subcommand
+	membersCmd := &cobra.Command{
+		Use:   "members [LIST]",
+		Short: "List subscribers of the list",
+		Long: `If LIST is not specified, configured lists are printed
+instead.`,
+		Args: cobra.MaximumNArgs(1),
+		RunE: listMembers,
+	}
+	membersCmd.Flags().String("cfg-block", "lists", "Module configuration block to use")
+	membersCmd.Flags().Bool("quiet", false, "Do not print 'No members.' message")
+
+	listCmd.AddCommand(addCmd, removeCmd, membersCmd)
+	return listCmd
+}
+
+func openList(cmd *cobra.Command) (*list.Target, error) {
+	globals, mod, err := getCfgBlockModule(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	tgt, ok := mod.Instance.(*list.Target)
+	if !ok {
+		cfgBlock, _ := cmd.Flags().GetString("cfg-block")
+		return nil, fmt.Errorf("configuration block %s is not target.list", cfgBlock)
+	}
+
+	if err := mod.Instance.Init(config.NewMap(globals, mod.Cfg)); err != nil {
+		return nil, fmt.Errorf("Error: module initialization failed: %w", err)
+	}
+
+	return tgt, nil
+}
+
+func listAdd(cmd *cobra.Command, args []string) error {
+	tgt, err := openList(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(tgt)
+
+	for _, addr := range args[1:] {
+		if err := tgt.AddMember(args[0], addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listRemove(cmd *cobra.Command, args []string) error {
+	tgt, err := openList(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(tgt)
+
+	for _, addr := range args[1:] {
+		if err := tgt.RemoveMember(args[0], addr); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func listMembers(cmd *cobra.Command, args []string) error {
+	tgt, err := openList(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(tgt)
+
+	if len(args) == 0 {
+		for _, l := range tgt.Lists() {
+			fmt.Println(l)
+		}
+		return nil
+	}
+
+	members, err := tgt.Members(args[0])
+	if err != nil {
+		return err
+	}
+	quiet, _ := cmd.Flags().GetBool("quiet")
+	if len(members) == 0 && !quiet {
+		fmt.Fprintln(os.Stderr, "No members.")
+	}
+	for _, m := range members {
+		fmt.Println(m)
+	}
+	return nil
+}
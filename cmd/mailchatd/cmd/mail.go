@@ -19,6 +19,7 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package cmd
 
 import (
+	"context"
 	"errors"
 	"fmt"
 	"io"
@@ -27,6 +28,7 @@ import (
 	"path/filepath"
 	"runtime"
 	"runtime/debug"
+	"time"
 
 	"github.com/caddyserver/certmagic"
 	parser "github.com/mail-chat-chain/mailchatd/framework/cfgparser"
@@ -36,14 +38,17 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/hooks"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 	"github.com/mail-chat-chain/mailchatd/internal/authz"
 	"github.com/spf13/cobra"
 
 	// Import packages for side-effect of module registration.
+	_ "github.com/mail-chat-chain/mailchatd/internal/auth/app_passwords"
 	_ "github.com/mail-chat-chain/mailchatd/internal/auth/dovecot_sasl"
 	_ "github.com/mail-chat-chain/mailchatd/internal/auth/external"
 	_ "github.com/mail-chat-chain/mailchatd/internal/auth/ldap"
 	_ "github.com/mail-chat-chain/mailchatd/internal/auth/netauth"
+	_ "github.com/mail-chat-chain/mailchatd/internal/auth/oauth2"
 	_ "github.com/mail-chat-chain/mailchatd/internal/auth/pam"
 	_ "github.com/mail-chat-chain/mailchatd/internal/auth/pass_blockchain"
 	_ "github.com/mail-chat-chain/mailchatd/internal/auth/pass_table"
@@ -56,10 +61,14 @@ import (
 	_ "github.com/mail-chat-chain/mailchatd/internal/check/dns"
 	_ "github.com/mail-chat-chain/mailchatd/internal/check/dnsbl"
 	_ "github.com/mail-chat-chain/mailchatd/internal/check/milter"
+	_ "github.com/mail-chat-chain/mailchatd/internal/check/postage"
 	_ "github.com/mail-chat-chain/mailchatd/internal/check/requiretls"
 	_ "github.com/mail-chat-chain/mailchatd/internal/check/rspamd"
+	_ "github.com/mail-chat-chain/mailchatd/internal/check/sending_quota"
 	_ "github.com/mail-chat-chain/mailchatd/internal/check/spf"
+	_ "github.com/mail-chat-chain/mailchatd/internal/endpoint/admin"
 	_ "github.com/mail-chat-chain/mailchatd/internal/endpoint/dovecot_sasld"
+	_ "github.com/mail-chat-chain/mailchatd/internal/endpoint/http"
 	_ "github.com/mail-chat-chain/mailchatd/internal/endpoint/imap"
 	_ "github.com/mail-chat-chain/mailchatd/internal/endpoint/openmetrics"
 	_ "github.com/mail-chat-chain/mailchatd/internal/endpoint/smtp"
@@ -68,13 +77,18 @@ import (
 	_ "github.com/mail-chat-chain/mailchatd/internal/libdns"
 	_ "github.com/mail-chat-chain/mailchatd/internal/modify"
 	_ "github.com/mail-chat-chain/mailchatd/internal/modify/dkim"
+	_ "github.com/mail-chat-chain/mailchatd/internal/modify/notarize"
+	_ "github.com/mail-chat-chain/mailchatd/internal/storage/blob/encrypted"
 	_ "github.com/mail-chat-chain/mailchatd/internal/storage/blob/fs"
 	_ "github.com/mail-chat-chain/mailchatd/internal/storage/blob/s3"
 	_ "github.com/mail-chat-chain/mailchatd/internal/storage/imapsql"
 	_ "github.com/mail-chat-chain/mailchatd/internal/table"
+	_ "github.com/mail-chat-chain/mailchatd/internal/target/http"
+	_ "github.com/mail-chat-chain/mailchatd/internal/target/list"
 	_ "github.com/mail-chat-chain/mailchatd/internal/target/queue"
 	_ "github.com/mail-chat-chain/mailchatd/internal/target/remote"
 	_ "github.com/mail-chat-chain/mailchatd/internal/target/smtp"
+	_ "github.com/mail-chat-chain/mailchatd/internal/target/vacation"
 	_ "github.com/mail-chat-chain/mailchatd/internal/tls"
 	_ "github.com/mail-chat-chain/mailchatd/internal/tls/acme"
 )
@@ -110,6 +124,8 @@ func addMailCommands(rootCmd *cobra.Command) {
 	configPath = filepath.Join(ConfigDirectory, "mailchatd.conf")
 	AddGlobalStringFlag(rootCmd, "mail-config", "Configuration file to use", "MAILCHAT_CONFIG", configPath, &configPath)
 	// fmt.Printf("Using config file: %s\n", configPath)
+	AddGlobalStringFlag(rootCmd, "admin-socket", "Manage a running server through its admin API (unix socket path or URL)", "MAILCHAT_ADMIN_SOCKET", "", &adminSocket)
+	adminToken = os.Getenv("MAILCHAT_ADMIN_TOKEN")
 
 	var (
 		logTargets []string
@@ -147,6 +163,14 @@ func addMailCommands(rootCmd *cobra.Command) {
 		NewImapMsgsCmd(),
 		NewImapMboxesCmd(),
 		NewDNSCmd(),
+		NewVacationCmd(),
+		NewListCmd(),
+		NewAdminCmd(),
+		NewAuthGuardCmd(),
+		NewSendingQuotaCmd(),
+		NewPostageCmd(),
+		NewBlobCryptCmd(),
+		NewNotaryCmd(),
 	)
 }
 
@@ -308,6 +332,7 @@ func ReadGlobals(cfg []config.Node) (map[string]interface{}, []config.Node, erro
 	globals.StringList("auth_domains", false, false, nil, nil)
 	globals.Custom("log", false, false, defaultLogOutput, logOutput, &log.DefaultLogger.Out)
 	globals.Bool("debug", false, log.DefaultLogger.Debug, &log.DefaultLogger.Debug)
+	globals.Custom("tracing", false, false, nil, tracing.Directive, nil)
 	config.EnumMapped(globals, "auth_map_normalize", true, false, authz.NormalizeFuncs, authz.NormalizeAuto, nil)
 	modconfig.Table(globals, "auth_map", true, false, nil, nil)
 	globals.AllowUnknown()
@@ -328,6 +353,21 @@ func moduleMain(cfg []config.Node) error {
 
 	hooks.AddHook(hooks.EventLogRotate, reinitLogging)
 
+	if tracingCfg, ok := globals["tracing"].(*tracing.Config); ok {
+		shutdown, err := tracing.Setup(tracingCfg, Version)
+		if err != nil {
+			return err
+		}
+		hooks.AddHook(hooks.EventShutdown, func() {
+			ctx, cancel := context.WithTimeout(context.Background(), 10*time.Second)
+			defer cancel()
+			if err := shutdown(ctx); err != nil {
+				log.Println("tracing shutdown failed:", err)
+			}
+		})
+		log.Debugln("tracing enabled, exporting to", tracingCfg.Exporter, tracingCfg.Endpoint)
+	}
+
 	endpoints, mods, err := RegisterModules(globals, modBlocks)
 	if err != nil {
 		return err
@@ -356,6 +396,7 @@ type ModInfo struct {
 
 func RegisterModules(globals map[string]interface{}, nodes []config.Node) (endpoints, mods []ModInfo, err error) {
 	mods = make([]ModInfo, 0, len(nodes))
+	module.ResetListeners()
 
 	for _, block := range nodes {
 		var instName string
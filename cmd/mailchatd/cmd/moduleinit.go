@@ -28,6 +28,7 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/config"
 	"github.com/mail-chat-chain/mailchatd/framework/hooks"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/endpoint/admin"
 	"github.com/mail-chat-chain/mailchatd/internal/updatepipe"
 	"github.com/spf13/cobra"
 )
@@ -39,6 +40,16 @@ func closeIfNeeded(i interface{}) {
 }
 
 func getCfgBlockModule(cmd *cobra.Command) (map[string]interface{}, *ModInfo, error) {
+	cfgBlock, _ := cmd.Flags().GetString("cfg-block")
+	if cfgBlock == "" {
+		return nil, nil, fmt.Errorf("cfg-block is required")
+	}
+	return getBlockModule(cmd, cfgBlock)
+}
+
+// getBlockModule reads the configuration and returns the module defined in
+// the top-level block with the specified name. Module is not initialized.
+func getBlockModule(cmd *cobra.Command, cfgBlock string) (map[string]interface{}, *ModInfo, error) {
 	cfgPath, _ := cmd.Flags().GetString("config")
 	if cfgPath == "" {
 		return nil, nil, fmt.Errorf("config is required")
@@ -69,10 +80,6 @@ func getCfgBlockModule(cmd *cobra.Command) (map[string]interface{}, *ModInfo, er
 	}
 	defer hooks.RunHooks(hooks.EventShutdown)
 
-	cfgBlock, _ := cmd.Flags().GetString("cfg-block")
-	if cfgBlock == "" {
-		return nil, nil, fmt.Errorf("cfg-block is required")
-	}
 	var mod ModInfo
 	for _, m := range mods {
 		if m.Instance.InstanceName() == cfgBlock {
@@ -87,7 +94,16 @@ func getCfgBlockModule(cmd *cobra.Command) (map[string]interface{}, *ModInfo, er
 	return globals, &mod, nil
 }
 
+// adminSocket and adminToken select the admin API of a running server
+// instead of opening storage directly. This is the only safe way to manage
+// SQLite-backed storage while the server is running.
+var adminSocket, adminToken string
+
 func openStorage(cmd *cobra.Command) (module.Storage, error) {
+	if adminSocket != "" {
+		return admin.NewClient(adminSocket, adminToken).Storage(), nil
+	}
+
 	globals, mod, err := getCfgBlockModule(cmd)
 	if err != nil {
 		return nil, err
@@ -115,6 +131,10 @@ func openStorage(cmd *cobra.Command) (module.Storage, error) {
 }
 
 func openUserDB(cmd *cobra.Command) (module.PlainUserDB, error) {
+	if adminSocket != "" {
+		return admin.NewClient(adminSocket, adminToken).UserDB(), nil
+	}
+
 	globals, mod, err := getCfgBlockModule(cmd)
 	if err != nil {
 		return nil, err
@@ -0,0 +1,132 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/modify/notarize"
+	"github.com/spf13/cobra"
+)
+
+func NewNotaryCmd() *cobra.Command {
+	notaryCmd := &cobra.Command{
+		Use:   "notary",
+		Short: "Message notarization management",
+		Long: `These subcommands can be used to check messages notarized by the
+modify.notarize module.
+
+The corresponding module should be configured in mailchat.conf and be
+defined in a top-level configuration block. By default, the name of that
+block should be notary but this can be changed using --cfg-block flag for
+subcommands.`,
+	}
+
+	// Digest subcommand
+	digestCmd := &cobra.Command{
+		Use:   "digest FILE",
+		Short: "Compute the notarization digest of the message",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			digest, err := messageDigest(args[0])
+			if err != nil {
+				return err
+			}
+			fmt.Println(hex.EncodeToString(digest[:]))
+			return nil
+		},
+	}
+
+	// Verify subcommand
+	verifyCmd := &cobra.Command{
+		Use:   "verify FILE",
+		Short: "Verify that the message was notarized",
+		Long: `Verify that the message was notarized.
+
+FILE is the message in RFC 5322 format. The inclusion proof is read from the
+proofs table of the module and the batch root is checked against the
+contract.`,
+		Args: cobra.ExactArgs(1),
+		RunE: notaryVerify,
+	}
+	verifyCmd.Flags().String("cfg-block", "notary", "Module configuration block to use")
+
+	notaryCmd.AddCommand(digestCmd, verifyCmd)
+	return notaryCmd
+}
+
+func messageDigest(path string) ([32]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return [32]byte{}, err
+	}
+	defer f.Close()
+
+	br := bufio.NewReader(f)
+	h, err := textproto.ReadHeader(br)
+	if err != nil {
+		return [32]byte{}, fmt.Errorf("Error: malformed message: %w", err)
+	}
+	return notarize.Digest(h, br)
+}
+
+func notaryVerify(cmd *cobra.Command, args []string) error {
+	digest, err := messageDigest(args[0])
+	if err != nil {
+		return err
+	}
+
+	globals, mod, err := getCfgBlockModule(cmd)
+	if err != nil {
+		return err
+	}
+	m, ok := mod.Instance.(*notarize.Modifier)
+	if !ok {
+		cfgBlock, _ := cmd.Flags().GetString("cfg-block")
+		return fmt.Errorf("configuration block %s is not modify.notarize", cfgBlock)
+	}
+	if err := mod.Instance.Init(config.NewMap(globals, mod.Cfg)); err != nil {
+		return fmt.Errorf("Error: module initialization failed: %w", err)
+	}
+	defer closeIfNeeded(m)
+
+	v, err := m.Verify(context.Background(), digest)
+	if err != nil {
+		if errors.Is(err, notarize.ErrPending) {
+			return fmt.Errorf("Error: %x: batch is not submitted yet", digest)
+		}
+		return fmt.Errorf("Error: %x: %w", digest, err)
+	}
+
+	fmt.Printf("Digest:    %x\n", digest)
+	fmt.Printf("Root:      %s\n", v.Record.Root)
+	fmt.Printf("Tx:        %s\n", v.Record.Tx)
+	fmt.Printf("Submitter: %s\n", v.Submitter.Hex())
+	fmt.Printf("Block:     %d\n", v.Block)
+	fmt.Printf("Time:      %s\n", v.Time.UTC().Format(time.RFC3339))
+	return nil
+}
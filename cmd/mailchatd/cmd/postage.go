@@ -0,0 +1,213 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"fmt"
+	"os"
+	"strings"
+	"text/tabwriter"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/check/postage"
+	"github.com/spf13/cobra"
+)
+
+func NewPostageCmd() *cobra.Command {
+	postageCmd := &cobra.Command{
+		Use:   "postage",
+		Short: "Postage escrow management",
+		Long: `These subcommands can be used to inspect, claim and refund the postage
+escrowed for messages accepted by the check.postage module.
+
+The postage ID of each recipient is added to the message in the
+X-Postage-Deposit header field. It can also be computed from the Message-ID
+and the recipient using the id subcommand.
+
+The corresponding module should be configured in mailchat.conf and be
+defined in a top-level configuration block. By default, the name of that
+block should be postage but this can be changed using --cfg-block flag for
+subcommands.`,
+	}
+
+	// ID subcommand
+	idCmd := &cobra.Command{
+		Use:   "id MESSAGE-ID RECIPIENT",
+		Short: "Compute the postage ID for the message recipient",
+		Long: `Compute the postage ID for the message recipient.
+
+RECIPIENT can be either an EVM address or an email address that is mapped to
+one using the module configuration.`,
+		Args: cobra.ExactArgs(2),
+		RunE: postageID,
+	}
+	idCmd.Flags().String("cfg-block", "postage", "Module configuration block to use")
+
+	// Status subcommand
+	statusCmd := &cobra.Command{
+		Use:   "status ID...",
+		Short: "Show the escrow state of the postage",
+		Args:  cobra.MinimumNArgs(1),
+		RunE:  postageStatus,
+	}
+	statusCmd.Flags().String("cfg-block", "postage", "Module configuration block to use")
+
+	// Claim subcommand
+	claimCmd := &cobra.Command{
+		Use:   "claim ID...",
+		Short: "Transfer the postage to the recipient",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return postageSend(cmd, args, postage.Escrow.Claim)
+		},
+	}
+	claimCmd.Flags().String("cfg-block", "postage", "Module configuration block to use")
+	claimCmd.Flags().String("key", "", "File with the hex-encoded private key of the recipient")
+	claimCmd.MarkFlagRequired("key")
+
+	// Refund subcommand
+	refundCmd := &cobra.Command{
+		Use:   "refund ID...",
+		Short: "Return the postage to the sender",
+		Args:  cobra.MinimumNArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return postageSend(cmd, args, postage.Escrow.Refund)
+		},
+	}
+	refundCmd.Flags().String("cfg-block", "postage", "Module configuration block to use")
+	refundCmd.Flags().String("key", "", "File with the hex-encoded private key of the recipient")
+	refundCmd.MarkFlagRequired("key")
+
+	postageCmd.AddCommand(idCmd, statusCmd, claimCmd, refundCmd)
+	return postageCmd
+}
+
+func openPostage(cmd *cobra.Command) (*postage.Check, error) {
+	globals, mod, err := getCfgBlockModule(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := mod.Instance.(*postage.Check)
+	if !ok {
+		cfgBlock, _ := cmd.Flags().GetString("cfg-block")
+		return nil, fmt.Errorf("configuration block %s is not check.postage", cfgBlock)
+	}
+
+	if err := mod.Instance.Init(config.NewMap(globals, mod.Cfg)); err != nil {
+		return nil, fmt.Errorf("Error: module initialization failed: %w", err)
+	}
+
+	return c, nil
+}
+
+func parsePostageIDs(args []string) ([]common.Hash, error) {
+	ids := make([]common.Hash, 0, len(args))
+	for _, arg := range args {
+		if len(strings.TrimPrefix(arg, "0x")) != 2*common.HashLength {
+			return nil, fmt.Errorf("Error: invalid postage ID: %s", arg)
+		}
+		ids = append(ids, common.HexToHash(arg))
+	}
+	return ids, nil
+}
+
+func postageID(cmd *cobra.Command, args []string) error {
+	rcpt := args[1]
+	if common.IsHexAddress(rcpt) {
+		fmt.Println(postage.ID(args[0], common.HexToAddress(rcpt)).Hex())
+		return nil
+	}
+
+	c, err := openPostage(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(c)
+
+	addr, ok, err := c.RecipientAddress(context.Background(), rcpt)
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("Error: %s has no EVM address", rcpt)
+	}
+	fmt.Println(postage.ID(args[0], addr).Hex())
+	return nil
+}
+
+func postageStatus(cmd *cobra.Command, args []string) error {
+	ids, err := parsePostageIDs(args)
+	if err != nil {
+		return err
+	}
+	c, err := openPostage(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(c)
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "ID\tSTATE\tAMOUNT\tSENDER\tRECIPIENT")
+	for _, id := range ids {
+		d, err := c.Escrow().Deposit(context.Background(), id)
+		if err != nil {
+			return err
+		}
+		if d.State == postage.StateNone {
+			fmt.Fprintf(w, "%s\t%s\t-\t-\t-\n", id.Hex(), d.State)
+			continue
+		}
+		fmt.Fprintf(w, "%s\t%s\t%s\t%s\t%s\n", id.Hex(), d.State, d.Amount, d.Sender.Hex(), d.Recipient.Hex())
+	}
+	return w.Flush()
+}
+
+type postageSendFunc func(postage.Escrow, context.Context, common.Hash, *ecdsa.PrivateKey, uint64) (common.Hash, error)
+
+func postageSend(cmd *cobra.Command, args []string, send postageSendFunc) error {
+	ids, err := parsePostageIDs(args)
+	if err != nil {
+		return err
+	}
+	keyFile, _ := cmd.Flags().GetString("key")
+	key, err := crypto.LoadECDSA(keyFile)
+	if err != nil {
+		return fmt.Errorf("Error: failed to load the key: %w", err)
+	}
+
+	c, err := openPostage(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(c)
+
+	for _, id := range ids {
+		txHash, err := send(c.Escrow(), context.Background(), id, key, c.GasLimit())
+		if err != nil {
+			return fmt.Errorf("Error: %s: %w", id.Hex(), err)
+		}
+		fmt.Printf("%s: transaction %s sent\n", id.Hex(), txHash.Hex())
+	}
+	return nil
+}
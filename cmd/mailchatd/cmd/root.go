@@ -21,6 +21,7 @@ import (
 	srvflags "github.com/cosmos/evm/server/flags"
 	app "github.com/mail-chat-chain/mailchatd/app"
 	evmdconfig "github.com/mail-chat-chain/mailchatd/config"
+	mailcli "github.com/mail-chat-chain/mailchatd/x/mail/client/cli"
 
 	"cosmossdk.io/log"
 	"cosmossdk.io/store"
@@ -245,6 +246,7 @@ func queryCommand() *cobra.Command {
 		authcmd.QueryTxCmd(),
 		sdkserver.QueryBlockCmd(),
 		sdkserver.QueryBlockResultsCmd(),
+		mailcli.GetQueryCmd(),
 	)
 
 	cmd.PersistentFlags().String(flags.FlagChainID, "", "The network chain ID")
@@ -271,6 +273,7 @@ func txCommand() *cobra.Command {
 		authcmd.GetEncodeCommand(),
 		authcmd.GetDecodeCommand(),
 		authcmd.GetSimulateCmd(),
+		mailcli.NewTxCmd(),
 	)
 
 	cmd.PersistentFlags().String(flags.FlagChainID, "", "The network chain ID")
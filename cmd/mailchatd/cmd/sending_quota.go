@@ -0,0 +1,134 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"text/tabwriter"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/check/sending_quota"
+	"github.com/spf13/cobra"
+)
+
+func NewSendingQuotaCmd() *cobra.Command {
+	quotaCmd := &cobra.Command{
+		Use:   "sending-quota",
+		Short: "Per-user sending quotas management",
+		Long: `These subcommands can be used to inspect sending quota usage and lift
+suspensions made by the check.sending_quota module.
+
+The corresponding module should be configured in mailchat.conf and be
+defined in a top-level configuration block with the state table set. By
+default, the name of that block should be sending_quota but this can be
+changed using --cfg-block flag for subcommands.`,
+	}
+
+	// List subcommand
+	listCmd := &cobra.Command{
+		Use:   "list [USERNAME]",
+		Short: "Show quota usage of all users or the specified user",
+		Args:  cobra.MaximumNArgs(1),
+		RunE:  sendingQuotaList,
+	}
+	listCmd.Flags().String("cfg-block", "sending_quota", "Module configuration block to use")
+	listCmd.Flags().Bool("suspended", false, "List only suspended users")
+
+	// Reset subcommand
+	resetCmd := &cobra.Command{
+		Use:   "reset USERNAME",
+		Short: "Reset quota counters and lift the suspension of the user",
+		Args:  cobra.ExactArgs(1),
+		RunE:  sendingQuotaReset,
+	}
+	resetCmd.Flags().String("cfg-block", "sending_quota", "Module configuration block to use")
+
+	quotaCmd.AddCommand(listCmd, resetCmd)
+	return quotaCmd
+}
+
+func openSendingQuota(cmd *cobra.Command) (*sending_quota.Check, error) {
+	globals, mod, err := getCfgBlockModule(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	c, ok := mod.Instance.(*sending_quota.Check)
+	if !ok {
+		cfgBlock, _ := cmd.Flags().GetString("cfg-block")
+		return nil, fmt.Errorf("configuration block %s is not check.sending_quota", cfgBlock)
+	}
+
+	if err := mod.Instance.Init(config.NewMap(globals, mod.Cfg)); err != nil {
+		return nil, fmt.Errorf("Error: module initialization failed: %w", err)
+	}
+	if !c.Persistent() {
+		return nil, fmt.Errorf("Error: state table is not configured for check.sending_quota")
+	}
+
+	return c, nil
+}
+
+func sendingQuotaList(cmd *cobra.Command, args []string) error {
+	c, err := openSendingQuota(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(c)
+
+	users := args
+	if len(users) == 0 {
+		users, err = c.Users()
+		if err != nil {
+			return err
+		}
+	}
+	onlySuspended, _ := cmd.Flags().GetBool("suspended")
+
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0)
+	fmt.Fprintln(w, "USERNAME\tMESSAGES/HOUR\tRECIPIENTS/DAY\tUNIQUE/DAY\tVIOLATIONS\tSUSPENDED")
+	for _, user := range users {
+		u, err := c.Usage(context.Background(), user)
+		if err != nil {
+			return err
+		}
+		if onlySuspended && u.Suspended.IsZero() {
+			continue
+		}
+		suspended := "-"
+		if !u.Suspended.IsZero() {
+			suspended = u.Suspended.Local().Format(time.DateTime)
+		}
+		fmt.Fprintf(w, "%s\t%d\t%d\t%d\t%d\t%s\n", user, u.Messages, u.Recipients, len(u.Unique), u.Violations, suspended)
+	}
+	return w.Flush()
+}
+
+func sendingQuotaReset(cmd *cobra.Command, args []string) error {
+	c, err := openSendingQuota(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(c)
+
+	return c.Reset(args[0])
+}
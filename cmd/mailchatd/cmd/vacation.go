@@ -0,0 +1,275 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/target/vacation"
+	"github.com/spf13/cobra"
+)
+
+func NewVacationCmd() *cobra.Command {
+	vacationCmd := &cobra.Command{
+		Use:   "vacation",
+		Short: "Automatic replies (out of office) management",
+		Long: `These subcommands can be used to manage per-account automatic replies
+sent by the target.vacation module.
+
+The corresponding module should be configured in mailchat.conf and be
+defined in a top-level configuration block. By default, the name of that
+block should be vacation but this can be changed using --cfg-block
+flag for subcommands.`,
+	}
+
+	// List subcommand
+	listCmd := &cobra.Command{
+		Use:   "list",
+		Short: "List accounts with automatic replies configured",
+		RunE:  vacationList,
+	}
+	listCmd.Flags().String("cfg-block", "vacation", "Module configuration block to use")
+
+	// Status subcommand
+	statusCmd := &cobra.Command{
+		Use:   "status USERNAME",
+		Short: "Show automatic reply configuration for the account",
+		Args:  cobra.ExactArgs(1),
+		RunE:  vacationStatus,
+	}
+	statusCmd.Flags().String("cfg-block", "vacation", "Module configuration block to use")
+
+	// Enable subcommand
+	enableCmd := &cobra.Command{
+		Use:   "enable USERNAME",
+		Short: "Enable automatic replies for the account",
+		Long: `Reply text is read from the file specified using --body-file
+or from --body flag. If --subject is not set, replies use the original subject
+prefixed with "Auto:".
+
+--start and --end accept RFC 3339 timestamps (2006-01-02T15:04:05Z07:00) or
+dates (2006-01-02).`,
+		Args: cobra.ExactArgs(1),
+		RunE: vacationEnable,
+	}
+	enableCmd.Flags().String("cfg-block", "vacation", "Module configuration block to use")
+	enableCmd.Flags().String("subject", "", "Subject of the reply")
+	enableCmd.Flags().String("body", "", "Text of the reply")
+	enableCmd.Flags().String("body-file", "", "Read text of the reply from file")
+	enableCmd.Flags().String("start", "", "Do not send replies before this time")
+	enableCmd.Flags().String("end", "", "Do not send replies after this time")
+	enableCmd.Flags().StringSlice("alias", nil, "Other addresses of the account to reply to, may be repeated")
+
+	// Disable subcommand
+	disableCmd := &cobra.Command{
+		Use:   "disable USERNAME",
+		Short: "Disable automatic replies for the account",
+		Long: `Configured reply text is preserved and can be reused by running
+'enable' without --body flag.`,
+		Args: cobra.ExactArgs(1),
+		RunE: vacationDisable,
+	}
+	disableCmd.Flags().String("cfg-block", "vacation", "Module configuration block to use")
+
+	// Remove subcommand
+	removeCmd := &cobra.Command{
+		Use:   "remove USERNAME",
+		Short: "Remove automatic reply configuration and history for the account",
+		Args:  cobra.ExactArgs(1),
+		RunE:  vacationRemove,
+	}
+	removeCmd.Flags().String("cfg-block", "vacation", "Module configuration block to use")
+
+	vacationCmd.AddCommand(listCmd, statusCmd, enableCmd, disableCmd, removeCmd)
+	return vacationCmd
+}
+
+func openVacation(cmd *cobra.Command) (*vacation.Target, error) {
+	globals, mod, err := getCfgBlockModule(cmd)
+	if err != nil {
+		return nil, err
+	}
+
+	tgt, ok := mod.Instance.(*vacation.Target)
+	if !ok {
+		cfgBlock, _ := cmd.Flags().GetString("cfg-block")
+		return nil, fmt.Errorf("configuration block %s is not target.vacation", cfgBlock)
+	}
+
+	if err := mod.Instance.Init(config.NewMap(globals, mod.Cfg)); err != nil {
+		return nil, fmt.Errorf("Error: module initialization failed: %w", err)
+	}
+
+	return tgt, nil
+}
+
+func parseVacationTime(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, s); err == nil {
+		return t, nil
+	}
+	return time.ParseInLocation("2006-01-02", s, time.Local)
+}
+
+func vacationList(cmd *cobra.Command, args []string) error {
+	tgt, err := openVacation(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(tgt)
+
+	accts, err := tgt.Accounts()
+	if err != nil {
+		return err
+	}
+	if len(accts) == 0 {
+		fmt.Fprintln(os.Stderr, "No accounts.")
+	}
+
+	for _, acct := range accts {
+		s, _, err := tgt.Settings(context.TODO(), acct)
+		if err != nil {
+			return err
+		}
+		state := "disabled"
+		if s.Active(time.Now()) {
+			state = "active"
+		} else if s.Enabled {
+			state = "scheduled"
+		}
+		fmt.Printf("%s\t%s\n", acct, state)
+	}
+	return nil
+}
+
+func vacationStatus(cmd *cobra.Command, args []string) error {
+	tgt, err := openVacation(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(tgt)
+
+	s, ok, err := tgt.Settings(context.TODO(), args[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no automatic reply configured for %s", args[0])
+	}
+
+	fmt.Println("Enabled:", s.Enabled)
+	if !s.Start.IsZero() {
+		fmt.Println("Start:", s.Start.Format(time.RFC3339))
+	}
+	if !s.End.IsZero() {
+		fmt.Println("End:", s.End.Format(time.RFC3339))
+	}
+	if len(s.Aliases) != 0 {
+		fmt.Println("Aliases:", strings.Join(s.Aliases, ", "))
+	}
+	if s.Subject != "" {
+		fmt.Println("Subject:", s.Subject)
+	}
+	fmt.Println()
+	fmt.Println(s.Body)
+	return nil
+}
+
+func vacationEnable(cmd *cobra.Command, args []string) error {
+	tgt, err := openVacation(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(tgt)
+
+	s, _, err := tgt.Settings(context.TODO(), args[0])
+	if err != nil {
+		return err
+	}
+	s.Enabled = true
+
+	if cmd.Flags().Changed("subject") {
+		s.Subject, _ = cmd.Flags().GetString("subject")
+	}
+	if cmd.Flags().Changed("body") {
+		s.Body, _ = cmd.Flags().GetString("body")
+	}
+	if bodyFile, _ := cmd.Flags().GetString("body-file"); bodyFile != "" {
+		body, err := os.ReadFile(bodyFile)
+		if err != nil {
+			return err
+		}
+		s.Body = string(body)
+	}
+	if cmd.Flags().Changed("alias") {
+		s.Aliases, _ = cmd.Flags().GetStringSlice("alias")
+	}
+	if cmd.Flags().Changed("start") {
+		start, _ := cmd.Flags().GetString("start")
+		s.Start, err = parseVacationTime(start)
+		if err != nil {
+			return fmt.Errorf("invalid --start value: %w", err)
+		}
+	}
+	if cmd.Flags().Changed("end") {
+		end, _ := cmd.Flags().GetString("end")
+		s.End, err = parseVacationTime(end)
+		if err != nil {
+			return fmt.Errorf("invalid --end value: %w", err)
+		}
+	}
+
+	return tgt.SetSettings(args[0], s)
+}
+
+func vacationDisable(cmd *cobra.Command, args []string) error {
+	tgt, err := openVacation(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(tgt)
+
+	s, ok, err := tgt.Settings(context.TODO(), args[0])
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return fmt.Errorf("no automatic reply configured for %s", args[0])
+	}
+	s.Enabled = false
+
+	return tgt.SetSettings(args[0], s)
+}
+
+func vacationRemove(cmd *cobra.Command, args []string) error {
+	tgt, err := openVacation(cmd)
+	if err != nil {
+		return err
+	}
+	defer closeIfNeeded(tgt)
+
+	return tgt.RemoveSettings(args[0])
+}
@@ -3,6 +3,7 @@ package config
 import (
 	"fmt"
 	"maps"
+	"slices"
 	"sort"
 
 	corevm "github.com/ethereum/go-ethereum/core/vm"
@@ -23,6 +24,9 @@ import (
 	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
 	minttypes "github.com/cosmos/cosmos-sdk/x/mint/types"
 	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+
+	mailprecompile "github.com/mail-chat-chain/mailchatd/precompiles/mail"
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
 )
 
 func MustGetDefaultNodeHome() string {
@@ -48,6 +52,9 @@ var maccPerms = map[string][]string{
 	feemarkettypes.ModuleName:   nil,
 	erc20types.ModuleName:       {authtypes.Minter, authtypes.Burner},
 	precisebanktypes.ModuleName: {authtypes.Minter, authtypes.Burner},
+
+	// MailChat modules
+	mailtypes.ModuleName: nil,
 }
 
 // BlockedAddresses returns all the app's blocked account addresses.
@@ -56,6 +63,7 @@ var maccPerms = map[string][]string{
 //   - module accounts
 //   - Ethereum's native precompiled smart contracts
 //   - Cosmos EVM' available static precompiled contracts
+//   - the mail registry precompiled contract
 func BlockedAddresses() map[string]bool {
 	blockedAddrs := make(map[string]bool)
 
@@ -71,7 +79,7 @@ func BlockedAddresses() map[string]bool {
 		blockedAddrs[authtypes.NewModuleAddress(acc).String()] = true
 	}
 
-	blockedPrecompilesHex := evmtypes.AvailableStaticPrecompiles
+	blockedPrecompilesHex := append(slices.Clone(evmtypes.AvailableStaticPrecompiles), mailprecompile.PrecompileAddress)
 	for _, addr := range corevm.PrecompiledAddressesPrague {
 		blockedPrecompilesHex = append(blockedPrecompilesHex, addr.Hex())
 	}
@@ -0,0 +1,30 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+pragma solidity ^0.8.20;
+
+/// @title Notary
+/// @notice Records the time of Merkle roots of message digest batches
+/// submitted by mail servers. Inclusion proofs of individual messages are
+/// kept by the server, so only the root is stored on chain.
+/// @dev Records are kept per submitter, so a root copied from a pending
+/// transaction by another account does not block the server's submission.
+contract Notary {
+    struct Record {
+        uint64 timestamp;
+        uint64 blockNumber;
+    }
+
+    mapping(address => mapping(bytes32 => Record)) public roots;
+
+    event Notarized(bytes32 indexed root, address indexed submitter, uint64 timestamp);
+
+    error AlreadyNotarized(bytes32 root);
+
+    /// @notice Records the batch root submitted by the sender with the
+    /// current block time.
+    function notarize(bytes32 root) external {
+        if (roots[msg.sender][root].timestamp != 0) revert AlreadyNotarized(root);
+
+        roots[msg.sender][root] = Record(uint64(block.timestamp), uint64(block.number));
+        emit Notarized(root, msg.sender, uint64(block.timestamp));
+    }
+}
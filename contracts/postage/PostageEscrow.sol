@@ -0,0 +1,72 @@
+// SPDX-License-Identifier: GPL-3.0-or-later
+pragma solidity ^0.8.20;
+
+/// @title PostageEscrow
+/// @notice Holds the postage attached to email messages by senders that are
+/// not in the recipient's contacts. The postage is identified by
+/// keccak256(normalized Message-ID || recipient address) and can be claimed
+/// by the recipient or refunded to the sender by the recipient.
+contract PostageEscrow {
+    enum State {
+        None,
+        Held,
+        Claimed,
+        Refunded
+    }
+
+    struct Deposit {
+        address sender;
+        address recipient;
+        uint256 amount;
+        State state;
+    }
+
+    mapping(bytes32 => Deposit) public deposits;
+
+    event Deposited(bytes32 indexed id, address indexed sender, address indexed recipient, uint256 amount);
+    event Claimed(bytes32 indexed id, address indexed recipient, uint256 amount);
+    event Refunded(bytes32 indexed id, address indexed sender, uint256 amount);
+
+    error DepositExists(bytes32 id);
+    error NotHeld(bytes32 id);
+    error NotRecipient(bytes32 id);
+    error ZeroAmount();
+    error TransferFailed();
+
+    /// @notice Escrows msg.value as the postage for the recipient.
+    function deposit(bytes32 id, address recipient) external payable {
+        if (msg.value == 0) revert ZeroAmount();
+        if (deposits[id].state != State.None) revert DepositExists(id);
+
+        deposits[id] = Deposit(msg.sender, recipient, msg.value, State.Held);
+        emit Deposited(id, msg.sender, recipient, msg.value);
+    }
+
+    /// @notice Transfers the postage to the recipient.
+    function claim(bytes32 id) external {
+        Deposit storage d = _held(id);
+        d.state = State.Claimed;
+        emit Claimed(id, d.recipient, d.amount);
+        _send(d.recipient, d.amount);
+    }
+
+    /// @notice Returns the postage to the sender, e.g. if the message turned
+    /// out to be wanted.
+    function refund(bytes32 id) external {
+        Deposit storage d = _held(id);
+        d.state = State.Refunded;
+        emit Refunded(id, d.sender, d.amount);
+        _send(d.sender, d.amount);
+    }
+
+    function _held(bytes32 id) private view returns (Deposit storage d) {
+        d = deposits[id];
+        if (d.state != State.Held) revert NotHeld(id);
+        if (d.recipient != msg.sender) revert NotRecipient(id);
+    }
+
+    function _send(address to, uint256 amount) private {
+        (bool ok, ) = to.call{value: amount}("");
+        if (!ok) revert TransferFailed();
+    }
+}
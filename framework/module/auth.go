@@ -18,7 +18,10 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 package module
 
-import "errors"
+import (
+	"context"
+	"errors"
+)
 
 // ErrUnknownCredentials should be returned by auth. provider if supplied
 // credentials are valid for it but are not recognized (e.g. not found in
@@ -33,6 +36,40 @@ type PlainAuth interface {
 	AuthPlain(username, password string) error
 }
 
+// Protocol names passed to ScopedPlainAuth.
+const (
+	ProtocolIMAP = "imap"
+	ProtocolSMTP = "smtp"
+)
+
+// ScopedPlainAuth is implemented by PlainAuth modules that can restrict
+// credentials to specific protocols.
+//
+// protocol is one of Protocol* constants or an empty string if it is
+// unknown.
+type ScopedPlainAuth interface {
+	PlainAuth
+	AuthPlainScoped(protocol, username, password string) error
+}
+
+// UsernameNormalizer is implemented by PlainAuth modules that need to see
+// usernames normalized differently from auth_map_normalize, e.g. because the
+// letter case is significant. The result is used instead of the
+// auth_map_normalize result for the module.
+type UsernameNormalizer interface {
+	NormalizeUsername(username string) (string, error)
+}
+
+// BearerAuth is the interface implemented by modules providing authentication
+// using OAuth 2.0 bearer tokens (RFC 6750).
+//
+// Modules implementing this interface should be registered with "auth." prefix in name.
+type BearerAuth interface {
+	// AuthBearer validates the access token and returns the username it was
+	// issued for.
+	AuthBearer(ctx context.Context, token string) (string, error)
+}
+
 // PlainUserDB is a local credentials store that can be managed using mailcoin command
 // utility.
 type PlainUserDB interface {
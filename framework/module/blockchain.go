@@ -2,11 +2,77 @@ package module
 
 import (
 	"context"
+	"errors"
+	"math/big"
+	"time"
 )
 
+// ErrTxPending is returned by BlockChain.TransactionReceipt if the
+// transaction is not included in a block yet.
+var ErrTxPending = errors.New("transaction is pending")
+
+// TxReceipt is the outcome of a transaction included in a block.
+type TxReceipt struct {
+	TxHash      string
+	Success     bool
+	BlockNumber uint64
+}
+
+// SignatureVerifier verifies messages signed by wallets of a chain.
+type SignatureVerifier interface {
+	// ChainType returns the kind of addresses handled by the verifier:
+	// "ethereum", "cosmos" or "ed25519".
+	ChainType(ctx context.Context) string
+	// CheckSign reports whether sign is a valid signature of the message
+	// made by the key of the address pk.
+	CheckSign(ctx context.Context, pk, sign, message string) (bool, error)
+}
+
 type BlockChain interface {
+	SignatureVerifier
+
 	// SendRawTx 发送交易
 	SendRawTx(ctx context.Context, rawTx string) error
-	ChainType(ctx context.Context) string
-	CheckSign(ctx context.Context, pk, sign, message string) (bool, error)
+
+	// ChainID returns the EIP-155 chain ID used to sign transactions.
+	ChainID(ctx context.Context) (*big.Int, error)
+	// CallContract executes a read-only contract call against the latest
+	// block. data and the returned result are hex-encoded with 0x prefix.
+	CallContract(ctx context.Context, to, data string) (string, error)
+	// PendingNonce returns the nonce to use for the next transaction of the
+	// account.
+	PendingNonce(ctx context.Context, account string) (uint64, error)
+	// SuggestGasPrice returns the gas price for new transactions.
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	// TransactionReceipt returns the receipt of the transaction or
+	// ErrTxPending if it is not included in a block yet.
+	TransactionReceipt(ctx context.Context, txHash string) (*TxReceipt, error)
+}
+
+// WaitReceipt polls the receipt of the transaction every interval until it
+// is available or timeout expires, in which case ErrTxPending is returned.
+func WaitReceipt(ctx context.Context, chain BlockChain, txHash string, timeout, interval time.Duration) (*TxReceipt, error) {
+	ctx, cancel := context.WithTimeout(ctx, timeout)
+	defer cancel()
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	for {
+		receipt, err := chain.TransactionReceipt(ctx, txHash)
+		if err == nil {
+			return receipt, nil
+		}
+		if ctx.Err() != nil {
+			return nil, ErrTxPending
+		}
+		if !errors.Is(err, ErrTxPending) {
+			return nil, err
+		}
+
+		select {
+		case <-ctx.Done():
+			return nil, ErrTxPending
+		case <-ticker.C:
+		}
+	}
 }
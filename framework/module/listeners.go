@@ -0,0 +1,77 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package module
+
+import (
+	"sync"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+)
+
+// Listener describes an address a protocol endpoint accepts connections on.
+//
+// It is used by modules that advertise the server configuration to clients
+// (e.g. autoconfig). Endpoints are initialized in configuration order so
+// consumers should call Listeners when the information is needed and not
+// during Init.
+type Listener struct {
+	// Protocol is the endpoint module name: smtp, submission, lmtp, imap.
+	Protocol string
+	// Hostname is the server name used by the endpoint, if known.
+	Hostname string
+	Endpoint config.Endpoint
+	// STARTTLS is set if the endpoint does not use implicit TLS but
+	// supports upgrading the connection.
+	STARTTLS bool
+}
+
+var (
+	listeners    []Listener
+	listenersLck sync.RWMutex
+)
+
+// RegisterListener adds the listener to the global list.
+func RegisterListener(l Listener) {
+	listenersLck.Lock()
+	defer listenersLck.Unlock()
+	listeners = append(listeners, l)
+}
+
+// Listeners returns all registered listeners for the protocol.
+func Listeners(protocol string) []Listener {
+	listenersLck.RLock()
+	defer listenersLck.RUnlock()
+
+	var res []Listener
+	for _, l := range listeners {
+		if l.Protocol == protocol {
+			res = append(res, l)
+		}
+	}
+	return res
+}
+
+// ResetListeners clears the listener list. It is called before endpoints of
+// a new configuration are initialized so addresses of the previous
+// configuration are not advertised.
+func ResetListeners() {
+	listenersLck.Lock()
+	defer listenersLck.Unlock()
+	listeners = nil
+}
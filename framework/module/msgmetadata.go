@@ -136,6 +136,13 @@ type MsgMetadata struct {
 	// header. It is only meaningful if server has seen the body at least once
 	// (e.g. the message was passed via queue).
 	TLSRequireOverride bool
+
+	// TraceParent is the W3C traceparent value identifying the span the
+	// message was accepted in. It is empty if tracing is not enabled.
+	//
+	// It is used to add the trace ID to log lines and to continue the trace
+	// when the message is processed later (e.g. by the queue).
+	TraceParent string
 }
 
 // DeepCopy creates a copy of the MsgMetadata structure, also
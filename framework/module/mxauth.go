@@ -21,6 +21,7 @@ package module
 import (
 	"context"
 	"crypto/tls"
+	"net"
 )
 
 const (
@@ -45,6 +46,7 @@ const (
 	MXNone MXLevel = iota
 	MX_MTASTS
 	MX_DNSSEC
+	MX_CHAIN
 )
 
 func (l TLSLevel) String() string {
@@ -67,6 +69,8 @@ func (l MXLevel) String() string {
 		return "mtasts"
 	case MX_DNSSEC:
 		return "dnssec"
+	case MX_CHAIN:
+		return "chain"
 	}
 	return "???"
 }
@@ -153,4 +157,13 @@ type (
 		// newMsg may be nil if object is not needed anymore.
 		Reset(newMsg *MsgMetadata)
 	}
+
+	// DeliveryMXResolver is an optional interface DeliveryMXAuthPolicy can
+	// implement to provide MX records from a source other than DNS.
+	//
+	// LookupMX is called after PrepareDomain. If ok is false, the next
+	// policy is consulted and DNS is used if no policy provides the records.
+	DeliveryMXResolver interface {
+		LookupMX(ctx context.Context, domain string) (records []*net.MX, ok bool, err error)
+	}
 )
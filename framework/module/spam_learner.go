@@ -0,0 +1,41 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package module
+
+import (
+	"context"
+	"io"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// SpamLearner is the interface implemented by modules that can train a spam
+// classifier using messages classified by the users (e.g. moved into or out
+// of the Junk mailbox).
+//
+// Modules implementing this interface are usually also registered as checks
+// ("check." prefix), since the classifier is queried by the same module.
+type SpamLearner interface {
+	// Learn submits the message to the classifier as spam (spam = true) or
+	// ham (spam = false).
+	//
+	// Implementation is free to ignore the message (e.g. if it was already
+	// learned recently) and should not block for a long time.
+	Learn(ctx context.Context, spam bool, header textproto.Header, body io.Reader) error
+}
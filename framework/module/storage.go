@@ -39,6 +39,18 @@ type Storage interface {
 	IMAPExtensions() []string
 }
 
+// SessionStorage is implemented by storages that use the password of the
+// IMAP login for the duration of the session, e.g. to decrypt messages
+// encrypted to a key derived from it.
+type SessionStorage interface {
+	Storage
+
+	// OpenIMAPSession is the same as GetOrCreateIMAPAcct but also receives
+	// the password used for the login. password is empty if the login does
+	// not use one.
+	OpenIMAPSession(username, password string) (imapbackend.User, error)
+}
+
 // ManageableStorage is an extended Storage interface that allows to
 // list existing accounts, create and delete them.
 type ManageableStorage interface {
@@ -0,0 +1,33 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package module
+
+import "errors"
+
+// ErrInvalidToken is returned by Unsubscriber if the unsubscription token
+// does not match the list and member.
+var ErrInvalidToken = errors.New("invalid unsubscription token")
+
+// Unsubscriber is the interface implemented by modules that support RFC 8058
+// one-click unsubscription (target.list).
+type Unsubscriber interface {
+	// Unsubscribe removes the member from the list. ErrInvalidToken is
+	// returned if the token is not valid.
+	Unsubscribe(list, member, token string) error
+}
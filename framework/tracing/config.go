@@ -0,0 +1,160 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tracing
+
+import (
+	"context"
+	"fmt"
+	"net/url"
+	"os"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/exporters/otlp/otlptrace/otlptracehttp"
+	"go.opentelemetry.io/otel/exporters/stdout/stdouttrace"
+	"go.opentelemetry.io/otel/sdk/resource"
+	sdktrace "go.opentelemetry.io/otel/sdk/trace"
+)
+
+// Config is the tracing configuration parsed from the 'tracing' global
+// directive.
+type Config struct {
+	// Exporter is either "otlp" or "file".
+	Exporter string
+	// Endpoint is the OTLP/HTTP collector URL for the "otlp" exporter or the
+	// file path for the "file" exporter.
+	Endpoint string
+	Headers  map[string]string
+
+	ServiceName string
+	SampleRatio float64
+}
+
+// Directive parses the 'tracing' block:
+//
+//	tracing {
+//	    exporter otlp https://collector.example.org:4318
+//	    header Authorization "Bearer token"
+//	    service_name mailchatd
+//	    sample_ratio 0.1
+//	}
+//
+// 'exporter file /path/to/traces.json' writes spans as JSON objects to the
+// file instead.
+func Directive(_ *config.Map, node config.Node) (interface{}, error) {
+	cfg := &Config{
+		Headers: map[string]string{},
+	}
+	var exporter []string
+
+	m := config.NewMap(nil, node)
+	m.StringList("exporter", false, true, nil, &exporter)
+	m.String("service_name", false, false, "mailchatd", &cfg.ServiceName)
+	m.Float("sample_ratio", false, false, 1, &cfg.SampleRatio)
+	m.Callback("header", func(_ *config.Map, node config.Node) error {
+		if len(node.Args) != 2 {
+			return config.NodeErr(node, "expected two arguments: header name and value")
+		}
+		cfg.Headers[node.Args[0]] = node.Args[1]
+		return nil
+	})
+	if _, err := m.Process(); err != nil {
+		return nil, err
+	}
+
+	if len(exporter) != 2 {
+		return nil, config.NodeErr(node, "exporter: expected two arguments: type and endpoint")
+	}
+	cfg.Exporter, cfg.Endpoint = exporter[0], exporter[1]
+	switch cfg.Exporter {
+	case "otlp":
+		u, err := url.Parse(cfg.Endpoint)
+		if err != nil {
+			return nil, config.NodeErr(node, "exporter: %v", err)
+		}
+		if u.Scheme != "http" && u.Scheme != "https" {
+			return nil, config.NodeErr(node, "exporter: OTLP endpoint should be an http:// or https:// URL")
+		}
+	case "file":
+	default:
+		return nil, config.NodeErr(node, "exporter: unknown type: %v", cfg.Exporter)
+	}
+	if cfg.SampleRatio < 0 || cfg.SampleRatio > 1 {
+		return nil, config.NodeErr(node, "sample_ratio should be in [0, 1] range")
+	}
+
+	return cfg, nil
+}
+
+// Setup configures the global tracer provider according to cfg.
+//
+// Returned function flushes buffered spans and stops the exporter.
+func Setup(cfg *Config, version string) (shutdown func(context.Context) error, err error) {
+	var (
+		exp       sdktrace.SpanExporter
+		closeFile = func() error { return nil }
+	)
+	switch cfg.Exporter {
+	case "otlp":
+		exp, err = otlptracehttp.New(context.Background(),
+			otlptracehttp.WithEndpointURL(cfg.Endpoint),
+			otlptracehttp.WithHeaders(cfg.Headers))
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+	case "file":
+		f, err := os.OpenFile(cfg.Endpoint, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o640)
+		if err != nil {
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		exp, err = stdouttrace.New(stdouttrace.WithWriter(f))
+		if err != nil {
+			f.Close()
+			return nil, fmt.Errorf("tracing: %w", err)
+		}
+		closeFile = f.Close
+	default:
+		return nil, fmt.Errorf("tracing: unknown exporter: %v", cfg.Exporter)
+	}
+
+	res, err := resource.Merge(resource.Default(), resource.NewSchemaless(
+		attribute.String("service.name", cfg.ServiceName),
+		attribute.String("service.version", version),
+	))
+	if err != nil {
+		return nil, fmt.Errorf("tracing: %w", err)
+	}
+
+	tp := sdktrace.NewTracerProvider(
+		sdktrace.WithBatcher(exp),
+		sdktrace.WithResource(res),
+		sdktrace.WithSampler(sdktrace.ParentBased(sdktrace.TraceIDRatioBased(cfg.SampleRatio))),
+	)
+	otel.SetTracerProvider(tp)
+	otel.SetTextMapPropagator(propagator)
+
+	return func(ctx context.Context) error {
+		err := tp.Shutdown(ctx)
+		if cerr := closeFile(); err == nil {
+			err = cerr
+		}
+		return err
+	}, nil
+}
@@ -0,0 +1,97 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package tracing provides OpenTelemetry tracing of the message flow.
+//
+// Spans are created using the global tracer provider. Until Setup is called
+// (that is, unless the 'tracing' global directive is used) it is a no-op
+// provider and all functions here are cheap.
+package tracing
+
+import (
+	"context"
+	"strings"
+
+	"go.opentelemetry.io/otel"
+	"go.opentelemetry.io/otel/attribute"
+	"go.opentelemetry.io/otel/codes"
+	"go.opentelemetry.io/otel/propagation"
+	"go.opentelemetry.io/otel/trace"
+)
+
+const instrumentationName = "github.com/mail-chat-chain/mailchatd"
+
+var propagator = propagation.TraceContext{}
+
+func Tracer() trace.Tracer {
+	return otel.Tracer(instrumentationName)
+}
+
+// Start creates the span as a child of the span in ctx, if any.
+func Start(ctx context.Context, name string, attrs ...attribute.KeyValue) (context.Context, trace.Span) {
+	return Tracer().Start(ctx, name, trace.WithAttributes(attrs...))
+}
+
+// Event adds the event to the span in ctx, if any.
+func Event(ctx context.Context, name string, attrs ...attribute.KeyValue) {
+	trace.SpanFromContext(ctx).AddEvent(name, trace.WithAttributes(attrs...))
+}
+
+// RecordError records err in the span and marks it as failed.
+func RecordError(span trace.Span, err error) {
+	if err == nil {
+		return
+	}
+	span.RecordError(err)
+	span.SetStatus(codes.Error, err.Error())
+}
+
+// End sets the span status from err and ends it.
+func End(span trace.Span, err error) {
+	RecordError(span, err)
+	span.End()
+}
+
+// TraceParent returns the W3C traceparent value identifying the span in
+// ctx, or an empty string if there is no recording span.
+func TraceParent(ctx context.Context) string {
+	carrier := propagation.MapCarrier{}
+	propagator.Inject(ctx, carrier)
+	return carrier.Get("traceparent")
+}
+
+// WithParent returns the context with the remote span identified by the W3C
+// traceparent value. Spans started using that context will belong to the
+// same trace.
+//
+// ctx is returned unchanged if traceParent is empty or malformed.
+func WithParent(ctx context.Context, traceParent string) context.Context {
+	if traceParent == "" {
+		return ctx
+	}
+	return propagator.Extract(ctx, propagation.MapCarrier{"traceparent": traceParent})
+}
+
+// TraceID returns the trace ID part of the W3C traceparent value.
+func TraceID(traceParent string) string {
+	parts := strings.Split(traceParent, "-")
+	if len(parts) != 4 {
+		return ""
+	}
+	return parts[1]
+}
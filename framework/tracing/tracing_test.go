@@ -0,0 +1,123 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package tracing
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	parser "github.com/mail-chat-chain/mailchatd/framework/cfgparser"
+)
+
+func parseDirective(t *testing.T, cfg string) (*Config, error) {
+	t.Helper()
+	nodes, err := parser.Read(strings.NewReader(cfg), "literal")
+	if err != nil {
+		t.Fatal(err)
+	}
+	res, err := Directive(nil, nodes[0])
+	if err != nil {
+		return nil, err
+	}
+	return res.(*Config), nil
+}
+
+func TestDirective(t *testing.T) {
+	cfg, err := parseDirective(t, `tracing {
+		exporter otlp https://collector.example.org:4318
+		header Authorization "Bearer token"
+		sample_ratio 0.5
+	}`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if cfg.Exporter != "otlp" || cfg.Endpoint != "https://collector.example.org:4318" ||
+		cfg.SampleRatio != 0.5 || cfg.ServiceName != "mailchatd" ||
+		cfg.Headers["Authorization"] != "Bearer token" {
+		t.Fatalf("unexpected config: %+v", cfg)
+	}
+
+	for _, bad := range []string{
+		`tracing { exporter otlp collector.example.org:4318 }`,
+		`tracing { exporter jaeger http://collector }`,
+		`tracing { exporter file }`,
+		`tracing {
+			exporter file /tmp/traces.json
+			sample_ratio 2
+		}`,
+	} {
+		if _, err := parseDirective(t, bad); err == nil {
+			t.Errorf("no error for %q", bad)
+		}
+	}
+}
+
+func TestFileExporter(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "traces.json")
+	shutdown, err := Setup(&Config{
+		Exporter:    "file",
+		Endpoint:    path,
+		ServiceName: "mailchatd",
+		SampleRatio: 1,
+	}, "test")
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	ctx, span := Start(context.Background(), "smtp.message")
+	traceParent := TraceParent(ctx)
+	if traceParent == "" {
+		t.Fatal("no traceparent for the recording span")
+	}
+	span.End()
+
+	// Continue the trace as the queue does.
+	ctx, span = Start(WithParent(context.Background(), traceParent), "queue.attempt")
+	Event(ctx, "route.destination")
+	if got := TraceID(TraceParent(ctx)); got != TraceID(traceParent) {
+		t.Errorf("trace is not continued: %s != %s", got, TraceID(traceParent))
+	}
+	span.End()
+
+	if err := shutdown(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	blob, err := os.ReadFile(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, s := range []string{"smtp.message", "queue.attempt", "route.destination", TraceID(traceParent)} {
+		if !strings.Contains(string(blob), s) {
+			t.Errorf("exported spans do not contain %q", s)
+		}
+	}
+}
+
+func TestTraceID(t *testing.T) {
+	if id := TraceID("00-4bf92f3577b34da6a3ce929d0e0e4736-00f067aa0ba902b7-01"); id != "4bf92f3577b34da6a3ce929d0e0e4736" {
+		t.Error("wrong trace ID:", id)
+	}
+	if id := TraceID(""); id != "" {
+		t.Error("non-empty trace ID for empty traceparent:", id)
+	}
+}
@@ -0,0 +1,396 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package app_passwords implements the auth.app_passwords module that allows
+// users to log in using per-device passwords restricted to specific protocols.
+//
+// The module wraps another PlainAuth provider (the main account credentials)
+// that is used if no app password matches.
+//
+// Logins using app passwords never unlock the account key of
+// storage.blob.encrypted, messages encrypted to it stay unreadable during
+// such sessions.
+//
+// Interfaces implemented:
+// - module.PlainAuth
+// - module.ScopedPlainAuth
+package app_passwords
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"math/big"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/auth/pass_table"
+	"golang.org/x/crypto/bcrypt"
+	"golang.org/x/text/secure/precis"
+)
+
+const modName = "auth.app_passwords"
+
+// idLen is the length of the password ID. Generated passwords start with the
+// ID so only one hash is checked per login attempt.
+const idLen = 4
+
+// lastUsedInterval limits how often the last use time is written to the
+// table.
+const lastUsedInterval = time.Minute
+
+// Protocols that app passwords can be restricted to.
+var Protocols = []string{module.ProtocolIMAP, module.ProtocolSMTP}
+
+var ErrNoSuchPassword = errors.New("app_passwords: no such app password")
+
+// AppPassword is the app password record. Records for an account are stored
+// in the table as a JSON array.
+type AppPassword struct {
+	// ID is also the first group of the generated password.
+	ID    string `json:"id"`
+	Label string `json:"label"`
+	Hash  string `json:"hash"`
+
+	// Protocols the password can be used for. Empty list means any protocol.
+	Protocols []string `json:"protocols,omitempty"`
+
+	Created  time.Time `json:"created"`
+	LastUsed time.Time `json:"last_used,omitzero"`
+	// Expires is the zero value if the password does not expire.
+	Expires time.Time `json:"expires,omitzero"`
+}
+
+// Expired reports whether the password cannot be used at the specified
+// moment.
+func (p AppPassword) Expired(now time.Time) bool {
+	return !p.Expires.IsZero() && !now.Before(p.Expires)
+}
+
+// Allows reports whether the password can be used for the protocol.
+func (p AppPassword) Allows(protocol string) bool {
+	return len(p.Protocols) == 0 || slices.Contains(p.Protocols, protocol)
+}
+
+type Auth struct {
+	instName string
+	log      log.Logger
+
+	table    module.MutableTable
+	fallback module.PlainAuth
+	hash     string
+	hashOpts pass_table.HashOpts
+
+	// lck serializes read-modify-write updates of account records.
+	lck sync.Mutex
+	now func() time.Time
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	if len(inlineArgs) != 0 {
+		return nil, fmt.Errorf("%s: inline arguments are not used", modName)
+	}
+	return &Auth{
+		instName: instName,
+		log:      log.Logger{Name: modName},
+		now:      time.Now,
+	}, nil
+}
+
+func (a *Auth) Init(cfg *config.Map) error {
+	cfg.Bool("debug", true, false, &a.log.Debug)
+	cfg.Custom("table", false, true, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var tbl module.MutableTable
+		err := modconfig.ModuleFromNode("table", node.Args, node, m.Globals, &tbl)
+		return tbl, err
+	}, &a.table)
+	cfg.Custom("auth", false, false, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var auth module.PlainAuth
+		err := modconfig.ModuleFromNode("auth", node.Args, node, m.Globals, &auth)
+		return auth, err
+	}, &a.fallback)
+	cfg.Enum("hash", false, false, pass_table.Hashes, pass_table.DefaultHash, &a.hash)
+	cfg.Int("bcrypt_cost", false, false, bcrypt.DefaultCost, &a.hashOpts.BcryptCost)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+	if _, ok := pass_table.HashCompute[a.hash]; !ok {
+		return fmt.Errorf("%s: unknown hash function: %v", modName, a.hash)
+	}
+	return nil
+}
+
+func (a *Auth) Name() string {
+	return modName
+}
+
+func (a *Auth) InstanceName() string {
+	return a.instName
+}
+
+func accountKey(username string) (string, error) {
+	return precis.UsernameCaseMapped.CompareKey(username)
+}
+
+// normalizePassword removes spaces that are used to group characters of
+// generated passwords for readability.
+func normalizePassword(password string) string {
+	return strings.ReplaceAll(password, " ", "")
+}
+
+func (a *Auth) AuthPlain(username, password string) error {
+	return a.AuthPlainScoped("", username, password)
+}
+
+func (a *Auth) AuthPlainScoped(protocol, username, password string) error {
+	key, err := accountKey(username)
+	if err != nil {
+		return err
+	}
+
+	pwds, err := a.list(context.TODO(), key)
+	if err != nil {
+		return err
+	}
+	now := a.now()
+	normalized := normalizePassword(password)
+	i := -1
+	if len(normalized) > idLen {
+		id := normalized[:idLen]
+		i = slices.IndexFunc(pwds, func(p AppPassword) bool { return p.ID == id })
+	}
+	if i != -1 {
+		p := pwds[i]
+		if !p.Expired(now) && p.Allows(protocol) && verifyHash(p.Hash, normalized) == nil {
+			a.log.DebugMsg("app password accepted", "username", key, "id", p.ID, "label", p.Label, "protocol", protocol)
+			if now.Sub(p.LastUsed) >= lastUsedInterval {
+				if err := a.touch(key, p.ID, now); err != nil {
+					a.log.Error("failed to update last use time", err, "username", key, "id", p.ID)
+				}
+			}
+			return nil
+		}
+	}
+
+	switch fallback := a.fallback.(type) {
+	case nil:
+		return module.ErrUnknownCredentials
+	case module.ScopedPlainAuth:
+		return fallback.AuthPlainScoped(protocol, username, password)
+	default:
+		return fallback.AuthPlain(username, password)
+	}
+}
+
+func verifyHash(hash, password string) error {
+	algo, hash, ok := strings.Cut(hash, ":")
+	if !ok {
+		return errors.New("no hash tag")
+	}
+	verify := pass_table.HashVerify[algo]
+	if verify == nil {
+		return fmt.Errorf("unknown hash: %s", algo)
+	}
+	return verify(password, hash)
+}
+
+func (a *Auth) list(ctx context.Context, key string) ([]AppPassword, error) {
+	val, ok, err := a.table.Lookup(ctx, key)
+	if err != nil {
+		return nil, fmt.Errorf("%s: lookup %s: %w", modName, key, err)
+	}
+	if !ok {
+		return nil, nil
+	}
+
+	var pwds []AppPassword
+	if err := json.Unmarshal([]byte(val), &pwds); err != nil {
+		return nil, fmt.Errorf("%s: malformed record for %s: %w", modName, key, err)
+	}
+	return pwds, nil
+}
+
+func (a *Auth) store(key string, pwds []AppPassword) error {
+	if len(pwds) == 0 {
+		if err := a.table.RemoveKey(key); err != nil {
+			return fmt.Errorf("%s: remove %s: %w", modName, key, err)
+		}
+		return nil
+	}
+
+	blob, err := json.Marshal(pwds)
+	if err != nil {
+		return err
+	}
+	if err := a.table.SetKey(key, string(blob)); err != nil {
+		return fmt.Errorf("%s: set %s: %w", modName, key, err)
+	}
+	return nil
+}
+
+func (a *Auth) touch(key, id string, now time.Time) error {
+	a.lck.Lock()
+	defer a.lck.Unlock()
+
+	pwds, err := a.list(context.TODO(), key)
+	if err != nil {
+		return err
+	}
+	i := slices.IndexFunc(pwds, func(p AppPassword) bool { return p.ID == id })
+	if i == -1 {
+		// Revoked concurrently.
+		return nil
+	}
+	pwds[i].LastUsed = now
+	return a.store(key, pwds)
+}
+
+// List returns app passwords of the account. Hashes are not cleared.
+func (a *Auth) List(username string) ([]AppPassword, error) {
+	key, err := accountKey(username)
+	if err != nil {
+		return nil, err
+	}
+	return a.list(context.TODO(), key)
+}
+
+// Add creates the app password for the account. The generated password is
+// returned and cannot be retrieved later.
+//
+// expires is the zero value if the password should not expire.
+func (a *Auth) Add(username, label string, protocols []string, expires time.Time) (string, AppPassword, error) {
+	key, err := accountKey(username)
+	if err != nil {
+		return "", AppPassword{}, err
+	}
+	if strings.TrimSpace(label) == "" {
+		return "", AppPassword{}, errors.New("app_passwords: label is required")
+	}
+	for _, proto := range protocols {
+		if !slices.Contains(Protocols, proto) {
+			return "", AppPassword{}, fmt.Errorf("app_passwords: unknown protocol: %s", proto)
+		}
+	}
+	now := a.now()
+	if !expires.IsZero() && !expires.After(now) {
+		return "", AppPassword{}, errors.New("app_passwords: expiry time is in the past")
+	}
+
+	a.lck.Lock()
+	defer a.lck.Unlock()
+
+	pwds, err := a.list(context.TODO(), key)
+	if err != nil {
+		return "", AppPassword{}, err
+	}
+
+	var id string
+	for {
+		id, err = randomLetters(idLen)
+		if err != nil {
+			return "", AppPassword{}, err
+		}
+		if !slices.ContainsFunc(pwds, func(p AppPassword) bool { return p.ID == id }) {
+			break
+		}
+	}
+	password, err := generatePassword(id)
+	if err != nil {
+		return "", AppPassword{}, err
+	}
+	hash, err := pass_table.HashCompute[a.hash](a.hashOpts, normalizePassword(password))
+	if err != nil {
+		return "", AppPassword{}, fmt.Errorf("%s: hash: %w", modName, err)
+	}
+
+	p := AppPassword{
+		ID:        id,
+		Label:     label,
+		Hash:      a.hash + ":" + hash,
+		Protocols: protocols,
+		Created:   now,
+		Expires:   expires,
+	}
+	if err := a.store(key, append(pwds, p)); err != nil {
+		return "", AppPassword{}, err
+	}
+	return password, p, nil
+}
+
+// Revoke removes app passwords with the specified ID or label.
+func (a *Auth) Revoke(username, id string) error {
+	key, err := accountKey(username)
+	if err != nil {
+		return err
+	}
+
+	a.lck.Lock()
+	defer a.lck.Unlock()
+
+	pwds, err := a.list(context.TODO(), key)
+	if err != nil {
+		return err
+	}
+	remaining := slices.DeleteFunc(slices.Clone(pwds), func(p AppPassword) bool {
+		return p.ID == id || p.Label == id
+	})
+	if len(remaining) == len(pwds) {
+		return ErrNoSuchPassword
+	}
+	return a.store(key, remaining)
+}
+
+// generatePassword returns the password ID followed by 16 random lowercase
+// letters (~75 bits of entropy), split into groups of four.
+func generatePassword(id string) (string, error) {
+	secret, err := randomLetters(16)
+	if err != nil {
+		return "", err
+	}
+	var sb strings.Builder
+	sb.WriteString(id)
+	for i := 0; i < len(secret); i += 4 {
+		sb.WriteByte(' ')
+		sb.WriteString(secret[i : i+4])
+	}
+	return sb.String(), nil
+}
+
+func randomLetters(n int) (string, error) {
+	b := make([]byte, n)
+	for i := range b {
+		c, err := rand.Int(rand.Reader, big.NewInt(26))
+		if err != nil {
+			return "", err
+		}
+		b[i] = byte('a' + c.Int64())
+	}
+	return string(b), nil
+}
+
+func init() {
+	module.Register(modName, New)
+}
@@ -0,0 +1,151 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package app_passwords
+
+import (
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/auth/pass_table"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+	"golang.org/x/crypto/bcrypt"
+)
+
+type mainAuth struct {
+	protocols []string
+}
+
+func (m *mainAuth) AuthPlain(username, password string) error {
+	return m.AuthPlainScoped("", username, password)
+}
+
+func (m *mainAuth) AuthPlainScoped(protocol, username, password string) error {
+	m.protocols = append(m.protocols, protocol)
+	if username == "user@example.org" && password == "main" {
+		return nil
+	}
+	return module.ErrUnknownCredentials
+}
+
+func testAuth(t *testing.T) (*Auth, *mainAuth, *testutils.MutableTable) {
+	mod, err := New(modName, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := mod.(*Auth)
+	if err := a.Init(config.NewMap(nil, config.Node{})); err == nil {
+		t.Fatal("Expected error for missing table")
+	}
+
+	tbl := &testutils.MutableTable{M: map[string]string{}}
+	main := &mainAuth{}
+	a.log = testutils.Logger(t, modName)
+	a.table = tbl
+	a.fallback = main
+	a.hash = pass_table.HashBcrypt
+	a.hashOpts.BcryptCost = bcrypt.MinCost
+	return a, main, tbl
+}
+
+func TestAuthPlainScoped(t *testing.T) {
+	a, main, tbl := testAuth(t)
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	a.now = func() time.Time { return now }
+
+	imapPass, p, err := a.Add("User@example.org", "Phone", []string{module.ProtocolIMAP}, time.Time{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(imapPass) != 24 || strings.Count(imapPass, " ") != 4 || !strings.HasPrefix(imapPass, p.ID+" ") {
+		t.Fatalf("Wrong password format: %q", imapPass)
+	}
+	anyPass, _, err := a.Add("user@example.org", "Laptop", nil, now.Add(time.Hour))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, _, err := a.Add("user@example.org", "Other", []string{"pop3"}, time.Time{}); err == nil {
+		t.Fatal("Expected error for unknown protocol")
+	}
+	if strings.Contains(tbl.M["user@example.org"], imapPass) {
+		t.Fatal("Password stored in plaintext")
+	}
+
+	for _, tc := range []struct {
+		protocol string
+		password string
+		ok       bool
+	}{
+		{module.ProtocolIMAP, imapPass, true},
+		{module.ProtocolIMAP, strings.ReplaceAll(imapPass, " ", ""), true},
+		{module.ProtocolSMTP, imapPass, false},
+		{module.ProtocolSMTP, anyPass, true},
+		{module.ProtocolSMTP, "main", true},
+		{module.ProtocolSMTP, "wrong", false},
+		// The ID selects the record, a valid secret under another ID is
+		// rejected.
+		{module.ProtocolIMAP, anyPass[:idLen] + imapPass[idLen:], false},
+	} {
+		err := a.AuthPlainScoped(tc.protocol, "user@example.org", tc.password)
+		if tc.ok && err != nil {
+			t.Errorf("%s %q: unexpected error: %v", tc.protocol, tc.password, err)
+		}
+		if !tc.ok && err == nil {
+			t.Errorf("%s %q: expected error", tc.protocol, tc.password)
+		}
+	}
+	if strings.Join(main.protocols, ",") != "smtp,smtp,smtp,imap" {
+		t.Errorf("Wrong protocols passed to wrapped module: %v", main.protocols)
+	}
+
+	pwds, err := a.List("user@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(pwds) != 2 || pwds[0].ID != p.ID || !pwds[0].LastUsed.Equal(now) || !pwds[0].Created.Equal(now) {
+		t.Fatalf("Wrong records: %+v", pwds)
+	}
+
+	// Expired passwords are rejected.
+	now = now.Add(2 * time.Hour)
+	if err := a.AuthPlain("user@example.org", anyPass); err == nil {
+		t.Error("Expired password accepted")
+	}
+
+	if err := a.Revoke("user@example.org", p.ID); err != nil {
+		t.Fatal(err)
+	}
+	if err := a.Revoke("user@example.org", p.ID); !errors.Is(err, ErrNoSuchPassword) {
+		t.Fatal("Expected ErrNoSuchPassword, got", err)
+	}
+	if err := a.AuthPlainScoped(module.ProtocolIMAP, "user@example.org", imapPass); err == nil {
+		t.Error("Revoked password accepted")
+	}
+
+	if err := a.Revoke("user@example.org", "Laptop"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := tbl.M["user@example.org"]; ok {
+		t.Error("Record is not removed after revoking all passwords")
+	}
+}
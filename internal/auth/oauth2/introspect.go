@@ -0,0 +1,163 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package oauth2
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+)
+
+// maxCacheSize is the amount of cached introspection results after which
+// expired entries are pruned.
+const maxCacheSize = 1024
+
+func (a *Auth) introspect(ctx context.Context, token string) (map[string]interface{}, error) {
+	key := sha256.Sum256([]byte(token))
+	if claims := a.cache.get(key); claims != nil {
+		return claims, nil
+	}
+
+	form := url.Values{
+		"token":           {token},
+		"token_type_hint": {"access_token"},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, a.introspectURL, strings.NewReader(form.Encode()))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	if a.clientID != "" {
+		req.SetBasicAuth(url.QueryEscape(a.clientID), url.QueryEscape(a.clientSecret))
+	}
+
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("introspection: %w", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("introspection: %s", resp.Status)
+	}
+
+	var claims map[string]interface{}
+	if err := json.NewDecoder(resp.Body).Decode(&claims); err != nil {
+		return nil, fmt.Errorf("introspection: %w", err)
+	}
+	if active, _ := claims["active"].(bool); !active {
+		return nil, errors.New("token is not active")
+	}
+
+	if a.issuer != "" {
+		if iss, _ := claims["iss"].(string); iss != a.issuer {
+			return nil, fmt.Errorf("token issuer mismatch: %s", iss)
+		}
+	}
+	if len(a.audience) != 0 {
+		aud := claimStrings(claims["aud"])
+		if !slices.ContainsFunc(a.audience, func(s string) bool { return slices.Contains(aud, s) }) {
+			return nil, errors.New("token audience mismatch")
+		}
+	}
+
+	var expiry time.Time
+	if exp, ok := claims["exp"].(float64); ok {
+		expiry = time.Unix(int64(exp), 0)
+		if time.Now().After(expiry.Add(a.leeway)) {
+			return nil, errors.New("token is expired")
+		}
+	}
+
+	a.cache.put(key, claims, expiry)
+	return claims, nil
+}
+
+// tokenCache keeps results of successful introspection requests for a short
+// time since clients tend to open many connections using the same token.
+type tokenCache struct {
+	ttl time.Duration
+
+	lck     sync.Mutex
+	entries map[[sha256.Size]byte]cacheEntry
+}
+
+type cacheEntry struct {
+	claims  map[string]interface{}
+	expires time.Time
+}
+
+func newTokenCache(ttl time.Duration) *tokenCache {
+	return &tokenCache{
+		ttl:     ttl,
+		entries: make(map[[sha256.Size]byte]cacheEntry),
+	}
+}
+
+func (c *tokenCache) get(key [sha256.Size]byte) map[string]interface{} {
+	if c == nil {
+		return nil
+	}
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	e, ok := c.entries[key]
+	if !ok {
+		return nil
+	}
+	if time.Now().After(e.expires) {
+		delete(c.entries, key)
+		return nil
+	}
+	return e.claims
+}
+
+func (c *tokenCache) put(key [sha256.Size]byte, claims map[string]interface{}, tokenExpiry time.Time) {
+	if c == nil {
+		return
+	}
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	now := time.Now()
+	expires := now.Add(c.ttl)
+	if !tokenExpiry.IsZero() && tokenExpiry.Before(expires) {
+		expires = tokenExpiry
+	}
+
+	if len(c.entries) >= maxCacheSize {
+		for k, e := range c.entries {
+			if now.After(e.expires) {
+				delete(c.entries, k)
+			}
+		}
+		if len(c.entries) >= maxCacheSize {
+			return
+		}
+	}
+	c.entries[key] = cacheEntry{claims: claims, expires: expires}
+}
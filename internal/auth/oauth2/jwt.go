@@ -0,0 +1,192 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package oauth2
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+)
+
+// minRefetchInterval limits how often the key set is fetched when tokens
+// signed with unknown keys are presented.
+const minRefetchInterval = time.Minute
+
+// keySet is the cached provider JWKS.
+type keySet struct {
+	a       *Auth
+	url     string
+	refresh time.Duration
+
+	lck         sync.Mutex
+	set         jose.JSONWebKeySet
+	fetched     time.Time
+	lastAttempt time.Time
+}
+
+func newKeySet(a *Auth, url string, refresh time.Duration) *keySet {
+	return &keySet{a: a, url: url, refresh: refresh}
+}
+
+func (ks *keySet) lookup(kid string) []jose.JSONWebKey {
+	if kid == "" {
+		return ks.set.Keys
+	}
+	return ks.set.Key(kid)
+}
+
+// keys returns the keys matching the key ID, fetching the key set if it is
+// stale or the key is unknown (the provider may have rotated keys).
+func (ks *keySet) keys(ctx context.Context, kid string) ([]jose.JSONWebKey, error) {
+	ks.lck.Lock()
+	defer ks.lck.Unlock()
+
+	keys := ks.lookup(kid)
+	if len(keys) != 0 && time.Since(ks.fetched) < ks.refresh {
+		return keys, nil
+	}
+	if time.Since(ks.lastAttempt) < minRefetchInterval {
+		if len(keys) == 0 {
+			return nil, fmt.Errorf("unknown signing key %q", kid)
+		}
+		return keys, nil
+	}
+
+	ks.lastAttempt = time.Now()
+	if err := ks.fetch(ctx); err != nil {
+		if len(keys) != 0 {
+			ks.a.log.Error("failed to refresh key set, using cached keys", err)
+			return keys, nil
+		}
+		return nil, err
+	}
+
+	keys = ks.lookup(kid)
+	if len(keys) == 0 {
+		return nil, fmt.Errorf("unknown signing key %q", kid)
+	}
+	return keys, nil
+}
+
+func (ks *keySet) fetch(ctx context.Context) error {
+	if ks.url == "" {
+		url, err := ks.a.discoverJWKS(ctx)
+		if err != nil {
+			return err
+		}
+		ks.url = url
+	}
+
+	var set jose.JSONWebKeySet
+	if err := ks.a.getJSON(ctx, ks.url, &set); err != nil {
+		return fmt.Errorf("key set: %w", err)
+	}
+	ks.set = set
+	ks.fetched = time.Now()
+	ks.a.log.DebugMsg("key set fetched", "url", ks.url, "keys", len(set.Keys))
+	return nil
+}
+
+// discoverJWKS reads the key set URL from the OpenID Connect provider
+// metadata.
+func (a *Auth) discoverJWKS(ctx context.Context) (string, error) {
+	var meta struct {
+		Issuer  string `json:"issuer"`
+		JWKSURI string `json:"jwks_uri"`
+	}
+	url := strings.TrimSuffix(a.issuer, "/") + "/.well-known/openid-configuration"
+	if err := a.getJSON(ctx, url, &meta); err != nil {
+		return "", fmt.Errorf("discovery: %w", err)
+	}
+	if meta.Issuer != a.issuer {
+		return "", fmt.Errorf("discovery: issuer mismatch: %s", meta.Issuer)
+	}
+	if meta.JWKSURI == "" {
+		return "", errors.New("discovery: provider has no jwks_uri")
+	}
+	return meta.JWKSURI, nil
+}
+
+func (a *Auth) getJSON(ctx context.Context, url string, out interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, url, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := a.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("GET %s: %s", url, resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
+
+func (a *Auth) verifyJWT(ctx context.Context, token string) (map[string]interface{}, error) {
+	tok, err := jwt.ParseSigned(token, a.algorithms)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := a.keys.keys(ctx, tok.Headers[0].KeyID)
+	if err != nil {
+		return nil, err
+	}
+
+	var (
+		std    jwt.Claims
+		claims map[string]interface{}
+	)
+	for _, key := range keys {
+		if err = tok.Claims(key.Key, &std, &claims); err == nil {
+			break
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	if std.Expiry == nil {
+		return nil, errors.New("token has no expiration time")
+	}
+	if std.Issuer == "" {
+		return nil, errors.New("token has no issuer")
+	}
+	if len(std.Audience) == 0 {
+		return nil, errors.New("token has no audience")
+	}
+	err = std.ValidateWithLeeway(jwt.Expected{
+		Issuer:      a.issuer,
+		AnyAudience: a.audience,
+		Time:        time.Now(),
+	}, a.leeway)
+	if err != nil {
+		return nil, err
+	}
+	return claims, nil
+}
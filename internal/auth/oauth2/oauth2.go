@@ -0,0 +1,256 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package oauth2 implements the auth.oauth2 module that authenticates users
+// using OAuth 2.0 access tokens issued by an OpenID Connect provider.
+//
+// JWT access tokens are validated locally using the provider key set (JWKS).
+// Opaque tokens are validated using the token introspection endpoint (RFC 7662).
+package oauth2
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	tls2 "github.com/mail-chat-chain/mailchatd/framework/config/tls"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+const modName = "auth.oauth2"
+
+var defaultAlgorithms = []string{"RS256", "RS384", "RS512", "PS256", "PS384", "PS512", "ES256", "ES384", "ES512", "EdDSA"}
+
+type Auth struct {
+	instName string
+	log      log.Logger
+
+	issuer         string
+	audience       []string
+	algorithms     []jose.SignatureAlgorithm
+	leeway         time.Duration
+	requiredScopes []string
+	usernameClaim  string
+	usernameMap    module.Table
+
+	// keys is nil if local JWT validation is disabled.
+	keys *keySet
+
+	introspectURL string
+	clientID      string
+	clientSecret  string
+	cache         *tokenCache
+
+	http *http.Client
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	if len(inlineArgs) != 0 {
+		return nil, fmt.Errorf("%s: inline arguments are not used", modName)
+	}
+	return &Auth{
+		instName: instName,
+		log:      log.Logger{Name: modName},
+	}, nil
+}
+
+func (a *Auth) Name() string {
+	return modName
+}
+
+func (a *Auth) InstanceName() string {
+	return a.instName
+}
+
+func (a *Auth) Init(cfg *config.Map) error {
+	var (
+		jwksURL        string
+		jwksRefresh    time.Duration
+		algorithms     []string
+		cacheTTL       time.Duration
+		requestTimeout time.Duration
+		tlsCfg         tls.Config
+	)
+	cfg.Bool("debug", true, false, &a.log.Debug)
+	cfg.String("issuer", false, false, "", &a.issuer)
+	cfg.StringList("audience", false, false, nil, &a.audience)
+	cfg.String("jwks_url", false, false, "", &jwksURL)
+	cfg.Duration("jwks_refresh", false, false, time.Hour, &jwksRefresh)
+	cfg.StringList("algorithms", false, false, defaultAlgorithms, &algorithms)
+	cfg.Duration("clock_skew", false, false, time.Minute, &a.leeway)
+	cfg.StringList("required_scope", false, false, nil, &a.requiredScopes)
+	cfg.String("username_claim", false, false, "email", &a.usernameClaim)
+	modconfig.Table(cfg, "username_map", false, false, nil, &a.usernameMap)
+	cfg.String("introspection_url", false, false, "", &a.introspectURL)
+	cfg.String("client_id", false, false, "", &a.clientID)
+	cfg.String("client_secret", false, false, "", &a.clientSecret)
+	cfg.Duration("introspection_cache", false, false, time.Minute, &cacheTTL)
+	cfg.Duration("request_timeout", false, false, 10*time.Second, &requestTimeout)
+	cfg.Custom("tls_client", true, false, func() (interface{}, error) {
+		return tls.Config{}, nil
+	}, tls2.TLSClientBlock, &tlsCfg)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	for _, alg := range algorithms {
+		a.algorithms = append(a.algorithms, jose.SignatureAlgorithm(alg))
+	}
+
+	transport := http.DefaultTransport.(*http.Transport).Clone()
+	transport.TLSClientConfig = &tlsCfg
+	a.http = &http.Client{Transport: transport, Timeout: requestTimeout}
+
+	// The key set is discovered using the issuer metadata unless specified
+	// explicitly or only introspection is configured.
+	switch {
+	case jwksURL != "":
+		a.keys = newKeySet(a, jwksURL, jwksRefresh)
+	case a.introspectURL == "":
+		if a.issuer == "" {
+			return fmt.Errorf("%s: issuer, jwks_url or introspection_url should be set", modName)
+		}
+		a.keys = newKeySet(a, "", jwksRefresh)
+	}
+	// Without the issuer and audience checks a token issued by the provider
+	// to any other client would be accepted.
+	if a.keys != nil {
+		if a.issuer == "" {
+			return fmt.Errorf("%s: issuer is required for JWT validation", modName)
+		}
+		if len(a.audience) == 0 {
+			return fmt.Errorf("%s: audience is required for JWT validation", modName)
+		}
+	}
+	if a.introspectURL != "" && cacheTTL > 0 {
+		a.cache = newTokenCache(cacheTTL)
+	}
+
+	return nil
+}
+
+// AuthBearer validates the access token and returns the username derived
+// from its claims.
+func (a *Auth) AuthBearer(ctx context.Context, token string) (string, error) {
+	var (
+		claims map[string]interface{}
+		err    error
+	)
+	switch {
+	case a.keys != nil && strings.Count(token, ".") == 2:
+		claims, err = a.verifyJWT(ctx, token)
+	case a.introspectURL != "":
+		claims, err = a.introspect(ctx, token)
+	default:
+		return "", module.ErrUnknownCredentials
+	}
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", modName, err)
+	}
+
+	if err := a.checkScopes(claims); err != nil {
+		return "", fmt.Errorf("%s: %w", modName, err)
+	}
+
+	username, err := a.username(ctx, claims)
+	if err != nil {
+		return "", fmt.Errorf("%s: %w", modName, err)
+	}
+	a.log.DebugMsg("token accepted", "username", username)
+	return username, nil
+}
+
+func (a *Auth) checkScopes(claims map[string]interface{}) error {
+	if len(a.requiredScopes) == 0 {
+		return nil
+	}
+
+	granted := map[string]bool{}
+	if scope, ok := claims["scope"].(string); ok {
+		for _, s := range strings.Fields(scope) {
+			granted[s] = true
+		}
+	}
+	// Some providers (e.g. Azure AD, Okta) use the scp claim instead.
+	for _, s := range claimStrings(claims["scp"]) {
+		for _, s := range strings.Fields(s) {
+			granted[s] = true
+		}
+	}
+
+	for _, s := range a.requiredScopes {
+		if !granted[s] {
+			return fmt.Errorf("token does not grant the %s scope", s)
+		}
+	}
+	return nil
+}
+
+func (a *Auth) username(ctx context.Context, claims map[string]interface{}) (string, error) {
+	value, _ := claims[a.usernameClaim].(string)
+	if value == "" {
+		return "", fmt.Errorf("token has no %s claim", a.usernameClaim)
+	}
+	if a.usernameClaim == "email" {
+		if verified, ok := claims["email_verified"].(bool); ok && !verified {
+			return "", errors.New("token email is not verified")
+		}
+	}
+
+	if a.usernameMap == nil {
+		return value, nil
+	}
+	username, ok, err := a.usernameMap.Lookup(ctx, value)
+	if err != nil {
+		return "", err
+	}
+	if !ok {
+		return "", fmt.Errorf("no username mapping for %s", value)
+	}
+	return username, nil
+}
+
+// claimStrings returns the claim value that can be either a string or an
+// array of strings (such as aud).
+func claimStrings(v interface{}) []string {
+	switch v := v.(type) {
+	case string:
+		return []string{v}
+	case []interface{}:
+		res := make([]string, 0, len(v))
+		for _, s := range v {
+			if s, ok := s.(string); ok {
+				res = append(res, s)
+			}
+		}
+		return res
+	}
+	return nil
+}
+
+func init() {
+	module.Register(modName, New)
+}
@@ -0,0 +1,272 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package oauth2
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/go-jose/go-jose/v4"
+	"github.com/go-jose/go-jose/v4/jwt"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+type provider struct {
+	*httptest.Server
+
+	lck         sync.Mutex
+	keys        map[string]*ecdsa.PrivateKey
+	jwksFetches int
+	active      map[string]map[string]interface{}
+}
+
+func newProvider(t *testing.T) *provider {
+	p := &provider{
+		keys:   map[string]*ecdsa.PrivateKey{},
+		active: map[string]map[string]interface{}{},
+	}
+	p.addKey(t, "key1")
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("GET /.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]string{
+			"issuer":   p.URL,
+			"jwks_uri": p.URL + "/jwks",
+		})
+	})
+	mux.HandleFunc("GET /jwks", func(w http.ResponseWriter, r *http.Request) {
+		p.lck.Lock()
+		defer p.lck.Unlock()
+		p.jwksFetches++
+		var set jose.JSONWebKeySet
+		for kid, key := range p.keys {
+			set.Keys = append(set.Keys, jose.JSONWebKey{Key: key.Public(), KeyID: kid, Algorithm: "ES256", Use: "sig"})
+		}
+		json.NewEncoder(w).Encode(set)
+	})
+	mux.HandleFunc("POST /introspect", func(w http.ResponseWriter, r *http.Request) {
+		if id, secret, _ := r.BasicAuth(); id != "mail" || secret != "s3cr3t" {
+			w.WriteHeader(http.StatusUnauthorized)
+			return
+		}
+		p.lck.Lock()
+		defer p.lck.Unlock()
+		claims, ok := p.active[r.PostFormValue("token")]
+		if !ok {
+			claims = map[string]interface{}{"active": false}
+		}
+		json.NewEncoder(w).Encode(claims)
+	})
+	p.Server = httptest.NewServer(mux)
+	t.Cleanup(p.Close)
+	return p
+}
+
+func (p *provider) addKey(t *testing.T, kid string) {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.lck.Lock()
+	p.keys[kid] = key
+	p.lck.Unlock()
+}
+
+func (p *provider) sign(t *testing.T, kid string, claims map[string]interface{}) string {
+	p.lck.Lock()
+	key := p.keys[kid]
+	p.lck.Unlock()
+
+	signer, err := jose.NewSigner(jose.SigningKey{
+		Algorithm: jose.ES256,
+		Key:       jose.JSONWebKey{Key: key, KeyID: kid},
+	}, (&jose.SignerOptions{}).WithType("at+jwt"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	tok, err := jwt.Signed(signer).Claims(claims).Serialize()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tok
+}
+
+func (p *provider) claims(extra map[string]interface{}) map[string]interface{} {
+	claims := map[string]interface{}{
+		"iss":   p.URL,
+		"aud":   []string{"mail"},
+		"sub":   "1234",
+		"email": "foo@example.org",
+		"scope": "openid email mail",
+		"exp":   time.Now().Add(time.Hour).Unix(),
+	}
+	for k, v := range extra {
+		if v == nil {
+			delete(claims, k)
+			continue
+		}
+		claims[k] = v
+	}
+	return claims
+}
+
+func initAuth(t *testing.T, children ...config.Node) *Auth {
+	mod, err := New(modName, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := mod.(*Auth)
+	a.log = testutils.Logger(t, modName)
+	if err := a.Init(config.NewMap(nil, config.Node{Children: children})); err != nil {
+		t.Fatal(err)
+	}
+	return a
+}
+
+func TestAuthBearer_JWT(t *testing.T) {
+	p := newProvider(t)
+	a := initAuth(t,
+		config.Node{Name: "issuer", Args: []string{p.URL}},
+		config.Node{Name: "audience", Args: []string{"mail"}},
+		config.Node{Name: "required_scope", Args: []string{"mail"}},
+	)
+
+	for _, tc := range []struct {
+		name   string
+		kid    string
+		claims map[string]interface{}
+		ok     bool
+	}{
+		{"valid", "key1", nil, true},
+		{"wrong issuer", "key1", map[string]interface{}{"iss": "https://evil.example.org"}, false},
+		{"wrong audience", "key1", map[string]interface{}{"aud": "other"}, false},
+		{"no issuer", "key1", map[string]interface{}{"iss": nil}, false},
+		{"no audience", "key1", map[string]interface{}{"aud": nil}, false},
+		{"expired", "key1", map[string]interface{}{"exp": time.Now().Add(-time.Hour).Unix()}, false},
+		{"no expiry", "key1", map[string]interface{}{"exp": nil}, false},
+		{"missing scope", "key1", map[string]interface{}{"scope": "openid email"}, false},
+		{"scp claim", "key1", map[string]interface{}{"scope": nil, "scp": []string{"mail"}}, true},
+		{"no username", "key1", map[string]interface{}{"email": nil}, false},
+		{"unverified email", "key1", map[string]interface{}{"email_verified": false}, false},
+	} {
+		t.Run(tc.name, func(t *testing.T) {
+			username, err := a.AuthBearer(context.Background(), p.sign(t, tc.kid, p.claims(tc.claims)))
+			if tc.ok {
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				if username != "foo@example.org" {
+					t.Fatal("Wrong username:", username)
+				}
+			} else if err == nil {
+				t.Fatal("Expected error, got none")
+			}
+		})
+	}
+
+	// Tampered token.
+	tok := p.sign(t, "key1", p.claims(nil))
+	parts := strings.Split(tok, ".")
+	payload, _ := json.Marshal(p.claims(map[string]interface{}{"email": "admin@example.org"}))
+	parts[1] = base64.RawURLEncoding.EncodeToString(payload)
+	if _, err := a.AuthBearer(context.Background(), strings.Join(parts, ".")); err == nil {
+		t.Fatal("Tampered token accepted")
+	}
+
+	// Key rotation is picked up without waiting for the refresh interval.
+	p.addKey(t, "key2")
+	a.keys.lastAttempt = time.Time{}
+	if _, err := a.AuthBearer(context.Background(), p.sign(t, "key2", p.claims(nil))); err != nil {
+		t.Fatal("Token signed with rotated key rejected:", err)
+	}
+
+	// But fetches triggered by unknown keys are rate-limited.
+	p.addKey(t, "key3")
+	if _, err := a.AuthBearer(context.Background(), p.sign(t, "key3", p.claims(nil))); err == nil {
+		t.Fatal("Expected error, got none")
+	}
+	if p.jwksFetches != 2 {
+		t.Fatal("Wrong amount of key set fetches:", p.jwksFetches)
+	}
+}
+
+func TestInit_JWTRequiresIssuerAndAudience(t *testing.T) {
+	for _, children := range [][]config.Node{
+		{{Name: "issuer", Args: []string{"https://idp.example.org"}}},
+		{{Name: "jwks_url", Args: []string{"https://idp.example.org/jwks"}}, {Name: "audience", Args: []string{"mail"}}},
+	} {
+		mod, err := New(modName, "", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if err := mod.Init(config.NewMap(nil, config.Node{Children: children})); err == nil {
+			t.Errorf("Expected error for %v", children)
+		}
+	}
+}
+
+func TestAuthBearer_Introspection(t *testing.T) {
+	p := newProvider(t)
+	a := initAuth(t,
+		config.Node{Name: "introspection_url", Args: []string{p.URL + "/introspect"}},
+		config.Node{Name: "client_id", Args: []string{"mail"}},
+		config.Node{Name: "client_secret", Args: []string{"s3cr3t"}},
+		config.Node{Name: "username_claim", Args: []string{"username"}},
+		config.Node{Name: "audience", Args: []string{"mail"}},
+	)
+	a.usernameMap = testutils.Table{M: map[string]string{"foo": "foo@example.org"}}
+	if a.keys != nil {
+		t.Fatal("JWT validation is enabled with only introspection configured")
+	}
+
+	p.active["opaque-foo"] = map[string]interface{}{"active": true, "username": "foo", "aud": "mail"}
+	p.active["opaque-bar"] = map[string]interface{}{"active": true, "username": "bar", "aud": "mail"}
+	p.active["opaque-other"] = map[string]interface{}{"active": true, "username": "foo", "aud": "other"}
+
+	username, err := a.AuthBearer(context.Background(), "opaque-foo")
+	if err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if username != "foo@example.org" {
+		t.Fatal("Wrong username:", username)
+	}
+
+	for _, tok := range []string{"opaque-bar", "opaque-other", "unknown"} {
+		if _, err := a.AuthBearer(context.Background(), tok); err == nil {
+			t.Error("Expected error for", tok)
+		}
+	}
+
+	// Results are cached.
+	delete(p.active, "opaque-foo")
+	if _, err := a.AuthBearer(context.Background(), "opaque-foo"); err != nil {
+		t.Fatal("Cached token rejected:", err)
+	}
+}
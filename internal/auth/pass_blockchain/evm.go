@@ -5,11 +5,12 @@ import (
 	"strings"
 
 	"github.com/mail-chat-chain/mailchatd/framework/address"
-	parser "github.com/mail-chat-chain/mailchatd/framework/cfgparser"
 	"github.com/mail-chat-chain/mailchatd/framework/config"
 	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/authz"
+	"github.com/mail-chat-chain/mailchatd/internal/blockchain"
 )
 
 type EVMAuth struct {
@@ -19,7 +20,9 @@ type EVMAuth struct {
 
 	log log.Logger
 	// custom fields
-	chain   module.BlockChain
+	// chains maps the chain type to the verifier used for addresses of
+	// that format.
+	chains  map[string]module.SignatureVerifier
 	storage module.ManageableStorage
 }
 
@@ -33,13 +36,14 @@ func NewEVM(modName, instName string, _, inlineArgs []string) (module.Module, er
 }
 
 func (a *EVMAuth) Init(cfg *config.Map) error {
-	err := modconfig.ModuleFromNode("blockchain", cfg.Block.Children[0].Args, parser.Node{}, cfg.Globals, &a.chain)
-	if err != nil {
-		a.log.Printf("error initializing blockchain: %v", err)
-		return err
-	}
-	err = modconfig.ModuleFromNode("storage", cfg.Block.Children[1].Args, parser.Node{}, cfg.Globals, &a.storage)
-	return nil
+	cfg.Custom("blockchain", false, true, nil, blockchain.VerifiersDirective, &a.chains)
+	cfg.Custom("storage", false, true, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var storage module.ManageableStorage
+		err := modconfig.ModuleFromNode("storage", node.Args, node, m.Globals, &storage)
+		return storage, err
+	}, &a.storage)
+	_, err := cfg.Process()
+	return err
 }
 
 func (a *EVMAuth) Name() string {
@@ -50,13 +54,48 @@ func (a *EVMAuth) InstanceName() string {
 	return a.instName
 }
 
+// NormalizeUsername applies the default auth_map_normalize function except
+// for base58 ed25519 addresses, the letter case of these is kept since it is
+// a part of the public key.
+func (a *EVMAuth) NormalizeUsername(username string) (string, error) {
+	normalized, err := authz.NormalizeAuto(username)
+	if err != nil {
+		return "", err
+	}
+	pk, _, hasDomain := strings.Cut(username, "@")
+	if !blockchain.IsCaseSensitiveAddress(pk) {
+		return normalized, nil
+	}
+	if !hasDomain {
+		return pk, nil
+	}
+	_, domain, err := address.Split(normalized)
+	if err != nil {
+		return "", err
+	}
+	return pk + "@" + domain, nil
+}
+
 func (a *EVMAuth) AuthPlain(username, sign string) error {
 	pk, _, err := address.Split(username)
 	if err != nil {
 		a.log.Printf("error splitting address: %v", err)
 		return err
 	}
-	result, err := a.chain.CheckSign(context.TODO(), pk, sign, strings.ToLower(pk))
+	chainType := blockchain.AddressChainType(pk)
+	chain, ok := a.chains[chainType]
+	if !ok {
+		a.log.DebugMsg("no blockchain for the address format", "username", username)
+		return module.ErrUnknownCredentials
+	}
+	// The wallet signs the address as it appears in the username. The
+	// letter case is only significant for base58 addresses, others are
+	// signed in lowercase.
+	message := pk
+	if !blockchain.IsCaseSensitiveAddress(pk) {
+		message = strings.ToLower(pk)
+	}
+	result, err := chain.CheckSign(context.TODO(), pk, sign, message)
 	if err != nil {
 		a.log.Printf("error checking signature: %v", err)
 		return err
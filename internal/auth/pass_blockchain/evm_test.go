@@ -0,0 +1,84 @@
+package pass_blockchain
+
+import (
+	"crypto/ed25519"
+	"encoding/hex"
+	"strings"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/auth"
+	"github.com/mail-chat-chain/mailchatd/internal/authz"
+	"github.com/mail-chat-chain/mailchatd/internal/blockchain"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+func TestAuthPlain_Ed25519(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	chain, err := blockchain.NewEd25519BlockChain("blockchain.ed25519", "solana", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	a := &EVMAuth{
+		log:    testutils.Logger(t, "auth.pass_evm"),
+		chains: map[string]module.SignatureVerifier{"ed25519": chain.(module.SignatureVerifier)},
+	}
+	// Default auth_map_normalize of the endpoints.
+	sasl := auth.SASLAuth{
+		Log:           testutils.Logger(t, "saslauth"),
+		Plain:         []module.PlainAuth{a},
+		AuthNormalize: authz.NormalizeAuto,
+	}
+
+	addr := hex.EncodeToString(pub)
+	sign := base58.Encode(ed25519.Sign(priv, []byte(addr)))
+
+	// Clients may send the key in any case, the signature is made over the
+	// canonical lowercase address.
+	if err := sasl.AuthPlain(strings.ToUpper(addr)+"@example.org", sign); err != nil {
+		t.Fatal("signature not accepted:", err)
+	}
+	if err := sasl.AuthPlain(addr+"@example.org", sign); err != nil {
+		t.Fatal("signature not accepted:", err)
+	}
+
+	other, _, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := sasl.AuthPlain(hex.EncodeToString(other)+"@example.org", sign); err == nil {
+		t.Fatal("signature of another key accepted")
+	}
+
+	// base58 addresses are case-sensitive and are not folded.
+	b58 := base58.Encode(pub)
+	b58Sign := base58.Encode(ed25519.Sign(priv, []byte(b58)))
+	if err := sasl.AuthPlain(b58+"@Example.org", b58Sign); err != nil {
+		t.Fatal("base58 signature not accepted:", err)
+	}
+	if err := sasl.AuthPlain(strings.ToLower(b58)+"@example.org", b58Sign); err == nil {
+		t.Fatal("case-folded base58 address accepted")
+	}
+}
+
+func TestNormalizeUsername(t *testing.T) {
+	a := &EVMAuth{}
+	for username, expected := range map[string]string{
+		"0x52908400098527886E0F7030069857D2E4169EE7@Example.ORG":   "0x52908400098527886e0f7030069857d2e4169ee7@example.org",
+		"4Nd1mBQtrMJVYVfKf2PJy9NZUZdTAsp7D4xWLs4gDB4T@Example.ORG": "4Nd1mBQtrMJVYVfKf2PJy9NZUZdTAsp7D4xWLs4gDB4T@example.org",
+		"4Nd1mBQtrMJVYVfKf2PJy9NZUZdTAsp7D4xWLs4gDB4T":             "4Nd1mBQtrMJVYVfKf2PJy9NZUZdTAsp7D4xWLs4gDB4T",
+		"Alice@Example.ORG": "alice@example.org",
+	} {
+		res, err := a.NormalizeUsername(username)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if res != expected {
+			t.Errorf("NormalizeUsername(%q) = %q, expected %q", username, res, expected)
+		}
+	}
+}
@@ -30,6 +30,8 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
 	"github.com/mail-chat-chain/mailchatd/internal/auth/sasllogin"
+	"github.com/mail-chat-chain/mailchatd/internal/auth/saslxoauth2"
+	"github.com/mail-chat-chain/mailchatd/internal/authguard"
 	"github.com/mail-chat-chain/mailchatd/internal/authz"
 )
 
@@ -54,7 +56,14 @@ type SASLAuth struct {
 	AuthMap       module.Table
 	AuthNormalize authz.NormalizeFunc
 
-	Plain []module.PlainAuth
+	// Protocol is passed to providers implementing module.ScopedPlainAuth.
+	Protocol string
+
+	// Guard throttles and bans sources of authentication failures. May be nil.
+	Guard *authguard.Guard
+
+	Plain  []module.PlainAuth
+	Bearer []module.BearerAuth
 }
 
 func (s *SASLAuth) SASLMechanisms() []string {
@@ -66,14 +75,21 @@ func (s *SASLAuth) SASLMechanisms() []string {
 			mechs = append(mechs, sasl.Login)
 		}
 	}
+	if len(s.Bearer) != 0 {
+		mechs = append(mechs, sasl.OAuthBearer, saslxoauth2.XOAuth2)
+	}
 
 	return mechs
 }
 
-func (s *SASLAuth) usernameForAuth(ctx context.Context, saslUsername string) (string, error) {
-	if s.AuthNormalize != nil {
+func (s *SASLAuth) usernameForAuth(ctx context.Context, p module.PlainAuth, saslUsername string) (string, error) {
+	normalize := s.AuthNormalize
+	if n, ok := p.(module.UsernameNormalizer); ok {
+		normalize = n.NormalizeUsername
+	}
+	if normalize != nil {
 		var err error
-		saslUsername, err = s.AuthNormalize(saslUsername)
+		saslUsername, err = normalize(saslUsername)
 		if err != nil {
 			return "", err
 		}
@@ -105,7 +121,7 @@ func (s *SASLAuth) AuthPlain(username, password string) error {
 
 	var lastErr error
 	for _, p := range s.Plain {
-		mappedUsername, err := s.usernameForAuth(context.TODO(), username)
+		mappedUsername, err := s.usernameForAuth(context.TODO(), p, username)
 		if err != nil {
 			return err
 		}
@@ -114,7 +130,11 @@ func (s *SASLAuth) AuthPlain(username, password string) error {
 			"mapped_username", mappedUsername, "original_username", username,
 			"module", p)
 
-		lastErr = p.AuthPlain(mappedUsername, password)
+		if scoped, ok := p.(module.ScopedPlainAuth); ok {
+			lastErr = scoped.AuthPlainScoped(s.Protocol, mappedUsername, password)
+		} else {
+			lastErr = p.AuthPlain(mappedUsername, password)
+		}
 		if lastErr == nil {
 			return nil
 		}
@@ -123,6 +143,68 @@ func (s *SASLAuth) AuthPlain(username, password string) error {
 	return fmt.Errorf("no auth. provider accepted creds, last err: %w", lastErr)
 }
 
+// AuthPlainFrom is AuthPlain for credentials supplied by the client at
+// remoteAddr. Brute-force protection is applied if Guard is set.
+func (s *SASLAuth) AuthPlainFrom(remoteAddr net.Addr, username, password string) error {
+	if err := s.Guard.Check(context.TODO(), remoteAddr, username); err != nil {
+		return err
+	}
+	err := s.AuthPlain(username, password)
+	s.Guard.Record(remoteAddr, username, err == nil)
+	return err
+}
+
+// AuthBearer validates the OAuth 2.0 access token and returns the
+// (normalized) username it was issued for.
+func (s *SASLAuth) AuthBearer(ctx context.Context, token string) (string, error) {
+	if len(s.Bearer) == 0 {
+		return "", ErrUnsupportedMech
+	}
+
+	var lastErr error
+	for _, b := range s.Bearer {
+		s.Log.DebugMsg("attempting token authentication", "module", b)
+
+		var username string
+		username, lastErr = b.AuthBearer(ctx, token)
+		if lastErr != nil {
+			continue
+		}
+		if s.AuthNormalize != nil {
+			return s.AuthNormalize(username)
+		}
+		return username, nil
+	}
+
+	return "", fmt.Errorf("no auth. provider accepted token, last err: %w", lastErr)
+}
+
+// bearerIdentity validates the token and checks that the username supplied
+// by the client (if any) matches the token owner.
+func (s *SASLAuth) bearerIdentity(remoteAddr net.Addr, username, token string) (string, error) {
+	if err := s.Guard.Check(context.TODO(), remoteAddr, username); err != nil {
+		return "", err
+	}
+	owner, err := s.AuthBearer(context.TODO(), token)
+	s.Guard.Record(remoteAddr, username, err == nil)
+	if err != nil {
+		return "", err
+	}
+	if username == "" {
+		return owner, nil
+	}
+	if s.AuthNormalize != nil {
+		username, err = s.AuthNormalize(username)
+		if err != nil {
+			return "", err
+		}
+	}
+	if username != owner {
+		return "", fmt.Errorf("token is issued for %s, not %s", owner, username)
+	}
+	return owner, nil
+}
+
 type ContextData struct {
 	// Authentication username. May be different from identity.
 	Username string
@@ -143,7 +225,7 @@ func (s *SASLAuth) CreateSASL(mech string, remoteAddr net.Addr, successCb func(i
 				return ErrInvalidAuthCred
 			}
 
-			err := s.AuthPlain(username, password)
+			err := s.AuthPlainFrom(remoteAddr, username, password)
 			if err != nil {
 				s.Log.Error("authentication failed", err, "username", username, "src_ip", remoteAddr)
 				return ErrInvalidAuthCred
@@ -160,15 +242,17 @@ func (s *SASLAuth) CreateSASL(mech string, remoteAddr net.Addr, successCb func(i
 		}
 
 		return sasllogin.NewLoginServer(func(username, password string) error {
-			username, err := s.usernameForAuth(context.Background(), username)
+			// AuthPlainFrom maps the username for each provider, it may
+			// normalize it differently.
+			err := s.AuthPlainFrom(remoteAddr, username, password)
 			if err != nil {
-				return err
+				s.Log.Error("authentication failed", err, "username", username, "src_ip", remoteAddr)
+				return ErrInvalidAuthCred
 			}
 
-			err = s.AuthPlain(username, password)
+			username, err = s.usernameForAuth(context.Background(), nil, username)
 			if err != nil {
-				s.Log.Error("authentication failed", err, "username", username, "src_ip", remoteAddr)
-				return ErrInvalidAuthCred
+				return err
 			}
 
 			return successCb(username, ContextData{
@@ -176,6 +260,36 @@ func (s *SASLAuth) CreateSASL(mech string, remoteAddr net.Addr, successCb func(i
 				Password: password,
 			})
 		})
+	case sasl.OAuthBearer:
+		if len(s.Bearer) == 0 {
+			return FailingSASLServ{Err: ErrUnsupportedMech}
+		}
+
+		return sasl.NewOAuthBearerServer(func(opts sasl.OAuthBearerOptions) *sasl.OAuthBearerError {
+			username, err := s.bearerIdentity(remoteAddr, opts.Username, opts.Token)
+			if err == nil {
+				err = successCb(username, ContextData{Username: username})
+			}
+			if err != nil {
+				s.Log.Error("authentication failed", err, "username", opts.Username, "src_ip", remoteAddr)
+				return &sasl.OAuthBearerError{Status: "invalid_token", Schemes: "bearer"}
+			}
+			return nil
+		})
+	case saslxoauth2.XOAuth2:
+		if len(s.Bearer) == 0 {
+			return FailingSASLServ{Err: ErrUnsupportedMech}
+		}
+
+		return saslxoauth2.NewServer(func(username, token string) error {
+			owner, err := s.bearerIdentity(remoteAddr, username, token)
+			if err != nil {
+				s.Log.Error("authentication failed", err, "username", username, "src_ip", remoteAddr)
+				return ErrInvalidAuthCred
+			}
+
+			return successCb(owner, ContextData{Username: owner})
+		})
 	}
 	return FailingSASLServ{Err: ErrUnsupportedMech}
 }
@@ -193,6 +307,10 @@ func (s *SASLAuth) AddProvider(m *config.Map, node config.Node) error {
 		s.Plain = append(s.Plain, plainAuth)
 		hasAny = true
 	}
+	if bearerAuth, ok := any.(module.BearerAuth); ok {
+		s.Bearer = append(s.Bearer, bearerAuth)
+		hasAny = true
+	}
 
 	if !hasAny {
 		return config.NodeErr(node, "auth: specified module does not provide any SASL mechanism")
@@ -19,11 +19,14 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package auth
 
 import (
+	"context"
 	"errors"
 	"net"
 	"testing"
 
+	"github.com/mail-chat-chain/mailchatd/framework/config"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/authguard"
 	"github.com/mail-chat-chain/mailchatd/internal/testutils"
 )
 
@@ -39,6 +42,18 @@ func (m mockAuth) AuthPlain(username, _ string) error {
 	return nil
 }
 
+type mockBearer struct {
+	tokens map[string]string
+}
+
+func (m mockBearer) AuthBearer(_ context.Context, token string) (string, error) {
+	username, ok := m.tokens[token]
+	if !ok {
+		return "", errors.New("invalid token")
+	}
+	return username, nil
+}
+
 func TestCreateSASL(t *testing.T) {
 	a := SASLAuth{
 		Log: testutils.Logger(t, "saslauth"),
@@ -86,4 +101,112 @@ func TestCreateSASL(t *testing.T) {
 			t.Error("Unexpected error:", err)
 		}
 	})
+
+	t.Run("OAUTHBEARER unavailable", func(t *testing.T) {
+		srv := a.CreateSASL("OAUTHBEARER", &net.TCPAddr{}, func(string, ContextData) error { return nil })
+		_, _, err := srv.Next([]byte("n,,\x01auth=Bearer token1\x01\x01"))
+		if err == nil {
+			t.Error("No error for OAUTHBEARER without providers")
+		}
+	})
+}
+
+func TestCreateSASL_Bearer(t *testing.T) {
+	a := SASLAuth{
+		Log: testutils.Logger(t, "saslauth"),
+		Bearer: []module.BearerAuth{
+			mockBearer{tokens: map[string]string{"token1": "user1"}},
+		},
+	}
+
+	mechs := a.SASLMechanisms()
+	if len(mechs) != 2 || mechs[0] != "OAUTHBEARER" || mechs[1] != "XOAUTH2" {
+		t.Fatal("Wrong mechanisms:", mechs)
+	}
+
+	for _, tc := range []struct {
+		mech     string
+		response string
+		ok       bool
+	}{
+		{"OAUTHBEARER", "n,,\x01auth=Bearer token1\x01\x01", true},
+		{"OAUTHBEARER", "n,a=user1,\x01host=mx.example.org\x01port=143\x01auth=Bearer token1\x01\x01", true},
+		{"OAUTHBEARER", "n,a=user2,\x01auth=Bearer token1\x01\x01", false},
+		{"OAUTHBEARER", "n,,\x01auth=Bearer token2\x01\x01", false},
+		{"XOAUTH2", "user=user1\x01auth=Bearer token1\x01\x01", true},
+		{"XOAUTH2", "user=user2\x01auth=Bearer token1\x01\x01", false},
+		{"XOAUTH2", "user=user1\x01auth=Bearer token2\x01\x01", false},
+		{"XOAUTH2", "user=user1\x01auth=Basic token1\x01\x01", false},
+	} {
+		t.Run(tc.mech+" "+tc.response, func(t *testing.T) {
+			var identity string
+			srv := a.CreateSASL(tc.mech, &net.TCPAddr{}, func(id string, data ContextData) error {
+				identity = id
+				return nil
+			})
+
+			challenge, done, err := srv.Next([]byte(tc.response))
+			if !done && err == nil {
+				// Failure is reported in the challenge, the exchange is
+				// finished after the client response.
+				if len(challenge) == 0 {
+					t.Fatal("Exchange not done and no error challenge")
+				}
+				_, done, err = srv.Next([]byte{0x01})
+				if !done {
+					t.Fatal("Exchange not done after error challenge")
+				}
+			}
+			if tc.ok {
+				if err != nil {
+					t.Fatal("Unexpected error:", err)
+				}
+				if identity != "user1" {
+					t.Fatal("Wrong identity passed to callback:", identity)
+				}
+			} else if err == nil {
+				t.Fatal("Expected error, got none")
+			}
+		})
+	}
+}
+
+func TestCreateSASL_Guard(t *testing.T) {
+	mod, err := authguard.New("auth_guard", "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	guard := mod.(*authguard.Guard)
+	err = guard.Init(config.NewMap(nil, config.Node{
+		Children: []config.Node{
+			{Name: "max_failures", Args: []string{"2"}},
+			{Name: "free_failures", Args: []string{"5"}},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer guard.Close()
+
+	a := SASLAuth{
+		Log:   testutils.Logger(t, "saslauth"),
+		Plain: []module.PlainAuth{&mockAuth{db: map[string]bool{"user1": true}}},
+		Guard: guard,
+	}
+	src := &net.TCPAddr{IP: net.IPv4(198, 51, 100, 1), Port: 1234}
+
+	for i := 0; i < 2; i++ {
+		srv := a.CreateSASL("PLAIN", src, func(string, ContextData) error { return nil })
+		if _, _, err := srv.Next([]byte("\x00user2\x00aa")); err == nil {
+			t.Fatal("Expected error, got none")
+		}
+	}
+
+	// Valid credentials are rejected from the banned source.
+	if err := a.AuthPlainFrom(src, "user1", "aa"); !errors.Is(err, authguard.ErrBanned) {
+		t.Fatal("Expected ErrBanned, got", err)
+	}
+	if err := a.AuthPlainFrom(&net.TCPAddr{IP: net.IPv4(198, 51, 100, 2)}, "user1", "aa"); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
 }
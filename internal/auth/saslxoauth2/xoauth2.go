@@ -0,0 +1,108 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package saslxoauth2
+
+import (
+	"errors"
+	"strings"
+
+	"github.com/emersion/go-sasl"
+)
+
+// XOAuth2 is the XOAUTH2 mechanism name.
+const XOAuth2 = "XOAUTH2"
+
+// Authenticator validates the bearer token for the user.
+type Authenticator func(username, token string) error
+
+// errorChallenge is sent to the client before failing the exchange, as done
+// by existing XOAUTH2 implementations.
+const errorChallenge = `{"status":"401","schemes":"bearer"}`
+
+type server struct {
+	done         bool
+	failErr      error
+	authenticate Authenticator
+}
+
+// NewServer creates the server implementation of the XOAUTH2 authentication
+// mechanism, as described in https://developers.google.com/gmail/imap/xoauth2-protocol.
+//
+// XOAUTH2 predates OAUTHBEARER (RFC 7628) and is supported for clients that
+// do not implement the latter.
+func NewServer(authenticator Authenticator) sasl.Server {
+	return &server{authenticate: authenticator}
+}
+
+func (a *server) Next(response []byte) (challenge []byte, done bool, err error) {
+	// The client responds to the error challenge with an empty message, the
+	// exchange fails after that.
+	if a.failErr != nil {
+		return nil, true, a.failErr
+	}
+	if a.done {
+		return nil, true, sasl.ErrUnexpectedClientResponse
+	}
+
+	// Generate empty challenge.
+	if response == nil {
+		return []byte{}, false, nil
+	}
+	a.done = true
+
+	username, token, err := parse(string(response))
+	if err != nil {
+		return nil, true, err
+	}
+
+	if err := a.authenticate(username, token); err != nil {
+		a.failErr = err
+		return []byte(errorChallenge), false, nil
+	}
+	return nil, true, nil
+}
+
+// parse splits the "user=...\x01auth=Bearer ...\x01\x01" client response.
+func parse(response string) (username, token string, err error) {
+	if !strings.HasSuffix(response, "\x01\x01") {
+		return "", "", errors.New("sasl: invalid XOAUTH2 response")
+	}
+	for _, field := range strings.Split(strings.TrimSuffix(response, "\x01\x01"), "\x01") {
+		key, value, ok := strings.Cut(field, "=")
+		if !ok {
+			return "", "", errors.New("sasl: invalid XOAUTH2 response, missing '='")
+		}
+		switch key {
+		case "user":
+			username = value
+		case "auth":
+			scheme, tok, ok := strings.Cut(value, " ")
+			if !ok || !strings.EqualFold(scheme, "bearer") {
+				return "", "", errors.New("sasl: unsupported XOAUTH2 token type")
+			}
+			token = tok
+		default:
+			return "", "", errors.New("sasl: invalid XOAUTH2 response, unknown field: " + key)
+		}
+	}
+	if token == "" {
+		return "", "", errors.New("sasl: invalid XOAUTH2 response, missing token")
+	}
+	return username, token, nil
+}
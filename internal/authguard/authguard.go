@@ -0,0 +1,405 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package authguard implements the auth_guard module that slows down and
+// temporarily bans sources of repeated authentication failures.
+//
+// Failures are tracked per IP subnet and per username. Usernames are only
+// throttled to not let attackers lock out legitimate users, subnets are
+// banned after max_failures failures within the window.
+//
+// Interfaces implemented:
+// - module.Check (as no-op, used for module.EarlyCheck)
+// - module.EarlyCheck
+package authguard
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/table"
+)
+
+const modName = "auth_guard"
+
+// ErrBanned is returned by Guard.Check if the source is banned.
+var ErrBanned = errors.New("auth_guard: source is temporarily banned due to authentication failures")
+
+type counter struct {
+	failures int
+	first    time.Time
+}
+
+type Guard struct {
+	instName string
+	log      log.Logger
+
+	prefixV4    int
+	prefixV6    int
+	maxFailures int
+	window      time.Duration
+	banTime     time.Duration
+	maxBanTime  time.Duration
+	freeTries   int
+	delay       time.Duration
+	maxDelay    time.Duration
+	exempt      []net.IPNet
+
+	bans module.MutableTable
+
+	countersLck sync.Mutex
+	counters    map[string]*counter
+
+	now   func() time.Time
+	sleep func(ctx context.Context, d time.Duration) error
+	stop  chan struct{}
+	done  chan struct{}
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	if len(inlineArgs) != 0 {
+		return nil, fmt.Errorf("%s: inline arguments are not used", modName)
+	}
+	return &Guard{
+		instName: instName,
+		log:      log.Logger{Name: modName},
+		counters: make(map[string]*counter),
+		now:      time.Now,
+		sleep:    sleepCtx,
+	}, nil
+}
+
+func sleepCtx(ctx context.Context, d time.Duration) error {
+	t := time.NewTimer(d)
+	defer t.Stop()
+	select {
+	case <-t.C:
+		return nil
+	case <-ctx.Done():
+		return ctx.Err()
+	}
+}
+
+func (g *Guard) Init(cfg *config.Map) error {
+	var exempt []string
+	cfg.Bool("debug", true, false, &g.log.Debug)
+	cfg.Int("ipv4_prefix", false, false, 32, &g.prefixV4)
+	cfg.Int("ipv6_prefix", false, false, 64, &g.prefixV6)
+	cfg.Int("max_failures", false, false, 10, &g.maxFailures)
+	cfg.Duration("window", false, false, 15*time.Minute, &g.window)
+	cfg.Duration("ban_time", false, false, time.Hour, &g.banTime)
+	cfg.Duration("max_ban_time", false, false, 24*time.Hour, &g.maxBanTime)
+	cfg.Int("free_failures", false, false, 3, &g.freeTries)
+	cfg.Duration("delay", false, false, time.Second, &g.delay)
+	cfg.Duration("max_delay", false, false, 10*time.Second, &g.maxDelay)
+	cfg.StringList("exempt", false, false, []string{"127.0.0.0/8", "::1/128"}, &exempt)
+	cfg.Custom("bans", false, false, func() (interface{}, error) {
+		return table.NewMemory(), nil
+	}, func(m *config.Map, node config.Node) (interface{}, error) {
+		var tbl module.MutableTable
+		err := modconfig.ModuleFromNode("table", node.Args, node, m.Globals, &tbl)
+		return tbl, err
+	}, &g.bans)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	if g.prefixV4 < 0 || g.prefixV4 > 32 {
+		return fmt.Errorf("%s: invalid ipv4_prefix: %d", modName, g.prefixV4)
+	}
+	if g.prefixV6 < 0 || g.prefixV6 > 128 {
+		return fmt.Errorf("%s: invalid ipv6_prefix: %d", modName, g.prefixV6)
+	}
+	if g.maxFailures <= 0 {
+		return fmt.Errorf("%s: max_failures should be positive", modName)
+	}
+	for _, cidr := range exempt {
+		_, ipNet, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return fmt.Errorf("%s: exempt: %w", modName, err)
+		}
+		g.exempt = append(g.exempt, *ipNet)
+	}
+	if !g.Persistent() {
+		g.log.Msg("no bans table configured, bans will not persist across restarts")
+	}
+
+	if module.NoRun {
+		return nil
+	}
+	g.stop = make(chan struct{})
+	g.done = make(chan struct{})
+	go g.cleanup()
+	return nil
+}
+
+func (g *Guard) Name() string {
+	return modName
+}
+
+func (g *Guard) InstanceName() string {
+	return g.instName
+}
+
+func (g *Guard) Close() error {
+	if g.stop != nil {
+		close(g.stop)
+		<-g.done
+	}
+	return nil
+}
+
+// cleanup periodically drops expired failure counters and ban records.
+func (g *Guard) cleanup() {
+	defer close(g.done)
+	t := time.NewTicker(time.Minute)
+	defer t.Stop()
+	for i := 0; ; i++ {
+		select {
+		case <-g.stop:
+			return
+		case <-t.C:
+		}
+
+		g.pruneCounters()
+		if i%60 == 0 {
+			if err := g.pruneBans(); err != nil {
+				g.log.Error("failed to prune expired bans", err)
+			}
+		}
+	}
+}
+
+func (g *Guard) pruneCounters() {
+	g.countersLck.Lock()
+	defer g.countersLck.Unlock()
+	now := g.now()
+	for k, c := range g.counters {
+		if now.Sub(c.first) > g.window {
+			delete(g.counters, k)
+		}
+	}
+}
+
+// Prefix returns the subnet of the IP address used to track failures and
+// bans.
+func (g *Guard) Prefix(ip net.IP) string {
+	if v4 := ip.To4(); v4 != nil {
+		return (&net.IPNet{IP: v4.Mask(net.CIDRMask(g.prefixV4, 32)), Mask: net.CIDRMask(g.prefixV4, 32)}).String()
+	}
+	return (&net.IPNet{IP: ip.Mask(net.CIDRMask(g.prefixV6, 128)), Mask: net.CIDRMask(g.prefixV6, 128)}).String()
+}
+
+// sourceIP returns the IP address to track or nil if the address is not
+// an IP address or is exempt.
+func (g *Guard) sourceIP(addr net.Addr) net.IP {
+	tcpAddr, ok := addr.(*net.TCPAddr)
+	if !ok {
+		return nil
+	}
+	for _, n := range g.exempt {
+		if n.Contains(tcpAddr.IP) {
+			return nil
+		}
+	}
+	return tcpAddr.IP
+}
+
+func userKey(username string) string {
+	return "user:" + strings.ToLower(username)
+}
+
+func ipKey(prefix string) string {
+	return "ip:" + prefix
+}
+
+// failures returns the amount of recent failures for the key and increments
+// it if inc is true.
+func (g *Guard) failures(key string, inc bool) int {
+	g.countersLck.Lock()
+	defer g.countersLck.Unlock()
+
+	now := g.now()
+	c := g.counters[key]
+	if c != nil && now.Sub(c.first) > g.window {
+		c = nil
+		delete(g.counters, key)
+	}
+	if !inc {
+		if c == nil {
+			return 0
+		}
+		return c.failures
+	}
+	if c == nil {
+		c = &counter{first: now}
+		g.counters[key] = c
+	}
+	c.failures++
+	return c.failures
+}
+
+func (g *Guard) resetFailures(key string) {
+	g.countersLck.Lock()
+	defer g.countersLck.Unlock()
+	delete(g.counters, key)
+}
+
+// delayFor returns the delay applied after the specified amount of failures.
+func (g *Guard) delayFor(failures int) time.Duration {
+	n := failures - g.freeTries
+	if n <= 0 || g.delay <= 0 {
+		return 0
+	}
+	d := g.delay
+	for i := 1; i < n && d < g.maxDelay; i++ {
+		d *= 2
+	}
+	return min(d, g.maxDelay)
+}
+
+// Check should be called before verifying credentials supplied by the client.
+// It returns ErrBanned if the source is banned and delays the attempt if
+// there were recent failures for the source or username.
+//
+// Guard methods can be called on a nil Guard, they do nothing in this case.
+func (g *Guard) Check(ctx context.Context, addr net.Addr, username string) error {
+	if g == nil {
+		return nil
+	}
+
+	failures := 0
+	if username != "" {
+		failures = g.failures(userKey(username), false)
+	}
+	if ip := g.sourceIP(addr); ip != nil {
+		prefix := g.Prefix(ip)
+		ban, err := g.ban(ctx, prefix)
+		if err != nil {
+			g.log.Error("ban lookup failed", err, "prefix", prefix)
+		} else if ban != nil {
+			g.log.DebugMsg("rejecting banned source", "src_ip", ip, "until", ban.Until)
+			return ErrBanned
+		}
+		failures = max(failures, g.failures(ipKey(prefix), false))
+	}
+
+	if d := g.delayFor(failures); d > 0 {
+		g.log.DebugMsg("delaying authentication", "src_addr", addr, "username", username, "delay", d)
+		return g.sleep(ctx, d)
+	}
+	return nil
+}
+
+// Record should be called after verifying credentials supplied by the client.
+func (g *Guard) Record(addr net.Addr, username string, success bool) {
+	if g == nil {
+		return
+	}
+
+	if success {
+		if username != "" {
+			g.resetFailures(userKey(username))
+		}
+		return
+	}
+
+	if username != "" {
+		g.failures(userKey(username), true)
+	}
+	ip := g.sourceIP(addr)
+	if ip == nil {
+		return
+	}
+	prefix := g.Prefix(ip)
+	if g.failures(ipKey(prefix), true) < g.maxFailures {
+		return
+	}
+
+	g.resetFailures(ipKey(prefix))
+	ban, err := g.Ban(prefix, 0, "too many authentication failures")
+	if err != nil {
+		g.log.Error("failed to ban source", err, "prefix", prefix)
+		return
+	}
+	g.log.Msg("banned source due to authentication failures", "prefix", prefix, "until", ban.Until, "offense", ban.Count)
+}
+
+// CheckConnection implements module.EarlyCheck.
+func (g *Guard) CheckConnection(ctx context.Context, state *module.ConnState) error {
+	ip := g.sourceIP(state.RemoteAddr)
+	if ip == nil {
+		return nil
+	}
+	ban, err := g.ban(ctx, g.Prefix(ip))
+	if err != nil {
+		g.log.Error("ban lookup failed", err, "src_ip", ip)
+		return nil
+	}
+	if ban == nil {
+		return nil
+	}
+	return &exterrors.SMTPError{
+		Code:         421,
+		EnhancedCode: exterrors.EnhancedCode{4, 7, 0},
+		Message:      "Too many authentication failures, try again later",
+		CheckName:    modName,
+		Err:          ErrBanned,
+	}
+}
+
+type noopState struct{}
+
+func (noopState) CheckConnection(context.Context) module.CheckResult     { return module.CheckResult{} }
+func (noopState) CheckSender(context.Context, string) module.CheckResult { return module.CheckResult{} }
+func (noopState) CheckRcpt(context.Context, string) module.CheckResult   { return module.CheckResult{} }
+func (noopState) CheckBody(context.Context, textproto.Header, buffer.Buffer) module.CheckResult {
+	return module.CheckResult{}
+}
+func (noopState) Close() error { return nil }
+
+// CheckStateForMsg implements module.Check. All work is done in
+// CheckConnection.
+func (g *Guard) CheckStateForMsg(context.Context, *module.MsgMetadata) (module.CheckState, error) {
+	return noopState{}, nil
+}
+
+// Directive parses the auth_guard directive of endpoints.
+func Directive(m *config.Map, node config.Node) (interface{}, error) {
+	var g *Guard
+	if err := modconfig.GroupFromNode(modName, node.Args, node, m.Globals, &g); err != nil {
+		return nil, err
+	}
+	return g, nil
+}
+
+func init() {
+	module.Register(modName, New)
+}
@@ -0,0 +1,186 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package authguard
+
+import (
+	"context"
+	"errors"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+func testGuard(t *testing.T, children ...config.Node) (*Guard, *[]time.Duration, *time.Time) {
+	mod, err := New(modName, "", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := mod.(*Guard)
+	g.log = testutils.Logger(t, modName)
+	if err := g.Init(config.NewMap(nil, config.Node{Children: children})); err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { g.Close() })
+
+	now := time.Date(2026, 1, 1, 0, 0, 0, 0, time.UTC)
+	var delays []time.Duration
+	g.now = func() time.Time { return now }
+	g.sleep = func(_ context.Context, d time.Duration) error {
+		delays = append(delays, d)
+		return nil
+	}
+	return g, &delays, &now
+}
+
+func addr(ip string) net.Addr {
+	return &net.TCPAddr{IP: net.ParseIP(ip), Port: 1234}
+}
+
+func TestGuard_Delay(t *testing.T) {
+	g, delays, now := testGuard(t)
+	src := addr("198.51.100.1")
+
+	for i := 0; i < 6; i++ {
+		if err := g.Check(context.Background(), src, "user"); err != nil {
+			t.Fatal(err)
+		}
+		g.Record(src, "user", false)
+	}
+	expected := []time.Duration{time.Second, 2 * time.Second}
+	if len(*delays) != len(expected) || (*delays)[0] != expected[0] || (*delays)[1] != expected[1] {
+		t.Fatalf("Wrong delays: %v", *delays)
+	}
+
+	// Username failures are tracked separately from the source.
+	*delays = nil
+	if err := g.Check(context.Background(), addr("203.0.113.1"), "USER"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*delays) != 1 || (*delays)[0] != 4*time.Second {
+		t.Fatalf("Wrong delays: %v", *delays)
+	}
+
+	// Success resets the username counter.
+	g.Record(addr("203.0.113.1"), "user", true)
+	*delays = nil
+	if err := g.Check(context.Background(), addr("203.0.113.1"), "user"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*delays) != 0 {
+		t.Fatalf("Wrong delays: %v", *delays)
+	}
+
+	// Counters expire after the window.
+	*now = now.Add(16 * time.Minute)
+	*delays = nil
+	if err := g.Check(context.Background(), src, "other"); err != nil {
+		t.Fatal(err)
+	}
+	if len(*delays) != 0 {
+		t.Fatalf("Wrong delays: %v", *delays)
+	}
+}
+
+func TestGuard_Ban(t *testing.T) {
+	bans := &testutils.MutableTable{M: map[string]string{}}
+	g, _, now := testGuard(t,
+		config.Node{Name: "max_failures", Args: []string{"3"}},
+	)
+	g.bans = bans
+
+	fail := func(ip string, times int) {
+		for i := 0; i < times; i++ {
+			g.Record(addr(ip), "user", false)
+		}
+	}
+
+	fail("198.51.100.1", 3)
+	if err := g.Check(context.Background(), addr("198.51.100.1"), ""); !errors.Is(err, ErrBanned) {
+		t.Fatal("Expected ErrBanned, got", err)
+	}
+	if err := g.Check(context.Background(), addr("198.51.100.2"), ""); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+	if err := g.CheckConnection(context.Background(), &module.ConnState{RemoteAddr: addr("198.51.100.1")}); err == nil {
+		t.Fatal("Expected early check to reject the connection")
+	}
+	if _, ok := bans.M["198.51.100.1/32"]; !ok {
+		t.Fatal("Ban is not stored in the table:", bans.M)
+	}
+
+	// IPv6 addresses are grouped by /64.
+	fail("2001:db8::1", 2)
+	fail("2001:db8::2", 1)
+	if err := g.Check(context.Background(), addr("2001:db8::ffff"), ""); !errors.Is(err, ErrBanned) {
+		t.Fatal("Expected ErrBanned, got", err)
+	}
+
+	// Loopback is exempt.
+	fail("127.0.0.1", 10)
+	if err := g.Check(context.Background(), addr("127.0.0.1"), ""); err != nil {
+		t.Fatal("Unexpected error:", err)
+	}
+
+	list, err := g.Bans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 2 {
+		t.Fatalf("Wrong bans: %+v", list)
+	}
+
+	// Repeated offense doubles the ban time.
+	*now = now.Add(time.Hour)
+	fail("198.51.100.1", 2)
+	if err := g.Check(context.Background(), addr("198.51.100.1"), ""); err != nil {
+		t.Fatal("Ban did not expire:", err)
+	}
+	fail("198.51.100.1", 1)
+	list, err = g.Bans()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0].Prefix != "198.51.100.1/32" || list[0].Count != 2 || !list[0].Until.Equal(now.Add(2*time.Hour)) {
+		t.Fatalf("Wrong bans: %+v", list)
+	}
+
+	prefix, err := g.ParsePrefix("198.51.100.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Unban(prefix); err != nil {
+		t.Fatal(err)
+	}
+	if err := g.Check(context.Background(), addr("198.51.100.1"), ""); err != nil {
+		t.Fatal("Unexpected error after unban:", err)
+	}
+
+	// Expired records are pruned after max_ban_time.
+	*now = now.Add(48 * time.Hour)
+	if err := g.pruneBans(); err != nil {
+		t.Fatal(err)
+	}
+	if len(bans.M) != 0 {
+		t.Fatal("Expired bans are not pruned:", bans.M)
+	}
+}
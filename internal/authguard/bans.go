@@ -0,0 +1,177 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package authguard
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"sort"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/internal/table"
+)
+
+// Ban is the ban record stored in the bans table as a JSON object, keyed by
+// the subnet.
+//
+// Records are kept after the ban expires for max_ban_time so repeated
+// offenses are banned for longer.
+type Ban struct {
+	Prefix string    `json:"-"`
+	Until  time.Time `json:"until"`
+	Count  int       `json:"count"`
+	Reason string    `json:"reason,omitempty"`
+}
+
+// Active reports whether the ban is in effect at the specified moment.
+func (b Ban) Active(now time.Time) bool {
+	return now.Before(b.Until)
+}
+
+func (g *Guard) record(ctx context.Context, prefix string) (*Ban, error) {
+	val, ok, err := g.bans.Lookup(ctx, prefix)
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, nil
+	}
+	var b Ban
+	if err := json.Unmarshal([]byte(val), &b); err != nil {
+		return nil, fmt.Errorf("%s: malformed ban record for %s: %w", modName, prefix, err)
+	}
+	b.Prefix = prefix
+	return &b, nil
+}
+
+// ban returns the active ban for the subnet or nil.
+func (g *Guard) ban(ctx context.Context, prefix string) (*Ban, error) {
+	b, err := g.record(ctx, prefix)
+	if err != nil || b == nil || !b.Active(g.now()) {
+		return nil, err
+	}
+	return b, nil
+}
+
+// ParsePrefix converts the IP address to the subnet used as the ban key.
+func (g *Guard) ParsePrefix(s string) (string, error) {
+	ip := net.ParseIP(s)
+	if ip == nil {
+		return "", fmt.Errorf("%s: malformed IP address: %s", modName, s)
+	}
+	return g.Prefix(ip), nil
+}
+
+// Ban bans the subnet. If duration is zero, ban_time is used and doubled
+// for each repeated offense, up to max_ban_time.
+func (g *Guard) Ban(prefix string, duration time.Duration, reason string) (Ban, error) {
+	ctx := context.TODO()
+	now := g.now()
+
+	b, err := g.record(ctx, prefix)
+	if err != nil {
+		return Ban{}, err
+	}
+	if b == nil || now.Sub(b.Until) > g.maxBanTime {
+		b = &Ban{Prefix: prefix}
+	}
+	b.Count++
+	b.Reason = reason
+
+	if duration == 0 {
+		duration = g.banTime
+		for i := 1; i < b.Count && duration < g.maxBanTime; i++ {
+			duration *= 2
+		}
+		duration = min(duration, g.maxBanTime)
+	}
+	b.Until = now.Add(duration)
+
+	blob, err := json.Marshal(b)
+	if err != nil {
+		return Ban{}, err
+	}
+	if err := g.bans.SetKey(prefix, string(blob)); err != nil {
+		return Ban{}, fmt.Errorf("%s: ban %s: %w", modName, prefix, err)
+	}
+	return *b, nil
+}
+
+// Unban removes the ban record for the subnet, including the offense
+// history.
+func (g *Guard) Unban(prefix string) error {
+	g.resetFailures(ipKey(prefix))
+	if err := g.bans.RemoveKey(prefix); err != nil {
+		return fmt.Errorf("%s: unban %s: %w", modName, prefix, err)
+	}
+	return nil
+}
+
+// Bans returns active bans sorted by expiry time.
+func (g *Guard) Bans() ([]Ban, error) {
+	keys, err := g.bans.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("%s: list bans: %w", modName, err)
+	}
+	now := g.now()
+	res := make([]Ban, 0, len(keys))
+	for _, k := range keys {
+		b, err := g.record(context.TODO(), k)
+		if err != nil {
+			return nil, err
+		}
+		if b != nil && b.Active(now) {
+			res = append(res, *b)
+		}
+	}
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].Until.Before(res[j].Until)
+	})
+	return res, nil
+}
+
+// pruneBans removes records of bans that expired more than max_ban_time ago.
+func (g *Guard) pruneBans() error {
+	keys, err := g.bans.Keys()
+	if err != nil {
+		return err
+	}
+	now := g.now()
+	for _, k := range keys {
+		b, err := g.record(context.TODO(), k)
+		if err != nil {
+			g.log.Error("removing malformed ban record", err, "prefix", k)
+		} else if b == nil || now.Sub(b.Until) <= g.maxBanTime {
+			continue
+		}
+		if err := g.bans.RemoveKey(k); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Persistent reports whether bans are stored in the configured table and
+// thus can be managed externally.
+func (g *Guard) Persistent() bool {
+	_, ok := g.bans.(*table.Memory)
+	return !ok
+}
@@ -0,0 +1,73 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package blockchain
+
+import (
+	"context"
+
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// AddressChainType returns the chain type of the signature verifier that
+// handles the address format or an empty string if the format is unknown.
+//
+// 0x-prefixed hex addresses are used by Ethereum, bech32 addresses by Cosmos
+// and base58 or hex-encoded public keys (without the 0x prefix) by ed25519
+// wallets.
+func AddressChainType(addr string) string {
+	if has0xPrefix(addr) && common.IsHexAddress(addr) {
+		return "ethereum"
+	}
+	if _, _, err := bech32.DecodeAndConvert(addr); err == nil {
+		return "cosmos"
+	}
+	if _, ok := decodeEd25519PublicKey(addr); ok {
+		return "ed25519"
+	}
+	return ""
+}
+
+func has0xPrefix(s string) bool {
+	return len(s) >= 2 && s[0] == '0' && (s[1] == 'x' || s[1] == 'X')
+}
+
+// VerifiersDirective loads the list of blockchain modules used to verify
+// signatures. The result is the map from the chain type to the module.
+func VerifiersDirective(m *config.Map, node config.Node) (interface{}, error) {
+	if len(node.Args) == 0 {
+		return nil, config.NodeErr(node, "expected at least one argument")
+	}
+	verifiers := make(map[string]module.SignatureVerifier, len(node.Args))
+	for _, arg := range node.Args {
+		var chain module.SignatureVerifier
+		if err := modconfig.ModuleFromNode("blockchain", []string{arg}, config.Node{}, m.Globals, &chain); err != nil {
+			return nil, err
+		}
+		chainType := chain.ChainType(context.Background())
+		if _, ok := verifiers[chainType]; ok {
+			return nil, config.NodeErr(node, "multiple blockchains of type %s", chainType)
+		}
+		verifiers[chainType] = chain
+	}
+	return verifiers, nil
+}
@@ -0,0 +1,225 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"strings"
+	"sync"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+var (
+	// ErrUnsupported is returned by backends that can't serve the call. The
+	// call is passed to the next endpoint.
+	ErrUnsupported = errors.New("blockchain: call is not supported by the backend")
+	// ErrReverted is returned for calls reverted by the EVM.
+	ErrReverted = errors.New("execution reverted")
+)
+
+// Backend is a connection to an EVM node.
+type Backend interface {
+	ChainID(ctx context.Context) (*big.Int, error)
+	SendRawTx(ctx context.Context, rawTx []byte) error
+	CallContract(ctx context.Context, to common.Address, data []byte) ([]byte, error)
+	PendingNonce(ctx context.Context, account common.Address) (uint64, error)
+	SuggestGasPrice(ctx context.Context) (*big.Int, error)
+	TransactionReceipt(ctx context.Context, txHash common.Hash) (*module.TxReceipt, error)
+}
+
+var (
+	localLck sync.RWMutex
+	local    Backend
+)
+
+// SetLocal sets the backend used by the "local" endpoint. It is called by
+// the node application if it runs in the same process as the mail server.
+func SetLocal(b Backend) {
+	localLck.Lock()
+	defer localLck.Unlock()
+	local = b
+}
+
+// localBackend resolves the backend set by SetLocal at call time, so the
+// mail server can be initialized before the node. Calls fail with
+// ErrUnsupported until the node is running.
+type localBackend struct{}
+
+func (localBackend) get() (Backend, error) {
+	localLck.RLock()
+	defer localLck.RUnlock()
+	if local == nil {
+		return nil, ErrUnsupported
+	}
+	return local, nil
+}
+
+func (l localBackend) ChainID(ctx context.Context) (*big.Int, error) {
+	b, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return b.ChainID(ctx)
+}
+
+func (l localBackend) SendRawTx(ctx context.Context, rawTx []byte) error {
+	b, err := l.get()
+	if err != nil {
+		return err
+	}
+	return b.SendRawTx(ctx, rawTx)
+}
+
+func (l localBackend) CallContract(ctx context.Context, to common.Address, data []byte) ([]byte, error) {
+	b, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return b.CallContract(ctx, to, data)
+}
+
+func (l localBackend) PendingNonce(ctx context.Context, account common.Address) (uint64, error) {
+	b, err := l.get()
+	if err != nil {
+		return 0, err
+	}
+	return b.PendingNonce(ctx, account)
+}
+
+func (l localBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	b, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return b.SuggestGasPrice(ctx)
+}
+
+func (l localBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*module.TxReceipt, error) {
+	b, err := l.get()
+	if err != nil {
+		return nil, err
+	}
+	return b.TransactionReceipt(ctx, txHash)
+}
+
+// rpcBackend talks to the node using JSON-RPC. The client is dialed lazily
+// and reused for all calls.
+type rpcBackend struct {
+	url string
+
+	lck    sync.Mutex
+	client *ethclient.Client
+}
+
+func (r *rpcBackend) dial(ctx context.Context) (*ethclient.Client, error) {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	if r.client != nil {
+		return r.client, nil
+	}
+	client, err := ethclient.DialContext(ctx, r.url)
+	if err != nil {
+		return nil, err
+	}
+	r.client = client
+	return client, nil
+}
+
+func (r *rpcBackend) Close() {
+	r.lck.Lock()
+	defer r.lck.Unlock()
+
+	if r.client != nil {
+		r.client.Close()
+		r.client = nil
+	}
+}
+
+func (r *rpcBackend) ChainID(ctx context.Context) (*big.Int, error) {
+	client, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.ChainID(ctx)
+}
+
+func (r *rpcBackend) SendRawTx(ctx context.Context, rawTx []byte) error {
+	client, err := r.dial(ctx)
+	if err != nil {
+		return err
+	}
+	err = client.Client().CallContext(ctx, nil, "eth_sendRawTransaction", hexutil.Encode(rawTx))
+	// The transaction was already submitted through another endpoint.
+	if err != nil && strings.Contains(err.Error(), "already known") {
+		return nil
+	}
+	return err
+}
+
+func (r *rpcBackend) CallContract(ctx context.Context, to common.Address, data []byte) ([]byte, error) {
+	client, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.CallContract(ctx, ethereum.CallMsg{To: &to, Data: data}, nil)
+}
+
+func (r *rpcBackend) PendingNonce(ctx context.Context, account common.Address) (uint64, error) {
+	client, err := r.dial(ctx)
+	if err != nil {
+		return 0, err
+	}
+	return client.PendingNonceAt(ctx, account)
+}
+
+func (r *rpcBackend) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	client, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	return client.SuggestGasPrice(ctx)
+}
+
+func (r *rpcBackend) TransactionReceipt(ctx context.Context, txHash common.Hash) (*module.TxReceipt, error) {
+	client, err := r.dial(ctx)
+	if err != nil {
+		return nil, err
+	}
+	receipt, err := client.TransactionReceipt(ctx, txHash)
+	if err != nil {
+		if errors.Is(err, ethereum.NotFound) {
+			return nil, module.ErrTxPending
+		}
+		return nil, err
+	}
+	return &module.TxReceipt{
+		TxHash:      receipt.TxHash.Hex(),
+		Success:     receipt.Status == types.ReceiptStatusSuccessful,
+		BlockNumber: receipt.BlockNumber.Uint64(),
+	}, nil
+}
@@ -0,0 +1,182 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package blockchain
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"strings"
+
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// Amino names of the supported public key types.
+const (
+	pubKeySecp256k1    = "tendermint/PubKeySecp256k1"
+	pubKeyEthSecp256k1 = "os/PubKeyEthSecp256k1"
+	// Used by Keplr for Ethermint-based chains.
+	pubKeyEthermintSecp256k1 = "ethermint/PubKeyEthSecp256k1"
+)
+
+// CosmosBlockChain verifies ADR-036 (sign arbitrary data) signatures made by
+// Cosmos wallets such as Keplr.
+//
+// The signature is the JSON object returned by signArbitrary:
+//
+//	{"pub_key":{"type":"tendermint/PubKeySecp256k1","value":"<base64>"},"signature":"<base64>"}
+//
+// or the compact "<base64 public key>:<base64 signature>" form.
+type CosmosBlockChain struct {
+	modName  string
+	instName string
+	log      log.Logger
+
+	prefix string
+}
+
+func NewCosmosBlockChain(modName, instName string, _, _ []string) (module.Module, error) {
+	return &CosmosBlockChain{
+		modName:  modName,
+		instName: instName,
+		log:      log.Logger{Name: modName, Debug: log.DefaultLogger.Debug},
+	}, nil
+}
+
+func (b *CosmosBlockChain) Init(cfg *config.Map) error {
+	cfg.Bool("debug", true, false, &b.log.Debug)
+	cfg.String("bech32_prefix", false, false, "mailchat", &b.prefix)
+	_, err := cfg.Process()
+	return err
+}
+
+func (b *CosmosBlockChain) Name() string { return b.modName }
+
+func (b *CosmosBlockChain) InstanceName() string {
+	return b.instName
+}
+
+func (b *CosmosBlockChain) ChainType(ctx context.Context) string {
+	return "cosmos"
+}
+
+type stdSignature struct {
+	PubKey struct {
+		Type  string `json:"type"`
+		Value []byte `json:"value"`
+	} `json:"pub_key"`
+	Signature []byte `json:"signature"`
+}
+
+func parseStdSignature(sign string) (*stdSignature, error) {
+	var sig stdSignature
+	if strings.HasPrefix(strings.TrimSpace(sign), "{") {
+		if err := json.Unmarshal([]byte(sign), &sig); err != nil {
+			return nil, fmt.Errorf("malformed signature: %w", err)
+		}
+		return &sig, nil
+	}
+
+	pub, s, ok := strings.Cut(sign, ":")
+	if !ok {
+		return nil, fmt.Errorf("malformed signature")
+	}
+	var err error
+	sig.PubKey.Value, err = base64.StdEncoding.DecodeString(pub)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %w", err)
+	}
+	sig.Signature, err = base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed signature: %w", err)
+	}
+	return &sig, nil
+}
+
+// adr036SignDoc returns the canonical amino JSON of the ADR-036 sign
+// document for the data signed by the signer.
+func adr036SignDoc(signer string, data []byte) []byte {
+	signerJSON, _ := json.Marshal(signer)
+	dataJSON, _ := json.Marshal(base64.StdEncoding.EncodeToString(data))
+	return []byte(`{"account_number":"0","chain_id":"","fee":{"amount":[],"gas":"0"},"memo":"",` +
+		`"msgs":[{"type":"sign/MsgSignData","value":{"data":` + string(dataJSON) + `,"signer":` + string(signerJSON) + `}}],` +
+		`"sequence":"0"}`)
+}
+
+// verifySecp256k1 verifies the signature made by the standard Cosmos key.
+func verifySecp256k1(addr, pub, doc, sig []byte) bool {
+	key := &secp256k1.PubKey{Key: pub}
+	if len(pub) != secp256k1.PubKeySize || !bytes.Equal(key.Address(), addr) {
+		return false
+	}
+	return key.VerifySignature(doc, sig)
+}
+
+// verifyEthSecp256k1 verifies the signature made by the Ethereum-compatible
+// key used by Cosmos EVM accounts.
+func verifyEthSecp256k1(addr, pub, doc, sig []byte) bool {
+	key, err := crypto.DecompressPubkey(pub)
+	if err != nil || !bytes.Equal(crypto.PubkeyToAddress(*key).Bytes(), addr) {
+		return false
+	}
+	if len(sig) == crypto.SignatureLength {
+		sig = sig[:crypto.RecoveryIDOffset]
+	}
+	return crypto.VerifySignature(pub, crypto.Keccak256(doc), sig)
+}
+
+func (b *CosmosBlockChain) CheckSign(ctx context.Context, pk, sign, message string) (bool, error) {
+	hrp, addr, err := bech32.DecodeAndConvert(pk)
+	if err != nil {
+		return false, fmt.Errorf("invalid address: %w", err)
+	}
+	if hrp != b.prefix {
+		return false, nil
+	}
+	sig, err := parseStdSignature(sign)
+	if err != nil {
+		return false, err
+	}
+
+	doc := adr036SignDoc(pk, []byte(message))
+	switch sig.PubKey.Type {
+	case pubKeySecp256k1:
+		return verifySecp256k1(addr, sig.PubKey.Value, doc, sig.Signature), nil
+	case pubKeyEthSecp256k1, pubKeyEthermintSecp256k1:
+		return verifyEthSecp256k1(addr, sig.PubKey.Value, doc, sig.Signature), nil
+	case "":
+		// The compact form does not carry the key type, the address tells
+		// which one is used.
+		return verifySecp256k1(addr, sig.PubKey.Value, doc, sig.Signature) ||
+			verifyEthSecp256k1(addr, sig.PubKey.Value, doc, sig.Signature), nil
+	default:
+		return false, fmt.Errorf("unsupported public key type: %s", sig.PubKey.Type)
+	}
+}
+
+func init() {
+	module.Register("blockchain.cosmos", NewCosmosBlockChain)
+}
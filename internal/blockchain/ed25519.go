@@ -0,0 +1,130 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package blockchain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"fmt"
+	"strings"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// Ed25519BlockChain verifies signatures made by ed25519 wallets such as
+// Solana ones. The address is the base58 or hex-encoded public key and the
+// signature of the raw message is encoded using base58, base64 or hex.
+//
+// base58 addresses are case-sensitive, auth.pass_evm keeps their letter case
+// when usernames are normalized.
+type Ed25519BlockChain struct {
+	modName  string
+	instName string
+	log      log.Logger
+}
+
+func NewEd25519BlockChain(modName, instName string, _, _ []string) (module.Module, error) {
+	return &Ed25519BlockChain{
+		modName:  modName,
+		instName: instName,
+		log:      log.Logger{Name: modName, Debug: log.DefaultLogger.Debug},
+	}, nil
+}
+
+func (b *Ed25519BlockChain) Init(cfg *config.Map) error {
+	cfg.Bool("debug", true, false, &b.log.Debug)
+	_, err := cfg.Process()
+	return err
+}
+
+func (b *Ed25519BlockChain) Name() string { return b.modName }
+
+func (b *Ed25519BlockChain) InstanceName() string {
+	return b.instName
+}
+
+func (b *Ed25519BlockChain) ChainType(ctx context.Context) string {
+	return "ed25519"
+}
+
+// decodeEd25519PublicKey decodes the hex or base58 address. ok is false if
+// it is not an ed25519 public key.
+func decodeEd25519PublicKey(addr string) (ed25519.PublicKey, bool) {
+	if len(addr) == hex.EncodedLen(ed25519.PublicKeySize) {
+		if pub, err := hex.DecodeString(addr); err == nil {
+			return pub, true
+		}
+	}
+	if pub := base58.Decode(addr); len(pub) == ed25519.PublicKeySize {
+		return pub, true
+	}
+	return nil, false
+}
+
+// IsCaseSensitiveAddress reports whether the letter case is significant in
+// the address, that is the case for base58-encoded ed25519 public keys.
+func IsCaseSensitiveAddress(addr string) bool {
+	if _, ok := decodeEd25519PublicKey(addr); !ok {
+		return false
+	}
+	_, err := hex.DecodeString(addr)
+	return err != nil
+}
+
+// decodeEd25519Signatures returns all interpretations of the signature that
+// have the valid length.
+func decodeEd25519Signatures(sign string) [][]byte {
+	var sigs [][]byte
+	if sig := base58.Decode(sign); len(sig) == ed25519.SignatureSize {
+		sigs = append(sigs, sig)
+	}
+	if sig, err := base64.StdEncoding.DecodeString(sign); err == nil && len(sig) == ed25519.SignatureSize {
+		sigs = append(sigs, sig)
+	}
+	if sig, err := hex.DecodeString(strings.TrimPrefix(sign, "0x")); err == nil && len(sig) == ed25519.SignatureSize {
+		sigs = append(sigs, sig)
+	}
+	return sigs
+}
+
+func (b *Ed25519BlockChain) CheckSign(ctx context.Context, pk, sign, message string) (bool, error) {
+	pub, ok := decodeEd25519PublicKey(pk)
+	if !ok {
+		return false, fmt.Errorf("invalid address: %s", pk)
+	}
+	sigs := decodeEd25519Signatures(sign)
+	if len(sigs) == 0 {
+		return false, fmt.Errorf("malformed signature")
+	}
+	for _, sig := range sigs {
+		if ed25519.Verify(pub, []byte(message), sig) {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func init() {
+	module.Register("blockchain.ed25519", NewEd25519BlockChain)
+}
@@ -3,68 +3,194 @@ package blockchain
 import (
 	"context"
 	"encoding/hex"
+	"errors"
 	"fmt"
+	"math/big"
 	"strings"
+	"time"
 
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/common/math"
 	"github.com/ethereum/go-ethereum/crypto"
-	"github.com/ethereum/go-ethereum/ethclient"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
 	"github.com/mail-chat-chain/mailchatd/framework/config"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
 )
 
-func verifySignature(message, signature, address string) (bool, error) {
-	// 签名为65字节，最后一个字节为v值，需要手动调整
+// decodeEthSignature decodes the 65 bytes signature and normalizes the v
+// value to 0 or 1.
+func decodeEthSignature(signature string) ([]byte, error) {
 	sigBytes, err := hex.DecodeString(strings.TrimPrefix(signature, "0x"))
 	if err != nil {
-		return false, err
+		return nil, err
 	}
 	if len(sigBytes) != 65 {
-		return false, fmt.Errorf("invalid signature length")
+		return nil, fmt.Errorf("invalid signature length")
 	}
+	if sigBytes[64] >= 27 {
+		sigBytes[64] -= 27
+	}
+	return sigBytes, nil
+}
 
-	// 将 v 值调整为标准值（0或1）
-	sigBytes[64] -= 27
-
-	// 对消息进行哈希处理
-	msgHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), message)))
+func recoveredAddressIs(hash, sig []byte, address string) (bool, error) {
+	pubKey, err := crypto.SigToPub(hash, sig)
+	if err != nil {
+		return false, err
+	}
+	recoveredAddress := crypto.PubkeyToAddress(*pubKey).Hex()
+	return strings.EqualFold(recoveredAddress, address), nil
+}
 
-	// 恢复公钥
-	pubKey, err := crypto.SigToPub(msgHash.Bytes(), sigBytes)
+// verifySignature verifies the personal_sign (EIP-191) signature.
+func verifySignature(message, signature, address string) (bool, error) {
+	sigBytes, err := decodeEthSignature(signature)
 	if err != nil {
 		return false, err
 	}
+	msgHash := crypto.Keccak256Hash([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(message), message)))
+	return recoveredAddressIs(msgHash.Bytes(), sigBytes, address)
+}
 
-	// 从公钥导出地址
-	recoveredAddress := crypto.PubkeyToAddress(*pubKey).Hex()
+// loginTypedData returns the EIP-712 typed data signed by wallets that
+// support only eth_signTypedData_v4.
+func loginTypedData(message string, chainID int64) apitypes.TypedData {
+	return apitypes.TypedData{
+		Types: apitypes.Types{
+			"EIP712Domain": {
+				{Name: "name", Type: "string"},
+				{Name: "version", Type: "string"},
+				{Name: "chainId", Type: "uint256"},
+			},
+			"Login": {
+				{Name: "message", Type: "string"},
+			},
+		},
+		PrimaryType: "Login",
+		Domain: apitypes.TypedDataDomain{
+			Name:    "MailChat",
+			Version: "1",
+			ChainId: math.NewHexOrDecimal256(chainID),
+		},
+		Message: apitypes.TypedDataMessage{
+			"message": message,
+		},
+	}
+}
 
-	// 比较地址是否一致
-	return strings.ToLower(recoveredAddress) == strings.ToLower(address), nil
+// verifyTypedDataSignature verifies the EIP-712 signature of the login
+// message.
+func verifyTypedDataSignature(message, signature, address string, chainID int64) (bool, error) {
+	sigBytes, err := decodeEthSignature(signature)
+	if err != nil {
+		return false, err
+	}
+	hash, _, err := apitypes.TypedDataAndHash(loginTypedData(message, chainID))
+	if err != nil {
+		return false, err
+	}
+	return recoveredAddressIs(hash, sigBytes, address)
 }
 
+// EVMBlockChain implements module.BlockChain using one or more node
+// endpoints. Calls go to the first available endpoint and fail over to the
+// next ones; see pool for the circuit breaker rules. The "local" endpoint
+// serves calls in-process using the node running in the same process, if
+// any.
 type EVMBlockChain struct {
 	modName  string
 	instName string
 	log      log.Logger
 
-	//TODO add more fields
 	chainID int64
-	rpcURL  string
+	pool    pool
 }
 
 func (b *EVMBlockChain) SendRawTx(ctx context.Context, rawTx string) error {
-	client, err := ethclient.Dial(b.rpcURL)
+	raw, err := hexutil.Decode(rawTx)
+	if err != nil {
+		return fmt.Errorf("invalid raw transaction: %w", err)
+	}
+	return b.pool.call(ctx, "eth_sendRawTransaction", func(ctx context.Context, be Backend) error {
+		return be.SendRawTx(ctx, raw)
+	})
+}
+
+// ChainID returns the configured chain ID. Endpoints serving other chains are
+// not used.
+func (b *EVMBlockChain) ChainID(ctx context.Context) (*big.Int, error) {
+	return big.NewInt(b.chainID), nil
+}
+
+func (b *EVMBlockChain) CallContract(ctx context.Context, to, data string) (string, error) {
+	if !common.IsHexAddress(to) {
+		return "", fmt.Errorf("invalid contract address: %s", to)
+	}
+	input, err := hexutil.Decode(data)
 	if err != nil {
-		b.log.Error("failed to dial rpc", err)
+		return "", fmt.Errorf("invalid call data: %w", err)
+	}
+
+	var res []byte
+	err = b.pool.call(ctx, "eth_call", func(ctx context.Context, be Backend) error {
+		var err error
+		res, err = be.CallContract(ctx, common.HexToAddress(to), input)
 		return err
+	})
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(res), nil
+}
+
+func (b *EVMBlockChain) PendingNonce(ctx context.Context, account string) (uint64, error) {
+	if !common.IsHexAddress(account) {
+		return 0, fmt.Errorf("invalid account address: %s", account)
 	}
-	defer client.Close()
-	err = client.Client().CallContext(ctx, nil, "eth_sendRawTransaction", rawTx)
-	return err
+
+	var nonce uint64
+	err := b.pool.call(ctx, "eth_getTransactionCount", func(ctx context.Context, be Backend) error {
+		var err error
+		nonce, err = be.PendingNonce(ctx, common.HexToAddress(account))
+		return err
+	})
+	return nonce, err
+}
+
+func (b *EVMBlockChain) SuggestGasPrice(ctx context.Context) (*big.Int, error) {
+	var price *big.Int
+	err := b.pool.call(ctx, "eth_gasPrice", func(ctx context.Context, be Backend) error {
+		var err error
+		price, err = be.SuggestGasPrice(ctx)
+		return err
+	})
+	return price, err
+}
+
+func (b *EVMBlockChain) TransactionReceipt(ctx context.Context, txHash string) (*module.TxReceipt, error) {
+	hash, err := hexutil.Decode(txHash)
+	if err != nil || len(hash) != common.HashLength {
+		return nil, fmt.Errorf("invalid transaction hash: %s", txHash)
+	}
+
+	var receipt *module.TxReceipt
+	err = b.pool.call(ctx, "eth_getTransactionReceipt", func(ctx context.Context, be Backend) error {
+		var err error
+		receipt, err = be.TransactionReceipt(ctx, common.BytesToHash(hash))
+		return err
+	})
+	return receipt, err
 }
 
+// CheckSign accepts both personal_sign and EIP-712 signatures.
 func (b *EVMBlockChain) CheckSign(ctx context.Context, pk, sign, message string) (bool, error) {
-	return verifySignature(message, sign, pk)
+	ok, err := verifySignature(message, sign, pk)
+	if err != nil || ok {
+		return ok, err
+	}
+	return verifyTypedDataSignature(message, sign, pk, b.chainID)
 }
 
 func (b *EVMBlockChain) ChainType(ctx context.Context) string {
@@ -80,18 +206,80 @@ func NewEVMBlockChain(modName, instName string, _, _ []string) (module.Module, e
 }
 
 func (b *EVMBlockChain) Init(cfg *config.Map) error {
-	//TODO implement me
+	var (
+		urls                []string
+		healthCheckInterval time.Duration
+	)
+	cfg.Bool("debug", true, false, &b.log.Debug)
 	cfg.Int64("chain_id", false, true, 0, &b.chainID)
-	cfg.String("rpc_url", false, true, "", &b.rpcURL)
+	cfg.StringList("rpc_url", false, true, nil, &urls)
+	cfg.Duration("call_timeout", false, false, 10*time.Second, &b.pool.timeout)
+	cfg.Duration("health_check_interval", false, false, 30*time.Second, &healthCheckInterval)
+	cfg.Int("failure_threshold", false, false, 3, &b.pool.failureThreshold)
+	cfg.Duration("breaker_cooldown", false, false, 30*time.Second, &b.pool.cooldown)
 	if _, err := cfg.Process(); err != nil {
 		b.log.Error("failed to process config", err)
 		return err
 	}
+
+	if b.chainID <= 0 {
+		return fmt.Errorf("%s: chain_id should be positive", b.modName)
+	}
+	if b.pool.failureThreshold <= 0 {
+		return fmt.Errorf("%s: failure_threshold should be positive", b.modName)
+	}
+	if b.pool.timeout <= 0 {
+		return fmt.Errorf("%s: call_timeout should be positive", b.modName)
+	}
+
+	b.pool.instName = b.instName
+	b.pool.log = b.log
+	b.pool.now = time.Now
+	b.pool.endpoints = nil
+	for _, u := range urls {
+		e := &endpoint{name: endpointName(u)}
+		if u == localEndpoint {
+			e.backend = localBackend{}
+		} else {
+			if e.name == "invalid" {
+				return fmt.Errorf("%s: invalid rpc_url: %s", b.modName, u)
+			}
+			e.backend = &rpcBackend{url: u}
+		}
+		b.pool.endpoints = append(b.pool.endpoints, e)
+		endpointUp.WithLabelValues(b.instName, e.name).Set(1)
+	}
+
+	expected := big.NewInt(b.chainID)
+	for _, e := range b.pool.endpoints {
+		ctx, cancel := context.WithTimeout(context.Background(), b.pool.timeout)
+		err := checkChainID(ctx, e, expected)
+		cancel()
+		switch {
+		case err == nil, errors.Is(err, ErrUnsupported):
+		case errors.Is(err, errWrongChain):
+			return fmt.Errorf("%s: %s: %w", b.modName, e.name, err)
+		default:
+			// The node might be not started yet, the health check will
+			// verify it later.
+			b.log.Error("failed to verify endpoint chain ID", err, "endpoint", e.name)
+			b.pool.failure(e, err)
+		}
+	}
+
+	if healthCheckInterval > 0 {
+		b.pool.startHealthChecks(healthCheckInterval, expected)
+	}
 	return nil
 }
 
 func (b *EVMBlockChain) Name() string { return b.modName }
 
+func (b *EVMBlockChain) Close() error {
+	b.pool.close()
+	return nil
+}
+
 func (b *EVMBlockChain) InstanceName() string {
 	return b.instName
 }
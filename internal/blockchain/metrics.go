@@ -0,0 +1,58 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package blockchain
+
+import "github.com/prometheus/client_golang/prometheus"
+
+var (
+	rpcCalls = prometheus.NewCounterVec(
+		prometheus.CounterOpts{
+			Namespace: "mailcoin",
+			Subsystem: "blockchain",
+			Name:      "rpc_calls",
+			Help:      "Number of calls made to the node endpoints by result (ok, error, failure, unsupported)",
+		},
+		[]string{"module", "endpoint", "method", "result"},
+	)
+	rpcDuration = prometheus.NewHistogramVec(
+		prometheus.HistogramOpts{
+			Namespace: "mailcoin",
+			Subsystem: "blockchain",
+			Name:      "rpc_call_duration_seconds",
+			Help:      "Time spent on calls to the node endpoints",
+			Buckets:   prometheus.DefBuckets,
+		},
+		[]string{"module", "endpoint", "method"},
+	)
+	endpointUp = prometheus.NewGaugeVec(
+		prometheus.GaugeOpts{
+			Namespace: "mailcoin",
+			Subsystem: "blockchain",
+			Name:      "endpoint_up",
+			Help:      "Whether the node endpoint is used for calls (1) or its circuit breaker is open (0)",
+		},
+		[]string{"module", "endpoint"},
+	)
+)
+
+func init() {
+	prometheus.MustRegister(rpcCalls)
+	prometheus.MustRegister(rpcDuration)
+	prometheus.MustRegister(endpointUp)
+}
@@ -0,0 +1,255 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package blockchain
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"math/big"
+	"net/url"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/rpc"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// ErrNoEndpoints is returned if circuit breakers of all endpoints are open.
+var ErrNoEndpoints = errors.New("blockchain: no node endpoints available")
+
+const localEndpoint = "local"
+
+// endpoint is a node endpoint with the circuit breaker. The breaker opens
+// after failureThreshold consecutive failures and the endpoint is skipped
+// until the cooldown expires or the health check succeeds. After the
+// cooldown a single failure opens it again.
+//
+// Endpoints for another chain are disabled regardless of the cooldown until
+// the health check finds the expected chain ID.
+type endpoint struct {
+	name    string
+	backend Backend
+
+	lck        sync.Mutex
+	failures   int
+	openUntil  time.Time
+	wrongChain bool
+}
+
+// endpointName returns the name of the endpoint used in logs and metrics.
+// Paths and credentials are stripped since they often contain API keys.
+func endpointName(rawURL string) string {
+	if rawURL == localEndpoint {
+		return localEndpoint
+	}
+	u, err := url.Parse(rawURL)
+	if err != nil || u.Host == "" {
+		return "invalid"
+	}
+	return u.Scheme + "://" + u.Host
+}
+
+func (e *endpoint) available(now time.Time) bool {
+	e.lck.Lock()
+	defer e.lck.Unlock()
+	return !e.wrongChain && !now.Before(e.openUntil)
+}
+
+type pool struct {
+	instName  string
+	log       log.Logger
+	endpoints []*endpoint
+
+	timeout          time.Duration
+	failureThreshold int
+	cooldown         time.Duration
+	now              func() time.Time
+
+	stop chan struct{}
+	wg   sync.WaitGroup
+}
+
+func (p *pool) success(e *endpoint) {
+	e.lck.Lock()
+	defer e.lck.Unlock()
+	e.failures = 0
+	e.openUntil = time.Time{}
+	e.wrongChain = false
+	endpointUp.WithLabelValues(p.instName, e.name).Set(1)
+}
+
+func (p *pool) failure(e *endpoint, err error) {
+	e.lck.Lock()
+	defer e.lck.Unlock()
+	e.failures++
+	if e.failures < p.failureThreshold {
+		return
+	}
+	if e.failures == p.failureThreshold {
+		p.log.Error("endpoint is unavailable, circuit breaker is open", err, "endpoint", e.name, "cooldown", p.cooldown)
+	}
+	e.openUntil = p.now().Add(p.cooldown)
+	endpointUp.WithLabelValues(p.instName, e.name).Set(0)
+}
+
+// isEndpointFailure reports whether the error is caused by the endpoint
+// rather than by the call itself, so the call should be retried using
+// another endpoint.
+func isEndpointFailure(err error) bool {
+	var rpcErr rpc.Error
+	switch {
+	case errors.As(err, &rpcErr):
+		// The node responded with an error.
+		return false
+	case errors.Is(err, ethereum.NotFound), errors.Is(err, module.ErrTxPending), errors.Is(err, ErrReverted):
+		return false
+	}
+	return true
+}
+
+// call runs fn using the first available endpoint, failing over to the next
+// endpoint on endpoint failures.
+func (p *pool) call(ctx context.Context, method string, fn func(ctx context.Context, b Backend) error) error {
+	var lastErr error
+	for _, e := range p.endpoints {
+		if !e.available(p.now()) {
+			continue
+		}
+
+		callCtx, cancel := context.WithTimeout(ctx, p.timeout)
+		start := time.Now()
+		err := fn(callCtx, e.backend)
+		cancel()
+
+		result := "ok"
+		switch {
+		case err == nil:
+		case errors.Is(err, ErrUnsupported):
+			result = "unsupported"
+		case !isEndpointFailure(err):
+			result = "error"
+		default:
+			result = "failure"
+		}
+		rpcCalls.WithLabelValues(p.instName, e.name, method, result).Inc()
+		if result != "unsupported" {
+			rpcDuration.WithLabelValues(p.instName, e.name, method).Observe(time.Since(start).Seconds())
+		}
+
+		switch result {
+		case "ok", "error":
+			p.success(e)
+			return err
+		case "unsupported":
+			if lastErr == nil {
+				lastErr = err
+			}
+			continue
+		}
+
+		if ctx.Err() != nil {
+			// The caller gave up, this is not the endpoint fault.
+			return err
+		}
+		p.log.DebugMsg("call failed, trying the next endpoint", "endpoint", e.name, "method", method, "reason", err.Error())
+		p.failure(e, err)
+		lastErr = fmt.Errorf("%s: %w", e.name, err)
+	}
+	if lastErr == nil {
+		return ErrNoEndpoints
+	}
+	return lastErr
+}
+
+// checkChainID returns the error if the endpoint is for another chain.
+// ErrUnsupported is returned as is.
+func checkChainID(ctx context.Context, e *endpoint, expected *big.Int) error {
+	id, err := e.backend.ChainID(ctx)
+	if err != nil {
+		return err
+	}
+	if id.Cmp(expected) != 0 {
+		return fmt.Errorf("%w: endpoint chain ID is %v, expected %v", errWrongChain, id, expected)
+	}
+	return nil
+}
+
+var errWrongChain = errors.New("blockchain: wrong chain")
+
+// healthCheck verifies the chain ID of all endpoints, including the ones with
+// the open circuit breaker, and updates their state.
+func (p *pool) healthCheck(expected *big.Int) {
+	for _, e := range p.endpoints {
+		ctx, cancel := context.WithTimeout(context.Background(), p.timeout)
+		err := checkChainID(ctx, e, expected)
+		cancel()
+
+		switch {
+		case err == nil:
+			if !e.available(p.now()) {
+				p.log.Msg("endpoint is available again", "endpoint", e.name)
+			}
+			p.success(e)
+		case errors.Is(err, ErrUnsupported):
+		case errors.Is(err, errWrongChain):
+			p.log.Error("endpoint disabled", err, "endpoint", e.name)
+			e.lck.Lock()
+			e.failures = p.failureThreshold
+			e.wrongChain = true
+			e.lck.Unlock()
+			endpointUp.WithLabelValues(p.instName, e.name).Set(0)
+		default:
+			p.failure(e, err)
+		}
+	}
+}
+
+func (p *pool) startHealthChecks(interval time.Duration, expected *big.Int) {
+	p.stop = make(chan struct{})
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-p.stop:
+				return
+			case <-ticker.C:
+				p.healthCheck(expected)
+			}
+		}
+	}()
+}
+
+func (p *pool) close() {
+	if p.stop != nil {
+		close(p.stop)
+		p.wg.Wait()
+		p.stop = nil
+	}
+	for _, e := range p.endpoints {
+		if r, ok := e.backend.(*rpcBackend); ok {
+			r.Close()
+		}
+	}
+}
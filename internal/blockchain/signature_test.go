@@ -0,0 +1,198 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package blockchain
+
+import (
+	"context"
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"testing"
+
+	"github.com/btcsuite/btcd/btcutil/base58"
+	"github.com/cosmos/cosmos-sdk/crypto/keys/secp256k1"
+	"github.com/cosmos/cosmos-sdk/types/bech32"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/ethereum/go-ethereum/signer/core/apitypes"
+)
+
+func TestEVMCheckSign(t *testing.T) {
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := crypto.PubkeyToAddress(key.PublicKey).Hex()
+	b := &EVMBlockChain{chainID: 26000}
+
+	sign := func(hash []byte) string {
+		sig, err := crypto.Sign(hash, key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig[64] += 27
+		return "0x" + hex.EncodeToString(sig)
+	}
+
+	personal := sign(crypto.Keccak256([]byte(fmt.Sprintf("\x19Ethereum Signed Message:\n%d%s", len(addr), addr))))
+	typedHash, _, err := apitypes.TypedDataAndHash(loginTypedData(addr, 26000))
+	if err != nil {
+		t.Fatal(err)
+	}
+	typed := sign(typedHash)
+	otherChainHash, _, err := apitypes.TypedDataAndHash(loginTypedData(addr, 1))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	for name, sig := range map[string]string{"personal_sign": personal, "EIP-712": typed} {
+		ok, err := b.CheckSign(context.Background(), addr, sig, addr)
+		if err != nil || !ok {
+			t.Errorf("%s: signature not accepted: %v", name, err)
+		}
+	}
+	ok, err := b.CheckSign(context.Background(), addr, sign(otherChainHash), addr)
+	if err != nil || ok {
+		t.Error("signature for another chain accepted:", err)
+	}
+	ok, err = b.CheckSign(context.Background(), addr, personal, "other message")
+	if err != nil || ok {
+		t.Error("signature for another message accepted:", err)
+	}
+}
+
+func TestCosmosCheckSign(t *testing.T) {
+	b := &CosmosBlockChain{prefix: "mailchat"}
+
+	t.Run("secp256k1", func(t *testing.T) {
+		key := secp256k1.GenPrivKey()
+		addr, err := bech32.ConvertAndEncode("mailchat", key.PubKey().Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := key.Sign(adr036SignDoc(addr, []byte(addr)))
+		if err != nil {
+			t.Fatal(err)
+		}
+		signJSON, err := json.Marshal(map[string]interface{}{
+			"pub_key":   map[string]interface{}{"type": pubKeySecp256k1, "value": key.PubKey().Bytes()},
+			"signature": sig,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		compact := base64.StdEncoding.EncodeToString(key.PubKey().Bytes()) + ":" + base64.StdEncoding.EncodeToString(sig)
+
+		for _, sign := range []string{string(signJSON), compact} {
+			ok, err := b.CheckSign(context.Background(), addr, sign, addr)
+			if err != nil || !ok {
+				t.Errorf("signature not accepted: %v", err)
+			}
+		}
+		ok, err := b.CheckSign(context.Background(), addr, compact, "other message")
+		if err != nil || ok {
+			t.Error("signature for another message accepted:", err)
+		}
+
+		other, err := bech32.ConvertAndEncode("mailchat", secp256k1.GenPrivKey().PubKey().Address())
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err = b.CheckSign(context.Background(), other, compact, addr)
+		if err != nil || ok {
+			t.Error("signature of another key accepted:", err)
+		}
+	})
+
+	t.Run("eth_secp256k1", func(t *testing.T) {
+		key, err := crypto.GenerateKey()
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr, err := bech32.ConvertAndEncode("mailchat", crypto.PubkeyToAddress(key.PublicKey).Bytes())
+		if err != nil {
+			t.Fatal(err)
+		}
+		sig, err := crypto.Sign(crypto.Keccak256(adr036SignDoc(addr, []byte(addr))), key)
+		if err != nil {
+			t.Fatal(err)
+		}
+		signJSON, err := json.Marshal(map[string]interface{}{
+			"pub_key":   map[string]interface{}{"type": pubKeyEthSecp256k1, "value": crypto.CompressPubkey(&key.PublicKey)},
+			"signature": sig,
+		})
+		if err != nil {
+			t.Fatal(err)
+		}
+		ok, err := b.CheckSign(context.Background(), addr, string(signJSON), addr)
+		if err != nil || !ok {
+			t.Errorf("signature not accepted: %v", err)
+		}
+	})
+
+	ok, err := b.CheckSign(context.Background(), "cosmos1qypqxpqpqgpsgqgzqvzqzqsrqsqsyqcyy2wt4y", "a:b", "")
+	if ok || err != nil {
+		t.Error("address with another prefix accepted:", err)
+	}
+}
+
+func TestEd25519CheckSign(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := hex.EncodeToString(pub)
+	sig := ed25519.Sign(priv, []byte(addr))
+	b := &Ed25519BlockChain{}
+
+	for _, sign := range []string{base58.Encode(sig), base64.StdEncoding.EncodeToString(sig), hex.EncodeToString(sig)} {
+		ok, err := b.CheckSign(context.Background(), addr, sign, addr)
+		if err != nil || !ok {
+			t.Errorf("%s: signature not accepted: %v", sign, err)
+		}
+	}
+	ok, err := b.CheckSign(context.Background(), addr, base58.Encode(sig), "other message")
+	if err != nil || ok {
+		t.Error("signature for another message accepted:", err)
+	}
+	if _, err := b.CheckSign(context.Background(), addr, "x", addr); err == nil {
+		t.Error("malformed signature accepted")
+	}
+	b58 := base58.Encode(pub)
+	if ok, err := b.CheckSign(context.Background(), b58, base58.Encode(ed25519.Sign(priv, []byte(b58))), b58); err != nil || !ok {
+		t.Error("base58 address not accepted:", err)
+	}
+}
+
+func TestAddressChainType(t *testing.T) {
+	for addr, chainType := range map[string]string{
+		"0x52908400098527886E0F7030069857D2E4169EE7":                       "ethereum",
+		"mailchat1qypqxpqpqgpsgqgzqvzqzqsrqsqsyqcyfxhnnh":                  "cosmos",
+		"d75a980182b10ab7d54bfed3c964073a0ee172f3daa62325af021a68f707511a": "ed25519",
+		"D75A980182B10AB7D54BFED3C964073A0EE172F3DAA62325AF021A68F707511A": "ed25519",
+		"4Nd1mBQtrMJVYVfKf2PJy9NZUZdTAsp7D4xWLs4gDB4T":                     "ed25519",
+		"0x1234":         "",
+		"not an address": "",
+	} {
+		if res := AddressChainType(addr); res != chainType {
+			t.Errorf("%s: expected %q, got %q", addr, chainType, res)
+		}
+	}
+}
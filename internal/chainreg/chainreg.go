@@ -0,0 +1,249 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package chainreg reads the on-chain mail server registry maintained by the
+// x/mail module.
+//
+// The registry can be read over the node gRPC API, over the EVM JSON-RPC API
+// (using the mail precompile) or in-process when the node runs in the same
+// binary.
+package chainreg
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// ErrNotRegistered is returned by Registry.Domain if the domain has no
+// verified registry entry.
+var ErrNotRegistered = errors.New("chainreg: domain is not registered")
+
+// FingerprintSHA256 is the only fingerprint algorithm used by the registry.
+const FingerprintSHA256 = "sha256"
+
+type (
+	// MXHost is a mail exchanger declared for a domain.
+	MXHost struct {
+		Host       string
+		Preference uint32
+	}
+
+	// KeyFingerprint identifies a DKIM or TLS public key by its digest.
+	KeyFingerprint struct {
+		// Selector is the DKIM selector for DKIM keys and the MX host name for
+		// TLS keys.
+		Selector    string
+		Algorithm   string
+		Fingerprint string
+	}
+
+	// Domain is a verified registry entry.
+	Domain struct {
+		Name     string
+		Owner    string
+		MXHosts  []MXHost
+		DKIMKeys []KeyFingerprint
+		TLSKeys  []KeyFingerprint
+	}
+)
+
+// Registry provides read access to the on-chain mail server registry.
+type Registry interface {
+	// Domain returns the registry entry of a verified domain.
+	//
+	// ErrNotRegistered is returned if the domain is not registered or its
+	// registration is not verified yet.
+	Domain(ctx context.Context, name string) (*Domain, error)
+}
+
+// New creates the Registry using the arguments of the 'registry' directive.
+//
+//	registry jsonrpc URL
+//	registry grpc ADDRESS
+//	registry local
+func New(args []string) (Registry, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("chainreg: backend type is required")
+	}
+	switch kind := args[0]; kind {
+	case "jsonrpc":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("chainreg: jsonrpc: exactly one URL argument is required")
+		}
+		return NewJSONRPC(args[1])
+	case "grpc":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("chainreg: grpc: exactly one address argument is required")
+		}
+		return NewGRPC(args[1])
+	case "local":
+		if len(args) != 1 {
+			return nil, fmt.Errorf("chainreg: local: no arguments expected")
+		}
+		return localRegistry{}, nil
+	default:
+		return nil, fmt.Errorf("chainreg: unknown backend: %v", kind)
+	}
+}
+
+// NormalizeDomain converts the domain name into the form used as a registry
+// key.
+func NormalizeDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// HasMX reports whether host is one of the declared mail exchangers.
+func (d *Domain) HasMX(host string) bool {
+	host = NormalizeDomain(host)
+	for _, mx := range d.MXHosts {
+		if NormalizeDomain(mx.Host) == host {
+			return true
+		}
+	}
+	return false
+}
+
+// TLSPins returns the TLS key fingerprints declared for the MX host.
+func (d *Domain) TLSPins(host string) []KeyFingerprint {
+	host = NormalizeDomain(host)
+	var pins []KeyFingerprint
+	for _, k := range d.TLSKeys {
+		if NormalizeDomain(k.Selector) == host {
+			pins = append(pins, k)
+		}
+	}
+	return pins
+}
+
+// MatchCert reports whether the certificate matches one of pins. Both the
+// digest of the whole certificate and the digest of its SubjectPublicKeyInfo
+// (SPKI pin) are accepted.
+func MatchCert(pins []KeyFingerprint, cert *x509.Certificate) bool {
+	certSum := sha256.Sum256(cert.Raw)
+	spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+	certHex := hex.EncodeToString(certSum[:])
+	spkiHex := hex.EncodeToString(spkiSum[:])
+
+	for _, p := range pins {
+		if p.Algorithm != FingerprintSHA256 {
+			continue
+		}
+		fp := strings.ToLower(strings.ReplaceAll(p.Fingerprint, ":", ""))
+		if fp == certHex || fp == spkiHex {
+			return true
+		}
+	}
+	return false
+}
+
+var (
+	localLck sync.RWMutex
+	local    Registry
+)
+
+// SetLocal sets the Registry used by the 'local' backend. It is called by
+// the chain application when the node runs in the same process.
+func SetLocal(r Registry) {
+	localLck.Lock()
+	defer localLck.Unlock()
+	local = r
+}
+
+type localRegistry struct{}
+
+func (localRegistry) Domain(ctx context.Context, name string) (*Domain, error) {
+	localLck.RLock()
+	r := local
+	localLck.RUnlock()
+
+	if r == nil {
+		return nil, errors.New("chainreg: local: no in-process node is running")
+	}
+	return r.Domain(ctx, name)
+}
+
+type cacheEntry struct {
+	domain  *Domain
+	err     error
+	expires time.Time
+}
+
+// Cached wraps the Registry to remember lookup results for ttl. Lookup errors
+// other than ErrNotRegistered are not cached.
+type Cached struct {
+	R   Registry
+	TTL time.Duration
+
+	lck     sync.Mutex
+	entries map[string]cacheEntry
+}
+
+func NewCached(r Registry, ttl time.Duration) *Cached {
+	return &Cached{
+		R:       r,
+		TTL:     ttl,
+		entries: make(map[string]cacheEntry),
+	}
+}
+
+func (c *Cached) Domain(ctx context.Context, name string) (*Domain, error) {
+	name = NormalizeDomain(name)
+	now := time.Now()
+
+	c.lck.Lock()
+	e, ok := c.entries[name]
+	c.lck.Unlock()
+	if ok && now.Before(e.expires) {
+		return e.domain, e.err
+	}
+
+	d, err := c.R.Domain(ctx, name)
+	if err != nil && !errors.Is(err, ErrNotRegistered) {
+		return nil, err
+	}
+
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	c.entries[name] = cacheEntry{domain: d, err: err, expires: now.Add(c.TTL)}
+	// Drop expired entries once the map grows, this keeps memory usage
+	// bounded by the number of domains seen within TTL.
+	if len(c.entries) > 1024 {
+		for k, v := range c.entries {
+			if now.After(v.expires) {
+				delete(c.entries, k)
+			}
+		}
+	}
+	return d, err
+}
+
+// Close closes the wrapped Registry if it holds any resources.
+func (c *Cached) Close() error {
+	if closer, ok := c.R.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
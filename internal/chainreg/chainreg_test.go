@@ -0,0 +1,206 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package chainreg
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+)
+
+func testCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.example.org"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestMatchCert(t *testing.T) {
+	cert := testCert(t)
+	certSum := sha256.Sum256(cert.Raw)
+	spkiSum := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	d := &Domain{
+		TLSKeys: []KeyFingerprint{
+			{Selector: "mx1.example.org", Algorithm: FingerprintSHA256, Fingerprint: hex.EncodeToString(certSum[:])},
+			{Selector: "MX2.example.org.", Algorithm: FingerprintSHA256, Fingerprint: hex.EncodeToString(spkiSum[:])},
+			{Selector: "mx3.example.org", Algorithm: FingerprintSHA256, Fingerprint: hex.EncodeToString(make([]byte, 32))},
+		},
+	}
+
+	if !MatchCert(d.TLSPins("mx1.example.org."), cert) {
+		t.Error("certificate digest pin does not match")
+	}
+	if !MatchCert(d.TLSPins("mx2.example.org"), cert) {
+		t.Error("SPKI pin does not match")
+	}
+	if MatchCert(d.TLSPins("mx3.example.org"), cert) {
+		t.Error("wrong pin matches")
+	}
+	if pins := d.TLSPins("mx4.example.org"); len(pins) != 0 {
+		t.Errorf("unexpected pins for undeclared host: %v", pins)
+	}
+}
+
+func TestDomain_HasMX(t *testing.T) {
+	d := &Domain{MXHosts: []MXHost{{Host: "mx1.example.org", Preference: 10}}}
+	if !d.HasMX("MX1.example.org.") {
+		t.Error("declared MX is not matched")
+	}
+	if d.HasMX("mx2.example.org") {
+		t.Error("undeclared MX is matched")
+	}
+}
+
+type countingRegistry struct {
+	calls int
+	err   error
+}
+
+func (r *countingRegistry) Domain(_ context.Context, name string) (*Domain, error) {
+	r.calls++
+	if r.err != nil {
+		return nil, r.err
+	}
+	if name != "example.org" {
+		return nil, ErrNotRegistered
+	}
+	return &Domain{Name: name}, nil
+}
+
+func TestCached(t *testing.T) {
+	r := &countingRegistry{}
+	c := NewCached(r, time.Minute)
+
+	for i := 0; i < 3; i++ {
+		d, err := c.Domain(context.Background(), "Example.org.")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.Name != "example.org" {
+			t.Fatalf("wrong domain: %v", d.Name)
+		}
+		if _, err := c.Domain(context.Background(), "example.net"); !errors.Is(err, ErrNotRegistered) {
+			t.Fatalf("expected ErrNotRegistered, got %v", err)
+		}
+	}
+	if r.calls != 2 {
+		t.Errorf("expected 2 lookups, got %d", r.calls)
+	}
+
+	// Transport errors are not cached.
+	r.err = errors.New("unavailable")
+	for i := 0; i < 2; i++ {
+		if _, err := c.Domain(context.Background(), "example.com"); err == nil {
+			t.Fatal("expected error")
+		}
+	}
+	if r.calls != 4 {
+		t.Errorf("expected 4 lookups, got %d", r.calls)
+	}
+}
+
+func TestNew(t *testing.T) {
+	for _, args := range [][]string{
+		nil,
+		{"unknown"},
+		{"jsonrpc"},
+		{"grpc", "a", "b"},
+		{"local", "x"},
+	} {
+		if _, err := New(args); err == nil {
+			t.Errorf("expected error for %v", args)
+		}
+	}
+
+	r, err := New([]string{"local"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := r.Domain(context.Background(), "example.org"); err == nil {
+		t.Fatal("expected error without in-process node")
+	}
+	SetLocal(&countingRegistry{})
+	defer SetLocal(nil)
+	if _, err := r.Domain(context.Background(), "example.org"); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestJSONRPC_DecodeDomain(t *testing.T) {
+	j, err := NewJSONRPC("http://127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer j.Close()
+
+	type kf = abiKeyFingerprint
+	in := abiDomainInfo{
+		Name:   "example.org",
+		Status: 2,
+		MxHosts: []struct {
+			Host       string `abi:"host"`
+			Preference uint32 `abi:"preference"`
+		}{{Host: "mx.example.org", Preference: 10}},
+		TlsKeys: []kf{{Selector: "mx.example.org", Algorithm: FingerprintSHA256, Fingerprint: "ab"}},
+	}
+	in.Bond.Amount = big.NewInt(0)
+
+	packed, err := j.abi.Methods["getDomain"].Outputs.Pack(in)
+	if err != nil {
+		t.Fatal(err)
+	}
+	vals, err := j.abi.Unpack("getDomain", packed)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var res struct{ Domain abiDomainInfo }
+	if err := j.abi.Methods["getDomain"].Outputs.Copy(&res, vals); err != nil {
+		t.Fatal(err)
+	}
+	out := res.Domain
+	if out.Name != in.Name || len(out.MxHosts) != 1 || out.MxHosts[0].Preference != 10 || out.TlsKeys[0].Selector != "mx.example.org" {
+		t.Fatalf("decoded value mismatch: %+v", out)
+	}
+}
@@ -0,0 +1,125 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package chainreg
+
+import (
+	"context"
+	"crypto/tls"
+	"fmt"
+	"strings"
+
+	gogoproto "github.com/cosmos/gogoproto/proto"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/status"
+
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+// gogoCodec marshals gogoproto-generated messages used by the node API.
+type gogoCodec struct{}
+
+func (gogoCodec) Marshal(v interface{}) ([]byte, error) {
+	msg, ok := v.(gogoproto.Message)
+	if !ok {
+		return nil, fmt.Errorf("chainreg: grpc: cannot marshal %T", v)
+	}
+	return gogoproto.Marshal(msg)
+}
+
+func (gogoCodec) Unmarshal(data []byte, v interface{}) error {
+	msg, ok := v.(gogoproto.Message)
+	if !ok {
+		return fmt.Errorf("chainreg: grpc: cannot unmarshal into %T", v)
+	}
+	return gogoproto.Unmarshal(data, msg)
+}
+
+func (gogoCodec) Name() string {
+	return "proto"
+}
+
+// GRPC reads the registry using the x/mail query service of the node gRPC
+// API.
+type GRPC struct {
+	conn   *grpc.ClientConn
+	client mailtypes.QueryClient
+}
+
+// NewGRPC connects to the node gRPC endpoint. The address is used as is
+// for plaintext connections, tls:// prefix enables TLS.
+func NewGRPC(addr string) (*GRPC, error) {
+	creds := insecure.NewCredentials()
+	if strings.HasPrefix(addr, "tls://") {
+		addr = strings.TrimPrefix(addr, "tls://")
+		creds = credentials.NewTLS(&tls.Config{MinVersion: tls.VersionTLS12})
+	}
+
+	conn, err := grpc.NewClient(addr,
+		grpc.WithTransportCredentials(creds),
+		grpc.WithDefaultCallOptions(grpc.ForceCodec(gogoCodec{})))
+	if err != nil {
+		return nil, fmt.Errorf("chainreg: grpc: %w", err)
+	}
+	return &GRPC{
+		conn:   conn,
+		client: mailtypes.NewQueryClient(conn),
+	}, nil
+}
+
+func (g *GRPC) Domain(ctx context.Context, name string) (*Domain, error) {
+	res, err := g.client.Domain(ctx, &mailtypes.QueryDomainRequest{Name: NormalizeDomain(name)})
+	if err != nil {
+		if status.Code(err) == codes.NotFound {
+			return nil, ErrNotRegistered
+		}
+		return nil, fmt.Errorf("chainreg: grpc: %w", err)
+	}
+	return FromModule(res.Domain)
+}
+
+func (g *GRPC) Close() error {
+	return g.conn.Close()
+}
+
+// FromModule converts the x/mail registry entry into Domain.
+//
+// ErrNotRegistered is returned for entries that are not verified.
+func FromModule(md mailtypes.Domain) (*Domain, error) {
+	if !md.IsVerified() {
+		return nil, ErrNotRegistered
+	}
+
+	d := &Domain{
+		Name:  md.Name,
+		Owner: md.Owner,
+	}
+	for _, mx := range md.MxHosts {
+		d.MXHosts = append(d.MXHosts, MXHost{Host: mx.Host, Preference: mx.Preference})
+	}
+	for _, k := range md.DkimKeys {
+		d.DKIMKeys = append(d.DKIMKeys, KeyFingerprint{Selector: k.Selector, Algorithm: k.Algorithm, Fingerprint: k.Fingerprint})
+	}
+	for _, k := range md.TlsKeys {
+		d.TLSKeys = append(d.TLSKeys, KeyFingerprint{Selector: k.Selector, Algorithm: k.Algorithm, Fingerprint: k.Fingerprint})
+	}
+	return d, nil
+}
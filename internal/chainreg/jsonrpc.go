@@ -0,0 +1,171 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package chainreg
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/ethclient"
+)
+
+// PrecompileAddress is the address of the mail registry precompile.
+const PrecompileAddress = "0x0000000000000000000000000000000000000809"
+
+// precompileABI is the subset of the IMail interface used by the JSON-RPC
+// backend.
+const precompileABI = `[
+{"type":"function","name":"isVerified","stateMutability":"view",
+ "inputs":[{"name":"name","type":"string"}],
+ "outputs":[{"name":"verified","type":"bool"}]},
+{"type":"function","name":"getDomain","stateMutability":"view",
+ "inputs":[{"name":"name","type":"string"}],
+ "outputs":[{"name":"domain","type":"tuple","components":[
+  {"name":"name","type":"string"},
+  {"name":"owner","type":"address"},
+  {"name":"status","type":"uint8"},
+  {"name":"challenge","type":"string"},
+  {"name":"challengeExpiry","type":"int64"},
+  {"name":"verifiedBy","type":"uint8"},
+  {"name":"verifiedHeight","type":"int64"},
+  {"name":"bond","type":"tuple","components":[
+   {"name":"denom","type":"string"},{"name":"amount","type":"uint256"}]},
+  {"name":"mxHosts","type":"tuple[]","components":[
+   {"name":"host","type":"string"},{"name":"preference","type":"uint32"}]},
+  {"name":"dkimKeys","type":"tuple[]","components":[
+   {"name":"selector","type":"string"},{"name":"algorithm","type":"string"},{"name":"fingerprint","type":"string"}]},
+  {"name":"tlsKeys","type":"tuple[]","components":[
+   {"name":"selector","type":"string"},{"name":"algorithm","type":"string"},{"name":"fingerprint","type":"string"}]}
+ ]}]}
+]`
+
+type abiKeyFingerprint struct {
+	Selector    string `abi:"selector"`
+	Algorithm   string `abi:"algorithm"`
+	Fingerprint string `abi:"fingerprint"`
+}
+
+type abiDomainInfo struct {
+	Name            string         `abi:"name"`
+	Owner           common.Address `abi:"owner"`
+	Status          uint8          `abi:"status"`
+	Challenge       string         `abi:"challenge"`
+	ChallengeExpiry int64          `abi:"challengeExpiry"`
+	VerifiedBy      uint8          `abi:"verifiedBy"`
+	VerifiedHeight  int64          `abi:"verifiedHeight"`
+	Bond            struct {
+		Denom  string   `abi:"denom"`
+		Amount *big.Int `abi:"amount"`
+	} `abi:"bond"`
+	MxHosts []struct {
+		Host       string `abi:"host"`
+		Preference uint32 `abi:"preference"`
+	} `abi:"mxHosts"`
+	DkimKeys []abiKeyFingerprint `abi:"dkimKeys"`
+	TlsKeys  []abiKeyFingerprint `abi:"tlsKeys"`
+}
+
+// JSONRPC reads the registry by calling the mail precompile through the EVM
+// JSON-RPC API.
+type JSONRPC struct {
+	client *ethclient.Client
+	abi    abi.ABI
+	addr   common.Address
+}
+
+func NewJSONRPC(url string) (*JSONRPC, error) {
+	parsed, err := abi.JSON(strings.NewReader(precompileABI))
+	if err != nil {
+		return nil, fmt.Errorf("chainreg: jsonrpc: %w", err)
+	}
+	client, err := ethclient.Dial(url)
+	if err != nil {
+		return nil, fmt.Errorf("chainreg: jsonrpc: %w", err)
+	}
+	return &JSONRPC{
+		client: client,
+		abi:    parsed,
+		addr:   common.HexToAddress(PrecompileAddress),
+	}, nil
+}
+
+func (j *JSONRPC) call(ctx context.Context, method string, out interface{}, args ...interface{}) error {
+	data, err := j.abi.Pack(method, args...)
+	if err != nil {
+		return err
+	}
+	res, err := j.client.CallContract(ctx, ethereum.CallMsg{To: &j.addr, Data: data}, nil)
+	if err != nil {
+		return fmt.Errorf("chainreg: jsonrpc: %s: %w", method, err)
+	}
+	vals, err := j.abi.Unpack(method, res)
+	if err != nil {
+		return fmt.Errorf("chainreg: jsonrpc: %s: %w", method, err)
+	}
+	if len(vals) != 1 {
+		return fmt.Errorf("chainreg: jsonrpc: %s: unexpected number of results: %d", method, len(vals))
+	}
+	return j.abi.Methods[method].Outputs.Copy(out, vals)
+}
+
+func (j *JSONRPC) Domain(ctx context.Context, name string) (*Domain, error) {
+	name = NormalizeDomain(name)
+
+	// getDomain reverts for unknown domains, check for the verified entry
+	// first to tell these apart from transport errors.
+	var verified bool
+	if err := j.call(ctx, "isVerified", &verified, name); err != nil {
+		return nil, err
+	}
+	if !verified {
+		return nil, ErrNotRegistered
+	}
+
+	// Single struct results are copied into the first field of out.
+	var res struct{ Domain abiDomainInfo }
+	if err := j.call(ctx, "getDomain", &res, name); err != nil {
+		return nil, err
+	}
+	info := res.Domain
+
+	d := &Domain{
+		Name:  info.Name,
+		Owner: info.Owner.Hex(),
+	}
+	for _, mx := range info.MxHosts {
+		d.MXHosts = append(d.MXHosts, MXHost{Host: mx.Host, Preference: mx.Preference})
+	}
+	for _, k := range info.DkimKeys {
+		d.DKIMKeys = append(d.DKIMKeys, KeyFingerprint(k))
+	}
+	for _, k := range info.TlsKeys {
+		d.TLSKeys = append(d.TLSKeys, KeyFingerprint(k))
+	}
+	return d, nil
+}
+
+func (j *JSONRPC) Close() error {
+	j.client.Close()
+	return nil
+}
@@ -0,0 +1,251 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package postage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"fmt"
+	"math/big"
+	"strings"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// escrowABI is the subset of the contracts/postage/PostageEscrow.sol ABI used
+// by the check.
+const escrowABI = `[
+	{"type":"function","name":"deposit","stateMutability":"payable","inputs":[{"name":"id","type":"bytes32"},{"name":"recipient","type":"address"}],"outputs":[]},
+	{"type":"function","name":"deposits","stateMutability":"view","inputs":[{"name":"","type":"bytes32"}],"outputs":[{"name":"sender","type":"address"},{"name":"recipient","type":"address"},{"name":"amount","type":"uint256"},{"name":"state","type":"uint8"}]},
+	{"type":"function","name":"claim","stateMutability":"nonpayable","inputs":[{"name":"id","type":"bytes32"}],"outputs":[]},
+	{"type":"function","name":"refund","stateMutability":"nonpayable","inputs":[{"name":"id","type":"bytes32"}],"outputs":[]}
+]`
+
+var parsedABI = func() abi.ABI {
+	a, err := abi.JSON(strings.NewReader(escrowABI))
+	if err != nil {
+		panic(err)
+	}
+	return a
+}()
+
+// State is the state of the escrowed postage.
+type State uint8
+
+const (
+	StateNone State = iota
+	StateHeld
+	StateClaimed
+	StateRefunded
+)
+
+func (s State) String() string {
+	switch s {
+	case StateNone:
+		return "none"
+	case StateHeld:
+		return "held"
+	case StateClaimed:
+		return "claimed"
+	case StateRefunded:
+		return "refunded"
+	}
+	return fmt.Sprintf("unknown(%d)", uint8(s))
+}
+
+// Deposit is the postage record stored by the escrow contract.
+type Deposit struct {
+	Sender    common.Address
+	Recipient common.Address
+	Amount    *big.Int
+	State     State
+}
+
+var (
+	ErrWrongContract  = errors.New("transaction is not sent to the escrow contract")
+	ErrWrongCall      = errors.New("transaction is not an escrow deposit")
+	ErrWrongRecipient = errors.New("postage is not escrowed to the recipient")
+	ErrLowAmount      = errors.New("postage amount is too low")
+	ErrNotHeld        = errors.New("postage is not held by the escrow")
+)
+
+// ID returns the postage identifier for the message and the recipient
+// address: keccak256(Message-ID || recipient). Message-ID is used without
+// angle brackets and surrounding whitespace.
+func ID(msgID string, recipient common.Address) common.Hash {
+	msgID = strings.TrimSpace(msgID)
+	msgID = strings.TrimSuffix(strings.TrimPrefix(msgID, "<"), ">")
+	return crypto.Keccak256Hash([]byte(msgID), recipient.Bytes())
+}
+
+// Escrow is a client for the postage escrow contract.
+type Escrow struct {
+	Chain   module.BlockChain
+	Address common.Address
+}
+
+// Deposit returns the escrow record for the postage ID. Unknown IDs are
+// returned as a record with StateNone.
+func (e Escrow) Deposit(ctx context.Context, id common.Hash) (*Deposit, error) {
+	data, err := parsedABI.Pack("deposits", id)
+	if err != nil {
+		return nil, err
+	}
+	res, err := e.Chain.CallContract(ctx, e.Address.Hex(), hexutil.Encode(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := hexutil.Decode(res)
+	if err != nil {
+		return nil, fmt.Errorf("malformed deposits result: %w", err)
+	}
+	var d struct {
+		Sender    common.Address
+		Recipient common.Address
+		Amount    *big.Int
+		State     uint8
+	}
+	if err := parsedABI.UnpackIntoInterface(&d, "deposits", out); err != nil {
+		return nil, fmt.Errorf("malformed deposits result: %w", err)
+	}
+	return &Deposit{
+		Sender:    d.Sender,
+		Recipient: d.Recipient,
+		Amount:    d.Amount,
+		State:     State(d.State),
+	}, nil
+}
+
+// CheckHeld verifies that the postage is held by the escrow for the recipient
+// and is at least price.
+func (e Escrow) CheckHeld(ctx context.Context, id common.Hash, recipient common.Address, price *big.Int) (*Deposit, error) {
+	d, err := e.Deposit(ctx, id)
+	if err != nil {
+		return nil, err
+	}
+	if d.State != StateHeld {
+		return nil, fmt.Errorf("%w: %s", ErrNotHeld, d.State)
+	}
+	if d.Recipient != recipient {
+		return nil, ErrWrongRecipient
+	}
+	if d.Amount.Cmp(price) < 0 {
+		return nil, ErrLowAmount
+	}
+	return d, nil
+}
+
+// DepositTx is a signed escrow deposit transaction.
+type DepositTx struct {
+	Tx        *types.Transaction
+	ID        common.Hash
+	Sender    common.Address
+	Recipient common.Address
+}
+
+// DecodeDepositTx decodes the signed raw transaction and verifies that it
+// calls deposit on the escrow contract with a valid signature for chainID.
+func (e Escrow) DecodeDepositTx(rawTx []byte, chainID *big.Int) (*DepositTx, error) {
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(rawTx); err != nil {
+		return nil, fmt.Errorf("malformed transaction: %w", err)
+	}
+	if tx.To() == nil || *tx.To() != e.Address {
+		return nil, ErrWrongContract
+	}
+	method := parsedABI.Methods["deposit"]
+	data := tx.Data()
+	if len(data) < 4 || !bytes.Equal(data[:4], method.ID) {
+		return nil, ErrWrongCall
+	}
+	args, err := method.Inputs.Unpack(data[4:])
+	if err != nil {
+		return nil, fmt.Errorf("%w: %v", ErrWrongCall, err)
+	}
+
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		return nil, fmt.Errorf("invalid transaction signature: %w", err)
+	}
+	return &DepositTx{
+		Tx:        tx,
+		ID:        common.Hash(args[0].([32]byte)),
+		Sender:    sender,
+		Recipient: args[1].(common.Address),
+	}, nil
+}
+
+// Claim sends the transaction transferring the postage to the recipient. key
+// should be the recipient key.
+func (e Escrow) Claim(ctx context.Context, id common.Hash, key *ecdsa.PrivateKey, gasLimit uint64) (common.Hash, error) {
+	return e.send(ctx, "claim", id, key, gasLimit)
+}
+
+// Refund sends the transaction returning the postage to the sender. key
+// should be the recipient key.
+func (e Escrow) Refund(ctx context.Context, id common.Hash, key *ecdsa.PrivateKey, gasLimit uint64) (common.Hash, error) {
+	return e.send(ctx, "refund", id, key, gasLimit)
+}
+
+func (e Escrow) send(ctx context.Context, method string, id common.Hash, key *ecdsa.PrivateKey, gasLimit uint64) (common.Hash, error) {
+	data, err := parsedABI.Pack(method, id)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	from := crypto.PubkeyToAddress(key.PublicKey)
+
+	chainID, err := e.Chain.ChainID(ctx)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("chain ID: %w", err)
+	}
+	nonce, err := e.Chain.PendingNonce(ctx, from.Hex())
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("nonce: %w", err)
+	}
+	gasPrice, err := e.Chain.SuggestGasPrice(ctx)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("gas price: %w", err)
+	}
+
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(chainID), &types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: gasPrice,
+		Gas:      gasLimit,
+		To:       &e.Address,
+		Data:     data,
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := e.Chain.SendRawTx(ctx, hexutil.Encode(raw)); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
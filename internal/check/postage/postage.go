@@ -0,0 +1,655 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package postage implements the check.postage module that requires senders
+// unknown to the recipient to pay postage escrowed on-chain.
+//
+// The postage is escrowed by the PostageEscrow contract (see
+// contracts/postage) to the EVM address of the recipient under the ID
+// keccak256(Message-ID || recipient address). The proof is attached using
+// the X-Postage header field, one per recipient:
+//
+//	X-Postage: tx=0x<signed raw deposit transaction>
+//	X-Postage: voucher=0x<postage ID of a deposit already on-chain>
+//
+// Transactions are verified and broadcasted by the check and accepted once
+// they are included in a block, vouchers are verified by reading the escrow
+// state. The recipient can then claim the
+// postage or refund it to the sender using the mailchatd postage command.
+//
+// Since the postage ID depends only on the Message-ID and the recipient,
+// accepted IDs are recorded in the consumed table together with the body
+// hash and any other message reusing them is rejected. Redelivery of the
+// same message is still accepted.
+//
+// Senders listed in the recipient contacts, authenticated users and
+// recipients without an EVM address are exempt.
+package postage
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"math/big"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target"
+)
+
+const (
+	modName = "check.postage"
+
+	proofHeader   = "X-Postage"
+	depositHeader = "X-Postage-Deposit"
+)
+
+type Check struct {
+	instName string
+	log      log.Logger
+
+	escrow              Escrow
+	price               *big.Int
+	gasLimit            uint64
+	contacts            module.Table
+	rcptAddress         module.Table
+	exemptAuthenticated bool
+	failAction          modconfig.FailAction
+	receiptTimeout      time.Duration
+	pollInterval        time.Duration
+
+	// consumed maps postage IDs of accepted messages to
+	// "<expiry unix time>:<body hash>".
+	consumed     module.MutableTable
+	consumedTTL  time.Duration
+	consumedLock sync.Mutex
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	if len(inlineArgs) != 0 {
+		return nil, fmt.Errorf("%s: inline arguments are not used", modName)
+	}
+	return &Check{
+		instName:     instName,
+		log:          log.Logger{Name: modName},
+		pollInterval: time.Second,
+	}, nil
+}
+
+func (c *Check) Name() string {
+	return modName
+}
+
+func (c *Check) InstanceName() string {
+	return c.instName
+}
+
+func (c *Check) Init(cfg *config.Map) error {
+	var (
+		escrowAddr, price string
+		gasLimit          int
+		consumed          module.Table
+	)
+	cfg.Bool("debug", true, false, &c.log.Debug)
+	cfg.Custom("blockchain", false, true, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var chain module.BlockChain
+		err := modconfig.ModuleFromNode("blockchain", node.Args, node, m.Globals, &chain)
+		return chain, err
+	}, &c.escrow.Chain)
+	cfg.String("escrow", false, true, "", &escrowAddr)
+	cfg.String("price", false, true, "", &price)
+	cfg.Int("gas_limit", false, false, 100000, &gasLimit)
+	cfg.Custom("contacts", false, false, nil, modconfig.TableDirective, &c.contacts)
+	cfg.Custom("recipient_address", false, false, nil, modconfig.TableDirective, &c.rcptAddress)
+	cfg.Bool("exempt_authenticated", false, true, &c.exemptAuthenticated)
+	cfg.Duration("receipt_timeout", false, false, 15*time.Second, &c.receiptTimeout)
+	cfg.Custom("consumed", false, true, nil, modconfig.TableDirective, &consumed)
+	cfg.Duration("consumed_ttl", false, false, 30*24*time.Hour, &c.consumedTTL)
+	cfg.Custom("fail_action", false, false, func() (interface{}, error) {
+		return modconfig.FailAction{Quarantine: true}, nil
+	}, modconfig.FailActionDirective, &c.failAction)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	if !common.IsHexAddress(escrowAddr) {
+		return fmt.Errorf("%s: invalid escrow address: %s", modName, escrowAddr)
+	}
+	c.escrow.Address = common.HexToAddress(escrowAddr)
+
+	var ok bool
+	c.price, ok = new(big.Int).SetString(price, 10)
+	if !ok || c.price.Sign() <= 0 {
+		return fmt.Errorf("%s: price should be a positive amount in wei: %s", modName, price)
+	}
+	if gasLimit <= 0 {
+		return fmt.Errorf("%s: gas_limit should be positive", modName)
+	}
+	c.gasLimit = uint64(gasLimit)
+
+	c.consumed, ok = consumed.(module.MutableTable)
+	if !ok {
+		return fmt.Errorf("%s: consumed table should be mutable", modName)
+	}
+	if c.consumedTTL <= 0 {
+		return fmt.Errorf("%s: consumed_ttl should be positive", modName)
+	}
+
+	if !module.NoRun {
+		c.stop = make(chan struct{})
+		c.done = make(chan struct{})
+		go c.purgeLoop()
+	}
+	return nil
+}
+
+func (c *Check) Close() error {
+	if c.stop != nil {
+		close(c.stop)
+		<-c.done
+		c.stop = nil
+	}
+	return nil
+}
+
+// purgeLoop removes expired entries from the consumed table.
+func (c *Check) purgeLoop() {
+	defer close(c.done)
+
+	ticker := time.NewTicker(time.Hour)
+	defer ticker.Stop()
+	for {
+		c.purgeConsumed(time.Now())
+
+		select {
+		case <-c.stop:
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
+func (c *Check) purgeConsumed(now time.Time) {
+	c.consumedLock.Lock()
+	defer c.consumedLock.Unlock()
+
+	keys, err := c.consumed.Keys()
+	if err != nil {
+		c.log.Error("failed to list consumed postage IDs", err)
+		return
+	}
+	for _, key := range keys {
+		_, ok, err := c.consumedHash(context.Background(), key, now)
+		if err != nil {
+			c.log.Error("failed to read consumed postage ID", err, "id", key)
+			continue
+		}
+		if ok {
+			continue
+		}
+		if err := c.consumed.RemoveKey(key); err != nil {
+			c.log.Error("failed to remove consumed postage ID", err, "id", key)
+		}
+	}
+}
+
+// consumedHash returns the body hash of the message that used the postage ID
+// if the entry is not expired.
+func (c *Check) consumedHash(ctx context.Context, key string, now time.Time) (string, bool, error) {
+	val, ok, err := c.consumed.Lookup(ctx, key)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	expiry, hash, found := strings.Cut(val, ":")
+	if !found {
+		// Malformed entries are dropped by the purge.
+		return "", false, nil
+	}
+	unix, err := strconv.ParseInt(expiry, 10, 64)
+	if err != nil || now.Unix() >= unix {
+		return "", false, nil
+	}
+	return hash, true, nil
+}
+
+// consume records the postage IDs as used by the message with the body hash.
+// It fails if any of them was used by a different message.
+func (c *Check) consume(ctx context.Context, ids []common.Hash, bodyHash string) error {
+	c.consumedLock.Lock()
+	defer c.consumedLock.Unlock()
+
+	now := time.Now()
+	for _, id := range ids {
+		hash, ok, err := c.consumedHash(ctx, id.Hex(), now)
+		if err != nil {
+			return err
+		}
+		if ok && hash != bodyHash {
+			return invalidf("postage %s is already used by another message", id.Hex())
+		}
+	}
+	val := strconv.FormatInt(now.Add(c.consumedTTL).Unix(), 10) + ":" + bodyHash
+	for _, id := range ids {
+		if err := c.consumed.SetKey(id.Hex(), val); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func hashBody(body buffer.Buffer) (string, error) {
+	r, err := body.Open()
+	if err != nil {
+		return "", err
+	}
+	defer r.Close()
+
+	h := sha256.New()
+	if _, err := io.Copy(h, r); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
+
+// Escrow returns the escrow contract client used by the check.
+func (c *Check) Escrow() Escrow {
+	return c.escrow
+}
+
+// GasLimit returns the gas limit for claim and refund transactions.
+func (c *Check) GasLimit() uint64 {
+	return c.gasLimit
+}
+
+// RecipientAddress returns the EVM address of the recipient using the
+// recipient_address table or, if it has no entry, the local part of the
+// address. ok is false if the recipient has no EVM address.
+func (c *Check) RecipientAddress(ctx context.Context, rcpt string) (addr common.Address, ok bool, err error) {
+	rcpt = strings.ToLower(rcpt)
+	if c.rcptAddress != nil {
+		val, found, err := c.rcptAddress.Lookup(ctx, rcpt)
+		if err != nil {
+			return common.Address{}, false, err
+		}
+		if found {
+			if !common.IsHexAddress(val) {
+				return common.Address{}, false, fmt.Errorf("%s: invalid EVM address for %s: %s", modName, rcpt, val)
+			}
+			return common.HexToAddress(val), true, nil
+		}
+	}
+
+	local, _, err := address.Split(rcpt)
+	if err != nil {
+		return common.Address{}, false, nil
+	}
+	if !common.IsHexAddress(local) {
+		return common.Address{}, false, nil
+	}
+	return common.HexToAddress(local), true, nil
+}
+
+// isContact reports whether the sender is listed in the recipient contacts
+// either by the address or by the "@domain" entry.
+func (c *Check) isContact(ctx context.Context, rcpt, sender string) (bool, error) {
+	if c.contacts == nil || sender == "" {
+		return false, nil
+	}
+
+	var contacts []string
+	if multi, ok := c.contacts.(module.MultiTable); ok {
+		vals, err := multi.LookupMulti(ctx, strings.ToLower(rcpt))
+		if err != nil {
+			return false, err
+		}
+		contacts = vals
+	} else {
+		val, ok, err := c.contacts.Lookup(ctx, strings.ToLower(rcpt))
+		if err != nil {
+			return false, err
+		}
+		if ok {
+			contacts = strings.Split(val, ",")
+		}
+	}
+
+	sender = strings.ToLower(sender)
+	_, domain, err := address.Split(sender)
+	if err != nil {
+		return false, nil
+	}
+	for _, contact := range contacts {
+		contact = strings.ToLower(strings.TrimSpace(contact))
+		if contact == sender || contact == "@"+domain {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+type recipient struct {
+	rcpt string
+	addr common.Address
+}
+
+type state struct {
+	c       *Check
+	msgMeta *module.MsgMetadata
+	log     log.Logger
+
+	exempt bool
+	sender string
+	// Recipients that need postage.
+	rcpts []recipient
+}
+
+func (c *Check) CheckStateForMsg(_ context.Context, msgMeta *module.MsgMetadata) (module.CheckState, error) {
+	s := &state{
+		c:       c,
+		msgMeta: msgMeta,
+		log:     target.DeliveryLogger(c.log, msgMeta),
+	}
+	if msgMeta.Conn == nil {
+		// Locally generated message.
+		s.exempt = true
+	} else if c.exemptAuthenticated && msgMeta.Conn.AuthUser != "" {
+		s.exempt = true
+	}
+	return s, nil
+}
+
+func internalErr(err error) module.CheckResult {
+	return module.CheckResult{
+		Reason: &exterrors.SMTPError{
+			Code:         451,
+			EnhancedCode: exterrors.EnhancedCode{4, 7, 1},
+			Message:      "Internal error during postage check",
+			CheckName:    modName,
+			Err:          err,
+		},
+	}
+}
+
+func (s *state) CheckConnection(_ context.Context) module.CheckResult {
+	return module.CheckResult{}
+}
+
+func (s *state) CheckSender(_ context.Context, mailFrom string) module.CheckResult {
+	s.sender = mailFrom
+	return module.CheckResult{}
+}
+
+func (s *state) CheckRcpt(ctx context.Context, rcpt string) module.CheckResult {
+	if s.exempt {
+		return module.CheckResult{}
+	}
+
+	addr, ok, err := s.c.RecipientAddress(ctx, rcpt)
+	if err != nil {
+		return internalErr(err)
+	}
+	if !ok {
+		s.log.DebugMsg("recipient has no EVM address, postage is not required", "rcpt", rcpt)
+		return module.CheckResult{}
+	}
+
+	contact, err := s.c.isContact(ctx, rcpt, s.sender)
+	if err != nil {
+		return internalErr(err)
+	}
+	if contact {
+		s.log.DebugMsg("sender is a contact of the recipient", "rcpt", rcpt, "sender", s.sender)
+		return module.CheckResult{}
+	}
+
+	s.rcpts = append(s.rcpts, recipient{rcpt: rcpt, addr: addr})
+	return module.CheckResult{}
+}
+
+// parseProofs returns raw deposit transactions and voucher IDs from the
+// X-Postage header fields.
+func parseProofs(h textproto.Header) (txs [][]byte, vouchers []common.Hash, err error) {
+	for _, val := range h.Values(proofHeader) {
+		kind, value, ok := strings.Cut(strings.TrimSpace(val), "=")
+		if !ok {
+			return nil, nil, fmt.Errorf("malformed %s field: %s", proofHeader, val)
+		}
+		blob, err := hexutil.Decode(strings.TrimSpace(value))
+		if err != nil {
+			return nil, nil, fmt.Errorf("malformed %s field: %w", proofHeader, err)
+		}
+		switch strings.ToLower(strings.TrimSpace(kind)) {
+		case "tx":
+			txs = append(txs, blob)
+		case "voucher":
+			if len(blob) != common.HashLength {
+				return nil, nil, fmt.Errorf("malformed %s voucher: invalid length", proofHeader)
+			}
+			vouchers = append(vouchers, common.BytesToHash(blob))
+		default:
+			return nil, nil, fmt.Errorf("unknown %s proof: %s", proofHeader, kind)
+		}
+	}
+	return txs, vouchers, nil
+}
+
+// invalidError is returned by verify for missing or invalid postage, other
+// errors are failures to talk to the chain.
+type invalidError struct {
+	error
+}
+
+func invalidf(format string, args ...interface{}) error {
+	return invalidError{fmt.Errorf(format, args...)}
+}
+
+// pendingError is returned by verify if the deposit transaction is not
+// included in a block yet.
+type pendingError struct {
+	error
+}
+
+// checkHeld wraps Escrow.CheckHeld, reporting invalid deposits using
+// invalidError.
+func (s *state) checkHeld(ctx context.Context, r recipient, id common.Hash) (*Deposit, error) {
+	d, err := s.c.escrow.CheckHeld(ctx, id, r.addr, s.c.price)
+	if err != nil {
+		if errors.Is(err, ErrNotHeld) || errors.Is(err, ErrWrongRecipient) || errors.Is(err, ErrLowAmount) {
+			return nil, invalidf("%s: %w", r.rcpt, err)
+		}
+		return nil, err
+	}
+	return d, nil
+}
+
+// verify checks the postage for all recipients and returns the postage IDs.
+func (s *state) verify(ctx context.Context, msgID string, txs [][]byte, vouchers []common.Hash) ([]common.Hash, error) {
+	var chainID *big.Int
+	deposits := make(map[common.Hash]*DepositTx, len(txs))
+	for _, raw := range txs {
+		if chainID == nil {
+			var err error
+			chainID, err = s.c.escrow.Chain.ChainID(ctx)
+			if err != nil {
+				return nil, err
+			}
+		}
+		dtx, err := s.c.escrow.DecodeDepositTx(raw, chainID)
+		if err != nil {
+			return nil, invalidError{err}
+		}
+		deposits[dtx.ID] = dtx
+	}
+
+	ids := make([]common.Hash, 0, len(s.rcpts))
+	for _, r := range s.rcpts {
+		id := ID(msgID, r.addr)
+
+		if dtx, ok := deposits[id]; ok {
+			if dtx.Recipient != r.addr {
+				return nil, invalidf("%s: %w", r.rcpt, ErrWrongRecipient)
+			}
+			if dtx.Tx.Value().Cmp(s.c.price) < 0 {
+				return nil, invalidf("%s: %w", r.rcpt, ErrLowAmount)
+			}
+			raw, _ := dtx.Tx.MarshalBinary()
+			hash := dtx.Tx.Hash().Hex()
+			if err := s.c.escrow.Chain.SendRawTx(ctx, hexutil.Encode(raw)); err != nil {
+				// The sender might have broadcasted it already, the receipt
+				// tells whether it was included.
+				s.log.DebugMsg("deposit broadcast failed, waiting for the receipt", "rcpt", r.rcpt, "reason", err.Error())
+			}
+
+			// Acceptance by the node does not mean the deposit will be
+			// made: the transaction can be replaced, never mined or
+			// reverted.
+			receipt, err := module.WaitReceipt(ctx, s.c.escrow.Chain, hash, s.c.receiptTimeout, s.c.pollInterval)
+			if err != nil {
+				if errors.Is(err, module.ErrTxPending) {
+					return nil, pendingError{fmt.Errorf("%s: deposit %s is not included in a block yet", r.rcpt, hash)}
+				}
+				return nil, err
+			}
+			if !receipt.Success {
+				return nil, invalidf("%s: deposit transaction %s reverted", r.rcpt, hash)
+			}
+			if _, err := s.checkHeld(ctx, r, id); err != nil {
+				return nil, err
+			}
+			s.log.Msg("postage deposited", "rcpt", r.rcpt, "id", id.Hex(), "sender_addr", dtx.Sender.Hex(),
+				"amount", dtx.Tx.Value().String(), "tx", dtx.Tx.Hash().Hex())
+			ids = append(ids, id)
+			continue
+		}
+
+		found := false
+		for _, v := range vouchers {
+			if v == id {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return nil, invalidf("%s: no postage", r.rcpt)
+		}
+		d, err := s.checkHeld(ctx, r, id)
+		if err != nil {
+			return nil, err
+		}
+		s.log.Msg("postage voucher accepted", "rcpt", r.rcpt, "id", id.Hex(), "sender_addr", d.Sender.Hex(),
+			"amount", d.Amount.String())
+		ids = append(ids, id)
+	}
+	return ids, nil
+}
+
+func (s *state) CheckBody(ctx context.Context, h textproto.Header, body buffer.Buffer) module.CheckResult {
+	if len(s.rcpts) == 0 {
+		return module.CheckResult{}
+	}
+
+	fail := func(msg string, err error) module.CheckResult {
+		s.log.Msg("postage check failed", "reason", err.Error())
+		return s.c.failAction.Apply(module.CheckResult{
+			Reason: &exterrors.SMTPError{
+				Code:         550,
+				EnhancedCode: exterrors.EnhancedCode{5, 7, 1},
+				Message:      msg,
+				CheckName:    modName,
+				Err:          err,
+			},
+		})
+	}
+
+	msgID := h.Get("Message-Id")
+	if msgID == "" {
+		return fail("Postage is required but the message has no Message-ID", errors.New("no Message-ID"))
+	}
+	txs, vouchers, err := parseProofs(h)
+	if err != nil {
+		return fail("Malformed postage", err)
+	}
+
+	ids, err := s.verify(ctx, msgID, txs, vouchers)
+	if err != nil {
+		var (
+			invalid invalidError
+			pending pendingError
+		)
+		if errors.As(err, &invalid) {
+			return fail("Postage is required to deliver this message", invalid.error)
+		}
+		if errors.As(err, &pending) {
+			s.log.Msg("postage deposit is not confirmed yet", "reason", err.Error())
+			return module.CheckResult{
+				Reason: &exterrors.SMTPError{
+					Code:         451,
+					EnhancedCode: exterrors.EnhancedCode{4, 7, 1},
+					Message:      "Postage deposit is not confirmed yet, try again later",
+					CheckName:    modName,
+					Err:          pending.error,
+				},
+			}
+		}
+		return internalErr(err)
+	}
+
+	bodyHash, err := hashBody(body)
+	if err != nil {
+		return internalErr(err)
+	}
+	if err := s.c.consume(ctx, ids, bodyHash); err != nil {
+		var invalid invalidError
+		if errors.As(err, &invalid) {
+			return fail("Postage is already used by another message", invalid.error)
+		}
+		return internalErr(err)
+	}
+
+	res := module.CheckResult{}
+	for _, id := range ids {
+		res.Header.Add(depositHeader, id.Hex())
+	}
+	return res
+}
+
+func (s *state) Close() error {
+	return nil
+}
+
+func init() {
+	module.Register(modName, New)
+}
@@ -0,0 +1,470 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package postage
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/table"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+var (
+	escrowAddr = common.HexToAddress("0x00000000000000000000000000000000000e5c40")
+	chainID    = big.NewInt(26000)
+)
+
+// fakeChain executes escrow contract calls in memory.
+type fakeChain struct {
+	deposits map[common.Hash]*Deposit
+	receipts map[common.Hash]bool
+	sent     []*types.Transaction
+
+	// unmined makes transactions accepted without including them.
+	unmined bool
+}
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{
+		deposits: map[common.Hash]*Deposit{},
+		receipts: map[common.Hash]bool{},
+	}
+}
+
+func (f *fakeChain) SendRawTx(_ context.Context, rawTx string) error {
+	raw, err := hexutil.Decode(rawTx)
+	if err != nil {
+		return err
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return err
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		return err
+	}
+	if _, ok := f.receipts[tx.Hash()]; ok {
+		return errors.New("already known")
+	}
+	f.sent = append(f.sent, tx)
+	if f.unmined {
+		return nil
+	}
+	if err := f.apply(tx, sender); err != nil {
+		f.receipts[tx.Hash()] = false
+		return err
+	}
+	f.receipts[tx.Hash()] = true
+	return nil
+}
+
+func (f *fakeChain) apply(tx *types.Transaction, sender common.Address) error {
+	method, err := parsedABI.MethodById(tx.Data())
+	if err != nil {
+		return err
+	}
+	args, err := method.Inputs.Unpack(tx.Data()[4:])
+	if err != nil {
+		return err
+	}
+	id := common.Hash(args[0].([32]byte))
+	switch method.Name {
+	case "deposit":
+		if _, ok := f.deposits[id]; ok {
+			return errors.New("execution reverted: DepositExists")
+		}
+		f.deposits[id] = &Deposit{Sender: sender, Recipient: args[1].(common.Address), Amount: tx.Value(), State: StateHeld}
+	case "claim", "refund":
+		d, ok := f.deposits[id]
+		if !ok || d.State != StateHeld || d.Recipient != sender {
+			return errors.New("execution reverted")
+		}
+		d.State = StateClaimed
+		if method.Name == "refund" {
+			d.State = StateRefunded
+		}
+	}
+	return nil
+}
+
+func (f *fakeChain) ChainType(context.Context) string { return "evm" }
+
+func (f *fakeChain) CheckSign(context.Context, string, string, string) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeChain) ChainID(context.Context) (*big.Int, error) { return chainID, nil }
+
+func (f *fakeChain) CallContract(_ context.Context, to, data string) (string, error) {
+	if common.HexToAddress(to) != escrowAddr {
+		return "0x", nil
+	}
+	input, err := hexutil.Decode(data)
+	if err != nil {
+		return "", err
+	}
+	method, err := parsedABI.MethodById(input)
+	if err != nil {
+		return "", err
+	}
+	args, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return "", err
+	}
+	d, ok := f.deposits[common.Hash(args[0].([32]byte))]
+	if !ok {
+		d = &Deposit{Amount: new(big.Int)}
+	}
+	out, err := method.Outputs.Pack(d.Sender, d.Recipient, d.Amount, uint8(d.State))
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(out), nil
+}
+
+func (f *fakeChain) PendingNonce(context.Context, string) (uint64, error) {
+	return uint64(len(f.sent)), nil
+}
+
+func (f *fakeChain) SuggestGasPrice(context.Context) (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+
+func (f *fakeChain) TransactionReceipt(_ context.Context, hash string) (*module.TxReceipt, error) {
+	success, ok := f.receipts[common.HexToHash(hash)]
+	if !ok {
+		return nil, module.ErrTxPending
+	}
+	return &module.TxReceipt{TxHash: hash, Success: success, BlockNumber: 1}, nil
+}
+
+func testCheck(t *testing.T, chain *fakeChain) *Check {
+	t.Helper()
+	return &Check{
+		log:                 testutils.Logger(t, modName),
+		escrow:              Escrow{Chain: chain, Address: escrowAddr},
+		price:               big.NewInt(1000),
+		gasLimit:            100000,
+		exemptAuthenticated: true,
+		failAction:          modconfig.FailAction{Quarantine: true},
+		receiptTimeout:      50 * time.Millisecond,
+		pollInterval:        10 * time.Millisecond,
+		consumed:            table.NewMemory(),
+		consumedTTL:         time.Hour,
+	}
+}
+
+func depositTx(t *testing.T, key *ecdsa.PrivateKey, id common.Hash, rcpt common.Address, value int64) string {
+	t.Helper()
+	data, err := parsedABI.Pack("deposit", id, rcpt)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(chainID), &types.LegacyTx{
+		GasPrice: big.NewInt(1),
+		Gas:      100000,
+		To:       &escrowAddr,
+		Value:    big.NewInt(value),
+		Data:     data,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(raw)
+}
+
+func run(t *testing.T, c *Check, sender, rcpt string, hdr textproto.Header) module.CheckResult {
+	t.Helper()
+	return runBody(t, c, sender, rcpt, hdr, "Hello!\r\n")
+}
+
+func runBody(t *testing.T, c *Check, sender, rcpt string, hdr textproto.Header, body string) module.CheckResult {
+	t.Helper()
+	ctx := context.Background()
+	st, err := c.CheckStateForMsg(ctx, &module.MsgMetadata{
+		ID:   "msg",
+		Conn: &module.ConnState{},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	if res := st.CheckSender(ctx, sender); res.Reason != nil {
+		return res
+	}
+	if res := st.CheckRcpt(ctx, rcpt); res.Reason != nil {
+		return res
+	}
+	return st.CheckBody(ctx, hdr, buffer.MemoryBuffer{Slice: []byte(body)})
+}
+
+func TestID(t *testing.T) {
+	rcpt := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	if ID("<a@example.org>", rcpt) != ID(" a@example.org ", rcpt) {
+		t.Fatal("angle brackets and whitespace should be ignored")
+	}
+	if ID("a@example.org", rcpt) == ID("b@example.org", rcpt) {
+		t.Fatal("different messages should have different IDs")
+	}
+	if ID("a@example.org", rcpt) == ID("a@example.org", common.Address{}) {
+		t.Fatal("different recipients should have different IDs")
+	}
+}
+
+func TestCheck(t *testing.T) {
+	senderKey, _ := crypto.GenerateKey()
+	rcptAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	rcpt := strings.ToLower(rcptAddr.Hex()) + "@example.org"
+	id := ID("<msg@example.com>", rcptAddr)
+
+	hdr := func(proofs ...string) textproto.Header {
+		var h textproto.Header
+		h.Add("Message-Id", "<msg@example.com>")
+		for _, p := range proofs {
+			h.Add(proofHeader, p)
+		}
+		return h
+	}
+
+	t.Run("missing", func(t *testing.T) {
+		res := run(t, testCheck(t, newFakeChain()), "spam@example.com", rcpt, hdr())
+		if !res.Quarantine || res.Reason == nil {
+			t.Fatalf("expected quarantine, got %+v", res)
+		}
+	})
+
+	t.Run("tx", func(t *testing.T) {
+		chain := newFakeChain()
+		res := run(t, testCheck(t, chain), "a@example.com", rcpt, hdr("tx="+depositTx(t, senderKey, id, rcptAddr, 1000)))
+		if res.Reason != nil {
+			t.Fatal("unexpected failure:", res.Reason)
+		}
+		if len(chain.sent) != 1 {
+			t.Fatal("deposit was not broadcasted")
+		}
+		if res.Header.Get(depositHeader) != id.Hex() {
+			t.Fatalf("wrong %s: %s", depositHeader, res.Header.Get(depositHeader))
+		}
+	})
+
+	t.Run("tx already broadcasted", func(t *testing.T) {
+		chain := newFakeChain()
+		tx := depositTx(t, senderKey, id, rcptAddr, 1000)
+		if err := chain.SendRawTx(context.Background(), tx); err != nil {
+			t.Fatal(err)
+		}
+		if res := run(t, testCheck(t, chain), "a@example.com", rcpt, hdr("tx="+tx)); res.Reason != nil {
+			t.Fatal("unexpected failure:", res.Reason)
+		}
+	})
+
+	t.Run("tx pending", func(t *testing.T) {
+		chain := newFakeChain()
+		chain.unmined = true
+		res := run(t, testCheck(t, chain), "a@example.com", rcpt, hdr("tx="+depositTx(t, senderKey, id, rcptAddr, 1000)))
+		if res.Reason == nil || res.Quarantine || res.Reason.(*exterrors.SMTPError).Code != 451 {
+			t.Fatalf("expected temporary failure, got %+v", res)
+		}
+	})
+
+	t.Run("tx reverted", func(t *testing.T) {
+		chain := newFakeChain()
+		// Another deposit with the same ID was made first.
+		chain.deposits[id] = &Deposit{Recipient: rcptAddr, Amount: big.NewInt(1000), State: StateClaimed}
+		res := run(t, testCheck(t, chain), "a@example.com", rcpt, hdr("tx="+depositTx(t, senderKey, id, rcptAddr, 1000)))
+		if !res.Quarantine || res.Reason == nil {
+			t.Fatalf("expected quarantine, got %+v", res)
+		}
+	})
+
+	t.Run("tx low amount", func(t *testing.T) {
+		chain := newFakeChain()
+		res := run(t, testCheck(t, chain), "a@example.com", rcpt, hdr("tx="+depositTx(t, senderKey, id, rcptAddr, 999)))
+		if res.Reason == nil || len(chain.sent) != 0 {
+			t.Fatal("postage below price should not be accepted")
+		}
+	})
+
+	t.Run("tx other recipient", func(t *testing.T) {
+		other := common.HexToAddress("0x3333333333333333333333333333333333333333")
+		res := run(t, testCheck(t, newFakeChain()), "a@example.com", rcpt, hdr("tx="+depositTx(t, senderKey, ID("<msg@example.com>", other), other, 1000)))
+		if res.Reason == nil {
+			t.Fatal("postage for another recipient should not be accepted")
+		}
+	})
+
+	t.Run("voucher", func(t *testing.T) {
+		chain := newFakeChain()
+		chain.deposits[id] = &Deposit{Recipient: rcptAddr, Amount: big.NewInt(5000), State: StateHeld}
+		if res := run(t, testCheck(t, chain), "a@example.com", rcpt, hdr("voucher="+id.Hex())); res.Reason != nil {
+			t.Fatal("unexpected failure:", res.Reason)
+		}
+
+		chain.deposits[id].State = StateClaimed
+		if res := run(t, testCheck(t, chain), "a@example.com", rcpt, hdr("voucher="+id.Hex())); res.Reason == nil {
+			t.Fatal("claimed voucher should not be accepted")
+		}
+	})
+
+	t.Run("replay", func(t *testing.T) {
+		chain := newFakeChain()
+		chain.deposits[id] = &Deposit{Recipient: rcptAddr, Amount: big.NewInt(5000), State: StateHeld}
+		c := testCheck(t, chain)
+		if res := runBody(t, c, "a@example.com", rcpt, hdr("voucher="+id.Hex()), "Hello!\r\n"); res.Reason != nil {
+			t.Fatal("unexpected failure:", res.Reason)
+		}
+		if res := runBody(t, c, "a@example.com", rcpt, hdr("voucher="+id.Hex()), "Hello!\r\n"); res.Reason != nil {
+			t.Fatal("redelivery should be accepted:", res.Reason)
+		}
+		if res := runBody(t, c, "b@example.com", rcpt, hdr("voucher="+id.Hex()), "Buy now!\r\n"); !res.Quarantine || res.Reason == nil {
+			t.Fatalf("reused postage should not be accepted, got %+v", res)
+		}
+
+		// Expired entries are purged.
+		c.purgeConsumed(time.Now().Add(2 * time.Hour))
+		keys, err := c.consumed.Keys()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(keys) != 0 {
+			t.Fatal("expired entries are not purged:", keys)
+		}
+	})
+
+	t.Run("reject", func(t *testing.T) {
+		c := testCheck(t, newFakeChain())
+		c.failAction = modconfig.FailAction{Reject: true}
+		res := run(t, c, "a@example.com", rcpt, hdr("voucher="+id.Hex()))
+		if !res.Reject || res.Reason.(*exterrors.SMTPError).Code != 550 {
+			t.Fatalf("expected rejection, got %+v", res)
+		}
+	})
+
+	t.Run("malformed", func(t *testing.T) {
+		if res := run(t, testCheck(t, newFakeChain()), "a@example.com", rcpt, hdr("tx=zzz")); res.Reason == nil {
+			t.Fatal("malformed proof should not be accepted")
+		}
+	})
+}
+
+func TestExemptions(t *testing.T) {
+	rcptAddr := common.HexToAddress("0x2222222222222222222222222222222222222222")
+	rcpt := strings.ToLower(rcptAddr.Hex()) + "@example.org"
+	var hdr textproto.Header
+	hdr.Add("Message-Id", "<msg@example.com>")
+
+	t.Run("contact", func(t *testing.T) {
+		contacts := table.NewMemory()
+		contacts.SetKey(rcpt, "friend@example.com, @trusted.org")
+		c := testCheck(t, newFakeChain())
+		c.contacts = contacts
+
+		for _, sender := range []string{"Friend@example.com", "any@trusted.org"} {
+			if res := run(t, c, sender, rcpt, hdr); res.Reason != nil {
+				t.Fatalf("%s: unexpected failure: %v", sender, res.Reason)
+			}
+		}
+		if res := run(t, c, "stranger@example.com", rcpt, hdr); res.Reason == nil {
+			t.Fatal("postage should be required from strangers")
+		}
+	})
+
+	t.Run("no address", func(t *testing.T) {
+		if res := run(t, testCheck(t, newFakeChain()), "a@example.com", "user@example.org", hdr); res.Reason != nil {
+			t.Fatal("unexpected failure:", res.Reason)
+		}
+	})
+
+	t.Run("recipient_address", func(t *testing.T) {
+		addrs := table.NewMemory()
+		addrs.SetKey("user@example.org", rcptAddr.Hex())
+		c := testCheck(t, newFakeChain())
+		c.rcptAddress = addrs
+		if res := run(t, c, "a@example.com", "user@example.org", hdr); res.Reason == nil {
+			t.Fatal("postage should be required for the mapped recipient")
+		}
+	})
+
+	t.Run("authenticated", func(t *testing.T) {
+		c := testCheck(t, newFakeChain())
+		st, _ := c.CheckStateForMsg(context.Background(), &module.MsgMetadata{Conn: &module.ConnState{AuthUser: "user"}})
+		st.CheckRcpt(context.Background(), rcpt)
+		if res := st.CheckBody(context.Background(), hdr, buffer.MemoryBuffer{}); res.Reason != nil {
+			t.Fatal("unexpected failure:", res.Reason)
+		}
+	})
+}
+
+func TestClaimRefund(t *testing.T) {
+	rcptKey, _ := crypto.GenerateKey()
+	rcptAddr := crypto.PubkeyToAddress(rcptKey.PublicKey)
+	otherKey, _ := crypto.GenerateKey()
+	chain := newFakeChain()
+	e := Escrow{Chain: chain, Address: escrowAddr}
+	ctx := context.Background()
+
+	a, b := common.HexToHash("0xa"), common.HexToHash("0xb")
+	chain.deposits[a] = &Deposit{Recipient: rcptAddr, Amount: big.NewInt(1), State: StateHeld}
+	chain.deposits[b] = &Deposit{Recipient: rcptAddr, Amount: big.NewInt(1), State: StateHeld}
+
+	if _, err := e.Claim(ctx, a, otherKey, 100000); err == nil {
+		t.Fatal("only the recipient should be able to claim")
+	}
+	if _, err := e.Claim(ctx, a, rcptKey, 100000); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := e.Refund(ctx, b, rcptKey, 100000); err != nil {
+		t.Fatal(err)
+	}
+
+	for id, want := range map[common.Hash]State{a: StateClaimed, b: StateRefunded} {
+		d, err := e.Deposit(ctx, id)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if d.State != want {
+			t.Errorf("%s: expected %s, got %s", id.Hex(), want, d.State)
+		}
+	}
+	if !bytes.Equal(chain.sent[len(chain.sent)-1].Data()[:4], parsedABI.Methods["refund"].ID) {
+		t.Error("refund call expected")
+	}
+}
@@ -0,0 +1,189 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package rspamd
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/internal/limits/limiters"
+)
+
+// learnedCache remembers the classification of recently learned messages by
+// their Message-ID so repeated moves of the same message do not result in
+// repeated learn requests.
+type learnedCache struct {
+	ttl     time.Duration
+	maxSize int
+
+	lck sync.Mutex
+	m   map[string]learnedEntry
+}
+
+type learnedEntry struct {
+	spam bool
+	at   time.Time
+}
+
+func newLearnedCache(ttl time.Duration, maxSize int) *learnedCache {
+	return &learnedCache{
+		ttl:     ttl,
+		maxSize: maxSize,
+		m:       make(map[string]learnedEntry),
+	}
+}
+
+// seen reports whether the message with the specified ID was already learned
+// with the same classification. If it was not, it is recorded as learned.
+func (c *learnedCache) seen(msgID string, spam bool) bool {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+
+	now := time.Now()
+	if e, ok := c.m[msgID]; ok && e.spam == spam && now.Sub(e.at) < c.ttl {
+		return true
+	}
+
+	if len(c.m) >= c.maxSize {
+		for k, e := range c.m {
+			if now.Sub(e.at) >= c.ttl {
+				delete(c.m, k)
+			}
+		}
+		// Still full? Drop arbitrary entries, worst case is that a message
+		// will be learned twice.
+		for k := range c.m {
+			if len(c.m) < c.maxSize {
+				break
+			}
+			delete(c.m, k)
+		}
+	}
+
+	c.m[msgID] = learnedEntry{spam: spam, at: now}
+	return false
+}
+
+func (c *learnedCache) forget(msgID string) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	delete(c.m, msgID)
+}
+
+func learnRateDirective(_ *config.Map, node config.Node) (interface{}, error) {
+	period := 1 * time.Minute
+	burst := 0
+
+	switch len(node.Args) {
+	case 2:
+		var err error
+		period, err = time.ParseDuration(node.Args[1])
+		if err != nil {
+			return nil, config.NodeErr(node, "%v", err)
+		}
+		fallthrough
+	case 1:
+		var err error
+		burst, err = strconv.Atoi(node.Args[0])
+		if err != nil {
+			return nil, config.NodeErr(node, "%v", err)
+		}
+	default:
+		return nil, config.NodeErr(node, "expected 1 or 2 arguments")
+	}
+
+	return limiters.NewRate(burst, period), nil
+}
+
+// Learn implements module.SpamLearner using rspamd controller /learnspam and
+// /learnham endpoints.
+func (c *Check) Learn(ctx context.Context, spam bool, hdr textproto.Header, body io.Reader) error {
+	msgID := hdr.Get("Message-Id")
+	if msgID != "" && c.learned.seen(msgID, spam) {
+		c.log.DebugMsg("message already learned, skipping", "msg_id", msgID, "spam", spam)
+		return nil
+	}
+
+	if err := c.learnRate.TakeContext(ctx); err != nil {
+		if msgID != "" {
+			c.learned.forget(msgID)
+		}
+		return fmt.Errorf("%s: learn rate limit exceeded: %w", modName, err)
+	}
+
+	if err := c.learn(ctx, spam, hdr, body); err != nil {
+		if msgID != "" {
+			c.learned.forget(msgID)
+		}
+		return err
+	}
+
+	c.log.DebugMsg("message learned", "msg_id", msgID, "spam", spam)
+	return nil
+}
+
+func (c *Check) learn(ctx context.Context, spam bool, hdr textproto.Header, body io.Reader) error {
+	endpoint := "/learnham"
+	if spam {
+		endpoint = "/learnspam"
+	}
+
+	var buf bytes.Buffer
+	if err := textproto.WriteHeader(&buf, hdr); err != nil {
+		return err
+	}
+
+	r, err := http.NewRequestWithContext(ctx, "POST", c.controllerPath+endpoint, io.MultiReader(&buf, body))
+	if err != nil {
+		return err
+	}
+	r.Header.Add("User-Agent", "mailcoin")
+	if c.controllerPassword != "" {
+		r.Header.Add("Password", c.controllerPassword)
+	}
+	if c.settingsID != "" {
+		r.Header.Add("Settings-ID", c.settingsID)
+	}
+
+	resp, err := c.client.Do(r)
+	if err != nil {
+		return fmt.Errorf("%s: %w", modName, err)
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+		return nil
+	case http.StatusAlreadyReported:
+		// rspamd uses 208 to report that the message was already learned
+		// with the same class.
+		return nil
+	default:
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: learn failed: HTTP %d: %s", modName, resp.StatusCode, bytes.TrimSpace(msg))
+	}
+}
@@ -0,0 +1,89 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package rspamd
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/mail-chat-chain/mailchatd/internal/limits/limiters"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+func TestLearn(t *testing.T) {
+	var requests []string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		body, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Password") != "secret" {
+			w.WriteHeader(http.StatusForbidden)
+			return
+		}
+		if !strings.Contains(string(body), "Message-Id: <a@example.org>") {
+			t.Errorf("message header not passed: %q", body)
+		}
+		requests = append(requests, r.URL.Path)
+	}))
+	defer srv.Close()
+
+	c := &Check{
+		log:                testutils.Logger(t, modName),
+		client:             http.DefaultClient,
+		controllerPath:     srv.URL,
+		controllerPassword: "secret",
+		learnRate:          limiters.NewRate(0, time.Minute),
+		learned:            newLearnedCache(time.Hour, 10),
+	}
+
+	hdr := textproto.Header{}
+	hdr.Add("Message-Id", "<a@example.org>")
+
+	learn := func(spam bool) {
+		t.Helper()
+		if err := c.Learn(context.Background(), spam, hdr, strings.NewReader("body")); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	learn(true)
+	learn(true) // duplicate, should be skipped
+	learn(false)
+
+	if len(requests) != 2 || requests[0] != "/learnspam" || requests[1] != "/learnham" {
+		t.Fatalf("unexpected requests: %v", requests)
+	}
+}
+
+func TestLearnedCache_Bounded(t *testing.T) {
+	c := newLearnedCache(time.Hour, 2)
+	c.seen("a", true)
+	c.seen("b", true)
+	c.seen("c", true)
+	if len(c.m) > 2 {
+		t.Fatalf("cache grew beyond its limit: %d", len(c.m))
+	}
+	if !c.seen("c", true) {
+		t.Fatal("last entry was evicted")
+	}
+}
@@ -29,6 +29,7 @@ import (
 	"net/http"
 	"strconv"
 	"strings"
+	"time"
 
 	"github.com/emersion/go-message/textproto"
 	"github.com/mail-chat-chain/mailchatd/framework/buffer"
@@ -38,6 +39,7 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/limits/limiters"
 	"github.com/mail-chat-chain/mailchatd/internal/target"
 )
 
@@ -59,6 +61,11 @@ type Check struct {
 	rewriteSubjAction modconfig.FailAction
 
 	client *http.Client
+
+	controllerPath     string
+	controllerPassword string
+	learnRate          limiters.Rate
+	learned            *learnedCache
 }
 
 func New(modName, instName string, _, inlineArgs []string) (module.Module, error) {
@@ -118,6 +125,11 @@ func (c *Check) Init(cfg *config.Map) error {
 			return modconfig.FailAction{Quarantine: true}, nil
 		}, modconfig.FailActionDirective, &c.rewriteSubjAction)
 	cfg.StringList("flags", false, false, []string{"pass_all"}, &flags)
+	cfg.String("controller_path", false, false, "http://127.0.0.1:11334", &c.controllerPath)
+	cfg.String("controller_password", false, false, "", &c.controllerPassword)
+	cfg.Custom("learn_rate", false, false, func() (interface{}, error) {
+		return limiters.NewRate(10, 1*time.Minute), nil
+	}, learnRateDirective, &c.learnRate)
 	if _, err := cfg.Process(); err != nil {
 		return err
 	}
@@ -128,6 +140,7 @@ func (c *Check) Init(cfg *config.Map) error {
 		},
 	}
 	c.flags = strings.Join(flags, ",")
+	c.learned = newLearnedCache(24*time.Hour, 10000)
 
 	return nil
 }
@@ -362,6 +375,11 @@ func (s *state) Close() error {
 	return nil
 }
 
+func (c *Check) Close() error {
+	c.learnRate.Close()
+	return nil
+}
+
 func init() {
 	module.Register(modName, New)
 }
@@ -0,0 +1,100 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package sending_quota
+
+import (
+	"context"
+	"fmt"
+	"mime"
+	"runtime/trace"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// notify sends the suspension notification to the administrators.
+func (c *Check) notify(username string, violations int, reason string) {
+	if c.notifyTarget == nil {
+		return
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Minute)
+	defer cancel()
+
+	if err := c.sendNotification(ctx, username, violations, reason); err != nil {
+		c.log.Error("failed to send suspension notification", err, "username", username)
+	}
+}
+
+func (c *Check) sendNotification(ctx context.Context, username string, violations int, reason string) (err error) {
+	msgID, err := module.GenerateMsgID()
+	if err != nil {
+		return err
+	}
+
+	from := c.notifyFrom
+	if from == "" {
+		from = "postmaster@" + c.autogenDomain
+	}
+
+	hdr := textproto.Header{}
+	hdr.Add("Date", c.now().Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	hdr.Add("Message-Id", "<"+msgID+"@"+c.autogenDomain+">")
+	hdr.Add("From", "<"+from+">")
+	hdr.Add("To", "<"+strings.Join(c.notifyTo, ">, <")+">")
+	hdr.Add("Subject", mime.QEncoding.Encode("utf-8", "Account suspended: "+username))
+	hdr.Add("Auto-Submitted", "auto-generated")
+	hdr.Add("MIME-Version", "1.0")
+	hdr.Add("Content-Type", "text/plain; charset=utf-8")
+
+	body := fmt.Sprintf("Sending from the account %s was suspended after %d sending quota\r\n"+
+		"violations within a day.\r\n\r\n"+
+		"Last violation: %s\r\n\r\n"+
+		"Use 'mailchatd sending-quota reset %s' to lift the suspension.\r\n",
+		username, violations, reason, username)
+
+	ctx, task := trace.NewTask(ctx, "Sending quota notification")
+	defer task.End()
+
+	delivery, err := c.notifyTarget.Start(ctx, &module.MsgMetadata{ID: msgID}, from)
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if err := delivery.Abort(ctx); err != nil {
+				c.log.Error("failed to abort notification delivery", err, "msg_id", msgID)
+			}
+		}
+	}()
+
+	for _, rcpt := range c.notifyTo {
+		if err = delivery.AddRcpt(ctx, rcpt, smtp.RcptOptions{}); err != nil {
+			return err
+		}
+	}
+	if err = delivery.Body(ctx, hdr, buffer.MemoryBuffer{Slice: []byte(body)}); err != nil {
+		return err
+	}
+	return delivery.Commit(ctx)
+}
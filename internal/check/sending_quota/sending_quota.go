@@ -0,0 +1,402 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package sending_quota implements the check.sending_quota module that
+// limits the amount of mail sent by each authenticated user.
+//
+// Messages per hour, recipients per day and unique recipients per day are
+// counted. Users exceeding the quota get the temporary 450 4.7.1 error and
+// can be suspended automatically after the configured amount of violations
+// per day. Counters are stored in the state table so they can be shared by
+// multiple instances (e.g. using table.sql_table).
+//
+// Unauthenticated and locally generated messages are not checked.
+package sending_quota
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"slices"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/table"
+	"github.com/mail-chat-chain/mailchatd/internal/target"
+)
+
+const modName = "check.sending_quota"
+
+// Usage is the per-user record stored in the state table as a JSON object,
+// keyed by the username.
+type Usage struct {
+	HourStart time.Time `json:"hour_start"`
+	Messages  int       `json:"messages"`
+
+	DayStart   time.Time `json:"day_start"`
+	Recipients int       `json:"recipients"`
+	// Unique contains truncated hashes of recipient addresses.
+	Unique []string `json:"unique,omitempty"`
+
+	Violations int `json:"violations"`
+	// LastViolation is the ID of the message that caused the last violation,
+	// used to count each message only once.
+	LastViolation string    `json:"last_violation,omitempty"`
+	Suspended     time.Time `json:"suspended,omitzero"`
+}
+
+// roll resets the counters of expired windows.
+func (u *Usage) roll(now time.Time) {
+	if now.Sub(u.HourStart) >= time.Hour {
+		u.HourStart = now
+		u.Messages = 0
+	}
+	if now.Sub(u.DayStart) >= 24*time.Hour {
+		u.DayStart = now
+		u.Recipients = 0
+		u.Unique = nil
+		u.Violations = 0
+		u.LastViolation = ""
+	}
+}
+
+type Check struct {
+	instName string
+	log      log.Logger
+
+	msgsPerHour   int
+	rcptsPerDay   int
+	uniquePerDay  int
+	suspendAfter  int
+	state         module.MutableTable
+	notifyTarget  module.DeliveryTarget
+	notifyFrom    string
+	notifyTo      []string
+	autogenDomain string
+
+	// lck serializes read-modify-write cycles on the state table. Updates
+	// from other instances sharing the table are not serialized and can be
+	// lost in rare cases.
+	lck sync.Mutex
+	now func() time.Time
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	if len(inlineArgs) != 0 {
+		return nil, fmt.Errorf("%s: inline arguments are not used", modName)
+	}
+	return &Check{
+		instName: instName,
+		log:      log.Logger{Name: modName},
+		now:      time.Now,
+	}, nil
+}
+
+func (c *Check) Name() string {
+	return modName
+}
+
+func (c *Check) InstanceName() string {
+	return c.instName
+}
+
+func (c *Check) Init(cfg *config.Map) error {
+	cfg.Bool("debug", true, false, &c.log.Debug)
+	cfg.Int("messages_per_hour", false, false, 0, &c.msgsPerHour)
+	cfg.Int("recipients_per_day", false, false, 0, &c.rcptsPerDay)
+	cfg.Int("unique_recipients_per_day", false, false, 0, &c.uniquePerDay)
+	cfg.Int("suspend_after", false, false, 0, &c.suspendAfter)
+	cfg.Custom("state", false, false, func() (interface{}, error) {
+		return table.NewMemory(), nil
+	}, func(m *config.Map, node config.Node) (interface{}, error) {
+		var tbl module.MutableTable
+		err := modconfig.ModuleFromNode("table", node.Args, node, m.Globals, &tbl)
+		return tbl, err
+	}, &c.state)
+	cfg.Custom("notify_target", false, false, nil, modconfig.DeliveryDirective, &c.notifyTarget)
+	cfg.String("notify_from", false, false, "", &c.notifyFrom)
+	cfg.StringList("notify_to", false, false, nil, &c.notifyTo)
+	cfg.String("autogenerated_msg_domain", true, false, "", &c.autogenDomain)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	for name, v := range map[string]int{
+		"messages_per_hour":         c.msgsPerHour,
+		"recipients_per_day":        c.rcptsPerDay,
+		"unique_recipients_per_day": c.uniquePerDay,
+		"suspend_after":             c.suspendAfter,
+	} {
+		if v < 0 {
+			return fmt.Errorf("%s: %s should not be negative", modName, name)
+		}
+	}
+	if c.notifyTarget != nil {
+		if len(c.notifyTo) == 0 {
+			return fmt.Errorf("%s: notify_to is required if notify_target is set", modName)
+		}
+		if c.autogenDomain == "" {
+			return fmt.Errorf("%s: autogenerated_msg_domain is required if notify_target is set", modName)
+		}
+	}
+	if !c.Persistent() {
+		c.log.Msg("no state table configured, quotas will not be shared or persist across restarts")
+	}
+
+	return nil
+}
+
+// Persistent reports whether the state is stored in the configured table and
+// thus can be managed externally.
+func (c *Check) Persistent() bool {
+	_, ok := c.state.(*table.Memory)
+	return !ok
+}
+
+func (c *Check) load(ctx context.Context, username string) (*Usage, error) {
+	val, ok, err := c.state.Lookup(ctx, username)
+	if err != nil {
+		return nil, err
+	}
+	u := &Usage{}
+	if ok {
+		if err := json.Unmarshal([]byte(val), u); err != nil {
+			return nil, fmt.Errorf("%s: malformed state for %s: %w", modName, username, err)
+		}
+	}
+	u.roll(c.now())
+	return u, nil
+}
+
+func (c *Check) save(username string, u *Usage) error {
+	blob, err := json.Marshal(u)
+	if err != nil {
+		return err
+	}
+	return c.state.SetKey(username, string(blob))
+}
+
+// Usage returns the current usage record of the user.
+func (c *Check) Usage(ctx context.Context, username string) (*Usage, error) {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return c.load(ctx, username)
+}
+
+// Users returns the list of users with usage records.
+func (c *Check) Users() ([]string, error) {
+	users, err := c.state.Keys()
+	if err != nil {
+		return nil, err
+	}
+	slices.Sort(users)
+	return users, nil
+}
+
+// Reset clears counters and lifts the suspension of the user.
+func (c *Check) Reset(username string) error {
+	c.lck.Lock()
+	defer c.lck.Unlock()
+	return c.state.RemoveKey(username)
+}
+
+func recipientHash(rcpt string) string {
+	sum := sha256.Sum256([]byte(strings.ToLower(rcpt)))
+	return hex.EncodeToString(sum[:8])
+}
+
+func quotaErr(msg string) module.CheckResult {
+	return module.CheckResult{
+		Reason: &exterrors.SMTPError{
+			Code:         450,
+			EnhancedCode: exterrors.EnhancedCode{4, 7, 1},
+			Message:      msg,
+			CheckName:    modName,
+		},
+	}
+}
+
+func internalErr(err error) module.CheckResult {
+	return module.CheckResult{
+		Reason: &exterrors.SMTPError{
+			Code:         451,
+			EnhancedCode: exterrors.EnhancedCode{4, 7, 1},
+			Message:      "Internal error during quota check",
+			CheckName:    modName,
+			Err:          err,
+		},
+	}
+}
+
+var suspendedRes = module.CheckResult{
+	Reason: &exterrors.SMTPError{
+		Code:         550,
+		EnhancedCode: exterrors.EnhancedCode{5, 7, 1},
+		Message:      "Sending is suspended for this account, contact the administrator",
+		CheckName:    modName,
+	},
+}
+
+type state struct {
+	c        *Check
+	msgMeta  *module.MsgMetadata
+	log      log.Logger
+	username string
+
+	// Hashes of the accepted recipients of this message.
+	rcpts []string
+}
+
+func (c *Check) CheckStateForMsg(_ context.Context, msgMeta *module.MsgMetadata) (module.CheckState, error) {
+	s := &state{
+		c:       c,
+		msgMeta: msgMeta,
+		log:     target.DeliveryLogger(c.log, msgMeta),
+	}
+	if msgMeta.Conn != nil {
+		s.username = msgMeta.Conn.AuthUser
+	}
+	return s, nil
+}
+
+// violation records the quota violation and suspends the user if
+// suspend_after is reached. It should be called with c.lck held.
+func (s *state) violation(u *Usage, msg string) module.CheckResult {
+	if u.LastViolation != s.msgMeta.ID {
+		u.Violations++
+		u.LastViolation = s.msgMeta.ID
+	}
+	s.log.Msg("sending quota exceeded", "username", s.username, "reason", msg, "violations", u.Violations)
+
+	suspend := s.c.suspendAfter > 0 && u.Violations >= s.c.suspendAfter && u.Suspended.IsZero()
+	if suspend {
+		u.Suspended = s.c.now()
+	}
+	if err := s.c.save(s.username, u); err != nil {
+		s.log.Error("failed to save quota state", err, "username", s.username)
+	}
+	if suspend {
+		s.log.Msg("account suspended due to sending quota violations", "username", s.username, "violations", u.Violations)
+		go s.c.notify(s.username, u.Violations, msg)
+	}
+
+	return quotaErr(msg)
+}
+
+func (s *state) CheckConnection(_ context.Context) module.CheckResult {
+	return module.CheckResult{}
+}
+
+func (s *state) CheckSender(ctx context.Context, _ string) module.CheckResult {
+	if s.username == "" {
+		return module.CheckResult{}
+	}
+
+	s.c.lck.Lock()
+	defer s.c.lck.Unlock()
+
+	u, err := s.c.load(ctx, s.username)
+	if err != nil {
+		return internalErr(err)
+	}
+	if !u.Suspended.IsZero() {
+		return suspendedRes
+	}
+	if s.c.msgsPerHour > 0 && u.Messages >= s.c.msgsPerHour {
+		return s.violation(u, "Hourly message quota exceeded, try again later")
+	}
+	return module.CheckResult{}
+}
+
+func (s *state) CheckRcpt(ctx context.Context, rcpt string) module.CheckResult {
+	if s.username == "" || (s.c.rcptsPerDay == 0 && s.c.uniquePerDay == 0) {
+		return module.CheckResult{}
+	}
+
+	s.c.lck.Lock()
+	defer s.c.lck.Unlock()
+
+	u, err := s.c.load(ctx, s.username)
+	if err != nil {
+		return internalErr(err)
+	}
+	if s.c.rcptsPerDay > 0 && u.Recipients+len(s.rcpts) >= s.c.rcptsPerDay {
+		return s.violation(u, "Daily recipients quota exceeded, try again later")
+	}
+
+	h := recipientHash(rcpt)
+	if s.c.uniquePerDay > 0 && !slices.Contains(u.Unique, h) && !slices.Contains(s.rcpts, h) {
+		pendingNew := 0
+		for _, r := range s.rcpts {
+			if !slices.Contains(u.Unique, r) {
+				pendingNew++
+			}
+		}
+		if len(u.Unique)+pendingNew >= s.c.uniquePerDay {
+			return s.violation(u, "Daily unique recipients quota exceeded, try again later")
+		}
+	}
+
+	s.rcpts = append(s.rcpts, h)
+	return module.CheckResult{}
+}
+
+// CheckBody accounts the message. It is counted even if it is rejected later
+// in the pipeline, since that is still an attempt to send it.
+func (s *state) CheckBody(ctx context.Context, _ textproto.Header, _ buffer.Buffer) module.CheckResult {
+	if s.username == "" {
+		return module.CheckResult{}
+	}
+
+	s.c.lck.Lock()
+	defer s.c.lck.Unlock()
+
+	u, err := s.c.load(ctx, s.username)
+	if err != nil {
+		return internalErr(err)
+	}
+	u.Messages++
+	u.Recipients += len(s.rcpts)
+	for _, h := range s.rcpts {
+		if !slices.Contains(u.Unique, h) {
+			u.Unique = append(u.Unique, h)
+		}
+	}
+	if err := s.c.save(s.username, u); err != nil {
+		return internalErr(err)
+	}
+	return module.CheckResult{}
+}
+
+func (s *state) Close() error {
+	return nil
+}
+
+func init() {
+	module.Register(modName, New)
+}
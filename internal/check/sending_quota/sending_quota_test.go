@@ -0,0 +1,183 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package sending_quota
+
+import (
+	"context"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/table"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+func testCheck(t *testing.T) *Check {
+	t.Helper()
+	return &Check{
+		log:   testutils.Logger(t, modName),
+		state: table.NewMemory(),
+		now:   time.Now,
+	}
+}
+
+// send runs the message through the check and returns the SMTP code of the
+// first rejection, or 0.
+func send(t *testing.T, c *Check, id, user string, rcpts ...string) int {
+	t.Helper()
+	ctx := context.Background()
+	st, err := c.CheckStateForMsg(ctx, &module.MsgMetadata{
+		ID:   id,
+		Conn: &module.ConnState{AuthUser: user},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	code := func(res module.CheckResult) int {
+		if res.Reason == nil {
+			return 0
+		}
+		return res.Reason.(*exterrors.SMTPError).Code
+	}
+
+	if c := code(st.CheckSender(ctx, user)); c != 0 {
+		return c
+	}
+	for _, rcpt := range rcpts {
+		if c := code(st.CheckRcpt(ctx, rcpt)); c != 0 {
+			return c
+		}
+	}
+	return code(st.CheckBody(ctx, textproto.Header{}, buffer.MemoryBuffer{}))
+}
+
+func TestMessagesPerHour(t *testing.T) {
+	c := testCheck(t)
+	c.msgsPerHour = 2
+	now := time.Now()
+	c.now = func() time.Time { return now }
+
+	for i := 0; i < 2; i++ {
+		if code := send(t, c, "msg", "user", "a@example.org"); code != 0 {
+			t.Fatalf("message %d rejected with %d", i, code)
+		}
+	}
+	if code := send(t, c, "msg", "user", "a@example.org"); code != 450 {
+		t.Fatalf("expected 450, got %d", code)
+	}
+	if code := send(t, c, "msg", "other", "a@example.org"); code != 0 {
+		t.Fatalf("other user rejected with %d", code)
+	}
+	if code := send(t, c, "msg", "", "a@example.org"); code != 0 {
+		t.Fatalf("unauthenticated message rejected with %d", code)
+	}
+
+	now = now.Add(time.Hour)
+	if code := send(t, c, "msg", "user", "a@example.org"); code != 0 {
+		t.Fatalf("message rejected after the window with %d", code)
+	}
+}
+
+func TestRecipientsPerDay(t *testing.T) {
+	c := testCheck(t)
+	c.rcptsPerDay = 3
+	c.uniquePerDay = 2
+
+	if code := send(t, c, "1", "user", "a@example.org", "A@example.org"); code != 0 {
+		t.Fatalf("rejected with %d", code)
+	}
+	if code := send(t, c, "2", "user", "b@example.org", "c@example.org"); code != 450 {
+		t.Fatalf("expected 450 for unique recipients, got %d", code)
+	}
+	// b@ was not accounted since the message was rejected.
+	if code := send(t, c, "3", "user", "b@example.org"); code != 0 {
+		t.Fatalf("rejected with %d", code)
+	}
+	if code := send(t, c, "4", "user", "a@example.org"); code != 450 {
+		t.Fatalf("expected 450 for recipients, got %d", code)
+	}
+
+	u, err := c.Usage(context.Background(), "user")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if u.Messages != 2 || u.Recipients != 3 || len(u.Unique) != 2 || u.Violations != 2 {
+		t.Fatalf("unexpected usage: %+v", u)
+	}
+}
+
+func TestSuspend(t *testing.T) {
+	c := testCheck(t)
+	c.msgsPerHour = 1
+	c.suspendAfter = 2
+
+	send(t, c, "1", "user", "a@example.org")
+	// Repeated rejections of the same message are one violation.
+	for i := 0; i < 3; i++ {
+		if code := send(t, c, "2", "user", "a@example.org"); code != 450 {
+			t.Fatalf("expected 450, got %d", code)
+		}
+	}
+	u, _ := c.Usage(context.Background(), "user")
+	if !u.Suspended.IsZero() {
+		t.Fatal("suspended too early")
+	}
+
+	if code := send(t, c, "3", "user", "a@example.org"); code != 450 {
+		t.Fatalf("expected 450, got %d", code)
+	}
+	if code := send(t, c, "4", "user", "a@example.org"); code != 550 {
+		t.Fatalf("expected 550 for suspended account, got %d", code)
+	}
+
+	if err := c.Reset("user"); err != nil {
+		t.Fatal(err)
+	}
+	if code := send(t, c, "5", "user", "a@example.org"); code != 0 {
+		t.Fatalf("rejected after reset with %d", code)
+	}
+}
+
+func TestNotification(t *testing.T) {
+	c := testCheck(t)
+	tgt := &testutils.Target{}
+	c.notifyTarget = tgt
+	c.notifyTo = []string{"admin@example.org"}
+	c.autogenDomain = "example.org"
+
+	if err := c.sendNotification(context.Background(), "user", 3, "Hourly message quota exceeded"); err != nil {
+		t.Fatal(err)
+	}
+	if len(tgt.Messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(tgt.Messages))
+	}
+	msg := tgt.Messages[0]
+	if msg.MailFrom != "postmaster@example.org" || len(msg.RcptTo) != 1 || msg.RcptTo[0] != "admin@example.org" {
+		t.Fatalf("wrong envelope: %v %v", msg.MailFrom, msg.RcptTo)
+	}
+	if !strings.Contains(msg.Header.Get("Subject"), "user") || !strings.Contains(string(msg.Body), "user") {
+		t.Fatal("notification does not mention the username")
+	}
+}
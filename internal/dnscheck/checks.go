@@ -0,0 +1,474 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package dnscheck
+
+import (
+	"context"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"fmt"
+	"net"
+	"strings"
+
+	"github.com/foxcpp/go-mtasts"
+	"github.com/mail-chat-chain/mailchatd/framework/dns"
+)
+
+// parseTags parses the tag=value list used by DKIM and DMARC records.
+func parseTags(record string) map[string]string {
+	tags := make(map[string]string)
+	for _, part := range strings.Split(record, ";") {
+		key, value, ok := strings.Cut(part, "=")
+		if !ok {
+			continue
+		}
+		tags[strings.ToLower(strings.TrimSpace(key))] = strings.TrimSpace(value)
+	}
+	return tags
+}
+
+func findTXT(txts []string, prefix string) []string {
+	var res []string
+	for _, txt := range txts {
+		if strings.HasPrefix(strings.ToLower(strings.TrimSpace(txt)), strings.ToLower(prefix)) {
+			res = append(res, txt)
+		}
+	}
+	return res
+}
+
+func (c *Checker) lookupTXT(ctx context.Context, name string) (txts []string, ad bool, err error) {
+	if c.Ext != nil {
+		ad, txts, err = c.Ext.AuthLookupTXT(ctx, name)
+	} else {
+		txts, err = c.Resolver.LookupTXT(ctx, name)
+	}
+	if dns.IsNotFound(err) {
+		return nil, ad, nil
+	}
+	return txts, ad, err
+}
+
+func (c *Checker) checkDNSSEC(ctx context.Context, domain string) Result {
+	res := Result{Check: "dnssec", Name: domain, Weight: 5}
+	if c.Ext == nil {
+		res.Status = StatusSkip
+		res.Message = c.Printer.Sprintf("DNSSEC check requires a validating resolver")
+		return res
+	}
+	ad, _, err := c.Ext.AuthLookupMX(ctx, domain)
+	switch {
+	case err != nil && !dns.IsNotFound(err):
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+	case ad:
+		res.Status = StatusOK
+		res.DNSSEC = true
+		res.Message = c.Printer.Sprintf("DNSSEC validated (AD bit set)")
+	default:
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("Zone is not DNSSEC-signed or resolver does not validate")
+	}
+	return res
+}
+
+func (c *Checker) checkMX(ctx context.Context, domain string) (Result, []string) {
+	res := Result{Check: "mx", Name: domain, Weight: 20}
+
+	var (
+		mxs []*net.MX
+		err error
+	)
+	if c.Ext != nil {
+		res.DNSSEC, mxs, err = c.Ext.AuthLookupMX(ctx, domain)
+	} else {
+		mxs, err = c.Resolver.LookupMX(ctx, domain)
+	}
+	if err != nil && !dns.IsNotFound(err) {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res, nil
+	}
+	if len(mxs) == 0 {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("No MX records found")
+		return res, nil
+	}
+
+	hosts := make([]string, 0, len(mxs))
+	for _, mx := range mxs {
+		host := strings.TrimSuffix(mx.Host, ".")
+		hosts = append(hosts, host)
+		res.Values = append(res.Values, fmt.Sprintf("%d %s", mx.Pref, host))
+	}
+	res.Status = StatusOK
+	res.Message = c.Printer.Sprintf("%d MX record(s) found", len(mxs))
+	return res, hosts
+}
+
+func (c *Checker) checkHost(ctx context.Context, host string) (Result, []string) {
+	res := Result{Check: "mx_address", Name: host, Weight: 10}
+	addrs, err := c.Resolver.LookupHost(ctx, host)
+	if err != nil && !dns.IsNotFound(err) {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res, nil
+	}
+	if len(addrs) == 0 {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("MX host has no addresses")
+		return res, nil
+	}
+	res.Status = StatusOK
+	res.Values = addrs
+	res.Message = c.Printer.Sprintf("MX host resolves to %d address(es)", len(addrs))
+	return res, addrs
+}
+
+func (c *Checker) checkSPF(ctx context.Context, domain string) Result {
+	res := Result{Check: "spf", Name: domain, Weight: 15}
+	txts, ad, err := c.lookupTXT(ctx, domain)
+	res.DNSSEC = ad
+	if err != nil {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res
+	}
+
+	spf := findTXT(txts, "v=spf1")
+	res.Values = spf
+	switch {
+	case len(spf) == 0:
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("No SPF record found")
+	case len(spf) > 1:
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Multiple SPF records found")
+	default:
+		fields := strings.Fields(spf[0])
+		all := fields[len(fields)-1]
+		if all == "+all" || all == "?all" || all == "all" {
+			res.Status = StatusWarn
+			res.Message = c.Printer.Sprintf("SPF policy is too permissive (%s)", all)
+			return res
+		}
+		res.Status = StatusOK
+		res.Message = c.Printer.Sprintf("SPF record found")
+	}
+	return res
+}
+
+func (c *Checker) checkDKIM(ctx context.Context, domain, selector string) Result {
+	name := selector + "._domainkey." + domain
+	res := Result{Check: "dkim", Name: name, Weight: 15}
+	txts, ad, err := c.lookupTXT(ctx, name)
+	res.DNSSEC = ad
+	if err != nil {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res
+	}
+
+	var record string
+	for _, txt := range txts {
+		if strings.Contains(txt, "p=") {
+			record = txt
+			break
+		}
+	}
+	if record == "" {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("No DKIM record found for selector %s", selector)
+		return res
+	}
+	res.Values = []string{record}
+
+	tags := parseTags(record)
+	keyB64 := strings.Join(strings.Fields(tags["p"]), "")
+	if keyB64 == "" {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("DKIM key is revoked")
+		return res
+	}
+	keyBlob, err := base64.StdEncoding.DecodeString(keyB64)
+	if err != nil {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Malformed DKIM record: %v", err)
+		return res
+	}
+
+	switch strings.ToLower(tags["k"]) {
+	case "", "rsa":
+		bits, err := rsaKeyBits(keyBlob)
+		if err != nil {
+			res.Status = StatusFail
+			res.Message = c.Printer.Sprintf("Malformed DKIM record: %v", err)
+			return res
+		}
+		switch {
+		case bits < 1024:
+			res.Status = StatusFail
+			res.Message = c.Printer.Sprintf("DKIM RSA key is too weak (%d bits)", bits)
+		case bits < 2048:
+			res.Status = StatusWarn
+			res.Message = c.Printer.Sprintf("DKIM RSA key is shorter than recommended (%d bits)", bits)
+		default:
+			res.Status = StatusOK
+			res.Message = c.Printer.Sprintf("DKIM RSA key, %d bits", bits)
+		}
+	case "ed25519":
+		if len(keyBlob) != 32 {
+			res.Status = StatusFail
+			res.Message = c.Printer.Sprintf("Malformed DKIM record: %v", fmt.Errorf("invalid Ed25519 key length %d", len(keyBlob)))
+			return res
+		}
+		res.Status = StatusOK
+		res.Message = c.Printer.Sprintf("DKIM Ed25519 key")
+	default:
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Malformed DKIM record: %v", fmt.Errorf("unknown key type %s", tags["k"]))
+	}
+	return res
+}
+
+func rsaKeyBits(blob []byte) (int, error) {
+	if key, err := x509.ParsePKIXPublicKey(blob); err == nil {
+		rsaKey, ok := key.(*rsa.PublicKey)
+		if !ok {
+			return 0, fmt.Errorf("not an RSA key: %T", key)
+		}
+		return rsaKey.N.BitLen(), nil
+	}
+	key, err := x509.ParsePKCS1PublicKey(blob)
+	if err != nil {
+		return 0, err
+	}
+	return key.N.BitLen(), nil
+}
+
+func (c *Checker) checkDMARC(ctx context.Context, domain string) Result {
+	name := "_dmarc." + domain
+	res := Result{Check: "dmarc", Name: name, Weight: 15}
+	txts, ad, err := c.lookupTXT(ctx, name)
+	res.DNSSEC = ad
+	if err != nil {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res
+	}
+
+	dmarc := findTXT(txts, "v=DMARC1")
+	if len(dmarc) == 0 {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("No DMARC record found")
+		return res
+	}
+	res.Values = dmarc
+
+	switch policy := strings.ToLower(parseTags(dmarc[0])["p"]); policy {
+	case "quarantine", "reject":
+		res.Status = StatusOK
+		res.Message = c.Printer.Sprintf("DMARC policy is %s", policy)
+	case "none":
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("DMARC policy is none (monitoring only)")
+	default:
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Malformed DMARC record")
+	}
+	return res
+}
+
+func (c *Checker) checkMTASTS(ctx context.Context, domain string, mxHosts []string) Result {
+	name := "_mta-sts." + domain
+	res := Result{Check: "mta_sts", Name: name, Weight: 5}
+	txts, ad, err := c.lookupTXT(ctx, name)
+	res.DNSSEC = ad
+	if err != nil {
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res
+	}
+	records := findTXT(txts, "v=STSv1")
+	if len(records) == 0 {
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("No MTA-STS record found")
+		return res
+	}
+	res.Values = records
+
+	if c.FetchMTASTS == nil {
+		res.Status = StatusOK
+		res.Message = c.Printer.Sprintf("MTA-STS record found")
+		return res
+	}
+	policy, err := c.FetchMTASTS(ctx, domain)
+	if err != nil {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("MTA-STS policy fetch failed: %v", err)
+		return res
+	}
+	for _, mx := range mxHosts {
+		if !policy.Match(mx) {
+			res.Status = StatusFail
+			res.Message = c.Printer.Sprintf("MTA-STS policy does not cover MX %s", mx)
+			return res
+		}
+	}
+	if policy.Mode != mtasts.ModeEnforce {
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("MTA-STS policy is in %s mode", string(policy.Mode))
+		return res
+	}
+	res.Status = StatusOK
+	res.Message = c.Printer.Sprintf("MTA-STS policy is enforced")
+	return res
+}
+
+func (c *Checker) checkTLSRPT(ctx context.Context, domain string) Result {
+	name := "_smtp._tls." + domain
+	res := Result{Check: "tlsrpt", Name: name, Weight: 5}
+	txts, ad, err := c.lookupTXT(ctx, name)
+	res.DNSSEC = ad
+	if err != nil {
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res
+	}
+	records := findTXT(txts, "v=TLSRPTv1")
+	if len(records) == 0 {
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("No TLSRPT record found")
+		return res
+	}
+	res.Values = records
+	res.Status = StatusOK
+	res.Message = c.Printer.Sprintf("TLSRPT record found")
+	return res
+}
+
+func (c *Checker) checkTLSA(ctx context.Context, host string) Result {
+	res := Result{Check: "tlsa", Name: "_25._tcp." + host, Weight: 5}
+	if c.Ext == nil {
+		res.Status = StatusSkip
+		res.Message = c.Printer.Sprintf("DNSSEC check requires a validating resolver")
+		return res
+	}
+
+	ad, recs, err := c.Ext.AuthLookupTLSA(ctx, "25", "tcp", host)
+	res.DNSSEC = ad
+	if err != nil && !dns.IsNotFound(err) {
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res
+	}
+	if len(recs) == 0 {
+		res.Status = StatusSkip
+		res.Message = c.Printer.Sprintf("No TLSA records (DANE not used)")
+		return res
+	}
+	for _, rec := range recs {
+		res.Values = append(res.Values, fmt.Sprintf("%d %d %d %s", rec.Usage, rec.Selector, rec.MatchingType, rec.Certificate))
+	}
+	if !ad {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("TLSA records are not DNSSEC-signed and will be ignored by senders")
+		return res
+	}
+	if c.ServerTLS == nil {
+		res.Status = StatusOK
+		res.Message = c.Printer.Sprintf("TLSA records found")
+		return res
+	}
+
+	state, err := c.ServerTLS(ctx, host)
+	if err != nil {
+		res.Status = StatusWarn
+		res.Message = c.Printer.Sprintf("Unable to get server certificate: %v", err)
+		return res
+	}
+	if tlsaMatch(recs, state.PeerCertificates) {
+		res.Status = StatusOK
+		res.Message = c.Printer.Sprintf("TLSA record matches the server certificate")
+		return res
+	}
+	res.Status = StatusFail
+	res.Message = c.Printer.Sprintf("TLSA records do not match the server certificate")
+	return res
+}
+
+// tlsaMatch checks whether any of DANE-EE or DANE-TA records match the
+// certificate chain presented by the server (RFC 7672, Section 3.1).
+func tlsaMatch(recs []dns.TLSA, chain []*x509.Certificate) bool {
+	if len(chain) == 0 {
+		return false
+	}
+	for _, rec := range recs {
+		switch rec.Usage {
+		case 3: // DANE-EE
+			if rec.Verify(chain[0]) == nil {
+				return true
+			}
+		case 2: // DANE-TA
+			for _, cert := range chain[1:] {
+				if rec.Verify(cert) == nil {
+					return true
+				}
+			}
+		}
+	}
+	return false
+}
+
+// checkPTR checks that the address has a PTR record and the name resolves
+// back to the address (forward-confirmed reverse DNS).
+func (c *Checker) checkPTR(ctx context.Context, ip string) Result {
+	res := Result{Check: "ptr", Name: ip, Weight: 10}
+	names, err := c.Resolver.LookupAddr(ctx, ip)
+	if err != nil && !dns.IsNotFound(err) {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("Lookup failed: %v", err)
+		return res
+	}
+	if len(names) == 0 {
+		res.Status = StatusFail
+		res.Message = c.Printer.Sprintf("No PTR record")
+		return res
+	}
+	res.Values = names
+
+	parsedIP := net.ParseIP(ip)
+	for _, name := range names {
+		addrs, err := c.Resolver.LookupHost(ctx, name)
+		if err != nil {
+			continue
+		}
+		for _, addr := range addrs {
+			if net.ParseIP(addr).Equal(parsedIP) {
+				res.Status = StatusOK
+				res.Message = c.Printer.Sprintf("Forward-confirmed reverse DNS: %s", strings.TrimSuffix(name, "."))
+				return res
+			}
+		}
+	}
+	res.Status = StatusWarn
+	res.Message = c.Printer.Sprintf("PTR %s does not resolve back to the address", strings.TrimSuffix(names[0], "."))
+	return res
+}
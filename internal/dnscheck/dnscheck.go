@@ -0,0 +1,203 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package dnscheck implements checks of DNS records relevant for mail
+// deliverability: MX, SPF, DKIM, DMARC, MTA-STS, TLSRPT, DANE and reverse DNS.
+//
+// Results are scored and messages are localized using the printer passed to
+// the Checker.
+package dnscheck
+
+import (
+	"context"
+	"crypto/tls"
+	"math"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-smtp"
+	"github.com/foxcpp/go-mtasts"
+	"github.com/mail-chat-chain/mailchatd/framework/dns"
+	"golang.org/x/text/message"
+)
+
+type Status string
+
+const (
+	StatusOK   Status = "ok"
+	StatusWarn Status = "warn"
+	StatusFail Status = "fail"
+	StatusSkip Status = "skip"
+)
+
+// Result is the outcome of a single check.
+type Result struct {
+	// Check is the stable identifier of the check, e.g. "spf".
+	Check string `json:"check"`
+	// Name is the DNS name (or address) that was checked.
+	Name    string `json:"name"`
+	Status  Status `json:"status"`
+	Message string `json:"message"`
+	// Values contains the relevant records as they were found.
+	Values []string `json:"values,omitempty"`
+	// DNSSEC is set if the records were DNSSEC-authenticated.
+	DNSSEC bool `json:"dnssec"`
+	// Weight is the contribution of the check to the overall score.
+	Weight int `json:"weight"`
+}
+
+type Report struct {
+	Domain  string   `json:"domain"`
+	Results []Result `json:"results"`
+	// Score is the overall deliverability score in range 0-100.
+	Score int `json:"score"`
+}
+
+// Checker runs the checks. Only Resolver and Printer are required, checks
+// that need other fields are skipped if they are not set.
+type Checker struct {
+	Resolver dns.Resolver
+	// Ext is used for DNSSEC-aware lookups (AD bit and TLSA records).
+	Ext *dns.ExtResolver
+
+	Printer *message.Printer
+
+	// DKIMSelectors to look up, "default" is used if empty.
+	DKIMSelectors []string
+	// ServerIPs are additionally checked for PTR/FCrDNS.
+	ServerIPs []string
+
+	// FetchMTASTS downloads the MTA-STS policy for the domain.
+	FetchMTASTS func(ctx context.Context, domain string) (*mtasts.Policy, error)
+	// ServerTLS connects to the MX host and returns the TLS connection state
+	// after STARTTLS.
+	ServerTLS func(ctx context.Context, host string) (tls.ConnectionState, error)
+}
+
+// NewChecker returns the Checker using the framework resolver, DNSSEC-aware
+// resolver (if it can be configured) and the network for MTA-STS and TLS
+// checks.
+func NewChecker(p *message.Printer) *Checker {
+	c := &Checker{
+		Resolver:  dns.DefaultResolver(),
+		Printer:   p,
+		ServerTLS: smtpTLSState,
+	}
+	if ext, err := dns.NewExtResolver(); err == nil {
+		c.Ext = ext
+	}
+	c.FetchMTASTS = func(ctx context.Context, domain string) (*mtasts.Policy, error) {
+		cache := mtasts.NewNopCache()
+		cache.Resolver = c.Resolver
+		return cache.Get(ctx, domain)
+	}
+	return c
+}
+
+func smtpTLSState(ctx context.Context, host string) (tls.ConnectionState, error) {
+	dialer := net.Dialer{Timeout: 10 * time.Second}
+	conn, err := dialer.DialContext(ctx, "tcp", net.JoinHostPort(host, "25"))
+	if err != nil {
+		return tls.ConnectionState{}, err
+	}
+	if deadline, ok := ctx.Deadline(); ok {
+		_ = conn.SetDeadline(deadline)
+	}
+
+	cl := smtp.NewClient(conn)
+	defer cl.Close()
+	if err := cl.Hello("localhost"); err != nil {
+		return tls.ConnectionState{}, err
+	}
+	if err := cl.StartTLS(&tls.Config{
+		ServerName: host,
+		// Certificate is verified against TLSA records instead.
+		InsecureSkipVerify: true,
+	}); err != nil {
+		return tls.ConnectionState{}, err
+	}
+	state, _ := cl.TLSConnectionState()
+	return state, nil
+}
+
+// Check runs all checks for the domain.
+func (c *Checker) Check(ctx context.Context, domain string) *Report {
+	domain = strings.TrimSuffix(domain, ".")
+
+	r := &Report{Domain: domain}
+	add := func(res ...Result) {
+		r.Results = append(r.Results, res...)
+	}
+
+	add(c.checkDNSSEC(ctx, domain))
+	mxRes, mxHosts := c.checkMX(ctx, domain)
+	add(mxRes)
+	ips := append([]string(nil), c.ServerIPs...)
+	for _, host := range mxHosts {
+		res, addrs := c.checkHost(ctx, host)
+		add(res)
+		ips = append(ips, addrs...)
+	}
+	add(c.checkSPF(ctx, domain))
+	selectors := c.DKIMSelectors
+	if len(selectors) == 0 {
+		selectors = []string{"default"}
+	}
+	for _, sel := range selectors {
+		add(c.checkDKIM(ctx, domain, sel))
+	}
+	add(c.checkDMARC(ctx, domain))
+	add(c.checkMTASTS(ctx, domain, mxHosts))
+	add(c.checkTLSRPT(ctx, domain))
+	for _, host := range mxHosts {
+		add(c.checkTLSA(ctx, host))
+	}
+	seen := make(map[string]bool)
+	for _, ip := range ips {
+		if seen[ip] {
+			continue
+		}
+		seen[ip] = true
+		add(c.checkPTR(ctx, ip))
+	}
+
+	r.Score = score(r.Results)
+	return r
+}
+
+// score computes the weighted score. Warnings count as half of the weight,
+// skipped checks are not counted.
+func score(results []Result) int {
+	var got, total float64
+	for _, res := range results {
+		switch res.Status {
+		case StatusOK:
+			got += float64(res.Weight)
+		case StatusWarn:
+			got += float64(res.Weight) / 2
+		case StatusSkip:
+			continue
+		}
+		total += float64(res.Weight)
+	}
+	if total == 0 {
+		return 0
+	}
+	return int(math.Round(100 * got / total))
+}
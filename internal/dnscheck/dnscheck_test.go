@@ -0,0 +1,192 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package dnscheck
+
+import (
+	"context"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/x509"
+	"encoding/base64"
+	"net"
+	"testing"
+
+	"github.com/foxcpp/go-mockdns"
+	"github.com/foxcpp/go-mtasts"
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+)
+
+func dkimRecord(t *testing.T, bits int) string {
+	t.Helper()
+	key, err := rsa.GenerateKey(rand.Reader, bits)
+	if err != nil {
+		t.Fatal(err)
+	}
+	blob, err := x509.MarshalPKIXPublicKey(&key.PublicKey)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return "v=DKIM1; k=rsa; p=" + base64.StdEncoding.EncodeToString(blob)
+}
+
+func testChecker(zones map[string]mockdns.Zone) *Checker {
+	return &Checker{
+		Resolver: &mockdns.Resolver{Zones: zones},
+		Printer:  message.NewPrinter(language.English),
+	}
+}
+
+func findResult(t *testing.T, r *Report, check string) Result {
+	t.Helper()
+	for _, res := range r.Results {
+		if res.Check == check {
+			return res
+		}
+	}
+	t.Fatalf("no %s result in report", check)
+	return Result{}
+}
+
+func TestCheck(t *testing.T) {
+	zones := map[string]mockdns.Zone{
+		"example.org.": {
+			MX:  []net.MX{{Host: "mx.example.org.", Pref: 10}},
+			TXT: []string{"v=spf1 mx -all"},
+		},
+		"mx.example.org.": {
+			A: []string{"192.0.2.1"},
+		},
+		"1.2.0.192.in-addr.arpa.": {
+			PTR: []string{"mx.example.org."},
+		},
+		"default._domainkey.example.org.": {
+			TXT: []string{dkimRecord(t, 2048)},
+		},
+		"_dmarc.example.org.": {
+			TXT: []string{"v=DMARC1; p=reject"},
+		},
+		"_mta-sts.example.org.": {
+			TXT: []string{"v=STSv1; id=1"},
+		},
+		"_smtp._tls.example.org.": {
+			TXT: []string{"v=TLSRPTv1; rua=mailto:tls@example.org"},
+		},
+	}
+	c := testChecker(zones)
+	c.FetchMTASTS = func(ctx context.Context, domain string) (*mtasts.Policy, error) {
+		return &mtasts.Policy{Mode: mtasts.ModeEnforce, MaxAge: 86400, MX: []string{"mx.example.org"}}, nil
+	}
+
+	r := c.Check(context.Background(), "example.org")
+	for _, check := range []string{"mx", "mx_address", "spf", "dkim", "dmarc", "mta_sts", "tlsrpt", "ptr"} {
+		if res := findResult(t, r, check); res.Status != StatusOK {
+			t.Errorf("%s: expected ok, got %s (%s)", check, res.Status, res.Message)
+		}
+	}
+	for _, check := range []string{"dnssec", "tlsa"} {
+		if res := findResult(t, r, check); res.Status != StatusSkip {
+			t.Errorf("%s: expected skip, got %s (%s)", check, res.Status, res.Message)
+		}
+	}
+	if r.Score != 100 {
+		t.Errorf("expected score 100, got %d", r.Score)
+	}
+}
+
+func TestCheck_Weak(t *testing.T) {
+	zones := map[string]mockdns.Zone{
+		"example.org.": {
+			TXT: []string{"v=spf1 +all"},
+		},
+		"default._domainkey.example.org.": {
+			TXT: []string{dkimRecord(t, 1024)},
+		},
+		"_dmarc.example.org.": {
+			TXT: []string{"v=DMARC1; p=none"},
+		},
+	}
+	c := testChecker(zones)
+
+	r := c.Check(context.Background(), "example.org")
+	expected := map[string]Status{
+		"mx":      StatusFail,
+		"spf":     StatusWarn,
+		"dkim":    StatusWarn,
+		"dmarc":   StatusWarn,
+		"mta_sts": StatusWarn,
+		"tlsrpt":  StatusWarn,
+	}
+	for check, status := range expected {
+		if res := findResult(t, r, check); res.Status != status {
+			t.Errorf("%s: expected %s, got %s (%s)", check, status, res.Status, res.Message)
+		}
+	}
+	if r.Score <= 0 || r.Score >= 50 {
+		t.Errorf("unexpected score %d", r.Score)
+	}
+}
+
+func TestCheck_DKIMRevoked(t *testing.T) {
+	c := testChecker(map[string]mockdns.Zone{
+		"sel._domainkey.example.org.": {
+			TXT: []string{"v=DKIM1; p="},
+		},
+	})
+	res := c.checkDKIM(context.Background(), "example.org", "sel")
+	if res.Status != StatusFail {
+		t.Fatalf("expected fail, got %s", res.Status)
+	}
+}
+
+func TestCheck_MTASTSMismatch(t *testing.T) {
+	c := testChecker(map[string]mockdns.Zone{
+		"_mta-sts.example.org.": {
+			TXT: []string{"v=STSv1; id=1"},
+		},
+	})
+	c.FetchMTASTS = func(ctx context.Context, domain string) (*mtasts.Policy, error) {
+		return &mtasts.Policy{Mode: mtasts.ModeEnforce, MX: []string{"mx.example.org"}}, nil
+	}
+	res := c.checkMTASTS(context.Background(), "example.org", []string{"mx.example.com"})
+	if res.Status != StatusFail {
+		t.Fatalf("expected fail, got %s", res.Status)
+	}
+}
+
+func TestNewPrinter(t *testing.T) {
+	for lang, expected := range map[string]string{
+		"":            "No SPF record found",
+		"en_US.UTF-8": "No SPF record found",
+		"zh_CN.UTF-8": "未找到 SPF 记录",
+		"zh":          "未找到 SPF 记录",
+	} {
+		t.Setenv("LC_ALL", "")
+		t.Setenv("LC_MESSAGES", "")
+		t.Setenv("LANG", "")
+		if got := NewPrinter(lang).Sprintf("No SPF record found"); got != expected {
+			t.Errorf("%q: expected %q, got %q", lang, expected, got)
+		}
+	}
+
+	t.Setenv("LANG", "zh_CN.UTF-8")
+	if got := NewPrinter("").Sprintf("Score: %d/100", 42); got != "得分: 42/100" {
+		t.Errorf("unexpected message from LANG: %q", got)
+	}
+}
@@ -0,0 +1,145 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package dnscheck
+
+import (
+	"os"
+	"strings"
+
+	"golang.org/x/text/language"
+	"golang.org/x/text/message"
+	"golang.org/x/text/message/catalog"
+)
+
+// Messages are keyed by their English text, translations are listed below.
+var translations = map[language.Tag]map[string]string{
+	language.Chinese: {
+		"Lookup failed: %v": "查询失败: %v",
+
+		"DNSSEC check requires a validating resolver":             "DNSSEC 检查需要支持验证的解析器",
+		"DNSSEC validated (AD bit set)":                           "DNSSEC 验证通过 (AD 标志已设置)",
+		"Zone is not DNSSEC-signed or resolver does not validate": "区域未进行 DNSSEC 签名或解析器不进行验证",
+
+		"No MX records found":                "未找到 MX 记录",
+		"%d MX record(s) found":              "找到 %d 条 MX 记录",
+		"MX host has no addresses":           "MX 主机没有 IP 地址",
+		"MX host resolves to %d address(es)": "MX 主机解析到 %d 个地址",
+
+		"No SPF record found":               "未找到 SPF 记录",
+		"Multiple SPF records found":        "存在多条 SPF 记录",
+		"SPF policy is too permissive (%s)": "SPF 策略过于宽松 (%s)",
+		"SPF record found":                  "SPF 记录正常",
+
+		"No DKIM record found for selector %s":               "未找到选择器 %s 的 DKIM 记录",
+		"DKIM key is revoked":                                "DKIM 密钥已被撤销",
+		"Malformed DKIM record: %v":                          "DKIM 记录格式错误: %v",
+		"DKIM RSA key is too weak (%d bits)":                 "DKIM RSA 密钥强度过低 (%d 位)",
+		"DKIM RSA key is shorter than recommended (%d bits)": "DKIM RSA 密钥短于推荐长度 (%d 位)",
+		"DKIM RSA key, %d bits":                              "DKIM RSA 密钥, %d 位",
+		"DKIM Ed25519 key":                                   "DKIM Ed25519 密钥",
+
+		"No DMARC record found":                  "未找到 DMARC 记录",
+		"DMARC policy is %s":                     "DMARC 策略为 %s",
+		"DMARC policy is none (monitoring only)": "DMARC 策略为 none (仅监控)",
+		"Malformed DMARC record":                 "DMARC 记录格式错误",
+
+		"No MTA-STS record found":             "未找到 MTA-STS 记录",
+		"MTA-STS record found":                "MTA-STS 记录正常",
+		"MTA-STS policy fetch failed: %v":     "获取 MTA-STS 策略失败: %v",
+		"MTA-STS policy does not cover MX %s": "MTA-STS 策略未包含 MX 主机 %s",
+		"MTA-STS policy is in %s mode":        "MTA-STS 策略处于 %s 模式",
+		"MTA-STS policy is enforced":          "MTA-STS 策略已强制执行",
+		"No TLSRPT record found":              "未找到 TLSRPT 记录",
+		"TLSRPT record found":                 "TLSRPT 记录正常",
+
+		"No TLSA records (DANE not used)":                                   "没有 TLSA 记录 (未使用 DANE)",
+		"TLSA records are not DNSSEC-signed and will be ignored by senders": "TLSA 记录未经 DNSSEC 签名, 发送方将忽略它们",
+		"TLSA records found":                                                "TLSA 记录存在",
+		"Unable to get server certificate: %v":                              "无法获取服务器证书: %v",
+		"TLSA record matches the server certificate":                        "TLSA 记录与服务器证书匹配",
+		"TLSA records do not match the server certificate":                  "TLSA 记录与服务器证书不匹配",
+
+		"No PTR record":                               "未配置反向 DNS (PTR) 记录",
+		"Forward-confirmed reverse DNS: %s":           "反向 DNS 已正向确认: %s",
+		"PTR %s does not resolve back to the address": "PTR 记录 %s 未解析回该地址",
+
+		"Check":          "检查项",
+		"Status":         "状态",
+		"Name":           "名称",
+		"Details":        "详情",
+		"OK":             "正常",
+		"WARN":           "警告",
+		"FAIL":           "失败",
+		"SKIP":           "跳过",
+		"Score: %d/100":  "得分: %d/100",
+		"Checking %s...": "正在检查 %s...",
+
+		"Specify the domain or make sure the configuration file exists": "请指定域名或确保配置文件存在",
+	},
+}
+
+var (
+	messages = catalog.NewBuilder(catalog.Fallback(language.English))
+	matcher  language.Matcher
+)
+
+func init() {
+	tags := []language.Tag{language.English}
+	for tag, msgs := range translations {
+		for key, msg := range msgs {
+			if err := messages.SetString(tag, key, msg); err != nil {
+				panic(err)
+			}
+		}
+		tags = append(tags, tag)
+	}
+	matcher = language.NewMatcher(tags)
+}
+
+// NewPrinter returns the printer for the language. If lang is empty, it is
+// taken from the LC_ALL, LC_MESSAGES or LANG environment variables.
+func NewPrinter(lang string) *message.Printer {
+	if lang == "" {
+		for _, env := range []string{"LC_ALL", "LC_MESSAGES", "LANG"} {
+			if lang = os.Getenv(env); lang != "" {
+				break
+			}
+		}
+	}
+	// POSIX locale names: zh_CN.UTF-8
+	lang, _, _ = strings.Cut(lang, ".")
+	lang = strings.ReplaceAll(lang, "_", "-")
+
+	tag, _, _ := matcher.Match(language.Make(lang))
+	return message.NewPrinter(tag, message.Catalog(messages))
+}
+
+// StatusText returns the localized status label.
+func StatusText(p *message.Printer, s Status) string {
+	switch s {
+	case StatusOK:
+		return p.Sprintf("OK")
+	case StatusWarn:
+		return p.Sprintf("WARN")
+	case StatusFail:
+		return p.Sprintf("FAIL")
+	default:
+		return p.Sprintf("SKIP")
+	}
+}
@@ -0,0 +1,317 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package admin implements the admin endpoint module that exposes the
+// management REST API inside the running server.
+//
+// The API mirrors the CLI subcommands (creds, imap-acct, imap-mboxes,
+// imap-msgs) and additionally gives access to the delivery queue and
+// mutable tables. Using it instead of opening the storage directly is safe
+// while the server is running.
+//
+// Clients are authenticated using bearer tokens, TLS client certificates or
+// by connecting over a unix socket. Each client is granted a set of scopes
+// (RESOURCE:read or RESOURCE:write), roles are named sets of scopes.
+// Unix socket clients get the read-only role unless unix_scopes is set.
+package admin
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	tls2 "github.com/mail-chat-chain/mailchatd/framework/config/tls"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target/queue"
+)
+
+const modName = "admin"
+
+type Endpoint struct {
+	addrs  []string
+	logger log.Logger
+
+	tokens     []token
+	certs      map[string]map[string]bool
+	unixScopes map[string]bool
+	audit      *auditLog
+
+	userDB  module.PlainUserDB
+	storage module.Storage
+	queue   *queue.Queue
+	tables  map[string]module.Table
+
+	listenersWg sync.WaitGroup
+	serv        http.Server
+	mux         *http.ServeMux
+}
+
+func New(_ string, args []string) (module.Module, error) {
+	return &Endpoint{
+		addrs:  args,
+		logger: log.Logger{Name: modName},
+		tables: make(map[string]module.Table),
+	}, nil
+}
+
+type grant struct {
+	name   string
+	hash   []byte
+	scopes []string
+}
+
+func (e *Endpoint) Init(cfg *config.Map) error {
+	var (
+		tlsConfig  *tls.Config
+		clientCA   string
+		auditPath  string
+		unixScopes []string
+		tokens     []grant
+		certs      []grant
+	)
+	roles := builtinRoles()
+
+	cfg.Bool("debug", true, false, &e.logger.Debug)
+	cfg.Custom("tls", true, false, nil, tls2.TLSDirective, &tlsConfig)
+	cfg.String("client_ca", false, false, "", &clientCA)
+	cfg.Callback("role", func(m *config.Map, node config.Node) error {
+		if len(node.Args) < 2 {
+			return config.NodeErr(node, "expected at least 2 arguments: role name and scopes")
+		}
+		for _, s := range node.Args[1:] {
+			if !isScope(s) {
+				return config.NodeErr(node, "unknown scope: %s", s)
+			}
+		}
+		roles[node.Args[0]] = node.Args[1:]
+		return nil
+	})
+	cfg.Callback("token", func(m *config.Map, node config.Node) error {
+		if len(node.Args) < 3 {
+			return config.NodeErr(node, "expected at least 3 arguments: token name, hash and scopes")
+		}
+		hash, err := parseTokenHash(node.Args[1])
+		if err != nil {
+			return config.NodeErr(node, "%v", err)
+		}
+		tokens = append(tokens, grant{name: node.Args[0], hash: hash, scopes: node.Args[2:]})
+		return nil
+	})
+	cfg.Callback("client_cert", func(m *config.Map, node config.Node) error {
+		if len(node.Args) < 2 {
+			return config.NodeErr(node, "expected at least 2 arguments: certificate common name and scopes")
+		}
+		certs = append(certs, grant{name: node.Args[0], scopes: node.Args[1:]})
+		return nil
+	})
+	cfg.StringList("unix_scopes", false, false, []string{"read-only"}, &unixScopes)
+	cfg.String("audit_log", false, false, filepath.Join(config.StateDirectory, "admin_audit.log"), &auditPath)
+	cfg.Custom("credentials", false, false, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var db module.PlainUserDB
+		err := modconfig.ModuleFromNode("auth", node.Args, node, m.Globals, &db)
+		return db, err
+	}, &e.userDB)
+	cfg.Custom("storage", false, false, nil, modconfig.StorageDirective, &e.storage)
+	cfg.Custom("queue", false, false, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var q *queue.Queue
+		err := modconfig.ModuleFromNode("target", node.Args, node, m.Globals, &q)
+		return q, err
+	}, &e.queue)
+	cfg.Callback("table", func(m *config.Map, node config.Node) error {
+		if len(node.Args) < 2 {
+			return config.NodeErr(node, "expected at least 2 arguments: table name and module reference")
+		}
+		var tbl module.Table
+		if err := modconfig.ModuleFromNode("table", node.Args[1:], node, m.Globals, &tbl); err != nil {
+			return err
+		}
+		e.tables[node.Args[0]] = tbl
+		return nil
+	})
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	// Roles can be defined after they are used so scopes are resolved only
+	// after all directives are read.
+	for _, g := range tokens {
+		scopes, err := resolveScopes(roles, g.scopes)
+		if err != nil {
+			return fmt.Errorf("%s: token %s: %w", modName, g.name, err)
+		}
+		e.tokens = append(e.tokens, token{name: g.name, hash: g.hash, scopes: scopes})
+	}
+	e.certs = make(map[string]map[string]bool, len(certs))
+	for _, g := range certs {
+		scopes, err := resolveScopes(roles, g.scopes)
+		if err != nil {
+			return fmt.Errorf("%s: client_cert %s: %w", modName, g.name, err)
+		}
+		e.certs[g.name] = scopes
+	}
+	if len(unixScopes) != 0 && !(len(unixScopes) == 1 && unixScopes[0] == "none") {
+		var err error
+		e.unixScopes, err = resolveScopes(roles, unixScopes)
+		if err != nil {
+			return fmt.Errorf("%s: unix_scopes: %w", modName, err)
+		}
+	}
+
+	if clientCA != "" {
+		if tlsConfig == nil {
+			return fmt.Errorf("%s: client_ca requires TLS configuration", modName)
+		}
+		var err error
+		tlsConfig, err = clientAuthConfig(tlsConfig, clientCA)
+		if err != nil {
+			return fmt.Errorf("%s: %w", modName, err)
+		}
+	}
+
+	if auditPath != "off" {
+		var err error
+		e.audit, err = openAuditLog(auditPath)
+		if err != nil {
+			return fmt.Errorf("%s: %w", modName, err)
+		}
+	}
+
+	e.setupServer()
+
+	if module.NoRun {
+		return nil
+	}
+	return e.setupListeners(tlsConfig)
+}
+
+func (e *Endpoint) setupServer() {
+	e.mux = http.NewServeMux()
+	e.registerRoutes()
+	e.serv.Handler = e.mux
+	e.serv.ReadHeaderTimeout = 30 * time.Second
+	e.serv.ErrorLog = stdlog.New(e.logger.DebugWriter(), "", 0)
+	e.serv.ConnContext = func(ctx context.Context, c net.Conn) context.Context {
+		if c.LocalAddr().Network() == "unix" {
+			return context.WithValue(ctx, connKindKey{}, connUnix)
+		}
+		return context.WithValue(ctx, connKindKey{}, connTCP)
+	}
+}
+
+// clientAuthConfig wraps the server TLS configuration to request and verify
+// client certificates signed by the CA.
+func clientAuthConfig(base *tls.Config, caPath string) (*tls.Config, error) {
+	caBlob, err := os.ReadFile(caPath)
+	if err != nil {
+		return nil, err
+	}
+	pool := x509.NewCertPool()
+	if !pool.AppendCertsFromPEM(caBlob) {
+		return nil, fmt.Errorf("no certificates found in %s", caPath)
+	}
+
+	return &tls.Config{
+		GetConfigForClient: func(hello *tls.ClientHelloInfo) (*tls.Config, error) {
+			cfg, err := base.GetConfigForClient(hello)
+			if err != nil {
+				return nil, err
+			}
+			cfg = cfg.Clone()
+			cfg.ClientAuth = tls.VerifyClientCertIfGiven
+			cfg.ClientCAs = pool
+			return cfg, nil
+		},
+	}, nil
+}
+
+func (e *Endpoint) setupListeners(tlsConfig *tls.Config) error {
+	for _, a := range e.addrs {
+		endp, err := config.ParseEndpoint(a)
+		if err != nil {
+			return fmt.Errorf("%s: malformed endpoint: %v", modName, err)
+		}
+		if endp.Scheme == "unix" {
+			// Remove the stale socket left after unclean shutdown.
+			if err := os.Remove(endp.Path); err != nil && !os.IsNotExist(err) {
+				return fmt.Errorf("%s: %v", modName, err)
+			}
+		}
+		l, err := net.Listen(endp.Network(), endp.Address())
+		if err != nil {
+			return fmt.Errorf("%s: %v", modName, err)
+		}
+		switch {
+		case endp.Scheme == "unix":
+			if err := os.Chmod(endp.Path, 0o660); err != nil {
+				l.Close()
+				return fmt.Errorf("%s: %v", modName, err)
+			}
+		case endp.IsTLS():
+			if tlsConfig == nil {
+				l.Close()
+				return fmt.Errorf("%s: can't bind on HTTPS endpoint without TLS configuration", modName)
+			}
+			l = tls.NewListener(l, tlsConfig)
+		default:
+			e.logger.Println("plain-text TCP endpoint is used, API tokens can be intercepted:", endp)
+		}
+
+		e.listenersWg.Add(1)
+		go func() {
+			e.logger.Println("listening on", endp.String())
+			err := e.serv.Serve(l)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				e.logger.Error("serve failed", err, "endpoint", a)
+			}
+			e.listenersWg.Done()
+		}()
+	}
+	return nil
+}
+
+func (e *Endpoint) Name() string {
+	return modName
+}
+
+func (e *Endpoint) InstanceName() string {
+	return ""
+}
+
+func (e *Endpoint) Close() error {
+	if err := e.serv.Close(); err != nil {
+		return err
+	}
+	e.listenersWg.Wait()
+	return e.audit.Close()
+}
+
+func init() {
+	module.RegisterEndpoint(modName, New)
+}
@@ -0,0 +1,353 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package admin
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/emersion/go-imap"
+	imapbackend "github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-imap/backend/memory"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+type userDB struct {
+	lck   sync.Mutex
+	users map[string]string
+}
+
+func (db *userDB) AuthPlain(username, password string) error {
+	return module.ErrUnknownCredentials
+}
+
+func (db *userDB) ListUsers() ([]string, error) {
+	db.lck.Lock()
+	defer db.lck.Unlock()
+	var res []string
+	for u := range db.users {
+		res = append(res, u)
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (db *userDB) CreateUser(username, password string) error {
+	db.lck.Lock()
+	defer db.lck.Unlock()
+	if _, ok := db.users[username]; ok {
+		return fmt.Errorf("credentials for %s already exist", username)
+	}
+	db.users[username] = password
+	return nil
+}
+
+func (db *userDB) SetUserPassword(username, password string) error {
+	db.lck.Lock()
+	defer db.lck.Unlock()
+	if _, ok := db.users[username]; !ok {
+		return module.ErrUnknownCredentials
+	}
+	db.users[username] = password
+	return nil
+}
+
+func (db *userDB) DeleteUser(username string) error {
+	db.lck.Lock()
+	defer db.lck.Unlock()
+	if _, ok := db.users[username]; !ok {
+		return module.ErrUnknownCredentials
+	}
+	delete(db.users, username)
+	return nil
+}
+
+type memStorage struct {
+	be *memory.Backend
+}
+
+func (s memStorage) GetOrCreateIMAPAcct(username string) (imapbackend.User, error) {
+	return s.GetIMAPAcct(username)
+}
+
+func (s memStorage) GetIMAPAcct(username string) (imapbackend.User, error) {
+	return s.be.Login(nil, username, "password")
+}
+
+func (s memStorage) IMAPExtensions() []string {
+	return nil
+}
+
+func testEndpoint(t *testing.T) (*Endpoint, *userDB, string) {
+	roles := builtinRoles()
+	roles["aliases"] = []string{"tables:read", "tables:write"}
+
+	e := &Endpoint{
+		logger: testutils.Logger(t, modName),
+		tables: map[string]module.Table{
+			"aliases": &testutils.MutableTable{M: map[string]string{"postmaster": "admin@example.org"}},
+		},
+	}
+	for _, g := range []struct {
+		name, secret string
+		scopes       []string
+	}{
+		{"root", "root-secret", []string{"admin"}},
+		{"ro", "ro-secret", []string{"read-only"}},
+		{"aliases", "aliases-secret", []string{"aliases"}},
+	} {
+		hash, err := parseTokenHash(HashToken(g.secret))
+		if err != nil {
+			t.Fatal(err)
+		}
+		scopes, err := resolveScopes(roles, g.scopes)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e.tokens = append(e.tokens, token{name: g.name, hash: hash, scopes: scopes})
+	}
+	e.unixScopes, _ = resolveScopes(roles, []string{"admin"})
+
+	auditPath := filepath.Join(t.TempDir(), "audit.log")
+	var err error
+	e.audit, err = openAuditLog(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { e.audit.Close() })
+
+	db := &userDB{users: map[string]string{}}
+	e.userDB = db
+	e.setupServer()
+	return e, db, auditPath
+}
+
+func TestResolveScopes(t *testing.T) {
+	roles := builtinRoles()
+	scopes, err := resolveScopes(roles, []string{"read-only", "users:write"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !scopes["queue:read"] || !scopes["users:write"] || scopes["queue:write"] {
+		t.Errorf("wrong scopes: %v", scopes)
+	}
+	if _, err := resolveScopes(roles, []string{"superuser"}); err == nil {
+		t.Error("unknown role accepted")
+	}
+	if _, err := parseTokenHash("deadbeef"); err == nil {
+		t.Error("hash without prefix accepted")
+	}
+}
+
+func TestTokenAuth(t *testing.T) {
+	e, db, auditPath := testEndpoint(t)
+	srv := httptest.NewServer(e.mux)
+	defer srv.Close()
+
+	for _, tc := range []struct {
+		token  string
+		method string
+		path   string
+		body   string
+		status int
+	}{
+		{"", "GET", "/v1/users", "", http.StatusUnauthorized},
+		{"wrong", "GET", "/v1/users", "", http.StatusUnauthorized},
+		{"ro-secret", "GET", "/v1/users", "", http.StatusOK},
+		{"ro-secret", "POST", "/v1/users", `{"username":"foo","password":"bar"}`, http.StatusForbidden},
+		{"root-secret", "POST", "/v1/users", `{"username":"foo","password":"bar"}`, http.StatusCreated},
+		{"root-secret", "POST", "/v1/users", `{"username":"foo","password":"bar"}`, http.StatusInternalServerError},
+		{"root-secret", "POST", "/v1/users", `{"username":"foo"}`, http.StatusBadRequest},
+		{"root-secret", "DELETE", "/v1/users/nobody", "", http.StatusNotFound},
+		{"aliases-secret", "GET", "/v1/users", "", http.StatusForbidden},
+		{"aliases-secret", "GET", "/v1/tables/aliases/keys/postmaster", "", http.StatusOK},
+		{"aliases-secret", "GET", "/v1/tables/aliases/keys/nobody", "", http.StatusNotFound},
+		{"aliases-secret", "GET", "/v1/tables/nonexistent/keys", "", http.StatusNotFound},
+		{"root-secret", "GET", "/v1/queue", "", http.StatusNotImplemented},
+		{"", "GET", "/v1/openapi.json", "", http.StatusOK},
+	} {
+		req, err := http.NewRequest(tc.method, srv.URL+tc.path, strings.NewReader(tc.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if tc.token != "" {
+			req.Header.Set("Authorization", "Bearer "+tc.token)
+		}
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		resp.Body.Close()
+		if resp.StatusCode != tc.status {
+			t.Errorf("%s %s (token %q): expected %d, got %d", tc.method, tc.path, tc.token, tc.status, resp.StatusCode)
+		}
+	}
+
+	if db.users["foo"] != "bar" {
+		t.Error("user was not created")
+	}
+
+	audit, err := os.ReadFile(auditPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lines := strings.Split(strings.TrimSpace(string(audit)), "\n")
+	if len(lines) != 13 {
+		t.Fatalf("expected 13 audit log entries, got %d", len(lines))
+	}
+	var entry auditEntry
+	if err := json.Unmarshal([]byte(lines[4]), &entry); err != nil {
+		t.Fatal(err)
+	}
+	if entry.Principal != "root" || entry.Method != "token" || entry.Request != "POST /v1/users" ||
+		entry.Scope != "users:write" || entry.Status != http.StatusCreated {
+		t.Errorf("wrong audit entry: %+v", entry)
+	}
+}
+
+func TestClient_Unix(t *testing.T) {
+	e, db, _ := testEndpoint(t)
+
+	sockPath := filepath.Join(t.TempDir(), "admin.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go e.serv.Serve(l)
+	defer e.serv.Close()
+
+	c := NewClient("unix://"+sockPath, "")
+	users := c.UserDB()
+	if err := users.CreateUser("foo@example.org", "1234"); err != nil {
+		t.Fatal(err)
+	}
+	if err := users.SetUserPassword("foo@example.org", "5678"); err != nil {
+		t.Fatal(err)
+	}
+	list, err := users.ListUsers()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(list) != 1 || list[0] != "foo@example.org" || db.users["foo@example.org"] != "5678" {
+		t.Fatalf("wrong users: %v %v", list, db.users)
+	}
+	var apiErr *APIError
+	if err := users.DeleteUser("bar@example.org"); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotFound {
+		t.Fatalf("expected 404 API error, got %v", err)
+	}
+	if err := users.DeleteUser("foo@example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	if err := c.do(http.MethodPut, "/v1/tables/aliases/keys/abuse", TableValue{Value: "admin@example.org"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	var keys []string
+	if err := c.do(http.MethodGet, "/v1/tables/aliases/keys", nil, &keys); err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(keys, ",") != "abuse,postmaster" {
+		t.Fatalf("wrong keys: %v", keys)
+	}
+
+	// Storage is not configured.
+	if _, err := c.Storage().ListIMAPAccts(); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotImplemented {
+		t.Fatalf("expected 501 API error, got %v", err)
+	}
+
+	// Unix socket access can be disabled.
+	e.unixScopes = nil
+	if _, err := users.ListUsers(); !errors.As(err, &apiErr) || apiErr.Status != http.StatusUnauthorized {
+		t.Fatalf("expected 401 API error, got %v", err)
+	}
+}
+
+func TestClient_Mailbox(t *testing.T) {
+	e, _, _ := testEndpoint(t)
+	e.storage = memStorage{memory.New()}
+
+	sockPath := filepath.Join(t.TempDir(), "admin.sock")
+	l, err := net.Listen("unix", sockPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	go e.serv.Serve(l)
+	defer e.serv.Close()
+
+	u, err := NewClient(sockPath, "").Storage().GetIMAPAcct("username")
+	if err != nil {
+		t.Fatal(err)
+	}
+	_, mbox, err := u.GetMailbox("INBOX", false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	list := func(items []imap.FetchItem) ([]*imap.Message, error) {
+		ch := make(chan *imap.Message, 10)
+		seq, _ := imap.ParseSeqSet("1:*")
+		err := mbox.ListMessages(true, seq, items, ch)
+		var msgs []*imap.Message
+		for msg := range ch {
+			msgs = append(msgs, msg)
+		}
+		return msgs, err
+	}
+
+	seq, _ := imap.ParseSeqSet("6")
+	if err := mbox.UpdateMessagesFlags(true, seq, imap.AddFlags, true, []string{imap.FlaggedFlag}); err != nil {
+		t.Fatal(err)
+	}
+	msgs, err := list([]imap.FetchItem{imap.FetchUid, imap.FetchFlags})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(msgs) != 1 || msgs[0].Uid != 6 || msgs[0].SeqNum != 1 ||
+		strings.Join(msgs[0].Flags, " ") != `\Seen \Flagged` {
+		t.Fatalf("wrong messages: %+v", msgs)
+	}
+
+	if _, err := list([]imap.FetchItem{imap.FetchEnvelope}); !errors.Is(err, ErrNotSupported) {
+		t.Fatalf("expected ErrNotSupported, got %v", err)
+	}
+
+	// The in-memory backend does not support removal.
+	var apiErr *APIError
+	if err := mbox.(interface {
+		DelMessages(uid bool, seqset *imap.SeqSet) error
+	}).DelMessages(true, seq); !errors.As(err, &apiErr) || apiErr.Status != http.StatusNotImplemented {
+		t.Fatalf("expected 501 API error, got %v", err)
+	}
+
+	_, mbox, _ = u.GetMailbox("Missing", true, nil)
+	if _, err := list(nil); !errors.As(err, &apiErr) {
+		t.Fatalf("expected API error for missing mailbox, got %v", err)
+	}
+}
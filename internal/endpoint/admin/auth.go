@@ -0,0 +1,257 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package admin
+
+import (
+	"context"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+	"time"
+)
+
+// Scopes are named as RESOURCE:ACCESS.
+var allScopes = []string{
+	"users:read", "users:write",
+	"accounts:read", "accounts:write",
+	"mailboxes:read", "mailboxes:write",
+	"messages:read", "messages:write",
+	"quotas:read", "quotas:write",
+	"queue:read", "queue:write",
+	"tables:read", "tables:write",
+}
+
+// builtinRoles are available without explicit role definitions.
+func builtinRoles() map[string][]string {
+	var readOnly []string
+	for _, s := range allScopes {
+		if strings.HasSuffix(s, ":read") {
+			readOnly = append(readOnly, s)
+		}
+	}
+	return map[string][]string{
+		"admin":     allScopes,
+		"read-only": readOnly,
+	}
+}
+
+func isScope(s string) bool {
+	for _, scope := range allScopes {
+		if scope == s {
+			return true
+		}
+	}
+	return false
+}
+
+// resolveScopes expands role names into scopes.
+func resolveScopes(roles map[string][]string, names []string) (map[string]bool, error) {
+	res := make(map[string]bool)
+	for _, name := range names {
+		if isScope(name) {
+			res[name] = true
+			continue
+		}
+		scopes, ok := roles[name]
+		if !ok {
+			return nil, fmt.Errorf("unknown scope or role: %s", name)
+		}
+		for _, s := range scopes {
+			res[s] = true
+		}
+	}
+	return res, nil
+}
+
+// principal is the authenticated API client.
+type principal struct {
+	// Name is the token name, client certificate subject or "unix".
+	Name   string
+	Method string
+	Scopes map[string]bool
+}
+
+type token struct {
+	name   string
+	hash   []byte
+	scopes map[string]bool
+}
+
+// HashToken returns the value to use in the token directive for the secret.
+func HashToken(secret string) string {
+	sum := sha256.Sum256([]byte(secret))
+	return "sha256:" + hex.EncodeToString(sum[:])
+}
+
+func parseTokenHash(s string) ([]byte, error) {
+	hexHash, ok := strings.CutPrefix(s, "sha256:")
+	if !ok {
+		return nil, fmt.Errorf("token hash should be in sha256:HEX format")
+	}
+	hash, err := hex.DecodeString(hexHash)
+	if err != nil || len(hash) != sha256.Size {
+		return nil, fmt.Errorf("malformed token hash")
+	}
+	return hash, nil
+}
+
+type connKind int
+
+const (
+	connTCP connKind = iota
+	connUnix
+)
+
+type connKindKey struct{}
+
+// authenticate determines the principal for the request. nil is returned if
+// the request does not carry valid credentials.
+func (e *Endpoint) authenticate(r *http.Request) *principal {
+	if auth := r.Header.Get("Authorization"); auth != "" {
+		secret, ok := strings.CutPrefix(auth, "Bearer ")
+		if !ok {
+			return nil
+		}
+		sum := sha256.Sum256([]byte(strings.TrimSpace(secret)))
+		for _, t := range e.tokens {
+			if subtle.ConstantTimeCompare(sum[:], t.hash) == 1 {
+				return &principal{Name: t.name, Method: "token", Scopes: t.scopes}
+			}
+		}
+		return nil
+	}
+
+	if r.TLS != nil && len(r.TLS.VerifiedChains) != 0 {
+		cn := r.TLS.VerifiedChains[0][0].Subject.CommonName
+		if scopes, ok := e.certs[cn]; ok {
+			return &principal{Name: cn, Method: "mtls", Scopes: scopes}
+		}
+		return nil
+	}
+
+	if kind, _ := r.Context().Value(connKindKey{}).(connKind); kind == connUnix && e.unixScopes != nil {
+		return &principal{Name: "unix", Method: "unix", Scopes: e.unixScopes}
+	}
+
+	return nil
+}
+
+type principalKey struct{}
+
+func getPrincipal(ctx context.Context) *principal {
+	p, _ := ctx.Value(principalKey{}).(*principal)
+	return p
+}
+
+// statusWriter records the response status for the audit log.
+type statusWriter struct {
+	http.ResponseWriter
+	status int
+}
+
+func (w *statusWriter) WriteHeader(status int) {
+	w.status = status
+	w.ResponseWriter.WriteHeader(status)
+}
+
+// require wraps the handler checking that the client is authenticated and
+// has the scope. All requests are recorded in the audit log.
+func (e *Endpoint) require(scope string, h http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		sw := &statusWriter{ResponseWriter: w, status: http.StatusOK}
+		p := e.authenticate(r)
+		defer func() {
+			e.audit.record(r, p, scope, sw.status)
+		}()
+
+		if p == nil {
+			sw.Header().Set("WWW-Authenticate", `Bearer realm="mailchatd"`)
+			writeError(sw, http.StatusUnauthorized, "authentication required")
+			return
+		}
+		if !p.Scopes[scope] {
+			writeError(sw, http.StatusForbidden, "missing scope "+scope)
+			return
+		}
+
+		h(sw, r.WithContext(context.WithValue(r.Context(), principalKey{}, p)))
+	}
+}
+
+type auditEntry struct {
+	Time      time.Time `json:"time"`
+	Principal string    `json:"principal,omitempty"`
+	Method    string    `json:"auth_method,omitempty"`
+	Remote    string    `json:"remote,omitempty"`
+	Request   string    `json:"request"`
+	Scope     string    `json:"scope"`
+	Status    int       `json:"status"`
+}
+
+// auditLog writes JSON lines describing API requests.
+type auditLog struct {
+	lck sync.Mutex
+	f   *os.File
+}
+
+func openAuditLog(path string) (*auditLog, error) {
+	f, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_APPEND, 0o600)
+	if err != nil {
+		return nil, err
+	}
+	return &auditLog{f: f}, nil
+}
+
+func (a *auditLog) record(r *http.Request, p *principal, scope string, status int) {
+	if a == nil {
+		return
+	}
+	entry := auditEntry{
+		Time:    time.Now().UTC(),
+		Remote:  r.RemoteAddr,
+		Request: r.Method + " " + r.URL.RequestURI(),
+		Scope:   scope,
+		Status:  status,
+	}
+	if p != nil {
+		entry.Principal = p.Name
+		entry.Method = p.Method
+	}
+	blob, err := json.Marshal(entry)
+	if err != nil {
+		return
+	}
+
+	a.lck.Lock()
+	defer a.lck.Unlock()
+	_, _ = a.f.Write(append(blob, '\n'))
+}
+
+func (a *auditLog) Close() error {
+	if a == nil {
+		return nil
+	}
+	return a.f.Close()
+}
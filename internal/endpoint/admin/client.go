@@ -0,0 +1,388 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package admin
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapbackend "github.com/emersion/go-imap/backend"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target/queue"
+)
+
+// ErrNotSupported is returned by the Client adapters for operations that
+// are not available over the API.
+var ErrNotSupported = errors.New("admin: operation is not supported over the admin API")
+
+// APIError is the error reported by the API server.
+type APIError struct {
+	Status  int
+	Message string
+}
+
+func (err *APIError) Error() string {
+	return fmt.Sprintf("admin API: %s (HTTP %d)", err.Message, err.Status)
+}
+
+// Client is the admin API client.
+type Client struct {
+	base  string
+	token string
+	http  *http.Client
+}
+
+// NewClient creates the client for the API server at addr. addr is either
+// the path to the unix socket (optionally with the unix:// prefix) or the
+// http:// or https:// URL.
+func NewClient(addr, token string) *Client {
+	c := &Client{
+		base:  strings.TrimSuffix(addr, "/"),
+		token: token,
+		http:  &http.Client{Timeout: time.Minute},
+	}
+	if !strings.HasPrefix(addr, "http://") && !strings.HasPrefix(addr, "https://") {
+		path := strings.TrimPrefix(addr, "unix://")
+		c.base = "http://admin"
+		c.http.Transport = &http.Transport{
+			DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+				var d net.Dialer
+				return d.DialContext(ctx, "unix", path)
+			},
+		}
+	}
+	return c
+}
+
+func (c *Client) do(method, path string, in, out interface{}) error {
+	var body io.Reader
+	if in != nil {
+		blob, err := json.Marshal(in)
+		if err != nil {
+			return err
+		}
+		body = bytes.NewReader(blob)
+	}
+	req, err := http.NewRequest(method, c.base+path, body)
+	if err != nil {
+		return err
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+	if c.token != "" {
+		req.Header.Set("Authorization", "Bearer "+c.token)
+	}
+
+	resp, err := c.http.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode >= 300 {
+		var apiErr Error
+		if err := json.NewDecoder(resp.Body).Decode(&apiErr); err != nil || apiErr.Error == "" {
+			apiErr.Error = resp.Status
+		}
+		return &APIError{Status: resp.StatusCode, Message: apiErr.Error}
+	}
+	if out != nil {
+		return json.NewDecoder(resp.Body).Decode(out)
+	}
+	return nil
+}
+
+func pathEscape(parts ...string) string {
+	escaped := make([]string, len(parts))
+	for i, p := range parts {
+		escaped[i] = url.PathEscape(p)
+	}
+	return strings.Join(escaped, "/")
+}
+
+// UserDB returns the credentials store backed by the API.
+func (c *Client) UserDB() module.PlainUserDB {
+	return remoteUserDB{c}
+}
+
+// Storage returns the IMAP storage backed by the API. Only accounts,
+// mailboxes, quota management and basic message operations (list, flags
+// update and removal) are supported.
+func (c *Client) Storage() module.ManageableStorage {
+	return remoteStorage{c}
+}
+
+// Queue returns messages waiting in the delivery queue.
+func (c *Client) Queue() ([]queue.MessageInfo, error) {
+	var msgs []queue.MessageInfo
+	err := c.do(http.MethodGet, "/v1/queue", nil, &msgs)
+	return msgs, err
+}
+
+// RemoveQueued drops the message from the delivery queue.
+func (c *Client) RemoveQueued(id string) error {
+	return c.do(http.MethodDelete, "/v1/queue/"+pathEscape(id), nil, nil)
+}
+
+type remoteUserDB struct {
+	c *Client
+}
+
+func (db remoteUserDB) AuthPlain(username, password string) error {
+	return ErrNotSupported
+}
+
+func (db remoteUserDB) ListUsers() ([]string, error) {
+	var users []string
+	err := db.c.do(http.MethodGet, "/v1/users", nil, &users)
+	return users, err
+}
+
+func (db remoteUserDB) CreateUser(username, password string) error {
+	return db.c.do(http.MethodPost, "/v1/users", UserRequest{Username: username, Password: password}, nil)
+}
+
+func (db remoteUserDB) SetUserPassword(username, password string) error {
+	return db.c.do(http.MethodPut, "/v1/users/"+pathEscape(username, "password"), UserRequest{Password: password}, nil)
+}
+
+func (db remoteUserDB) DeleteUser(username string) error {
+	return db.c.do(http.MethodDelete, "/v1/users/"+pathEscape(username), nil, nil)
+}
+
+type remoteStorage struct {
+	c *Client
+}
+
+func (s remoteStorage) GetOrCreateIMAPAcct(username string) (imapbackend.User, error) {
+	return s.GetIMAPAcct(username)
+}
+
+func (s remoteStorage) GetIMAPAcct(username string) (imapbackend.User, error) {
+	return &remoteUser{c: s.c, username: username}, nil
+}
+
+func (s remoteStorage) IMAPExtensions() []string {
+	return nil
+}
+
+func (s remoteStorage) ListIMAPAccts() ([]string, error) {
+	var accts []string
+	err := s.c.do(http.MethodGet, "/v1/accounts", nil, &accts)
+	return accts, err
+}
+
+func (s remoteStorage) CreateIMAPAcct(username string) error {
+	return s.c.do(http.MethodPost, "/v1/accounts", AccountRequest{Username: username}, nil)
+}
+
+func (s remoteStorage) DeleteIMAPAcct(username string) error {
+	return s.c.do(http.MethodDelete, "/v1/accounts/"+pathEscape(username), nil, nil)
+}
+
+type remoteUser struct {
+	c        *Client
+	username string
+}
+
+func (u *remoteUser) path(parts ...string) string {
+	return "/v1/accounts/" + pathEscape(append([]string{u.username}, parts...)...)
+}
+
+func (u *remoteUser) Username() string {
+	return u.username
+}
+
+func (u *remoteUser) Status(mbox string, items []imap.StatusItem) (*imap.MailboxStatus, error) {
+	return nil, ErrNotSupported
+}
+
+func (u *remoteUser) SetSubscribed(mbox string, subscribed bool) error {
+	return ErrNotSupported
+}
+
+func (u *remoteUser) CreateMessage(mbox string, flags []string, date time.Time, body imap.Literal, selectedMailbox imapbackend.Mailbox) error {
+	return ErrNotSupported
+}
+
+func (u *remoteUser) ListMailboxes(subscribed bool) ([]imap.MailboxInfo, error) {
+	var mboxes []MailboxInfo
+	path := u.path("mailboxes")
+	if subscribed {
+		path += "?subscribed=true"
+	}
+	if err := u.c.do(http.MethodGet, path, nil, &mboxes); err != nil {
+		return nil, err
+	}
+	res := make([]imap.MailboxInfo, 0, len(mboxes))
+	for _, mbox := range mboxes {
+		res = append(res, imap.MailboxInfo{Name: mbox.Name, Attributes: mbox.Attributes})
+	}
+	return res, nil
+}
+
+// GetMailbox returns the mailbox backed by the messages API. The mailbox
+// existence is checked by the server on the first operation.
+func (u *remoteUser) GetMailbox(name string, readOnly bool, conn imapbackend.Conn) (*imap.MailboxStatus, imapbackend.Mailbox, error) {
+	status := imap.NewMailboxStatus(name, nil)
+	status.ReadOnly = readOnly
+	return status, &remoteMailbox{u: u, name: name}, nil
+}
+
+func (u *remoteUser) CreateMailbox(name string) error {
+	return u.c.do(http.MethodPost, u.path("mailboxes"), MailboxInfo{Name: name}, nil)
+}
+
+func (u *remoteUser) CreateMailboxSpecial(name, specialUseAttr string) error {
+	for specialUse, attr := range specialUseAttrs {
+		if attr == specialUseAttr {
+			return u.c.do(http.MethodPost, u.path("mailboxes"), MailboxInfo{Name: name, SpecialUse: specialUse}, nil)
+		}
+	}
+	return fmt.Errorf("admin: unknown special-use attribute: %s", specialUseAttr)
+}
+
+func (u *remoteUser) DeleteMailbox(name string) error {
+	return u.c.do(http.MethodDelete, u.path("mailboxes", name), nil, nil)
+}
+
+func (u *remoteUser) RenameMailbox(existingName, newName string) error {
+	return u.c.do(http.MethodPatch, u.path("mailboxes", existingName), MailboxInfo{Name: newName}, nil)
+}
+
+func (u *remoteUser) Logout() error {
+	return nil
+}
+
+// CreateMessageLimit returns the account APPENDLIMIT. nil is returned if
+// the limit is not set or cannot be retrieved.
+func (u *remoteUser) CreateMessageLimit() *uint32 {
+	var quota QuotaInfo
+	if err := u.c.do(http.MethodGet, u.path("quota"), nil, &quota); err != nil {
+		return nil
+	}
+	return quota.AppendLimit
+}
+
+func (u *remoteUser) SetMessageLimit(val *uint32) error {
+	return u.c.do(http.MethodPut, u.path("quota"), QuotaInfo{AppendLimit: val}, nil)
+}
+
+type remoteMailbox struct {
+	u    *remoteUser
+	name string
+}
+
+func (m *remoteMailbox) path(set string, uid bool) string {
+	query := url.Values{"set": {set}}
+	if uid {
+		query.Set("uid", "true")
+	}
+	return m.u.path("mailboxes", m.name, "messages") + "?" + query.Encode()
+}
+
+func (m *remoteMailbox) Name() string {
+	return m.name
+}
+
+func (m *remoteMailbox) Close() error {
+	return nil
+}
+
+func (m *remoteMailbox) Info() (*imap.MailboxInfo, error) {
+	return &imap.MailboxInfo{Name: m.name}, nil
+}
+
+func (m *remoteMailbox) Poll(expunge bool) error {
+	return nil
+}
+
+// ListMessages returns messages with the UID, flags, internal date and size.
+// Other items are not available over the API.
+func (m *remoteMailbox) ListMessages(uid bool, seqset *imap.SeqSet, items []imap.FetchItem, ch chan<- *imap.Message) error {
+	defer close(ch)
+
+	for _, item := range items {
+		switch item {
+		case imap.FetchUid, imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size:
+		default:
+			return ErrNotSupported
+		}
+	}
+
+	var msgs []MessageInfo
+	if err := m.u.c.do(http.MethodGet, m.path(seqset.String(), uid), nil, &msgs); err != nil {
+		return err
+	}
+	for _, info := range msgs {
+		msg := imap.NewMessage(info.SeqNum, items)
+		msg.Uid = info.UID
+		msg.Flags = info.Flags
+		msg.InternalDate = info.Date
+		msg.Size = info.Size
+		ch <- msg
+	}
+	return nil
+}
+
+func (m *remoteMailbox) SearchMessages(uid bool, criteria *imap.SearchCriteria) ([]uint32, error) {
+	return nil, ErrNotSupported
+}
+
+func (m *remoteMailbox) UpdateMessagesFlags(uid bool, seqset *imap.SeqSet, operation imap.FlagsOp, silent bool, flags []string) error {
+	var op string
+	switch operation {
+	case imap.AddFlags:
+		op = "add"
+	case imap.RemoveFlags:
+		op = "remove"
+	case imap.SetFlags:
+		op = "set"
+	default:
+		return fmt.Errorf("admin: unknown flags operation: %v", operation)
+	}
+	return m.u.c.do(http.MethodPost, m.u.path("mailboxes", m.name, "messages", "flags"),
+		FlagsRequest{Set: seqset.String(), UID: uid, Op: op, Flags: flags}, nil)
+}
+
+// DelMessages removes the messages from the mailbox.
+func (m *remoteMailbox) DelMessages(uid bool, seqset *imap.SeqSet) error {
+	return m.u.c.do(http.MethodDelete, m.path(seqset.String(), uid), nil, nil)
+}
+
+func (m *remoteMailbox) CopyMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	return ErrNotSupported
+}
+
+func (m *remoteMailbox) Expunge() error {
+	return ErrNotSupported
+}
+
+func (m *remoteMailbox) Idle(done <-chan struct{}) {}
@@ -0,0 +1,764 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package admin
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"net/http"
+	"sort"
+	"strconv"
+	"time"
+
+	"github.com/emersion/go-imap"
+	imapbackend "github.com/emersion/go-imap/backend"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target/queue"
+)
+
+// maxRequestBody limits JSON request bodies. Messages uploaded using
+// POST .../messages are limited by maxMessageSize.
+const (
+	maxRequestBody = 1 << 20
+	maxMessageSize = 64 << 20
+)
+
+// AppendLimitUser is implemented by storage accounts that support per-user
+// APPENDLIMIT values.
+type AppendLimitUser interface {
+	imapbackend.AppendLimitUser
+	SetMessageLimit(val *uint32) error
+}
+
+// SpecialUseUser is implemented by storage accounts that support
+// SPECIAL-USE mailbox attributes.
+type SpecialUseUser interface {
+	CreateMailboxSpecial(name, specialUseAttr string) error
+}
+
+type messageDeleter interface {
+	DelMessages(uid bool, seqset *imap.SeqSet) error
+}
+
+type (
+	UserRequest struct {
+		Username string `json:"username"`
+		Password string `json:"password"`
+	}
+	AccountRequest struct {
+		Username string `json:"username"`
+	}
+	QuotaInfo struct {
+		// AppendLimit is the maximum message size for IMAP APPEND, nil
+		// means no limit.
+		AppendLimit *uint32 `json:"append_limit"`
+	}
+	MailboxInfo struct {
+		Name       string   `json:"name"`
+		Attributes []string `json:"attributes,omitempty"`
+		// SpecialUse is only used when creating the mailbox.
+		SpecialUse string `json:"special_use,omitempty"`
+	}
+	MessageInfo struct {
+		SeqNum uint32    `json:"seq"`
+		UID    uint32    `json:"uid"`
+		Flags  []string  `json:"flags"`
+		Date   time.Time `json:"date"`
+		Size   uint32    `json:"size"`
+	}
+	FlagsRequest struct {
+		Set string `json:"set"`
+		UID bool   `json:"uid"`
+		// Op is one of add, remove or set.
+		Op    string   `json:"op"`
+		Flags []string `json:"flags"`
+	}
+	TableValue struct {
+		Value string `json:"value"`
+	}
+	Error struct {
+		Error string `json:"error"`
+	}
+)
+
+func (e *Endpoint) registerRoutes() {
+	e.mux.HandleFunc("GET /v1/openapi.json", serveSpec)
+
+	e.mux.HandleFunc("GET /v1/users", e.require("users:read", e.listUsers))
+	e.mux.HandleFunc("POST /v1/users", e.require("users:write", e.createUser))
+	e.mux.HandleFunc("PUT /v1/users/{username}/password", e.require("users:write", e.setPassword))
+	e.mux.HandleFunc("DELETE /v1/users/{username}", e.require("users:write", e.deleteUser))
+
+	e.mux.HandleFunc("GET /v1/accounts", e.require("accounts:read", e.listAccounts))
+	e.mux.HandleFunc("POST /v1/accounts", e.require("accounts:write", e.createAccount))
+	e.mux.HandleFunc("DELETE /v1/accounts/{username}", e.require("accounts:write", e.deleteAccount))
+
+	e.mux.HandleFunc("GET /v1/accounts/{username}/quota", e.require("quotas:read", e.getQuota))
+	e.mux.HandleFunc("PUT /v1/accounts/{username}/quota", e.require("quotas:write", e.setQuota))
+
+	e.mux.HandleFunc("GET /v1/accounts/{username}/mailboxes", e.require("mailboxes:read", e.listMailboxes))
+	e.mux.HandleFunc("POST /v1/accounts/{username}/mailboxes", e.require("mailboxes:write", e.createMailbox))
+	e.mux.HandleFunc("PATCH /v1/accounts/{username}/mailboxes/{mailbox}", e.require("mailboxes:write", e.renameMailbox))
+	e.mux.HandleFunc("DELETE /v1/accounts/{username}/mailboxes/{mailbox}", e.require("mailboxes:write", e.deleteMailbox))
+
+	e.mux.HandleFunc("GET /v1/accounts/{username}/mailboxes/{mailbox}/messages", e.require("messages:read", e.listMessages))
+	e.mux.HandleFunc("POST /v1/accounts/{username}/mailboxes/{mailbox}/messages", e.require("messages:write", e.addMessage))
+	e.mux.HandleFunc("POST /v1/accounts/{username}/mailboxes/{mailbox}/messages/flags", e.require("messages:write", e.updateFlags))
+	e.mux.HandleFunc("DELETE /v1/accounts/{username}/mailboxes/{mailbox}/messages", e.require("messages:write", e.deleteMessages))
+
+	e.mux.HandleFunc("GET /v1/queue", e.require("queue:read", e.listQueue))
+	e.mux.HandleFunc("DELETE /v1/queue/{id}", e.require("queue:write", e.removeQueued))
+
+	e.mux.HandleFunc("GET /v1/tables", e.require("tables:read", e.listTables))
+	e.mux.HandleFunc("GET /v1/tables/{table}/keys", e.require("tables:read", e.listKeys))
+	e.mux.HandleFunc("GET /v1/tables/{table}/keys/{key}", e.require("tables:read", e.lookupKey))
+	e.mux.HandleFunc("PUT /v1/tables/{table}/keys/{key}", e.require("tables:write", e.setKey))
+	e.mux.HandleFunc("DELETE /v1/tables/{table}/keys/{key}", e.require("tables:write", e.removeKey))
+}
+
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+func writeError(w http.ResponseWriter, status int, msg string) {
+	writeJSON(w, status, Error{Error: msg})
+}
+
+// writeBackendError reports the error returned by the storage or
+// credentials module.
+func writeBackendError(w http.ResponseWriter, err error) {
+	switch {
+	case errors.Is(err, imapbackend.ErrNoSuchMailbox),
+		errors.Is(err, module.ErrUnknownCredentials),
+		errors.Is(err, queue.ErrNoSuchMessage):
+		writeError(w, http.StatusNotFound, err.Error())
+	case errors.Is(err, imapbackend.ErrMailboxAlreadyExists):
+		writeError(w, http.StatusConflict, err.Error())
+	default:
+		writeError(w, http.StatusInternalServerError, err.Error())
+	}
+}
+
+func readJSON(w http.ResponseWriter, r *http.Request, v interface{}) bool {
+	if err := json.NewDecoder(io.LimitReader(r.Body, maxRequestBody)).Decode(v); err != nil {
+		writeError(w, http.StatusBadRequest, "malformed request: "+err.Error())
+		return false
+	}
+	return true
+}
+
+func notConfigured(w http.ResponseWriter, what string) {
+	writeError(w, http.StatusNotImplemented, what+" is not configured for the admin endpoint")
+}
+
+func (e *Endpoint) listUsers(w http.ResponseWriter, r *http.Request) {
+	if e.userDB == nil {
+		notConfigured(w, "credentials")
+		return
+	}
+	users, err := e.userDB.ListUsers()
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if users == nil {
+		users = []string{}
+	}
+	writeJSON(w, http.StatusOK, users)
+}
+
+func (e *Endpoint) createUser(w http.ResponseWriter, r *http.Request) {
+	if e.userDB == nil {
+		notConfigured(w, "credentials")
+		return
+	}
+	var req UserRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Username == "" || req.Password == "" {
+		writeError(w, http.StatusBadRequest, "username and password are required")
+		return
+	}
+	if err := e.userDB.CreateUser(req.Username, req.Password); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (e *Endpoint) setPassword(w http.ResponseWriter, r *http.Request) {
+	if e.userDB == nil {
+		notConfigured(w, "credentials")
+		return
+	}
+	var req UserRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Password == "" {
+		writeError(w, http.StatusBadRequest, "password is required")
+		return
+	}
+	if err := e.userDB.SetUserPassword(r.PathValue("username"), req.Password); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) deleteUser(w http.ResponseWriter, r *http.Request) {
+	if e.userDB == nil {
+		notConfigured(w, "credentials")
+		return
+	}
+	if err := e.userDB.DeleteUser(r.PathValue("username")); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) manageableStorage(w http.ResponseWriter) module.ManageableStorage {
+	if e.storage == nil {
+		notConfigured(w, "storage")
+		return nil
+	}
+	mbe, ok := e.storage.(module.ManageableStorage)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "storage backend does not support accounts management")
+		return nil
+	}
+	return mbe
+}
+
+func (e *Endpoint) listAccounts(w http.ResponseWriter, r *http.Request) {
+	mbe := e.manageableStorage(w)
+	if mbe == nil {
+		return
+	}
+	accts, err := mbe.ListIMAPAccts()
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if accts == nil {
+		accts = []string{}
+	}
+	writeJSON(w, http.StatusOK, accts)
+}
+
+func (e *Endpoint) createAccount(w http.ResponseWriter, r *http.Request) {
+	mbe := e.manageableStorage(w)
+	if mbe == nil {
+		return
+	}
+	var req AccountRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Username == "" {
+		writeError(w, http.StatusBadRequest, "username is required")
+		return
+	}
+	if err := mbe.CreateIMAPAcct(req.Username); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (e *Endpoint) deleteAccount(w http.ResponseWriter, r *http.Request) {
+	mbe := e.manageableStorage(w)
+	if mbe == nil {
+		return
+	}
+	if err := mbe.DeleteIMAPAcct(r.PathValue("username")); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// account returns the storage account from the request path.
+func (e *Endpoint) account(w http.ResponseWriter, r *http.Request) imapbackend.User {
+	if e.storage == nil {
+		notConfigured(w, "storage")
+		return nil
+	}
+	u, err := e.storage.GetIMAPAcct(r.PathValue("username"))
+	if err != nil {
+		writeBackendError(w, err)
+		return nil
+	}
+	return u
+}
+
+func (e *Endpoint) getQuota(w http.ResponseWriter, r *http.Request) {
+	u := e.account(w, r)
+	if u == nil {
+		return
+	}
+	defer u.Logout()
+	al, ok := u.(AppendLimitUser)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "storage backend does not support per-user append limit")
+		return
+	}
+	writeJSON(w, http.StatusOK, QuotaInfo{AppendLimit: al.CreateMessageLimit()})
+}
+
+func (e *Endpoint) setQuota(w http.ResponseWriter, r *http.Request) {
+	u := e.account(w, r)
+	if u == nil {
+		return
+	}
+	defer u.Logout()
+	al, ok := u.(AppendLimitUser)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "storage backend does not support per-user append limit")
+		return
+	}
+	var req QuotaInfo
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := al.SetMessageLimit(req.AppendLimit); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) listMailboxes(w http.ResponseWriter, r *http.Request) {
+	u := e.account(w, r)
+	if u == nil {
+		return
+	}
+	defer u.Logout()
+
+	subscribed, _ := strconv.ParseBool(r.URL.Query().Get("subscribed"))
+	mboxes, err := u.ListMailboxes(subscribed)
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	res := make([]MailboxInfo, 0, len(mboxes))
+	for _, mbox := range mboxes {
+		res = append(res, MailboxInfo{Name: mbox.Name, Attributes: mbox.Attributes})
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+var specialUseAttrs = map[string]string{
+	"archive": imap.ArchiveAttr,
+	"drafts":  imap.DraftsAttr,
+	"junk":    imap.JunkAttr,
+	"sent":    imap.SentAttr,
+	"trash":   imap.TrashAttr,
+}
+
+func (e *Endpoint) createMailbox(w http.ResponseWriter, r *http.Request) {
+	u := e.account(w, r)
+	if u == nil {
+		return
+	}
+	defer u.Logout()
+
+	var req MailboxInfo
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+
+	var err error
+	if req.SpecialUse != "" {
+		attr, ok := specialUseAttrs[req.SpecialUse]
+		if !ok {
+			writeError(w, http.StatusBadRequest, "unknown special-use attribute: "+req.SpecialUse)
+			return
+		}
+		suu, ok := u.(SpecialUseUser)
+		if !ok {
+			writeError(w, http.StatusNotImplemented, "storage backend does not support SPECIAL-USE IMAP extension")
+			return
+		}
+		err = suu.CreateMailboxSpecial(req.Name, attr)
+	} else {
+		err = u.CreateMailbox(req.Name)
+	}
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusCreated)
+}
+
+func (e *Endpoint) renameMailbox(w http.ResponseWriter, r *http.Request) {
+	u := e.account(w, r)
+	if u == nil {
+		return
+	}
+	defer u.Logout()
+
+	var req MailboxInfo
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if req.Name == "" {
+		writeError(w, http.StatusBadRequest, "name is required")
+		return
+	}
+	if err := u.RenameMailbox(r.PathValue("mailbox"), req.Name); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) deleteMailbox(w http.ResponseWriter, r *http.Request) {
+	u := e.account(w, r)
+	if u == nil {
+		return
+	}
+	defer u.Logout()
+
+	if err := u.DeleteMailbox(r.PathValue("mailbox")); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+// mailbox opens the mailbox from the request path. Returned function should
+// be called to release it.
+func (e *Endpoint) mailbox(w http.ResponseWriter, r *http.Request, readOnly bool) (imapbackend.Mailbox, func()) {
+	u := e.account(w, r)
+	if u == nil {
+		return nil, nil
+	}
+	_, mbox, err := u.GetMailbox(r.PathValue("mailbox"), readOnly, nil)
+	if err != nil {
+		u.Logout()
+		writeBackendError(w, err)
+		return nil, nil
+	}
+	return mbox, func() {
+		mbox.Close()
+		u.Logout()
+	}
+}
+
+func parseSet(w http.ResponseWriter, set string) *imap.SeqSet {
+	if set == "" {
+		set = "1:*"
+	}
+	seq, err := imap.ParseSeqSet(set)
+	if err != nil {
+		writeError(w, http.StatusBadRequest, "malformed set: "+err.Error())
+		return nil
+	}
+	return seq
+}
+
+func (e *Endpoint) listMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	seq := parseSet(w, query.Get("set"))
+	if seq == nil {
+		return
+	}
+	useUID, _ := strconv.ParseBool(query.Get("uid"))
+
+	mbox, release := e.mailbox(w, r, true)
+	if mbox == nil {
+		return
+	}
+	defer release()
+
+	items := []imap.FetchItem{imap.FetchUid, imap.FetchFlags, imap.FetchInternalDate, imap.FetchRFC822Size}
+	ch := make(chan *imap.Message, 16)
+	done := make(chan error, 1)
+	go func() {
+		done <- mbox.ListMessages(useUID, seq, items, ch)
+	}()
+
+	res := []MessageInfo{}
+	for msg := range ch {
+		flags := msg.Flags
+		if flags == nil {
+			flags = []string{}
+		}
+		res = append(res, MessageInfo{
+			SeqNum: msg.SeqNum,
+			UID:    msg.Uid,
+			Flags:  flags,
+			Date:   msg.InternalDate,
+			Size:   msg.Size,
+		})
+	}
+	if err := <-done; err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusOK, res)
+}
+
+// addMessage appends the message from the request body (message/rfc822).
+// Flags and internal date can be set using flag and date query parameters.
+func (e *Endpoint) addMessage(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	flags := query["flag"]
+	if flags == nil {
+		flags = []string{}
+	}
+	date := time.Now()
+	if s := query.Get("date"); s != "" {
+		var err error
+		date, err = time.Parse(time.RFC3339, s)
+		if err != nil {
+			writeError(w, http.StatusBadRequest, "invalid date format: "+err.Error())
+			return
+		}
+	}
+
+	body, err := io.ReadAll(io.LimitReader(r.Body, maxMessageSize+1))
+	if err != nil {
+		writeError(w, http.StatusBadRequest, err.Error())
+		return
+	}
+	if len(body) == 0 {
+		writeError(w, http.StatusBadRequest, "empty message")
+		return
+	}
+	if len(body) > maxMessageSize {
+		writeError(w, http.StatusRequestEntityTooLarge, "message is too big")
+		return
+	}
+
+	u := e.account(w, r)
+	if u == nil {
+		return
+	}
+	defer u.Logout()
+
+	name := r.PathValue("mailbox")
+	status, err := u.Status(name, []imap.StatusItem{imap.StatusUidNext})
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if err := u.CreateMessage(name, flags, date, bytes.NewReader(body), nil); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	writeJSON(w, http.StatusCreated, MessageInfo{UID: status.UidNext, Flags: flags, Date: date, Size: uint32(len(body))})
+}
+
+func (e *Endpoint) updateFlags(w http.ResponseWriter, r *http.Request) {
+	var req FlagsRequest
+	if !readJSON(w, r, &req) {
+		return
+	}
+	var op imap.FlagsOp
+	switch req.Op {
+	case "add":
+		op = imap.AddFlags
+	case "remove":
+		op = imap.RemoveFlags
+	case "set":
+		op = imap.SetFlags
+	default:
+		writeError(w, http.StatusBadRequest, "op should be add, remove or set")
+		return
+	}
+	seq := parseSet(w, req.Set)
+	if seq == nil {
+		return
+	}
+
+	mbox, release := e.mailbox(w, r, false)
+	if mbox == nil {
+		return
+	}
+	defer release()
+
+	if err := mbox.UpdateMessagesFlags(req.UID, seq, op, true, req.Flags); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) deleteMessages(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	if query.Get("set") == "" {
+		writeError(w, http.StatusBadRequest, "set is required")
+		return
+	}
+	seq := parseSet(w, query.Get("set"))
+	if seq == nil {
+		return
+	}
+	useUID, _ := strconv.ParseBool(query.Get("uid"))
+
+	mbox, release := e.mailbox(w, r, false)
+	if mbox == nil {
+		return
+	}
+	defer release()
+
+	del, ok := mbox.(messageDeleter)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "storage backend does not support messages removal")
+		return
+	}
+	if err := del.DelMessages(useUID, seq); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) listQueue(w http.ResponseWriter, r *http.Request) {
+	if e.queue == nil {
+		notConfigured(w, "queue")
+		return
+	}
+	msgs, err := e.queue.List()
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if msgs == nil {
+		msgs = []queue.MessageInfo{}
+	}
+	writeJSON(w, http.StatusOK, msgs)
+}
+
+func (e *Endpoint) removeQueued(w http.ResponseWriter, r *http.Request) {
+	if e.queue == nil {
+		notConfigured(w, "queue")
+		return
+	}
+	if err := e.queue.Remove(r.PathValue("id")); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) listTables(w http.ResponseWriter, r *http.Request) {
+	names := make([]string, 0, len(e.tables))
+	for name := range e.tables {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	writeJSON(w, http.StatusOK, names)
+}
+
+func (e *Endpoint) table(w http.ResponseWriter, r *http.Request) module.Table {
+	tbl, ok := e.tables[r.PathValue("table")]
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such table")
+		return nil
+	}
+	return tbl
+}
+
+func (e *Endpoint) mutableTable(w http.ResponseWriter, r *http.Request) module.MutableTable {
+	tbl := e.table(w, r)
+	if tbl == nil {
+		return nil
+	}
+	mtbl, ok := tbl.(module.MutableTable)
+	if !ok {
+		writeError(w, http.StatusNotImplemented, "table is not mutable")
+		return nil
+	}
+	return mtbl
+}
+
+func (e *Endpoint) listKeys(w http.ResponseWriter, r *http.Request) {
+	tbl := e.mutableTable(w, r)
+	if tbl == nil {
+		return
+	}
+	keys, err := tbl.Keys()
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if keys == nil {
+		keys = []string{}
+	}
+	sort.Strings(keys)
+	writeJSON(w, http.StatusOK, keys)
+}
+
+func (e *Endpoint) lookupKey(w http.ResponseWriter, r *http.Request) {
+	tbl := e.table(w, r)
+	if tbl == nil {
+		return
+	}
+	val, ok, err := tbl.Lookup(r.Context(), r.PathValue("key"))
+	if err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	if !ok {
+		writeError(w, http.StatusNotFound, "no such key")
+		return
+	}
+	writeJSON(w, http.StatusOK, TableValue{Value: val})
+}
+
+func (e *Endpoint) setKey(w http.ResponseWriter, r *http.Request) {
+	tbl := e.mutableTable(w, r)
+	if tbl == nil {
+		return
+	}
+	var req TableValue
+	if !readJSON(w, r, &req) {
+		return
+	}
+	if err := tbl.SetKey(r.PathValue("key"), req.Value); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
+
+func (e *Endpoint) removeKey(w http.ResponseWriter, r *http.Request) {
+	tbl := e.mutableTable(w, r)
+	if tbl == nil {
+		return
+	}
+	if err := tbl.RemoveKey(r.PathValue("key")); err != nil {
+		writeBackendError(w, err)
+		return
+	}
+	w.WriteHeader(http.StatusNoContent)
+}
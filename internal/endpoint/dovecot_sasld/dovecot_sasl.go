@@ -32,6 +32,7 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
 	"github.com/mail-chat-chain/mailchatd/internal/auth"
+	"github.com/mail-chat-chain/mailchatd/internal/authguard"
 	"github.com/mail-chat-chain/mailchatd/internal/authz"
 )
 
@@ -70,6 +71,7 @@ func (endp *Endpoint) Init(cfg *config.Map) error {
 		return endp.saslAuth.AddProvider(m, node)
 	})
 	cfg.Bool("sasl_login", false, false, &endp.saslAuth.EnableLogin)
+	cfg.Custom("auth_guard", false, false, nil, authguard.Directive, &endp.saslAuth.Guard)
 	config.EnumMapped(cfg, "auth_map_normalize", true, false, authz.NormalizeFuncs, authz.NormalizeAuto,
 		&endp.saslAuth.AuthNormalize)
 	modconfig.Table(cfg, "auth_map", true, false, nil, &endp.saslAuth.AuthMap)
@@ -88,7 +90,10 @@ func (endp *Endpoint) Init(cfg *config.Map) error {
 				remoteAddr = &net.TCPAddr{IP: req.RemoteIP, Port: int(req.RemotePort)}
 			}
 
-			return endp.saslAuth.CreateSASL(mech, remoteAddr, func(_ string, _ auth.ContextData) error { return nil })
+			// SASLAuth is copied to pass the protocol of this request.
+			saslAuth := endp.saslAuth
+			saslAuth.Protocol = serviceProtocol(req.Service)
+			return saslAuth.CreateSASL(mech, remoteAddr, func(_ string, _ auth.ContextData) error { return nil })
 		})
 	}
 
@@ -122,6 +127,18 @@ func (endp *Endpoint) Close() error {
 	return endp.srv.Close()
 }
 
+// serviceProtocol maps the Dovecot service name to the protocol name used by
+// module.ScopedPlainAuth.
+func serviceProtocol(service string) string {
+	switch service {
+	case "imap":
+		return module.ProtocolIMAP
+	case "smtp", "submission":
+		return module.ProtocolSMTP
+	}
+	return service
+}
+
 func init() {
 	module.RegisterEndpoint(modName, New)
 }
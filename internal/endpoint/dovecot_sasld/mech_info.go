@@ -21,6 +21,7 @@ package dovecotsasld
 import (
 	"github.com/emersion/go-sasl"
 	dovecotsasl "github.com/foxcpp/go-dovecot-sasl"
+	"github.com/mail-chat-chain/mailchatd/internal/auth/saslxoauth2"
 )
 
 var mechInfo = map[string]dovecotsasl.Mechanism{
@@ -30,4 +31,6 @@ var mechInfo = map[string]dovecotsasl.Mechanism{
 	sasl.Login: {
 		Plaintext: true,
 	},
+	sasl.OAuthBearer:    {},
+	saslxoauth2.XOAuth2: {},
 }
@@ -0,0 +1,285 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package http_endpoint
+
+import (
+	"encoding/xml"
+	"io"
+	"net"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+const (
+	securitySSL      = "SSL"
+	securitySTARTTLS = "STARTTLS"
+	securityPlain    = "plain"
+)
+
+// serverSettings are the connection settings for a single listener as
+// advertised to clients.
+type serverSettings struct {
+	Hostname string
+	Port     int
+	// Security is SSL (implicit TLS), STARTTLS or plain.
+	Security string
+}
+
+// settings returns the connection settings for the protocol endpoints,
+// most secure first. Unix sockets and loopback addresses are not included.
+func (e *Endpoint) settings(protocol string) []serverSettings {
+	var res []serverSettings
+	seen := make(map[string]bool)
+	for _, l := range module.Listeners(protocol) {
+		if l.Endpoint.Network() != "tcp" {
+			continue
+		}
+		if l.Endpoint.Host == "localhost" {
+			continue
+		}
+		if ip := net.ParseIP(l.Endpoint.Host); ip != nil && ip.IsLoopback() {
+			continue
+		}
+		port, err := strconv.Atoi(l.Endpoint.Port)
+		if err != nil {
+			continue
+		}
+
+		s := serverSettings{
+			Hostname: l.Hostname,
+			Port:     port,
+			Security: securityPlain,
+		}
+		if s.Hostname == "" {
+			s.Hostname = e.hostname
+		}
+		switch {
+		case l.Endpoint.IsTLS():
+			s.Security = securitySSL
+		case l.STARTTLS:
+			s.Security = securitySTARTTLS
+		}
+
+		key := s.Hostname + ":" + l.Endpoint.Port
+		if seen[key] {
+			continue
+		}
+		seen[key] = true
+		res = append(res, s)
+	}
+
+	rank := map[string]int{securitySSL: 0, securitySTARTTLS: 1, securityPlain: 2}
+	sort.SliceStable(res, func(i, j int) bool {
+		return rank[res[i].Security] < rank[res[j].Security]
+	})
+	return res
+}
+
+// requestDomain returns the mail domain the client is configuring. It is
+// taken from the email address, if known, or from the Host header
+// (autoconfig.example.org).
+func (e *Endpoint) requestDomain(r *http.Request, email string) string {
+	if email != "" {
+		_, domain, err := address.Split(email)
+		if err == nil && domain != "" {
+			return strings.ToLower(domain)
+		}
+	}
+
+	host := r.Host
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.ToLower(host)
+	for _, prefix := range []string{"autoconfig.", "autodiscover."} {
+		if strings.HasPrefix(host, prefix) {
+			return strings.TrimPrefix(host, prefix)
+		}
+	}
+	if host == "" || net.ParseIP(host) != nil {
+		return e.hostname
+	}
+	return host
+}
+
+type autoconfigServer struct {
+	Type           string `xml:"type,attr"`
+	Hostname       string `xml:"hostname"`
+	Port           int    `xml:"port"`
+	SocketType     string `xml:"socketType"`
+	Authentication string `xml:"authentication"`
+	Username       string `xml:"username"`
+}
+
+type autoconfigProvider struct {
+	ID               string             `xml:"id,attr"`
+	Domain           string             `xml:"domain"`
+	DisplayName      string             `xml:"displayName"`
+	DisplayShortName string             `xml:"displayShortName"`
+	Incoming         []autoconfigServer `xml:"incomingServer"`
+	Outgoing         []autoconfigServer `xml:"outgoingServer"`
+}
+
+type autoconfigResponse struct {
+	XMLName  xml.Name           `xml:"clientConfig"`
+	Version  string             `xml:"version,attr"`
+	Provider autoconfigProvider `xml:"emailProvider"`
+}
+
+// serveAutoconfig serves the Thunderbird autoconfig document.
+//
+// See https://wiki.mozilla.org/Thunderbird:Autoconfiguration:ConfigFileFormat
+func (e *Endpoint) serveAutoconfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	domain := e.requestDomain(r, r.URL.Query().Get("emailaddress"))
+	resp := autoconfigResponse{
+		Version: "1.1",
+		Provider: autoconfigProvider{
+			ID:               domain,
+			Domain:           domain,
+			DisplayName:      e.displayName,
+			DisplayShortName: e.displayName,
+		},
+	}
+	server := func(typ string, s serverSettings) autoconfigServer {
+		return autoconfigServer{
+			Type:           typ,
+			Hostname:       s.Hostname,
+			Port:           s.Port,
+			SocketType:     s.Security,
+			Authentication: "password-cleartext",
+			Username:       "%EMAILADDRESS%",
+		}
+	}
+	for _, s := range e.settings("imap") {
+		resp.Provider.Incoming = append(resp.Provider.Incoming, server("imap", s))
+	}
+	for _, s := range e.settings("submission") {
+		resp.Provider.Outgoing = append(resp.Provider.Outgoing, server("smtp", s))
+	}
+
+	e.writeXML(w, resp)
+}
+
+type autodiscoverRequest struct {
+	EmailAddress string `xml:"Request>EMailAddress"`
+}
+
+type autodiscoverProtocol struct {
+	Type           string `xml:"Type"`
+	Server         string `xml:"Server"`
+	Port           int    `xml:"Port"`
+	DomainRequired string `xml:"DomainRequired"`
+	LoginName      string `xml:"LoginName,omitempty"`
+	SPA            string `xml:"SPA"`
+	SSL            string `xml:"SSL"`
+	Encryption     string `xml:"Encryption"`
+	AuthRequired   string `xml:"AuthRequired"`
+}
+
+type autodiscoverResponse struct {
+	XMLName  xml.Name `xml:"http://schemas.microsoft.com/exchange/autodiscover/responseschema/2006 Autodiscover"`
+	Response struct {
+		XMLNS   string `xml:"xmlns,attr"`
+		Account struct {
+			AccountType string                 `xml:"AccountType"`
+			Action      string                 `xml:"Action"`
+			Protocols   []autodiscoverProtocol `xml:"Protocol"`
+		} `xml:"Account"`
+	} `xml:"Response"`
+}
+
+// serveAutodiscover serves the Outlook (POX) Autodiscover response.
+//
+// See [MS-OXDSCLI] for the format.
+func (e *Endpoint) serveAutodiscover(w http.ResponseWriter, r *http.Request) {
+	var email string
+	switch r.Method {
+	case http.MethodPost:
+		var req autodiscoverRequest
+		if err := xml.NewDecoder(io.LimitReader(r.Body, 64*1024)).Decode(&req); err != nil {
+			e.logger.DebugMsg("malformed autodiscover request", "err", err)
+			http.Error(w, "Malformed request", http.StatusBadRequest)
+			return
+		}
+		email = strings.TrimSpace(req.EmailAddress)
+	case http.MethodGet:
+		email = r.URL.Query().Get("email")
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var resp autodiscoverResponse
+	resp.Response.XMLNS = "http://schemas.microsoft.com/exchange/autodiscover/outlook/responseschema/2006a"
+	resp.Response.Account.AccountType = "email"
+	resp.Response.Account.Action = "settings"
+	protocol := func(typ string, s serverSettings) autodiscoverProtocol {
+		p := autodiscoverProtocol{
+			Type:           typ,
+			Server:         s.Hostname,
+			Port:           s.Port,
+			DomainRequired: "off",
+			LoginName:      email,
+			SPA:            "off",
+			SSL:            "on",
+			AuthRequired:   "on",
+		}
+		switch s.Security {
+		case securitySSL:
+			p.Encryption = "SSL"
+		case securitySTARTTLS:
+			p.Encryption = "TLS"
+		default:
+			p.SSL = "off"
+			p.Encryption = "None"
+		}
+		return p
+	}
+	// Outlook uses only the first entry of each type.
+	if imap := e.settings("imap"); len(imap) != 0 {
+		resp.Response.Account.Protocols = append(resp.Response.Account.Protocols, protocol("IMAP", imap[0]))
+	}
+	if smtp := e.settings("submission"); len(smtp) != 0 {
+		resp.Response.Account.Protocols = append(resp.Response.Account.Protocols, protocol("SMTP", smtp[0]))
+	}
+
+	e.writeXML(w, resp)
+}
+
+func (e *Endpoint) writeXML(w http.ResponseWriter, v interface{}) {
+	blob, err := xml.MarshalIndent(v, "", "  ")
+	if err != nil {
+		e.logger.Error("failed to serialize response", err)
+		w.WriteHeader(http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/xml; charset=utf-8")
+	_, _ = io.WriteString(w, xml.Header)
+	_, _ = w.Write(blob)
+}
@@ -0,0 +1,194 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package http_endpoint implements the http endpoint module that serves
+// documents used by mail clients and remote servers to discover the server
+// configuration:
+//
+// - MTA-STS policy (RFC 8461) at /.well-known/mta-sts.txt
+// - Thunderbird autoconfig at /mail/config-v1.1.xml
+// - Outlook Autodiscover at /autodiscover/autodiscover.xml
+// - Apple configuration profiles at /mobileconfig
+// - RFC 8058 one-click unsubscription for target.list at /unsubscribe
+//
+// Client settings are generated from the smtp, submission and imap endpoints
+// defined in the same configuration.
+package http_endpoint
+
+import (
+	"crypto/tls"
+	"errors"
+	"fmt"
+	stdlog "log"
+	"net"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	tls2 "github.com/mail-chat-chain/mailchatd/framework/config/tls"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"golang.org/x/net/idna"
+)
+
+const modName = "http"
+
+type Endpoint struct {
+	addrs  []string
+	logger log.Logger
+
+	hostname    string
+	displayName string
+	tlsConfig   *tls.Config
+
+	mtaSTSMode   string
+	mtaSTSMaxAge time.Duration
+	mtaSTSMX     []string
+
+	unsub module.Unsubscriber
+
+	listenersWg sync.WaitGroup
+	serv        http.Server
+	mux         *http.ServeMux
+}
+
+func New(_ string, args []string) (module.Module, error) {
+	return &Endpoint{
+		addrs:  args,
+		logger: log.Logger{Name: modName},
+	}, nil
+}
+
+func (e *Endpoint) Init(cfg *config.Map) error {
+	cfg.Bool("debug", true, false, &e.logger.Debug)
+	cfg.String("hostname", true, true, "", &e.hostname)
+	cfg.String("display_name", false, false, "", &e.displayName)
+	cfg.Custom("tls", true, false, nil, tls2.TLSDirective, &e.tlsConfig)
+	cfg.Enum("mta_sts_mode", false, false, []string{"enforce", "testing", "none"}, "enforce", &e.mtaSTSMode)
+	cfg.Duration("mta_sts_max_age", false, false, 7*24*time.Hour, &e.mtaSTSMaxAge)
+	cfg.StringList("mta_sts_mx", false, false, nil, &e.mtaSTSMX)
+	cfg.Custom("unsubscribe", false, false, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var u module.Unsubscriber
+		err := modconfig.ModuleFromNode("target", node.Args, node, m.Globals, &u)
+		return u, err
+	}, &e.unsub)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	var err error
+	e.hostname, err = idna.ToASCII(e.hostname)
+	if err != nil {
+		return fmt.Errorf("%s: cannot represent the hostname as an A-label name: %w", modName, err)
+	}
+	if len(e.mtaSTSMX) == 0 {
+		e.mtaSTSMX = []string{e.hostname}
+	}
+	if e.displayName == "" {
+		e.displayName = e.hostname
+	}
+
+	e.mux = http.NewServeMux()
+	e.mux.HandleFunc("/.well-known/mta-sts.txt", e.serveMTASTS)
+	e.mux.HandleFunc("/mail/config-v1.1.xml", e.serveAutoconfig)
+	e.mux.HandleFunc("/.well-known/autoconfig/mail/config-v1.1.xml", e.serveAutoconfig)
+	e.mux.HandleFunc("/autodiscover/autodiscover.xml", e.serveAutodiscover)
+	e.mux.HandleFunc("/Autodiscover/Autodiscover.xml", e.serveAutodiscover)
+	e.mux.HandleFunc("/mobileconfig", e.serveMobileconfig)
+	if e.unsub != nil {
+		e.mux.HandleFunc("/unsubscribe", e.serveUnsubscribe)
+	}
+	e.serv.Handler = e.mux
+	e.serv.ReadHeaderTimeout = 30 * time.Second
+	e.serv.ErrorLog = stdlog.New(e.logger.DebugWriter(), "", 0)
+
+	if module.NoRun {
+		return nil
+	}
+
+	for _, a := range e.addrs {
+		endp, err := config.ParseEndpoint(a)
+		if err != nil {
+			return fmt.Errorf("%s: malformed endpoint: %v", modName, err)
+		}
+		l, err := net.Listen(endp.Network(), endp.Address())
+		if err != nil {
+			return fmt.Errorf("%s: %v", modName, err)
+		}
+		if endp.IsTLS() {
+			if e.tlsConfig == nil {
+				l.Close()
+				return fmt.Errorf("%s: can't bind on HTTPS endpoint without TLS configuration", modName)
+			}
+			l = tls.NewListener(l, e.tlsConfig)
+		}
+
+		e.listenersWg.Add(1)
+		go func() {
+			e.logger.Println("listening on", endp.String())
+			err := e.serv.Serve(l)
+			if err != nil && !errors.Is(err, http.ErrServerClosed) {
+				e.logger.Error("serve failed", err, "endpoint", a)
+			}
+			e.listenersWg.Done()
+		}()
+	}
+
+	return nil
+}
+
+func (e *Endpoint) serveMTASTS(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	var policy strings.Builder
+	policy.WriteString("version: STSv1\r\n")
+	policy.WriteString("mode: " + e.mtaSTSMode + "\r\n")
+	for _, mx := range e.mtaSTSMX {
+		policy.WriteString("mx: " + mx + "\r\n")
+	}
+	fmt.Fprintf(&policy, "max_age: %d\r\n", int64(e.mtaSTSMaxAge/time.Second))
+
+	w.Header().Set("Content-Type", "text/plain")
+	_, _ = w.Write([]byte(policy.String()))
+}
+
+func (e *Endpoint) Name() string {
+	return modName
+}
+
+func (e *Endpoint) InstanceName() string {
+	return ""
+}
+
+func (e *Endpoint) Close() error {
+	if err := e.serv.Close(); err != nil {
+		return err
+	}
+	e.listenersWg.Wait()
+	return nil
+}
+
+func init() {
+	module.RegisterEndpoint(modName, New)
+}
@@ -0,0 +1,266 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package http_endpoint
+
+import (
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+func init() {
+	for _, l := range []struct {
+		proto, addr string
+		starttls    bool
+	}{
+		{"imap", "tcp://0.0.0.0:143", true},
+		{"imap", "tls://0.0.0.0:993", false},
+		{"imap", "tcp://127.0.0.1:1143", false},
+		{"submission", "tcp://0.0.0.0:587", true},
+		{"submission", "unix:///run/submission.sock", false},
+	} {
+		endp, err := config.ParseEndpoint(l.addr)
+		if err != nil {
+			panic(err)
+		}
+		module.RegisterListener(module.Listener{
+			Protocol: l.proto,
+			Endpoint: endp,
+			STARTTLS: l.starttls,
+		})
+	}
+}
+
+func testEndpoint(t *testing.T) *Endpoint {
+	return &Endpoint{
+		logger:       testutils.Logger(t, modName),
+		hostname:     "mx.example.org",
+		displayName:  "Example Mail",
+		mtaSTSMode:   "enforce",
+		mtaSTSMaxAge: 7 * 24 * time.Hour,
+		mtaSTSMX:     []string{"mx.example.org"},
+	}
+}
+
+func TestSettings(t *testing.T) {
+	e := testEndpoint(t)
+
+	imap := e.settings("imap")
+	if len(imap) != 2 {
+		t.Fatalf("expected 2 IMAP listeners, got %+v", imap)
+	}
+	if imap[0].Port != 993 || imap[0].Security != securitySSL {
+		t.Errorf("expected implicit TLS listener first, got %+v", imap[0])
+	}
+	if imap[1].Port != 143 || imap[1].Security != securitySTARTTLS {
+		t.Errorf("unexpected second listener: %+v", imap[1])
+	}
+	if imap[0].Hostname != "mx.example.org" {
+		t.Errorf("hostname was not defaulted: %+v", imap[0])
+	}
+
+	smtp := e.settings("submission")
+	if len(smtp) != 1 || smtp[0].Port != 587 {
+		t.Errorf("unexpected submission listeners: %+v", smtp)
+	}
+}
+
+func TestMTASTS(t *testing.T) {
+	e := testEndpoint(t)
+	rec := httptest.NewRecorder()
+	e.serveMTASTS(rec, httptest.NewRequest("GET", "https://mta-sts.example.org/.well-known/mta-sts.txt", nil))
+
+	expected := "version: STSv1\r\nmode: enforce\r\nmx: mx.example.org\r\nmax_age: 604800\r\n"
+	if rec.Body.String() != expected {
+		t.Errorf("wrong policy:\n%q\nexpected:\n%q", rec.Body.String(), expected)
+	}
+	if ct := rec.Header().Get("Content-Type"); ct != "text/plain" {
+		t.Errorf("wrong Content-Type: %s", ct)
+	}
+}
+
+func TestAutoconfig(t *testing.T) {
+	e := testEndpoint(t)
+	rec := httptest.NewRecorder()
+	e.serveAutoconfig(rec, httptest.NewRequest("GET", "https://autoconfig.example.com/mail/config-v1.1.xml?emailaddress=foo%40example.com", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+
+	var resp autoconfigResponse
+	if err := xml.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	if resp.Provider.ID != "example.com" {
+		t.Errorf("wrong provider id: %s", resp.Provider.ID)
+	}
+	if len(resp.Provider.Incoming) != 2 || resp.Provider.Incoming[0].SocketType != "SSL" || resp.Provider.Incoming[0].Port != 993 {
+		t.Errorf("wrong incoming servers: %+v", resp.Provider.Incoming)
+	}
+	if len(resp.Provider.Outgoing) != 1 || resp.Provider.Outgoing[0].Type != "smtp" || resp.Provider.Outgoing[0].SocketType != "STARTTLS" {
+		t.Errorf("wrong outgoing servers: %+v", resp.Provider.Outgoing)
+	}
+}
+
+func TestAutodiscover(t *testing.T) {
+	e := testEndpoint(t)
+	req := `<?xml version="1.0" encoding="utf-8"?>
+<Autodiscover xmlns="http://schemas.microsoft.com/exchange/autodiscover/outlook/requestschema/2006">
+  <Request>
+    <EMailAddress>foo@example.org</EMailAddress>
+    <AcceptableResponseSchema>http://schemas.microsoft.com/exchange/autodiscover/outlook/responseschema/2006a</AcceptableResponseSchema>
+  </Request>
+</Autodiscover>`
+	rec := httptest.NewRecorder()
+	e.serveAutodiscover(rec, httptest.NewRequest("POST", "https://autodiscover.example.org/autodiscover/autodiscover.xml", strings.NewReader(req)))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+
+	var resp autodiscoverResponse
+	if err := xml.Unmarshal(rec.Body.Bytes(), &resp); err != nil {
+		t.Fatal(err)
+	}
+	protos := resp.Response.Account.Protocols
+	if len(protos) != 2 {
+		t.Fatalf("expected 2 protocols, got %+v", protos)
+	}
+	if protos[0].Type != "IMAP" || protos[0].Port != 993 || protos[0].Encryption != "SSL" || protos[0].LoginName != "foo@example.org" {
+		t.Errorf("wrong IMAP settings: %+v", protos[0])
+	}
+	if protos[1].Type != "SMTP" || protos[1].Port != 587 || protos[1].Encryption != "TLS" {
+		t.Errorf("wrong SMTP settings: %+v", protos[1])
+	}
+
+	rec = httptest.NewRecorder()
+	e.serveAutodiscover(rec, httptest.NewRequest("POST", "https://autodiscover.example.org/autodiscover/autodiscover.xml", strings.NewReader("garbage")))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("malformed request accepted: %d", rec.Code)
+	}
+}
+
+func TestMobileconfig(t *testing.T) {
+	e := testEndpoint(t)
+	rec := httptest.NewRecorder()
+	e.serveMobileconfig(rec, httptest.NewRequest("GET", "https://mx.example.org/mobileconfig?email=foo%40example.org", nil))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+	body := rec.Body.String()
+	for _, part := range []string{
+		"<key>EmailAddress</key><string>foo@example.org</string>",
+		"<key>IncomingMailServerPortNumber</key><integer>993</integer>",
+		"<key>OutgoingMailServerPortNumber</key><integer>587</integer>",
+		"<key>OutgoingMailServerUseSSL</key><true/>",
+		"<key>PayloadIdentifier</key><string>org.example.mx.mail</string>",
+	} {
+		if !strings.Contains(body, part) {
+			t.Errorf("profile does not contain %s", part)
+		}
+	}
+	if err := xml.Unmarshal(rec.Body.Bytes(), new(struct{})); err != nil {
+		t.Errorf("profile is not well-formed: %v", err)
+	}
+	if cd := rec.Header().Get("Content-Disposition"); cd != `attachment; filename=example.org.mobileconfig` {
+		t.Errorf("unexpected Content-Disposition: %s", cd)
+	}
+
+	// Profile should be stable.
+	rec2 := httptest.NewRecorder()
+	e.serveMobileconfig(rec2, httptest.NewRequest("GET", "https://mx.example.org/mobileconfig?email=foo%40example.org", nil))
+	if rec2.Body.String() != body {
+		t.Error("profile changed between requests")
+	}
+
+	// Domain is quoted in the header.
+	rec = httptest.NewRecorder()
+	e.serveMobileconfig(rec, httptest.NewRequest("GET", "https://mx.example.org/mobileconfig?email=foo%40a%22%3B+x%3Dy", nil))
+	_, params, err := mime.ParseMediaType(rec.Header().Get("Content-Disposition"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if params["filename"] != `a"; x=y.mobileconfig` || len(params) != 1 {
+		t.Errorf("domain is not quoted in Content-Disposition: %v", params)
+	}
+
+	rec = httptest.NewRecorder()
+	e.serveMobileconfig(rec, httptest.NewRequest("GET", "https://mx.example.org/mobileconfig", nil))
+	if rec.Code != http.StatusBadRequest {
+		t.Errorf("request without email accepted: %d", rec.Code)
+	}
+}
+
+type unsubscriber struct {
+	calls []string
+}
+
+func (u *unsubscriber) Unsubscribe(listAddr, member, token string) error {
+	if token != "valid" {
+		return module.ErrInvalidToken
+	}
+	u.calls = append(u.calls, listAddr+" "+member)
+	return nil
+}
+
+func TestUnsubscribe(t *testing.T) {
+	e := testEndpoint(t)
+	u := &unsubscriber{}
+	e.unsub = u
+
+	link := func(token string) string {
+		return "https://mx.example.org/unsubscribe?" + url.Values{
+			"list":  {"dev@example.org"},
+			"addr":  {"foo@example.com"},
+			"token": {token},
+		}.Encode()
+	}
+	oneClick := func() *strings.Reader {
+		return strings.NewReader("List-Unsubscribe=One-Click")
+	}
+
+	rec := httptest.NewRecorder()
+	e.serveUnsubscribe(rec, httptest.NewRequest("GET", link("valid"), nil))
+	if rec.Code != http.StatusOK || len(u.calls) != 0 {
+		t.Fatalf("GET should only show the form: %d %v", rec.Code, u.calls)
+	}
+
+	rec = httptest.NewRecorder()
+	e.serveUnsubscribe(rec, httptest.NewRequest("POST", link("invalid"), oneClick()))
+	if rec.Code != http.StatusForbidden || len(u.calls) != 0 {
+		t.Fatalf("invalid token accepted: %d %v", rec.Code, u.calls)
+	}
+
+	rec = httptest.NewRecorder()
+	e.serveUnsubscribe(rec, httptest.NewRequest("POST", link("valid"), oneClick()))
+	if rec.Code != http.StatusOK {
+		t.Fatalf("unexpected status: %d", rec.Code)
+	}
+	if len(u.calls) != 1 || u.calls[0] != "dev@example.org foo@example.com" {
+		t.Fatalf("wrong calls: %v", u.calls)
+	}
+}
@@ -0,0 +1,168 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package http_endpoint
+
+import (
+	"bytes"
+	"encoding/xml"
+	"mime"
+	"net/http"
+	"strconv"
+	"strings"
+
+	"github.com/google/uuid"
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+)
+
+// plistEntry is a key-value pair of the property list dictionary. Values
+// can be string, int, bool, plistDict or []plistDict.
+type plistEntry struct {
+	Key   string
+	Value interface{}
+}
+
+type plistDict []plistEntry
+
+func (d plistDict) encode(b *bytes.Buffer) {
+	b.WriteString("<dict>")
+	for _, e := range d {
+		b.WriteString("<key>")
+		_ = xml.EscapeText(b, []byte(e.Key))
+		b.WriteString("</key>")
+		switch v := e.Value.(type) {
+		case string:
+			b.WriteString("<string>")
+			_ = xml.EscapeText(b, []byte(v))
+			b.WriteString("</string>")
+		case int:
+			b.WriteString("<integer>" + strconv.Itoa(v) + "</integer>")
+		case bool:
+			if v {
+				b.WriteString("<true/>")
+			} else {
+				b.WriteString("<false/>")
+			}
+		case plistDict:
+			v.encode(b)
+		case []plistDict:
+			b.WriteString("<array>")
+			for _, d := range v {
+				d.encode(b)
+			}
+			b.WriteString("</array>")
+		default:
+			panic("plist: unsupported value type")
+		}
+	}
+	b.WriteString("</dict>")
+}
+
+// payloadIdentifier returns the reverse-DNS identifier used for profile
+// payloads, e.g. org.example.mx.mail.
+func payloadIdentifier(hostname string) string {
+	labels := strings.Split(strings.TrimSuffix(hostname, "."), ".")
+	for i, j := 0, len(labels)-1; i < j; i, j = i+1, j-1 {
+		labels[i], labels[j] = labels[j], labels[i]
+	}
+	return strings.Join(labels, ".") + ".mail"
+}
+
+// serveMobileconfig serves the Apple configuration profile for the account
+// specified using the email query parameter.
+//
+// The profile is not signed so devices show it as unverified.
+func (e *Endpoint) serveMobileconfig(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet && r.Method != http.MethodHead {
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	email := r.URL.Query().Get("email")
+	if email == "" {
+		http.Error(w, "Missing email parameter", http.StatusBadRequest)
+		return
+	}
+	if _, domain, err := address.Split(email); err != nil || domain == "" {
+		http.Error(w, "Invalid email address", http.StatusBadRequest)
+		return
+	}
+	imap := e.settings("imap")
+	smtp := e.settings("submission")
+	if len(imap) == 0 || len(smtp) == 0 {
+		http.Error(w, "IMAP or submission endpoint is not configured", http.StatusNotFound)
+		return
+	}
+	domain := e.requestDomain(r, email)
+
+	// UUIDs should be stable so reinstalling the profile replaces the
+	// existing one instead of adding a duplicate account.
+	ident := payloadIdentifier(e.hostname)
+	accountID := uuid.NewSHA1(uuid.NameSpaceURL, []byte("mailto:"+strings.ToLower(email))).String()
+	profileID := uuid.NewSHA1(uuid.NameSpaceURL, []byte("mobileconfig:"+e.hostname+":"+strings.ToLower(email))).String()
+
+	account := plistDict{
+		{"EmailAccountDescription", e.displayName},
+		{"EmailAccountName", email},
+		{"EmailAccountType", "EmailTypeIMAP"},
+		{"EmailAddress", email},
+		{"IncomingMailServerAuthentication", "EmailAuthPassword"},
+		{"IncomingMailServerHostName", imap[0].Hostname},
+		{"IncomingMailServerPortNumber", imap[0].Port},
+		{"IncomingMailServerUseSSL", imap[0].Security != securityPlain},
+		{"IncomingMailServerUsername", email},
+		{"OutgoingMailServerAuthentication", "EmailAuthPassword"},
+		{"OutgoingMailServerHostName", smtp[0].Hostname},
+		{"OutgoingMailServerPortNumber", smtp[0].Port},
+		{"OutgoingMailServerUseSSL", smtp[0].Security != securityPlain},
+		{"OutgoingMailServerUsername", email},
+		{"OutgoingPasswordSameAsIncomingPassword", true},
+		{"PayloadDescription", "Email account " + email},
+		{"PayloadDisplayName", domain},
+		{"PayloadIdentifier", ident + ".account." + accountID},
+		{"PayloadType", "com.apple.mail.managed"},
+		{"PayloadUUID", accountID},
+		{"PayloadVersion", 1},
+	}
+	profile := plistDict{
+		{"PayloadContent", []plistDict{account}},
+		{"PayloadDisplayName", e.displayName},
+		{"PayloadIdentifier", ident},
+		{"PayloadRemovalDisallowed", false},
+		{"PayloadType", "Configuration"},
+		{"PayloadUUID", profileID},
+		{"PayloadVersion", 1},
+	}
+
+	var b bytes.Buffer
+	b.WriteString(xml.Header)
+	b.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	b.WriteString(`<plist version="1.0">`)
+	profile.encode(&b)
+	b.WriteString("</plist>\n")
+
+	w.Header().Set("Content-Type", "application/x-apple-aspen-config; charset=utf-8")
+	// The domain comes from the request, FormatMediaType quotes it and
+	// returns an empty string if it cannot be represented.
+	disposition := mime.FormatMediaType("attachment", map[string]string{"filename": domain + ".mobileconfig"})
+	if disposition == "" {
+		disposition = "attachment"
+	}
+	w.Header().Set("Content-Disposition", disposition)
+	_, _ = w.Write(b.Bytes())
+}
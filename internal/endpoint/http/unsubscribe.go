@@ -0,0 +1,86 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package http_endpoint
+
+import (
+	"errors"
+	"html/template"
+	"net/http"
+
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+var unsubscribePage = template.Must(template.New("unsubscribe").Parse(`<!DOCTYPE html>
+<html>
+<head><meta charset="utf-8"><title>Unsubscribe</title></head>
+<body>
+{{if .Done}}<p>{{.Member}} has been unsubscribed from {{.List}}.</p>
+{{else}}<form method="post">
+<p>Unsubscribe {{.Member}} from {{.List}}?</p>
+<input type="hidden" name="List-Unsubscribe" value="One-Click">
+<button type="submit">Unsubscribe</button>
+</form>
+{{end}}</body>
+</html>
+`))
+
+// serveUnsubscribe handles unsubscription links generated by target.list.
+//
+// POST requests (RFC 8058 one-click) remove the member immediately. GET
+// requests only show a confirmation form since links are often followed by
+// link scanners and prefetchers.
+func (e *Endpoint) serveUnsubscribe(w http.ResponseWriter, r *http.Request) {
+	query := r.URL.Query()
+	data := struct {
+		List, Member string
+		Done         bool
+	}{
+		List:   query.Get("list"),
+		Member: query.Get("addr"),
+	}
+	token := query.Get("token")
+	if data.List == "" || data.Member == "" || token == "" {
+		http.Error(w, "Invalid unsubscription link", http.StatusBadRequest)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet, http.MethodHead:
+	case http.MethodPost:
+		if err := e.unsub.Unsubscribe(data.List, data.Member, token); err != nil {
+			if errors.Is(err, module.ErrInvalidToken) {
+				http.Error(w, "Invalid unsubscription link", http.StatusForbidden)
+				return
+			}
+			e.logger.Error("unsubscribe failed", err, "list", data.List, "member", data.Member)
+			http.Error(w, "Unable to unsubscribe", http.StatusBadRequest)
+			return
+		}
+		e.logger.Msg("member unsubscribed", "list", data.List, "member", data.Member)
+		data.Done = true
+	default:
+		w.WriteHeader(http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	if err := unsubscribePage.Execute(w, data); err != nil {
+		e.logger.Error("failed to render page", err)
+	}
+}
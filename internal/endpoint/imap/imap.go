@@ -43,6 +43,7 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
 	"github.com/mail-chat-chain/mailchatd/internal/auth"
+	"github.com/mail-chat-chain/mailchatd/internal/authguard"
 	"github.com/mail-chat-chain/mailchatd/internal/authz"
 	"github.com/mail-chat-chain/mailchatd/internal/proxy_protocol"
 	"github.com/mail-chat-chain/mailchatd/internal/updatepipe"
@@ -71,7 +72,8 @@ func New(modName string, addrs []string) (module.Module, error) {
 		addrs: addrs,
 		Log:   log.Logger{Name: modName},
 		saslAuth: auth.SASLAuth{
-			Log: log.Logger{Name: modName + "/sasl"},
+			Log:      log.Logger{Name: modName + "/sasl"},
+			Protocol: module.ProtocolIMAP,
 		},
 	}
 
@@ -89,6 +91,7 @@ func (endp *Endpoint) Init(cfg *config.Map) error {
 		return endp.saslAuth.AddProvider(m, node)
 	})
 	cfg.Bool("sasl_login", false, false, &endp.saslAuth.EnableLogin)
+	cfg.Custom("auth_guard", false, false, nil, authguard.Directive, &endp.saslAuth.Guard)
 	cfg.Custom("storage", false, true, nil, modconfig.StorageDirective, &endp.Store)
 	cfg.Custom("tls", true, true, nil, tls2.TLSDirective, &endp.tlsConfig)
 	cfg.Custom("proxy_protocol", false, false, nil, proxy_protocol.ProxyProtocolDirective, &endp.proxyProtocol)
@@ -143,7 +146,7 @@ func (endp *Endpoint) Init(cfg *config.Map) error {
 	for _, mech := range endp.saslAuth.SASLMechanisms() {
 		endp.serv.EnableAuth(mech, func(c imapserver.Conn) sasl.Server {
 			return endp.saslAuth.CreateSASL(mech, c.Info().RemoteAddr, func(identity string, data auth.ContextData) error {
-				return endp.openAccount(c, identity)
+				return endp.openAccount(c, identity, data.Password)
 			})
 		})
 	}
@@ -173,6 +176,11 @@ func (endp *Endpoint) setupListeners(addresses []config.Endpoint) error {
 		}
 
 		endp.listeners = append(endp.listeners, l)
+		module.RegisterListener(module.Listener{
+			Protocol: "imap",
+			Endpoint: addr,
+			STARTTLS: !addr.IsTLS() && endp.tlsConfig != nil,
+		})
 
 		endp.listenersWg.Add(1)
 		go func() {
@@ -238,7 +246,7 @@ func (endp *Endpoint) usernameForStorage(ctx context.Context, saslUsername strin
 	return mapped, nil
 }
 
-func (endp *Endpoint) openAccount(c imapserver.Conn, identity string) error {
+func (endp *Endpoint) openAccount(c imapserver.Conn, identity, password string) error {
 	username, err := endp.usernameForStorage(context.TODO(), identity)
 	if err != nil {
 		if errors.Is(err, imapbackend.ErrInvalidCredentials) {
@@ -248,7 +256,7 @@ func (endp *Endpoint) openAccount(c imapserver.Conn, identity string) error {
 		return fmt.Errorf("internal server error")
 	}
 
-	u, err := endp.Store.GetOrCreateIMAPAcct(username)
+	u, err := endp.openStorageAcct(username, password)
 	if err != nil {
 		return err
 	}
@@ -260,7 +268,7 @@ func (endp *Endpoint) openAccount(c imapserver.Conn, identity string) error {
 
 func (endp *Endpoint) Login(connInfo *imap.ConnInfo, username, password string) (imapbackend.User, error) {
 	// saslAuth handles AuthMap calling.
-	err := endp.saslAuth.AuthPlain(username, password)
+	err := endp.saslAuth.AuthPlainFrom(connInfo.RemoteAddr, username, password)
 	if err != nil {
 		endp.Log.Error("authentication failed", err, "username", username, "src_ip", connInfo.RemoteAddr)
 		return nil, imapbackend.ErrInvalidCredentials
@@ -275,7 +283,16 @@ func (endp *Endpoint) Login(connInfo *imap.ConnInfo, username, password string)
 		return nil, fmt.Errorf("internal server error")
 	}
 
-	return endp.Store.GetOrCreateIMAPAcct(storageUsername)
+	return endp.openStorageAcct(storageUsername, password)
+}
+
+// openStorageAcct opens the storage account for the session, passing the
+// login password to the storage if it uses it during the session.
+func (endp *Endpoint) openStorageAcct(username, password string) (imapbackend.User, error) {
+	if sessions, ok := endp.Store.(module.SessionStorage); ok {
+		return sessions.OpenIMAPSession(username, password)
+	}
+	return endp.Store.GetOrCreateIMAPAcct(username)
 }
 
 func (endp *Endpoint) I18NLevel() int {
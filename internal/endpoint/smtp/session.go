@@ -39,7 +39,10 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 	"github.com/mail-chat-chain/mailchatd/internal/auth"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 func limitReader(r io.Reader, n int64, err error) *limitedReader {
@@ -76,6 +79,7 @@ type Session struct {
 	// Specific for this session.
 	// sessionCtx is not used for cancellation or timeouts, only for tracing.
 	sessionCtx       context.Context
+	sessionSpan      oteltrace.Span
 	cancelRDNS       func()
 	connState        module.ConnState
 	repeatedMailErrs int
@@ -89,6 +93,7 @@ type Session struct {
 	msgLock     sync.Mutex
 	msgCtx      context.Context
 	msgTask     *trace.Task
+	msgSpan     oteltrace.Span
 	mailFrom    string
 	opts        smtp.MailOptions
 	msgMeta     *module.MsgMetadata
@@ -142,6 +147,7 @@ func (s *Session) abort(ctx context.Context) {
 		s.endp.Log.Error("delivery abort failed", err)
 	}
 	s.log.Msg("aborted", "msg_id", s.msgMeta.ID)
+	s.msgSpan.AddEvent("aborted")
 	abortedSMTPTransactions.WithLabelValues(s.endp.name).Inc()
 	s.cleanSession()
 }
@@ -156,6 +162,7 @@ func (s *Session) cleanSession() {
 	s.deliveryErr = nil
 	s.msgCtx = nil
 	s.msgTask.End()
+	s.msgSpan.End()
 }
 
 func (s *Session) AuthPlain(username, password string) error {
@@ -192,8 +199,7 @@ func (s *Session) AuthPlain(username, password string) error {
 	return nil
 }
 
-func (s *Session) startDelivery(ctx context.Context, from string, opts smtp.MailOptions) (string, error) {
-	var err error
+func (s *Session) startDelivery(ctx context.Context, from string, opts smtp.MailOptions) (msgID string, err error) {
 	msgMeta := &module.MsgMetadata{
 		Conn:     &s.connState,
 		SMTPOpts: opts,
@@ -203,22 +209,30 @@ func (s *Session) startDelivery(ctx context.Context, from string, opts smtp.Mail
 		return "", err
 	}
 
+	msgCtx, msgSpan := tracing.Start(ctx, "smtp.message",
+		attribute.String("msg_id", msgMeta.ID),
+		attribute.String("smtp.mail_from", from))
+	defer func() {
+		if err != nil {
+			tracing.End(msgSpan, err)
+		}
+	}()
+	msgMeta.TraceParent = tracing.TraceParent(msgCtx)
+
+	logFields := []interface{}{
+		"src_host", msgMeta.Conn.Hostname,
+		"src_ip", msgMeta.Conn.RemoteAddr.String(),
+		"sender", from,
+		"msg_id", msgMeta.ID,
+	}
 	if s.connState.AuthUser != "" {
-		s.log.Msg("incoming message",
-			"src_host", msgMeta.Conn.Hostname,
-			"src_ip", msgMeta.Conn.RemoteAddr.String(),
-			"sender", from,
-			"msg_id", msgMeta.ID,
-			"username", s.connState.AuthUser,
-		)
-	} else {
-		s.log.Msg("incoming message",
-			"src_host", msgMeta.Conn.Hostname,
-			"src_ip", msgMeta.Conn.RemoteAddr.String(),
-			"sender", from,
-			"msg_id", msgMeta.ID,
-		)
+		msgSpan.SetAttributes(attribute.String("auth.user", s.connState.AuthUser))
+		logFields = append(logFields, "username", s.connState.AuthUser)
+	}
+	if traceID := tracing.TraceID(msgMeta.TraceParent); traceID != "" {
+		logFields = append(logFields, "trace_id", traceID)
 	}
+	s.log.Msg("incoming message", logFields...)
 
 	// INTERNATIONALIZATION: Do not permit non-ASCII addresses unless SMTPUTF8 is
 	// used.
@@ -264,7 +278,8 @@ func (s *Session) startDelivery(ctx context.Context, from string, opts smtp.Mail
 		return "", err
 	}
 
-	s.msgCtx, s.msgTask = trace.NewTask(ctx, "Incoming Message")
+	s.msgCtx, s.msgTask = trace.NewTask(msgCtx, "Incoming Message")
+	s.msgSpan = msgSpan
 
 	mailCtx, mailTask := trace.NewTask(s.msgCtx, "MAIL FROM")
 	defer mailTask.End()
@@ -273,6 +288,7 @@ func (s *Session) startDelivery(ctx context.Context, from string, opts smtp.Mail
 	if err != nil {
 		s.msgCtx = nil
 		s.msgTask.End()
+		s.msgSpan = nil
 		s.endp.limits.ReleaseMsg(remoteIP.IP, domain)
 		return msgMeta.ID, err
 	}
@@ -423,6 +439,7 @@ func (s *Session) Logout() error {
 	if s.cancelRDNS != nil {
 		s.cancelRDNS()
 	}
+	s.sessionSpan.End()
 
 	s.endp.sessionCnt.Add(-1)
 
@@ -469,6 +486,7 @@ func (s *Session) Data(r io.Reader) error {
 
 	wrapErr := func(err error) error {
 		s.log.Error("DATA error", err, "msg_id", s.msgMeta.ID)
+		tracing.RecordError(s.msgSpan, err)
 		return s.endp.wrapErr(s.msgMeta.ID, !s.opts.UTF8, "DATA", err)
 	}
 
@@ -524,6 +542,7 @@ func (s *Session) LMTPData(r io.Reader, sc smtp.StatusCollector) error {
 
 	wrapErr := func(err error) error {
 		s.log.Error("DATA error", err, "msg_id", s.msgMeta.ID)
+		tracing.RecordError(s.msgSpan, err)
 		return s.endp.wrapErr(s.msgMeta.ID, !s.opts.UTF8, "DATA", err)
 	}
 
@@ -41,11 +41,14 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/future"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 	"github.com/mail-chat-chain/mailchatd/internal/auth"
+	"github.com/mail-chat-chain/mailchatd/internal/authguard"
 	"github.com/mail-chat-chain/mailchatd/internal/authz"
 	"github.com/mail-chat-chain/mailchatd/internal/limits"
 	"github.com/mail-chat-chain/mailchatd/internal/msgpipeline"
 	"github.com/mail-chat-chain/mailchatd/internal/proxy_protocol"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/idna"
 )
 
@@ -95,7 +98,8 @@ func New(modName string, addrs []string) (module.Module, error) {
 		buffer:     buffer.BufferInMemory,
 		Log:        log.Logger{Name: modName},
 		saslAuth: auth.SASLAuth{
-			Log: log.Logger{Name: modName + "/sasl"},
+			Log:      log.Logger{Name: modName + "/sasl"},
+			Protocol: module.ProtocolSMTP,
 		},
 	}
 	return endp, nil
@@ -246,6 +250,7 @@ func (endp *Endpoint) setConfig(cfg *config.Map) error {
 		return endp.saslAuth.AddProvider(m, node)
 	})
 	cfg.Bool("sasl_login", false, false, &endp.saslAuth.EnableLogin)
+	cfg.Custom("auth_guard", false, false, nil, authguard.Directive, &endp.saslAuth.Guard)
 	cfg.String("hostname", true, true, "", &hostname)
 	config.EnumMapped(cfg, "auth_map_normalize", true, false, authz.NormalizeFuncs, authz.NormalizeAuto,
 		&endp.saslAuth.AuthNormalize)
@@ -340,6 +345,12 @@ func (endp *Endpoint) setupListeners(addresses []config.Endpoint) error {
 		}
 
 		endp.listeners = append(endp.listeners, l)
+		module.RegisterListener(module.Listener{
+			Protocol: endp.name,
+			Hostname: endp.serv.Domain,
+			Endpoint: addr,
+			STARTTLS: !addr.IsTLS() && endp.serv.TLSConfig != nil,
+		})
 
 		endp.listenersWg.Add(1)
 		go func() {
@@ -371,10 +382,11 @@ func (endp *Endpoint) NewSession(conn *smtp.Conn) (smtp.Session, error) {
 
 func (endp *Endpoint) newSession(conn *smtp.Conn) *Session {
 	s := &Session{
-		endp:       endp,
-		log:        endp.Log,
-		sessionCtx: context.Background(),
+		endp: endp,
+		log:  endp.Log,
 	}
+	s.sessionCtx, s.sessionSpan = tracing.Start(context.Background(), "smtp.session",
+		attribute.String("endpoint", endp.name))
 
 	// Used in tests.
 	if conn == nil {
@@ -389,6 +401,10 @@ func (endp *Endpoint) newSession(conn *smtp.Conn) *Session {
 	if tlsState, ok := conn.TLSConnectionState(); ok {
 		s.connState.TLS = tlsState
 	}
+	s.sessionSpan.SetAttributes(
+		attribute.String("net.peer.addr", s.connState.RemoteAddr.String()),
+		attribute.String("smtp.helo", s.connState.Hostname),
+	)
 
 	if endp.serv.LMTP {
 		s.connState.Proto = "LMTP"
@@ -1,6 +1,9 @@
 package libdns
 
 import (
+	"context"
+	"fmt"
+
 	"github.com/libdns/libdns"
 	"github.com/mail-chat-chain/mailchatd/framework/config"
 )
@@ -33,3 +36,23 @@ func (p *ProviderModule) Name() string {
 func (p *ProviderModule) InstanceName() string {
 	return p.instName
 }
+
+// GetRecords lists records in the zone if the underlying provider supports
+// it.
+func (p *ProviderModule) GetRecords(ctx context.Context, zone string) ([]libdns.Record, error) {
+	getter, ok := p.RecordAppender.(libdns.RecordGetter)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", p.modName, ErrNotSupported)
+	}
+	return getter.GetRecords(ctx, zone)
+}
+
+// SetRecords updates records in the zone if the underlying provider supports
+// it.
+func (p *ProviderModule) SetRecords(ctx context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	setter, ok := p.RecordAppender.(libdns.RecordSetter)
+	if !ok {
+		return nil, fmt.Errorf("%s: %w", p.modName, ErrNotSupported)
+	}
+	return setter.SetRecords(ctx, zone, recs)
+}
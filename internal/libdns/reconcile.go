@@ -0,0 +1,204 @@
+package libdns
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"sort"
+	"strings"
+
+	"github.com/libdns/libdns"
+)
+
+var ErrNotSupported = errors.New("operation is not supported by the DNS provider")
+
+// ZoneEditor is the set of libdns interfaces required to reconcile zone
+// contents. It is implemented by ProviderModule.
+type ZoneEditor interface {
+	libdns.RecordGetter
+	libdns.RecordAppender
+	libdns.RecordSetter
+	libdns.RecordDeleter
+}
+
+const (
+	ActionCreate = "create"
+	ActionUpdate = "update"
+	ActionDelete = "delete"
+)
+
+// Change is a single modification of the zone.
+type Change struct {
+	Action string
+	// Old is the record present in the zone. Empty for ActionCreate.
+	Old libdns.Record
+	// New is the desired record. Empty for ActionDelete.
+	New libdns.Record
+}
+
+func (c Change) String() string {
+	switch c.Action {
+	case ActionCreate:
+		return "+ " + FormatRecord(c.New)
+	case ActionDelete:
+		return "- " + FormatRecord(c.Old)
+	default:
+		return "~ " + FormatRecord(c.Old) + "\n  -> " + FormatRecord(c.New)
+	}
+}
+
+// FormatRecord formats the record similarly to the zone file syntax.
+func FormatRecord(rec libdns.Record) string {
+	value := rec.Value
+	switch rec.Type {
+	case "MX":
+		value = fmt.Sprintf("%d %s", rec.Priority, rec.Value)
+	case "SRV":
+		value = fmt.Sprintf("%d %d %s", rec.Priority, rec.Weight, rec.Value)
+	case "TXT":
+		value = `"` + rec.Value + `"`
+	}
+	return fmt.Sprintf("%-40s %-6s %s", rec.Name, rec.Type, value)
+}
+
+// Plan is the list of changes required to make the zone match the desired
+// state.
+type Plan struct {
+	Zone    string
+	Changes []Change
+}
+
+// slotKey identifies the set of records managed together.
+//
+// For most types the whole RRset (name and type) is managed. TXT records are
+// often shared by unrelated services so only the records with the same
+// version tag (e.g. "v=spf1" or "v=DMARC1") are considered to be the same
+// slot.
+func slotKey(rec libdns.Record) string {
+	key := normalizeName(rec.Name) + "|" + strings.ToUpper(rec.Type)
+	if strings.EqualFold(rec.Type, "TXT") {
+		key += "|" + txtTag(rec.Value)
+	}
+	return key
+}
+
+func txtTag(value string) string {
+	value = strings.TrimSpace(strings.Trim(value, `"`))
+	if !strings.HasPrefix(strings.ToLower(value), "v=") {
+		return value
+	}
+	end := strings.IndexAny(value, "; ")
+	if end == -1 {
+		end = len(value)
+	}
+	return strings.ToLower(value[:end])
+}
+
+// normalizeName converts the relative record name to the canonical form.
+// Providers are inconsistent in naming the zone apex, both "" and "@" are
+// used.
+func normalizeName(name string) string {
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if name == "" {
+		return "@"
+	}
+	return name
+}
+
+func normalizeValue(value string) string {
+	value = strings.TrimSpace(strings.Trim(value, `"`))
+	return strings.ToLower(strings.TrimSuffix(value, "."))
+}
+
+func sameRecord(a, b libdns.Record) bool {
+	return strings.EqualFold(a.Type, b.Type) &&
+		normalizeName(a.Name) == normalizeName(b.Name) &&
+		normalizeValue(a.Value) == normalizeValue(b.Value) &&
+		a.Priority == b.Priority &&
+		a.Weight == b.Weight
+}
+
+// ComputePlan compares records in the zone with the desired set. Records in
+// slots not mentioned in desired are left untouched.
+func ComputePlan(zone string, current, desired []libdns.Record) Plan {
+	plan := Plan{Zone: zone}
+
+	currentSlots := make(map[string][]libdns.Record)
+	for _, rec := range current {
+		key := slotKey(rec)
+		currentSlots[key] = append(currentSlots[key], rec)
+	}
+	desiredSlots := make(map[string][]libdns.Record)
+	var keys []string
+	for _, rec := range desired {
+		key := slotKey(rec)
+		if _, ok := desiredSlots[key]; !ok {
+			keys = append(keys, key)
+		}
+		desiredSlots[key] = append(desiredSlots[key], rec)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		existing := currentSlots[key]
+		var missing []libdns.Record
+
+	desiredLoop:
+		for _, want := range desiredSlots[key] {
+			for i, have := range existing {
+				if sameRecord(have, want) {
+					existing = append(existing[:i:i], existing[i+1:]...)
+					continue desiredLoop
+				}
+			}
+			missing = append(missing, want)
+		}
+
+		// Outdated records in the slot are updated in place, the rest is
+		// created or removed.
+		for len(missing) != 0 && len(existing) != 0 {
+			plan.Changes = append(plan.Changes, Change{Action: ActionUpdate, Old: existing[0], New: missing[0]})
+			missing, existing = missing[1:], existing[1:]
+		}
+		for _, rec := range missing {
+			plan.Changes = append(plan.Changes, Change{Action: ActionCreate, New: rec})
+		}
+		for _, rec := range existing {
+			plan.Changes = append(plan.Changes, Change{Action: ActionDelete, Old: rec})
+		}
+	}
+
+	return plan
+}
+
+// Apply executes the plan. Updates use SetRecords if the provider supports
+// it and the record has a provider-specific ID, otherwise the old record is
+// deleted and the new one is created.
+func Apply(ctx context.Context, editor ZoneEditor, plan Plan) error {
+	for _, c := range plan.Changes {
+		var err error
+		switch c.Action {
+		case ActionCreate:
+			_, err = editor.AppendRecords(ctx, plan.Zone, []libdns.Record{c.New})
+		case ActionDelete:
+			_, err = editor.DeleteRecords(ctx, plan.Zone, []libdns.Record{c.Old})
+		case ActionUpdate:
+			if c.Old.ID != "" {
+				rec := c.New
+				rec.ID = c.Old.ID
+				_, err = editor.SetRecords(ctx, plan.Zone, []libdns.Record{rec})
+				if err == nil || !errors.Is(err, ErrNotSupported) {
+					break
+				}
+			}
+			if _, err = editor.DeleteRecords(ctx, plan.Zone, []libdns.Record{c.Old}); err != nil {
+				break
+			}
+			_, err = editor.AppendRecords(ctx, plan.Zone, []libdns.Record{c.New})
+		}
+		if err != nil {
+			return fmt.Errorf("%s: %w", strings.TrimSpace(c.String()), err)
+		}
+	}
+	return nil
+}
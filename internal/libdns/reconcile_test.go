@@ -0,0 +1,153 @@
+package libdns
+
+import (
+	"context"
+	"strconv"
+	"testing"
+
+	"github.com/libdns/libdns"
+)
+
+type memZone struct {
+	recs   []libdns.Record
+	nextID int
+	// noSet makes SetRecords fail as for providers that do not support it.
+	noSet bool
+}
+
+func (z *memZone) GetRecords(_ context.Context, _ string) ([]libdns.Record, error) {
+	return append([]libdns.Record(nil), z.recs...), nil
+}
+
+func (z *memZone) AppendRecords(_ context.Context, _ string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, rec := range recs {
+		z.nextID++
+		rec.ID = strconv.Itoa(z.nextID)
+		z.recs = append(z.recs, rec)
+	}
+	return recs, nil
+}
+
+func (z *memZone) SetRecords(_ context.Context, _ string, recs []libdns.Record) ([]libdns.Record, error) {
+	if z.noSet {
+		return nil, ErrNotSupported
+	}
+	for _, rec := range recs {
+		for i := range z.recs {
+			if z.recs[i].ID == rec.ID {
+				z.recs[i] = rec
+			}
+		}
+	}
+	return recs, nil
+}
+
+func (z *memZone) DeleteRecords(_ context.Context, _ string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, rec := range recs {
+		for i := range z.recs {
+			if z.recs[i].ID == rec.ID {
+				z.recs = append(z.recs[:i], z.recs[i+1:]...)
+				break
+			}
+		}
+	}
+	return recs, nil
+}
+
+func TestComputePlan(t *testing.T) {
+	zone := &memZone{}
+	ctx := context.Background()
+	_, _ = zone.AppendRecords(ctx, "example.org.", []libdns.Record{
+		{Type: "A", Name: "", Value: "192.0.2.1"},
+		{Type: "MX", Name: "@", Value: "mx.example.org.", Priority: 10},
+		{Type: "TXT", Name: "@", Value: "v=spf1 -all"},
+		{Type: "TXT", Name: "@", Value: "google-site-verification=xxx"},
+		{Type: "TXT", Name: "_dmarc", Value: "v=DMARC1; p=none"},
+		{Type: "A", Name: "www", Value: "192.0.2.5"},
+	})
+
+	desired := []libdns.Record{
+		{Type: "A", Name: "@", Value: "192.0.2.2"},
+		{Type: "MX", Name: "@", Value: "mx.example.org", Priority: 10},
+		{Type: "TXT", Name: "@", Value: "v=spf1 mx ~all"},
+		{Type: "TXT", Name: "_dmarc", Value: "v=DMARC1; p=none"},
+		{Type: "TXT", Name: "_mta-sts", Value: "v=STSv1; id=1"},
+	}
+
+	current, _ := zone.GetRecords(ctx, "example.org.")
+	plan := ComputePlan("example.org.", current, desired)
+
+	actions := map[string]int{}
+	for _, c := range plan.Changes {
+		actions[c.Action]++
+		t.Log(c)
+	}
+	if actions[ActionUpdate] != 2 || actions[ActionCreate] != 1 || actions[ActionDelete] != 0 {
+		t.Fatalf("unexpected plan: %v", actions)
+	}
+
+	if err := Apply(ctx, zone, plan); err != nil {
+		t.Fatal(err)
+	}
+
+	current, _ = zone.GetRecords(ctx, "example.org.")
+	if plan := ComputePlan("example.org.", current, desired); len(plan.Changes) != 0 {
+		t.Errorf("zone is not in the desired state after apply: %v", plan.Changes)
+	}
+	if len(current) != 7 {
+		t.Errorf("unrelated records should be preserved, got %v", current)
+	}
+}
+
+func TestApply(t *testing.T) {
+	for _, noSet := range []bool{false, true} {
+		t.Run("noSet="+strconv.FormatBool(noSet), func(t *testing.T) {
+			zone := &memZone{noSet: noSet}
+			ctx := context.Background()
+			_, _ = zone.AppendRecords(ctx, "example.org.", []libdns.Record{
+				{Type: "A", Name: "@", Value: "192.0.2.1"},
+				{Type: "A", Name: "@", Value: "192.0.2.9"},
+				{Type: "MX", Name: "@", Value: "mx.old.example.org.", Priority: 10},
+				{Type: "TXT", Name: "_dmarc", Value: "v=DMARC1; p=none"},
+			})
+
+			desired := []libdns.Record{
+				{Type: "A", Name: "@", Value: "192.0.2.1"},
+				{Type: "MX", Name: "@", Value: "mx.example.org.", Priority: 10},
+				{Type: "TXT", Name: "_dmarc", Value: "v=DMARC1; p=reject"},
+				{Type: "TXT", Name: "_mta-sts", Value: "v=STSv1; id=1"},
+			}
+
+			current, _ := zone.GetRecords(ctx, "example.org.")
+			plan := ComputePlan("example.org.", current, desired)
+			actions := map[string]int{}
+			for _, c := range plan.Changes {
+				actions[c.Action]++
+			}
+			if actions[ActionUpdate] != 2 || actions[ActionCreate] != 1 || actions[ActionDelete] != 1 {
+				t.Fatalf("unexpected plan: %v", actions)
+			}
+
+			if err := Apply(ctx, zone, plan); err != nil {
+				t.Fatal(err)
+			}
+
+			current, _ = zone.GetRecords(ctx, "example.org.")
+			if plan := ComputePlan("example.org.", current, desired); len(plan.Changes) != 0 {
+				t.Errorf("zone is not in the desired state after apply: %v", plan.Changes)
+			}
+			if len(current) != len(desired) {
+				t.Errorf("unexpected zone contents: %v", current)
+			}
+			for _, rec := range current {
+				if rec.Type != "MX" {
+					continue
+				}
+				// Records are updated in place if the provider supports it.
+				if updated := rec.ID == "3"; updated == noSet {
+					t.Errorf("unexpected ID of the updated record: %s", rec.ID)
+				}
+			}
+		})
+	}
+}
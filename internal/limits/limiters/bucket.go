@@ -34,8 +34,8 @@ import (
 // buckets are in active use), Take will return false. Alternatively, in some
 // rare cases, some other (undefined) waiting Take can return false.
 //
-// A BucksetSet without a New function assigned is no-op: Take and TakeContext
-// always succeed and Release does nothing.
+// A BucksetSet without a New or NewKeyed function assigned is no-op: Take and
+// TakeContext always succeed and Release does nothing.
 type BucketSet struct {
 	// New function is used to construct underlying L instances.
 	//
@@ -43,6 +43,11 @@ type BucketSet struct {
 	// goroutine.
 	New func() L
 
+	// NewKeyed is used instead of New if set. It gets the bucket key, which
+	// is necessary for limiters that keep their state externally (see
+	// SharedRate).
+	NewKeyed func(key string) L
+
 	// Time after which bucket is considered stale and can be removed from the
 	// set. For safe use with Rate limiter, it should be at least as twice as
 	// big as Rate refill interval.
@@ -69,6 +74,24 @@ func NewBucketSet(new_ func() L, reapInterval time.Duration, maxBuckets int) *Bu
 	}
 }
 
+// NewKeyedBucketSet is NewBucketSet for limiters that need the bucket key.
+func NewKeyedBucketSet(new_ func(key string) L, reapInterval time.Duration, maxBuckets int) *BucketSet {
+	r := NewBucketSet(nil, reapInterval, maxBuckets)
+	r.NewKeyed = new_
+	return r
+}
+
+func (r *BucketSet) noop() bool {
+	return r.New == nil && r.NewKeyed == nil
+}
+
+func (r *BucketSet) newBucket(key string) L {
+	if r.NewKeyed != nil {
+		return r.NewKeyed(key)
+	}
+	return r.New()
+}
+
 func (r *BucketSet) Close() {
 	r.mLck.Lock()
 	defer r.mLck.Unlock()
@@ -109,7 +132,7 @@ func (r *BucketSet) take(key string) L {
 			r       L
 			lastUse time.Time
 		}{
-			r:       r.New(),
+			r:       r.newBucket(key),
 			lastUse: time.Now(),
 		}
 		bucket = r.m[key]
@@ -120,7 +143,7 @@ func (r *BucketSet) take(key string) L {
 }
 
 func (r *BucketSet) Take(key string) bool {
-	if r.New == nil {
+	if r.noop() {
 		return true
 	}
 
@@ -129,7 +152,7 @@ func (r *BucketSet) Take(key string) bool {
 }
 
 func (r *BucketSet) Release(key string) {
-	if r.New == nil {
+	if r.noop() {
 		return
 	}
 
@@ -144,7 +167,7 @@ func (r *BucketSet) Release(key string) {
 }
 
 func (r *BucketSet) TakeContext(ctx context.Context, key string) error {
-	if r.New == nil {
+	if r.noop() {
 		return nil
 	}
 
@@ -0,0 +1,95 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package limiters
+
+import (
+	"context"
+	"time"
+)
+
+// Store keeps the state of token buckets shared by multiple server
+// instances.
+type Store interface {
+	// Take attempts to take a token from the bucket identified by key.
+	//
+	// The bucket holds up to burst tokens and is refilled at the rate of
+	// burst tokens per interval. If the bucket is empty, Take returns the time
+	// after which the next attempt may succeed.
+	Take(ctx context.Context, key string, burst int, interval time.Duration) (wait time.Duration, err error)
+}
+
+// SharedRate is the Rate limiter that keeps its state in a Store so the limit
+// is enforced across all instances using the same store.
+//
+// If the store fails, the Fallback limiter is used instead so the limit is
+// still enforced, albeit locally.
+//
+// If Burst = 0, all methods are no-op and always succeed.
+type SharedRate struct {
+	Store    Store
+	Key      string
+	Burst    int
+	Interval time.Duration
+	Fallback L
+}
+
+func NewSharedRate(store Store, key string, burstSize int, interval time.Duration) *SharedRate {
+	return &SharedRate{
+		Store:    store,
+		Key:      key,
+		Burst:    burstSize,
+		Interval: interval,
+		Fallback: NewRate(burstSize, interval),
+	}
+}
+
+func (r *SharedRate) Take() bool {
+	return r.TakeContext(context.Background()) == nil
+}
+
+func (r *SharedRate) TakeContext(ctx context.Context) error {
+	if r.Burst == 0 {
+		return nil
+	}
+
+	for {
+		wait, err := r.Store.Take(ctx, r.Key, r.Burst, r.Interval)
+		if err != nil {
+			return r.Fallback.TakeContext(ctx)
+		}
+		if wait <= 0 {
+			return nil
+		}
+
+		t := time.NewTimer(wait)
+		select {
+		case <-t.C:
+		case <-ctx.Done():
+			t.Stop()
+			return ctx.Err()
+		}
+	}
+}
+
+func (r *SharedRate) Release() {
+}
+
+func (r *SharedRate) Close() {
+	r.Fallback.Close()
+}
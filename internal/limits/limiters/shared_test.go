@@ -0,0 +1,81 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package limiters
+
+import (
+	"context"
+	"errors"
+	"testing"
+	"time"
+)
+
+type fakeStore struct {
+	waits []time.Duration
+	err   error
+	calls int
+}
+
+func (s *fakeStore) Take(_ context.Context, _ string, _ int, _ time.Duration) (time.Duration, error) {
+	s.calls++
+	if s.err != nil {
+		return 0, s.err
+	}
+	if len(s.waits) == 0 {
+		return 0, nil
+	}
+	w := s.waits[0]
+	s.waits = s.waits[1:]
+	return w, nil
+}
+
+func TestSharedRate(t *testing.T) {
+	st := &fakeStore{waits: []time.Duration{10 * time.Millisecond, 0}}
+	r := NewSharedRate(st, "k", 1, time.Second)
+	defer r.Close()
+
+	if err := r.TakeContext(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if st.calls != 2 {
+		t.Fatalf("expected 2 store calls, got %d", st.calls)
+	}
+
+	st.waits = []time.Duration{time.Hour}
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.TakeContext(ctx); !errors.Is(err, context.DeadlineExceeded) {
+		t.Fatalf("expected deadline error, got %v", err)
+	}
+}
+
+func TestSharedRate_Fallback(t *testing.T) {
+	st := &fakeStore{err: errors.New("unreachable")}
+	r := NewSharedRate(st, "k", 1, time.Hour)
+	defer r.Close()
+
+	if !r.Take() {
+		t.Fatal("first Take should succeed using the fallback")
+	}
+
+	ctx, cancel := context.WithTimeout(context.Background(), 20*time.Millisecond)
+	defer cancel()
+	if err := r.TakeContext(ctx); err == nil {
+		t.Fatal("fallback limit is not enforced")
+	}
+}
@@ -28,17 +28,28 @@ package limits
 
 import (
 	"context"
+	"errors"
+	"io"
 	"net"
 	"strconv"
+	"sync"
 	"time"
 
 	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
 	"github.com/mail-chat-chain/mailchatd/internal/limits/limiters"
+	"github.com/mail-chat-chain/mailchatd/internal/limits/store"
 )
 
 type Group struct {
 	instName string
+	log      log.Logger
+
+	// store is used to share rate limits between instances, nil if limits
+	// are local.
+	store       limiters.Store
+	storePrefix string
 
 	global limiters.MultiLimit
 	ip     *limiters.BucketSet // BucketSet of MultiLimit
@@ -49,29 +60,62 @@ type Group struct {
 func New(_, instName string, _, _ []string) (module.Module, error) {
 	return &Group{
 		instName: instName,
+		log:      log.Logger{Name: "limits"},
 	}, nil
 }
 
 func (g *Group) Init(cfg *config.Map) error {
 	var (
 		globalL []limiters.L
-		ipL     []func() limiters.L
-		sourceL []func() limiters.L
-		destL   []func() limiters.L
+		ipL     []func(key string) limiters.L
+		sourceL []func(key string) limiters.L
+		destL   []func(key string) limiters.L
 	)
 
+	g.log.Debug = log.DefaultLogger.Debug
+	g.storePrefix = "mailchat:limits:"
+
+	// Store must be known before any limit is constructed, so handle it
+	// first.
 	for _, child := range cfg.Block.Children {
+		switch child.Name {
+		case "store":
+			if g.store != nil {
+				return config.NodeErr(child, "store is already specified")
+			}
+			st, err := store.New(child.Args)
+			if err != nil {
+				return config.NodeErr(child, "%v", err)
+			}
+			g.store = &breakerStore{
+				Store: st,
+				log:   g.log,
+			}
+		case "store_prefix":
+			if len(child.Args) != 1 {
+				return config.NodeErr(child, "exactly one argument is required")
+			}
+			g.storePrefix = child.Args[0]
+		}
+	}
+
+	for _, child := range cfg.Block.Children {
+		switch child.Name {
+		case "store", "store_prefix":
+			continue
+		}
+
 		if len(child.Args) < 1 {
 			return config.NodeErr(child, "at least two arguments are required")
 		}
 
 		var (
-			ctor func() limiters.L
+			ctor func(key string) limiters.L
 			err  error
 		)
 		switch kind := child.Args[0]; kind {
 		case "rate":
-			ctor, err = rateCtor(child, child.Args[1:])
+			ctor, err = g.rateCtor(child, child.Name, child.Args[1:])
 		case "concurrency":
 			ctor, err = concurrencyCtor(child, child.Args[1:])
 		default:
@@ -83,7 +127,7 @@ func (g *Group) Init(cfg *config.Map) error {
 
 		switch scope := child.Name; scope {
 		case "all":
-			globalL = append(globalL, ctor())
+			globalL = append(globalL, ctor(""))
 		case "ip":
 			ipL = append(ipL, ctor)
 		case "source":
@@ -99,37 +143,29 @@ func (g *Group) Init(cfg *config.Map) error {
 	// endpoint/smtp.
 	g.global = limiters.MultiLimit{Wrapped: globalL}
 	if len(ipL) != 0 {
-		g.ip = limiters.NewBucketSet(func() limiters.L {
-			l := make([]limiters.L, 0, len(ipL))
-			for _, ctor := range ipL {
-				l = append(l, ctor())
-			}
-			return &limiters.MultiLimit{Wrapped: l}
-		}, 1*time.Minute, 20010)
+		g.ip = limiters.NewKeyedBucketSet(multiCtor(ipL), 1*time.Minute, 20010)
 	}
 	if len(sourceL) != 0 {
-		g.source = limiters.NewBucketSet(func() limiters.L {
-			l := make([]limiters.L, 0, len(sourceL))
-			for _, ctor := range sourceL {
-				l = append(l, ctor())
-			}
-			return &limiters.MultiLimit{Wrapped: l}
-		}, 1*time.Minute, 20010)
+		g.source = limiters.NewKeyedBucketSet(multiCtor(sourceL), 1*time.Minute, 20010)
 	}
 	if len(destL) != 0 {
-		g.dest = limiters.NewBucketSet(func() limiters.L {
-			l := make([]limiters.L, 0, len(sourceL))
-			for _, ctor := range sourceL {
-				l = append(l, ctor())
-			}
-			return &limiters.MultiLimit{Wrapped: l}
-		}, 1*time.Minute, 20010)
+		g.dest = limiters.NewKeyedBucketSet(multiCtor(destL), 1*time.Minute, 20010)
 	}
 
 	return nil
 }
 
-func rateCtor(node config.Node, args []string) (func() limiters.L, error) {
+func multiCtor(ctors []func(key string) limiters.L) func(key string) limiters.L {
+	return func(key string) limiters.L {
+		l := make([]limiters.L, 0, len(ctors))
+		for _, ctor := range ctors {
+			l = append(l, ctor(key))
+		}
+		return &limiters.MultiLimit{Wrapped: l}
+	}
+}
+
+func (g *Group) rateCtor(node config.Node, scope string, args []string) (func(key string) limiters.L, error) {
 	period := 1 * time.Second
 	burst := 0
 
@@ -153,12 +189,23 @@ func rateCtor(node config.Node, args []string) (func() limiters.L, error) {
 		return nil, config.NodeErr(node, "too many arguments")
 	}
 
-	return func() limiters.L {
-		return limiters.NewRate(burst, period)
+	if g.store == nil {
+		return func(string) limiters.L {
+			return limiters.NewRate(burst, period)
+		}, nil
+	}
+
+	// Burst size and period are included in the key so instances with
+	// different configuration do not share the bucket state.
+	prefix := g.storePrefix + scope + ":" + strconv.Itoa(burst) + "/" + period.String() + ":"
+	return func(key string) limiters.L {
+		return limiters.NewSharedRate(g.store, prefix+key, burst, period)
 	}, nil
 }
 
-func concurrencyCtor(node config.Node, args []string) (func() limiters.L, error) {
+// concurrencyCtor creates the Semaphore constructor. Concurrency limits are
+// always local to the instance.
+func concurrencyCtor(node config.Node, args []string) (func(key string) limiters.L, error) {
 	if len(args) != 1 {
 		return nil, config.NodeErr(node, "max concurrency value is needed")
 	}
@@ -166,7 +213,7 @@ func concurrencyCtor(node config.Node, args []string) (func() limiters.L, error)
 	if err != nil {
 		return nil, config.NodeErr(node, "%v", err)
 	}
-	return func() limiters.L {
+	return func(string) limiters.L {
 		return limiters.NewSemaphore(max)
 	}, nil
 }
@@ -221,6 +268,21 @@ func (g *Group) ReleaseDest(domain string) {
 	g.dest.Release(domain)
 }
 
+func (g *Group) Close() error {
+	g.global.Close()
+	for _, set := range []*limiters.BucketSet{g.ip, g.source, g.dest} {
+		if set != nil {
+			set.Close()
+		}
+	}
+	if g.store != nil {
+		if c, ok := g.store.(io.Closer); ok {
+			return c.Close()
+		}
+	}
+	return nil
+}
+
 func (g *Group) Name() string {
 	return "limits"
 }
@@ -229,6 +291,51 @@ func (g *Group) InstanceName() string {
 	return g.instName
 }
 
+// breakerDowntime is the time store is not used for after a failure.
+const breakerDowntime = 10 * time.Second
+
+var errStoreDown = errors.New("limits: store is temporary disabled after failure")
+
+// breakerStore stops using the wrapped Store for some time after a failure
+// so the unreachable store does not slow down each message. Limiters fall
+// back to the local state meanwhile.
+type breakerStore struct {
+	limiters.Store
+	log log.Logger
+
+	mu        sync.Mutex
+	downUntil time.Time
+}
+
+func (b *breakerStore) Take(ctx context.Context, key string, burst int, interval time.Duration) (time.Duration, error) {
+	b.mu.Lock()
+	down := time.Now().Before(b.downUntil)
+	b.mu.Unlock()
+	if down {
+		return 0, errStoreDown
+	}
+
+	wait, err := b.Store.Take(ctx, key, burst, interval)
+	if err != nil {
+		if errors.Is(err, context.Canceled) || errors.Is(err, context.DeadlineExceeded) {
+			return 0, err
+		}
+		b.mu.Lock()
+		b.downUntil = time.Now().Add(breakerDowntime)
+		b.mu.Unlock()
+		b.log.Error("store failed, falling back to local limits", err, "downtime", breakerDowntime.String())
+		return 0, err
+	}
+	return wait, nil
+}
+
+func (b *breakerStore) Close() error {
+	if c, ok := b.Store.(io.Closer); ok {
+		return c.Close()
+	}
+	return nil
+}
+
 func init() {
 	module.Register("limits", New)
 }
@@ -0,0 +1,67 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package limits
+
+import (
+	"context"
+	"net"
+	"testing"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+)
+
+func TestDestinationLimits(t *testing.T) {
+	mod, err := New("", "test", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	g := mod.(*Group)
+	err = g.Init(config.NewMap(nil, config.Node{
+		Children: []config.Node{
+			{Name: "source", Args: []string{"concurrency", "5"}},
+			{Name: "destination", Args: []string{"concurrency", "1"}},
+		},
+	}))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer g.Close()
+
+	ip := net.IPv4(192, 0, 2, 1)
+	for i := 0; i < 2; i++ {
+		if err := g.TakeMsg(context.Background(), ip, "example.org"); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	// Destination buckets use the destination limits, not the source ones.
+	if err := g.TakeDest(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), 50*time.Millisecond)
+	defer cancel()
+	if err := g.TakeDest(ctx, "example.com"); err == nil {
+		t.Fatal("destination concurrency limit is not applied")
+	}
+	g.ReleaseDest("example.com")
+	if err := g.TakeDest(context.Background(), "example.com"); err != nil {
+		t.Fatal(err)
+	}
+}
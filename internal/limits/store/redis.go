@@ -0,0 +1,316 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package store
+
+import (
+	"bufio"
+	"context"
+	"crypto/tls"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// tokenBucketScript implements the token bucket on the Redis side so the
+// read-modify-write cycle is atomic. Redis server time is used to not depend
+// on clock synchronization between instances.
+//
+// It returns the time to wait (in milliseconds) before the token can be
+// taken, 0 means the token was taken.
+const tokenBucketScript = `
+local burst = tonumber(ARGV[1])
+local interval = tonumber(ARGV[2])
+local t = redis.call('TIME')
+local now = tonumber(t[1]) * 1000 + math.floor(tonumber(t[2]) / 1000)
+local st = redis.call('HMGET', KEYS[1], 't', 'u')
+local tokens = tonumber(st[1])
+local updated = tonumber(st[2])
+if tokens == nil or updated == nil then
+	tokens = burst
+	updated = now
+end
+tokens = math.min(burst, tokens + (now - updated) * burst / interval)
+local wait = 0
+if tokens >= 1 then
+	tokens = tokens - 1
+else
+	wait = math.ceil((1 - tokens) * interval / burst)
+end
+redis.call('HSET', KEYS[1], 't', tostring(tokens), 'u', tostring(now))
+redis.call('PEXPIRE', KEYS[1], interval * 2)
+return wait
+`
+
+const redisMaxIdle = 4
+
+// Redis is the Store implementation using a Redis server.
+//
+// Only a minimal subset of RESP necessary to run the token bucket script is
+// implemented.
+type Redis struct {
+	addr     string
+	useTLS   bool
+	tlsCfg   *tls.Config
+	username string
+	password string
+	db       int
+	timeout  time.Duration
+
+	idle chan *redisConn
+}
+
+type redisConn struct {
+	c net.Conn
+	r *bufio.Reader
+}
+
+// NewRedis creates the Redis store using the redis:// or rediss:// URL.
+//
+// The connection is established lazily.
+func NewRedis(rawURL string) (*Redis, error) {
+	u, err := url.Parse(rawURL)
+	if err != nil {
+		return nil, fmt.Errorf("store: redis: %w", err)
+	}
+
+	r := &Redis{
+		timeout: 2 * time.Second,
+		idle:    make(chan *redisConn, redisMaxIdle),
+	}
+	switch u.Scheme {
+	case "redis":
+	case "rediss":
+		r.useTLS = true
+		r.tlsCfg = &tls.Config{ServerName: u.Hostname()}
+	default:
+		return nil, fmt.Errorf("store: redis: unsupported URL scheme: %v", u.Scheme)
+	}
+
+	r.addr = u.Host
+	if u.Port() == "" {
+		r.addr = net.JoinHostPort(u.Hostname(), "6379")
+	}
+	if u.User != nil {
+		r.password, _ = u.User.Password()
+		r.username = u.User.Username()
+		if _, ok := u.User.Password(); !ok {
+			// redis://secret@host is commonly used to specify only the password.
+			r.password = r.username
+			r.username = ""
+		}
+	}
+	if db := strings.TrimPrefix(u.Path, "/"); db != "" {
+		r.db, err = strconv.Atoi(db)
+		if err != nil {
+			return nil, fmt.Errorf("store: redis: invalid database number: %v", db)
+		}
+	}
+
+	return r, nil
+}
+
+func (r *Redis) dial(ctx context.Context) (*redisConn, error) {
+	var (
+		c   net.Conn
+		err error
+	)
+	d := net.Dialer{Timeout: r.timeout}
+	if r.useTLS {
+		td := tls.Dialer{NetDialer: &d, Config: r.tlsCfg}
+		c, err = td.DialContext(ctx, "tcp", r.addr)
+	} else {
+		c, err = d.DialContext(ctx, "tcp", r.addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &redisConn{c: c, r: bufio.NewReader(c)}
+	if r.password != "" {
+		args := []string{"AUTH", r.password}
+		if r.username != "" {
+			args = []string{"AUTH", r.username, r.password}
+		}
+		if _, err := conn.do(ctx, r.timeout, args...); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	if r.db != 0 {
+		if _, err := conn.do(ctx, r.timeout, "SELECT", strconv.Itoa(r.db)); err != nil {
+			c.Close()
+			return nil, err
+		}
+	}
+	return conn, nil
+}
+
+func (r *Redis) get(ctx context.Context) (*redisConn, error) {
+	select {
+	case c := <-r.idle:
+		return c, nil
+	default:
+		return r.dial(ctx)
+	}
+}
+
+func (r *Redis) put(c *redisConn) {
+	select {
+	case r.idle <- c:
+	default:
+		c.c.Close()
+	}
+}
+
+func (r *Redis) Take(ctx context.Context, key string, burst int, interval time.Duration) (time.Duration, error) {
+	conn, err := r.get(ctx)
+	if err != nil {
+		return 0, fmt.Errorf("store: redis: %w", err)
+	}
+
+	reply, err := conn.do(ctx, r.timeout, "EVAL", tokenBucketScript, "1", key,
+		strconv.Itoa(burst), strconv.FormatInt(interval.Milliseconds(), 10))
+	if err != nil {
+		var rerr redisError
+		if errors.As(err, &rerr) {
+			// Connection is still usable after the server-side error.
+			r.put(conn)
+		} else {
+			conn.c.Close()
+		}
+		return 0, fmt.Errorf("store: redis: %w", err)
+	}
+	r.put(conn)
+
+	waitMs, ok := reply.(int64)
+	if !ok {
+		return 0, fmt.Errorf("store: redis: unexpected script reply: %v", reply)
+	}
+	return time.Duration(waitMs) * time.Millisecond, nil
+}
+
+func (r *Redis) Close() error {
+	for {
+		select {
+		case c := <-r.idle:
+			c.c.Close()
+		default:
+			return nil
+		}
+	}
+}
+
+type redisError string
+
+func (e redisError) Error() string {
+	return string(e)
+}
+
+func (c *redisConn) do(ctx context.Context, timeout time.Duration, args ...string) (interface{}, error) {
+	deadline := time.Now().Add(timeout)
+	if ctxDeadline, ok := ctx.Deadline(); ok && ctxDeadline.Before(deadline) {
+		deadline = ctxDeadline
+	}
+	if err := c.c.SetDeadline(deadline); err != nil {
+		return nil, err
+	}
+
+	var b strings.Builder
+	fmt.Fprintf(&b, "*%d\r\n", len(args))
+	for _, a := range args {
+		fmt.Fprintf(&b, "$%d\r\n%s\r\n", len(a), a)
+	}
+	if _, err := io.WriteString(c.c, b.String()); err != nil {
+		return nil, err
+	}
+
+	return c.readReply()
+}
+
+func (c *redisConn) readLine() (string, error) {
+	line, err := c.r.ReadString('\n')
+	if err != nil {
+		return "", err
+	}
+	if !strings.HasSuffix(line, "\r\n") {
+		return "", errors.New("malformed reply")
+	}
+	return line[:len(line)-2], nil
+}
+
+func (c *redisConn) readReply() (interface{}, error) {
+	line, err := c.readLine()
+	if err != nil {
+		return nil, err
+	}
+	if line == "" {
+		return nil, errors.New("malformed reply")
+	}
+
+	switch line[0] {
+	case '+':
+		return line[1:], nil
+	case '-':
+		return nil, redisError(line[1:])
+	case ':':
+		return strconv.ParseInt(line[1:], 10, 64)
+	case '$':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		buf := make([]byte, n+2)
+		if _, err := io.ReadFull(c.r, buf); err != nil {
+			return nil, err
+		}
+		return string(buf[:n]), nil
+	case '*':
+		n, err := strconv.Atoi(line[1:])
+		if err != nil {
+			return nil, err
+		}
+		if n < 0 {
+			return nil, nil
+		}
+		res := make([]interface{}, 0, n)
+		for i := 0; i < n; i++ {
+			v, err := c.readReply()
+			if err != nil {
+				var rerr redisError
+				if !errors.As(err, &rerr) {
+					return nil, err
+				}
+				// Keep reading so the connection stays in sync.
+				v = rerr
+			}
+			res = append(res, v)
+		}
+		return res, nil
+	default:
+		return nil, fmt.Errorf("unexpected reply type: %q", line[0])
+	}
+}
@@ -0,0 +1,191 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package store
+
+import (
+	"context"
+	"database/sql"
+	"errors"
+	"fmt"
+	"math"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"time"
+
+	_ "github.com/go-sql-driver/mysql"
+	_ "github.com/lib/pq"
+	_ "github.com/mattn/go-sqlite3"
+)
+
+const (
+	sqlTable       = "limits_buckets"
+	sqlMaxRetries  = 5
+	sqlCleanupFreq = time.Minute
+)
+
+// SQL is the Store implementation using an SQL database.
+//
+// Concurrent updates from multiple instances are resolved using the
+// optimistic locking on the version column. Clocks of instances sharing the
+// database are assumed to be reasonably synchronized.
+type SQL struct {
+	db     *sql.DB
+	driver string
+
+	lastCleanup atomic.Int64
+}
+
+// NewSQL opens the database and creates the buckets table if it does not
+// exist.
+//
+// Only postgres, mysql and sqlite3 drivers are supported.
+func NewSQL(driver, dsn string) (*SQL, error) {
+	switch driver {
+	case "postgres", "mysql", "sqlite3":
+	default:
+		return nil, fmt.Errorf("store: sql: unsupported driver: %v", driver)
+	}
+
+	db, err := sql.Open(driver, dsn)
+	if err != nil {
+		return nil, fmt.Errorf("store: sql: %w", err)
+	}
+
+	s := &SQL{db: db, driver: driver}
+	_, err = db.Exec(`CREATE TABLE IF NOT EXISTS ` + sqlTable + ` (
+		bucket VARCHAR(255) NOT NULL PRIMARY KEY,
+		tokens DOUBLE PRECISION NOT NULL,
+		updated BIGINT NOT NULL,
+		version BIGINT NOT NULL,
+		expires BIGINT NOT NULL
+	)`)
+	if err != nil {
+		db.Close()
+		return nil, fmt.Errorf("store: sql: %w", err)
+	}
+	return s, nil
+}
+
+// query rewrites ? placeholders into the form expected by the driver.
+func (s *SQL) query(q string) string {
+	if s.driver != "postgres" {
+		return q
+	}
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, ch := range q {
+		if ch == '?' {
+			n++
+			b.WriteString("$" + strconv.Itoa(n))
+			continue
+		}
+		b.WriteRune(ch)
+	}
+	return b.String()
+}
+
+func (s *SQL) Take(ctx context.Context, key string, burst int, interval time.Duration) (time.Duration, error) {
+	now := time.Now()
+	s.cleanup(ctx, now)
+
+	for i := 0; i < sqlMaxRetries; i++ {
+		wait, ok, err := s.tryTake(ctx, key, burst, interval, now)
+		if err != nil {
+			return 0, fmt.Errorf("store: sql: %w", err)
+		}
+		if ok {
+			return wait, nil
+		}
+		now = time.Now()
+	}
+	return 0, fmt.Errorf("store: sql: too many concurrent updates for %v", key)
+}
+
+// tryTake runs one read-modify-write cycle. ok = false is returned if the
+// bucket was modified concurrently.
+func (s *SQL) tryTake(ctx context.Context, key string, burst int, interval time.Duration, now time.Time) (wait time.Duration, ok bool, err error) {
+	nowMs := now.UnixMilli()
+	expires := nowMs + 2*interval.Milliseconds()
+
+	var (
+		tokens  float64
+		updated int64
+		version int64
+	)
+	err = s.db.QueryRowContext(ctx, s.query(`SELECT tokens, updated, version FROM `+sqlTable+` WHERE bucket = ?`), key).
+		Scan(&tokens, &updated, &version)
+	if errors.Is(err, sql.ErrNoRows) {
+		_, err := s.db.ExecContext(ctx, s.query(`INSERT INTO `+sqlTable+` (bucket, tokens, updated, version, expires) VALUES (?, ?, ?, ?, ?)`),
+			key, float64(burst-1), nowMs, 1, expires)
+		if err != nil {
+			// Most likely, the row was inserted concurrently, let the caller
+			// retry. Persistent errors will be reported by the SELECT.
+			return 0, false, nil
+		}
+		return 0, true, nil
+	}
+	if err != nil {
+		return 0, false, err
+	}
+
+	// Bucket expired and was not cleaned up yet.
+	if nowMs-updated > 2*interval.Milliseconds() || updated > nowMs {
+		tokens = float64(burst)
+		updated = nowMs
+	}
+	tokens = math.Min(float64(burst), tokens+float64(nowMs-updated)*float64(burst)/float64(interval.Milliseconds()))
+	if tokens >= 1 {
+		tokens--
+	} else {
+		waitMs := math.Ceil((1 - tokens) * float64(interval.Milliseconds()) / float64(burst))
+		wait = time.Duration(waitMs) * time.Millisecond
+	}
+
+	res, err := s.db.ExecContext(ctx, s.query(`UPDATE `+sqlTable+` SET tokens = ?, updated = ?, version = ?, expires = ? WHERE bucket = ? AND version = ?`),
+		tokens, nowMs, version+1, expires, key, version)
+	if err != nil {
+		return 0, false, err
+	}
+	affected, err := res.RowsAffected()
+	if err != nil {
+		return 0, false, err
+	}
+	return wait, affected == 1, nil
+}
+
+// cleanup removes expired buckets, at most once per sqlCleanupFreq.
+func (s *SQL) cleanup(ctx context.Context, now time.Time) {
+	last := s.lastCleanup.Load()
+	if now.UnixMilli()-last < sqlCleanupFreq.Milliseconds() {
+		return
+	}
+	if !s.lastCleanup.CompareAndSwap(last, now.UnixMilli()) {
+		return
+	}
+	// Errors there are not important, they will show up in the actual
+	// queries if persistent.
+	_, _ = s.db.ExecContext(ctx, s.query(`DELETE FROM `+sqlTable+` WHERE expires < ?`), now.UnixMilli())
+}
+
+func (s *SQL) Close() error {
+	return s.db.Close()
+}
@@ -0,0 +1,52 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package store provides implementations of limiters.Store that allow
+// rate limits to be shared between multiple server instances.
+package store
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/mail-chat-chain/mailchatd/internal/limits/limiters"
+)
+
+// New creates the Store using the arguments of the 'store' directive.
+//
+//	store redis URL
+//	store sql DRIVER DSN...
+func New(args []string) (limiters.Store, error) {
+	if len(args) == 0 {
+		return nil, fmt.Errorf("store: backend type is required")
+	}
+	switch kind := args[0]; kind {
+	case "redis":
+		if len(args) != 2 {
+			return nil, fmt.Errorf("store: redis: exactly one URL argument is required")
+		}
+		return NewRedis(args[1])
+	case "sql":
+		if len(args) < 3 {
+			return nil, fmt.Errorf("store: sql: driver and DSN are required")
+		}
+		return NewSQL(args[1], strings.Join(args[2:], " "))
+	default:
+		return nil, fmt.Errorf("store: unknown backend: %v", kind)
+	}
+}
@@ -2,39 +2,107 @@ package modify
 
 import (
 	"context"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
 
 	"github.com/emersion/go-message/textproto"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
 	"github.com/mail-chat-chain/mailchatd/framework/buffer"
 	"github.com/mail-chat-chain/mailchatd/framework/config"
 	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target"
 )
 
 const (
 	blockchainRawTxMailHeader = "X-Blockchain-Tx"
 	blockchainTypeHeader      = "X-Blockchain-Type"
+	blockchainTxResultHeader  = "X-Blockchain-Tx-Result"
 )
 
+// Values of the broadcast_failure directive.
+const (
+	txFailureIgnore   = "ignore"
+	txFailureTempfail = "tempfail"
+	txFailureReject   = "reject"
+)
+
+// Values of the status parameter of X-Blockchain-Tx-Result.
+const (
+	txStatusSuccess  = "success"
+	txStatusReverted = "reverted"
+	txStatusPending  = "pending"
+	txStatusRejected = "rejected"
+	txStatusInvalid  = "invalid"
+)
+
+// blockchainTxSender broadcasts the raw transaction attached to the message
+// in the X-Blockchain-Tx header field and replaces it with the
+// X-Blockchain-Tx-Result field describing the outcome.
+//
+// The transaction should be signed for the chain ID of the configured
+// blockchain by the EVM address of the authenticated user (the local part of
+// the username), unless require_signer is disabled.
 type blockchainTxSender struct {
 	modName    string
 	instName   string
 	inlineArgs []string
+	log        log.Logger
 
-	chain module.BlockChain
+	chain          module.BlockChain
+	requireSigner  bool
+	receiptTimeout time.Duration
+	failurePolicy  string
+	pollInterval   time.Duration
 }
 
 func NewBlockchainTxSender(modName, instName string, _, inlineArgs []string) (module.Module, error) {
 	b := blockchainTxSender{
-		modName:    modName,
-		instName:   instName,
-		inlineArgs: inlineArgs,
+		modName:      modName,
+		instName:     instName,
+		inlineArgs:   inlineArgs,
+		log:          log.Logger{Name: modName},
+		pollInterval: time.Second,
 	}
 
 	return &b, nil
 }
 
 func (b *blockchainTxSender) Init(cfg *config.Map) error {
-	err := modconfig.ModuleFromNode("blockchain_tx", b.inlineArgs, cfg.Block, cfg.Globals, &b.chain)
+	switch {
+	case len(b.inlineArgs) == 0:
+		cfg.Custom("blockchain", false, true, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+			var chain module.BlockChain
+			err := modconfig.ModuleFromNode("blockchain", node.Args, node, m.Globals, &chain)
+			return chain, err
+		}, &b.chain)
+	case strings.HasPrefix(b.inlineArgs[0], "&"):
+		if err := modconfig.ModuleFromNode("blockchain", b.inlineArgs, config.Node{}, cfg.Globals, &b.chain); err != nil {
+			return err
+		}
+	default:
+		// Inline blockchain definition, the block configures the blockchain
+		// module and defaults are used for everything else.
+		err := modconfig.ModuleFromNode("blockchain", b.inlineArgs, cfg.Block, cfg.Globals, &b.chain)
+		b.requireSigner = true
+		b.receiptTimeout = 15 * time.Second
+		b.failurePolicy = txFailureIgnore
+		return err
+	}
+
+	cfg.Bool("debug", true, false, &b.log.Debug)
+	cfg.Bool("require_signer", false, true, &b.requireSigner)
+	cfg.Duration("receipt_timeout", false, false, 15*time.Second, &b.receiptTimeout)
+	cfg.Enum("broadcast_failure", false, false,
+		[]string{txFailureIgnore, txFailureTempfail, txFailureReject}, txFailureIgnore, &b.failurePolicy)
+	_, err := cfg.Process()
 	return err
 }
 
@@ -46,31 +114,177 @@ func (b *blockchainTxSender) InstanceName() string {
 	return b.instName
 }
 
+type blockchainTxState struct {
+	b       *blockchainTxSender
+	msgMeta *module.MsgMetadata
+	log     log.Logger
+}
+
 func (b *blockchainTxSender) ModStateForMsg(ctx context.Context, msgMeta *module.MsgMetadata) (module.ModifierState, error) {
-	return b, nil
+	return &blockchainTxState{
+		b:       b,
+		msgMeta: msgMeta,
+		log:     target.DeliveryLogger(b.log, msgMeta),
+	}, nil
 }
 
-func (b *blockchainTxSender) RewriteSender(ctx context.Context, mailFrom string) (string, error) {
+func (s *blockchainTxState) RewriteSender(ctx context.Context, mailFrom string) (string, error) {
 	return mailFrom, nil
 }
 
-func (b *blockchainTxSender) RewriteRcpt(ctx context.Context, rcptTo string) ([]string, error) {
+func (s *blockchainTxState) RewriteRcpt(ctx context.Context, rcptTo string) ([]string, error) {
 	return []string{rcptTo}, nil
 }
 
-func (b *blockchainTxSender) RewriteBody(ctx context.Context, h *textproto.Header, body buffer.Buffer) error {
-	c, ok := b.chain.(module.BlockChain)
-	if !ok {
+// txResult is the value of the X-Blockchain-Tx-Result header field.
+type txResult struct {
+	hash   string
+	status string
+	block  uint64
+	err    error
+}
+
+func (r txResult) String() string {
+	var sb strings.Builder
+	if r.hash != "" {
+		sb.WriteString(r.hash)
+		sb.WriteString("; ")
+	}
+	sb.WriteString("status=")
+	sb.WriteString(r.status)
+	if r.block != 0 {
+		sb.WriteString("; block=")
+		sb.WriteString(strconv.FormatUint(r.block, 10))
+	}
+	if r.err != nil {
+		sb.WriteString(`; reason="`)
+		sb.WriteString(strings.ReplaceAll(r.err.Error(), `"`, `'`))
+		sb.WriteString(`"`)
+	}
+	return sb.String()
+}
+
+func (s *blockchainTxState) RewriteBody(ctx context.Context, h *textproto.Header, body buffer.Buffer) error {
+	// The result is never accepted from the client.
+	h.Del(blockchainTxResultHeader)
+
+	rawTx := h.Get(blockchainRawTxMailHeader)
+	if rawTx == "" || s.b.chain.ChainType(ctx) != h.Get(blockchainTypeHeader) {
+		return nil
+	}
+	h.Del(blockchainRawTxMailHeader)
+	h.Del(blockchainTypeHeader)
+
+	res := s.process(ctx, rawTx)
+	h.Add(blockchainTxResultHeader, res.String())
+	if res.err == nil {
+		s.log.Msg("transaction processed", "tx", res.hash, "status", res.status, "block", res.block)
+		return nil
+	}
+
+	s.log.Error("transaction not broadcasted", res.err, "tx", res.hash, "status", res.status)
+	return s.b.failure(res)
+}
+
+// failure converts the failed result into the error according to the
+// broadcast_failure policy.
+func (b *blockchainTxSender) failure(res txResult) error {
+	if b.failurePolicy == txFailureIgnore {
 		return nil
 	}
-	if c.ChainType(ctx) == h.Get(blockchainTypeHeader) && h.Get(blockchainRawTxMailHeader) != "" {
-		err := c.SendRawTx(ctx, h.Get(blockchainRawTxMailHeader))
-		if err == nil {
-			h.Del(blockchainRawTxMailHeader)
-			h.Del(blockchainTypeHeader)
+	// Retrying an invalid transaction will not help.
+	if b.failurePolicy == txFailureTempfail && res.status != txStatusInvalid {
+		return &exterrors.SMTPError{
+			Code:         451,
+			EnhancedCode: exterrors.EnhancedCode{4, 7, 0},
+			Message:      "Unable to broadcast the attached transaction, try again later",
+			ModifierName: b.modName,
+			Err:          res.err,
 		}
-		return err
 	}
+	return &exterrors.SMTPError{
+		Code:         550,
+		EnhancedCode: exterrors.EnhancedCode{5, 7, 0},
+		Message:      "The attached transaction is rejected",
+		ModifierName: b.modName,
+		Err:          res.err,
+	}
+}
+
+// authAddress returns the EVM address of the authenticated user.
+func (s *blockchainTxState) authAddress() (common.Address, bool) {
+	if s.msgMeta.Conn == nil || s.msgMeta.Conn.AuthUser == "" {
+		return common.Address{}, false
+	}
+	local, _, _ := strings.Cut(s.msgMeta.Conn.AuthUser, "@")
+	if !common.IsHexAddress(local) {
+		return common.Address{}, false
+	}
+	return common.HexToAddress(local), true
+}
+
+func (s *blockchainTxState) process(ctx context.Context, rawTx string) txResult {
+	raw, err := hexutil.Decode(strings.TrimSpace(rawTx))
+	if err != nil {
+		return txResult{status: txStatusInvalid, err: fmt.Errorf("malformed transaction: %w", err)}
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return txResult{status: txStatusInvalid, err: fmt.Errorf("malformed transaction: %w", err)}
+	}
+	res := txResult{hash: tx.Hash().Hex()}
+
+	chainID, err := s.b.chain.ChainID(ctx)
+	if err != nil {
+		res.status, res.err = txStatusRejected, fmt.Errorf("chain ID: %w", err)
+		return res
+	}
+	if tx.ChainId().Cmp(chainID) != 0 {
+		res.status, res.err = txStatusInvalid, fmt.Errorf("transaction is for chain ID %v, expected %v", tx.ChainId(), chainID)
+		return res
+	}
+	signer, err := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		res.status, res.err = txStatusInvalid, fmt.Errorf("invalid signature: %w", err)
+		return res
+	}
+	if s.b.requireSigner {
+		expected, ok := s.authAddress()
+		if !ok {
+			res.status, res.err = txStatusInvalid, errors.New("sender is not authenticated with an EVM address")
+			return res
+		}
+		if signer != expected {
+			res.status, res.err = txStatusInvalid, fmt.Errorf("transaction is signed by %s, not by the sender", signer.Hex())
+			return res
+		}
+	}
+
+	if err := s.b.chain.SendRawTx(ctx, hexutil.Encode(raw)); err != nil {
+		res.status, res.err = txStatusRejected, err
+		return res
+	}
+
+	res.status = txStatusPending
+	if s.b.receiptTimeout == 0 {
+		return res
+	}
+	receipt, err := module.WaitReceipt(ctx, s.b.chain, res.hash, s.b.receiptTimeout, s.b.pollInterval)
+	if err != nil {
+		if !errors.Is(err, module.ErrTxPending) {
+			s.log.Error("failed to get transaction receipt", err, "tx", res.hash)
+		}
+		return res
+	}
+	res.status = txStatusReverted
+	if receipt.Success {
+		res.status = txStatusSuccess
+	}
+	res.block = receipt.BlockNumber
+	return res
+}
+
+func (s *blockchainTxState) Close() error {
 	return nil
 }
 
@@ -0,0 +1,224 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package modify
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+var testChainID = big.NewInt(26000)
+
+type testChain struct {
+	sendErr  error
+	sent     []string
+	receipts map[string]*module.TxReceipt
+}
+
+func (c *testChain) SendRawTx(_ context.Context, rawTx string) error {
+	if c.sendErr != nil {
+		return c.sendErr
+	}
+	c.sent = append(c.sent, rawTx)
+	return nil
+}
+
+func (c *testChain) ChainType(context.Context) string { return "ethereum" }
+
+func (c *testChain) CheckSign(context.Context, string, string, string) (bool, error) {
+	return false, nil
+}
+
+func (c *testChain) ChainID(context.Context) (*big.Int, error) { return testChainID, nil }
+
+func (c *testChain) CallContract(context.Context, string, string) (string, error) {
+	return "0x", nil
+}
+
+func (c *testChain) PendingNonce(context.Context, string) (uint64, error) { return 0, nil }
+
+func (c *testChain) SuggestGasPrice(context.Context) (*big.Int, error) { return big.NewInt(1), nil }
+
+func (c *testChain) TransactionReceipt(_ context.Context, hash string) (*module.TxReceipt, error) {
+	r, ok := c.receipts[hash]
+	if !ok {
+		return nil, module.ErrTxPending
+	}
+	return r, nil
+}
+
+func signedTx(t *testing.T, key *ecdsa.PrivateKey, chainID *big.Int) (string, string) {
+	t.Helper()
+	to := common.HexToAddress("0x1111111111111111111111111111111111111111")
+	tx, err := types.SignNewTx(key, types.LatestSignerForChainID(chainID), &types.LegacyTx{
+		GasPrice: big.NewInt(1),
+		Gas:      21000,
+		To:       &to,
+		Value:    big.NewInt(1),
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hexutil.Encode(raw), tx.Hash().Hex()
+}
+
+func rewriteTx(t *testing.T, b *blockchainTxSender, authUser, rawTx string) (textproto.Header, error) {
+	t.Helper()
+	state, err := b.ModStateForMsg(context.Background(), &module.MsgMetadata{
+		ID:   "msg",
+		Conn: &module.ConnState{AuthUser: authUser},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var h textproto.Header
+	h.Add(blockchainRawTxMailHeader, rawTx)
+	h.Add(blockchainTypeHeader, "ethereum")
+	h.Add(blockchainTxResultHeader, "0xspoofed; status=success")
+	err = state.RewriteBody(context.Background(), &h, buffer.MemoryBuffer{})
+	return h, err
+}
+
+func TestBlockchainTx(t *testing.T) {
+	key, _ := crypto.GenerateKey()
+	user := strings.ToLower(crypto.PubkeyToAddress(key.PublicKey).Hex()) + "@example.org"
+	rawTx, hash := signedTx(t, key, testChainID)
+
+	newSender := func(chain *testChain) *blockchainTxSender {
+		return &blockchainTxSender{
+			modName:        "modify.blockchain_tx",
+			log:            testutils.Logger(t, "modify.blockchain_tx"),
+			chain:          chain,
+			requireSigner:  true,
+			receiptTimeout: 50 * time.Millisecond,
+			pollInterval:   time.Millisecond,
+			failurePolicy:  txFailureIgnore,
+		}
+	}
+	status := func(t *testing.T, h textproto.Header, expected string) {
+		t.Helper()
+		if h.Has(blockchainRawTxMailHeader) || h.Has(blockchainTypeHeader) {
+			t.Error("transaction header fields are not removed")
+		}
+		if vals := h.Values(blockchainTxResultHeader); len(vals) != 1 || !strings.Contains(vals[0], "status="+expected) {
+			t.Errorf("expected status %s, got %v", expected, vals)
+		}
+	}
+
+	t.Run("mined", func(t *testing.T) {
+		chain := &testChain{receipts: map[string]*module.TxReceipt{
+			hash: {TxHash: hash, Success: true, BlockNumber: 42},
+		}}
+		h, err := rewriteTx(t, newSender(chain), user, rawTx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chain.sent) != 1 {
+			t.Fatal("transaction is not broadcasted")
+		}
+		status(t, h, txStatusSuccess)
+		if res := h.Get(blockchainTxResultHeader); !strings.HasPrefix(res, hash) || !strings.Contains(res, "block=42") {
+			t.Error("wrong result:", res)
+		}
+	})
+
+	t.Run("pending", func(t *testing.T) {
+		h, err := rewriteTx(t, newSender(&testChain{}), user, rawTx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		status(t, h, txStatusPending)
+	})
+
+	t.Run("other signer", func(t *testing.T) {
+		chain := &testChain{}
+		h, err := rewriteTx(t, newSender(chain), "0x2222222222222222222222222222222222222222@example.org", rawTx)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(chain.sent) != 0 {
+			t.Fatal("transaction of another account is broadcasted")
+		}
+		status(t, h, txStatusInvalid)
+	})
+
+	t.Run("other chain", func(t *testing.T) {
+		chain := &testChain{}
+		otherTx, _ := signedTx(t, key, big.NewInt(1))
+		b := newSender(chain)
+		b.failurePolicy = txFailureTempfail
+		_, err := rewriteTx(t, b, user, otherTx)
+		if err == nil || err.(*exterrors.SMTPError).Code != 550 {
+			t.Fatal("expected permanent rejection, got", err)
+		}
+		if len(chain.sent) != 0 {
+			t.Fatal("transaction for another chain is broadcasted")
+		}
+	})
+
+	t.Run("signer not required", func(t *testing.T) {
+		chain := &testChain{}
+		b := newSender(chain)
+		b.requireSigner = false
+		if _, err := rewriteTx(t, b, "", rawTx); err != nil {
+			t.Fatal(err)
+		}
+		if len(chain.sent) != 1 {
+			t.Fatal("transaction is not broadcasted")
+		}
+	})
+
+	for policy, code := range map[string]int{txFailureIgnore: 0, txFailureTempfail: 451, txFailureReject: 550} {
+		t.Run("broadcast failure "+policy, func(t *testing.T) {
+			b := newSender(&testChain{sendErr: errors.New("nonce too low")})
+			b.failurePolicy = policy
+			h, err := rewriteTx(t, b, user, rawTx)
+			if code == 0 {
+				if err != nil {
+					t.Fatal(err)
+				}
+				status(t, h, txStatusRejected)
+				return
+			}
+			if err == nil || err.(*exterrors.SMTPError).Code != code {
+				t.Fatalf("expected %d, got %v", code, err)
+			}
+		})
+	}
+}
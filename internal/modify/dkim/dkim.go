@@ -24,9 +24,9 @@ import (
 	"errors"
 	"fmt"
 	"io"
-	"path/filepath"
 	"runtime/trace"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/emersion/go-message/textproto"
@@ -44,6 +44,8 @@ import (
 
 const Day = 86400 * time.Second
 
+const defaultKeyPath = "dkim_keys/{domain}_{selector}.key"
+
 var (
 	oversignDefault = []string{
 		// Directly visible to the user.
@@ -98,7 +100,8 @@ type Modifier struct {
 
 	domains        []string
 	selector       string
-	signers        map[string]crypto.Signer
+	keysLck        sync.RWMutex
+	keys           map[string]signingKey
 	oversignHeader []string
 	signHeader     []string
 	headerCanon    dkim.Canonicalization
@@ -108,13 +111,24 @@ type Modifier struct {
 	multipleFromOk bool
 	signSubdomains bool
 
+	keyPathTemplate string
+	newKeyAlgo      string
+	rotation        rotationConfig
+	rotateStop      chan struct{}
+	rotateDone      chan struct{}
+
 	log log.Logger
 }
 
+type signingKey struct {
+	selector string
+	signer   crypto.Signer
+}
+
 func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
 	m := &Modifier{
 		instName: instName,
-		signers:  map[string]crypto.Signer{},
+		keys:     map[string]signingKey{},
 		log:      log.Logger{Name: "modify.dkim"},
 	}
 
@@ -140,16 +154,12 @@ func (m *Modifier) InstanceName() string {
 }
 
 func (m *Modifier) Init(cfg *config.Map) error {
-	var (
-		hashName        string
-		keyPathTemplate string
-		newKeyAlgo      string
-	)
+	var hashName string
 
 	cfg.Bool("debug", true, false, &m.log.Debug)
 	cfg.StringList("domains", false, false, m.domains, &m.domains)
 	cfg.String("selector", false, false, m.selector, &m.selector)
-	cfg.String("key_path", false, false, "dkim_keys/{domain}_{selector}.key", &keyPathTemplate)
+	cfg.String("key_path", false, false, defaultKeyPath, &m.keyPathTemplate)
 	cfg.StringList("oversign_fields", false, false, oversignDefault, &m.oversignHeader)
 	cfg.StringList("sign_fields", false, false, signDefault, &m.signHeader)
 	cfg.Enum("header_canon", false, false,
@@ -162,9 +172,10 @@ func (m *Modifier) Init(cfg *config.Map) error {
 	cfg.Enum("hash", false, false,
 		[]string{"sha256"}, "sha256", &hashName)
 	cfg.Enum("newkey_algo", false, false,
-		[]string{"rsa4096", "rsa2048", "ed25519"}, "rsa2048", &newKeyAlgo)
+		[]string{"rsa4096", "rsa2048", "ed25519"}, "rsa2048", &m.newKeyAlgo)
 	cfg.Bool("allow_multiple_from", false, false, &m.multipleFromOk)
 	cfg.Bool("sign_subdomains", false, false, &m.signSubdomains)
+	m.rotation.directives(cfg)
 
 	if _, err := cfg.Process(); err != nil {
 		return err
@@ -189,35 +200,59 @@ func (m *Modifier) Init(cfg *config.Map) error {
 		if _, err := idna.ToASCII(domain); err != nil {
 			m.log.Printf("warning: unable to convert domain %s to A-labels form, non-EAI messages will not be signed: %v", domain, err)
 		}
+	}
+
+	if m.rotation.interval != 0 {
+		if err := m.initRotation(); err != nil {
+			return err
+		}
+	}
+	if err := m.loadKeys(); err != nil {
+		return err
+	}
+	if m.rotation.interval != 0 && !module.NoRun {
+		m.startRotation()
+	}
+
+	return nil
+}
 
-		keyValues := strings.NewReplacer("{domain}", domain, "{selector}", m.selector)
-		keyPath := keyValues.Replace(keyPathTemplate)
+// loadKeys loads or generates signing keys of the active selectors.
+func (m *Modifier) loadKeys() error {
+	for _, domain := range m.domains {
+		selector := m.activeSelector(domain)
+		keyPath := m.keyPath(domain, selector)
 
-		signer, newKey, err := m.loadOrGenerateKey(keyPath, newKeyAlgo)
+		signer, newKey, err := m.loadOrGenerateKey(keyPath, m.newKeyAlgo)
 		if err != nil {
 			return err
 		}
 
 		if newKey {
-			dnsPath := keyPath + ".dns"
-			if filepath.Ext(keyPath) == ".key" {
-				dnsPath = keyPath[:len(keyPath)-4] + ".dns"
-			}
+			dnsPath := dnsRecordPath(keyPath)
 			m.log.Printf("generated a new %s keypair, private key is in %s, TXT record with public key is in %s,\n"+
 				"put its contents into TXT record for %s._domainkey.%s to make signing and verification work",
-				newKeyAlgo, keyPath, dnsPath, m.selector, domain)
+				m.newKeyAlgo, keyPath, dnsPath, selector, domain)
 		}
 
 		normDomain, err := dns.ForLookup(domain)
 		if err != nil {
 			return fmt.Errorf("sign_skim: unable to normalize domain %s: %w", domain, err)
 		}
-		m.signers[normDomain] = signer
+		m.keys[normDomain] = signingKey{selector: selector, signer: signer}
 	}
-
 	return nil
 }
 
+func (m *Modifier) keyPath(domain, selector string) string {
+	return expandKeyPath(m.keyPathTemplate, domain, selector)
+}
+
+func expandKeyPath(template, domain, selector string) string {
+	keyValues := strings.NewReplacer("{domain}", domain, "{selector}", selector)
+	return keyValues.Replace(template)
+}
+
 func (m *Modifier) fieldsToSign(h *textproto.Header) []string {
 	// Filter out duplicated fields from configs so they
 	// will not cause panic() in go-msgauth internals.
@@ -290,8 +325,6 @@ func (s *state) RewriteBody(ctx context.Context, h *textproto.Header, body buffe
 	if domain == "" {
 		domain = s.m.domains[0]
 	}
-	selector := s.m.selector
-
 	if s.m.signSubdomains {
 		topDomain := s.m.domains[0]
 		if strings.HasSuffix(domain, "."+topDomain) {
@@ -303,11 +336,14 @@ func (s *state) RewriteBody(ctx context.Context, h *textproto.Header, body buffe
 		s.log.Error("unable to normalize domain from envelope sender", err, "domain", domain)
 		return nil
 	}
-	keySigner := s.m.signers[normDomain]
-	if keySigner == nil {
+	s.m.keysLck.RLock()
+	key, ok := s.m.keys[normDomain]
+	s.m.keysLck.RUnlock()
+	if !ok {
 		s.log.Msg("no key for domain", "domain", normDomain)
 		return nil
 	}
+	selector := key.selector
 
 	// If the message is non-EAI, we are not allowed to use domains in U-labels,
 	// attempt to convert.
@@ -328,7 +364,7 @@ func (s *state) RewriteBody(ctx context.Context, h *textproto.Header, body buffe
 		Domain:                 domain,
 		Selector:               selector,
 		Identifier:             "@" + domain,
-		Signer:                 keySigner,
+		Signer:                 key.signer,
 		Hash:                   s.m.hash,
 		HeaderCanonicalization: s.m.headerCanon,
 		BodyCanonicalization:   s.m.bodyCanon,
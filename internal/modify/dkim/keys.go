@@ -168,10 +168,7 @@ func writeDNSRecord(keyPath, dkimAlgoName string, pkey crypto.Signer) (string, e
 		panic("modify.dkim.writeDNSRecord: unknown key algorithm")
 	}
 
-	dnsPath := keyPath + ".dns"
-	if filepath.Ext(keyPath) == ".key" {
-		dnsPath = keyPath[:len(keyPath)-4] + ".dns"
-	}
+	dnsPath := dnsRecordPath(keyPath)
 	dnsF, err := os.Create(dnsPath)
 	if err != nil {
 		return "", err
@@ -182,3 +179,12 @@ func writeDNSRecord(keyPath, dkimAlgoName string, pkey crypto.Signer) (string, e
 	}
 	return dnsPath, nil
 }
+
+// dnsRecordPath returns the path of the file with the DNS record for the key
+// stored at keyPath.
+func dnsRecordPath(keyPath string) string {
+	if filepath.Ext(keyPath) == ".key" {
+		return keyPath[:len(keyPath)-4] + ".dns"
+	}
+	return keyPath + ".dns"
+}
@@ -0,0 +1,506 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package dkim
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/libdns/libdns"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/dns"
+)
+
+// rotateCheckInterval is how often the rotation state is re-evaluated.
+const rotateCheckInterval = 5 * time.Minute
+
+const defaultRotateState = "dkim_keys/rotation.json"
+
+// DNSProvider is the subset of libdns interfaces required to publish and
+// remove DKIM records. It is implemented by libdns.* modules.
+type DNSProvider interface {
+	libdns.RecordAppender
+	libdns.RecordDeleter
+}
+
+type rotationConfig struct {
+	interval           time.Duration
+	grace              time.Duration
+	propagationTimeout time.Duration
+	recordTTL          time.Duration
+	statePath          string
+	zones              []string
+	provider           DNSProvider
+
+	resolver dns.Resolver
+	now      func() time.Time
+	state    rotationState
+}
+
+func (rc *rotationConfig) directives(cfg *config.Map) {
+	cfg.Duration("rotate_interval", false, false, 0, &rc.interval)
+	cfg.Duration("rotate_grace", false, false, 7*Day, &rc.grace)
+	cfg.Duration("rotate_propagation_timeout", false, false, 2*time.Hour, &rc.propagationTimeout)
+	cfg.Duration("rotate_record_ttl", false, false, time.Hour, &rc.recordTTL)
+	cfg.String("rotate_state", false, false, defaultRotateState, &rc.statePath)
+	cfg.StringList("rotate_zone", false, false, nil, &rc.zones)
+	cfg.Custom("rotate_dns", false, false, func() (interface{}, error) {
+		return nil, nil
+	}, func(m *config.Map, node config.Node) (interface{}, error) {
+		var p DNSProvider
+		err := modconfig.ModuleFromNode("libdns", node.Args, node, m.Globals, &p)
+		return p, err
+	}, &rc.provider)
+}
+
+// rotationState is persisted on disk so the rotation survives restarts.
+type rotationState struct {
+	Domains map[string]*domainRotation `json:"domains"`
+}
+
+type domainRotation struct {
+	// Active is the selector currently used for signing.
+	Active      string    `json:"active"`
+	ActiveSince time.Time `json:"active_since"`
+
+	// Pending is the selector that has its record published but is not
+	// used for signing until the record is visible in DNS.
+	Pending      string    `json:"pending,omitempty"`
+	PendingSince time.Time `json:"pending_since,omitempty"`
+
+	// Retiring contains selectors that are no longer used for signing but
+	// still have their records published so signatures on messages in
+	// transit can be verified.
+	Retiring []retiringSelector `json:"retiring,omitempty"`
+}
+
+type retiringSelector struct {
+	Selector string    `json:"selector"`
+	RetireAt time.Time `json:"retire_at"`
+}
+
+func (m *Modifier) initRotation() error {
+	rc := &m.rotation
+	if rc.provider == nil {
+		return errors.New("modify.dkim: rotate_dns is required to use rotate_interval")
+	}
+	if !strings.Contains(m.keyPathTemplate, "{selector}") {
+		return errors.New("modify.dkim: key_path should contain {selector} to use rotate_interval")
+	}
+	if rc.resolver == nil {
+		rc.resolver = dns.DefaultResolver()
+	}
+	if rc.now == nil {
+		rc.now = time.Now
+	}
+
+	return m.loadRotationState()
+}
+
+func (m *Modifier) startRotation() {
+	m.rotateStop = make(chan struct{})
+	m.rotateDone = make(chan struct{})
+	go m.rotateLoop()
+}
+
+// activeSelector returns the selector used for signing messages from the
+// domain. It is the one switched to by the rotation, if any, so the key of
+// the retired base selector is not regenerated after restart.
+func (m *Modifier) activeSelector(domain string) string {
+	if st := m.rotation.state.Domains[domain]; st != nil && st.Active != "" {
+		return st.Active
+	}
+	return m.selector
+}
+
+// PublishedSelectors returns the selectors that have their records published
+// for the domain according to the rotation state file at statePath: the
+// active one, the pending one and the retiring ones. It returns nil if the
+// file does not exist or has no entry for the domain.
+func PublishedSelectors(statePath, domain string) ([]string, error) {
+	blob, err := os.ReadFile(statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil, nil
+		}
+		return nil, err
+	}
+	var state rotationState
+	if err := json.Unmarshal(blob, &state); err != nil {
+		return nil, fmt.Errorf("%s: %w", statePath, err)
+	}
+	st := state.Domains[domain]
+	if st == nil || st.Active == "" {
+		return nil, nil
+	}
+	selectors := []string{st.Active}
+	if st.Pending != "" {
+		selectors = append(selectors, st.Pending)
+	}
+	for _, r := range st.Retiring {
+		selectors = append(selectors, r.Selector)
+	}
+	return selectors, nil
+}
+
+// KeyConfig is the part of the modify.dkim configuration that determines
+// which DKIM records are published.
+type KeyConfig struct {
+	Domains        []string
+	Selector       string
+	KeyPath        string
+	RotateInterval time.Duration
+	RotateState    string
+}
+
+// ReadKeyConfig reads the key configuration of modify.dkim from the
+// configuration block and the inline arguments (domains followed by the
+// selector) without initializing the module, so no keys are generated.
+func ReadKeyConfig(inlineArgs []string, node config.Node) (KeyConfig, error) {
+	var kc KeyConfig
+	if len(inlineArgs) == 1 {
+		return kc, errors.New("modify.dkim: at least two arguments required")
+	}
+	if len(inlineArgs) != 0 {
+		kc.Domains = inlineArgs[:len(inlineArgs)-1]
+		kc.Selector = inlineArgs[len(inlineArgs)-1]
+	}
+
+	cfg := config.NewMap(nil, node)
+	cfg.StringList("domains", false, false, kc.Domains, &kc.Domains)
+	cfg.String("selector", false, false, kc.Selector, &kc.Selector)
+	cfg.String("key_path", false, false, defaultKeyPath, &kc.KeyPath)
+	cfg.Duration("rotate_interval", false, false, 0, &kc.RotateInterval)
+	cfg.String("rotate_state", false, false, defaultRotateState, &kc.RotateState)
+	cfg.AllowUnknown()
+	if _, err := cfg.Process(); err != nil {
+		return kc, err
+	}
+	if kc.Selector == "" {
+		return kc, errors.New("modify.dkim: selector is not specified")
+	}
+	return kc, nil
+}
+
+// Signs reports whether the domain is one of the signing domains.
+func (kc KeyConfig) Signs(domain string) bool {
+	for _, d := range kc.Domains {
+		if strings.EqualFold(d, domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Records returns the DNS records of the keys published for the domain,
+// keyed by selector: the selectors from the rotation state if the keys are
+// rotated, the configured selector otherwise. Relative paths are resolved
+// against the current directory, the state directory of the server.
+func (kc KeyConfig) Records(domain string) (map[string]string, error) {
+	var selectors []string
+	if kc.RotateInterval != 0 {
+		var err error
+		selectors, err = PublishedSelectors(kc.RotateState, domain)
+		if err != nil {
+			return nil, err
+		}
+	}
+	if len(selectors) == 0 {
+		selectors = []string{kc.Selector}
+	}
+
+	records := make(map[string]string, len(selectors))
+	for _, selector := range selectors {
+		blob, err := os.ReadFile(dnsRecordPath(expandKeyPath(kc.KeyPath, domain, selector)))
+		if err != nil {
+			return nil, fmt.Errorf("DKIM selector %s: %w", selector, err)
+		}
+		records[selector] = strings.TrimSpace(string(blob))
+	}
+	return records, nil
+}
+
+func (m *Modifier) loadRotationState() error {
+	rc := &m.rotation
+	rc.state = rotationState{Domains: map[string]*domainRotation{}}
+
+	blob, err := os.ReadFile(rc.statePath)
+	if err != nil {
+		if os.IsNotExist(err) {
+			return nil
+		}
+		return fmt.Errorf("modify.dkim: load rotation state: %w", err)
+	}
+	if err := json.Unmarshal(blob, &rc.state); err != nil {
+		return fmt.Errorf("modify.dkim: load rotation state: %w", err)
+	}
+	if rc.state.Domains == nil {
+		rc.state.Domains = map[string]*domainRotation{}
+	}
+	return nil
+}
+
+func (m *Modifier) saveRotationState() error {
+	rc := &m.rotation
+	blob, err := json.MarshalIndent(rc.state, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rc.statePath), 0o777); err != nil {
+		return err
+	}
+	tmp := rc.statePath + ".tmp"
+	if err := os.WriteFile(tmp, blob, 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, rc.statePath)
+}
+
+func (m *Modifier) rotateLoop() {
+	defer close(m.rotateDone)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-m.rotateStop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(rotateCheckInterval)
+	defer ticker.Stop()
+	for {
+		m.rotate(ctx)
+
+		select {
+		case <-ticker.C:
+		case <-m.rotateStop:
+			return
+		}
+	}
+}
+
+func (m *Modifier) Close() error {
+	if m.rotateStop != nil {
+		close(m.rotateStop)
+		<-m.rotateDone
+	}
+	return nil
+}
+
+// rotate advances the rotation for all domains. Each step is idempotent and
+// the state is saved after each change so interrupted rotation continues
+// after restart.
+func (m *Modifier) rotate(ctx context.Context) {
+	rc := &m.rotation
+	for _, domain := range m.domains {
+		st := rc.state.Domains[domain]
+		if st == nil {
+			st = &domainRotation{Active: m.selector, ActiveSince: rc.now()}
+			rc.state.Domains[domain] = st
+			if err := m.saveRotationState(); err != nil {
+				m.log.Error("failed to save rotation state", err)
+			}
+		}
+
+		if err := m.rotateDomain(ctx, domain, st); err != nil {
+			m.log.Error("key rotation failed", err, "domain", domain)
+		}
+		if err := m.saveRotationState(); err != nil {
+			m.log.Error("failed to save rotation state", err)
+		}
+	}
+}
+
+func (m *Modifier) rotateDomain(ctx context.Context, domain string, st *domainRotation) error {
+	rc := &m.rotation
+	now := rc.now()
+
+	if st.Pending == "" && now.Sub(st.ActiveSince) >= rc.interval {
+		selector := m.selector + "-" + now.Format("20060102")
+		if selector == st.Active {
+			selector = m.selector + "-" + now.Format("200601021504")
+		}
+
+		if _, _, err := m.loadOrGenerateKey(m.keyPath(domain, selector), m.newKeyAlgo); err != nil {
+			return err
+		}
+		record, err := m.dnsRecord(domain, selector)
+		if err != nil {
+			return err
+		}
+		zone, name := rc.recordName(domain, selector)
+		if _, err := rc.provider.AppendRecords(ctx, zone, []libdns.Record{
+			{
+				Type:  "TXT",
+				Name:  name,
+				Value: record,
+				TTL:   rc.recordTTL,
+			},
+		}); err != nil {
+			return fmt.Errorf("publish %s: %w", selector, err)
+		}
+
+		m.log.Msg("published new DKIM key", "domain", domain, "selector", selector)
+		st.Pending = selector
+		st.PendingSince = now
+	}
+
+	if st.Pending != "" {
+		visible, err := m.recordVisible(ctx, domain, st.Pending)
+		if err != nil {
+			m.log.DebugMsg("DKIM record lookup failed", "domain", domain, "selector", st.Pending, "reason", err.Error())
+		}
+		switch {
+		case visible:
+			if err := m.switchKey(domain, st.Pending); err != nil {
+				return err
+			}
+			m.log.Msg("switched to new DKIM key", "domain", domain, "selector", st.Pending, "old_selector", st.Active)
+
+			st.Retiring = append(st.Retiring, retiringSelector{
+				Selector: st.Active,
+				RetireAt: now.Add(rc.grace),
+			})
+			st.Active = st.Pending
+			st.ActiveSince = now
+			st.Pending = ""
+			st.PendingSince = time.Time{}
+		case now.Sub(st.PendingSince) > rc.propagationTimeout:
+			m.log.Msg("DKIM record is still not visible in DNS, signing with the old key",
+				"domain", domain, "selector", st.Pending, "since", st.PendingSince)
+		}
+	}
+
+	remaining := st.Retiring[:0]
+	for _, r := range st.Retiring {
+		if now.Before(r.RetireAt) {
+			remaining = append(remaining, r)
+			continue
+		}
+		if err := m.retireKey(ctx, domain, r.Selector); err != nil {
+			m.log.Error("failed to retire DKIM key", err, "domain", domain, "selector", r.Selector)
+			remaining = append(remaining, r)
+			continue
+		}
+		m.log.Msg("retired old DKIM key", "domain", domain, "selector", r.Selector)
+	}
+	st.Retiring = remaining
+
+	return nil
+}
+
+// recordName returns the zone containing the DKIM record of the selector
+// and the record name relative to it. The longest of rotate_zone values that
+// contains the domain is used, the domain itself is used as the zone if none
+// does.
+func (rc *rotationConfig) recordName(domain, selector string) (zone, name string) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	name = selector + "._domainkey"
+
+	best := ""
+	for _, z := range rc.zones {
+		z = strings.ToLower(strings.TrimSuffix(z, "."))
+		if (domain == z || strings.HasSuffix(domain, "."+z)) && len(z) > len(best) {
+			best = z
+		}
+	}
+	if best == "" {
+		return domain + ".", name
+	}
+	if rel := strings.TrimSuffix(domain, "."+best); rel != domain {
+		name += "." + rel
+	}
+	return best + ".", name
+}
+
+func (m *Modifier) dnsRecord(domain, selector string) (string, error) {
+	keyPath := m.keyPath(domain, selector)
+	dnsPath := dnsRecordPath(keyPath)
+	blob, err := os.ReadFile(dnsPath)
+	if err != nil {
+		return "", err
+	}
+	return strings.TrimSpace(string(blob)), nil
+}
+
+func (m *Modifier) recordVisible(ctx context.Context, domain, selector string) (bool, error) {
+	record, err := m.dnsRecord(domain, selector)
+	if err != nil {
+		return false, err
+	}
+	txts, err := m.rotation.resolver.LookupTXT(ctx, selector+"._domainkey."+dns.FQDN(domain))
+	if err != nil {
+		return false, err
+	}
+	for _, txt := range txts {
+		if strings.ReplaceAll(txt, " ", "") == strings.ReplaceAll(record, " ", "") {
+			return true, nil
+		}
+	}
+	return false, nil
+}
+
+func (m *Modifier) switchKey(domain, selector string) error {
+	signer, _, err := m.loadOrGenerateKey(m.keyPath(domain, selector), m.newKeyAlgo)
+	if err != nil {
+		return err
+	}
+	normDomain, err := dns.ForLookup(domain)
+	if err != nil {
+		return err
+	}
+
+	m.keysLck.Lock()
+	m.keys[normDomain] = signingKey{selector: selector, signer: signer}
+	m.keysLck.Unlock()
+	return nil
+}
+
+// retireKey removes the DNS record and key files for the selector.
+func (m *Modifier) retireKey(ctx context.Context, domain, selector string) error {
+	record, err := m.dnsRecord(domain, selector)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	zone, name := m.rotation.recordName(domain, selector)
+	if _, err := m.rotation.provider.DeleteRecords(ctx, zone, []libdns.Record{
+		{
+			Type:  "TXT",
+			Name:  name,
+			Value: record,
+		},
+	}); err != nil {
+		return fmt.Errorf("remove record: %w", err)
+	}
+
+	keyPath := m.keyPath(domain, selector)
+	dnsPath := dnsRecordPath(keyPath)
+	for _, path := range []string{keyPath, dnsPath} {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,254 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package dkim
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/foxcpp/go-mockdns"
+	"github.com/libdns/libdns"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+)
+
+type testProvider struct {
+	resolver *mockdns.Resolver
+	deleted  []string
+}
+
+func (p *testProvider) AppendRecords(_ context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, rec := range recs {
+		name := rec.Name + "." + zone
+		z := p.resolver.Zones[name]
+		z.TXT = append(z.TXT, rec.Value)
+		p.resolver.Zones[name] = z
+	}
+	return recs, nil
+}
+
+func (p *testProvider) DeleteRecords(_ context.Context, zone string, recs []libdns.Record) ([]libdns.Record, error) {
+	for _, rec := range recs {
+		delete(p.resolver.Zones, rec.Name+"."+zone)
+		p.deleted = append(p.deleted, rec.Name)
+	}
+	return recs, nil
+}
+
+func TestRotation(t *testing.T) {
+	dir := t.TempDir()
+	m := newTestModifier(t, dir, "ed25519", []string{"mailcoin.test"})
+
+	now := time.Date(2026, 3, 1, 0, 0, 0, 0, time.UTC)
+	resolver := &mockdns.Resolver{Zones: map[string]mockdns.Zone{}}
+	provider := &testProvider{resolver: &mockdns.Resolver{Zones: map[string]mockdns.Zone{}}}
+
+	m.keyPathTemplate = filepath.Join(dir, "{domain}_{selector}.key")
+	m.rotation = rotationConfig{
+		interval:           30 * Day,
+		grace:              7 * Day,
+		propagationTimeout: time.Hour,
+		statePath:          filepath.Join(dir, "rotation.json"),
+		provider:           provider,
+		resolver:           resolver,
+		now:                func() time.Time { return now },
+	}
+	if err := m.loadRotationState(); err != nil {
+		t.Fatal(err)
+	}
+
+	ctx := context.Background()
+	checkSelector := func(expected string) {
+		t.Helper()
+		hdr, _ := signTestMsg(t, m, "test@mailcoin.test")
+		if sig := hdr.Get("Dkim-Signature"); !strings.Contains(sig, "s="+expected+";") {
+			t.Errorf("expected selector %s, got signature %s", expected, sig)
+		}
+	}
+
+	m.rotate(ctx)
+	checkSelector("default")
+
+	// Interval passed, new record published but not yet visible.
+	now = now.Add(31 * Day)
+	m.rotate(ctx)
+	checkSelector("default")
+	st := m.rotation.state.Domains["mailcoin.test"]
+	if st.Pending != "default-20260401" {
+		t.Fatalf("unexpected pending selector: %+v", st)
+	}
+	if _, ok := provider.resolver.Zones["default-20260401._domainkey.mailcoin.test."]; !ok {
+		t.Fatal("record was not published")
+	}
+
+	// Record became visible.
+	resolver.Zones = provider.resolver.Zones
+	now = now.Add(time.Hour)
+	m.rotate(ctx)
+	checkSelector("default-20260401")
+
+	// State is persisted and used after restart.
+	m2 := newTestModifier(t, dir, "ed25519", []string{"mailcoin.test"})
+	m2.keyPathTemplate = m.keyPathTemplate
+	m2.rotation = m.rotation
+	if err := m2.loadRotationState(); err != nil {
+		t.Fatal(err)
+	}
+	st = m2.rotation.state.Domains["mailcoin.test"]
+	if st.Active != "default-20260401" || len(st.Retiring) != 1 || st.Retiring[0].Selector != "default" {
+		t.Fatalf("unexpected persisted state: %+v", st)
+	}
+	selectors, err := PublishedSelectors(m.rotation.statePath, "mailcoin.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Join(selectors, ",") != "default-20260401,default" {
+		t.Errorf("unexpected published selectors: %v", selectors)
+	}
+	if selectors, _ := PublishedSelectors(m.rotation.statePath, "other.test"); selectors != nil {
+		t.Errorf("unexpected published selectors for unknown domain: %v", selectors)
+	}
+
+	// The base key was generated before key_path included the selector.
+	blob, err := os.ReadFile(filepath.Join(dir, "mailcoin.test.dns"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(dir, "mailcoin.test_default.dns"), blob, 0o644); err != nil {
+		t.Fatal(err)
+	}
+	kc, err := ReadKeyConfig([]string{"mailcoin.test", "default"}, config.Node{
+		Children: []config.Node{
+			{Name: "key_path", Args: []string{m.keyPathTemplate}},
+			{Name: "rotate_interval", Args: []string{"720h"}},
+			{Name: "rotate_state", Args: []string{m.rotation.statePath}},
+			{Name: "rotate_dns", Args: []string{"cloudflare"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	records, err := kc.Records("mailcoin.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 2 || !strings.HasPrefix(records["default-20260401"], "v=DKIM1") || !strings.HasPrefix(records["default"], "v=DKIM1") {
+		t.Errorf("unexpected records of rotated keys: %v", records)
+	}
+
+	// Old selector is removed after the grace period.
+	now = now.Add(8 * Day)
+	m.rotate(ctx)
+	if len(provider.deleted) != 1 || provider.deleted[0] != "default._domainkey" {
+		t.Errorf("old record was not removed: %v", provider.deleted)
+	}
+	if len(m.rotation.state.Domains["mailcoin.test"].Retiring) != 0 {
+		t.Errorf("old selector is still retiring")
+	}
+	if err := m.saveRotationState(); err != nil {
+		t.Fatal(err)
+	}
+
+	// The retired base key is not regenerated after restart.
+	mod, err := New("", "test", nil, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	m3 := mod.(*Modifier)
+	m3.log = m.log
+	m3.domains = m.domains
+	m3.selector = m.selector
+	m3.newKeyAlgo = m.newKeyAlgo
+	m3.keyPathTemplate = m.keyPathTemplate
+	m3.rotation = m.rotation
+	if err := m3.loadRotationState(); err != nil {
+		t.Fatal(err)
+	}
+	if err := m3.loadKeys(); err != nil {
+		t.Fatal(err)
+	}
+	if sel := m3.keys["mailcoin.test"].selector; sel != "default-20260401" {
+		t.Errorf("expected the active selector to be loaded, got %s", sel)
+	}
+	if _, err := os.Stat(m.keyPath("mailcoin.test", "default")); !os.IsNotExist(err) {
+		t.Errorf("retired key was regenerated: %v", err)
+	}
+}
+
+func TestRotationZone(t *testing.T) {
+	rc := rotationConfig{zones: []string{"example.org", "mail.example.org.", "example.com"}}
+	for _, tc := range []struct {
+		domain, zone, name string
+	}{
+		{"example.org", "example.org.", "s._domainkey"},
+		{"sub.example.org", "example.org.", "s._domainkey.sub"},
+		{"a.mail.example.org.", "mail.example.org.", "s._domainkey.a"},
+		{"Example.COM", "example.com.", "s._domainkey"},
+		{"example.net", "example.net.", "s._domainkey"},
+		{"notexample.org", "notexample.org.", "s._domainkey"},
+	} {
+		zone, name := rc.recordName(tc.domain, "s")
+		if zone != tc.zone || name != tc.name {
+			t.Errorf("%s: expected %s in %s, got %s in %s", tc.domain, tc.name, tc.zone, name, zone)
+		}
+	}
+}
+
+func TestKeyConfig(t *testing.T) {
+	dir := t.TempDir()
+	newTestModifier(t, dir, "ed25519", []string{"mailcoin.test"})
+
+	kc, err := ReadKeyConfig(nil, config.Node{
+		Children: []config.Node{
+			{Name: "domains", Args: []string{"mailcoin.test", "example.org"}},
+			{Name: "selector", Args: []string{"default"}},
+			{Name: "key_path", Args: []string{filepath.Join(dir, "{domain}.key")}},
+			{Name: "sign_subdomains", Args: []string{"yes"}},
+		},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !kc.Signs("MailCoin.test") || kc.Signs("other.test") {
+		t.Errorf("unexpected signing domains: %v", kc.Domains)
+	}
+	records, err := kc.Records("mailcoin.test")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(records) != 1 || !strings.HasPrefix(records["default"], "v=DKIM1") {
+		t.Errorf("unexpected records: %v", records)
+	}
+
+	// Missing keys are not generated.
+	if _, err := kc.Records("example.org"); !errors.Is(err, os.ErrNotExist) {
+		t.Errorf("expected a missing key error, got %v", err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, "example.org.key")); !os.IsNotExist(err) {
+		t.Errorf("key was generated: %v", err)
+	}
+
+	if _, err := ReadKeyConfig([]string{"mailcoin.test"}, config.Node{}); err == nil {
+		t.Error("expected an error for the missing selector")
+	}
+}
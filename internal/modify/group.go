@@ -20,12 +20,15 @@ package modify
 
 import (
 	"context"
+	"fmt"
 
 	"github.com/emersion/go-message/textproto"
 	"github.com/mail-chat-chain/mailchatd/framework/buffer"
 	"github.com/mail-chat-chain/mailchatd/framework/config"
 	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 type (
@@ -40,9 +43,19 @@ type (
 
 	groupState struct {
 		states []module.ModifierState
+		// names contains modifier names used in tracing spans, in the
+		// same order as states.
+		names []string
 	}
 )
 
+func modifierName(mod module.Modifier) string {
+	if m, ok := mod.(module.Module); ok {
+		return m.Name() + ":" + m.InstanceName()
+	}
+	return fmt.Sprintf("%T", mod)
+}
+
 func (g *Group) Init(cfg *config.Map) error {
 	for _, node := range cfg.Block.Children {
 		mod, err := modconfig.MsgModifier(cfg.Globals, append([]string{node.Name}, node.Args...), node)
@@ -76,14 +89,17 @@ func (g Group) ModStateForMsg(ctx context.Context, msgMeta *module.MsgMetadata)
 			return nil, err
 		}
 		gs.states = append(gs.states, state)
+		gs.names = append(gs.names, modifierName(modifier))
 	}
 	return gs, nil
 }
 
 func (gs groupState) RewriteSender(ctx context.Context, mailFrom string) (string, error) {
 	var err error
-	for _, state := range gs.states {
-		mailFrom, err = state.RewriteSender(ctx, mailFrom)
+	for i, state := range gs.states {
+		modCtx, span := tracing.Start(ctx, "modify.sender", attribute.String("modifier", gs.names[i]))
+		mailFrom, err = state.RewriteSender(modCtx, mailFrom)
+		tracing.End(span, err)
 		if err != nil {
 			return "", err
 		}
@@ -94,24 +110,30 @@ func (gs groupState) RewriteSender(ctx context.Context, mailFrom string) (string
 func (gs groupState) RewriteRcpt(ctx context.Context, rcptTo string) ([]string, error) {
 	var err error
 	var result = []string{rcptTo}
-	for _, state := range gs.states {
+	for i, state := range gs.states {
+		modCtx, span := tracing.Start(ctx, "modify.rcpt", attribute.String("modifier", gs.names[i]))
 		var intermediateResult = []string{}
 		for _, partResult := range result {
 			var partResult_multi []string
-			partResult_multi, err = state.RewriteRcpt(ctx, partResult)
+			partResult_multi, err = state.RewriteRcpt(modCtx, partResult)
 			if err != nil {
+				tracing.End(span, err)
 				return []string{""}, err
 			}
 			intermediateResult = append(intermediateResult, partResult_multi...)
 		}
+		tracing.End(span, nil)
 		result = intermediateResult
 	}
 	return result, nil
 }
 
 func (gs groupState) RewriteBody(ctx context.Context, h *textproto.Header, body buffer.Buffer) error {
-	for _, state := range gs.states {
-		if err := state.RewriteBody(ctx, h, body); err != nil {
+	for i, state := range gs.states {
+		modCtx, span := tracing.Start(ctx, "modify.body", attribute.String("modifier", gs.names[i]))
+		err := state.RewriteBody(modCtx, h, body)
+		tracing.End(span, err)
+		if err != nil {
 			return err
 		}
 	}
@@ -0,0 +1,117 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package notarize
+
+import (
+	"bufio"
+	"crypto/sha256"
+	"io"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+)
+
+// digestFields are the header fields covered by the digest. Trace fields
+// added in transit, such as Received, are not covered, so the sender and the
+// recipients compute the same digest.
+var digestFields = []string{
+	"From", "Sender", "Reply-To", "To", "Cc", "Subject", "Date",
+	"Message-Id", "In-Reply-To", "References",
+	"Mime-Version", "Content-Type", "Content-Transfer-Encoding",
+}
+
+// relax unfolds the value and reduces whitespace sequences to a single
+// space, as the DKIM relaxed canonicalization does.
+func relax(s string) string {
+	var sb strings.Builder
+	space := false
+	for _, r := range s {
+		switch r {
+		case ' ', '\t', '\r', '\n':
+			space = true
+			continue
+		}
+		if space && sb.Len() != 0 {
+			sb.WriteByte(' ')
+		}
+		space = false
+		sb.WriteRune(r)
+	}
+	return sb.String()
+}
+
+func canonicalHeader(w io.Writer, h textproto.Header) error {
+	for _, field := range digestFields {
+		for _, value := range h.Values(field) {
+			if _, err := io.WriteString(w, strings.ToLower(field)+":"+relax(value)+"\r\n"); err != nil {
+				return err
+			}
+		}
+	}
+	return nil
+}
+
+// canonicalBody writes the body using the DKIM relaxed canonicalization:
+// whitespace is reduced, line endings are normalized and trailing empty
+// lines are removed.
+func canonicalBody(w io.Writer, body io.Reader) error {
+	r := bufio.NewReader(body)
+	emptyLines := 0
+	for {
+		line, err := r.ReadString('\n')
+		if line != "" {
+			line = relax(line)
+			if line == "" {
+				emptyLines++
+			} else {
+				if _, err := io.WriteString(w, strings.Repeat("\r\n", emptyLines)+line+"\r\n"); err != nil {
+					return err
+				}
+				emptyLines = 0
+			}
+		}
+		if err == io.EOF {
+			return nil
+		}
+		if err != nil {
+			return err
+		}
+	}
+}
+
+// Digest returns the SHA-256 digest of the canonicalized message.
+func Digest(h textproto.Header, body io.Reader) ([32]byte, error) {
+	hash := sha256.New()
+	bw := bufio.NewWriter(hash)
+	if err := canonicalHeader(bw, h); err != nil {
+		return [32]byte{}, err
+	}
+	if _, err := bw.WriteString("\r\n"); err != nil {
+		return [32]byte{}, err
+	}
+	if err := canonicalBody(bw, body); err != nil {
+		return [32]byte{}, err
+	}
+	if err := bw.Flush(); err != nil {
+		return [32]byte{}, err
+	}
+	var digest [32]byte
+	copy(digest[:], hash.Sum(nil))
+	return digest, nil
+}
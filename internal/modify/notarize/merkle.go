@@ -0,0 +1,85 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package notarize
+
+import (
+	"bytes"
+	"crypto/sha256"
+)
+
+// Leaves and inner nodes are hashed with different prefixes, so an inner
+// node cannot be presented as a message digest. Children are sorted, so the
+// proof does not need to encode the position of the node.
+
+func leafHash(digest [32]byte) [32]byte {
+	return sha256.Sum256(append([]byte{0}, digest[:]...))
+}
+
+func nodeHash(a, b [32]byte) [32]byte {
+	if bytes.Compare(a[:], b[:]) > 0 {
+		a, b = b, a
+	}
+	buf := make([]byte, 0, 1+2*len(a))
+	buf = append(buf, 1)
+	buf = append(buf, a[:]...)
+	buf = append(buf, b[:]...)
+	return sha256.Sum256(buf)
+}
+
+// buildTree returns the root of the tree with the digests as leaves and the
+// inclusion proof for each digest. The node without a sibling is moved to
+// the next level as is.
+func buildTree(digests [][32]byte) ([32]byte, [][][32]byte) {
+	level := make([][32]byte, len(digests))
+	pos := make([]int, len(digests))
+	for i, d := range digests {
+		level[i] = leafHash(d)
+		pos[i] = i
+	}
+	proofs := make([][][32]byte, len(digests))
+
+	for len(level) > 1 {
+		for i, p := range pos {
+			if sibling := p ^ 1; sibling < len(level) {
+				proofs[i] = append(proofs[i], level[sibling])
+			}
+			pos[i] = p / 2
+		}
+
+		next := make([][32]byte, 0, (len(level)+1)/2)
+		for i := 0; i < len(level); i += 2 {
+			if i+1 == len(level) {
+				next = append(next, level[i])
+				break
+			}
+			next = append(next, nodeHash(level[i], level[i+1]))
+		}
+		level = next
+	}
+	return level[0], proofs
+}
+
+// proofRoot returns the root of the tree containing the digest.
+func proofRoot(digest [32]byte, proof [][32]byte) [32]byte {
+	node := leafHash(digest)
+	for _, sibling := range proof {
+		node = nodeHash(node, sibling)
+	}
+	return node
+}
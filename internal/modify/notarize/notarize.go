@@ -0,0 +1,538 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package notarize implements the modifier that records digests of messages
+// on chain.
+//
+// Digests are collected into batches. On each interval the Merkle root of the
+// batch is submitted to the Notary contract (contracts/notary/Notary.sol)
+// and the inclusion proof of each message is saved to the proofs table, so
+// the time the message was seen can be proven later using only the message,
+// its proof and the chain.
+package notarize
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdsa"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"runtime/trace"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target"
+)
+
+const notarizationHeader = "X-Notarization"
+
+// notaryABI is the ABI of contracts/notary/Notary.sol.
+const notaryABI = `[
+	{"type":"function","name":"notarize","stateMutability":"nonpayable","inputs":[{"name":"root","type":"bytes32"}],"outputs":[]},
+	{"type":"function","name":"roots","stateMutability":"view","inputs":[{"name":"","type":"address"},{"name":"","type":"bytes32"}],"outputs":[{"name":"timestamp","type":"uint64"},{"name":"blockNumber","type":"uint64"}]}
+]`
+
+var parsedABI = func() abi.ABI {
+	a, err := abi.JSON(strings.NewReader(notaryABI))
+	if err != nil {
+		panic(err)
+	}
+	return a
+}()
+
+var (
+	ErrNotNotarized = errors.New("notarize: message is not notarized")
+	ErrPending      = errors.New("notarize: message batch is not submitted yet")
+	ErrBadProof     = errors.New("notarize: inclusion proof does not match the batch root")
+	ErrNotOnChain   = errors.New("notarize: batch root is not recorded on chain by the server")
+)
+
+// Record is the inclusion proof stored in the proofs table under the
+// hex-encoded message digest. Root, Proof and Tx are empty until the batch
+// root is included in a block. Tx is also empty if the inclusion was noticed
+// only after receipt_timeout, on the next submission attempt.
+type Record struct {
+	Queued time.Time `json:"queued"`
+	Root   string    `json:"root,omitempty"`
+	Proof  []string  `json:"proof,omitempty"`
+	Tx     string    `json:"tx,omitempty"`
+}
+
+type Modifier struct {
+	instName string
+	log      log.Logger
+
+	chain    module.BlockChain
+	contract common.Address
+	key      *ecdsa.PrivateKey
+	gasLimit uint64
+	interval time.Duration
+	proofs   module.MutableTable
+
+	receiptTimeout time.Duration
+	pollInterval   time.Duration
+
+	lck     sync.Mutex
+	pending map[[32]byte]struct{}
+
+	stop chan struct{}
+	done chan struct{}
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	if len(inlineArgs) != 0 {
+		return nil, errors.New("modify.notarize: inline arguments are not used")
+	}
+	return &Modifier{
+		instName:     instName,
+		log:          log.Logger{Name: "modify.notarize"},
+		pending:      map[[32]byte]struct{}{},
+		pollInterval: time.Second,
+	}, nil
+}
+
+func (m *Modifier) Name() string {
+	return "modify.notarize"
+}
+
+func (m *Modifier) InstanceName() string {
+	return m.instName
+}
+
+func (m *Modifier) Init(cfg *config.Map) error {
+	var (
+		contract string
+		keyFile  string
+		gasLimit int
+		proofs   module.Table
+	)
+	cfg.Bool("debug", true, false, &m.log.Debug)
+	cfg.Custom("blockchain", false, true, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var chain module.BlockChain
+		err := modconfig.ModuleFromNode("blockchain", node.Args, node, m.Globals, &chain)
+		return chain, err
+	}, &m.chain)
+	cfg.String("contract", false, true, "", &contract)
+	cfg.String("key_file", false, true, "", &keyFile)
+	cfg.Custom("proofs", false, true, nil, modconfig.TableDirective, &proofs)
+	cfg.Duration("interval", false, false, 10*time.Minute, &m.interval)
+	cfg.Int("gas_limit", false, false, 100000, &gasLimit)
+	cfg.Duration("receipt_timeout", false, false, 15*time.Second, &m.receiptTimeout)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	if !common.IsHexAddress(contract) {
+		return fmt.Errorf("%s: invalid contract address: %s", m.Name(), contract)
+	}
+	m.contract = common.HexToAddress(contract)
+	if gasLimit <= 0 {
+		return fmt.Errorf("%s: gas_limit should be positive", m.Name())
+	}
+	m.gasLimit = uint64(gasLimit)
+	if m.interval <= 0 {
+		return fmt.Errorf("%s: interval should be positive", m.Name())
+	}
+	if m.receiptTimeout <= 0 {
+		return fmt.Errorf("%s: receipt_timeout should be positive", m.Name())
+	}
+
+	var ok bool
+	m.proofs, ok = proofs.(module.MutableTable)
+	if !ok {
+		return fmt.Errorf("%s: proofs table should be mutable", m.Name())
+	}
+
+	key, err := crypto.LoadECDSA(keyFile)
+	if err != nil {
+		return fmt.Errorf("%s: failed to load the key: %w", m.Name(), err)
+	}
+	m.key = key
+
+	if !module.NoRun {
+		if err := m.loadPending(context.Background()); err != nil {
+			return fmt.Errorf("%s: %w", m.Name(), err)
+		}
+		m.stop = make(chan struct{})
+		m.done = make(chan struct{})
+		go m.submitLoop()
+	}
+	return nil
+}
+
+// Address returns the address submitting batch roots.
+func (m *Modifier) Address() common.Address {
+	return crypto.PubkeyToAddress(m.key.PublicKey)
+}
+
+// loadPending queues digests of messages that were not submitted before the
+// restart.
+func (m *Modifier) loadPending(ctx context.Context) error {
+	keys, err := m.proofs.Keys()
+	if err != nil {
+		return err
+	}
+	for _, key := range keys {
+		rec, ok, err := m.record(ctx, key)
+		if err != nil {
+			m.log.Error("malformed proof record", err, "digest", key)
+			continue
+		}
+		if !ok || rec.Root != "" {
+			continue
+		}
+		b, err := hex.DecodeString(key)
+		if err != nil || len(b) != 32 {
+			continue
+		}
+		m.pending[[32]byte(b)] = struct{}{}
+	}
+	if len(m.pending) != 0 {
+		m.log.Msg("queued messages from the previous run", "count", len(m.pending))
+	}
+	return nil
+}
+
+func (m *Modifier) record(ctx context.Context, key string) (*Record, bool, error) {
+	val, ok, err := m.proofs.Lookup(ctx, key)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	var rec Record
+	if err := json.Unmarshal([]byte(val), &rec); err != nil {
+		return nil, false, err
+	}
+	return &rec, true, nil
+}
+
+func (m *Modifier) saveRecord(key string, rec Record) error {
+	val, err := json.Marshal(rec)
+	if err != nil {
+		return err
+	}
+	return m.proofs.SetKey(key, string(val))
+}
+
+// enqueue adds the digest to the current batch unless the message is
+// already notarized.
+func (m *Modifier) enqueue(ctx context.Context, digest [32]byte) error {
+	key := hex.EncodeToString(digest[:])
+
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if _, ok := m.pending[digest]; ok {
+		return nil
+	}
+	_, ok, err := m.record(ctx, key)
+	if err != nil {
+		return err
+	}
+	if ok {
+		return nil
+	}
+	if err := m.saveRecord(key, Record{Queued: time.Now()}); err != nil {
+		return err
+	}
+	m.pending[digest] = struct{}{}
+	return nil
+}
+
+func (m *Modifier) submitLoop() {
+	defer close(m.done)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	go func() {
+		<-m.stop
+		cancel()
+	}()
+
+	ticker := time.NewTicker(m.interval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ticker.C:
+			m.submit(ctx)
+		case <-m.stop:
+			return
+		}
+	}
+}
+
+// submit notarizes the current batch. Inclusion proofs are saved only once
+// the transaction is included in a block, the batch is kept for the next
+// attempt otherwise.
+func (m *Modifier) submit(ctx context.Context) {
+	m.lck.Lock()
+	digests := make([][32]byte, 0, len(m.pending))
+	for d := range m.pending {
+		digests = append(digests, d)
+	}
+	m.pending = map[[32]byte]struct{}{}
+	m.lck.Unlock()
+	if len(digests) == 0 {
+		return
+	}
+	sort.Slice(digests, func(i, j int) bool {
+		return bytes.Compare(digests[i][:], digests[j][:]) < 0
+	})
+
+	root, proofs := buildTree(digests)
+	txHash, err := m.notarizeRoot(ctx, root)
+	if err != nil {
+		m.log.Error("failed to submit the batch root, retrying on the next interval", err,
+			"root", hexutil.Encode(root[:]), "count", len(digests))
+		m.lck.Lock()
+		for _, d := range digests {
+			m.pending[d] = struct{}{}
+		}
+		m.lck.Unlock()
+		return
+	}
+	m.log.Msg("batch root notarized", "root", hexutil.Encode(root[:]), "tx", txHash, "count", len(digests))
+
+	for i, d := range digests {
+		key := hex.EncodeToString(d[:])
+		rec, _, err := m.record(ctx, key)
+		if err != nil || rec == nil {
+			rec = &Record{}
+		}
+		rec.Root = hexutil.Encode(root[:])
+		rec.Tx = txHash
+		rec.Proof = make([]string, 0, len(proofs[i]))
+		for _, p := range proofs[i] {
+			rec.Proof = append(rec.Proof, hexutil.Encode(p[:]))
+		}
+		if err := m.saveRecord(key, *rec); err != nil {
+			m.log.Error("failed to save the inclusion proof", err, "digest", key, "root", rec.Root)
+		}
+	}
+}
+
+// notarizeRoot submits the root and waits for the transaction to be
+// included in a block. It returns the transaction hash, which is empty if the
+// root was already recorded by an earlier attempt that timed out.
+func (m *Modifier) notarizeRoot(ctx context.Context, root [32]byte) (string, error) {
+	timestamp, _, err := m.rootRecord(ctx, root)
+	if err != nil {
+		return "", err
+	}
+	if timestamp != 0 {
+		return "", nil
+	}
+
+	txHash, err := m.sendRoot(ctx, root)
+	if err != nil {
+		return "", err
+	}
+	receipt, err := module.WaitReceipt(ctx, m.chain, txHash.Hex(), m.receiptTimeout, m.pollInterval)
+	if err != nil {
+		return "", fmt.Errorf("tx %s: %w", txHash.Hex(), err)
+	}
+	if !receipt.Success {
+		return "", fmt.Errorf("tx %s is reverted", txHash.Hex())
+	}
+	return txHash.Hex(), nil
+}
+
+// rootRecord returns the on-chain record of the root submitted by the
+// server. timestamp is zero if there is no such record.
+func (m *Modifier) rootRecord(ctx context.Context, root [32]byte) (timestamp, block uint64, err error) {
+	data, err := parsedABI.Pack("roots", m.Address(), root)
+	if err != nil {
+		return 0, 0, err
+	}
+	res, err := m.chain.CallContract(ctx, m.contract.Hex(), hexutil.Encode(data))
+	if err != nil {
+		return 0, 0, err
+	}
+	out, err := hexutil.Decode(res)
+	if err != nil {
+		return 0, 0, err
+	}
+	vals, err := parsedABI.Unpack("roots", out)
+	if err != nil {
+		return 0, 0, err
+	}
+	return vals[0].(uint64), vals[1].(uint64), nil
+}
+
+func (m *Modifier) sendRoot(ctx context.Context, root [32]byte) (common.Hash, error) {
+	data, err := parsedABI.Pack("notarize", root)
+	if err != nil {
+		return common.Hash{}, err
+	}
+	chainID, err := m.chain.ChainID(ctx)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("chain ID: %w", err)
+	}
+	nonce, err := m.chain.PendingNonce(ctx, m.Address().Hex())
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("nonce: %w", err)
+	}
+	gasPrice, err := m.chain.SuggestGasPrice(ctx)
+	if err != nil {
+		return common.Hash{}, fmt.Errorf("gas price: %w", err)
+	}
+
+	tx, err := types.SignNewTx(m.key, types.LatestSignerForChainID(chainID), &types.LegacyTx{
+		Nonce:    nonce,
+		GasPrice: gasPrice,
+		Gas:      m.gasLimit,
+		To:       &m.contract,
+		Data:     data,
+	})
+	if err != nil {
+		return common.Hash{}, err
+	}
+	raw, err := tx.MarshalBinary()
+	if err != nil {
+		return common.Hash{}, err
+	}
+	if err := m.chain.SendRawTx(ctx, hexutil.Encode(raw)); err != nil {
+		return common.Hash{}, err
+	}
+	return tx.Hash(), nil
+}
+
+// Verification is the result of the successful verification.
+type Verification struct {
+	Record    Record
+	Submitter common.Address
+	Time      time.Time
+	Block     uint64
+}
+
+// Verify checks the inclusion proof of the message with the digest and the
+// on-chain record of the batch root made by the server address.
+func (m *Modifier) Verify(ctx context.Context, digest [32]byte) (*Verification, error) {
+	rec, ok, err := m.record(ctx, hex.EncodeToString(digest[:]))
+	if err != nil {
+		return nil, err
+	}
+	if !ok {
+		return nil, ErrNotNotarized
+	}
+	if rec.Root == "" {
+		return nil, ErrPending
+	}
+
+	proof := make([][32]byte, 0, len(rec.Proof))
+	for _, p := range rec.Proof {
+		b, err := hexutil.Decode(p)
+		if err != nil || len(b) != 32 {
+			return nil, ErrBadProof
+		}
+		proof = append(proof, [32]byte(b))
+	}
+	root := proofRoot(digest, proof)
+	if hexutil.Encode(root[:]) != rec.Root {
+		return nil, ErrBadProof
+	}
+
+	timestamp, block, err := m.rootRecord(ctx, root)
+	if err != nil {
+		return nil, err
+	}
+	if timestamp == 0 {
+		return nil, ErrNotOnChain
+	}
+	return &Verification{
+		Record:    *rec,
+		Submitter: m.Address(),
+		Time:      time.Unix(int64(timestamp), 0),
+		Block:     block,
+	}, nil
+}
+
+func (m *Modifier) Close() error {
+	if m.stop != nil {
+		close(m.stop)
+		<-m.done
+		m.stop = nil
+	}
+	return nil
+}
+
+type state struct {
+	m   *Modifier
+	log log.Logger
+}
+
+func (m *Modifier) ModStateForMsg(ctx context.Context, msgMeta *module.MsgMetadata) (module.ModifierState, error) {
+	return state{
+		m:   m,
+		log: target.DeliveryLogger(m.log, msgMeta),
+	}, nil
+}
+
+func (s state) RewriteSender(ctx context.Context, mailFrom string) (string, error) {
+	return mailFrom, nil
+}
+
+func (s state) RewriteRcpt(ctx context.Context, rcptTo string) ([]string, error) {
+	return []string{rcptTo}, nil
+}
+
+func (s state) RewriteBody(ctx context.Context, h *textproto.Header, body buffer.Buffer) error {
+	defer trace.StartRegion(ctx, "modify.notarize/RewriteBody").End()
+
+	h.Del(notarizationHeader)
+
+	r, err := body.Open()
+	if err != nil {
+		return err
+	}
+	defer r.Close()
+	digest, err := Digest(*h, r)
+	if err != nil {
+		return err
+	}
+
+	// Notarization failure should not prevent the delivery, the header
+	// field is added only if the message is queued.
+	if err := s.m.enqueue(ctx, digest); err != nil {
+		s.log.Error("failed to queue the message for notarization", err)
+		return nil
+	}
+	h.Add(notarizationHeader, fmt.Sprintf("digest=%x; contract=%s", digest, s.m.contract.Hex()))
+	s.log.DebugMsg("message queued for notarization", "digest", hex.EncodeToString(digest[:]))
+	return nil
+}
+
+func (s state) Close() error {
+	return nil
+}
+
+func init() {
+	module.Register("modify.notarize", New)
+}
@@ -0,0 +1,430 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package notarize
+
+import (
+	"context"
+	"crypto/sha256"
+	"errors"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/table"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+var (
+	notaryAddr = common.HexToAddress("0x000000000000000000000000000000000000a07a")
+	chainID    = big.NewInt(26000)
+)
+
+type rootKey struct {
+	submitter common.Address
+	root      [32]byte
+}
+
+type rootRecord struct {
+	timestamp uint64
+	block     uint64
+}
+
+// fakeChain executes Notary contract calls in memory. If hold is set, sent
+// transactions stay in the mempool until mine is called. If revert is set,
+// sent transactions are included but reverted.
+type fakeChain struct {
+	roots    map[rootKey]rootRecord
+	sent     []*types.Transaction
+	mempool  []*types.Transaction
+	receipts map[string]*module.TxReceipt
+	fail     bool
+	hold     bool
+	revert   bool
+}
+
+func newFakeChain() *fakeChain {
+	return &fakeChain{
+		roots:    map[rootKey]rootRecord{},
+		receipts: map[string]*module.TxReceipt{},
+	}
+}
+
+func (f *fakeChain) mine() {
+	for _, tx := range f.mempool {
+		f.execute(tx)
+	}
+	f.mempool = nil
+}
+
+func (f *fakeChain) execute(tx *types.Transaction) {
+	receipt := &module.TxReceipt{TxHash: tx.Hash().Hex(), BlockNumber: uint64(len(f.receipts) + 1)}
+	f.receipts[tx.Hash().Hex()] = receipt
+	if f.revert {
+		return
+	}
+	sender, _ := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	args, _ := parsedABI.Methods["notarize"].Inputs.Unpack(tx.Data()[4:])
+	key := rootKey{submitter: sender, root: args[0].([32]byte)}
+	if _, ok := f.roots[key]; ok {
+		return
+	}
+	f.roots[key] = rootRecord{timestamp: uint64(time.Now().Unix()), block: receipt.BlockNumber}
+	receipt.Success = true
+}
+
+func (f *fakeChain) SendRawTx(_ context.Context, rawTx string) error {
+	if f.fail {
+		return errors.New("connection refused")
+	}
+	raw, err := hexutil.Decode(rawTx)
+	if err != nil {
+		return err
+	}
+	tx := new(types.Transaction)
+	if err := tx.UnmarshalBinary(raw); err != nil {
+		return err
+	}
+	sender, err := types.Sender(types.LatestSignerForChainID(chainID), tx)
+	if err != nil {
+		return err
+	}
+	args, err := parsedABI.Methods["notarize"].Inputs.Unpack(tx.Data()[4:])
+	if err != nil {
+		return err
+	}
+	if _, ok := f.roots[rootKey{submitter: sender, root: args[0].([32]byte)}]; ok {
+		return errors.New("execution reverted: AlreadyNotarized")
+	}
+	f.sent = append(f.sent, tx)
+	if f.hold {
+		f.mempool = append(f.mempool, tx)
+		return nil
+	}
+	f.execute(tx)
+	return nil
+}
+
+func (f *fakeChain) ChainType(context.Context) string { return "evm" }
+
+func (f *fakeChain) CheckSign(context.Context, string, string, string) (bool, error) {
+	return false, nil
+}
+
+func (f *fakeChain) ChainID(context.Context) (*big.Int, error) { return chainID, nil }
+
+func (f *fakeChain) CallContract(_ context.Context, to, data string) (string, error) {
+	input, err := hexutil.Decode(data)
+	if err != nil {
+		return "", err
+	}
+	method := parsedABI.Methods["roots"]
+	args, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return "", err
+	}
+	var r rootRecord
+	if common.HexToAddress(to) == notaryAddr {
+		r = f.roots[rootKey{submitter: args[0].(common.Address), root: args[1].([32]byte)}]
+	}
+	out, err := method.Outputs.Pack(r.timestamp, r.block)
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(out), nil
+}
+
+func (f *fakeChain) PendingNonce(context.Context, string) (uint64, error) {
+	return uint64(len(f.sent)), nil
+}
+
+func (f *fakeChain) SuggestGasPrice(context.Context) (*big.Int, error) {
+	return big.NewInt(1), nil
+}
+
+func (f *fakeChain) TransactionReceipt(_ context.Context, txHash string) (*module.TxReceipt, error) {
+	receipt, ok := f.receipts[txHash]
+	if !ok {
+		return nil, module.ErrTxPending
+	}
+	return receipt, nil
+}
+
+func testModifier(t *testing.T, chain module.BlockChain, proofs module.MutableTable) *Modifier {
+	t.Helper()
+	key, err := crypto.GenerateKey()
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &Modifier{
+		log:      testutils.Logger(t, "modify.notarize"),
+		chain:    chain,
+		contract: notaryAddr,
+		key:      key,
+		gasLimit: 100000,
+		proofs:   proofs,
+		pending:  map[[32]byte]struct{}{},
+
+		receiptTimeout: 50 * time.Millisecond,
+		pollInterval:   10 * time.Millisecond,
+	}
+}
+
+func testMessage(t *testing.T, m *Modifier, subject, body string) (textproto.Header, [32]byte) {
+	t.Helper()
+	ctx := context.Background()
+	st, err := m.ModStateForMsg(ctx, &module.MsgMetadata{ID: "msg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	h := textproto.Header{}
+	h.Add("From", "alice@example.org")
+	h.Add("Subject", subject)
+	if err := st.RewriteBody(ctx, &h, buffer.MemoryBuffer{Slice: []byte(body)}); err != nil {
+		t.Fatal(err)
+	}
+	digest, err := Digest(h, strings.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return h, digest
+}
+
+func TestMerkleProofs(t *testing.T) {
+	for _, n := range []int{1, 2, 3, 5, 8} {
+		digests := make([][32]byte, n)
+		for i := range digests {
+			digests[i] = sha256.Sum256([]byte{byte(i)})
+		}
+		root, proofs := buildTree(digests)
+		for i, d := range digests {
+			if got := proofRoot(d, proofs[i]); got != root {
+				t.Errorf("n=%d, leaf %d: proof does not lead to the root", n, i)
+			}
+		}
+		other := sha256.Sum256([]byte("other"))
+		if got := proofRoot(other, proofs[0]); got == root {
+			t.Errorf("n=%d: foreign digest is accepted", n)
+		}
+	}
+}
+
+func TestDigest(t *testing.T) {
+	digest := func(hdr, body string) [32]byte {
+		t.Helper()
+		h := textproto.Header{}
+		for _, line := range strings.Split(hdr, "\n") {
+			name, value, _ := strings.Cut(line, ":")
+			h.Add(name, value)
+		}
+		d, err := Digest(h, strings.NewReader(body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		return d
+	}
+
+	base := digest("From: alice@example.org\nSubject: Hello", "Hi there\r\n")
+	if d := digest("From:  alice@example.org\nSubject: Hello  ", "Hi   there\r\n\r\n"); d != base {
+		t.Error("whitespace changes alter the digest")
+	}
+	if d := digest("Received: from mx.example.org\nFrom: alice@example.org\nSubject: Hello", "Hi there\r\n"); d != base {
+		t.Error("trace header fields alter the digest")
+	}
+	if d := digest("From: alice@example.org\nSubject: Hello!", "Hi there\r\n"); d == base {
+		t.Error("subject change is not detected")
+	}
+	if d := digest("From: alice@example.org\nSubject: Hello", "Hi here\r\n"); d == base {
+		t.Error("body change is not detected")
+	}
+}
+
+func TestNotarize(t *testing.T) {
+	ctx := context.Background()
+	chain := newFakeChain()
+	m := testModifier(t, chain, table.NewMemory())
+
+	h, first := testMessage(t, m, "First", "Hello\r\n")
+	if !strings.Contains(h.Get(notarizationHeader), hexutil.Encode(first[:])[2:]) {
+		t.Fatalf("header field is missing: %q", h.Get(notarizationHeader))
+	}
+	_, second := testMessage(t, m, "Second", "Hello\r\n")
+	_, third := testMessage(t, m, "Third", "Hello\r\n")
+	testMessage(t, m, "First", "Hello\r\n")
+	if len(m.pending) != 3 {
+		t.Fatalf("expected 3 queued messages, got %d", len(m.pending))
+	}
+
+	if _, err := m.Verify(ctx, first); !errors.Is(err, ErrPending) {
+		t.Fatalf("expected ErrPending, got %v", err)
+	}
+
+	m.submit(ctx)
+	if len(chain.sent) != 1 {
+		t.Fatalf("expected 1 transaction, got %d", len(chain.sent))
+	}
+	for _, d := range [][32]byte{first, second, third} {
+		v, err := m.Verify(ctx, d)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if v.Submitter != m.Address() || v.Record.Tx != chain.sent[0].Hash().Hex() {
+			t.Errorf("unexpected verification result: %+v", v)
+		}
+	}
+
+	// Already notarized messages are not queued again.
+	testMessage(t, m, "Second", "Hello\r\n")
+	if len(m.pending) != 0 {
+		t.Fatal("notarized message is queued again")
+	}
+
+	if _, err := m.Verify(ctx, sha256.Sum256([]byte("unknown"))); !errors.Is(err, ErrNotNotarized) {
+		t.Fatalf("expected ErrNotNotarized, got %v", err)
+	}
+
+	other := testModifier(t, chain, m.proofs)
+	if _, err := other.Verify(ctx, first); !errors.Is(err, ErrNotOnChain) {
+		t.Fatalf("expected ErrNotOnChain, got %v", err)
+	}
+}
+
+func TestNotarizeHeader(t *testing.T) {
+	m := testModifier(t, newFakeChain(), table.NewMemory())
+	st, err := m.ModStateForMsg(context.Background(), &module.MsgMetadata{ID: "msg"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer st.Close()
+
+	h := textproto.Header{}
+	h.Add("From", "alice@example.org")
+	h.Add(notarizationHeader, "digest=00; contract=0x0000000000000000000000000000000000000000")
+	if err := st.RewriteBody(context.Background(), &h, buffer.MemoryBuffer{Slice: []byte("Hello\r\n")}); err != nil {
+		t.Fatal(err)
+	}
+	if vals := h.Values(notarizationHeader); len(vals) != 1 || !strings.Contains(vals[0], notaryAddr.Hex()) {
+		t.Fatalf("sender-supplied header field is kept: %q", vals)
+	}
+}
+
+func TestNotarizeReceipt(t *testing.T) {
+	ctx := context.Background()
+	chain := newFakeChain()
+	m := testModifier(t, chain, table.NewMemory())
+	_, digest := testMessage(t, m, "Subject", "Body\r\n")
+
+	// Reverted transaction.
+	chain.revert = true
+	m.submit(ctx)
+	if len(m.pending) != 1 {
+		t.Fatal("reverted batch is not requeued")
+	}
+	if _, err := m.Verify(ctx, digest); !errors.Is(err, ErrPending) {
+		t.Fatalf("expected ErrPending, got %v", err)
+	}
+	chain.revert = false
+
+	// Transaction is not included before receipt_timeout.
+	chain.hold = true
+	m.submit(ctx)
+	if len(m.pending) != 1 {
+		t.Fatal("unconfirmed batch is not requeued")
+	}
+	if _, err := m.Verify(ctx, digest); !errors.Is(err, ErrPending) {
+		t.Fatalf("expected ErrPending, got %v", err)
+	}
+
+	// Included later, the next attempt saves the proofs without sending
+	// the root again.
+	chain.mine()
+	sent := len(chain.sent)
+	m.submit(ctx)
+	if len(chain.sent) != sent {
+		t.Fatal("root recorded on chain is sent again")
+	}
+	if len(m.pending) != 0 {
+		t.Fatal("notarized batch is requeued")
+	}
+	if _, err := m.Verify(ctx, digest); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestNotarizeFrontRun(t *testing.T) {
+	ctx := context.Background()
+	chain := newFakeChain()
+	m := testModifier(t, chain, table.NewMemory())
+	_, digest := testMessage(t, m, "Subject", "Body\r\n")
+
+	// Another account records the same root first.
+	root, _ := buildTree([][32]byte{digest})
+	attacker := testModifier(t, chain, table.NewMemory())
+	if _, err := attacker.sendRoot(ctx, root); err != nil {
+		t.Fatal(err)
+	}
+
+	m.submit(ctx)
+	v, err := m.Verify(ctx, digest)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.Submitter != m.Address() {
+		t.Errorf("unexpected submitter: %s", v.Submitter.Hex())
+	}
+}
+
+func TestNotarizeRetry(t *testing.T) {
+	ctx := context.Background()
+	chain := newFakeChain()
+	chain.fail = true
+	proofs := table.NewMemory()
+	m := testModifier(t, chain, proofs)
+
+	_, digest := testMessage(t, m, "Subject", "Body\r\n")
+	m.submit(ctx)
+	if len(m.pending) != 1 {
+		t.Fatal("failed batch is not requeued")
+	}
+
+	// Queued messages survive the restart.
+	restarted := testModifier(t, chain, proofs)
+	restarted.key = m.key
+	if err := restarted.loadPending(ctx); err != nil {
+		t.Fatal(err)
+	}
+	if len(restarted.pending) != 1 {
+		t.Fatal("queued message is lost on restart")
+	}
+
+	chain.fail = false
+	restarted.submit(ctx)
+	if _, err := restarted.Verify(ctx, digest); err != nil {
+		t.Fatal(err)
+	}
+}
@@ -30,7 +30,9 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 	"github.com/mail-chat-chain/mailchatd/internal/dmarc"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // checkRunner runs groups of checks, collects and merges results.
@@ -51,7 +53,8 @@ type checkRunner struct {
 
 	log log.Logger
 
-	states map[module.Check]module.CheckState
+	states     map[module.Check]module.CheckState
+	stateNames map[module.CheckState]string
 
 	mergedRes module.CheckResult
 }
@@ -64,6 +67,7 @@ func newCheckRunner(msgMeta *module.MsgMetadata, log log.Logger, r dns.Resolver)
 		resolver:             r,
 		dmarcVerify:          dmarc.NewVerifier(r),
 		states:               make(map[module.Check]module.CheckState),
+		stateNames:           make(map[module.CheckState]string),
 	}
 }
 
@@ -93,6 +97,7 @@ func (cr *checkRunner) checkStates(ctx context.Context, checks []module.Check) (
 		states = append(states, state)
 		newStates = append(newStates, state)
 		newStatesMap[check] = state
+		cr.stateNames[state] = objectName(check)
 	}
 
 	if len(newStates) == 0 {
@@ -105,7 +110,7 @@ func (cr *checkRunner) checkStates(ctx context.Context, checks []module.Check) (
 	// Done outside of check loop above to make sure we can run these for multiple
 	// checks in parallel.
 	if cr.mailFromReceived {
-		err := cr.runAndMergeResults(newStates, func(s module.CheckState) module.CheckResult {
+		err := cr.runAndMergeResults(ctx, "connection", newStates, func(ctx context.Context, s module.CheckState) module.CheckResult {
 			res := s.CheckConnection(ctx)
 			return res
 		})
@@ -113,7 +118,7 @@ func (cr *checkRunner) checkStates(ctx context.Context, checks []module.Check) (
 			closeStates()
 			return nil, err
 		}
-		err = cr.runAndMergeResults(newStates, func(s module.CheckState) module.CheckResult {
+		err = cr.runAndMergeResults(ctx, "sender", newStates, func(ctx context.Context, s module.CheckState) module.CheckResult {
 			res := s.CheckSender(ctx, cr.mailFrom)
 			return res
 		})
@@ -125,7 +130,7 @@ func (cr *checkRunner) checkStates(ctx context.Context, checks []module.Check) (
 
 	if len(cr.checkedRcpts) != 0 {
 		for _, rcpt := range cr.checkedRcpts {
-			err := cr.runAndMergeResults(states, func(s module.CheckState) module.CheckResult {
+			err := cr.runAndMergeResults(ctx, "rcpt", states, func(ctx context.Context, s module.CheckState) module.CheckResult {
 				// Avoid calling CheckRcpt for the same recipient for the same check
 				// multiple times, even if requested.
 				cr.checkedRcptsLock.Lock()
@@ -158,7 +163,9 @@ func (cr *checkRunner) checkStates(ctx context.Context, checks []module.Check) (
 	return states, nil
 }
 
-func (cr *checkRunner) runAndMergeResults(states []module.CheckState, runner func(module.CheckState) module.CheckResult) error {
+// runAndMergeResults runs the runner for each state in parallel. stage is the
+// name of the check stage used in tracing spans.
+func (cr *checkRunner) runAndMergeResults(ctx context.Context, stage string, states []module.CheckState, runner func(context.Context, module.CheckState) module.CheckResult) error {
 	data := struct {
 		authResLock sync.Mutex
 		headerLock  sync.Mutex
@@ -185,7 +192,16 @@ func (cr *checkRunner) runAndMergeResults(states []module.CheckState, runner fun
 				}
 			}()
 
-			subCheckRes := runner(state)
+			checkCtx, span := tracing.Start(ctx, "check."+stage,
+				attribute.String("check", cr.stateNames[state]))
+			subCheckRes := runner(checkCtx, state)
+			switch {
+			case subCheckRes.Reject:
+				tracing.RecordError(span, subCheckRes.Reason)
+			case subCheckRes.Quarantine:
+				span.SetAttributes(attribute.Bool("check.quarantine", true))
+			}
+			span.End()
 
 			// We check the length because we don't want to take locks
 			// when it is not necessary.
@@ -251,7 +267,7 @@ func (cr *checkRunner) checkRcpt(ctx context.Context, checks []module.Check, rcp
 		return err
 	}
 
-	err = cr.runAndMergeResults(states, func(s module.CheckState) module.CheckResult {
+	err = cr.runAndMergeResults(ctx, "rcpt", states, func(ctx context.Context, s module.CheckState) module.CheckResult {
 		cr.checkedRcptsLock.Lock()
 		if _, ok := cr.checkedRcptsPerCheck[s][rcptTo]; ok {
 			cr.checkedRcptsLock.Unlock()
@@ -282,7 +298,7 @@ func (cr *checkRunner) checkBody(ctx context.Context, checks []module.Check, hea
 		cr.didDMARCFetch = true
 	}
 
-	return cr.runAndMergeResults(states, func(s module.CheckState) module.CheckResult {
+	return cr.runAndMergeResults(ctx, "body", states, func(ctx context.Context, s module.CheckState) module.CheckResult {
 		res := s.CheckBody(ctx, header, body)
 		return res
 	})
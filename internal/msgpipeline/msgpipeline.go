@@ -30,8 +30,10 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 	"github.com/mail-chat-chain/mailchatd/internal/modify"
 	"github.com/mail-chat-chain/mailchatd/internal/target"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/sync/errgroup"
 )
 
@@ -217,6 +219,7 @@ func (dd *msgpipelineDelivery) srcBlockForAddr(ctx context.Context, mailFrom str
 		if !ok {
 			continue
 		}
+		routeEvent(ctx, "source", mailFrom, "source_in")
 		return srcIn.block, nil
 	}
 
@@ -245,11 +248,14 @@ func (dd *msgpipelineDelivery) srcBlockForAddr(ctx context.Context, mailFrom str
 			// Fallback to the default source block.
 			srcBlock = dd.d.defaultSource
 			dd.log.Debugf("sender %s matched by default rule", mailFrom)
+			routeEvent(ctx, "source", mailFrom, "default")
 		} else {
 			dd.log.Debugf("sender %s matched by domain rule '%s'", mailFrom, domain)
+			routeEvent(ctx, "source", mailFrom, "domain "+domain)
 		}
 	} else {
 		dd.log.Debugf("sender %s matched by address rule '%s'", mailFrom, cleanFrom)
+		routeEvent(ctx, "source", mailFrom, "address "+cleanFrom)
 	}
 	return srcBlock, nil
 }
@@ -561,6 +567,7 @@ func (dd *msgpipelineDelivery) rcptBlockForAddr(ctx context.Context, rcptTo stri
 		if !ok {
 			continue
 		}
+		routeEvent(ctx, "destination", rcptTo, "destination_in")
 		return rcptIn.block, nil
 	}
 
@@ -586,15 +593,25 @@ func (dd *msgpipelineDelivery) rcptBlockForAddr(ctx context.Context, rcptTo stri
 			// Fallback to the default source block.
 			rcptBlock = dd.sourceBlock.defaultRcpt
 			dd.log.Debugf("recipient %s matched by default rule (clean = %s)", rcptTo, cleanRcpt)
+			routeEvent(ctx, "destination", rcptTo, "default")
 		} else {
 			dd.log.Debugf("recipient %s matched by domain rule '%s'", rcptTo, domain)
+			routeEvent(ctx, "destination", rcptTo, "domain "+domain)
 		}
 	} else {
 		dd.log.Debugf("recipient %s matched by address rule '%s'", rcptTo, cleanRcpt)
+		routeEvent(ctx, "destination", rcptTo, "address "+cleanRcpt)
 	}
 	return rcptBlock, nil
 }
 
+// routeEvent records the routing decision in the current tracing span.
+func routeEvent(ctx context.Context, kind, addr, rule string) {
+	tracing.Event(ctx, "route."+kind,
+		attribute.String("address", addr),
+		attribute.String("rule", rule))
+}
+
 func (dd *msgpipelineDelivery) getRcptModifiers(ctx context.Context, rcptBlock *rcptBlock, rcptTo string) (module.ModifierState, error) {
 	rcptModifiersState, ok := dd.rcptModifiersState[rcptBlock]
 	if ok {
@@ -0,0 +1,615 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package encrypted implements the blob store wrapper that encrypts message
+// bodies at rest.
+//
+// Each blob is encrypted using the random data key. The data key is wrapped
+// to the X25519 keys of the accounts storing the message and kept in the
+// separate blob with the ".keys" suffix. Accounts without the published key
+// use the server key-encryption key (KEK) instead.
+//
+// The store does not know the recipients when the message is written, so
+// new blobs are wrapped to the KEK and re-wrapped to the account keys by
+// SealPending once the storage knows where the message is stored.
+//
+// Blobs stored before the encryption was enabled are read as is until they
+// are encrypted by Rewrap.
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"crypto/ecdh"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/blockchain"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+const (
+	keysSuffix = ".keys"
+
+	// pendingTimeout is the time after which the blob that is not stored
+	// by any account is no longer re-wrapped, e.g. if the delivery failed.
+	pendingTimeout = 10 * time.Minute
+)
+
+var ErrBadBinding = errors.New("blob_store: the key is not signed by the account address")
+
+type Store struct {
+	instName string
+	log      log.Logger
+
+	base      module.BlobStore
+	keys      module.Table
+	chains    map[string]module.SignatureVerifier
+	unlockTTL time.Duration
+
+	kekPath string
+	kekLck  sync.Mutex
+	keks    []*ecdh.PrivateKey
+	kekMod  time.Time
+
+	pendingLck sync.Mutex
+	pending    map[string]time.Time
+}
+
+func New(_, instName string, _, _ []string) (module.Module, error) {
+	return &Store{
+		instName: instName,
+		log:      log.Logger{Name: "storage.blob.encrypted"},
+		pending:  map[string]time.Time{},
+	}, nil
+}
+
+func (s *Store) Name() string {
+	return "storage.blob.encrypted"
+}
+
+func (s *Store) InstanceName() string {
+	return s.instName
+}
+
+func (s *Store) Init(cfg *config.Map) error {
+	cfg.Bool("debug", true, false, &s.log.Debug)
+	cfg.Custom("store", false, true, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var store module.BlobStore
+		err := modconfig.ModuleFromNode("storage.blob", node.Args, node, m.Globals, &store)
+		return store, err
+	}, &s.base)
+	cfg.String("kek_file", false, false, filepath.Join(config.StateDirectory, "blob_kek.key"), &s.kekPath)
+	cfg.Custom("keys", false, false, func() (interface{}, error) {
+		return nil, nil
+	}, modconfig.TableDirective, &s.keys)
+	cfg.Custom("blockchain", false, false, func() (interface{}, error) {
+		return map[string]module.SignatureVerifier{}, nil
+	}, blockchain.VerifiersDirective, &s.chains)
+	cfg.Duration("unlock_ttl", false, false, time.Hour, &s.unlockTTL)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	if s.unlockTTL <= 0 || s.unlockTTL > maxUnlockTTL {
+		return fmt.Errorf("%s: unlock_ttl should be between 0 and %v", s.Name(), maxUnlockTTL)
+	}
+
+	if _, err := os.Stat(s.kekPath); errors.Is(err, os.ErrNotExist) {
+		s.log.Msg("generating the key-encryption key", "path", s.kekPath)
+		if err := s.writeKEKs([]*ecdh.PrivateKey{}, true); err != nil {
+			return fmt.Errorf("%s: %w", s.Name(), err)
+		}
+	}
+	if _, err := s.currentKEKs(); err != nil {
+		return fmt.Errorf("%s: %w", s.Name(), err)
+	}
+	return nil
+}
+
+func loadKEKs(path string) ([]*ecdh.PrivateKey, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var keks []*ecdh.PrivateKey
+	for _, line := range strings.Split(string(data), "\n") {
+		line = strings.TrimSpace(line)
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		b, err := base64.StdEncoding.DecodeString(line)
+		if err != nil {
+			return nil, fmt.Errorf("malformed key in %s: %w", path, err)
+		}
+		kek, err := ecdh.X25519().NewPrivateKey(b)
+		if err != nil {
+			return nil, fmt.Errorf("malformed key in %s: %w", path, err)
+		}
+		keks = append(keks, kek)
+	}
+	if len(keks) == 0 {
+		return nil, fmt.Errorf("no keys in %s", path)
+	}
+	return keks, nil
+}
+
+// currentKEKs returns the key-encryption keys, the first one is used to wrap
+// new keys. The file is reloaded if it was changed, e.g. by the key
+// rotation.
+func (s *Store) currentKEKs() ([]*ecdh.PrivateKey, error) {
+	s.kekLck.Lock()
+	defer s.kekLck.Unlock()
+
+	info, err := os.Stat(s.kekPath)
+	if err != nil {
+		if s.keks != nil {
+			s.log.Error("failed to check the key-encryption key file, using the loaded keys", err)
+			return s.keks, nil
+		}
+		return nil, err
+	}
+	if s.keks != nil && info.ModTime().Equal(s.kekMod) {
+		return s.keks, nil
+	}
+	keks, err := loadKEKs(s.kekPath)
+	if err != nil {
+		if s.keks != nil {
+			s.log.Error("failed to reload the key-encryption key file, using the loaded keys", err)
+			return s.keks, nil
+		}
+		return nil, err
+	}
+	s.keks = keks
+	s.kekMod = info.ModTime()
+	return keks, nil
+}
+
+// writeKEKs atomically replaces the key-encryption key file. A new current
+// key is generated if generate is set.
+func (s *Store) writeKEKs(keks []*ecdh.PrivateKey, generate bool) error {
+	if generate {
+		kek, err := ecdh.X25519().GenerateKey(rand.Reader)
+		if err != nil {
+			return err
+		}
+		keks = append([]*ecdh.PrivateKey{kek}, keks...)
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString("# The first key is used to encrypt new messages, the rest are kept for\n")
+	buf.WriteString("# reading messages encrypted before the key rotation.\n")
+	for _, kek := range keks {
+		buf.WriteString(base64.StdEncoding.EncodeToString(kek.Bytes()))
+		buf.WriteString("\n")
+	}
+
+	if err := os.MkdirAll(filepath.Dir(s.kekPath), 0o700); err != nil {
+		return err
+	}
+	tmp := s.kekPath + ".tmp"
+	if err := os.WriteFile(tmp, buf.Bytes(), 0o600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, s.kekPath)
+}
+
+// RotateKEK generates the new key-encryption key used for new messages.
+// Old keys are kept in the file to read existing messages until they are
+// re-wrapped.
+func (s *Store) RotateKEK() error {
+	keks, err := s.currentKEKs()
+	if err != nil {
+		return err
+	}
+	if err := s.writeKEKs(keks, true); err != nil {
+		return err
+	}
+	s.kekLck.Lock()
+	s.keks = nil
+	s.kekLck.Unlock()
+	_, err = s.currentKEKs()
+	return err
+}
+
+// KEKPath returns the path of the key-encryption key file.
+func (s *Store) KEKPath() string {
+	return s.kekPath
+}
+
+func (s *Store) accountKey(ctx context.Context, account string) (*ecdh.PublicKey, bool, error) {
+	if s.keys == nil {
+		return nil, false, nil
+	}
+	val, ok, err := s.keys.Lookup(ctx, account)
+	if err != nil || !ok {
+		return nil, false, err
+	}
+	pub, err := DecodePublicKey(val)
+	if err != nil {
+		return nil, false, fmt.Errorf("%s: %w", account, err)
+	}
+	return pub, true, nil
+}
+
+// recipients returns the stanzas without the wrapped key for the accounts.
+func (s *Store) recipients(ctx context.Context, accounts []string) ([]stanza, error) {
+	var (
+		res    []stanza
+		useKEK = len(accounts) == 0
+	)
+	for _, account := range accounts {
+		pub, ok, err := s.accountKey(ctx, account)
+		if err != nil {
+			return nil, err
+		}
+		if !ok {
+			useKEK = true
+			continue
+		}
+		res = append(res, stanza{Account: account, Recipient: pub.Bytes()})
+	}
+	if useKEK {
+		keks, err := s.currentKEKs()
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, stanza{Recipient: keks[0].PublicKey().Bytes()})
+	}
+	return res, nil
+}
+
+func (s *Store) wrap(dataKey []byte, rcpts []stanza) ([]stanza, error) {
+	res := make([]stanza, 0, len(rcpts))
+	for _, rcpt := range rcpts {
+		pub, err := ecdh.X25519().NewPublicKey(rcpt.Recipient)
+		if err != nil {
+			return nil, err
+		}
+		st, err := wrapKey(dataKey, rcpt.Account, pub)
+		if err != nil {
+			return nil, err
+		}
+		res = append(res, st)
+	}
+	return res, nil
+}
+
+// unwrap returns the data key using the KEK, the keys of authenticated
+// accounts or the additional keys.
+func (s *Store) unwrap(kf *keysFile, extra []*ecdh.PrivateKey) ([]byte, error) {
+	keks, err := s.currentKEKs()
+	if err != nil {
+		return nil, err
+	}
+	for _, st := range kf.Stanzas {
+		var candidates []*ecdh.PrivateKey
+		if st.Account == "" {
+			candidates = keks
+		} else if key := unlocked(st.Recipient, s.unlockTTL); key != nil {
+			candidates = []*ecdh.PrivateKey{key}
+		}
+		candidates = append(candidates, extra...)
+
+		for _, key := range candidates {
+			if !bytes.Equal(key.PublicKey().Bytes(), st.Recipient) {
+				continue
+			}
+			return unwrapKey(st, key)
+		}
+	}
+	return nil, ErrLocked
+}
+
+func (s *Store) readKeys(ctx context.Context, key string) (*keysFile, error) {
+	r, err := s.base.Open(ctx, key+keysSuffix)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	var kf keysFile
+	if err := json.NewDecoder(r).Decode(&kf); err != nil {
+		return nil, ErrCorrupted
+	}
+	if kf.Version != keysVersion {
+		return nil, fmt.Errorf("blob_store: unsupported keys version: %d", kf.Version)
+	}
+	return &kf, nil
+}
+
+func (s *Store) writeKeys(ctx context.Context, key string, stanzas []stanza) error {
+	data, err := json.Marshal(keysFile{Version: keysVersion, Stanzas: stanzas})
+	if err != nil {
+		return err
+	}
+	blob, err := s.base.Create(ctx, key+keysSuffix, int64(len(data)))
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	if _, err := blob.Write(data); err != nil {
+		return err
+	}
+	return blob.Sync()
+}
+
+func (s *Store) Create(ctx context.Context, key string, blobSize int64) (module.Blob, error) {
+	dataKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return nil, err
+	}
+	rcpts, err := s.recipients(ctx, nil)
+	if err != nil {
+		return nil, err
+	}
+	stanzas, err := s.wrap(dataKey, rcpts)
+	if err != nil {
+		return nil, err
+	}
+
+	blob, err := s.base.Create(ctx, key, encryptedSize(blobSize))
+	if err != nil {
+		return nil, err
+	}
+	w, err := newEncWriter(blob, dataKey, func() error {
+		if err := s.writeKeys(ctx, key, stanzas); err != nil {
+			return err
+		}
+		s.pendingLck.Lock()
+		s.pending[key] = time.Now()
+		s.pendingLck.Unlock()
+		return nil
+	})
+	if err != nil {
+		blob.Close()
+		return nil, err
+	}
+	return w, nil
+}
+
+func (s *Store) Open(ctx context.Context, key string) (io.ReadCloser, error) {
+	kf, err := s.readKeys(ctx, key)
+	if err != nil {
+		if errors.Is(err, module.ErrNoSuchBlob) {
+			// Stored before the encryption was enabled.
+			return s.base.Open(ctx, key)
+		}
+		return nil, err
+	}
+	dataKey, err := s.unwrap(kf, nil)
+	if err != nil {
+		return nil, err
+	}
+	r, err := s.base.Open(ctx, key)
+	if err != nil {
+		return nil, err
+	}
+	dr, err := newDecReader(r, dataKey)
+	if err != nil {
+		r.Close()
+		return nil, err
+	}
+	return dr, nil
+}
+
+func (s *Store) Delete(ctx context.Context, keys []string) error {
+	all := make([]string, 0, 2*len(keys))
+	s.pendingLck.Lock()
+	for _, key := range keys {
+		all = append(all, key, key+keysSuffix)
+		delete(s.pending, key)
+	}
+	s.pendingLck.Unlock()
+	return s.base.Delete(ctx, all)
+}
+
+func sameRecipients(stanzas, rcpts []stanza) bool {
+	if len(stanzas) != len(rcpts) {
+		return false
+	}
+	for _, rcpt := range rcpts {
+		found := false
+		for _, st := range stanzas {
+			if st.Account == rcpt.Account && bytes.Equal(st.Recipient, rcpt.Recipient) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			return false
+		}
+	}
+	return true
+}
+
+// Rewrap wraps the data key of the blob to the current keys of the accounts.
+// The data key is unwrapped using the KEK, keys of authenticated accounts
+// or the additional keys.
+func (s *Store) Rewrap(ctx context.Context, key string, accounts []string, extra ...*ecdh.PrivateKey) error {
+	rcpts, err := s.recipients(ctx, accounts)
+	if err != nil {
+		return err
+	}
+	kf, err := s.readKeys(ctx, key)
+	if err != nil {
+		if errors.Is(err, module.ErrNoSuchBlob) {
+			return s.encryptPlain(ctx, key, rcpts)
+		}
+		return err
+	}
+	if sameRecipients(kf.Stanzas, rcpts) {
+		return nil
+	}
+	dataKey, err := s.unwrap(kf, extra)
+	if err != nil {
+		return err
+	}
+	stanzas, err := s.wrap(dataKey, rcpts)
+	if err != nil {
+		return err
+	}
+	return s.writeKeys(ctx, key, stanzas)
+}
+
+// encryptPlain encrypts the blob stored before the encryption was enabled.
+func (s *Store) encryptPlain(ctx context.Context, key string, rcpts []stanza) error {
+	r, err := s.base.Open(ctx, key)
+	if err != nil {
+		return err
+	}
+	// The blob is overwritten in place, so it is read into memory first.
+	plain, err := io.ReadAll(r)
+	r.Close()
+	if err != nil {
+		return err
+	}
+
+	dataKey := make([]byte, chacha20poly1305.KeySize)
+	if _, err := rand.Read(dataKey); err != nil {
+		return err
+	}
+	stanzas, err := s.wrap(dataKey, rcpts)
+	if err != nil {
+		return err
+	}
+	blob, err := s.base.Create(ctx, key, encryptedSize(int64(len(plain))))
+	if err != nil {
+		return err
+	}
+	defer blob.Close()
+	w, err := newEncWriter(blob, dataKey, func() error {
+		return s.writeKeys(ctx, key, stanzas)
+	})
+	if err != nil {
+		return err
+	}
+	if _, err := w.Write(plain); err != nil {
+		return err
+	}
+	return w.Sync()
+}
+
+// SealPending re-wraps the blobs created since the last call to the keys of
+// the accounts storing them. Blobs that are not stored by any account yet
+// are retried on the next call. owners returns the accounts storing the
+// blob.
+func (s *Store) SealPending(ctx context.Context, owners func(ctx context.Context, key string) ([]string, error)) {
+	s.pendingLck.Lock()
+	pending := make(map[string]time.Time, len(s.pending))
+	for key, created := range s.pending {
+		pending[key] = created
+	}
+	s.pendingLck.Unlock()
+
+	for key, created := range pending {
+		accounts, err := owners(ctx, key)
+		if err != nil {
+			s.log.Error("failed to get the blob owners", err, "key", key)
+			continue
+		}
+		if len(accounts) == 0 && time.Since(created) < pendingTimeout {
+			continue
+		}
+		if len(accounts) != 0 {
+			if err := s.Rewrap(ctx, key, accounts); err != nil {
+				s.log.Error("failed to re-wrap the blob key", err, "key", key)
+			}
+		}
+		s.pendingLck.Lock()
+		delete(s.pending, key)
+		s.pendingLck.Unlock()
+	}
+}
+
+// Enroll publishes the account key. sig is the signature of
+// EnrollmentMessage made by the wallet of the account address.
+func (s *Store) Enroll(ctx context.Context, account, pub, sig string) error {
+	tbl, ok := s.keys.(module.MutableTable)
+	if !ok {
+		return fmt.Errorf("%s: keys table is not configured or is not mutable", s.Name())
+	}
+	if _, err := DecodePublicKey(pub); err != nil {
+		return err
+	}
+
+	addr, _, err := address.Split(account)
+	if err != nil {
+		return err
+	}
+	chain, ok := s.chains[blockchain.AddressChainType(addr)]
+	if !ok {
+		return fmt.Errorf("%s: no blockchain configured for %s", s.Name(), addr)
+	}
+	valid, err := chain.CheckSign(ctx, addr, sig, EnrollmentMessage(pub))
+	if err != nil {
+		return err
+	}
+	if !valid {
+		return ErrBadBinding
+	}
+	return tbl.SetKey(account, pub)
+}
+
+// OpenSession derives the account key from the password of the IMAP login
+// and makes it usable until the returned function is called on logout. The
+// key is kept only if it matches the enrolled key of the account, so logins
+// using other credentials, such as application passwords, do not unlock it.
+func (s *Store) OpenSession(ctx context.Context, account, password string) (release func()) {
+	pub, ok, err := s.accountKey(ctx, account)
+	if err != nil {
+		s.log.Error("failed to look up the account key", err, "account", account)
+		return func() {}
+	}
+	if !ok {
+		return func() {}
+	}
+	priv, err := DeriveKey(password)
+	if err != nil || !priv.PublicKey().Equal(pub) {
+		return func() {}
+	}
+	return openSession(account, priv)
+}
+
+// Unenroll removes the account key, new messages are encrypted using the
+// KEK.
+func (s *Store) Unenroll(account string) error {
+	tbl, ok := s.keys.(module.MutableTable)
+	if !ok {
+		return fmt.Errorf("%s: keys table is not configured or is not mutable", s.Name())
+	}
+	return tbl.RemoveKey(account)
+}
+
+func init() {
+	var _ module.BlobStore = &Store{}
+	module.Register("storage.blob.encrypted", New)
+}
@@ -0,0 +1,306 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package encrypted
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/storage/blob"
+	"github.com/mail-chat-chain/mailchatd/internal/storage/blob/fs"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+type memTable map[string]string
+
+func (m memTable) Lookup(_ context.Context, key string) (string, bool, error) {
+	v, ok := m[key]
+	return v, ok, nil
+}
+
+func (m memTable) Keys() ([]string, error) {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (m memTable) RemoveKey(k string) error {
+	delete(m, k)
+	return nil
+}
+
+func (m memTable) SetKey(k, v string) error {
+	m[k] = v
+	return nil
+}
+
+type testVerifier struct{}
+
+func (testVerifier) ChainType(context.Context) string { return "ethereum" }
+
+func (testVerifier) CheckSign(_ context.Context, pk, sign, message string) (bool, error) {
+	return sign == pk+" "+message, nil
+}
+
+func testStore(t *testing.T) *Store {
+	dir := testutils.Dir(t)
+	base, err := fs.New("storage.blob.fs", "test", nil, []string{filepath.Join(dir, "messages")})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := os.MkdirAll(filepath.Join(dir, "messages"), 0o700); err != nil {
+		t.Fatal(err)
+	}
+	s := &Store{
+		instName:  "test",
+		log:       testutils.Logger(t, "storage.blob.encrypted"),
+		base:      base.(module.BlobStore),
+		keys:      memTable{},
+		chains:    map[string]module.SignatureVerifier{"ethereum": testVerifier{}},
+		unlockTTL: time.Hour,
+		kekPath:   filepath.Join(dir, "kek.key"),
+		pending:   map[string]time.Time{},
+	}
+	if err := s.writeKEKs(nil, true); err != nil {
+		t.Fatal(err)
+	}
+	return s
+}
+
+func writeBlob(t *testing.T, s *Store, key string, data []byte) {
+	t.Helper()
+	w, err := s.Create(context.Background(), key, int64(len(data)))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Close()
+	if _, err := w.Write(data); err != nil {
+		t.Fatal(err)
+	}
+	if err := w.Sync(); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func readBlob(s *Store, key string) ([]byte, error) {
+	r, err := s.Open(context.Background(), key)
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	return io.ReadAll(r)
+}
+
+func TestStore(t *testing.T) {
+	blob.TestStore(t, func() module.BlobStore {
+		return testStore(t)
+	}, func(module.BlobStore) {})
+}
+
+func TestStream(t *testing.T) {
+	s := testStore(t)
+	for _, size := range []int{0, 1, chunkSize - 1, chunkSize, chunkSize + 1, 3 * chunkSize} {
+		data := bytes.Repeat([]byte{'a'}, size)
+		writeBlob(t, s, "msg", data)
+
+		info, err := os.Stat(filepath.Join(filepath.Dir(s.kekPath), "messages", "msg"))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if info.Size() != encryptedSize(int64(size)) {
+			t.Errorf("%d: expected blob size %d, got %d", size, encryptedSize(int64(size)), info.Size())
+		}
+		if bytes.Contains(mustRead(t, s, "msg"), []byte("aaaa")) {
+			t.Errorf("%d: blob is not encrypted", size)
+		}
+
+		res, err := readBlob(s, "msg")
+		if err != nil {
+			t.Fatal(err)
+		}
+		if !bytes.Equal(res, data) {
+			t.Errorf("%d: decrypted data does not match", size)
+		}
+	}
+
+	// Truncation is detected even on the chunk boundary.
+	writeBlob(t, s, "msg", bytes.Repeat([]byte{'a'}, 2*chunkSize))
+	path := filepath.Join(filepath.Dir(s.kekPath), "messages", "msg")
+	if err := os.Truncate(path, encryptedSize(chunkSize)); err != nil {
+		t.Fatal(err)
+	}
+	if _, err := readBlob(s, "msg"); !errors.Is(err, ErrCorrupted) {
+		t.Fatal("expected ErrCorrupted, got", err)
+	}
+}
+
+func mustRead(t *testing.T, s *Store, key string) []byte {
+	t.Helper()
+	r, err := s.base.Open(context.Background(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	data, err := io.ReadAll(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestAccountKeys(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	const (
+		account  = "0x52908400098527886E0F7030069857D2E4169EE7@example.org"
+		password = "0xsignature"
+	)
+	key, err := DeriveKey(password)
+	if err != nil {
+		t.Fatal(err)
+	}
+	pub := EncodePublicKey(key.PublicKey())
+
+	err = s.Enroll(ctx, account, pub, "wrong signature")
+	if !errors.Is(err, ErrBadBinding) {
+		t.Fatal("expected ErrBadBinding, got", err)
+	}
+	if err := s.Enroll(ctx, account, pub, "0x52908400098527886E0F7030069857D2E4169EE7 "+EnrollmentMessage(pub)); err != nil {
+		t.Fatal(err)
+	}
+
+	writeBlob(t, s, "msg", []byte("secret"))
+	s.SealPending(ctx, func(context.Context, string) ([]string, error) {
+		return []string{account}, nil
+	})
+	if len(s.pending) != 0 {
+		t.Fatal("blob is still pending")
+	}
+	if _, err := readBlob(s, "msg"); !errors.Is(err, ErrLocked) {
+		t.Fatal("expected ErrLocked, got", err)
+	}
+
+	// A session opened using other credentials, e.g. an application
+	// password, does not unlock the key.
+	s.OpenSession(ctx, account, "app-password")()
+	if _, err := readBlob(s, "msg"); !errors.Is(err, ErrLocked) {
+		t.Fatal("expected ErrLocked, got", err)
+	}
+	release := s.OpenSession(ctx, account, "app-password")
+	if _, err := readBlob(s, "msg"); !errors.Is(err, ErrLocked) {
+		t.Fatal("key is unlocked by a session without the wallet login:", err)
+	}
+	release()
+
+	release = s.OpenSession(ctx, account, password)
+	res, err := readBlob(s, "msg")
+	if err != nil || string(res) != "secret" {
+		t.Fatal("failed to read the unlocked blob:", err)
+	}
+
+	// The key is usable while any session of the wallet login is open.
+	release2 := s.OpenSession(ctx, account, password)
+	release()
+	release()
+	if _, err := readBlob(s, "msg"); err != nil {
+		t.Fatal("key is locked while the second session is open:", err)
+	}
+	release2()
+	if _, err := readBlob(s, "msg"); !errors.Is(err, ErrLocked) {
+		t.Fatal("key is usable after the logout:", err)
+	}
+
+	// Re-wrap to the KEK requires the account key.
+	if err := s.Unenroll(account); err != nil {
+		t.Fatal(err)
+	}
+	if err := s.Rewrap(ctx, "msg", []string{account}); !errors.Is(err, ErrLocked) {
+		t.Fatal("expected ErrLocked, got", err)
+	}
+	if err := s.Rewrap(ctx, "msg", []string{account}, key); err != nil {
+		t.Fatal(err)
+	}
+	res, err = readBlob(s, "msg")
+	if err != nil || string(res) != "secret" {
+		t.Fatal("failed to read the blob re-wrapped to the KEK:", err)
+	}
+}
+
+func TestRotateKEK(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	writeBlob(t, s, "msg", []byte("secret"))
+	old := s.keks[0]
+
+	if err := s.RotateKEK(); err != nil {
+		t.Fatal(err)
+	}
+	if len(s.keks) != 2 || !s.keks[1].Equal(old) {
+		t.Fatal("old key is not kept")
+	}
+	if err := s.Rewrap(ctx, "msg", nil); err != nil {
+		t.Fatal(err)
+	}
+
+	// Drop the old key, the message should be readable using the new one.
+	if err := s.writeKEKs(s.keks[:1], false); err != nil {
+		t.Fatal(err)
+	}
+	s.keks = nil
+	res, err := readBlob(s, "msg")
+	if err != nil || string(res) != "secret" {
+		t.Fatal("failed to read the blob after the rotation:", err)
+	}
+}
+
+func TestPlainBlob(t *testing.T) {
+	s := testStore(t)
+	ctx := context.Background()
+	w, err := s.base.Create(ctx, "msg", 6)
+	if err != nil {
+		t.Fatal(err)
+	}
+	w.Write([]byte("legacy"))
+	w.Sync()
+	w.Close()
+
+	res, err := readBlob(s, "msg")
+	if err != nil || string(res) != "legacy" {
+		t.Fatal("failed to read the unencrypted blob:", err)
+	}
+	if err := s.Rewrap(ctx, "msg", nil); err != nil {
+		t.Fatal(err)
+	}
+	if bytes.Contains(mustRead(t, s, "msg"), []byte("legacy")) {
+		t.Fatal("blob is not encrypted")
+	}
+	res, err = readBlob(s, "msg")
+	if err != nil || string(res) != "legacy" {
+		t.Fatal("failed to read the encrypted blob:", err)
+	}
+}
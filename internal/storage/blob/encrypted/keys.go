@@ -0,0 +1,215 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package encrypted
+
+import (
+	"bytes"
+	"crypto/ecdh"
+	"crypto/hkdf"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/base64"
+	"errors"
+	"fmt"
+	"sync"
+	"time"
+
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// ErrLocked is returned if the blob is encrypted only to the keys of
+// accounts that have no IMAP sessions authenticated using the wallet.
+var ErrLocked = errors.New("blob_store: message is encrypted to a locked account key")
+
+const (
+	keysVersion = 1
+
+	// maxUnlockTTL limits the time the unlocked key is usable regardless of
+	// the module configuration.
+	maxUnlockTTL = 24 * time.Hour
+)
+
+// stanza is the data key wrapped to the X25519 public key.
+type stanza struct {
+	// Account is the account the key belongs to, it is empty for the server
+	// key.
+	Account   string `json:"account,omitempty"`
+	Recipient []byte `json:"recipient"`
+	Ephemeral []byte `json:"ephemeral"`
+	Key       []byte `json:"key"`
+}
+
+// keysFile is stored next to the blob and contains its wrapped data key.
+type keysFile struct {
+	Version int      `json:"version"`
+	Stanzas []stanza `json:"stanzas"`
+}
+
+func wrappingKey(shared, ephemeral, recipient []byte) ([]byte, error) {
+	salt := make([]byte, 0, len(ephemeral)+len(recipient))
+	salt = append(append(salt, ephemeral...), recipient...)
+	return hkdf.Key(sha256.New, shared, salt, "mailchat blob key wrap", chacha20poly1305.KeySize)
+}
+
+func wrapKey(dataKey []byte, account string, recipient *ecdh.PublicKey) (stanza, error) {
+	ephemeral, err := ecdh.X25519().GenerateKey(rand.Reader)
+	if err != nil {
+		return stanza{}, err
+	}
+	shared, err := ephemeral.ECDH(recipient)
+	if err != nil {
+		return stanza{}, err
+	}
+	key, err := wrappingKey(shared, ephemeral.PublicKey().Bytes(), recipient.Bytes())
+	if err != nil {
+		return stanza{}, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return stanza{}, err
+	}
+	// The wrapping key is used only once, so the nonce can be constant.
+	nonce := make([]byte, aead.NonceSize())
+	return stanza{
+		Account:   account,
+		Recipient: recipient.Bytes(),
+		Ephemeral: ephemeral.PublicKey().Bytes(),
+		Key:       aead.Seal(nil, nonce, dataKey, nil),
+	}, nil
+}
+
+func unwrapKey(s stanza, priv *ecdh.PrivateKey) ([]byte, error) {
+	if !bytes.Equal(priv.PublicKey().Bytes(), s.Recipient) {
+		return nil, errors.New("blob_store: wrong key")
+	}
+	ephemeral, err := ecdh.X25519().NewPublicKey(s.Ephemeral)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	shared, err := priv.ECDH(ephemeral)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	key, err := wrappingKey(shared, s.Ephemeral, s.Recipient)
+	if err != nil {
+		return nil, err
+	}
+	aead, err := chacha20poly1305.New(key)
+	if err != nil {
+		return nil, err
+	}
+	dataKey, err := aead.Open(nil, make([]byte, aead.NonceSize()), s.Key, nil)
+	if err != nil {
+		return nil, ErrCorrupted
+	}
+	return dataKey, nil
+}
+
+// DeriveKey derives the X25519 account key from the wallet signature used
+// as the password by auth.pass_evm. Wallets produce the same signature of
+// the same message, so the client can compute the public key to publish
+// and the server can decrypt messages only while the owner logs in.
+func DeriveKey(password string) (*ecdh.PrivateKey, error) {
+	seed, err := hkdf.Key(sha256.New, []byte(password), nil, "mailchat blob encryption key", 32)
+	if err != nil {
+		return nil, err
+	}
+	return ecdh.X25519().NewPrivateKey(seed)
+}
+
+// EncodePublicKey returns the representation of the account key used in
+// the keys table.
+func EncodePublicKey(pub *ecdh.PublicKey) string {
+	return base64.StdEncoding.EncodeToString(pub.Bytes())
+}
+
+func DecodePublicKey(s string) (*ecdh.PublicKey, error) {
+	b, err := base64.StdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %w", err)
+	}
+	pub, err := ecdh.X25519().NewPublicKey(b)
+	if err != nil {
+		return nil, fmt.Errorf("malformed public key: %w", err)
+	}
+	return pub, nil
+}
+
+// EnrollmentMessage returns the message the owner of the account signs with
+// the wallet to bind the encryption key to the address.
+func EnrollmentMessage(pub string) string {
+	return "MailChat encryption key: " + pub
+}
+
+// unlockedKey is the account key derived from the password of the IMAP
+// login. It is usable only while the account has sessions opened by logins
+// using the wallet signature.
+type unlockedKey struct {
+	key      *ecdh.PrivateKey
+	at       time.Time
+	sessions int
+}
+
+// keyring contains the account keys of authenticated users.
+var keyring = struct {
+	lck  sync.Mutex
+	keys map[string]*unlockedKey
+}{keys: map[string]*unlockedKey{}}
+
+// openSession makes the account key usable until the returned function is
+// called on logout.
+func openSession(account string, priv *ecdh.PrivateKey) (release func()) {
+	keyring.lck.Lock()
+	defer keyring.lck.Unlock()
+
+	k := keyring.keys[account]
+	if k == nil {
+		k = &unlockedKey{}
+		keyring.keys[account] = k
+	}
+	k.key = priv
+	k.at = time.Now()
+	k.sessions++
+
+	var once sync.Once
+	return func() {
+		once.Do(func() {
+			keyring.lck.Lock()
+			defer keyring.lck.Unlock()
+			k.sessions--
+			if k.sessions == 0 && keyring.keys[account] == k {
+				delete(keyring.keys, account)
+			}
+		})
+	}
+}
+
+func unlocked(pub []byte, ttl time.Duration) *ecdh.PrivateKey {
+	keyring.lck.Lock()
+	defer keyring.lck.Unlock()
+	for _, k := range keyring.keys {
+		if k.sessions == 0 || time.Since(k.at) > ttl {
+			continue
+		}
+		if bytes.Equal(k.key.PublicKey().Bytes(), pub) {
+			return k.key
+		}
+	}
+	return nil
+}
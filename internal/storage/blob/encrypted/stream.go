@@ -0,0 +1,223 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package encrypted
+
+import (
+	"bufio"
+	"crypto/cipher"
+	"crypto/rand"
+	"encoding/binary"
+	"errors"
+	"io"
+
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"golang.org/x/crypto/chacha20poly1305"
+)
+
+// The encrypted blob consists of the header followed by chunks of the
+// message encrypted using XChaCha20-Poly1305. The nonce of each chunk is the
+// random prefix from the header followed by the chunk number and the flag
+// set for the last chunk, so reordered and truncated blobs are detected.
+const (
+	magic      = "MCENC\x00\x01\x00"
+	prefixSize = 16
+	headerSize = len(magic) + prefixSize
+	chunkSize  = 64 * 1024
+)
+
+var ErrCorrupted = errors.New("blob_store: encrypted blob is corrupted")
+
+// encryptedSize returns the size of the encrypted blob for the plaintext of
+// the specified size.
+func encryptedSize(size int64) int64 {
+	if size < 0 {
+		return module.UnknownBlobSize
+	}
+	chunks := (size + chunkSize - 1) / chunkSize
+	if chunks == 0 {
+		chunks = 1
+	}
+	return int64(headerSize) + size + chunks*chacha20poly1305.Overhead
+}
+
+func chunkNonce(prefix []byte, counter uint64, last bool) []byte {
+	nonce := make([]byte, chacha20poly1305.NonceSizeX)
+	copy(nonce, prefix)
+	// The counter takes 7 bytes between the prefix and the flag.
+	var ctr [8]byte
+	binary.BigEndian.PutUint64(ctr[:], counter)
+	copy(nonce[prefixSize:], ctr[1:])
+	if last {
+		nonce[chacha20poly1305.NonceSizeX-1] = 1
+	}
+	return nonce
+}
+
+type encWriter struct {
+	module.Blob
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint64
+	buf     []byte
+	out     []byte
+
+	// onSync is called after the last chunk is written and before the
+	// underlying blob is synced.
+	onSync func() error
+}
+
+func newEncWriter(blob module.Blob, dataKey []byte, onSync func() error) (*encWriter, error) {
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	prefix := make([]byte, prefixSize)
+	if _, err := rand.Read(prefix); err != nil {
+		return nil, err
+	}
+	if _, err := io.WriteString(blob, magic); err != nil {
+		return nil, err
+	}
+	if _, err := blob.Write(prefix); err != nil {
+		return nil, err
+	}
+	return &encWriter{
+		Blob:   blob,
+		aead:   aead,
+		prefix: prefix,
+		buf:    make([]byte, 0, chunkSize),
+		onSync: onSync,
+	}, nil
+}
+
+func (w *encWriter) flush(last bool) error {
+	w.out = w.aead.Seal(w.out[:0], chunkNonce(w.prefix, w.counter, last), w.buf, nil)
+	w.counter++
+	w.buf = w.buf[:0]
+	_, err := w.Blob.Write(w.out)
+	return err
+}
+
+func (w *encWriter) Write(p []byte) (int, error) {
+	written := 0
+	for len(p) != 0 {
+		// The full chunk is kept until more data is written since the last
+		// chunk is encrypted differently.
+		if len(w.buf) == chunkSize {
+			if err := w.flush(false); err != nil {
+				return written, err
+			}
+		}
+		n := copy(w.buf[len(w.buf):chunkSize], p)
+		w.buf = w.buf[:len(w.buf)+n]
+		p = p[n:]
+		written += n
+	}
+	return written, nil
+}
+
+func (w *encWriter) Sync() error {
+	if err := w.flush(true); err != nil {
+		return err
+	}
+	if err := w.onSync(); err != nil {
+		return err
+	}
+	return w.Blob.Sync()
+}
+
+type decReader struct {
+	io.Closer
+	r       *bufio.Reader
+	aead    cipher.AEAD
+	prefix  []byte
+	counter uint64
+	in      []byte
+	plain   []byte
+	done    bool
+}
+
+func newDecReader(r io.ReadCloser, dataKey []byte) (*decReader, error) {
+	aead, err := chacha20poly1305.NewX(dataKey)
+	if err != nil {
+		return nil, err
+	}
+	br := bufio.NewReader(r)
+	hdr := make([]byte, headerSize)
+	if _, err := io.ReadFull(br, hdr); err != nil {
+		if errors.Is(err, io.EOF) || errors.Is(err, io.ErrUnexpectedEOF) {
+			return nil, ErrCorrupted
+		}
+		return nil, err
+	}
+	if string(hdr[:len(magic)]) != magic {
+		return nil, ErrCorrupted
+	}
+	return &decReader{
+		Closer: r,
+		r:      br,
+		aead:   aead,
+		prefix: hdr[len(magic):],
+		in:     make([]byte, chunkSize+chacha20poly1305.Overhead),
+	}, nil
+}
+
+func (r *decReader) next() error {
+	n, err := io.ReadFull(r.r, r.in)
+	last := false
+	switch {
+	case err == nil:
+		if _, err := r.r.Peek(1); err != nil {
+			if err != io.EOF {
+				return err
+			}
+			last = true
+		}
+	case errors.Is(err, io.ErrUnexpectedEOF):
+		last = true
+	case errors.Is(err, io.EOF):
+		// Even the empty message has the last chunk.
+		return ErrCorrupted
+	default:
+		return err
+	}
+
+	plain, err := r.aead.Open(r.in[:0], chunkNonce(r.prefix, r.counter, last), r.in[:n], nil)
+	if err != nil {
+		return ErrCorrupted
+	}
+	r.counter++
+	r.plain = plain
+	r.done = last
+	return nil
+}
+
+func (r *decReader) Read(p []byte) (int, error) {
+	for len(r.plain) == 0 {
+		if r.done {
+			return 0, io.EOF
+		}
+		if err := r.next(); err != nil {
+			return 0, err
+		}
+	}
+	n := copy(p, r.plain)
+	r.plain = r.plain[n:]
+	return n, nil
+}
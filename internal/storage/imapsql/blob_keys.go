@@ -0,0 +1,128 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package imapsql
+
+import (
+	"context"
+	"strconv"
+	"strings"
+
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// BlobStore returns the store used for message bodies.
+func (store *Storage) BlobStore() module.BlobStore {
+	return store.blobStore
+}
+
+// rebind replaces ? placeholders with the ones used by the database driver.
+func (store *Storage) rebind(query string) string {
+	if store.driver != "postgres" {
+		return query
+	}
+	var (
+		b strings.Builder
+		n int
+	)
+	for _, r := range query {
+		if r != '?' {
+			b.WriteRune(r)
+			continue
+		}
+		n++
+		b.WriteString("$" + strconv.Itoa(n))
+	}
+	return b.String()
+}
+
+func (store *Storage) queryStrings(ctx context.Context, query string, args ...interface{}) ([]string, error) {
+	rows, err := store.Back.DB.QueryContext(ctx, store.rebind(query), args...)
+	if err != nil {
+		return nil, err
+	}
+	defer rows.Close()
+
+	var res []string
+	for rows.Next() {
+		var s string
+		if err := rows.Scan(&s); err != nil {
+			return nil, err
+		}
+		res = append(res, s)
+	}
+	return res, rows.Err()
+}
+
+// BlobOwners returns the accounts storing messages with the body in the
+// blob.
+func (store *Storage) BlobOwners(ctx context.Context, key string) ([]string, error) {
+	return store.queryStrings(ctx, `
+		SELECT DISTINCT users.username
+		FROM msgs
+		INNER JOIN mboxes ON msgs.mboxId = mboxes.id
+		INNER JOIN users ON mboxes.uid = users.id
+		WHERE msgs.extBodyKey = ?`, key)
+}
+
+// AccountBlobs returns the keys of blobs with message bodies stored by the
+// account.
+func (store *Storage) AccountBlobs(ctx context.Context, username string) ([]string, error) {
+	return store.queryStrings(ctx, `
+		SELECT DISTINCT msgs.extBodyKey
+		FROM msgs
+		INNER JOIN mboxes ON msgs.mboxId = mboxes.id
+		INNER JOIN users ON mboxes.uid = users.id
+		WHERE users.username = ? AND msgs.extBodyKey IS NOT NULL`, username)
+}
+
+// Blobs returns the keys of all blobs with message bodies.
+func (store *Storage) Blobs(ctx context.Context) ([]string, error) {
+	return store.queryStrings(ctx, `SELECT id FROM extKeys`)
+}
+
+// blobSealer is implemented by blob stores that need to know the accounts
+// storing the message, such as storage.blob.encrypted.
+type blobSealer interface {
+	SealPending(ctx context.Context, owners func(ctx context.Context, key string) ([]string, error))
+}
+
+// blobSessions is implemented by blob stores that can read messages only
+// during the sessions of the account, such as storage.blob.encrypted.
+type blobSessions interface {
+	OpenSession(ctx context.Context, account, password string) (release func())
+}
+
+// sessionUser releases the account keys used by the blob store when the
+// IMAP session ends.
+type sessionUser struct {
+	learnUser
+	release func()
+}
+
+func (u sessionUser) Logout() error {
+	u.release()
+	return u.learnUser.Logout()
+}
+
+// sealBlobs passes the owners of new messages to the blob store.
+func (store *Storage) sealBlobs(ctx context.Context) {
+	if sealer, ok := store.blobStore.(blobSealer); ok {
+		sealer.SealPending(ctx, store.BlobOwners)
+	}
+}
@@ -152,7 +152,11 @@ func (d *delivery) Abort(ctx context.Context) error {
 func (d *delivery) Commit(ctx context.Context) error {
 	defer trace.StartRegion(ctx, "sql/Commit").End()
 
-	return d.d.Commit()
+	if err := d.d.Commit(); err != nil {
+		return err
+	}
+	d.store.sealBlobs(ctx)
+	return nil
 }
 
 func (store *Storage) Start(ctx context.Context, msgMeta *module.MsgMetadata, mailFrom string) (module.Delivery, error) {
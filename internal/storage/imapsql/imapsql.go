@@ -70,7 +70,14 @@ type Storage struct {
 	updPushStop  chan struct{}
 	outboundUpds chan mess.Update
 
-	filters module.IMAPFilter
+	filters   module.IMAPFilter
+	blobStore module.BlobStore
+
+	learner     module.SpamLearner
+	learnIgnore []string
+	learnQueue  chan learnJob
+	learnStop   chan struct{}
+	learnDone   chan struct{}
 
 	deliveryMap       module.Table
 	deliveryNormalize func(context.Context, string) (string, error)
@@ -148,6 +155,14 @@ func (store *Storage) Init(cfg *config.Map) error {
 		err := modconfig.GroupFromNode("imap_filters", node.Args, node, m.Globals, &filter)
 		return filter, err
 	}, &store.filters)
+	cfg.Custom("spam_learner", false, false, func() (interface{}, error) {
+		return nil, nil
+	}, func(m *config.Map, node config.Node) (interface{}, error) {
+		var learner module.SpamLearner
+		err := modconfig.ModuleFromNode("check", node.Args, node, m.Globals, &learner)
+		return learner, err
+	}, &store.learner)
+	cfg.StringList("spam_learner_ignore", false, false, []string{"Trash"}, &store.learnIgnore)
 	cfg.Custom("auth_map", false, false, func() (interface{}, error) {
 		return nil, nil
 	}, modconfig.TableDirective, &store.authMap)
@@ -264,6 +279,7 @@ func (store *Storage) Init(cfg *config.Map) error {
 		}
 	}
 
+	store.blobStore = blobStore
 	store.Back, err = imapsql.New(driver, dsnStr, ExtBlobStore{Base: blobStore}, opts)
 	if err != nil {
 		return fmt.Errorf("imapsql: %s", err)
@@ -274,6 +290,13 @@ func (store *Storage) Init(cfg *config.Map) error {
 	store.driver = driver
 	store.dsn = dsn
 
+	if store.learner != nil && !module.NoRun {
+		store.learnQueue = make(chan learnJob, 128)
+		store.learnStop = make(chan struct{})
+		store.learnDone = make(chan struct{})
+		go store.learnLoop()
+	}
+
 	return nil
 }
 
@@ -379,12 +402,36 @@ func (store *Storage) CreateMessageLimit() *uint32 {
 }
 
 func (store *Storage) GetOrCreateIMAPAcct(username string) (backend.User, error) {
+	return store.OpenIMAPSession(username, "")
+}
+
+// OpenIMAPSession returns the account for the IMAP session. The password of
+// the login is passed to the blob store that can decrypt messages during
+// the session.
+func (store *Storage) OpenIMAPSession(username, password string) (backend.User, error) {
 	accountName, err := store.authNormalize(context.TODO(), username)
 	if err != nil {
 		return nil, backend.ErrInvalidCredentials
 	}
 
-	return store.Back.GetOrCreateUser(accountName)
+	u, err := store.Back.GetOrCreateUser(accountName)
+	if err != nil {
+		return nil, err
+	}
+	sqlUser, ok := u.(*imapsql.User)
+	if !ok {
+		return u, nil
+	}
+	if sessions, ok := store.blobStore.(blobSessions); ok && password != "" {
+		return sessionUser{
+			learnUser: learnUser{User: sqlUser, store: store},
+			release:   sessions.OpenSession(context.TODO(), accountName, password),
+		}, nil
+	}
+	if store.learnQueue != nil {
+		return learnUser{User: sqlUser, store: store}, nil
+	}
+	return u, nil
 }
 
 func (store *Storage) Lookup(ctx context.Context, key string) (string, bool, error) {
@@ -421,6 +468,11 @@ func (store *Storage) Close() error {
 		store.updPipe.Close()
 	}
 
+	if store.learnQueue != nil {
+		close(store.learnStop)
+		<-store.learnDone
+	}
+
 	return nil
 }
 
@@ -0,0 +1,247 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package imapsql
+
+import (
+	"bufio"
+	"bytes"
+	"context"
+	"io"
+	"runtime/debug"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-imap/backend"
+	"github.com/emersion/go-message/textproto"
+	imapsql "github.com/foxcpp/go-imap-sql"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+)
+
+// maxLearnBatch is the maximum amount of messages submitted to the spam
+// learner for a single COPY/MOVE command. Bulk operations (e.g. user moving
+// the entire folder) are not very useful for training and are expensive.
+const maxLearnBatch = 50
+
+type learnJob struct {
+	spam   bool
+	header textproto.Header
+	body   []byte
+}
+
+// learnUser wraps imapsql.User to intercept messages moved or copied into or
+// out of the junk mailbox and submit them to the configured spam learner.
+//
+// *imapsql.User is embedded so all optional backend interfaces implemented
+// by it are still visible to the IMAP server.
+type learnUser struct {
+	*imapsql.User
+	store *Storage
+}
+
+func (u learnUser) GetMailbox(name string, readOnly bool, conn backend.Conn) (*imap.MailboxStatus, backend.Mailbox, error) {
+	status, mbox, err := u.User.GetMailbox(name, readOnly, conn)
+	if err != nil {
+		return status, mbox, err
+	}
+	sqlMbox, ok := mbox.(*imapsql.Mailbox)
+	if !ok {
+		return status, mbox, nil
+	}
+	return status, learnMailbox{Mailbox: sqlMbox, store: u.store}, nil
+}
+
+type learnMailbox struct {
+	*imapsql.Mailbox
+	store *Storage
+}
+
+func (m learnMailbox) CopyMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	spam, learn := m.store.junkTransition(m.Name(), dest)
+	if !learn {
+		return m.Mailbox.CopyMessages(uid, seqset, dest)
+	}
+
+	jobs := m.collect(uid, seqset, spam)
+	if err := m.Mailbox.CopyMessages(uid, seqset, dest); err != nil {
+		return err
+	}
+	m.store.submitLearn(jobs)
+	return nil
+}
+
+func (m learnMailbox) MoveMessages(uid bool, seqset *imap.SeqSet, dest string) error {
+	spam, learn := m.store.junkTransition(m.Name(), dest)
+	if !learn {
+		return m.Mailbox.MoveMessages(uid, seqset, dest)
+	}
+
+	jobs := m.collect(uid, seqset, spam)
+	if err := m.Mailbox.MoveMessages(uid, seqset, dest); err != nil {
+		return err
+	}
+	m.store.submitLearn(jobs)
+	return nil
+}
+
+// collect reads the messages that are about to be moved. Errors are logged
+// and never prevent the move itself.
+func (m learnMailbox) collect(uid bool, seqset *imap.SeqSet, spam bool) []learnJob {
+	// Only the first maxLearnBatch messages are fetched, UIDs are looked up
+	// first since they are sparse and the set can contain "*".
+	batch, err := m.firstUIDs(uid, seqset, maxLearnBatch)
+	if err != nil {
+		m.store.Log.Error("failed to fetch messages for learning", err)
+		return nil
+	}
+	if batch.Empty() {
+		return nil
+	}
+
+	section := &imap.BodySectionName{Peek: true}
+	ch := make(chan *imap.Message, 1)
+	done := make(chan []learnJob, 1)
+
+	go func() {
+		jobs := make([]learnJob, 0, 1)
+		for msg := range ch {
+			// Only one section is requested. GetBody is not used since it
+			// looks up the section without PEEK.
+			var lit imap.Literal
+			for _, l := range msg.Body {
+				lit = l
+			}
+			if lit == nil {
+				continue
+			}
+			raw, err := io.ReadAll(lit)
+			if err != nil {
+				m.store.Log.Error("failed to read message for learning", err, "uid", msg.Uid)
+				continue
+			}
+			bufR := bufio.NewReader(bytes.NewReader(raw))
+			hdr, err := textproto.ReadHeader(bufR)
+			if err != nil {
+				m.store.Log.Error("failed to parse message for learning", err, "uid", msg.Uid)
+				continue
+			}
+			body, _ := io.ReadAll(bufR)
+			jobs = append(jobs, learnJob{spam: spam, header: hdr, body: body})
+		}
+		done <- jobs
+	}()
+
+	if err := m.Mailbox.ListMessages(true, batch, []imap.FetchItem{imap.FetchUid, section.FetchItem()}, ch); err != nil {
+		m.store.Log.Error("failed to fetch messages for learning", err)
+	}
+	return <-done
+}
+
+// firstUIDs returns the set of UIDs of at most limit first messages in the
+// seqset.
+func (m learnMailbox) firstUIDs(uid bool, seqset *imap.SeqSet, limit int) (*imap.SeqSet, error) {
+	ch := make(chan *imap.Message, 1)
+	done := make(chan *imap.SeqSet, 1)
+
+	go func() {
+		batch := new(imap.SeqSet)
+		n := 0
+		for msg := range ch {
+			if n >= limit {
+				continue
+			}
+			batch.AddNum(msg.Uid)
+			n++
+		}
+		done <- batch
+	}()
+
+	err := m.Mailbox.ListMessages(uid, seqset, []imap.FetchItem{imap.FetchUid}, ch)
+	batch := <-done
+	return batch, err
+}
+
+// junkTransition checks whether moving a message from the src mailbox to dst
+// should be used for learning and whether it should be learned as spam.
+func (store *Storage) junkTransition(src, dst string) (spam, learn bool) {
+	if store.learner == nil || src == dst {
+		return false, false
+	}
+	if strings.EqualFold(dst, store.junkMbox) {
+		return true, true
+	}
+	if strings.EqualFold(src, store.junkMbox) {
+		for _, ignored := range store.learnIgnore {
+			if strings.EqualFold(dst, ignored) {
+				return false, false
+			}
+		}
+		return false, true
+	}
+	return false, false
+}
+
+func (store *Storage) submitLearn(jobs []learnJob) {
+	for _, job := range jobs {
+		select {
+		case store.learnQueue <- job:
+		default:
+			store.Log.Msg("spam learner queue is full, dropping message",
+				"msg_id", job.header.Get("Message-Id"), "spam", job.spam)
+		}
+	}
+}
+
+func (store *Storage) learnLoop() {
+	// Abort the request in progress on shutdown.
+	baseCtx, cancelBase := context.WithCancel(context.Background())
+	defer cancelBase()
+	go func() {
+		select {
+		case <-store.learnStop:
+			cancelBase()
+		case <-baseCtx.Done():
+		}
+	}()
+
+	defer func() {
+		if err := recover(); err != nil {
+			stack := debug.Stack()
+			log.Printf("panic during imapsql spam learning: %v\n%s", err, stack)
+		}
+		close(store.learnDone)
+	}()
+
+	for {
+		var job learnJob
+		select {
+		case job = <-store.learnQueue:
+		case <-store.learnStop:
+			return
+		}
+
+		ctx, cancel := context.WithTimeout(baseCtx, 1*time.Minute)
+		err := store.learner.Learn(ctx, job.spam, job.header, bytes.NewReader(job.body))
+		cancel()
+		if err != nil {
+			store.Log.Error("spam learning failed", err,
+				"msg_id", job.header.Get("Message-Id"), "spam", job.spam)
+		}
+	}
+}
@@ -0,0 +1,197 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package imapsql
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-imap"
+	"github.com/emersion/go-message/textproto"
+	imapsql "github.com/foxcpp/go-imap-sql"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+type nopLearner struct{}
+
+func (nopLearner) Learn(context.Context, bool, textproto.Header, io.Reader) error {
+	return nil
+}
+
+func TestJunkTransition(t *testing.T) {
+	store := &Storage{
+		junkMbox:    "Junk",
+		learnIgnore: []string{"Trash"},
+	}
+	if _, learn := store.junkTransition("INBOX", "Junk"); learn {
+		t.Error("learning without a configured learner")
+	}
+
+	store.learner = nopLearner{}
+	for _, tc := range []struct {
+		src, dst    string
+		spam, learn bool
+	}{
+		{"INBOX", "Junk", true, true},
+		{"Archive", "junk", true, true},
+		{"Junk", "INBOX", false, true},
+		{"JUNK", "Archive", false, true},
+		{"Junk", "Trash", false, false},
+		{"Junk", "trash", false, false},
+		{"Junk", "Junk", false, false},
+		{"INBOX", "Archive", false, false},
+	} {
+		spam, learn := store.junkTransition(tc.src, tc.dst)
+		if spam != tc.spam || learn != tc.learn {
+			t.Errorf("%s -> %s: expected spam=%v learn=%v, got spam=%v learn=%v",
+				tc.src, tc.dst, tc.spam, tc.learn, spam, learn)
+		}
+	}
+}
+
+func testLearnUser(t *testing.T, msgs int) (learnUser, *Storage) {
+	t.Helper()
+	dir := t.TempDir()
+	db, err := imapsql.New("sqlite3", filepath.Join(dir, "test.db"), &imapsql.FSStore{Root: dir}, imapsql.Opts{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { db.Close() })
+
+	store := &Storage{
+		Back:        db,
+		Log:         testutils.Logger(t, "imapsql"),
+		junkMbox:    "Junk",
+		learnIgnore: []string{"Trash"},
+		learner:     nopLearner{},
+		learnQueue:  make(chan learnJob, 128),
+	}
+	u, err := db.GetOrCreateUser("test@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, name := range []string{"INBOX", "Junk", "Trash"} {
+		if name != "INBOX" {
+			if err := u.CreateMailbox(name); err != nil {
+				t.Fatal(err)
+			}
+		}
+	}
+	for i := 0; i < msgs; i++ {
+		body := fmt.Sprintf("Message-Id: <%d@example.org>\r\nSubject: test\r\n\r\nbody\r\n", i)
+		if err := u.CreateMessage("INBOX", nil, time.Now(), bytes.NewReader([]byte(body)), nil); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return learnUser{User: u.(*imapsql.User), store: store}, store
+}
+
+func learnMbox(t *testing.T, u learnUser, name string) learnMailbox {
+	t.Helper()
+	_, mbox, err := u.GetMailbox(name, false, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	lm, ok := mbox.(learnMailbox)
+	if !ok {
+		t.Fatalf("mailbox %s is not wrapped: %T", name, mbox)
+	}
+	return lm
+}
+
+func learnJobs(store *Storage) []learnJob {
+	var jobs []learnJob
+	for {
+		select {
+		case job := <-store.learnQueue:
+			jobs = append(jobs, job)
+		default:
+			return jobs
+		}
+	}
+}
+
+func TestLearnMailbox_CopyMove(t *testing.T) {
+	u, store := testLearnUser(t, 3)
+
+	mbox := learnMbox(t, u, "INBOX")
+	seq, _ := imap.ParseSeqSet("1")
+	if err := mbox.CopyMessages(false, seq, "Junk"); err != nil {
+		t.Fatal(err)
+	}
+	jobs := learnJobs(store)
+	if len(jobs) != 1 || !jobs[0].spam || jobs[0].header.Get("Message-Id") != "<0@example.org>" {
+		t.Fatalf("unexpected jobs after copy to Junk: %+v", jobs)
+	}
+
+	// Not a junk transition.
+	if err := mbox.CopyMessages(false, seq, "Trash"); err != nil {
+		t.Fatal(err)
+	}
+	if jobs := learnJobs(store); len(jobs) != 0 {
+		t.Fatalf("unexpected jobs after copy to Trash: %+v", jobs)
+	}
+
+	junk := learnMbox(t, u, "Junk")
+	all, _ := imap.ParseSeqSet("1:*")
+	if err := junk.MoveMessages(true, all, "INBOX"); err != nil {
+		t.Fatal(err)
+	}
+	jobs = learnJobs(store)
+	if len(jobs) != 1 || jobs[0].spam {
+		t.Fatalf("unexpected jobs after move out of Junk: %+v", jobs)
+	}
+	status, err := u.Status("Junk", []imap.StatusItem{imap.StatusMessages})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Messages != 0 {
+		t.Errorf("message was not moved: %d left in Junk", status.Messages)
+	}
+}
+
+func TestLearnMailbox_Batch(t *testing.T) {
+	u, store := testLearnUser(t, maxLearnBatch+5)
+
+	mbox := learnMbox(t, u, "INBOX")
+	all, _ := imap.ParseSeqSet("1:*")
+	if err := mbox.MoveMessages(false, all, "Junk"); err != nil {
+		t.Fatal(err)
+	}
+	jobs := learnJobs(store)
+	if len(jobs) != maxLearnBatch {
+		t.Fatalf("expected %d jobs, got %d", maxLearnBatch, len(jobs))
+	}
+	if jobs[0].header.Get("Message-Id") != "<0@example.org>" {
+		t.Errorf("batch does not start with the first message: %s", jobs[0].header.Get("Message-Id"))
+	}
+
+	status, err := u.Status("Junk", []imap.StatusItem{imap.StatusMessages})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if status.Messages != maxLearnBatch+5 {
+		t.Errorf("expected all messages to be moved, got %d", status.Messages)
+	}
+}
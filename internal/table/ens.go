@@ -0,0 +1,361 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package table
+
+import (
+	"context"
+	"fmt"
+	"math/big"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/ethereum/go-ethereum/crypto"
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// ensRegistryMainnet is the address of the ENS registry deployed on Ethereum
+// mainnet. It is used only if the blockchain has the mainnet chain ID.
+const ensRegistryMainnet = "0x00000000000C2E074eC69A0dFb2997BA6C7d2e1e"
+
+// ensMaxCacheSize is the amount of cached lookup results after which expired
+// entries are purged and new ones are not added.
+const ensMaxCacheSize = 4096
+
+// ensABI contains methods of the ENS registry and the public resolver used
+// for lookups.
+const ensABI = `[
+	{"type":"function","name":"resolver","stateMutability":"view","inputs":[{"name":"node","type":"bytes32"}],"outputs":[{"name":"","type":"address"}]},
+	{"type":"function","name":"addr","stateMutability":"view","inputs":[{"name":"node","type":"bytes32"}],"outputs":[{"name":"","type":"address"}]},
+	{"type":"function","name":"name","stateMutability":"view","inputs":[{"name":"node","type":"bytes32"}],"outputs":[{"name":"","type":"string"}]}
+]`
+
+var ensParsedABI = func() abi.ABI {
+	a, err := abi.JSON(strings.NewReader(ensABI))
+	if err != nil {
+		panic(err)
+	}
+	return a
+}()
+
+// ENS is the table that resolves ENS-style names (alice.eth) to EVM
+// addresses using the registry contract.
+//
+// If created with modName = "table.ens_reverse", it maps addresses to primary
+// names instead. The primary name is returned only if it resolves back to the
+// same address.
+type ENS struct {
+	modName  string
+	instName string
+	reverse  bool
+
+	chain    module.BlockChain
+	registry common.Address
+	suffixes []string
+	ttl      time.Duration
+	negTTL   time.Duration
+
+	lck   sync.Mutex
+	cache map[string]ensCacheEntry
+}
+
+type ensCacheEntry struct {
+	value   string
+	ok      bool
+	expires time.Time
+}
+
+func NewENS(modName, instName string, _, _ []string) (module.Module, error) {
+	return &ENS{
+		modName:  modName,
+		instName: instName,
+		reverse:  modName == "table.ens_reverse",
+		cache:    map[string]ensCacheEntry{},
+	}, nil
+}
+
+func (e *ENS) Init(cfg *config.Map) error {
+	var registry string
+	cfg.Custom("blockchain", false, true, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var chain module.BlockChain
+		err := modconfig.ModuleFromNode("blockchain", node.Args, node, m.Globals, &chain)
+		return chain, err
+	}, &e.chain)
+	cfg.String("registry", false, false, "", &registry)
+	cfg.StringList("suffixes", false, false, []string{"eth"}, &e.suffixes)
+	cfg.Duration("cache_ttl", false, false, 5*time.Minute, &e.ttl)
+	cfg.Duration("negative_ttl", false, false, time.Minute, &e.negTTL)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	if registry == "" {
+		var err error
+		registry, err = defaultRegistry(e.chain)
+		if err != nil {
+			return fmt.Errorf("%s: %w", e.modName, err)
+		}
+	}
+	if !common.IsHexAddress(registry) {
+		return fmt.Errorf("%s: invalid registry address: %s", e.modName, registry)
+	}
+	e.registry = common.HexToAddress(registry)
+	for i, s := range e.suffixes {
+		e.suffixes[i] = strings.ToLower(strings.Trim(s, "."))
+	}
+	return nil
+}
+
+// defaultRegistry returns the ENS registry address for the chain. Only
+// Ethereum mainnet has a well-known registry, other chains should set
+// registry explicitly.
+func defaultRegistry(chain module.BlockChain) (string, error) {
+	id, err := chain.ChainID(context.Background())
+	if err != nil {
+		return "", fmt.Errorf("cannot determine chain ID: %w", err)
+	}
+	if id.Cmp(big.NewInt(1)) != 0 {
+		return "", fmt.Errorf("registry is required for chain ID %v", id)
+	}
+	return ensRegistryMainnet, nil
+}
+
+func (e *ENS) Name() string {
+	return e.modName
+}
+
+func (e *ENS) InstanceName() string {
+	return e.instName
+}
+
+// ensNamehash computes the ENS node of the name as defined by EIP-137.
+func ensNamehash(name string) [32]byte {
+	var node [32]byte
+	if name == "" {
+		return node
+	}
+	labels := strings.Split(name, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		label := crypto.Keccak256([]byte(labels[i]))
+		copy(node[:], crypto.Keccak256(node[:], label))
+	}
+	return node
+}
+
+// normalizeName returns the lowercase name if it has one of the configured
+// suffixes and no empty labels.
+func (e *ENS) normalizeName(key string) (string, bool) {
+	name := strings.ToLower(key)
+	if strings.Contains(name, "@") {
+		return "", false
+	}
+	for _, label := range strings.Split(name, ".") {
+		if label == "" {
+			return "", false
+		}
+	}
+	for _, s := range e.suffixes {
+		if strings.HasSuffix(name, "."+s) {
+			return name, true
+		}
+	}
+	return "", false
+}
+
+func (e *ENS) call(ctx context.Context, to common.Address, method string, node [32]byte) ([]interface{}, error) {
+	data, err := ensParsedABI.Pack(method, node)
+	if err != nil {
+		return nil, err
+	}
+	res, err := e.chain.CallContract(ctx, to.Hex(), hexutil.Encode(data))
+	if err != nil {
+		return nil, err
+	}
+	out, err := hexutil.Decode(res)
+	if err != nil {
+		return nil, err
+	}
+	// Calls to addresses without code return empty data.
+	if len(out) == 0 {
+		return nil, nil
+	}
+	return ensParsedABI.Unpack(method, out)
+}
+
+func (e *ENS) resolver(ctx context.Context, node [32]byte) (common.Address, error) {
+	vals, err := e.call(ctx, e.registry, "resolver", node)
+	if err != nil || vals == nil {
+		return common.Address{}, err
+	}
+	return vals[0].(common.Address), nil
+}
+
+func (e *ENS) resolveAddr(ctx context.Context, name string) (common.Address, bool, error) {
+	node := ensNamehash(name)
+	resolver, err := e.resolver(ctx, node)
+	if err != nil {
+		return common.Address{}, false, err
+	}
+	if resolver == (common.Address{}) {
+		return common.Address{}, false, nil
+	}
+	vals, err := e.call(ctx, resolver, "addr", node)
+	if err != nil || vals == nil {
+		return common.Address{}, false, err
+	}
+	addr := vals[0].(common.Address)
+	return addr, addr != common.Address{}, nil
+}
+
+func (e *ENS) resolveName(ctx context.Context, addr common.Address) (string, bool, error) {
+	node := ensNamehash(strings.ToLower(addr.Hex()[2:]) + ".addr.reverse")
+	resolver, err := e.resolver(ctx, node)
+	if err != nil {
+		return "", false, err
+	}
+	if resolver == (common.Address{}) {
+		return "", false, nil
+	}
+	vals, err := e.call(ctx, resolver, "name", node)
+	if err != nil || vals == nil {
+		return "", false, err
+	}
+	name, ok := e.normalizeName(vals[0].(string))
+	if !ok {
+		return "", false, nil
+	}
+
+	// Reverse records can be set to any name by the address owner, so the
+	// name is used only if it points back to the address.
+	forward, ok, err := e.resolveAddr(ctx, name)
+	if err != nil || !ok {
+		return "", false, err
+	}
+	if forward != addr {
+		return "", false, nil
+	}
+	return name, true, nil
+}
+
+func (e *ENS) cached(key string) (ensCacheEntry, bool) {
+	e.lck.Lock()
+	defer e.lck.Unlock()
+
+	entry, ok := e.cache[key]
+	if !ok {
+		return ensCacheEntry{}, false
+	}
+	if time.Now().After(entry.expires) {
+		delete(e.cache, key)
+		return ensCacheEntry{}, false
+	}
+	return entry, true
+}
+
+func (e *ENS) store(key, value string, ok bool) {
+	ttl := e.ttl
+	if !ok {
+		ttl = e.negTTL
+	}
+	if ttl <= 0 {
+		return
+	}
+
+	e.lck.Lock()
+	defer e.lck.Unlock()
+
+	now := time.Now()
+	if len(e.cache) >= ensMaxCacheSize {
+		for k, entry := range e.cache {
+			if now.After(entry.expires) {
+				delete(e.cache, k)
+			}
+		}
+		if len(e.cache) >= ensMaxCacheSize {
+			return
+		}
+	}
+	e.cache[key] = ensCacheEntry{value: value, ok: ok, expires: now.Add(ttl)}
+}
+
+func (e *ENS) Lookup(ctx context.Context, key string) (string, bool, error) {
+	if e.reverse {
+		return e.lookupReverse(ctx, key)
+	}
+
+	name, ok := e.normalizeName(key)
+	if !ok {
+		return "", false, nil
+	}
+	if entry, ok := e.cached(name); ok {
+		return entry.value, entry.ok, nil
+	}
+
+	addr, ok, err := e.resolveAddr(ctx, name)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %s: %w", e.modName, name, err)
+	}
+	var value string
+	if ok {
+		// Mailboxes of blockchain accounts use lowercase addresses.
+		value = strings.ToLower(addr.Hex())
+	}
+	e.store(name, value, ok)
+	return value, ok, nil
+}
+
+func (e *ENS) LookupMulti(ctx context.Context, key string) ([]string, error) {
+	val, ok, err := e.Lookup(ctx, key)
+	if err != nil || !ok {
+		return []string{}, err
+	}
+	return []string{val}, nil
+}
+
+func (e *ENS) lookupReverse(ctx context.Context, key string) (string, bool, error) {
+	if mbox, _, err := address.Split(key); err == nil && mbox != "" {
+		key = mbox
+	}
+	if !strings.HasPrefix(key, "0x") && !strings.HasPrefix(key, "0X") || !common.IsHexAddress(key) {
+		return "", false, nil
+	}
+	addr := common.HexToAddress(key)
+	cacheKey := strings.ToLower(addr.Hex())
+	if entry, ok := e.cached(cacheKey); ok {
+		return entry.value, entry.ok, nil
+	}
+
+	name, ok, err := e.resolveName(ctx, addr)
+	if err != nil {
+		return "", false, fmt.Errorf("%s: %s: %w", e.modName, cacheKey, err)
+	}
+	e.store(cacheKey, name, ok)
+	return name, ok, nil
+}
+
+func init() {
+	module.Register("table.ens", NewENS)
+	module.Register("table.ens_reverse", NewENS)
+}
@@ -0,0 +1,211 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package table
+
+import (
+	"context"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/common/hexutil"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+var (
+	ensTestRegistry = common.HexToAddress("0x00000000000000000000000000000000000e5000")
+	ensTestResolver = common.HexToAddress("0x00000000000000000000000000000000000e5001")
+)
+
+// ensFakeChain serves the registry and a single resolver from memory.
+type ensFakeChain struct {
+	addrs map[[32]byte]common.Address
+	names map[[32]byte]string
+	calls int
+	fail  bool
+	// chainID is 1 (mainnet) if not set.
+	chainID int64
+}
+
+func (f *ensFakeChain) CallContract(_ context.Context, to, data string) (string, error) {
+	f.calls++
+	if f.fail {
+		return "", errors.New("connection refused")
+	}
+	input, err := hexutil.Decode(data)
+	if err != nil {
+		return "", err
+	}
+	method, err := ensParsedABI.MethodById(input)
+	if err != nil {
+		return "", err
+	}
+	args, err := method.Inputs.Unpack(input[4:])
+	if err != nil {
+		return "", err
+	}
+	node := args[0].([32]byte)
+
+	var out []byte
+	switch {
+	case common.HexToAddress(to) == ensTestRegistry && method.Name == "resolver":
+		resolver := common.Address{}
+		if _, ok := f.addrs[node]; ok {
+			resolver = ensTestResolver
+		}
+		if _, ok := f.names[node]; ok {
+			resolver = ensTestResolver
+		}
+		out, err = method.Outputs.Pack(resolver)
+	case common.HexToAddress(to) == ensTestResolver && method.Name == "addr":
+		out, err = method.Outputs.Pack(f.addrs[node])
+	case common.HexToAddress(to) == ensTestResolver && method.Name == "name":
+		out, err = method.Outputs.Pack(f.names[node])
+	}
+	if err != nil {
+		return "", err
+	}
+	return hexutil.Encode(out), nil
+}
+
+func (f *ensFakeChain) SendRawTx(context.Context, string) error { return nil }
+
+func (f *ensFakeChain) ChainType(context.Context) string { return "evm" }
+
+func (f *ensFakeChain) CheckSign(context.Context, string, string, string) (bool, error) {
+	return false, nil
+}
+
+func (f *ensFakeChain) ChainID(context.Context) (*big.Int, error) {
+	if f.chainID == 0 {
+		return big.NewInt(1), nil
+	}
+	return big.NewInt(f.chainID), nil
+}
+
+func (f *ensFakeChain) PendingNonce(context.Context, string) (uint64, error) { return 0, nil }
+
+func (f *ensFakeChain) SuggestGasPrice(context.Context) (*big.Int, error) { return big.NewInt(1), nil }
+
+func (f *ensFakeChain) TransactionReceipt(context.Context, string) (*module.TxReceipt, error) {
+	return nil, module.ErrTxPending
+}
+
+func TestENSNamehash(t *testing.T) {
+	for name, expected := range map[string]string{
+		"":        "0x0000000000000000000000000000000000000000000000000000000000000000",
+		"eth":     "0x93cdeb708b7545dc668eb9280176169d1c33cfd8ed6f04690a0bcc88a93fc4ae",
+		"foo.eth": "0xde9b09fd7c5f901e23a3f19fecc54828e9c848539801e86591bd9801b019f84f",
+	} {
+		node := ensNamehash(name)
+		if got := hexutil.Encode(node[:]); got != expected {
+			t.Errorf("namehash(%q) = %s, want %s", name, got, expected)
+		}
+	}
+}
+
+func TestENSDefaultRegistry(t *testing.T) {
+	registry, err := defaultRegistry(&ensFakeChain{})
+	if err != nil || registry != ensRegistryMainnet {
+		t.Fatalf("expected mainnet registry, got %q %v", registry, err)
+	}
+	if _, err := defaultRegistry(&ensFakeChain{chainID: 26000}); err == nil {
+		t.Fatal("mainnet registry used for another chain")
+	}
+}
+
+func TestENS(t *testing.T) {
+	ctx := context.Background()
+	alice := common.HexToAddress("0x00000000000000000000000000000000000A11CE")
+	mallory := common.HexToAddress("0x0000000000000000000000000000000000000BAD")
+	chain := &ensFakeChain{
+		addrs: map[[32]byte]common.Address{
+			ensNamehash("alice.eth"):      alice,
+			ensNamehash("alice.mailchat"): alice,
+		},
+		names: map[[32]byte]string{
+			ensNamehash("00000000000000000000000000000000000a11ce.addr.reverse"): "Alice.eth",
+			ensNamehash("0000000000000000000000000000000000000bad.addr.reverse"): "alice.eth",
+		},
+	}
+	newTable := func(modName string) *ENS {
+		mod, err := NewENS(modName, "", nil, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		e := mod.(*ENS)
+		e.chain = chain
+		e.registry = ensTestRegistry
+		e.suffixes = []string{"eth"}
+		e.ttl = time.Minute
+		e.negTTL = time.Minute
+		return e
+	}
+
+	test := func(e *ENS, key, expected string) {
+		t.Helper()
+		val, ok, err := e.Lookup(ctx, key)
+		if err != nil {
+			t.Fatalf("Lookup(%q): %v", key, err)
+		}
+		if expected == "" && ok {
+			t.Errorf("Lookup(%q) = %q, want not found", key, val)
+		}
+		if expected != "" && (!ok || val != expected) {
+			t.Errorf("Lookup(%q) = %q, %v, want %q", key, val, ok, expected)
+		}
+	}
+
+	forward := newTable("table.ens")
+	test(forward, "alice.eth", "0x00000000000000000000000000000000000a11ce")
+	test(forward, "ALICE.eth", "0x00000000000000000000000000000000000a11ce")
+	test(forward, "bob.eth", "")
+	test(forward, "alice.mailchat", "")
+	test(forward, "alice.eth@example.org", "")
+	test(forward, "alice..eth", "")
+
+	// Results, including negative ones, are cached.
+	calls := chain.calls
+	chain.fail = true
+	test(forward, "alice.eth", "0x00000000000000000000000000000000000a11ce")
+	test(forward, "bob.eth", "")
+	if chain.calls != calls {
+		t.Error("cached results are not used")
+	}
+	if _, _, err := forward.Lookup(ctx, "carol.eth"); err == nil {
+		t.Error("RPC error is not reported")
+	}
+	chain.fail = false
+
+	forward.suffixes = []string{"eth", "mailchat"}
+	test(forward, "alice.mailchat", "0x00000000000000000000000000000000000a11ce")
+
+	vals, err := forward.LookupMulti(ctx, "alice.eth")
+	if err != nil || len(vals) != 1 || vals[0] != "0x00000000000000000000000000000000000a11ce" {
+		t.Errorf("LookupMulti = %v, %v", vals, err)
+	}
+
+	reverse := newTable("table.ens_reverse")
+	test(reverse, "0x00000000000000000000000000000000000A11CE", "alice.eth")
+	test(reverse, "0x00000000000000000000000000000000000a11ce@example.org", "alice.eth")
+	test(reverse, mallory.Hex(), "")
+	test(reverse, "alice.eth", "")
+}
@@ -0,0 +1,68 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package table
+
+import (
+	"context"
+	"sync"
+)
+
+// Memory is the module.MutableTable that keeps entries in memory.
+//
+// It is not a module and is used by modules as a non-persistent default when
+// no table is configured.
+type Memory struct {
+	lck sync.Mutex
+	m   map[string]string
+}
+
+func NewMemory() *Memory {
+	return &Memory{m: make(map[string]string)}
+}
+
+func (t *Memory) Lookup(_ context.Context, key string) (string, bool, error) {
+	t.lck.Lock()
+	defer t.lck.Unlock()
+	val, ok := t.m[key]
+	return val, ok, nil
+}
+
+func (t *Memory) Keys() ([]string, error) {
+	t.lck.Lock()
+	defer t.lck.Unlock()
+	keys := make([]string, 0, len(t.m))
+	for k := range t.m {
+		keys = append(keys, k)
+	}
+	return keys, nil
+}
+
+func (t *Memory) RemoveKey(key string) error {
+	t.lck.Lock()
+	defer t.lck.Unlock()
+	delete(t.m, key)
+	return nil
+}
+
+func (t *Memory) SetKey(key, value string) error {
+	t.lck.Lock()
+	defer t.lck.Unlock()
+	t.m[key] = value
+	return nil
+}
@@ -21,14 +21,18 @@ package target
 import (
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 )
 
 func DeliveryLogger(l log.Logger, msgMeta *module.MsgMetadata) log.Logger {
-	fields := make(map[string]interface{}, len(l.Fields)+1)
+	fields := make(map[string]interface{}, len(l.Fields)+2)
 	for k, v := range l.Fields {
 		fields[k] = v
 	}
 	fields["msg_id"] = msgMeta.ID
+	if traceID := tracing.TraceID(msgMeta.TraceParent); traceID != "" {
+		fields["trace_id"] = traceID
+	}
 	l.Fields = fields
 	return l
 }
@@ -0,0 +1,347 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package http_target implements the target.http module that delivers
+// messages to an HTTP endpoint (webhook).
+//
+// Each message is submitted using a POST request either as is
+// (message/rfc822) or converted to a JSON document. Requests can be signed
+// using HMAC-SHA256 so the receiving side can verify their origin.
+//
+// Interfaces implemented:
+// - module.DeliveryTarget
+// - module.PartialDelivery
+package http_target
+
+import (
+	"bytes"
+	"context"
+	"crypto/hmac"
+	"crypto/sha256"
+	"crypto/tls"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"runtime/trace"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	tls2 "github.com/mail-chat-chain/mailchatd/framework/config/tls"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target"
+)
+
+const modName = "target.http"
+
+const (
+	FormatJSON = "json"
+	FormatRaw  = "raw"
+
+	AttachmentsBase64    = "base64"
+	AttachmentsMultipart = "multipart"
+)
+
+type Target struct {
+	instName string
+	log      log.Logger
+
+	endpoint     string
+	format       string
+	attachments  string
+	secret       []byte
+	perRecipient bool
+	client       *http.Client
+
+	// now is used to generate signature timestamps. Replaced in tests.
+	now func() time.Time
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	t := &Target{
+		instName: instName,
+		log:      log.Logger{Name: modName},
+		now:      time.Now,
+	}
+	switch len(inlineArgs) {
+	case 0:
+	case 1:
+		t.endpoint = inlineArgs[0]
+	default:
+		return nil, fmt.Errorf("%s: at most one inline argument is expected (endpoint URL)", modName)
+	}
+	return t, nil
+}
+
+func (t *Target) Init(cfg *config.Map) error {
+	var (
+		secret    string
+		timeout   time.Duration
+		tlsConfig tls.Config
+	)
+
+	cfg.Bool("debug", true, false, &t.log.Debug)
+	cfg.String("endpoint", false, t.endpoint == "", t.endpoint, &t.endpoint)
+	cfg.Enum("format", false, false, []string{FormatJSON, FormatRaw}, FormatJSON, &t.format)
+	cfg.Enum("attachments", false, false, []string{AttachmentsBase64, AttachmentsMultipart}, AttachmentsBase64, &t.attachments)
+	cfg.String("secret", false, false, "", &secret)
+	cfg.Bool("per_recipient", false, false, &t.perRecipient)
+	cfg.Duration("timeout", false, false, 30*time.Second, &timeout)
+	cfg.Custom("tls_client", true, false, func() (interface{}, error) {
+		return tls.Config{}, nil
+	}, tls2.TLSClientBlock, &tlsConfig)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	endpoint, err := url.Parse(t.endpoint)
+	if err != nil {
+		return fmt.Errorf("%s: malformed endpoint URL: %w", modName, err)
+	}
+	if endpoint.Scheme != "http" && endpoint.Scheme != "https" {
+		return fmt.Errorf("%s: endpoint URL should use http or https scheme", modName)
+	}
+	t.secret = []byte(secret)
+
+	t.client = &http.Client{
+		Transport: &http.Transport{
+			Proxy:           http.ProxyFromEnvironment,
+			TLSClientConfig: &tlsConfig,
+		},
+		Timeout: timeout,
+	}
+
+	return nil
+}
+
+func (t *Target) Name() string {
+	return modName
+}
+
+func (t *Target) InstanceName() string {
+	return t.instName
+}
+
+type delivery struct {
+	t        *Target
+	mailFrom string
+	log      log.Logger
+	msgMeta  *module.MsgMetadata
+	rcpts    []string
+}
+
+func (t *Target) Start(ctx context.Context, msgMeta *module.MsgMetadata, mailFrom string) (module.Delivery, error) {
+	return &delivery{
+		t:        t,
+		mailFrom: mailFrom,
+		log:      target.DeliveryLogger(t.log, msgMeta),
+		msgMeta:  msgMeta,
+	}, nil
+}
+
+func (d *delivery) AddRcpt(ctx context.Context, rcptTo string, _ smtp.RcptOptions) error {
+	for _, rcpt := range d.rcpts {
+		if rcpt == rcptTo {
+			return nil
+		}
+	}
+	d.rcpts = append(d.rcpts, rcptTo)
+	return nil
+}
+
+// Body submits the message to the endpoint.
+//
+// HTTP requests can't be rolled back, so the message is considered delivered
+// once the endpoint accepts it, Commit and Abort are no-op.
+func (d *delivery) Body(ctx context.Context, header textproto.Header, body buffer.Buffer) error {
+	if !d.t.perRecipient {
+		return d.post(ctx, d.rcpts, header, body)
+	}
+	for _, rcpt := range d.rcpts {
+		if err := d.post(ctx, []string{rcpt}, header, body); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func (d *delivery) BodyNonAtomic(ctx context.Context, c module.StatusCollector, header textproto.Header, body buffer.Buffer) {
+	if !d.t.perRecipient {
+		err := d.post(ctx, d.rcpts, header, body)
+		for _, rcpt := range d.rcpts {
+			c.SetStatus(rcpt, err)
+		}
+		return
+	}
+	for _, rcpt := range d.rcpts {
+		c.SetStatus(rcpt, d.post(ctx, []string{rcpt}, header, body))
+	}
+}
+
+func (d *delivery) Abort(ctx context.Context) error {
+	return nil
+}
+
+func (d *delivery) Commit(ctx context.Context) error {
+	return nil
+}
+
+// sign computes the value of the X-Webhook-Signature header field.
+//
+// The signed string is the value of X-Webhook-Timestamp, a dot and the
+// request body.
+func (t *Target) sign(timestamp string, payload []byte) string {
+	mac := hmac.New(sha256.New, t.secret)
+	mac.Write([]byte(timestamp))
+	mac.Write([]byte{'.'})
+	mac.Write(payload)
+	return "sha256=" + hex.EncodeToString(mac.Sum(nil))
+}
+
+func (d *delivery) post(ctx context.Context, rcpts []string, header textproto.Header, body buffer.Buffer) error {
+	defer trace.StartRegion(ctx, "target.http/post").End()
+
+	var (
+		payload     []byte
+		contentType string
+		err         error
+	)
+	if d.t.format == FormatRaw {
+		payload, err = rawPayload(header, body)
+		contentType = "message/rfc822"
+	} else {
+		payload, contentType, err = jsonPayload(d.msgMeta, d.mailFrom, rcpts, header, body, d.t.attachments)
+	}
+	if err != nil {
+		return &exterrors.SMTPError{
+			Code:         554,
+			EnhancedCode: exterrors.EnhancedCode{5, 6, 0},
+			Message:      "Unable to process the message content",
+			TargetName:   modName,
+			Err:          err,
+		}
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, d.t.endpoint, bytes.NewReader(payload))
+	if err != nil {
+		return d.networkError(err)
+	}
+	req.Header.Set("Content-Type", contentType)
+	req.Header.Set("User-Agent", "mailchatd/"+modName)
+	req.Header.Set("X-Webhook-Id", d.msgMeta.ID)
+	req.Header.Set("X-Webhook-Mail-From", d.mailFrom)
+	req.Header.Set("X-Webhook-Rcpt-To", strings.Join(rcpts, ", "))
+	if len(d.t.secret) != 0 {
+		timestamp := strconv.FormatInt(d.t.now().Unix(), 10)
+		req.Header.Set("X-Webhook-Timestamp", timestamp)
+		req.Header.Set("X-Webhook-Signature", d.t.sign(timestamp, payload))
+	}
+
+	resp, err := d.t.client.Do(req)
+	if err != nil {
+		return d.networkError(err)
+	}
+	defer resp.Body.Close()
+
+	respBody, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+	if err := responseError(resp.StatusCode, resp.Status, respBody); err != nil {
+		return err
+	}
+
+	d.log.DebugMsg("message submitted", "rcpts", rcpts, "status", resp.StatusCode)
+	return nil
+}
+
+func (d *delivery) networkError(err error) error {
+	return &exterrors.SMTPError{
+		Code:         451,
+		EnhancedCode: exterrors.EnhancedCode{4, 4, 2},
+		Message:      "Network I/O error",
+		TargetName:   modName,
+		Err:          err,
+	}
+}
+
+// responseError maps the HTTP response status to the SMTP error.
+//
+// 408, 429 and 5xx are considered temporary failures so the message will be
+// retried if target.queue is used. Other non-2xx codes are permanent
+// failures.
+func responseError(code int, status string, body []byte) error {
+	if code >= 200 && code < 300 {
+		return nil
+	}
+
+	reason := "HTTP " + status
+	if len(body) != 0 {
+		reason += ": " + strings.TrimSpace(string(body))
+	}
+	err := errors.New(reason)
+
+	switch {
+	case code == http.StatusRequestTimeout, code == http.StatusTooManyRequests, code >= 500:
+		return &exterrors.SMTPError{
+			Code:         451,
+			EnhancedCode: exterrors.EnhancedCode{4, 3, 0},
+			Message:      "Temporary delivery failure, try again later",
+			TargetName:   modName,
+			Err:          err,
+			Misc:         map[string]interface{}{"http_status": code},
+		}
+	case code == http.StatusNotFound, code == http.StatusGone:
+		return &exterrors.SMTPError{
+			Code:         550,
+			EnhancedCode: exterrors.EnhancedCode{5, 1, 1},
+			Message:      "Recipient rejected",
+			TargetName:   modName,
+			Err:          err,
+			Misc:         map[string]interface{}{"http_status": code},
+		}
+	case code == http.StatusRequestEntityTooLarge:
+		return &exterrors.SMTPError{
+			Code:         552,
+			EnhancedCode: exterrors.EnhancedCode{5, 3, 4},
+			Message:      "Message too big",
+			TargetName:   modName,
+			Err:          err,
+			Misc:         map[string]interface{}{"http_status": code},
+		}
+	default:
+		return &exterrors.SMTPError{
+			Code:         554,
+			EnhancedCode: exterrors.EnhancedCode{5, 0, 0},
+			Message:      "Message rejected",
+			TargetName:   modName,
+			Err:          err,
+			Misc:         map[string]interface{}{"http_status": code},
+		}
+	}
+}
+
+func init() {
+	module.Register(modName, New)
+}
@@ -0,0 +1,256 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package http_target
+
+import (
+	"context"
+	"encoding/json"
+	"io"
+	"mime"
+	"mime/multipart"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+const testMsg = "--BOUNDARY\r\n" +
+	"Content-Type: text/plain; charset=utf-8\r\n" +
+	"\r\n" +
+	"Hello!\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: text/html\r\n" +
+	"\r\n" +
+	"<p>Hello!</p>\r\n" +
+	"--BOUNDARY\r\n" +
+	"Content-Type: application/octet-stream\r\n" +
+	"Content-Disposition: attachment; filename=\"data.bin\"\r\n" +
+	"Content-Transfer-Encoding: base64\r\n" +
+	"\r\n" +
+	"AAEC\r\n" +
+	"--BOUNDARY--\r\n"
+
+func testHeader() textproto.Header {
+	hdr := textproto.Header{}
+	hdr.Add("From", "sender@example.org")
+	hdr.Add("Subject", "=?utf-8?q?Caf=C3=A9?=")
+	hdr.Add("Content-Type", `multipart/mixed; boundary="BOUNDARY"`)
+	return hdr
+}
+
+func testTarget(t *testing.T, endpoint string) *Target {
+	return &Target{
+		log:         testutils.Logger(t, modName),
+		endpoint:    endpoint,
+		format:      FormatJSON,
+		attachments: AttachmentsBase64,
+		secret:      []byte("secret"),
+		client:      http.DefaultClient,
+		now:         func() time.Time { return time.Unix(1700000000, 0) },
+	}
+}
+
+func deliver(t *testing.T, tgt *Target, rcpts ...string) error {
+	t.Helper()
+	ctx := context.Background()
+	d, err := tgt.Start(ctx, &module.MsgMetadata{ID: "test-id"}, "sender@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rcpt := range rcpts {
+		if err := d.AddRcpt(ctx, rcpt, smtp.RcptOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+	if err := d.Body(ctx, testHeader(), buffer.MemoryBuffer{Slice: []byte(testMsg)}); err != nil {
+		return err
+	}
+	return d.Commit(ctx)
+}
+
+func TestHTTP_JSON(t *testing.T) {
+	var (
+		got       jsonMessage
+		signature string
+		rawBody   []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		rawBody, _ = io.ReadAll(r.Body)
+		signature = r.Header.Get("X-Webhook-Signature")
+		if err := json.Unmarshal(rawBody, &got); err != nil {
+			t.Error(err)
+		}
+	}))
+	defer srv.Close()
+
+	tgt := testTarget(t, srv.URL)
+	if err := deliver(t, tgt, "bot@example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	if signature != tgt.sign("1700000000", rawBody) {
+		t.Errorf("wrong signature: %s", signature)
+	}
+	if got.ID != "test-id" || got.Envelope.MailFrom != "sender@example.org" || len(got.Envelope.RcptTo) != 1 {
+		t.Errorf("wrong envelope: %+v", got)
+	}
+	if got.Subject != "Café" {
+		t.Errorf("wrong subject: %q", got.Subject)
+	}
+	if got.Text != "Hello!" || got.HTML != "<p>Hello!</p>" {
+		t.Errorf("wrong text parts: %q %q", got.Text, got.HTML)
+	}
+	if len(got.Attachments) != 1 || got.Attachments[0].Filename != "data.bin" || got.Attachments[0].Content != "AAEC" {
+		t.Errorf("wrong attachments: %+v", got.Attachments)
+	}
+}
+
+func TestHTTP_Multipart(t *testing.T) {
+	var (
+		got  jsonMessage
+		data []byte
+	)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		_, params, err := mime.ParseMediaType(r.Header.Get("Content-Type"))
+		if err != nil {
+			t.Error(err)
+			return
+		}
+		mr := multipart.NewReader(r.Body, params["boundary"])
+		for {
+			part, err := mr.NextPart()
+			if err != nil {
+				break
+			}
+			content, _ := io.ReadAll(part)
+			switch part.FormName() {
+			case "message":
+				if err := json.Unmarshal(content, &got); err != nil {
+					t.Error(err)
+				}
+			case "attachment0":
+				data = content
+			}
+		}
+	}))
+	defer srv.Close()
+
+	tgt := testTarget(t, srv.URL)
+	tgt.attachments = AttachmentsMultipart
+	if err := deliver(t, tgt, "bot@example.org"); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(got.Attachments) != 1 || got.Attachments[0].Part != "attachment0" || got.Attachments[0].Content != "" {
+		t.Errorf("wrong attachments: %+v", got.Attachments)
+	}
+	if string(data) != "\x00\x01\x02" {
+		t.Errorf("wrong attachment content: %v", data)
+	}
+}
+
+func TestHTTP_Raw(t *testing.T) {
+	var got string
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("Content-Type") != "message/rfc822" {
+			t.Errorf("wrong content type: %s", r.Header.Get("Content-Type"))
+		}
+		body, _ := io.ReadAll(r.Body)
+		got = string(body)
+	}))
+	defer srv.Close()
+
+	tgt := testTarget(t, srv.URL)
+	tgt.format = FormatRaw
+	if err := deliver(t, tgt, "bot@example.org"); err != nil {
+		t.Fatal(err)
+	}
+	if !strings.Contains(got, "From: sender@example.org\r\n") || !strings.HasSuffix(got, testMsg) {
+		t.Errorf("wrong message: %q", got)
+	}
+}
+
+func TestHTTP_Status(t *testing.T) {
+	test := func(status int, temporary bool) {
+		t.Helper()
+		srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			w.WriteHeader(status)
+		}))
+		defer srv.Close()
+
+		err := deliver(t, testTarget(t, srv.URL), "bot@example.org")
+		if err == nil {
+			t.Fatalf("expected error for HTTP %d", status)
+		}
+		if exterrors.IsTemporary(err) != temporary {
+			t.Errorf("HTTP %d: expected temporary=%v, got %v", status, temporary, err)
+		}
+	}
+
+	test(http.StatusServiceUnavailable, true)
+	test(http.StatusTooManyRequests, true)
+	test(http.StatusBadRequest, false)
+	test(http.StatusNotFound, false)
+}
+
+type statusCollector map[string]error
+
+func (sc statusCollector) SetStatus(rcptTo string, err error) {
+	sc[rcptTo] = err
+}
+
+func TestHTTP_PerRecipient(t *testing.T) {
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.Header.Get("X-Webhook-Rcpt-To") == "unknown@example.org" {
+			w.WriteHeader(http.StatusNotFound)
+		}
+	}))
+	defer srv.Close()
+
+	tgt := testTarget(t, srv.URL)
+	tgt.perRecipient = true
+
+	ctx := context.Background()
+	d, err := tgt.Start(ctx, &module.MsgMetadata{ID: "test-id"}, "sender@example.org")
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, rcpt := range []string{"bot@example.org", "unknown@example.org"} {
+		if err := d.AddRcpt(ctx, rcpt, smtp.RcptOptions{}); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	sc := statusCollector{}
+	d.(module.PartialDelivery).BodyNonAtomic(ctx, sc, testHeader(), buffer.MemoryBuffer{Slice: []byte(testMsg)})
+	if sc["bot@example.org"] != nil {
+		t.Errorf("unexpected error: %v", sc["bot@example.org"])
+	}
+	if sc["unknown@example.org"] == nil {
+		t.Errorf("expected error for unknown recipient")
+	}
+}
@@ -0,0 +1,245 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package http_target
+
+import (
+	"bytes"
+	"encoding/base64"
+	"encoding/json"
+	"io"
+	"mime/multipart"
+	"net/textproto"
+	"strconv"
+	"strings"
+
+	"github.com/emersion/go-message"
+	_ "github.com/emersion/go-message/charset"
+	"github.com/emersion/go-message/mail"
+	gotextproto "github.com/emersion/go-message/textproto"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+type jsonField struct {
+	Name  string `json:"name"`
+	Value string `json:"value"`
+}
+
+type jsonEnvelope struct {
+	MailFrom string   `json:"mail_from"`
+	RcptTo   []string `json:"rcpt_to"`
+}
+
+type jsonAttachment struct {
+	Filename    string `json:"filename,omitempty"`
+	ContentType string `json:"content_type"`
+	ContentID   string `json:"content_id,omitempty"`
+	Size        int    `json:"size"`
+
+	// Content is the base64-encoded attachment body. Set only if
+	// 'attachments base64' is used.
+	Content string `json:"content,omitempty"`
+
+	// Part is the name of the multipart/form-data part that contains the
+	// attachment body. Set only if 'attachments multipart' is used.
+	Part string `json:"part,omitempty"`
+
+	body []byte
+}
+
+type jsonMessage struct {
+	ID          string           `json:"id"`
+	Envelope    jsonEnvelope     `json:"envelope"`
+	Headers     []jsonField      `json:"headers"`
+	Subject     string           `json:"subject,omitempty"`
+	Text        string           `json:"text,omitempty"`
+	HTML        string           `json:"html,omitempty"`
+	Attachments []jsonAttachment `json:"attachments,omitempty"`
+}
+
+func rawPayload(header gotextproto.Header, body buffer.Buffer) ([]byte, error) {
+	var buf bytes.Buffer
+	if err := gotextproto.WriteHeader(&buf, header); err != nil {
+		return nil, err
+	}
+	r, err := body.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+	if _, err := io.Copy(&buf, r); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func ignorableErr(err error) bool {
+	return message.IsUnknownCharset(err) || message.IsUnknownEncoding(err)
+}
+
+// parseMessage converts the message into the JSON representation. Text
+// parts are decoded to UTF-8, the first text/plain and text/html parts that
+// are not attachments are used as the message text.
+func parseMessage(msgMeta *module.MsgMetadata, mailFrom string, rcpts []string, header gotextproto.Header, body buffer.Buffer) (*jsonMessage, error) {
+	msg := &jsonMessage{
+		ID: msgMeta.ID,
+		Envelope: jsonEnvelope{
+			MailFrom: mailFrom,
+			RcptTo:   rcpts,
+		},
+		Headers: []jsonField{},
+	}
+
+	for fields := header.Fields(); fields.Next(); {
+		msg.Headers = append(msg.Headers, jsonField{Name: fields.Key(), Value: fields.Value()})
+	}
+	mailHdr := mail.Header{Header: message.Header{Header: header}}
+	if subject, err := mailHdr.Subject(); err == nil {
+		msg.Subject = subject
+	} else {
+		msg.Subject = header.Get("Subject")
+	}
+
+	r, err := body.Open()
+	if err != nil {
+		return nil, err
+	}
+	defer r.Close()
+
+	ent, err := message.New(message.Header{Header: header}, r)
+	if err != nil && !ignorableErr(err) {
+		return nil, err
+	}
+
+	err = ent.Walk(func(_ []int, part *message.Entity, err error) error {
+		if err != nil && !ignorableErr(err) {
+			return err
+		}
+
+		mediaType, ctParams, _ := part.Header.ContentType()
+		if strings.HasPrefix(mediaType, "multipart/") {
+			return nil
+		}
+		if mediaType == "" {
+			mediaType = "text/plain"
+		}
+		disp, dispParams, _ := part.Header.ContentDisposition()
+		filename := dispParams["filename"]
+		if filename == "" {
+			filename = ctParams["name"]
+		}
+
+		content, err := io.ReadAll(part.Body)
+		if err != nil {
+			return err
+		}
+
+		if disp != "attachment" && filename == "" {
+			switch {
+			case mediaType == "text/plain" && msg.Text == "":
+				msg.Text = string(content)
+				return nil
+			case mediaType == "text/html" && msg.HTML == "":
+				msg.HTML = string(content)
+				return nil
+			}
+		}
+
+		msg.Attachments = append(msg.Attachments, jsonAttachment{
+			Filename:    filename,
+			ContentType: mediaType,
+			ContentID:   strings.Trim(part.Header.Get("Content-Id"), "<>"),
+			Size:        len(content),
+			body:        content,
+		})
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return msg, nil
+}
+
+// jsonPayload builds the request body for 'format json'. It is either
+// application/json document or multipart/form-data with the document in the
+// "message" part and attachments in the "attachmentN" parts.
+func jsonPayload(msgMeta *module.MsgMetadata, mailFrom string, rcpts []string, header gotextproto.Header, body buffer.Buffer, attachments string) ([]byte, string, error) {
+	msg, err := parseMessage(msgMeta, mailFrom, rcpts, header, body)
+	if err != nil {
+		return nil, "", err
+	}
+
+	if attachments != AttachmentsMultipart || len(msg.Attachments) == 0 {
+		for i := range msg.Attachments {
+			msg.Attachments[i].Content = base64.StdEncoding.EncodeToString(msg.Attachments[i].body)
+		}
+		payload, err := json.Marshal(msg)
+		return payload, "application/json", err
+	}
+
+	for i := range msg.Attachments {
+		msg.Attachments[i].Part = "attachment" + strconv.Itoa(i)
+	}
+	doc, err := json.Marshal(msg)
+	if err != nil {
+		return nil, "", err
+	}
+
+	var buf bytes.Buffer
+	mw := multipart.NewWriter(&buf)
+	partHdr := textproto.MIMEHeader{}
+	partHdr.Set("Content-Disposition", `form-data; name="message"`)
+	partHdr.Set("Content-Type", "application/json")
+	w, err := mw.CreatePart(partHdr)
+	if err != nil {
+		return nil, "", err
+	}
+	if _, err := w.Write(doc); err != nil {
+		return nil, "", err
+	}
+
+	for _, att := range msg.Attachments {
+		filename := att.Filename
+		if filename == "" {
+			filename = att.Part
+		}
+		partHdr := textproto.MIMEHeader{}
+		partHdr.Set("Content-Disposition", `form-data; name="`+att.Part+`"; filename="`+escapeQuotes(filename)+`"`)
+		partHdr.Set("Content-Type", att.ContentType)
+		w, err := mw.CreatePart(partHdr)
+		if err != nil {
+			return nil, "", err
+		}
+		if _, err := w.Write(att.body); err != nil {
+			return nil, "", err
+		}
+	}
+	if err := mw.Close(); err != nil {
+		return nil, "", err
+	}
+
+	return buf.Bytes(), mw.FormDataContentType(), nil
+}
+
+var quoteEscaper = strings.NewReplacer(`\`, `\\`, `"`, `\"`)
+
+func escapeQuotes(s string) string {
+	return quoteEscaper.Replace(s)
+}
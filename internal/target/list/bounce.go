@@ -0,0 +1,84 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package list
+
+import (
+	"bufio"
+	"io"
+	"mime"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+)
+
+// failedRecipients checks whether the message is a delivery status
+// notification (RFC 3464) and returns the recipients it reports a permanent
+// failure (5.X.X status) for, normalized using address.ForLookup.
+func failedRecipients(hdr textproto.Header, body io.Reader) []string {
+	mediaType, params, err := mime.ParseMediaType(hdr.Get("Content-Type"))
+	if err != nil || mediaType != "multipart/report" ||
+		!strings.EqualFold(params["report-type"], "delivery-status") || params["boundary"] == "" {
+		return nil
+	}
+
+	mr := textproto.NewMultipartReader(body, params["boundary"])
+	for {
+		part, err := mr.NextPart()
+		if err != nil {
+			return nil
+		}
+		partType, _, _ := mime.ParseMediaType(part.Header.Get("Content-Type"))
+		if partType != "message/delivery-status" && partType != "message/global-delivery-status" {
+			continue
+		}
+		return permanentFailures(part)
+	}
+}
+
+func permanentFailures(r io.Reader) []string {
+	br := bufio.NewReader(r)
+
+	// Per-message fields go first, followed by per-recipient field groups.
+	if _, err := textproto.ReadHeader(br); err != nil {
+		return nil
+	}
+	var failed []string
+	for {
+		fields, err := textproto.ReadHeader(br)
+		if err != nil || fields.Len() == 0 {
+			return failed
+		}
+		action := strings.ToLower(strings.TrimSpace(fields.Get("Action")))
+		status := strings.TrimSpace(fields.Get("Status"))
+		if action != "failed" || !strings.HasPrefix(status, "5.") {
+			continue
+		}
+		// Final-Recipient: rfc822; user@example.org (RFC 3464, Section 2.3.2).
+		_, rcpt, ok := strings.Cut(fields.Get("Final-Recipient"), ";")
+		if !ok {
+			continue
+		}
+		rcpt, err = address.ForLookup(strings.TrimSpace(rcpt))
+		if err != nil {
+			continue
+		}
+		failed = append(failed, rcpt)
+	}
+}
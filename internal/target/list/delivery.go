@@ -0,0 +1,456 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package list
+
+import (
+	"context"
+	"mime"
+	"net/mail"
+	"net/url"
+	"slices"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target"
+)
+
+type listMember struct {
+	list   *List
+	member string
+}
+
+type delivery struct {
+	t        *Target
+	mailFrom string
+	msgMeta  *module.MsgMetadata
+	log      log.Logger
+
+	lists    []*List
+	bounces  []listMember
+	unsubs   []*List
+	confirms []listMember
+
+	// failedRcpts are the recipients the message received at the bounces
+	// address reports a permanent failure for, if it is a DSN.
+	failedRcpts []string
+
+	downstream []module.Delivery
+}
+
+func (t *Target) Start(ctx context.Context, msgMeta *module.MsgMetadata, mailFrom string) (module.Delivery, error) {
+	return &delivery{
+		t:        t,
+		mailFrom: mailFrom,
+		msgMeta:  msgMeta,
+		log:      target.DeliveryLogger(t.log, msgMeta),
+	}, nil
+}
+
+// mayPost checks whether the sender is allowed to post to the list.
+func (t *Target) mayPost(ctx context.Context, l *List, sender string) (bool, error) {
+	for _, poster := range l.Posters {
+		switch poster {
+		case PostersAnyone:
+			return true, nil
+		case PostersMembers:
+			if sender == "" {
+				continue
+			}
+			ok, err := t.isMember(ctx, l, sender)
+			if err != nil {
+				return false, err
+			}
+			if ok {
+				return true, nil
+			}
+		default:
+			if sender != "" && poster == sender {
+				return true, nil
+			}
+		}
+	}
+	return false, nil
+}
+
+// decodeMember decodes the member address encoded in the local part as
+// mbox=domain.
+func decodeMember(encoded string) (string, bool) {
+	idx := strings.LastIndexByte(encoded, '=')
+	if idx <= 0 || idx == len(encoded)-1 {
+		return "", false
+	}
+	return encoded[:idx] + "@" + encoded[idx+1:], true
+}
+
+// matchSpecial checks whether the address is one of the service addresses of
+// a configured list (<list>-bounces[+<member>]@, <list>-unsubscribe@,
+// <list>-unsubscribe+<token>@).
+func (t *Target) matchSpecial(rcpt string) (l *List, kind, member, token string) {
+	mbox, domain, err := address.Split(rcpt)
+	if err != nil {
+		return nil, "", "", ""
+	}
+	for _, l := range t.lists {
+		listMbox, listDomain := l.local()
+		if listDomain != domain || !strings.HasPrefix(mbox, listMbox+"-") {
+			continue
+		}
+		suffix := strings.TrimPrefix(mbox, listMbox+"-")
+		switch {
+		case suffix == "unsubscribe":
+			return l, "unsubscribe", "", ""
+		case strings.HasPrefix(suffix, "unsubscribe+"):
+			return l, "unsubscribe-confirm", "", strings.TrimPrefix(suffix, "unsubscribe+")
+		case suffix == "bounces":
+			return l, "bounces", "", ""
+		case strings.HasPrefix(suffix, "bounces+"):
+			member, ok := decodeMember(strings.TrimPrefix(suffix, "bounces+"))
+			if !ok {
+				continue
+			}
+			return l, "bounces", member, ""
+		}
+	}
+	return nil, "", "", ""
+}
+
+func (d *delivery) AddRcpt(ctx context.Context, rcptTo string, _ smtp.RcptOptions) error {
+	rcpt, err := address.ForLookup(rcptTo)
+	if err != nil {
+		return &exterrors.SMTPError{
+			Code:         553,
+			EnhancedCode: exterrors.EnhancedCode{5, 1, 3},
+			Message:      "Malformed recipient address",
+			TargetName:   modName,
+			Err:          err,
+		}
+	}
+
+	if l, ok := d.t.lists[rcpt]; ok {
+		sender, err := address.ForLookup(d.mailFrom)
+		if err != nil {
+			sender = ""
+		}
+		ok, err := d.t.mayPost(ctx, l, sender)
+		if err != nil {
+			return &exterrors.SMTPError{
+				Code:         451,
+				EnhancedCode: exterrors.EnhancedCode{4, 3, 0},
+				Message:      "Internal error during list lookup",
+				TargetName:   modName,
+				Err:          err,
+			}
+		}
+		if !ok {
+			return &exterrors.SMTPError{
+				Code:         550,
+				EnhancedCode: exterrors.EnhancedCode{5, 7, 1},
+				Message:      "You are not allowed to post to this list",
+				TargetName:   modName,
+				Misc:         map[string]interface{}{"list": l.Address},
+			}
+		}
+		d.lists = append(d.lists, l)
+		return nil
+	}
+
+	l, kind, member, token := d.t.matchSpecial(rcpt)
+	if kind == "unsubscribe-confirm" {
+		var ok bool
+		member, ok, err = d.t.confirmMember(l, token)
+		if err != nil {
+			return &exterrors.SMTPError{
+				Code:         451,
+				EnhancedCode: exterrors.EnhancedCode{4, 3, 0},
+				Message:      "Internal error during list lookup",
+				TargetName:   modName,
+				Err:          err,
+			}
+		}
+		if !ok {
+			kind = "invalid-confirm"
+		}
+	}
+	switch kind {
+	case "bounces":
+		d.bounces = append(d.bounces, listMember{list: l, member: member})
+		return nil
+	case "unsubscribe":
+		d.unsubs = append(d.unsubs, l)
+		return nil
+	case "unsubscribe-confirm":
+		d.confirms = append(d.confirms, listMember{list: l, member: member})
+		return nil
+	case "invalid-confirm":
+		return &exterrors.SMTPError{
+			Code:         550,
+			EnhancedCode: exterrors.EnhancedCode{5, 1, 1},
+			Message:      "Invalid unsubscription confirmation address",
+			TargetName:   modName,
+			Misc:         map[string]interface{}{"list": l.Address},
+		}
+	}
+
+	return &exterrors.SMTPError{
+		Code:         550,
+		EnhancedCode: exterrors.EnhancedCode{5, 1, 1},
+		Message:      "No such list",
+		TargetName:   modName,
+	}
+}
+
+func (d *delivery) Body(ctx context.Context, header textproto.Header, body buffer.Buffer) error {
+	for _, l := range d.lists {
+		if strings.Contains(header.Get("List-Id"), "<"+l.ID()+">") {
+			return &exterrors.SMTPError{
+				Code:         554,
+				EnhancedCode: exterrors.EnhancedCode{5, 4, 6},
+				Message:      "Mail loop detected",
+				TargetName:   modName,
+				Misc:         map[string]interface{}{"list": l.Address},
+			}
+		}
+	}
+
+	for _, l := range d.lists {
+		if err := d.expand(ctx, l, header, body); err != nil {
+			return err
+		}
+	}
+
+	// Only DSNs count as bounces. They are always sent using the null
+	// reverse-path (RFC 3464, Section 2), anything else is either a reply
+	// or a forgery.
+	if len(d.bounces) != 0 && d.mailFrom == "" {
+		r, err := body.Open()
+		if err != nil {
+			return err
+		}
+		d.failedRcpts = failedRecipients(header, r)
+		r.Close()
+	}
+	return nil
+}
+
+func (d *delivery) expand(ctx context.Context, l *List, header textproto.Header, body buffer.Buffer) error {
+	members, err := d.t.Members(l.Address)
+	if err != nil {
+		return &exterrors.SMTPError{
+			Code:         451,
+			EnhancedCode: exterrors.EnhancedCode{4, 3, 0},
+			Message:      "Internal error during list expansion",
+			TargetName:   modName,
+			Err:          err,
+		}
+	}
+	if len(members) == 0 {
+		d.log.Msg("list has no members", "list", l.Address)
+		return nil
+	}
+
+	// Per-member copies are required if the envelope sender or the
+	// unsubscription link is specific to the member.
+	if l.VERP || d.t.unsubURL != "" {
+		for _, member := range members {
+			hdr := d.t.listHeader(l, header, member)
+			if err := d.submit(ctx, l.BounceAddress(member), []string{member}, hdr, body); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	return d.submit(ctx, l.BounceAddress(""), members, d.t.listHeader(l, header, ""), body)
+}
+
+func (d *delivery) submit(ctx context.Context, mailFrom string, rcpts []string, hdr textproto.Header, body buffer.Buffer) error {
+	copyID, err := module.GenerateMsgID()
+	if err != nil {
+		return err
+	}
+	copyMeta := d.msgMeta.DeepCopy()
+	copyMeta.ID = copyID
+	copyMeta.OriginalFrom = mailFrom
+	copyMeta.OriginalRcpts = nil
+
+	dl, err := d.t.target.Start(ctx, copyMeta, mailFrom)
+	if err != nil {
+		return err
+	}
+	d.downstream = append(d.downstream, dl)
+
+	for _, rcpt := range rcpts {
+		if err := dl.AddRcpt(ctx, rcpt, smtp.RcptOptions{}); err != nil {
+			return err
+		}
+	}
+	return dl.Body(ctx, hdr, body)
+}
+
+// listHeader returns the header of the copy sent to the member. If member is
+// empty, the header is shared by all members.
+func (t *Target) listHeader(l *List, orig textproto.Header, member string) textproto.Header {
+	hdr := orig.Copy()
+
+	hdr.Set("List-Id", phrase(l.Name)+" <"+l.ID()+">")
+	hdr.Set("List-Post", "<mailto:"+l.Address+">")
+
+	listMbox, listDomain := l.local()
+	unsub := "<mailto:" + listMbox + "-unsubscribe@" + listDomain + ">"
+	if t.unsubURL != "" && member != "" {
+		// RFC 8058 one-click unsubscription.
+		query := url.Values{}
+		query.Set("list", l.Address)
+		query.Set("addr", member)
+		query.Set("token", t.unsubscribeToken(l.Address, member))
+		unsub = "<" + t.unsubURL + "?" + query.Encode() + ">, " + unsub
+		hdr.Set("List-Unsubscribe-Post", "List-Unsubscribe=One-Click")
+	}
+	hdr.Set("List-Unsubscribe", unsub)
+	hdr.Set("Precedence", "list")
+
+	if l.RewriteFrom {
+		rewriteFrom(l, &hdr)
+	}
+
+	return hdr
+}
+
+// rewriteFrom replaces the author address with the list address so the copy
+// is aligned with the list domain for DMARC purposes. The original author is
+// preserved in Reply-To unless it is already set.
+func rewriteFrom(l *List, hdr *textproto.Header) {
+	origFrom := hdr.Get("From")
+	if origFrom == "" {
+		return
+	}
+
+	displayName := ""
+	if addrs, err := mail.ParseAddressList(origFrom); err == nil && len(addrs) != 0 {
+		displayName = addrs[0].Name
+		if displayName == "" {
+			displayName = addrs[0].Address
+		}
+	}
+	if displayName == "" {
+		displayName = l.Name
+	} else {
+		displayName += " via " + l.Name
+	}
+
+	hdr.Set("From", (&mail.Address{Name: displayName, Address: l.Address}).String())
+	if !hdr.Has("Reply-To") {
+		hdr.Set("Reply-To", origFrom)
+	}
+	hdr.Set("X-Original-From", origFrom)
+}
+
+// phrase formats the list name for use in the List-Id field.
+func phrase(name string) string {
+	if !address.IsASCII(name) {
+		return mime.QEncoding.Encode("utf-8", name)
+	}
+	return `"` + strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace(name) + `"`
+}
+
+func (d *delivery) Abort(ctx context.Context) error {
+	for _, dl := range d.downstream {
+		if err := dl.Abort(ctx); err != nil {
+			d.log.Error("failed to abort list delivery", err)
+		}
+	}
+	return nil
+}
+
+func (d *delivery) Commit(ctx context.Context) error {
+	for i, dl := range d.downstream {
+		if err := dl.Commit(ctx); err != nil {
+			// Copies that were not committed yet are aborted, the ones already
+			// committed can't be recalled.
+			for _, rest := range d.downstream[i+1:] {
+				if err := rest.Abort(ctx); err != nil {
+					d.log.Error("failed to abort list delivery", err)
+				}
+			}
+			return err
+		}
+	}
+
+	if len(d.bounces) != 0 && len(d.failedRcpts) == 0 {
+		d.log.DebugMsg("message to the bounces address is not a permanent failure DSN, ignoring", "sender", d.mailFrom)
+		d.bounces = nil
+	}
+	for _, b := range d.bounces {
+		if b.member == "" {
+			d.log.DebugMsg("bounce without member information", "list", b.list.Address)
+			continue
+		}
+		// The bounces address is known to everyone who received a copy, so
+		// the DSN should be about the member it was sent to.
+		if !slices.Contains(d.failedRcpts, b.member) {
+			d.log.Msg("ignoring bounce for another recipient", "list", b.list.Address, "member", b.member, "failed_rcpts", d.failedRcpts)
+			continue
+		}
+		if err := d.t.recordBounce(ctx, b.list, b.member); err != nil {
+			d.log.Error("failed to record bounce", err, "list", b.list.Address, "member", b.member)
+		}
+	}
+
+	// The envelope sender is not authenticated so the request is only
+	// confirmed by sending a message to the member.
+	if len(d.unsubs) != 0 {
+		sender, err := address.ForLookup(d.mailFrom)
+		if err != nil || sender == "" {
+			d.log.Msg("ignoring unsubscription request without a valid sender", "sender", d.mailFrom)
+			return nil
+		}
+		for _, l := range d.unsubs {
+			ok, err := d.t.isMember(ctx, l, sender)
+			if err != nil {
+				d.log.Error("failed to check membership", err, "list", l.Address, "member", sender)
+				continue
+			}
+			if !ok {
+				d.log.Msg("ignoring unsubscription request from non-member", "list", l.Address, "sender", sender)
+				continue
+			}
+			if err := d.t.sendUnsubConfirmation(ctx, l, sender); err != nil {
+				d.log.Error("failed to send unsubscription confirmation", err, "list", l.Address, "member", sender)
+				continue
+			}
+			d.log.Msg("unsubscription confirmation sent", "list", l.Address, "member", sender)
+		}
+	}
+
+	for _, c := range d.confirms {
+		if err := d.t.RemoveMember(c.list.Address, c.member); err != nil {
+			d.log.Error("failed to unsubscribe", err, "list", c.list.Address, "member", c.member)
+			continue
+		}
+		d.log.Msg("unsubscribed by confirmed request", "list", c.list.Address, "member", c.member)
+	}
+
+	return nil
+}
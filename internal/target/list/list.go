@@ -0,0 +1,431 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package list implements the target.list module providing server-side
+// distribution lists (mailing lists).
+//
+// Messages delivered to a list address are expanded into a copy per each
+// subscriber with List-* header fields (RFC 2369, RFC 2919, RFC 8058) added
+// and submitted to the configured delivery target (usually the outbound
+// queue).
+//
+// Interfaces implemented:
+// - module.DeliveryTarget
+// - module.Unsubscriber
+package list
+
+import (
+	"context"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+const modName = "target.list"
+
+const (
+	PostersAnyone  = "anyone"
+	PostersMembers = "members"
+)
+
+// List is the configuration of a single distribution list.
+type List struct {
+	// Address is the list posting address, normalized using
+	// address.ForLookup.
+	Address string
+	Name    string
+
+	// Posters contains PostersAnyone, PostersMembers and/or explicit
+	// addresses allowed to post to the list.
+	Posters []string
+
+	// RewriteFrom enables replacement of the From field with the list
+	// address so copies will pass DMARC checks at the subscriber side. The
+	// original value is moved to Reply-To.
+	RewriteFrom bool
+
+	// VERP enables per-subscriber envelope sender so bounces can be
+	// attributed to the specific subscriber.
+	VERP bool
+}
+
+// ID returns the list identifier used in the List-Id field (RFC 2919).
+func (l *List) ID() string {
+	return strings.Replace(l.Address, "@", ".", 1)
+}
+
+func (l *List) local() (mbox, domain string) {
+	mbox, domain, _ = address.Split(l.Address)
+	return
+}
+
+// BounceAddress returns the envelope sender used for the copy sent to the
+// member.
+func (l *List) BounceAddress(member string) string {
+	mbox, domain := l.local()
+	if !l.VERP {
+		return mbox + "-bounces@" + domain
+	}
+	memberMbox, memberDomain, err := address.Split(member)
+	if err != nil {
+		return mbox + "-bounces@" + domain
+	}
+	return mbox + "-bounces+" + memberMbox + "=" + memberDomain + "@" + domain
+}
+
+// UnsubscribeAddress returns the address used to confirm the unsubscription.
+// The token identifies the member, see Target.confirmToken.
+func (l *List) UnsubscribeAddress(token string) string {
+	mbox, domain := l.local()
+	return mbox + "-unsubscribe+" + token + "@" + domain
+}
+
+type Target struct {
+	instName string
+	log      log.Logger
+
+	members module.MutableTable
+	target  module.DeliveryTarget
+	lists   map[string]*List
+
+	unsubURL        string
+	unsubSecret     []byte
+	unsubSecretFile string
+	maxBounces      int
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	if len(inlineArgs) != 0 {
+		return nil, fmt.Errorf("%s: inline arguments are not used", modName)
+	}
+	return &Target{
+		instName: instName,
+		log:      log.Logger{Name: modName},
+		lists:    make(map[string]*List),
+	}, nil
+}
+
+func (t *Target) Init(cfg *config.Map) error {
+	var unsubSecret string
+
+	cfg.Bool("debug", true, false, &t.log.Debug)
+	cfg.Custom("members", false, true, nil, func(m *config.Map, node config.Node) (interface{}, error) {
+		var tbl module.MutableTable
+		err := modconfig.ModuleFromNode("table", node.Args, node, m.Globals, &tbl)
+		return tbl, err
+	}, &t.members)
+	cfg.Custom("target", false, true, nil, modconfig.DeliveryDirective, &t.target)
+	cfg.String("unsubscribe_url", false, false, "", &t.unsubURL)
+	cfg.String("unsubscribe_secret", false, false, "", &unsubSecret)
+	cfg.String("unsubscribe_secret_file", false, false,
+		filepath.Join(config.StateDirectory, "list_"+t.instName+".secret"), &t.unsubSecretFile)
+	cfg.Int("max_bounces", false, false, 5, &t.maxBounces)
+	cfg.Callback("list", func(m *config.Map, node config.Node) error {
+		l, err := parseList(m.Globals, node)
+		if err != nil {
+			return err
+		}
+		if _, ok := t.lists[l.Address]; ok {
+			return config.NodeErr(node, "duplicate list: %s", l.Address)
+		}
+		t.lists[l.Address] = l
+		return nil
+	})
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	if len(t.lists) == 0 {
+		return fmt.Errorf("%s: at least one list should be defined", modName)
+	}
+	if t.unsubURL != "" {
+		if !strings.HasPrefix(t.unsubURL, "https://") {
+			return fmt.Errorf("%s: unsubscribe_url should be an https:// URL (RFC 8058)", modName)
+		}
+		if unsubSecret == "" {
+			return fmt.Errorf("%s: unsubscribe_secret is required if unsubscribe_url is set", modName)
+		}
+	}
+	t.unsubSecret = []byte(unsubSecret)
+	if len(t.unsubSecret) == 0 {
+		// Confirmation addresses sent in reply to unsubscription requests
+		// should stay valid after restart.
+		secret, err := loadOrGenerateSecret(t.unsubSecretFile)
+		if err != nil {
+			return fmt.Errorf("%s: unsubscribe_secret_file: %w", modName, err)
+		}
+		t.unsubSecret = secret
+	}
+
+	return nil
+}
+
+// loadOrGenerateSecret reads the secret from the file, generating the file
+// if it does not exist.
+func loadOrGenerateSecret(path string) ([]byte, error) {
+	blob, err := os.ReadFile(path)
+	if err == nil {
+		secret, err := hex.DecodeString(strings.TrimSpace(string(blob)))
+		if err != nil || len(secret) == 0 {
+			return nil, fmt.Errorf("%s: malformed secret", path)
+		}
+		return secret, nil
+	}
+	if !errors.Is(err, os.ErrNotExist) {
+		return nil, err
+	}
+
+	secret := make([]byte, 32)
+	if _, err := rand.Read(secret); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(secret)+"\n"), 0o600); err != nil {
+		return nil, err
+	}
+	return secret, nil
+}
+
+func parseList(globals map[string]interface{}, node config.Node) (*List, error) {
+	if len(node.Args) != 1 {
+		return nil, config.NodeErr(node, "exactly one argument is required (list address)")
+	}
+	addr, err := address.ForLookup(node.Args[0])
+	if err != nil {
+		return nil, config.NodeErr(node, "invalid list address: %v", err)
+	}
+	if _, _, err := address.Split(addr); err != nil {
+		return nil, config.NodeErr(node, "invalid list address: %v", err)
+	}
+
+	l := &List{Address: addr}
+	cfg := config.NewMap(globals, node)
+	cfg.String("name", false, false, addr, &l.Name)
+	cfg.StringList("posters", false, false, []string{PostersMembers}, &l.Posters)
+	cfg.Bool("rewrite_from", false, true, &l.RewriteFrom)
+	cfg.Bool("verp", false, true, &l.VERP)
+	if _, err := cfg.Process(); err != nil {
+		return nil, err
+	}
+
+	for i, poster := range l.Posters {
+		if poster == PostersAnyone || poster == PostersMembers {
+			continue
+		}
+		l.Posters[i], err = address.ForLookup(poster)
+		if err != nil {
+			return nil, config.NodeErr(node, "invalid poster address: %v", err)
+		}
+	}
+
+	return l, nil
+}
+
+func (t *Target) Name() string {
+	return modName
+}
+
+func (t *Target) InstanceName() string {
+	return t.instName
+}
+
+func memberKey(list, member string) string {
+	return list + "|" + member
+}
+
+func (t *Target) getList(list string) (*List, error) {
+	key, err := address.ForLookup(list)
+	if err != nil {
+		return nil, err
+	}
+	l, ok := t.lists[key]
+	if !ok {
+		return nil, fmt.Errorf("%s: no such list: %s", modName, key)
+	}
+	return l, nil
+}
+
+// Lists returns addresses of all configured lists.
+func (t *Target) Lists() []string {
+	res := make([]string, 0, len(t.lists))
+	for addr := range t.lists {
+		res = append(res, addr)
+	}
+	sort.Strings(res)
+	return res
+}
+
+// AddMember subscribes the address to the list.
+func (t *Target) AddMember(list, member string) error {
+	l, err := t.getList(list)
+	if err != nil {
+		return err
+	}
+	key, err := address.ForLookup(member)
+	if err != nil {
+		return err
+	}
+	if _, _, err := address.Split(key); err != nil {
+		return fmt.Errorf("%s: invalid member address: %w", modName, err)
+	}
+	if err := t.members.SetKey(memberKey(l.Address, key), "0"); err != nil {
+		return fmt.Errorf("%s: add member %s: %w", modName, key, err)
+	}
+	return nil
+}
+
+// RemoveMember unsubscribes the address from the list.
+func (t *Target) RemoveMember(list, member string) error {
+	l, err := t.getList(list)
+	if err != nil {
+		return err
+	}
+	key, err := address.ForLookup(member)
+	if err != nil {
+		return err
+	}
+	if err := t.members.RemoveKey(memberKey(l.Address, key)); err != nil {
+		return fmt.Errorf("%s: remove member %s: %w", modName, key, err)
+	}
+	return nil
+}
+
+// Members returns the list of subscribers.
+func (t *Target) Members(list string) ([]string, error) {
+	l, err := t.getList(list)
+	if err != nil {
+		return nil, err
+	}
+	keys, err := t.members.Keys()
+	if err != nil {
+		return nil, fmt.Errorf("%s: list members: %w", modName, err)
+	}
+
+	prefix := l.Address + "|"
+	res := make([]string, 0, len(keys))
+	for _, k := range keys {
+		if strings.HasPrefix(k, prefix) {
+			res = append(res, strings.TrimPrefix(k, prefix))
+		}
+	}
+	sort.Strings(res)
+	return res, nil
+}
+
+func (t *Target) isMember(ctx context.Context, l *List, addr string) (bool, error) {
+	_, ok, err := t.members.Lookup(ctx, memberKey(l.Address, addr))
+	return ok, err
+}
+
+// recordBounce increments the bounce counter for the member and removes it
+// once max_bounces is reached.
+func (t *Target) recordBounce(ctx context.Context, l *List, member string) error {
+	val, ok, err := t.members.Lookup(ctx, memberKey(l.Address, member))
+	if err != nil {
+		return err
+	}
+	if !ok {
+		return nil
+	}
+	count, _ := strconv.Atoi(val)
+	count++
+
+	if t.maxBounces > 0 && count >= t.maxBounces {
+		t.log.Msg("removing member after too many bounces", "list", l.Address, "member", member, "bounces", count)
+		return t.members.RemoveKey(memberKey(l.Address, member))
+	}
+	return t.members.SetKey(memberKey(l.Address, member), strconv.Itoa(count))
+}
+
+// unsubscribeToken computes the token included in the one-click
+// unsubscription URL.
+func (t *Target) unsubscribeToken(list, member string) string {
+	mac := hmac.New(sha256.New, t.unsubSecret)
+	mac.Write([]byte(list))
+	mac.Write([]byte{0})
+	mac.Write([]byte(member))
+	return hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// confirmToken computes the token of the address used to confirm the
+// unsubscription. It is short to keep the local part within 64 octets and is
+// mapped back to the member by confirmMember.
+func (t *Target) confirmToken(list, member string) string {
+	mac := hmac.New(sha256.New, t.unsubSecret)
+	mac.Write([]byte("confirm"))
+	mac.Write([]byte{0})
+	mac.Write([]byte(list))
+	mac.Write([]byte{0})
+	mac.Write([]byte(member))
+	return hex.EncodeToString(mac.Sum(nil)[:10])
+}
+
+// confirmMember returns the member of the list the confirmation token was
+// issued for.
+func (t *Target) confirmMember(l *List, token string) (string, bool, error) {
+	members, err := t.Members(l.Address)
+	if err != nil {
+		return "", false, err
+	}
+	for _, member := range members {
+		if hmac.Equal([]byte(t.confirmToken(l.Address, member)), []byte(token)) {
+			return member, true, nil
+		}
+	}
+	return "", false, nil
+}
+
+func (t *Target) validToken(list, member, token string) bool {
+	if len(t.unsubSecret) == 0 {
+		return false
+	}
+	return hmac.Equal([]byte(t.unsubscribeToken(list, member)), []byte(token))
+}
+
+// Unsubscribe removes the member from the list if the token is valid.
+func (t *Target) Unsubscribe(list, member, token string) error {
+	l, err := t.getList(list)
+	if err != nil {
+		return err
+	}
+	member, err = address.ForLookup(member)
+	if err != nil {
+		return err
+	}
+	if !t.validToken(l.Address, member, token) {
+		return module.ErrInvalidToken
+	}
+	return t.members.RemoveKey(memberKey(l.Address, member))
+}
+
+func init() {
+	module.Register(modName, New)
+}
@@ -0,0 +1,326 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package list
+
+import (
+	"bytes"
+	"context"
+	"errors"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/dsn"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+func testTarget(t *testing.T, l *List) (*Target, *testutils.Target) {
+	tgt := &testutils.Target{}
+	lt := &Target{
+		log:        testutils.Logger(t, modName),
+		members:    &testutils.MutableTable{},
+		target:     tgt,
+		lists:      map[string]*List{l.Address: l},
+		maxBounces: 2,
+
+		unsubSecret: []byte("secret"),
+	}
+	for _, m := range []string{"alice@example.com", "bob@example.net"} {
+		if err := lt.AddMember(l.Address, m); err != nil {
+			t.Fatal(err)
+		}
+	}
+	return lt, tgt
+}
+
+func deliver(t *testing.T, lt *Target, from, rcpt string, hdr textproto.Header) error {
+	t.Helper()
+	return deliverBody(t, lt, from, rcpt, hdr, []byte("hello\r\n"))
+}
+
+func deliverBody(t *testing.T, lt *Target, from, rcpt string, hdr textproto.Header, body []byte) error {
+	t.Helper()
+	ctx := context.Background()
+	d, err := lt.Start(ctx, &module.MsgMetadata{ID: "test"}, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddRcpt(ctx, rcpt, smtp.RcptOptions{}); err != nil {
+		return err
+	}
+	if err := d.Body(ctx, hdr, buffer.MemoryBuffer{Slice: body}); err != nil {
+		d.Abort(ctx)
+		return err
+	}
+	return d.Commit(ctx)
+}
+
+func smtpCode(err error) int {
+	var smtpErr *exterrors.SMTPError
+	if errors.As(err, &smtpErr) {
+		return smtpErr.Code
+	}
+	return 0
+}
+
+func TestList_Expand(t *testing.T) {
+	l := &List{Address: "team@example.org", Name: "Team", Posters: []string{PostersMembers}}
+	lt, tgt := testTarget(t, l)
+
+	hdr := textproto.Header{}
+	hdr.Add("From", "Alice <alice@example.com>")
+	hdr.Add("Subject", "Hi")
+	if err := deliver(t, lt, "alice@example.com", "team@example.org", hdr); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tgt.Messages) != 1 {
+		t.Fatalf("expected 1 message, got %d", len(tgt.Messages))
+	}
+	msg := tgt.Messages[0]
+	if msg.MailFrom != "team-bounces@example.org" {
+		t.Errorf("wrong envelope sender: %s", msg.MailFrom)
+	}
+	if len(msg.RcptTo) != 2 {
+		t.Errorf("wrong recipients: %v", msg.RcptTo)
+	}
+	if got := msg.Header.Get("List-Id"); got != `"Team" <team.example.org>` {
+		t.Errorf("wrong List-Id: %s", got)
+	}
+	if got := msg.Header.Get("List-Unsubscribe"); got != "<mailto:team-unsubscribe@example.org>" {
+		t.Errorf("wrong List-Unsubscribe: %s", got)
+	}
+	if msg.Header.Get("From") != "Alice <alice@example.com>" {
+		t.Errorf("From should not be rewritten: %s", msg.Header.Get("From"))
+	}
+
+	// Copy coming back to the list should be detected as a loop.
+	if err := deliver(t, lt, "alice@example.com", "team@example.org", msg.Header); smtpCode(err) != 554 {
+		t.Errorf("expected loop to be detected, got %v", err)
+	}
+}
+
+func TestList_Posters(t *testing.T) {
+	l := &List{Address: "team@example.org", Name: "Team", Posters: []string{PostersMembers, "boss@example.org"}}
+	lt, tgt := testTarget(t, l)
+
+	if err := deliver(t, lt, "stranger@example.com", "team@example.org", textproto.Header{}); smtpCode(err) != 550 {
+		t.Errorf("expected non-member to be rejected, got %v", err)
+	}
+	if err := deliver(t, lt, "", "team@example.org", textproto.Header{}); smtpCode(err) != 550 {
+		t.Errorf("expected null sender to be rejected, got %v", err)
+	}
+	if err := deliver(t, lt, "Boss@example.org", "team@example.org", textproto.Header{}); err != nil {
+		t.Errorf("expected explicit poster to be accepted, got %v", err)
+	}
+	if err := deliver(t, lt, "alice@example.com", "other@example.org", textproto.Header{}); smtpCode(err) != 550 {
+		t.Errorf("expected unknown list to be rejected, got %v", err)
+	}
+	if len(tgt.Messages) != 1 {
+		t.Errorf("expected 1 message, got %d", len(tgt.Messages))
+	}
+}
+
+func TestList_VERPAndRewrite(t *testing.T) {
+	l := &List{Address: "team@example.org", Name: "Team", Posters: []string{PostersAnyone}, VERP: true, RewriteFrom: true}
+	lt, tgt := testTarget(t, l)
+	lt.unsubURL = "https://lists.example.org/unsubscribe"
+	lt.unsubSecret = []byte("secret")
+
+	hdr := textproto.Header{}
+	hdr.Add("From", "Carol <carol@example.com>")
+	if err := deliver(t, lt, "carol@example.com", "team@example.org", hdr); err != nil {
+		t.Fatal(err)
+	}
+
+	if len(tgt.Messages) != 2 {
+		t.Fatalf("expected 2 messages, got %d", len(tgt.Messages))
+	}
+	msg := tgt.Messages[0]
+	if msg.MailFrom != "team-bounces+alice=example.com@example.org" {
+		t.Errorf("wrong VERP sender: %s", msg.MailFrom)
+	}
+	if got := msg.Header.Get("From"); got != `"Carol via Team" <team@example.org>` {
+		t.Errorf("wrong From: %s", got)
+	}
+	if got := msg.Header.Get("Reply-To"); got != "Carol <carol@example.com>" {
+		t.Errorf("wrong Reply-To: %s", got)
+	}
+	if msg.Header.Get("List-Unsubscribe-Post") != "List-Unsubscribe=One-Click" {
+		t.Errorf("missing List-Unsubscribe-Post")
+	}
+	if !strings.HasPrefix(msg.Header.Get("List-Unsubscribe"), "<https://lists.example.org/unsubscribe?") {
+		t.Errorf("wrong List-Unsubscribe: %s", msg.Header.Get("List-Unsubscribe"))
+	}
+
+	token := lt.unsubscribeToken("team@example.org", "alice@example.com")
+	if err := lt.Unsubscribe("team@example.org", "alice@example.com", "bad"); err != module.ErrInvalidToken {
+		t.Errorf("expected invalid token error, got %v", err)
+	}
+	if err := lt.Unsubscribe("team@example.org", "alice@example.com", token); err != nil {
+		t.Fatal(err)
+	}
+	members, _ := lt.Members("team@example.org")
+	if len(members) != 1 || members[0] != "bob@example.net" {
+		t.Errorf("wrong members after unsubscription: %v", members)
+	}
+}
+
+func bounceDSN(t *testing.T, member string, status smtp.EnhancedCode) (textproto.Header, []byte) {
+	t.Helper()
+	action := dsn.ActionFailed
+	if status[0] == 4 {
+		action = dsn.ActionDelayed
+	}
+	var body bytes.Buffer
+	hdr, err := dsn.GenerateDSN(false, dsn.Envelope{
+		MsgID: "<dsn@mx.example.net>",
+		To:    "team-bounces@example.org",
+	}, dsn.ReportingMTAInfo{
+		ReportingMTA: "mx.example.net",
+	}, []dsn.RecipientInfo{{
+		FinalRecipient: member,
+		Action:         action,
+		Status:         status,
+		DiagnosticCode: &smtp.SMTPError{Code: 550, EnhancedCode: status, Message: "No such user"},
+	}}, textproto.Header{}, &body)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return hdr, body.Bytes()
+}
+
+func bounceCount(t *testing.T, lt *Target, member string) string {
+	t.Helper()
+	val, _, err := lt.members.Lookup(context.Background(), memberKey("team@example.org", member))
+	if err != nil {
+		t.Fatal(err)
+	}
+	return val
+}
+
+func TestList_Bounces(t *testing.T) {
+	l := &List{Address: "team@example.org", Name: "Team", Posters: []string{PostersMembers}, VERP: true}
+	lt, _ := testTarget(t, l)
+	const bounceAddr = "team-bounces+bob=example.net@example.org"
+
+	// Forged bounce with a non-null sender.
+	hdr, body := bounceDSN(t, "bob@example.net", smtp.EnhancedCode{5, 1, 1})
+	if err := deliverBody(t, lt, "mallory@example.com", bounceAddr, hdr, body); err != nil {
+		t.Fatal(err)
+	}
+	// Not a DSN.
+	if err := deliver(t, lt, "", bounceAddr, textproto.Header{}); err != nil {
+		t.Fatal(err)
+	}
+	// Temporary failure.
+	hdr, body = bounceDSN(t, "bob@example.net", smtp.EnhancedCode{4, 2, 2})
+	if err := deliverBody(t, lt, "", bounceAddr, hdr, body); err != nil {
+		t.Fatal(err)
+	}
+	// Failure of another recipient sent to the member's bounces address.
+	hdr, body = bounceDSN(t, "mallory@example.com", smtp.EnhancedCode{5, 1, 1})
+	if err := deliverBody(t, lt, "", bounceAddr, hdr, body); err != nil {
+		t.Fatal(err)
+	}
+	if got := bounceCount(t, lt, "bob@example.net"); got != "0" {
+		t.Fatalf("bounce count changed by a message that is not a permanent failure DSN for the member: %s", got)
+	}
+
+	hdr, body = bounceDSN(t, "Bob@Example.net", smtp.EnhancedCode{5, 1, 1})
+	for i := 0; i < 2; i++ {
+		if err := deliverBody(t, lt, "", bounceAddr, hdr, body); err != nil {
+			t.Fatal(err)
+		}
+	}
+	members, _ := lt.Members("team@example.org")
+	if len(members) != 1 || members[0] != "alice@example.com" {
+		t.Errorf("bouncing member should be removed: %v", members)
+	}
+}
+
+func TestList_Unsubscribe(t *testing.T) {
+	l := &List{Address: "team@example.org", Name: "Team", Posters: []string{PostersMembers}}
+	lt, tgt := testTarget(t, l)
+
+	// Request with the sender that is not a member is ignored.
+	if err := deliver(t, lt, "mallory@example.com", "team-unsubscribe@example.org", textproto.Header{}); err != nil {
+		t.Fatal(err)
+	}
+	if len(tgt.Messages) != 0 {
+		t.Fatalf("confirmation sent to non-member: %v", tgt.Messages)
+	}
+
+	// The envelope sender can be forged so the member is only asked for
+	// confirmation.
+	if err := deliver(t, lt, "alice@example.com", "team-unsubscribe@example.org", textproto.Header{}); err != nil {
+		t.Fatal(err)
+	}
+	members, _ := lt.Members("team@example.org")
+	if len(members) != 2 {
+		t.Fatalf("member removed without confirmation: %v", members)
+	}
+	if len(tgt.Messages) != 1 {
+		t.Fatalf("expected 1 confirmation message, got %d", len(tgt.Messages))
+	}
+	msg := tgt.Messages[0]
+	if msg.MailFrom != "" || len(msg.RcptTo) != 1 || msg.RcptTo[0] != "alice@example.com" {
+		t.Errorf("wrong confirmation envelope: %q -> %v", msg.MailFrom, msg.RcptTo)
+	}
+	confirmAddr := l.UnsubscribeAddress(lt.confirmToken("team@example.org", "alice@example.com"))
+	if !strings.Contains(msg.Header.Get("From"), confirmAddr) {
+		t.Errorf("confirmation address is missing in From: %s", msg.Header.Get("From"))
+	}
+	if mbox, _, _ := strings.Cut(confirmAddr, "@"); len(mbox) > 64 {
+		t.Errorf("local part of the confirmation address is longer than 64 octets: %s", confirmAddr)
+	}
+
+	badAddr := l.UnsubscribeAddress(lt.unsubscribeToken("team@example.org", "alice@example.com"))
+	if err := deliver(t, lt, "mallory@example.com", badAddr, textproto.Header{}); smtpCode(err) != 550 {
+		t.Errorf("expected invalid token to be rejected, got %v", err)
+	}
+
+	if err := deliver(t, lt, "alice@example.com", confirmAddr, textproto.Header{}); err != nil {
+		t.Fatal(err)
+	}
+	members, _ = lt.Members("team@example.org")
+	if len(members) != 1 || members[0] != "bob@example.net" {
+		t.Errorf("member should be unsubscribed: %v", members)
+	}
+}
+
+func TestList_SecretFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "list.secret")
+	secret, err := loadOrGenerateSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	again, err := loadOrGenerateSecret(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(secret) != 32 || !bytes.Equal(secret, again) {
+		t.Fatal("secret is not persisted")
+	}
+}
@@ -0,0 +1,112 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package list
+
+import (
+	"context"
+	"mime"
+	"net/mail"
+	"net/url"
+	"runtime/trace"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// buildUnsubConfirmation builds the message asking the member to confirm the
+// unsubscription request.
+func (t *Target) buildUnsubConfirmation(l *List, member, msgID string) (textproto.Header, []byte) {
+	confirmAddr := l.UnsubscribeAddress(t.confirmToken(l.Address, member))
+
+	hdr := textproto.Header{}
+	hdr.Add("Date", time.Now().Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	hdr.Add("Message-Id", msgID)
+	hdr.Add("From", (&mail.Address{Name: l.Name, Address: confirmAddr}).String())
+	hdr.Add("To", "<"+member+">")
+	hdr.Add("Subject", mime.QEncoding.Encode("utf-8", "Confirm unsubscription from "+l.Name))
+	hdr.Add("Auto-Submitted", "auto-replied")
+	hdr.Add("X-Auto-Response-Suppress", "All")
+	hdr.Add("MIME-Version", "1.0")
+	hdr.Add("Content-Type", "text/plain; charset=utf-8")
+
+	var body strings.Builder
+	body.WriteString("We received a request to unsubscribe " + member + " from the\r\n")
+	body.WriteString(l.Name + " <" + l.Address + "> list.\r\n\r\n")
+	body.WriteString("To confirm, reply to this message or send any message to:\r\n\r\n")
+	body.WriteString("    " + confirmAddr + "\r\n\r\n")
+	if t.unsubURL != "" {
+		query := url.Values{}
+		query.Set("list", l.Address)
+		query.Set("addr", member)
+		query.Set("token", t.unsubscribeToken(l.Address, member))
+		body.WriteString("You can also open this link:\r\n\r\n")
+		body.WriteString("    " + t.unsubURL + "?" + query.Encode() + "\r\n\r\n")
+	}
+	body.WriteString("If you did not request this, ignore this message and you will stay\r\n")
+	body.WriteString("subscribed.\r\n")
+
+	return hdr, []byte(body.String())
+}
+
+// sendUnsubConfirmation submits the confirmation request to the configured
+// target. It is sent using the null reverse-path so it will never be counted
+// as a bounce or cause automatic replies.
+func (t *Target) sendUnsubConfirmation(ctx context.Context, l *List, member string) (err error) {
+	msgID, err := module.GenerateMsgID()
+	if err != nil {
+		return err
+	}
+	_, listDomain := l.local()
+	hdr, body := t.buildUnsubConfirmation(l, member, "<"+msgID+"@"+listDomain+">")
+
+	msgMeta := &module.MsgMetadata{
+		ID: msgID,
+		SMTPOpts: smtp.MailOptions{
+			UTF8: !address.IsASCII(member) || !address.IsASCII(l.Address),
+		},
+	}
+
+	ctx, task := trace.NewTask(ctx, "Unsubscription confirmation delivery")
+	defer task.End()
+
+	delivery, err := t.target.Start(ctx, msgMeta, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if err := delivery.Abort(ctx); err != nil {
+				t.log.Error("failed to abort confirmation delivery", err, "msg_id", msgID)
+			}
+		}
+	}()
+
+	if err = delivery.AddRcpt(ctx, member, smtp.RcptOptions{}); err != nil {
+		return err
+	}
+	if err = delivery.Body(ctx, hdr, buffer.MemoryBuffer{Slice: body}); err != nil {
+		return err
+	}
+	return delivery.Commit(ctx)
+}
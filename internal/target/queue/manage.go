@@ -0,0 +1,132 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package queue
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+)
+
+// ErrNoSuchMessage is returned by Remove if the message is not in the queue.
+var ErrNoSuchMessage = errors.New("queue: no such message")
+
+// MessageInfo is the summary of a queued message.
+type MessageInfo struct {
+	ID           string            `json:"id"`
+	From         string            `json:"from"`
+	To           []string          `json:"to"`
+	TriesCount   map[string]int    `json:"tries_count,omitempty"`
+	Errors       map[string]string `json:"errors,omitempty"`
+	FirstAttempt time.Time         `json:"first_attempt"`
+	LastAttempt  time.Time         `json:"last_attempt"`
+}
+
+// List returns the messages currently stored in the queue, oldest first.
+func (q *Queue) List() ([]MessageInfo, error) {
+	dirInfo, err := os.ReadDir(q.location)
+	if err != nil {
+		return nil, err
+	}
+
+	var res []MessageInfo
+	for _, entry := range dirInfo {
+		if entry.IsDir() || !strings.HasSuffix(entry.Name(), ".meta") {
+			continue
+		}
+		id := strings.TrimSuffix(entry.Name(), ".meta")
+
+		meta, err := q.readMessageMeta(id)
+		if err != nil {
+			// Removed concurrently or broken, readDiskQueue will
+			// report it.
+			continue
+		}
+		info := MessageInfo{
+			ID:           id,
+			From:         meta.From,
+			To:           meta.To,
+			TriesCount:   meta.TriesCount,
+			FirstAttempt: meta.FirstAttempt,
+			LastAttempt:  meta.LastAttempt,
+		}
+		if len(meta.RcptErrs) != 0 {
+			info.Errors = make(map[string]string, len(meta.RcptErrs))
+			for rcpt, err := range meta.RcptErrs {
+				info.Errors[rcpt] = err.Error()
+			}
+		}
+		res = append(res, info)
+	}
+
+	sort.Slice(res, func(i, j int) bool {
+		return res[i].FirstAttempt.Before(res[j].FirstAttempt)
+	})
+	return res, nil
+}
+
+// Remove deletes the message from the queue without generating a bounce.
+//
+// Delivery attempt that is already in progress is not interrupted.
+func (q *Queue) Remove(id string) error {
+	if id == "" || strings.ContainsAny(id, `/\`) || id == "." || id == ".." {
+		return fmt.Errorf("queue: invalid message ID: %q", id)
+	}
+	if _, err := os.Stat(filepath.Join(q.location, id+".meta")); err != nil {
+		if os.IsNotExist(err) {
+			return ErrNoSuchMessage
+		}
+		return err
+	}
+
+	q.removedLck.Lock()
+	q.removed[id] = struct{}{}
+	q.removedLck.Unlock()
+
+	q.removeFiles(id)
+	q.Log.Msg("message removed from queue", "msg_id", id)
+	return nil
+}
+
+// takeRemoved reports whether the message was removed using Remove. It
+// also deletes the files written by a delivery attempt that was in progress
+// during Remove.
+func (q *Queue) takeRemoved(id string) bool {
+	q.removedLck.Lock()
+	_, ok := q.removed[id]
+	delete(q.removed, id)
+	q.removedLck.Unlock()
+
+	if ok {
+		q.removeFiles(id)
+	}
+	return ok
+}
+
+func (q *Queue) removeFiles(id string) {
+	for _, ext := range []string{".header", ".body", ".meta"} {
+		if err := os.Remove(filepath.Join(q.location, id+ext)); err != nil && !os.IsNotExist(err) {
+			q.Log.Error("failed to remove file", err, "msg_id", id)
+		}
+	}
+}
@@ -82,9 +82,11 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 	"github.com/mail-chat-chain/mailchatd/internal/dsn"
 	"github.com/mail-chat-chain/mailchatd/internal/msgpipeline"
 	"github.com/mail-chat-chain/mailchatd/internal/target"
+	"go.opentelemetry.io/otel/attribute"
 )
 
 // partialError describes state of partially successful message delivery.
@@ -153,6 +155,11 @@ type Queue struct {
 	// Buffered channel used to restrict count of deliveries attempted
 	// in parallel.
 	deliverySemaphore chan struct{}
+
+	// IDs of messages removed using Remove that may still have a
+	// scheduled delivery.
+	removed    map[string]struct{}
+	removedLck sync.Mutex
 }
 
 type QueueMetadata struct {
@@ -248,6 +255,7 @@ func (q *Queue) Init(cfg *config.Map) error {
 
 func (q *Queue) start(maxParallelism int) error {
 	q.wheel = NewTimeWheel(q.dispatch)
+	q.removed = make(map[string]struct{})
 	q.deliverySemaphore = make(chan struct{}, maxParallelism)
 
 	if err := q.readDiskQueue(); err != nil {
@@ -306,6 +314,10 @@ func (q *Queue) dispatch(value TimeSlot) {
 		}()
 
 		q.Log.Debugln("delivery semaphore acquired for", slot.ID)
+		if q.takeRemoved(slot.ID) {
+			q.Log.Debugln("message was removed from the queue, skipping", slot.ID)
+			return
+		}
 		var (
 			meta *QueueMetadata
 			hdr  textproto.Header
@@ -469,7 +481,17 @@ func (q *Queue) deliver(meta *QueueMetadata, header textproto.Header, body buffe
 	msgMeta.ID = msgMeta.ID + "-" + strconv.FormatInt(time.Now().Unix(), 16)
 	dl.Debugf("using message ID = %s", msgMeta.ID)
 
-	msgCtx, msgTask := trace.NewTask(context.Background(), "Queue delivery")
+	// Continue the trace the message was accepted in.
+	ctx := tracing.WithParent(context.Background(), meta.MsgMeta.TraceParent)
+	ctx, span := tracing.Start(ctx, "queue.attempt",
+		attribute.String("msg_id", msgMeta.ID),
+		attribute.Int("rcpts", len(meta.To)))
+	defer func() {
+		span.SetAttributes(attribute.Int("failed_rcpts", len(perr.Errs)))
+		span.End()
+	}()
+
+	msgCtx, msgTask := trace.NewTask(ctx, "Queue delivery")
 	defer msgTask.End()
 
 	mailCtx, mailTask := trace.NewTask(msgCtx, "MAIL FROM")
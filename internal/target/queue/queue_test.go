@@ -823,6 +823,67 @@ func TestQueueDSN_RcptRewrite(t *testing.T) {
 	}
 }
 
+func TestQueueListRemove(t *testing.T) {
+	t.Parallel()
+
+	dt := unreliableTarget{
+		bodyFailures: []error{
+			exterrors.WithTemporary(errors.New("you shall not pass"), true),
+		},
+		aborted:   make(chan testutils.Msg, 10),
+		committed: make(chan testutils.Msg, 10),
+	}
+	q := newTestQueue(t, &dt)
+	q.initialRetryTime = time.Hour
+	defer cleanQueue(t, q)
+
+	testutils.DoTestDelivery(t, q, "tester@example.com", []string{"tester1@example.org"})
+	readMsgChanTimeout(t, dt.aborted, 5*time.Second)
+
+	// Wait for the attempt results to be saved.
+	var list []MessageInfo
+	for i := 0; i < 50; i++ {
+		var err error
+		list, err = q.List()
+		if err != nil {
+			t.Fatal(err)
+		}
+		if len(list) == 1 && list[0].TriesCount["tester1@example.org"] == 1 {
+			break
+		}
+		time.Sleep(100 * time.Millisecond)
+	}
+	if len(list) != 1 {
+		t.Fatalf("expected 1 message in queue, got %d", len(list))
+	}
+	if list[0].From != "tester@example.com" || len(list[0].To) != 1 || list[0].To[0] != "tester1@example.org" {
+		t.Fatalf("wrong message info: %+v", list[0])
+	}
+	if list[0].Errors["tester1@example.org"] == "" {
+		t.Errorf("delivery error is not reported: %+v", list[0])
+	}
+
+	if err := q.Remove("../foo"); err == nil {
+		t.Error("invalid ID accepted")
+	}
+	if err := q.Remove("nonexistent"); !errors.Is(err, ErrNoSuchMessage) {
+		t.Errorf("expected ErrNoSuchMessage, got %v", err)
+	}
+	if err := q.Remove(list[0].ID); err != nil {
+		t.Fatal(err)
+	}
+	checkQueueDir(t, q, []string{})
+
+	// Scheduled retry should be skipped.
+	q.dispatch(TimeSlot{Value: queueSlot{ID: list[0].ID}})
+	q.deliveryWg.Wait()
+	select {
+	case <-dt.committed:
+		t.Fatal("removed message was delivered")
+	default:
+	}
+}
+
 func init() {
 	dontRecover = true
 }
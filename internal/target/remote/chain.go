@@ -0,0 +1,255 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package remote
+
+import (
+	"context"
+	"crypto/tls"
+	"errors"
+	"io"
+	"net"
+	"runtime/debug"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	"github.com/mail-chat-chain/mailchatd/framework/dns"
+	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
+	"github.com/mail-chat-chain/mailchatd/framework/future"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/chainreg"
+	"github.com/mail-chat-chain/mailchatd/internal/target"
+)
+
+type (
+	// chainPolicy authenticates MX hosts and their TLS keys using the
+	// on-chain mail server registry (x/mail module).
+	//
+	// For domains with a verified registry entry, undeclared MX hosts are
+	// rejected and authenticated TLS is required: the server certificate has
+	// to match a declared TLS key or, if none is declared for the host,
+	// pass the PKIX verification.
+	//
+	// If override_mx is enabled, declared MX hosts also replace the DNS MX
+	// lookup. It is off by default since it lets the registry entry owner
+	// redirect mail without any change in DNS.
+	chainPolicy struct {
+		registry   chainreg.Registry
+		weight     int
+		overrideMX bool
+		log        log.Logger
+		instName   string
+	}
+	chainDelivery struct {
+		c         *chainPolicy
+		domainFut *future.Future
+		log       log.Logger
+	}
+)
+
+func NewChainPolicy(_, instName string, _, _ []string) (module.Module, error) {
+	return &chainPolicy{
+		instName: instName,
+		log:      log.Logger{Name: "mx_auth.chain", Debug: log.DefaultLogger.Debug},
+	}, nil
+}
+
+func (c *chainPolicy) Name() string {
+	return c.log.Name
+}
+
+func (c *chainPolicy) InstanceName() string {
+	return c.instName
+}
+
+func (c *chainPolicy) Weight() int {
+	return c.weight
+}
+
+func (c *chainPolicy) Init(cfg *config.Map) error {
+	var (
+		registryArgs []string
+		cacheTTL     time.Duration
+	)
+	cfg.Bool("debug", true, log.DefaultLogger.Debug, &c.log.Debug)
+	cfg.StringList("registry", false, false, []string{"grpc", "127.0.0.1:9090"}, &registryArgs)
+	cfg.Int("weight", false, false, 20, &c.weight)
+	cfg.Bool("override_mx", false, false, &c.overrideMX)
+	cfg.Duration("cache_ttl", false, false, 5*time.Minute, &cacheTTL)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	if c.weight < 0 || c.weight > 1000 {
+		return config.NodeErr(cfg.Block, "weight should be in range 0-1000")
+	}
+
+	registry, err := chainreg.New(registryArgs)
+	if err != nil {
+		return config.NodeErr(cfg.Block, "%v", err)
+	}
+	c.registry = registry
+	if cacheTTL > 0 {
+		c.registry = chainreg.NewCached(registry, cacheTTL)
+	}
+
+	return nil
+}
+
+func (c *chainPolicy) Start(msgMeta *module.MsgMetadata) module.DeliveryMXAuthPolicy {
+	return &chainDelivery{
+		c:   c,
+		log: target.DeliveryLogger(c.log, msgMeta),
+	}
+}
+
+func (c *chainPolicy) Close() error {
+	if closer, ok := c.registry.(io.Closer); ok {
+		return closer.Close()
+	}
+	return nil
+}
+
+func (c *chainDelivery) PrepareDomain(ctx context.Context, domain string) {
+	c.domainFut = future.New()
+	go func() {
+		defer func() {
+			if err := recover(); err != nil {
+				stack := debug.Stack()
+				log.Printf("panic during chain registry lookup: %v\n%s", err, stack)
+				c.domainFut.Set(nil, errors.New("chain registry lookup panicked"))
+			}
+		}()
+
+		c.domainFut.Set(c.c.registry.Domain(ctx, domain))
+	}()
+}
+
+// entry returns the registry entry of the domain or nil if the domain is not
+// registered or the registry is not reachable. In the latter case the policy
+// is not applied, just like MTA-STS policy is not applied if it cannot be
+// fetched.
+func (c *chainDelivery) entry(ctx context.Context) *chainreg.Domain {
+	if c.domainFut == nil {
+		return nil
+	}
+	dI, err := c.domainFut.GetContext(ctx)
+	if err != nil {
+		if !errors.Is(err, chainreg.ErrNotRegistered) {
+			c.log.Error("chain registry lookup failed", err)
+		}
+		return nil
+	}
+	return dI.(*chainreg.Domain)
+}
+
+func (c *chainDelivery) LookupMX(ctx context.Context, domain string) ([]*net.MX, bool, error) {
+	if !c.c.overrideMX {
+		return nil, false, nil
+	}
+	d := c.entry(ctx)
+	if d == nil || len(d.MXHosts) == 0 {
+		return nil, false, nil
+	}
+
+	records := make([]*net.MX, 0, len(d.MXHosts))
+	for _, mx := range d.MXHosts {
+		records = append(records, &net.MX{
+			Host: dns.FQDN(mx.Host),
+			Pref: uint16(mx.Preference), //nolint:gosec // validated on-chain to fit in uint16
+		})
+	}
+	c.log.DebugMsg("using MX hosts from chain registry", "domain", domain, "count", len(records))
+	return records, true, nil
+}
+
+func (c *chainDelivery) PrepareConn(ctx context.Context, mx string) {}
+
+func (c *chainDelivery) CheckMX(ctx context.Context, mxLevel module.MXLevel, domain, mx string, dnssec bool) (module.MXLevel, error) {
+	d := c.entry(ctx)
+	if d == nil || len(d.MXHosts) == 0 {
+		return module.MXNone, nil
+	}
+
+	if !d.HasMX(mx) {
+		return module.MXNone, &exterrors.SMTPError{
+			Code:         550,
+			EnhancedCode: exterrors.EnhancedCode{5, 7, 0},
+			Message:      "Failed to establish the MX record authenticity (chain registry)",
+			Misc: map[string]interface{}{
+				"mx": mx,
+			},
+		}
+	}
+	return module.MX_CHAIN, nil
+}
+
+func (c *chainDelivery) CheckConn(ctx context.Context, mxLevel module.MXLevel, tlsLevel module.TLSLevel, domain, mx string, tlsState tls.ConnectionState) (module.TLSLevel, error) {
+	d := c.entry(ctx)
+	if d == nil {
+		return module.TLSNone, nil
+	}
+
+	if !tlsState.HandshakeComplete {
+		return module.TLSNone, &exterrors.SMTPError{
+			Code:         451,
+			EnhancedCode: exterrors.EnhancedCode{4, 7, 1},
+			Message:      "TLS is required but unavailable or failed (chain registry)",
+		}
+	}
+
+	pins := d.TLSPins(mx)
+	if len(pins) == 0 {
+		if tlsState.VerifiedChains == nil {
+			return module.TLSNone, &exterrors.SMTPError{
+				Code:         451,
+				EnhancedCode: exterrors.EnhancedCode{4, 7, 1},
+				Message: "Recipient server TLS certificate is not trusted but " +
+					"authentication is required by chain registry",
+				Misc: map[string]interface{}{
+					"tls_level": tlsLevel,
+				},
+			}
+		}
+		return module.TLSNone, nil
+	}
+
+	if len(tlsState.PeerCertificates) == 0 || !chainreg.MatchCert(pins, tlsState.PeerCertificates[0]) {
+		return module.TLSNone, &exterrors.SMTPError{
+			Code:         451,
+			EnhancedCode: exterrors.EnhancedCode{4, 7, 1},
+			Message:      "Recipient server TLS key does not match chain registry",
+			Misc: map[string]interface{}{
+				"mx": mx,
+			},
+		}
+	}
+	return module.TLSAuthenticated, nil
+}
+
+func (c *chainDelivery) Reset(msgMeta *module.MsgMetadata) {
+	c.domainFut = nil
+	if msgMeta != nil {
+		c.log = target.DeliveryLogger(c.c.log, msgMeta)
+	}
+}
+
+func init() {
+	module.Register("mx_auth.chain", NewChainPolicy)
+}
@@ -0,0 +1,212 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package remote
+
+import (
+	"context"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/hex"
+	"errors"
+	"math/big"
+	"testing"
+	"time"
+
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/chainreg"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+type mockRegistry map[string]*chainreg.Domain
+
+func (r mockRegistry) Domain(_ context.Context, name string) (*chainreg.Domain, error) {
+	if name == "broken.invalid" {
+		return nil, errors.New("registry unavailable")
+	}
+	d, ok := r[name]
+	if !ok {
+		return nil, chainreg.ErrNotRegistered
+	}
+	return d, nil
+}
+
+func testChainCert(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	tmpl := &x509.Certificate{
+		SerialNumber: big.NewInt(1),
+		Subject:      pkix.Name{CommonName: "mx.example.invalid"},
+		NotBefore:    time.Now(),
+		NotAfter:     time.Now().Add(time.Hour),
+	}
+	der, err := x509.CreateCertificate(rand.Reader, tmpl, tmpl, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func testChainDelivery(t *testing.T, reg chainreg.Registry, domain string) *chainDelivery {
+	t.Helper()
+	p := &chainPolicy{
+		registry:   reg,
+		weight:     20,
+		overrideMX: true,
+		log:        testutils.Logger(t, "mx_auth.chain"),
+	}
+	d := p.Start(&module.MsgMetadata{ID: "test"}).(*chainDelivery)
+	d.PrepareDomain(context.Background(), domain)
+	return d
+}
+
+func TestChainPolicy(t *testing.T) {
+	cert := testChainCert(t)
+	spki := sha256.Sum256(cert.RawSubjectPublicKeyInfo)
+
+	reg := mockRegistry{
+		"example.invalid": {
+			Name: "example.invalid",
+			MXHosts: []chainreg.MXHost{
+				{Host: "mx2.example.invalid", Preference: 20},
+				{Host: "mx.example.invalid", Preference: 10},
+			},
+			TLSKeys: []chainreg.KeyFingerprint{
+				{Selector: "mx.example.invalid", Algorithm: chainreg.FingerprintSHA256, Fingerprint: hex.EncodeToString(spki[:])},
+			},
+		},
+	}
+	ctx := context.Background()
+
+	t.Run("override MX", func(t *testing.T) {
+		d := testChainDelivery(t, reg, "example.invalid")
+		records, ok, err := d.LookupMX(ctx, "example.invalid")
+		if err != nil || !ok {
+			t.Fatalf("LookupMX: %v %v", ok, err)
+		}
+		if len(records) != 2 || records[0].Host != "mx2.example.invalid." || records[1].Pref != 10 {
+			t.Fatalf("unexpected records: %+v %+v", records[0], records[1])
+		}
+
+		d.c.overrideMX = false
+		if _, ok, _ := d.LookupMX(ctx, "example.invalid"); ok {
+			t.Fatal("override used while disabled")
+		}
+	})
+
+	t.Run("declared MX", func(t *testing.T) {
+		d := testChainDelivery(t, reg, "example.invalid")
+		level, err := d.CheckMX(ctx, module.MXNone, "example.invalid", "mx.example.invalid.", false)
+		if err != nil || level != module.MX_CHAIN {
+			t.Fatalf("CheckMX: %v %v", level, err)
+		}
+		if _, err := d.CheckMX(ctx, module.MXNone, "example.invalid", "evil.example.invalid.", false); err == nil {
+			t.Fatal("undeclared MX accepted")
+		}
+	})
+
+	t.Run("pinned key", func(t *testing.T) {
+		d := testChainDelivery(t, reg, "example.invalid")
+		state := tls.ConnectionState{HandshakeComplete: true, PeerCertificates: []*x509.Certificate{cert}}
+		level, err := d.CheckConn(ctx, module.MX_CHAIN, module.TLSEncrypted, "example.invalid", "mx.example.invalid.", state)
+		if err != nil || level != module.TLSAuthenticated {
+			t.Fatalf("CheckConn: %v %v", level, err)
+		}
+
+		other := testChainCert(t)
+		state.PeerCertificates = []*x509.Certificate{other}
+		if _, err := d.CheckConn(ctx, module.MX_CHAIN, module.TLSEncrypted, "example.invalid", "mx.example.invalid.", state); err == nil {
+			t.Fatal("mismatched key accepted")
+		}
+		if _, err := d.CheckConn(ctx, module.MX_CHAIN, module.TLSNone, "example.invalid", "mx.example.invalid.", tls.ConnectionState{}); err == nil {
+			t.Fatal("plaintext connection accepted")
+		}
+	})
+
+	t.Run("no pin requires PKIX", func(t *testing.T) {
+		d := testChainDelivery(t, reg, "example.invalid")
+		state := tls.ConnectionState{HandshakeComplete: true, PeerCertificates: []*x509.Certificate{cert}}
+		if _, err := d.CheckConn(ctx, module.MX_CHAIN, module.TLSEncrypted, "example.invalid", "mx2.example.invalid.", state); err == nil {
+			t.Fatal("untrusted certificate accepted")
+		}
+		state.VerifiedChains = [][]*x509.Certificate{{cert}}
+		if _, err := d.CheckConn(ctx, module.MX_CHAIN, module.TLSAuthenticated, "example.invalid", "mx2.example.invalid.", state); err != nil {
+			t.Fatal(err)
+		}
+	})
+
+	for _, domain := range []string{"unregistered.invalid", "broken.invalid"} {
+		t.Run("not applied to "+domain, func(t *testing.T) {
+			d := testChainDelivery(t, reg, domain)
+			if _, ok, err := d.LookupMX(ctx, domain); ok || err != nil {
+				t.Fatalf("LookupMX: %v %v", ok, err)
+			}
+			if level, err := d.CheckMX(ctx, module.MXNone, domain, "mx."+domain, false); err != nil || level != module.MXNone {
+				t.Fatalf("CheckMX: %v %v", level, err)
+			}
+			if level, err := d.CheckConn(ctx, module.MXNone, module.TLSNone, domain, "mx."+domain, tls.ConnectionState{}); err != nil || level != module.TLSNone {
+				t.Fatalf("CheckConn: %v %v", level, err)
+			}
+		})
+	}
+}
+
+func TestPolicyGroup_Weight(t *testing.T) {
+	chain := &chainPolicy{weight: 5, log: log.Logger{Name: "mx_auth.chain"}}
+	l := []module.MXAuthPolicy{&mtastsPolicy{}, chain, &localPolicy{}}
+
+	sortPolicies(l)
+	if l[0] != module.MXAuthPolicy(chain) {
+		t.Fatalf("chain policy with lower weight is not applied first")
+	}
+	if _, ok := l[2].(*localPolicy); !ok {
+		t.Fatalf("local_policy is not applied last")
+	}
+
+	// Only the chain policy is moved, other policies keep the configured
+	// order even if their weights are not ordered.
+	sts, dnssec, local := &mtastsPolicy{}, &dnssecPolicy{}, &localPolicy{}
+	chain.weight = 20
+	l = []module.MXAuthPolicy{sts, dnssec, chain, local}
+	sortPolicies(l)
+	for i, p := range []module.MXAuthPolicy{sts, dnssec, chain, local} {
+		if l[i] != p {
+			t.Fatalf("wrong order at %d: %T", i, l[i])
+		}
+	}
+	chain.weight = 5
+	sortPolicies(l)
+	for i, p := range []module.MXAuthPolicy{chain, sts, dnssec, local} {
+		if l[i] != p {
+			t.Fatalf("wrong order at %d: %T", i, l[i])
+		}
+	}
+}
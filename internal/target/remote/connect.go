@@ -31,7 +31,10 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/dns"
 	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 	"github.com/mail-chat-chain/mailchatd/internal/smtpconn"
+	"go.opentelemetry.io/otel/attribute"
+	oteltrace "go.opentelemetry.io/otel/trace"
 )
 
 type mxConn struct {
@@ -83,6 +86,12 @@ func isVerifyError(err error) bool {
 // - tlsLevel    TLS security level that was estabilished.
 // - tlsErr      Error that prevented TLS from working if tlsLevel != TLSAuthenticated
 func (rd *remoteDelivery) connect(ctx context.Context, conn mxConn, host string, tlsCfg *tls.Config) (tlsLevel module.TLSLevel, tlsErr, err error) {
+	ctx, span := tracing.Start(ctx, "remote.connect", attribute.String("mx", host))
+	defer func() {
+		span.SetAttributes(attribute.String("tls_level", tlsLevel.String()))
+		tracing.End(span, err)
+	}()
+
 	tlsLevel = module.TLSAuthenticated
 	if rd.rt.tlsConfig != nil {
 		tlsCfg = rd.rt.tlsConfig.Clone()
@@ -105,6 +114,7 @@ retry:
 
 	starttlsOk, _ := conn.Client().Extension("STARTTLS")
 	if starttlsOk && tlsCfg != nil {
+		span.AddEvent("starttls")
 		if err := conn.Client().StartTLS(tlsCfg); err != nil {
 			// Here we just issue STARTTLS command. If it fails for some
 			// reason - this is either a connection problem or server actively
@@ -127,6 +137,7 @@ retry:
 			// *too* broken).
 			if isVerifyError(err) && tlsLevel == module.TLSAuthenticated {
 				rd.Log.Error("TLS verify error, trying without authentication", err, "remote_server", host, "domain", conn.domain)
+				span.AddEvent("tls.fallback_unauthenticated", oteltrace.WithAttributes(attribute.String("error", err.Error())))
 				tlsCfg.InsecureSkipVerify = true
 				tlsLevel = module.TLSEncrypted
 
@@ -138,6 +149,7 @@ retry:
 			}
 
 			rd.Log.Error("TLS error, trying plaintext", err, "remote_server", host, "domain", conn.domain)
+			span.AddEvent("tls.fallback_plaintext", oteltrace.WithAttributes(attribute.String("error", err.Error())))
 			tlsCfg = nil
 			tlsLevel = module.TLSNone
 			conn.DirectClose()
@@ -151,7 +163,12 @@ retry:
 	return tlsLevel, tlsErr, nil
 }
 
-func (rd *remoteDelivery) attemptMX(ctx context.Context, conn *mxConn, record *net.MX) error {
+func (rd *remoteDelivery) attemptMX(ctx context.Context, conn *mxConn, record *net.MX) (err error) {
+	ctx, span := tracing.Start(ctx, "remote.mx",
+		attribute.String("domain", conn.domain),
+		attribute.String("mx", record.Host))
+	defer func() { tracing.End(span, err) }()
+
 	mxLevel := module.MXNone
 
 	connCtx, cancel := context.WithCancel(ctx)
@@ -194,6 +211,9 @@ func (rd *remoteDelivery) attemptMX(ctx context.Context, conn *mxConn, record *n
 
 	conn.mxLevel = mxLevel
 	conn.tlsLevel = tlsLevel
+	span.SetAttributes(
+		attribute.String("mx_level", mxLevel.String()),
+		attribute.String("tls_level", tlsLevel.String()))
 
 	mxLevelCnt.WithLabelValues(rd.rt.Name(), mxLevel.String()).Inc()
 	tlsLevelCnt.WithLabelValues(rd.rt.Name(), tlsLevel.String()).Inc()
@@ -356,6 +376,23 @@ func (rd *remoteDelivery) newConn(ctx context.Context, domain string) (*mxConn,
 }
 
 func (rd *remoteDelivery) lookupMX(ctx context.Context, domain string) (dnssecOk bool, records []*net.MX, err error) {
+	for _, p := range rd.policies {
+		resolver, ok := p.(module.DeliveryMXResolver)
+		if !ok {
+			continue
+		}
+		records, ok, err := resolver.LookupMX(ctx, domain)
+		if err != nil {
+			return false, nil, err
+		}
+		if ok {
+			sort.Slice(records, func(i, j int) bool {
+				return records[i].Pref < records[j].Pref
+			})
+			return false, records, nil
+		}
+	}
+
 	if rd.rt.extResolver != nil {
 		dnssecOk, records, err = rd.rt.extResolver.AuthLookupMX(context.Background(), domain)
 	} else {
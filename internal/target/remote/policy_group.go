@@ -19,6 +19,8 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 package remote
 
 import (
+	"slices"
+
 	"github.com/mail-chat-chain/mailchatd/framework/config"
 	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
@@ -73,6 +75,7 @@ func (pg *PolicyGroup) Init(cfg *config.Map) error {
 		"sts_preload",
 		"dane",
 		"dnssec",
+		"chain",
 		// localPolicy should be the last one, since it considers levels defined by
 		// other policies.
 		"local_policy",
@@ -84,9 +87,32 @@ func (pg *PolicyGroup) Init(cfg *config.Map) error {
 		pg.L = append(pg.L, policy)
 	}
 
+	sortPolicies(pg.L)
+
 	return nil
 }
 
+// sortPolicies moves the chain policy before the first policy with a larger
+// Weight. Other policies keep the configured order.
+func sortPolicies(l []module.MXAuthPolicy) {
+	i := slices.IndexFunc(l, func(p module.MXAuthPolicy) bool {
+		_, ok := p.(*chainPolicy)
+		return ok
+	})
+	if i == -1 {
+		return
+	}
+	chain := l[i]
+	rest := slices.Delete(slices.Clone(l), i, i+1)
+	j := slices.IndexFunc(rest, func(p module.MXAuthPolicy) bool {
+		return p.Weight() > chain.Weight()
+	})
+	if j == -1 {
+		j = len(rest)
+	}
+	copy(l, slices.Insert(rest, j, chain))
+}
+
 func (PolicyGroup) Name() string {
 	return "mx_auth"
 }
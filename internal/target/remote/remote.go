@@ -45,9 +45,11 @@ import (
 	"github.com/mail-chat-chain/mailchatd/framework/exterrors"
 	"github.com/mail-chat-chain/mailchatd/framework/log"
 	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/framework/tracing"
 	"github.com/mail-chat-chain/mailchatd/internal/limits"
 	"github.com/mail-chat-chain/mailchatd/internal/smtpconn/pool"
 	"github.com/mail-chat-chain/mailchatd/internal/target"
+	"go.opentelemetry.io/otel/attribute"
 	"golang.org/x/net/idna"
 )
 
@@ -423,7 +425,11 @@ func (rd *remoteDelivery) BodyNonAtomic(ctx context.Context, c module.StatusColl
 			}
 			defer bodyR.Close()
 
-			err = conn.Data(ctx, header, bodyR)
+			dataCtx, span := tracing.Start(ctx, "remote.data",
+				attribute.String("domain", conn.domain),
+				attribute.Int("rcpts", len(conn.Rcpts())))
+			err = conn.Data(dataCtx, header, bodyR)
+			tracing.End(span, err)
 			for _, rcpt := range conn.Rcpts() {
 				c.SetStatus(rcpt, err)
 			}
@@ -566,7 +566,7 @@ func (c *localPolicy) Init(cfg *config.Map) error {
 	cfg.Enum("min_tls_level", false, false,
 		[]string{"none", "encrypted", "authenticated"}, "encrypted", &minTLSLevel)
 	cfg.Enum("min_mx_level", false, false,
-		[]string{"none", "mtasts", "dnssec"}, "none", &minMXLevel)
+		[]string{"none", "mtasts", "dnssec", "chain"}, "none", &minMXLevel)
 	if _, err := cfg.Process(); err != nil {
 		return err
 	}
@@ -587,6 +587,8 @@ func (c *localPolicy) Init(cfg *config.Map) error {
 		c.minMXLevel = module.MX_MTASTS
 	case "dnssec":
 		c.minMXLevel = module.MX_DNSSEC
+	case "chain":
+		c.minMXLevel = module.MX_CHAIN
 	}
 
 	return nil
@@ -0,0 +1,179 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package vacation
+
+import (
+	"bytes"
+	"context"
+	"mime"
+	"mime/quotedprintable"
+	"net/mail"
+	"runtime/trace"
+	"strings"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+)
+
+// suppressReason checks whether an automatic reply must not be sent for the
+// message per RFC 3834 and common practices. Non-empty string describing the
+// reason is returned in this case.
+func suppressReason(mailFrom string, hdr textproto.Header) string {
+	if mailFrom == "" {
+		return "null sender"
+	}
+
+	mbox, _, err := address.Split(mailFrom)
+	if err != nil {
+		return "malformed sender"
+	}
+	mbox = strings.ToLower(mbox)
+	switch {
+	case mbox == "mailer-daemon", mbox == "postmaster", mbox == "listserv", mbox == "majordomo":
+		return "system sender"
+	case strings.HasPrefix(mbox, "owner-"), strings.HasSuffix(mbox, "-request"),
+		strings.HasPrefix(mbox, "noreply"), strings.HasPrefix(mbox, "no-reply"):
+		return "system sender"
+	}
+
+	// RFC 3834, Section 2.
+	if autoSubmitted := strings.ToLower(strings.TrimSpace(hdr.Get("Auto-Submitted"))); autoSubmitted != "" && autoSubmitted != "no" {
+		return "auto-submitted message"
+	}
+
+	switch strings.ToLower(strings.TrimSpace(hdr.Get("Precedence"))) {
+	case "bulk", "list", "junk":
+		return "bulk message"
+	}
+
+	for _, field := range []string{"List-Id", "List-Unsubscribe", "List-Post", "List-Help"} {
+		if hdr.Has(field) {
+			return "mailing list message"
+		}
+	}
+
+	// Used by Microsoft Exchange to request suppression of auto-replies.
+	if suppress := strings.ToLower(hdr.Get("X-Auto-Response-Suppress")); strings.Contains(suppress, "oof") || strings.Contains(suppress, "all") {
+		return "suppression requested"
+	}
+
+	return ""
+}
+
+// addressedTo checks whether any of addrs is listed in To or Cc of the
+// message (RFC 3834, Section 2). Messages received as Bcc or through mailing
+// lists are not replied to.
+func addressedTo(hdr textproto.Header, addrs []string) bool {
+	for _, field := range []string{"To", "Cc"} {
+		for _, value := range hdr.Values(field) {
+			list, err := mail.ParseAddressList(value)
+			if err != nil {
+				continue
+			}
+			for _, listed := range list {
+				for _, addr := range addrs {
+					if address.Equal(addr, listed.Address) {
+						return true
+					}
+				}
+			}
+		}
+	}
+	return false
+}
+
+func (t *Target) buildReply(from, to, msgID string, settings Settings, origHdr textproto.Header) (textproto.Header, []byte) {
+	subject := settings.Subject
+	if subject == "" {
+		subject = "Auto: " + origHdr.Get("Subject")
+	}
+
+	hdr := textproto.Header{}
+	hdr.Add("Date", t.now().Format("Mon, 2 Jan 2006 15:04:05 -0700"))
+	hdr.Add("Message-Id", msgID)
+	hdr.Add("From", "<"+from+">")
+	hdr.Add("To", "<"+to+">")
+	hdr.Add("Subject", mime.QEncoding.Encode("utf-8", subject))
+	if origID := origHdr.Get("Message-Id"); origID != "" {
+		hdr.Add("In-Reply-To", origID)
+		refs := origHdr.Get("References")
+		if refs != "" {
+			refs += " "
+		}
+		hdr.Add("References", refs+origID)
+	}
+	hdr.Add("Auto-Submitted", "auto-replied")
+	hdr.Add("X-Auto-Response-Suppress", "All")
+	hdr.Add("MIME-Version", "1.0")
+	hdr.Add("Content-Type", "text/plain; charset=utf-8")
+	hdr.Add("Content-Transfer-Encoding", "quoted-printable")
+
+	var body bytes.Buffer
+	w := quotedprintable.NewWriter(&body)
+	_, _ = w.Write([]byte(strings.ReplaceAll(settings.Body, "\n", "\r\n")))
+	_ = w.Close()
+
+	return hdr, body.Bytes()
+}
+
+// sendReply generates the reply and submits it to the configured target.
+//
+// Per RFC 3834, Section 3.3, the reply is sent using the null reverse-path so
+// it will never cause bounces or further automatic replies.
+func (t *Target) sendReply(ctx context.Context, from, to string, settings Settings, origHdr textproto.Header) (err error) {
+	replyID, err := module.GenerateMsgID()
+	if err != nil {
+		return err
+	}
+
+	hdr, body := t.buildReply(from, to, "<"+replyID+"@"+t.autogenMsgDomain+">", settings, origHdr)
+
+	replyMeta := &module.MsgMetadata{
+		ID: replyID,
+		SMTPOpts: smtp.MailOptions{
+			UTF8: !address.IsASCII(from) || !address.IsASCII(to),
+		},
+	}
+
+	ctx, task := trace.NewTask(ctx, "Auto-reply delivery")
+	defer task.End()
+
+	delivery, err := t.target.Start(ctx, replyMeta, "")
+	if err != nil {
+		return err
+	}
+	defer func() {
+		if err != nil {
+			if err := delivery.Abort(ctx); err != nil {
+				t.log.Error("failed to abort auto-reply delivery", err, "reply_id", replyID)
+			}
+		}
+	}()
+
+	if err = delivery.AddRcpt(ctx, to, smtp.RcptOptions{}); err != nil {
+		return err
+	}
+	if err = delivery.Body(ctx, hdr, buffer.MemoryBuffer{Slice: body}); err != nil {
+		return err
+	}
+	return delivery.Commit(ctx)
+}
@@ -0,0 +1,348 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+// Package vacation implements the target.vacation module that sends
+// "out of office" automatic replies following the RFC 3834 recommendations.
+//
+// Interfaces implemented:
+// - module.DeliveryTarget
+package vacation
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/address"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/config"
+	modconfig "github.com/mail-chat-chain/mailchatd/framework/config/module"
+	"github.com/mail-chat-chain/mailchatd/framework/log"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/target"
+)
+
+const modName = "target.vacation"
+
+// Settings is the per-account auto-reply configuration stored in the
+// settings table as a JSON object.
+type Settings struct {
+	Enabled bool   `json:"enabled"`
+	Subject string `json:"subject,omitempty"`
+	Body    string `json:"body"`
+
+	// Start and End limit the period during which replies are sent. Zero
+	// values mean no limit.
+	Start time.Time `json:"start,omitempty"`
+	End   time.Time `json:"end,omitempty"`
+
+	// Aliases are other addresses of the account. Replies are sent only if
+	// the account address or one of the aliases is listed in To or Cc.
+	Aliases []string `json:"aliases,omitempty"`
+}
+
+// Active reports whether replies should be sent at the specified moment.
+func (s Settings) Active(now time.Time) bool {
+	if !s.Enabled {
+		return false
+	}
+	if !s.Start.IsZero() && now.Before(s.Start) {
+		return false
+	}
+	if !s.End.IsZero() && now.After(s.End) {
+		return false
+	}
+	return true
+}
+
+type Target struct {
+	instName string
+	log      log.Logger
+
+	hostname         string
+	autogenMsgDomain string
+	interval         time.Duration
+
+	settings module.MutableTable
+	replies  module.MutableTable
+	target   module.DeliveryTarget
+
+	now func() time.Time
+}
+
+func New(_, instName string, _, inlineArgs []string) (module.Module, error) {
+	if len(inlineArgs) != 0 {
+		return nil, fmt.Errorf("%s: inline arguments are not used", modName)
+	}
+	return &Target{
+		instName: instName,
+		log:      log.Logger{Name: modName},
+		now:      time.Now,
+	}, nil
+}
+
+func mutableTableDirective(m *config.Map, node config.Node) (interface{}, error) {
+	var tbl module.MutableTable
+	if err := modconfig.ModuleFromNode("table", node.Args, node, m.Globals, &tbl); err != nil {
+		return nil, err
+	}
+	return tbl, nil
+}
+
+func (t *Target) Init(cfg *config.Map) error {
+	cfg.Bool("debug", true, false, &t.log.Debug)
+	cfg.String("hostname", true, true, "", &t.hostname)
+	cfg.String("autogenerated_msg_domain", true, true, "", &t.autogenMsgDomain)
+	cfg.Duration("reply_interval", false, false, 7*24*time.Hour, &t.interval)
+	cfg.Custom("settings", false, true, nil, mutableTableDirective, &t.settings)
+	cfg.Custom("replies", false, true, nil, mutableTableDirective, &t.replies)
+	cfg.Custom("target", false, true, nil, modconfig.DeliveryDirective, &t.target)
+	if _, err := cfg.Process(); err != nil {
+		return err
+	}
+
+	if t.interval < 24*time.Hour {
+		t.log.Msg("reply_interval is less than one day, RFC 3834 recommends to not send replies more often", "interval", t.interval)
+	}
+
+	return nil
+}
+
+func (t *Target) Name() string {
+	return modName
+}
+
+func (t *Target) InstanceName() string {
+	return t.instName
+}
+
+func accountKey(addr string) (string, error) {
+	return address.ForLookup(addr)
+}
+
+// Settings returns the auto-reply configuration for the account.
+//
+// ok = false is returned if there is no configuration stored.
+func (t *Target) Settings(ctx context.Context, account string) (Settings, bool, error) {
+	key, err := accountKey(account)
+	if err != nil {
+		return Settings{}, false, err
+	}
+
+	val, ok, err := t.settings.Lookup(ctx, key)
+	if err != nil {
+		return Settings{}, false, fmt.Errorf("%s: settings lookup: %w", modName, err)
+	}
+	if !ok {
+		return Settings{}, false, nil
+	}
+
+	var s Settings
+	if err := json.Unmarshal([]byte(val), &s); err != nil {
+		return Settings{}, false, fmt.Errorf("%s: malformed settings for %s: %w", modName, key, err)
+	}
+	return s, true, nil
+}
+
+// SetSettings replaces the auto-reply configuration for the account.
+func (t *Target) SetSettings(account string, s Settings) error {
+	key, err := accountKey(account)
+	if err != nil {
+		return err
+	}
+	if s.Enabled && strings.TrimSpace(s.Body) == "" {
+		return errors.New("vacation: reply body is required")
+	}
+	if !s.Start.IsZero() && !s.End.IsZero() && s.End.Before(s.Start) {
+		return errors.New("vacation: end time is before start time")
+	}
+
+	blob, err := json.Marshal(s)
+	if err != nil {
+		return err
+	}
+	if err := t.settings.SetKey(key, string(blob)); err != nil {
+		return fmt.Errorf("%s: set settings for %s: %w", modName, key, err)
+	}
+	return nil
+}
+
+// RemoveSettings removes the auto-reply configuration and the reply history
+// for the account.
+func (t *Target) RemoveSettings(account string) error {
+	key, err := accountKey(account)
+	if err != nil {
+		return err
+	}
+	if err := t.settings.RemoveKey(key); err != nil {
+		return fmt.Errorf("%s: remove settings for %s: %w", modName, key, err)
+	}
+
+	keys, err := t.replies.Keys()
+	if err != nil {
+		return fmt.Errorf("%s: list replies: %w", modName, err)
+	}
+	for _, k := range keys {
+		if strings.HasPrefix(k, key+"|") {
+			if err := t.replies.RemoveKey(k); err != nil {
+				return fmt.Errorf("%s: remove reply history for %s: %w", modName, key, err)
+			}
+		}
+	}
+	return nil
+}
+
+// Accounts returns the list of accounts with auto-reply configuration.
+func (t *Target) Accounts() ([]string, error) {
+	return t.settings.Keys()
+}
+
+// shouldReply checks the per-sender reply interval.
+func (t *Target) shouldReply(ctx context.Context, account, sender string) (bool, error) {
+	val, ok, err := t.replies.Lookup(ctx, account+"|"+sender)
+	if err != nil {
+		return false, err
+	}
+	if ok {
+		lastReply, err := strconv.ParseInt(val, 10, 64)
+		if err == nil && t.now().Sub(time.Unix(lastReply, 0)) < t.interval {
+			return false, nil
+		}
+	}
+	return true, nil
+}
+
+// recordReply saves the reply time. It is called only after the reply is
+// committed so a failed delivery does not suppress the next attempt.
+func (t *Target) recordReply(account, sender string) error {
+	return t.replies.SetKey(account+"|"+sender, strconv.FormatInt(t.now().Unix(), 10))
+}
+
+type delivery struct {
+	t        *Target
+	mailFrom string
+	log      log.Logger
+	msgMeta  *module.MsgMetadata
+
+	rcpts  []string
+	header textproto.Header
+}
+
+func (t *Target) Start(ctx context.Context, msgMeta *module.MsgMetadata, mailFrom string) (module.Delivery, error) {
+	return &delivery{
+		t:        t,
+		mailFrom: mailFrom,
+		log:      target.DeliveryLogger(t.log, msgMeta),
+		msgMeta:  msgMeta,
+	}, nil
+}
+
+func (d *delivery) AddRcpt(ctx context.Context, rcptTo string, _ smtp.RcptOptions) error {
+	// Recipients without auto-reply configuration are silently ignored,
+	// target.vacation is used in addition to the actual mailbox storage.
+	for _, rcpt := range d.rcpts {
+		if rcpt == rcptTo {
+			return nil
+		}
+	}
+	d.rcpts = append(d.rcpts, rcptTo)
+	return nil
+}
+
+func (d *delivery) Body(ctx context.Context, header textproto.Header, body buffer.Buffer) error {
+	d.header = header.Copy()
+	return nil
+}
+
+func (d *delivery) Abort(ctx context.Context) error {
+	return nil
+}
+
+// Commit sends the replies. Failures are logged and never cause the message
+// delivery to fail.
+func (d *delivery) Commit(ctx context.Context) error {
+	if d.msgMeta.Quarantine {
+		d.log.DebugMsg("not replying to quarantined message")
+		return nil
+	}
+	if reason := suppressReason(d.mailFrom, d.header); reason != "" {
+		d.log.DebugMsg("auto-reply suppressed", "reason", reason)
+		return nil
+	}
+
+	sender, err := address.ForLookup(d.mailFrom)
+	if err != nil {
+		d.log.Error("malformed sender address", err)
+		return nil
+	}
+
+	for _, rcpt := range d.rcpts {
+		account, err := accountKey(rcpt)
+		if err != nil {
+			d.log.Error("malformed recipient address", err, "rcpt", rcpt)
+			continue
+		}
+		if address.Equal(account, sender) {
+			continue
+		}
+
+		settings, ok, err := d.t.Settings(ctx, account)
+		if err != nil {
+			d.log.Error("settings lookup failed", err, "rcpt", rcpt)
+			continue
+		}
+		if !ok || !settings.Active(d.t.now()) {
+			continue
+		}
+		if !addressedTo(d.header, append([]string{account}, settings.Aliases...)) {
+			d.log.DebugMsg("auto-reply suppressed", "reason", "recipient is not in To or Cc", "rcpt", rcpt)
+			continue
+		}
+
+		reply, err := d.t.shouldReply(ctx, account, sender)
+		if err != nil {
+			d.log.Error("reply history lookup failed", err, "rcpt", rcpt)
+			continue
+		}
+		if !reply {
+			d.log.DebugMsg("already replied to the sender recently", "rcpt", rcpt)
+			continue
+		}
+
+		if err := d.t.sendReply(ctx, rcpt, d.mailFrom, settings, d.header); err != nil {
+			d.log.Error("failed to send auto-reply", err, "rcpt", rcpt)
+			continue
+		}
+		d.log.Msg("auto-reply sent", "rcpt", rcpt)
+		if err := d.t.recordReply(account, sender); err != nil {
+			d.log.Error("failed to record the reply time", err, "rcpt", rcpt)
+		}
+	}
+
+	return nil
+}
+
+func init() {
+	module.Register(modName, New)
+}
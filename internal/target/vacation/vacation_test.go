@@ -0,0 +1,199 @@
+/*
+MailChat - Composable all-in-one email server.
+Copyright © 2019-2020 Max Mazurov <fox.cpp@disroot.org>, MailChat contributors
+
+This program is free software: you can redistribute it and/or modify
+it under the terms of the GNU General Public License as published by
+the Free Software Foundation, either version 3 of the License, or
+(at your option) any later version.
+
+This program is distributed in the hope that it will be useful,
+but WITHOUT ANY WARRANTY; without even the implied warranty of
+MERCHANTABILITY or FITNESS FOR A PARTICULAR PURPOSE.  See the
+GNU General Public License for more details.
+
+You should have received a copy of the GNU General Public License
+along with this program.  If not, see <https://www.gnu.org/licenses/>.
+*/
+
+package vacation
+
+import (
+	"context"
+	"errors"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/emersion/go-message/textproto"
+	"github.com/emersion/go-smtp"
+	"github.com/mail-chat-chain/mailchatd/framework/buffer"
+	"github.com/mail-chat-chain/mailchatd/framework/module"
+	"github.com/mail-chat-chain/mailchatd/internal/testutils"
+)
+
+func testTarget(t *testing.T) (*Target, *testutils.Target) {
+	tgt := &testutils.Target{}
+	now := time.Date(2026, 7, 1, 12, 0, 0, 0, time.UTC)
+	v := &Target{
+		log:              testutils.Logger(t, modName),
+		autogenMsgDomain: "example.org",
+		interval:         7 * 24 * time.Hour,
+		settings:         &testutils.MutableTable{},
+		replies:          &testutils.MutableTable{},
+		target:           tgt,
+		now:              func() time.Time { return now },
+	}
+	if err := v.SetSettings("away@example.org", Settings{
+		Enabled: true,
+		Subject: "Out of office",
+		Body:    "I am away.",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	return v, tgt
+}
+
+// addressed returns the header of a message sent to the account.
+func addressed(fields ...string) textproto.Header {
+	hdr := textproto.Header{}
+	hdr.Add("To", "Away <away@example.org>")
+	for i := 0; i < len(fields); i += 2 {
+		hdr.Add(fields[i], fields[i+1])
+	}
+	return hdr
+}
+
+func deliver(t *testing.T, v *Target, from string, hdr textproto.Header) {
+	t.Helper()
+	ctx := context.Background()
+	d, err := v.Start(ctx, &module.MsgMetadata{ID: "test"}, from)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := d.AddRcpt(ctx, "away@example.org", smtp.RcptOptions{}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Body(ctx, hdr, buffer.MemoryBuffer{Slice: []byte("hello\r\n")}); err != nil {
+		t.Fatal(err)
+	}
+	if err := d.Commit(ctx); err != nil {
+		t.Fatal(err)
+	}
+}
+
+func TestVacation_Reply(t *testing.T) {
+	v, tgt := testTarget(t)
+
+	hdr := addressed("Subject", "Hi", "Message-Id", "<orig@example.com>")
+
+	deliver(t, v, "sender@example.com", hdr)
+	// Second message within the interval should not generate a reply.
+	deliver(t, v, "sender@example.com", hdr)
+
+	if len(tgt.Messages) != 1 {
+		t.Fatalf("expected 1 reply, got %d", len(tgt.Messages))
+	}
+	msg := tgt.Messages[0]
+	if msg.MailFrom != "" {
+		t.Errorf("reply should use null sender, got %q", msg.MailFrom)
+	}
+	if len(msg.RcptTo) != 1 || msg.RcptTo[0] != "sender@example.com" {
+		t.Errorf("wrong reply recipients: %v", msg.RcptTo)
+	}
+	if msg.Header.Get("Auto-Submitted") != "auto-replied" {
+		t.Errorf("missing Auto-Submitted header")
+	}
+	if msg.Header.Get("In-Reply-To") != "<orig@example.com>" {
+		t.Errorf("wrong In-Reply-To: %q", msg.Header.Get("In-Reply-To"))
+	}
+	if !strings.Contains(string(msg.Body), "I am away.") {
+		t.Errorf("wrong body: %q", msg.Body)
+	}
+}
+
+func TestVacation_CommitFailed(t *testing.T) {
+	v, tgt := testTarget(t)
+	tgt.CommitErr = errors.New("remote server is down")
+
+	deliver(t, v, "sender@example.com", addressed())
+	if len(tgt.Messages) != 0 {
+		t.Fatal("unexpected reply")
+	}
+
+	// The failed reply is not recorded so the next message is replied to.
+	tgt.CommitErr = nil
+	deliver(t, v, "sender@example.com", addressed())
+	if len(tgt.Messages) != 1 {
+		t.Fatalf("expected 1 reply, got %d", len(tgt.Messages))
+	}
+}
+
+func TestVacation_Suppressed(t *testing.T) {
+	test := func(from string, fields ...string) {
+		t.Helper()
+		v, tgt := testTarget(t)
+		deliver(t, v, from, addressed(fields...))
+		if len(tgt.Messages) != 0 {
+			t.Errorf("unexpected reply for %s %v", from, fields)
+		}
+	}
+
+	test("")
+	test("MAILER-DAEMON@example.com")
+	test("list-request@example.com")
+	test("sender@example.com", "Auto-Submitted", "auto-generated")
+	test("sender@example.com", "Precedence", "bulk")
+	test("sender@example.com", "List-Id", "<list.example.com>")
+	test("away@example.org")
+}
+
+func TestVacation_Period(t *testing.T) {
+	v, tgt := testTarget(t)
+	if err := v.SetSettings("away@example.org", Settings{
+		Enabled: true,
+		Body:    "I am away.",
+		Start:   v.now().Add(24 * time.Hour),
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	deliver(t, v, "sender@example.com", addressed())
+	if len(tgt.Messages) != 0 {
+		t.Fatal("reply sent before the start time")
+	}
+}
+
+func TestVacation_Addressed(t *testing.T) {
+	test := func(replies int, hdr textproto.Header) {
+		t.Helper()
+		v, tgt := testTarget(t)
+		if err := v.SetSettings("away@example.org", Settings{
+			Enabled: true,
+			Body:    "I am away.",
+			Aliases: []string{"info@example.org"},
+		}); err != nil {
+			t.Fatal(err)
+		}
+		deliver(t, v, "sender@example.com", hdr)
+		if len(tgt.Messages) != replies {
+			t.Errorf("expected %d replies for %v, got %d", replies, hdr.Map(), len(tgt.Messages))
+		}
+	}
+
+	header := func(fields ...string) textproto.Header {
+		hdr := textproto.Header{}
+		for i := 0; i < len(fields); i += 2 {
+			hdr.Add(fields[i], fields[i+1])
+		}
+		return hdr
+	}
+
+	test(1, header("To", "other@example.com, AWAY@Example.org"))
+	test(1, header("To", "other@example.com", "Cc", "<away@example.org>"))
+	test(1, header("To", "Info <info@example.org>"))
+	test(0, header())
+	test(0, header("To", "undisclosed-recipients:;"))
+	test(0, header("To", "team@example.org"))
+	test(0, header("Bcc", "away@example.org"))
+}
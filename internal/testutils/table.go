@@ -18,7 +18,11 @@ along with this program.  If not, see <https://www.gnu.org/licenses/>.
 
 package testutils
 
-import "context"
+import (
+	"context"
+	"sort"
+	"sync"
+)
 
 type Table struct {
 	M   map[string]string
@@ -29,3 +33,44 @@ func (m Table) Lookup(_ context.Context, a string) (string, bool, error) {
 	b, ok := m.M[a]
 	return b, ok, m.Err
 }
+
+// MutableTable is an in-memory module.MutableTable implementation.
+type MutableTable struct {
+	lck sync.Mutex
+	M   map[string]string
+}
+
+func (m *MutableTable) Lookup(_ context.Context, a string) (string, bool, error) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	b, ok := m.M[a]
+	return b, ok, nil
+}
+
+func (m *MutableTable) Keys() ([]string, error) {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	keys := make([]string, 0, len(m.M))
+	for k := range m.M {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys, nil
+}
+
+func (m *MutableTable) RemoveKey(k string) error {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	delete(m.M, k)
+	return nil
+}
+
+func (m *MutableTable) SetKey(k, v string) error {
+	m.lck.Lock()
+	defer m.lck.Unlock()
+	if m.M == nil {
+		m.M = make(map[string]string)
+	}
+	m.M[k] = v
+	return nil
+}
@@ -0,0 +1,129 @@
+// SPDX-License-Identifier: LGPL-3.0-only
+pragma solidity >=0.8.17;
+
+/// @dev The IMail contract's address.
+address constant MAIL_PRECOMPILE_ADDRESS = 0x0000000000000000000000000000000000000809;
+
+/// @dev The IMail contract's instance.
+IMail constant MAIL_CONTRACT = IMail(MAIL_PRECOMPILE_ADDRESS);
+
+/// @dev Domain status values, see mailchat.mail.v1.DomainStatus.
+uint8 constant DOMAIN_STATUS_PENDING = 1;
+uint8 constant DOMAIN_STATUS_VERIFIED = 2;
+
+/// @dev Verification method values, see mailchat.mail.v1.VerificationMethod.
+uint8 constant VERIFICATION_METHOD_ATTESTATION = 1;
+uint8 constant VERIFICATION_METHOD_BOND = 2;
+
+/// @dev Coin is a Cosmos coin, identical to the Coin struct of the Cosmos EVM
+/// precompiles.
+struct Coin {
+    string denom;
+    uint256 amount;
+}
+
+/// @dev MXHost is a mail exchanger serving a domain.
+struct MXHost {
+    /// @dev Fully qualified host name of the mail server
+    string host;
+    /// @dev MX preference, lower values are tried first
+    uint32 preference;
+}
+
+/// @dev KeyFingerprint identifies a DKIM or TLS public key by its digest.
+struct KeyFingerprint {
+    /// @dev DKIM selector, or the host name for TLS keys
+    string selector;
+    /// @dev Digest algorithm, currently always "sha256"
+    string algorithm;
+    /// @dev Hex-encoded digest of the SubjectPublicKeyInfo
+    string fingerprint;
+}
+
+/// @dev DomainInfo is the on-chain registry entry of a mail domain.
+struct DomainInfo {
+    string name;
+    address owner;
+    uint8 status;
+    /// @dev Token to publish as "mailchat-verification=<challenge>" in the
+    /// _mailchat-challenge.<name> TXT record
+    string challenge;
+    int64 challengeExpiry;
+    uint8 verifiedBy;
+    int64 verifiedHeight;
+    Coin bond;
+    MXHost[] mxHosts;
+    KeyFingerprint[] dkimKeys;
+    KeyFingerprint[] tlsKeys;
+}
+
+/// @title Mail Server Registry Precompiled Contract
+/// @dev The interface through which solidity contracts interact with the
+/// x/mail module. The caller of every transaction is the domain owner, or the
+/// validator operator in case of attestDomain.
+/// @custom:address 0x0000000000000000000000000000000000000809
+interface IMail {
+    /// @dev Emitted when a domain is claimed and a new challenge is issued
+    event DomainRegistered(address indexed owner, string name, string challenge);
+
+    /// @dev Emitted when a validator attests the challenge of a domain
+    event DomainAttested(address indexed validator, string name, bool verified);
+
+    /// @dev Emitted when the owner bonds stake for a domain
+    event DomainBonded(address indexed owner, string name, uint256 amount);
+
+    /// @dev Emitted when the mail server records of a domain change
+    event MailServersUpdated(address indexed owner, string name);
+
+    /// @dev Emitted when a domain is removed from the registry
+    event DomainDeregistered(address indexed owner, string name);
+
+    /// @dev Claims a domain and issues a DNS TXT challenge.
+    /// @param name The domain name
+    /// @return challenge The token to publish in the TXT record
+    /// @return challengeExpiry The last block height the challenge can be proven at
+    function registerDomain(
+        string calldata name
+    ) external returns (string memory challenge, int64 challengeExpiry);
+
+    /// @dev Attests the DNS challenge as the operator of a bonded validator.
+    /// @param name The domain name
+    /// @param challenge The token found in the TXT record
+    /// @return verified Whether the domain collected enough attestations
+    function attestDomain(
+        string calldata name,
+        string calldata challenge
+    ) external returns (bool verified);
+
+    /// @dev Escrows at least min_bond of the bond denomination for a pending
+    /// domain. A bonded domain is verified by a single validator attestation.
+    /// @param name The domain name
+    /// @param amount The amount to escrow
+    function bondDomain(
+        string calldata name,
+        uint256 amount
+    ) external returns (bool success);
+
+    /// @dev Replaces the MX hosts and key fingerprints of a verified domain.
+    function setMailServers(
+        string calldata name,
+        MXHost[] calldata mxHosts,
+        KeyFingerprint[] calldata dkimKeys,
+        KeyFingerprint[] calldata tlsKeys
+    ) external returns (bool success);
+
+    /// @dev Removes a domain from the registry and refunds its bond.
+    function deregisterDomain(
+        string calldata name
+    ) external returns (bool success);
+
+    /// @dev Returns the registry entry of a domain.
+    function getDomain(
+        string calldata name
+    ) external view returns (DomainInfo memory domain);
+
+    /// @dev Returns whether the domain is registered and verified.
+    function isVerified(
+        string calldata name
+    ) external view returns (bool verified);
+}
@@ -0,0 +1,77 @@
+package mail
+
+import (
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/common"
+	ethtypes "github.com/ethereum/go-ethereum/core/types"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	cmn "github.com/cosmos/evm/precompiles/common"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+const (
+	// EventTypeDomainRegistered defines the event type for domain registrations
+	EventTypeDomainRegistered = "DomainRegistered"
+	// EventTypeDomainAttested defines the event type for validator attestations
+	EventTypeDomainAttested = "DomainAttested"
+	// EventTypeDomainBonded defines the event type for bond verifications
+	EventTypeDomainBonded = "DomainBonded"
+	// EventTypeMailServersUpdated defines the event type for mail server updates
+	EventTypeMailServersUpdated = "MailServersUpdated"
+	// EventTypeDomainDeregistered defines the event type for deregistrations
+	EventTypeDomainDeregistered = "DomainDeregistered"
+)
+
+// EmitDomainRegisteredEvent emits the DomainRegistered event
+func (p Precompile) EmitDomainRegisteredEvent(ctx sdk.Context, stateDB vm.StateDB, owner common.Address, name, challenge string) error {
+	return p.emit(ctx, stateDB, EventTypeDomainRegistered, owner, name, challenge)
+}
+
+// EmitDomainAttestedEvent emits the DomainAttested event
+func (p Precompile) EmitDomainAttestedEvent(ctx sdk.Context, stateDB vm.StateDB, validator common.Address, name string, verified bool) error {
+	return p.emit(ctx, stateDB, EventTypeDomainAttested, validator, name, verified)
+}
+
+// EmitDomainBondedEvent emits the DomainBonded event
+func (p Precompile) EmitDomainBondedEvent(ctx sdk.Context, stateDB vm.StateDB, owner common.Address, name string, amount *big.Int) error {
+	return p.emit(ctx, stateDB, EventTypeDomainBonded, owner, name, amount)
+}
+
+// EmitOwnerEvent emits one of the events that only carry the owner and the
+// domain name.
+func (p Precompile) EmitOwnerEvent(ctx sdk.Context, stateDB vm.StateDB, eventType string, owner common.Address, name string) error {
+	return p.emit(ctx, stateDB, eventType, owner, name)
+}
+
+// emit adds a log with the indexed address as the only topic besides the
+// event signature and the remaining values ABI-encoded as data.
+func (p Precompile) emit(ctx sdk.Context, stateDB vm.StateDB, eventType string, indexed common.Address, data ...interface{}) error {
+	event := p.Events[eventType]
+	topics := make([]common.Hash, 2)
+
+	// The first topic is always the signature of the event
+	topics[0] = event.ID
+
+	var err error
+	topics[1], err = cmn.MakeTopic(indexed)
+	if err != nil {
+		return err
+	}
+
+	packed, err := event.Inputs.NonIndexed().Pack(data...)
+	if err != nil {
+		return err
+	}
+
+	stateDB.AddLog(&ethtypes.Log{
+		Address:     p.Address(),
+		Topics:      topics,
+		Data:        packed,
+		BlockNumber: uint64(ctx.BlockHeight()), //nolint:gosec // G115
+	})
+
+	return nil
+}
@@ -0,0 +1,168 @@
+// Package mail implements the x/mail precompile, exposing the on-chain mail
+// server registry to Solidity contracts.
+package mail
+
+import (
+	"embed"
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+	"github.com/ethereum/go-ethereum/core/tracing"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	cmn "github.com/cosmos/evm/precompiles/common"
+
+	"cosmossdk.io/log"
+	storetypes "cosmossdk.io/store/types"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	mailkeeper "github.com/mail-chat-chain/mailchatd/x/mail/keeper"
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+// PrecompileAddress is the address of the mail registry precompile.
+const PrecompileAddress = "0x0000000000000000000000000000000000000809"
+
+var _ vm.PrecompiledContract = &Precompile{}
+
+// Embed abi json file to the executable binary. Needed when importing as dependency.
+//
+//go:embed abi.json
+var f embed.FS
+
+// Precompile defines the precompiled contract for the mail server registry.
+type Precompile struct {
+	cmn.Precompile
+	mailKeeper mailkeeper.Keeper
+	msgServer  mailtypes.MsgServer
+	query      mailtypes.QueryServer
+}
+
+// LoadABI loads the mail ABI from the embedded abi.json file.
+func LoadABI() (abi.ABI, error) {
+	return cmn.LoadABI(f, "abi.json")
+}
+
+// NewPrecompile creates a new mail Precompile instance as a
+// PrecompiledContract interface.
+func NewPrecompile(mailKeeper mailkeeper.Keeper) (*Precompile, error) {
+	abi, err := LoadABI()
+	if err != nil {
+		return nil, err
+	}
+
+	p := &Precompile{
+		Precompile: cmn.Precompile{
+			ABI:                  abi,
+			KvGasConfig:          storetypes.KVGasConfig(),
+			TransientKVGasConfig: storetypes.TransientGasConfig(),
+		},
+		mailKeeper: mailKeeper,
+		msgServer:  mailkeeper.NewMsgServerImpl(mailKeeper),
+		query:      mailkeeper.NewQueryServerImpl(mailKeeper),
+	}
+
+	p.SetAddress(common.HexToAddress(PrecompileAddress))
+
+	return p, nil
+}
+
+// RequiredGas calculates the precompiled contract's base gas rate.
+func (p Precompile) RequiredGas(input []byte) uint64 {
+	// NOTE: This check avoid panicking when trying to decode the method ID
+	if len(input) < 4 {
+		return 0
+	}
+
+	method, err := p.MethodById(input[:4])
+	if err != nil {
+		// This should never happen since this method is going to fail during Run
+		return 0
+	}
+
+	return p.Precompile.RequiredGas(input, p.IsTransaction(method))
+}
+
+// Run executes the precompiled contract mail methods defined in the ABI.
+func (p Precompile) Run(evm *vm.EVM, contract *vm.Contract, readOnly bool) (bz []byte, err error) {
+	bz, err = p.run(evm, contract, readOnly)
+	if err != nil {
+		return cmn.ReturnRevertError(evm, err)
+	}
+
+	return bz, nil
+}
+
+func (p Precompile) run(evm *vm.EVM, contract *vm.Contract, readOnly bool) (bz []byte, err error) {
+	ctx, stateDB, method, initialGas, args, err := p.RunSetup(evm, contract, readOnly, p.IsTransaction)
+	if err != nil {
+		return nil, err
+	}
+
+	// Start the balance change handler before executing the precompile,
+	// bondDomain and deregisterDomain move funds.
+	p.GetBalanceHandler().BeforeBalanceChange(ctx)
+
+	// This handles any out of gas errors that may occur during the execution of a precompile tx or query.
+	// It avoids panics and returns the out of gas error so the EVM can continue gracefully.
+	defer cmn.HandleGasError(ctx, contract, initialGas, &err)()
+
+	switch method.Name {
+	// mail transactions
+	case RegisterDomainMethod:
+		bz, err = p.RegisterDomain(ctx, method, stateDB, contract, args)
+	case AttestDomainMethod:
+		bz, err = p.AttestDomain(ctx, method, stateDB, contract, args)
+	case BondDomainMethod:
+		bz, err = p.BondDomain(ctx, method, stateDB, contract, args)
+	case SetMailServersMethod:
+		bz, err = p.SetMailServers(ctx, method, stateDB, contract, args)
+	case DeregisterDomainMethod:
+		bz, err = p.DeregisterDomain(ctx, method, stateDB, contract, args)
+	// mail queries
+	case GetDomainMethod:
+		bz, err = p.GetDomain(ctx, method, contract, args)
+	case IsVerifiedMethod:
+		bz, err = p.IsVerified(ctx, method, contract, args)
+	default:
+		return nil, fmt.Errorf(cmn.ErrUnknownMethod, method.Name)
+	}
+
+	if err != nil {
+		return nil, err
+	}
+
+	cost := ctx.GasMeter().GasConsumed() - initialGas
+
+	if !contract.UseGas(cost, nil, tracing.GasChangeCallPrecompiledContract) {
+		return nil, vm.ErrOutOfGas
+	}
+
+	// Process the native balance changes after the method execution.
+	if err := p.GetBalanceHandler().AfterBalanceChange(ctx, stateDB); err != nil {
+		return nil, err
+	}
+
+	return bz, nil
+}
+
+// IsTransaction checks if the given method name corresponds to a transaction or query.
+func (Precompile) IsTransaction(method *abi.Method) bool {
+	switch method.Name {
+	case RegisterDomainMethod,
+		AttestDomainMethod,
+		BondDomainMethod,
+		SetMailServersMethod,
+		DeregisterDomainMethod:
+		return true
+	default:
+		return false
+	}
+}
+
+// Logger returns a precompile-specific logger.
+func (p Precompile) Logger(ctx sdk.Context) log.Logger {
+	return ctx.Logger().With("evm extension", "mail")
+}
@@ -0,0 +1,72 @@
+package mail
+
+import (
+	"fmt"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	cmn "github.com/cosmos/evm/precompiles/common"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+const (
+	// GetDomainMethod defines the ABI method name for the Domain query.
+	GetDomainMethod = "getDomain"
+	// IsVerifiedMethod defines the ABI method name for checking whether a
+	// domain is verified.
+	IsVerifiedMethod = "isVerified"
+)
+
+// GetDomain returns the registry entry of a domain.
+func (p Precompile) GetDomain(
+	ctx sdk.Context,
+	method *abi.Method,
+	_ *vm.Contract,
+	args []interface{},
+) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf(cmn.ErrInvalidNumberOfArgs, 1, len(args))
+	}
+	name, err := parseName(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := p.query.Domain(ctx, &mailtypes.QueryDomainRequest{Name: name})
+	if err != nil {
+		return nil, err
+	}
+
+	info, err := NewDomainInfo(res.Domain)
+	if err != nil {
+		return nil, err
+	}
+	return method.Outputs.Pack(info)
+}
+
+// IsVerified returns whether a domain is registered and verified. Unknown
+// domains are reported as not verified instead of reverting.
+func (p Precompile) IsVerified(
+	ctx sdk.Context,
+	method *abi.Method,
+	_ *vm.Contract,
+	args []interface{},
+) ([]byte, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf(cmn.ErrInvalidNumberOfArgs, 1, len(args))
+	}
+	name, err := parseName(args, 0)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, err := p.mailKeeper.IsVerified(ctx, name)
+	if err != nil {
+		return nil, err
+	}
+	return method.Outputs.Pack(verified)
+}
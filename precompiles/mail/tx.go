@@ -0,0 +1,150 @@
+package mail
+
+import (
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/core/vm"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+const (
+	// RegisterDomainMethod defines the ABI method name for MsgRegisterDomain.
+	RegisterDomainMethod = "registerDomain"
+	// AttestDomainMethod defines the ABI method name for MsgAttestDomain.
+	AttestDomainMethod = "attestDomain"
+	// BondDomainMethod defines the ABI method name for MsgBondDomain.
+	BondDomainMethod = "bondDomain"
+	// SetMailServersMethod defines the ABI method name for MsgSetMailServers.
+	SetMailServersMethod = "setMailServers"
+	// DeregisterDomainMethod defines the ABI method name for MsgDeregisterDomain.
+	DeregisterDomainMethod = "deregisterDomain"
+)
+
+// RegisterDomain claims a domain for msg.sender and returns the DNS challenge.
+func (p Precompile) RegisterDomain(
+	ctx sdk.Context,
+	method *abi.Method,
+	stateDB vm.StateDB,
+	contract *vm.Contract,
+	args []interface{},
+) ([]byte, error) {
+	msg, err := NewMsgRegisterDomain(args, contract.Caller())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := p.msgServer.RegisterDomain(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.EmitDomainRegisteredEvent(ctx, stateDB, contract.Caller(), msg.Name, res.Challenge); err != nil {
+		return nil, err
+	}
+
+	return method.Outputs.Pack(res.Challenge, res.ChallengeExpiry)
+}
+
+// AttestDomain attests the DNS challenge of a domain on behalf of the
+// validator operated by msg.sender.
+func (p Precompile) AttestDomain(
+	ctx sdk.Context,
+	method *abi.Method,
+	stateDB vm.StateDB,
+	contract *vm.Contract,
+	args []interface{},
+) ([]byte, error) {
+	msg, err := NewMsgAttestDomain(args, contract.Caller())
+	if err != nil {
+		return nil, err
+	}
+
+	res, err := p.msgServer.AttestDomain(ctx, msg)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := p.EmitDomainAttestedEvent(ctx, stateDB, contract.Caller(), msg.Name, res.Verified); err != nil {
+		return nil, err
+	}
+
+	return method.Outputs.Pack(res.Verified)
+}
+
+// BondDomain escrows stake for a pending domain of msg.sender.
+func (p Precompile) BondDomain(
+	ctx sdk.Context,
+	method *abi.Method,
+	stateDB vm.StateDB,
+	contract *vm.Contract,
+	args []interface{},
+) ([]byte, error) {
+	params, err := p.mailKeeper.GetParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	msg, err := NewMsgBondDomain(args, contract.Caller(), params.MinBond.Denom)
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.msgServer.BondDomain(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	if err := p.EmitDomainBondedEvent(ctx, stateDB, contract.Caller(), msg.Name, msg.Amount.Amount.BigInt()); err != nil {
+		return nil, err
+	}
+
+	return method.Outputs.Pack(true)
+}
+
+// SetMailServers replaces the mail server records of a domain owned by
+// msg.sender.
+func (p Precompile) SetMailServers(
+	ctx sdk.Context,
+	method *abi.Method,
+	stateDB vm.StateDB,
+	contract *vm.Contract,
+	args []interface{},
+) ([]byte, error) {
+	msg, err := NewMsgSetMailServers(method, args, contract.Caller())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.msgServer.SetMailServers(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	if err := p.EmitOwnerEvent(ctx, stateDB, EventTypeMailServersUpdated, contract.Caller(), msg.Name); err != nil {
+		return nil, err
+	}
+
+	return method.Outputs.Pack(true)
+}
+
+// DeregisterDomain removes a domain owned by msg.sender and refunds its bond.
+func (p Precompile) DeregisterDomain(
+	ctx sdk.Context,
+	method *abi.Method,
+	stateDB vm.StateDB,
+	contract *vm.Contract,
+	args []interface{},
+) ([]byte, error) {
+	msg, err := NewMsgDeregisterDomain(args, contract.Caller())
+	if err != nil {
+		return nil, err
+	}
+
+	if _, err := p.msgServer.DeregisterDomain(ctx, msg); err != nil {
+		return nil, err
+	}
+
+	if err := p.EmitOwnerEvent(ctx, stateDB, EventTypeDomainDeregistered, contract.Caller(), msg.Name); err != nil {
+		return nil, err
+	}
+
+	return method.Outputs.Pack(true)
+}
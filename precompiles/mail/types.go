@@ -0,0 +1,210 @@
+package mail
+
+import (
+	"fmt"
+	"math/big"
+
+	"github.com/ethereum/go-ethereum/accounts/abi"
+	"github.com/ethereum/go-ethereum/common"
+
+	cmn "github.com/cosmos/evm/precompiles/common"
+
+	sdkmath "cosmossdk.io/math"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+// MXHost is the ABI representation of mailtypes.MXHost.
+type MXHost struct {
+	Host       string `abi:"host"`
+	Preference uint32 `abi:"preference"`
+}
+
+// KeyFingerprint is the ABI representation of mailtypes.KeyFingerprint.
+type KeyFingerprint struct {
+	Selector    string `abi:"selector"`
+	Algorithm   string `abi:"algorithm"`
+	Fingerprint string `abi:"fingerprint"`
+}
+
+// DomainInfo is the ABI representation of mailtypes.Domain.
+type DomainInfo struct {
+	Name            string           `abi:"name"`
+	Owner           common.Address   `abi:"owner"`
+	Status          uint8            `abi:"status"`
+	Challenge       string           `abi:"challenge"`
+	ChallengeExpiry int64            `abi:"challengeExpiry"`
+	VerifiedBy      uint8            `abi:"verifiedBy"`
+	VerifiedHeight  int64            `abi:"verifiedHeight"`
+	Bond            cmn.Coin         `abi:"bond"`
+	MxHosts         []MXHost         `abi:"mxHosts"`
+	DkimKeys        []KeyFingerprint `abi:"dkimKeys"`
+	TlsKeys         []KeyFingerprint `abi:"tlsKeys"`
+}
+
+// SetMailServersInput is the input of the setMailServers method.
+type SetMailServersInput struct {
+	Name     string           `abi:"name"`
+	MxHosts  []MXHost         `abi:"mxHosts"`
+	DkimKeys []KeyFingerprint `abi:"dkimKeys"`
+	TlsKeys  []KeyFingerprint `abi:"tlsKeys"`
+}
+
+func accAddress(addr common.Address) string {
+	return sdk.AccAddress(addr.Bytes()).String()
+}
+
+func parseName(args []interface{}, i int) (string, error) {
+	name, ok := args[i].(string)
+	if !ok {
+		return "", fmt.Errorf(cmn.ErrInvalidType, "name", "", args[i])
+	}
+	return mailtypes.NormalizeDomain(name), nil
+}
+
+// NewMsgRegisterDomain creates a new MsgRegisterDomain instance.
+func NewMsgRegisterDomain(args []interface{}, owner common.Address) (*mailtypes.MsgRegisterDomain, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf(cmn.ErrInvalidNumberOfArgs, 1, len(args))
+	}
+	name, err := parseName(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	msg := &mailtypes.MsgRegisterDomain{Owner: accAddress(owner), Name: name}
+	return msg, msg.ValidateBasic()
+}
+
+// NewMsgAttestDomain creates a new MsgAttestDomain instance.
+func NewMsgAttestDomain(args []interface{}, attestor common.Address) (*mailtypes.MsgAttestDomain, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf(cmn.ErrInvalidNumberOfArgs, 2, len(args))
+	}
+	name, err := parseName(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	challenge, ok := args[1].(string)
+	if !ok {
+		return nil, fmt.Errorf(cmn.ErrInvalidType, "challenge", "", args[1])
+	}
+	msg := &mailtypes.MsgAttestDomain{Attestor: accAddress(attestor), Name: name, Challenge: challenge}
+	return msg, msg.ValidateBasic()
+}
+
+// NewMsgBondDomain creates a new MsgBondDomain instance. The amount is taken
+// in the denomination of the min_bond parameter.
+func NewMsgBondDomain(args []interface{}, owner common.Address, denom string) (*mailtypes.MsgBondDomain, error) {
+	if len(args) != 2 {
+		return nil, fmt.Errorf(cmn.ErrInvalidNumberOfArgs, 2, len(args))
+	}
+	name, err := parseName(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	amount, ok := args[1].(*big.Int)
+	if !ok || amount == nil {
+		return nil, fmt.Errorf(cmn.ErrInvalidAmount, args[1])
+	}
+	msg := &mailtypes.MsgBondDomain{
+		Owner:  accAddress(owner),
+		Name:   name,
+		Amount: sdk.NewCoin(denom, sdkmath.NewIntFromBigInt(amount)),
+	}
+	return msg, msg.ValidateBasic()
+}
+
+// NewMsgSetMailServers creates a new MsgSetMailServers instance.
+func NewMsgSetMailServers(method *abi.Method, args []interface{}, owner common.Address) (*mailtypes.MsgSetMailServers, error) {
+	if len(args) != 4 {
+		return nil, fmt.Errorf(cmn.ErrInvalidNumberOfArgs, 4, len(args))
+	}
+
+	var input SetMailServersInput
+	if err := method.Inputs.Copy(&input, args); err != nil {
+		return nil, fmt.Errorf("error while unpacking args to SetMailServersInput: %s", err)
+	}
+
+	msg := &mailtypes.MsgSetMailServers{
+		Owner:    accAddress(owner),
+		Name:     mailtypes.NormalizeDomain(input.Name),
+		MxHosts:  make([]mailtypes.MXHost, 0, len(input.MxHosts)),
+		DkimKeys: toKeyFingerprints(input.DkimKeys),
+		TlsKeys:  toKeyFingerprints(input.TlsKeys),
+	}
+	for _, mx := range input.MxHosts {
+		msg.MxHosts = append(msg.MxHosts, mailtypes.MXHost{
+			Host:       mailtypes.NormalizeDomain(mx.Host),
+			Preference: mx.Preference,
+		})
+	}
+	return msg, msg.ValidateBasic()
+}
+
+func toKeyFingerprints(keys []KeyFingerprint) []mailtypes.KeyFingerprint {
+	out := make([]mailtypes.KeyFingerprint, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, mailtypes.KeyFingerprint{
+			Selector:    k.Selector,
+			Algorithm:   k.Algorithm,
+			Fingerprint: mailtypes.NormalizeFingerprint(k.Fingerprint),
+		})
+	}
+	return out
+}
+
+// NewMsgDeregisterDomain creates a new MsgDeregisterDomain instance.
+func NewMsgDeregisterDomain(args []interface{}, owner common.Address) (*mailtypes.MsgDeregisterDomain, error) {
+	if len(args) != 1 {
+		return nil, fmt.Errorf(cmn.ErrInvalidNumberOfArgs, 1, len(args))
+	}
+	name, err := parseName(args, 0)
+	if err != nil {
+		return nil, err
+	}
+	msg := &mailtypes.MsgDeregisterDomain{Owner: accAddress(owner), Name: name}
+	return msg, msg.ValidateBasic()
+}
+
+// NewDomainInfo converts a registry entry to its ABI representation.
+func NewDomainInfo(d mailtypes.Domain) (DomainInfo, error) {
+	owner, err := sdk.AccAddressFromBech32(d.Owner)
+	if err != nil {
+		return DomainInfo{}, fmt.Errorf("invalid owner of %s: %w", d.Name, err)
+	}
+
+	info := DomainInfo{
+		Name:            d.Name,
+		Owner:           common.BytesToAddress(owner),
+		Status:          uint8(d.Status), //nolint:gosec // G115 enum values are small
+		Challenge:       d.Challenge,
+		ChallengeExpiry: d.ChallengeExpiry,
+		VerifiedBy:      uint8(d.VerifiedBy), //nolint:gosec // G115 enum values are small
+		VerifiedHeight:  d.VerifiedHeight,
+		Bond:            cmn.Coin{Denom: d.Bond.Denom, Amount: big.NewInt(0)},
+		MxHosts:         make([]MXHost, 0, len(d.MxHosts)),
+		DkimKeys:        fromKeyFingerprints(d.DkimKeys),
+		TlsKeys:         fromKeyFingerprints(d.TlsKeys),
+	}
+	if !d.Bond.Amount.IsNil() {
+		info.Bond.Amount = d.Bond.Amount.BigInt()
+	}
+	for _, mx := range d.MxHosts {
+		info.MxHosts = append(info.MxHosts, MXHost{Host: mx.Host, Preference: mx.Preference})
+	}
+	return info, nil
+}
+
+func fromKeyFingerprints(keys []mailtypes.KeyFingerprint) []KeyFingerprint {
+	out := make([]KeyFingerprint, 0, len(keys))
+	for _, k := range keys {
+		out = append(out, KeyFingerprint{
+			Selector:    k.Selector,
+			Algorithm:   k.Algorithm,
+			Fingerprint: k.Fingerprint,
+		})
+	}
+	return out
+}
@@ -0,0 +1,269 @@
+package integration
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/require"
+
+	abci "github.com/cometbft/cometbft/abci/types"
+	cmtproto "github.com/cometbft/cometbft/proto/tendermint/types"
+
+	dbm "github.com/cosmos/cosmos-db"
+	"github.com/cosmos/evm"
+	testconfig "github.com/cosmos/evm/testutil/config"
+	"github.com/cosmos/evm/testutil/integration/evm/network"
+	evmtypes "github.com/cosmos/evm/x/vm/types"
+	"github.com/ethereum/go-ethereum/common"
+	app "github.com/mail-chat-chain/mailchatd/app"
+	mailkeeper "github.com/mail-chat-chain/mailchatd/x/mail/keeper"
+	mailtypes "github.com/mail-chat-chain/mailchatd/x/mail/types"
+
+	"cosmossdk.io/log"
+	storetypes "cosmossdk.io/store/types"
+	upgradetypes "cosmossdk.io/x/upgrade/types"
+
+	"github.com/cosmos/cosmos-sdk/baseapp"
+	simutils "github.com/cosmos/cosmos-sdk/testutil/sims"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+const testUpgradeName = "test-upgrade"
+
+// upgradeChain runs the chain with the binary that does not know about the
+// upgrade until the upgrade height and then restarts it with the binary that
+// handles the upgrade, using the same database and home directory.
+type upgradeChain struct {
+	t          *testing.T
+	db         dbm.DB
+	home       string
+	chainID    string
+	evmChainID uint64
+
+	app     *app.EVMD
+	network *network.IntegrationNetwork
+	header  cmtproto.Header
+	votes   []abci.VoteInfo
+}
+
+func newUpgradeChain(t *testing.T, opts ...network.ConfigOption) *upgradeChain {
+	c := &upgradeChain{
+		t:    t,
+		db:   dbm.NewMemDB(),
+		home: t.TempDir(),
+	}
+	c.network = network.New(func(chainID string, evmChainID uint64, customBaseAppOptions ...func(*baseapp.BaseApp)) evm.EvmApp {
+		c.chainID = chainID
+		c.evmChainID = evmChainID
+		c.app = c.newApp(customBaseAppOptions...)
+		return c.app
+	}, opts...)
+	c.header = c.network.GetContext().BlockHeader()
+	return c
+}
+
+func (c *upgradeChain) newApp(customBaseAppOptions ...func(*baseapp.BaseApp)) *app.EVMD {
+	return app.NewEVMApp(
+		log.NewNopLogger(),
+		c.db,
+		nil,
+		true,
+		simutils.NewAppOptionsWithFlagHome(c.home),
+		c.evmChainID,
+		testconfig.EvmAppOptions,
+		append(customBaseAppOptions, baseapp.SetChainID(c.chainID))...,
+	)
+}
+
+// context returns the context for reading and writing the committed state.
+func (c *upgradeChain) context() sdk.Context {
+	return c.app.NewUncachedContext(false, c.header)
+}
+
+// runUntilUpgrade produces blocks with the old binary until it stops at the
+// upgrade height.
+func (c *upgradeChain) runUntilUpgrade(name string, height int64) {
+	for {
+		ctx := c.network.GetContext()
+		c.header = ctx.BlockHeader()
+		c.votes = ctx.VoteInfos()
+
+		err := c.network.NextBlock()
+		if c.header.Height+1 < height {
+			require.NoError(c.t, err)
+			continue
+		}
+		require.ErrorContains(c.t, err, "UPGRADE \""+name+"\" NEEDED")
+		return
+	}
+}
+
+// dropStore removes the store from the committed state as if the old binary
+// did not have it.
+func (c *upgradeChain) dropStore(name string) {
+	key := []byte(fmt.Sprintf("s/%d", c.header.Height))
+	bz, err := c.db.Get(key)
+	require.NoError(c.t, err)
+	var info storetypes.CommitInfo
+	require.NoError(c.t, info.Unmarshal(bz))
+	stores := info.StoreInfos[:0]
+	for _, s := range info.StoreInfos {
+		if s.Name != name {
+			stores = append(stores, s)
+		}
+	}
+	info.StoreInfos = stores
+	bz, err = info.Marshal()
+	require.NoError(c.t, err)
+	require.NoError(c.t, c.db.Set(key, bz))
+
+	prefix := []byte("s/k:" + name + "/")
+	it, err := c.db.Iterator(prefix, storetypes.PrefixEndBytes(prefix))
+	require.NoError(c.t, err)
+	var keys [][]byte
+	for ; it.Valid(); it.Next() {
+		keys = append(keys, it.Key())
+	}
+	require.NoError(c.t, it.Close())
+	for _, k := range keys {
+		require.NoError(c.t, c.db.Delete(k))
+	}
+}
+
+// restart replaces the old binary with the one that handles the upgrade.
+func (c *upgradeChain) restart() {
+	c.app = c.newApp()
+	require.Equal(c.t, c.header.Height, c.app.LastBlockHeight())
+}
+
+// nextBlock produces the block with the new binary.
+func (c *upgradeChain) nextBlock() {
+	c.header.Height++
+	c.header.Time = c.header.Time.Add(time.Second)
+	c.header.AppHash = c.app.LastCommitID().Hash
+
+	_, err := c.app.FinalizeBlock(&abci.RequestFinalizeBlock{
+		Height:             c.header.Height,
+		Time:               c.header.Time,
+		Hash:               c.header.AppHash,
+		ProposerAddress:    c.header.ProposerAddress,
+		NextValidatorsHash: c.header.NextValidatorsHash,
+		DecidedLastCommit:  abci.CommitInfo{Votes: c.votes},
+	})
+	require.NoError(c.t, err)
+	_, err = c.app.Commit()
+	require.NoError(c.t, err)
+}
+
+func TestUpgrade(t *testing.T) {
+	const upgradeHeight = 5
+
+	account := sdk.AccAddress(common.HexToAddress("0x00000000000000000000000000000000000a11ce").Bytes())
+	c := newUpgradeChain(t, network.WithPreFundedAccounts(account))
+	denom := c.network.GetBaseDenom()
+
+	// State before the upgrade.
+	require.NoError(t, c.app.UpgradeKeeper.ScheduleUpgrade(c.context(), upgradetypes.Plan{
+		Name:   testUpgradeName,
+		Height: upgradeHeight,
+	}))
+	c.runUntilUpgrade(testUpgradeName, upgradeHeight)
+
+	ctx := c.context()
+	balance := c.app.BankKeeper.GetBalance(ctx, account, denom)
+	require.False(t, balance.IsZero())
+	paramsBefore := c.app.EVMKeeper.GetParams(ctx)
+	require.False(t, paramsBefore.AllowUnprotectedTxs)
+	versionsBefore, err := c.app.UpgradeKeeper.GetModuleVersionMap(ctx)
+	require.NoError(t, err)
+
+	handlerCalled := false
+	upgrades := app.Upgrades
+	app.Upgrades = append(app.Upgrades, app.Upgrade{
+		Name: testUpgradeName,
+		// Deleting a store that is not mounted is a no-op, this only
+		// exercises the store loader.
+		StoreUpgrades: storetypes.StoreUpgrades{Deleted: []string{"legacy"}},
+		EVMParams: func(params *evmtypes.Params) {
+			params.AllowUnprotectedTxs = true
+		},
+		Handler: func(ctx sdk.Context, a *app.EVMD) error {
+			handlerCalled = true
+			require.Equal(t, int64(upgradeHeight), ctx.BlockHeight())
+			return nil
+		},
+	})
+	t.Cleanup(func() { app.Upgrades = upgrades })
+
+	c.restart()
+	c.nextBlock()
+	c.nextBlock()
+
+	// State after the upgrade.
+	ctx = c.context()
+	require.True(t, handlerCalled)
+	doneHeight, err := c.app.UpgradeKeeper.GetDoneHeight(ctx, testUpgradeName)
+	require.NoError(t, err)
+	require.Equal(t, int64(upgradeHeight), doneHeight)
+
+	plan, err := c.app.UpgradeKeeper.GetUpgradePlan(ctx)
+	require.ErrorIs(t, err, upgradetypes.ErrNoUpgradePlanFound, "plan %v is not cleared", plan)
+
+	paramsAfter := c.app.EVMKeeper.GetParams(ctx)
+	require.True(t, paramsAfter.AllowUnprotectedTxs)
+	paramsAfter.AllowUnprotectedTxs = false
+	require.Equal(t, paramsBefore, paramsAfter, "unrelated EVM params are changed")
+
+	versionsAfter, err := c.app.UpgradeKeeper.GetModuleVersionMap(ctx)
+	require.NoError(t, err)
+	require.Equal(t, versionsBefore, versionsAfter)
+
+	require.Equal(t, balance, c.app.BankKeeper.GetBalance(ctx, account, denom))
+}
+
+func TestUpgradeMailRegistry(t *testing.T) {
+	const upgradeHeight = 4
+
+	// The old binary does not know about the upgrade.
+	upgrades := app.Upgrades
+	app.Upgrades = nil
+	t.Cleanup(func() { app.Upgrades = upgrades })
+
+	c := newUpgradeChain(t)
+	ctx := c.context()
+	require.NoError(t, c.app.UpgradeKeeper.ScheduleUpgrade(ctx, upgradetypes.Plan{
+		Name:   app.UpgradeNameMailRegistry,
+		Height: upgradeHeight,
+	}))
+	// Chains started before x/mail have no version for it.
+	ctx.KVStore(c.app.GetKey(upgradetypes.StoreKey)).Delete(append([]byte{upgradetypes.VersionMapByte}, mailtypes.ModuleName...))
+	c.runUntilUpgrade(app.UpgradeNameMailRegistry, upgradeHeight)
+	c.dropStore(mailtypes.StoreKey)
+
+	app.Upgrades = upgrades
+	c.restart()
+	c.nextBlock()
+	c.nextBlock()
+
+	ctx = c.context()
+	doneHeight, err := c.app.UpgradeKeeper.GetDoneHeight(ctx, app.UpgradeNameMailRegistry)
+	require.NoError(t, err)
+	require.Equal(t, int64(upgradeHeight), doneHeight)
+
+	versions, err := c.app.UpgradeKeeper.GetModuleVersionMap(ctx)
+	require.NoError(t, err)
+	require.Contains(t, versions, mailtypes.ModuleName)
+
+	params, err := c.app.MailKeeper.GetParams(ctx)
+	require.NoError(t, err)
+	require.Equal(t, app.NewMailGenesisState().Params, params)
+
+	// The store is usable after the upgrade.
+	res, err := mailkeeper.NewMsgServerImpl(c.app.MailKeeper).RegisterDomain(ctx, &mailtypes.MsgRegisterDomain{
+		Owner: sdk.AccAddress("owner_______________").String(),
+		Name:  "example.org",
+	})
+	require.NoError(t, err)
+	require.NotEmpty(t, res.Challenge)
+}
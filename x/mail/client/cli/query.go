@@ -0,0 +1,137 @@
+package cli
+
+import (
+	"github.com/spf13/cobra"
+
+	"github.com/cosmos/cosmos-sdk/client"
+	"github.com/cosmos/cosmos-sdk/client/flags"
+
+	"github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+const (
+	flagOwner    = "owner"
+	flagVerified = "verified"
+)
+
+// GetQueryCmd returns the parent command for all x/mail CLI query commands
+func GetQueryCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:                        types.ModuleName,
+		Short:                      "Querying commands for the mail server registry",
+		DisableFlagParsing:         true,
+		SuggestionsMinimumDistance: 2,
+		RunE:                       client.ValidateCmd,
+	}
+
+	cmd.AddCommand(
+		GetParamsCmd(),
+		GetDomainCmd(),
+		GetDomainsCmd(),
+	)
+	return cmd
+}
+
+// GetParamsCmd queries the module parameters
+func GetParamsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "params",
+		Short: "Gets x/mail params",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			clientCtx, err := client.GetClientQueryContext(cmd)
+			if err != nil {
+				return err
+			}
+
+			queryClient := types.NewQueryClient(clientCtx)
+
+			res, err := queryClient.Params(cmd.Context(), &types.QueryParamsRequest{})
+			if err != nil {
+				return err
+			}
+
+			return clientCtx.PrintProto(&res.Params)
+		},
+	}
+
+	flags.AddQueryFlagsToCmd(cmd)
+	return cmd
+}
+
+// GetDomainCmd queries a single registered domain
+func GetDomainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "domain DOMAIN",
+		Short: "Get the registry entry of a domain",
+		Long:  "Get the owner, verification status, MX hosts and DKIM/TLS key fingerprints of a domain",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			clientCtx, err := client.GetClientQueryContext(cmd)
+			if err != nil {
+				return err
+			}
+
+			queryClient := types.NewQueryClient(clientCtx)
+
+			res, err := queryClient.Domain(cmd.Context(), &types.QueryDomainRequest{
+				Name: types.NormalizeDomain(args[0]),
+			})
+			if err != nil {
+				return err
+			}
+
+			return clientCtx.PrintProto(&res.Domain)
+		},
+	}
+
+	flags.AddQueryFlagsToCmd(cmd)
+	return cmd
+}
+
+// GetDomainsCmd queries all registered domains
+func GetDomainsCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "domains",
+		Short: "Gets registered domains",
+		Args:  cobra.NoArgs,
+		RunE: func(cmd *cobra.Command, _ []string) error {
+			clientCtx, err := client.GetClientQueryContext(cmd)
+			if err != nil {
+				return err
+			}
+
+			queryClient := types.NewQueryClient(clientCtx)
+
+			pageReq, err := client.ReadPageRequest(cmd.Flags())
+			if err != nil {
+				return err
+			}
+			owner, err := cmd.Flags().GetString(flagOwner)
+			if err != nil {
+				return err
+			}
+			verified, err := cmd.Flags().GetBool(flagVerified)
+			if err != nil {
+				return err
+			}
+
+			res, err := queryClient.Domains(cmd.Context(), &types.QueryDomainsRequest{
+				Owner:        owner,
+				VerifiedOnly: verified,
+				Pagination:   pageReq,
+			})
+			if err != nil {
+				return err
+			}
+
+			return clientCtx.PrintProto(res)
+		},
+	}
+
+	cmd.Flags().String(flagOwner, "", "Only list domains registered by this account")
+	cmd.Flags().Bool(flagVerified, false, "Only list verified domains")
+	flags.AddQueryFlagsToCmd(cmd)
+	flags.AddPaginationFlagsToCmd(cmd, "domains")
+	return cmd
+}
@@ -0,0 +1,342 @@
+package cli
+
+import (
+	"fmt"
+	"net"
+	"strconv"
+	"strings"
+
+	"github.com/spf13/cobra"
+
+	"github.com/cosmos/cosmos-sdk/client"
+	"github.com/cosmos/cosmos-sdk/client/flags"
+	"github.com/cosmos/cosmos-sdk/client/tx"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+
+	"github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+const (
+	flagSkipDNSCheck = "skip-dns-check"
+	flagMX           = "mx"
+	flagDKIM         = "dkim"
+	flagTLS          = "tls"
+)
+
+// NewTxCmd returns a root CLI command handler for x/mail transaction commands
+func NewTxCmd() *cobra.Command {
+	txCmd := &cobra.Command{
+		Use:                        types.ModuleName,
+		Short:                      "Mail server registry subcommands",
+		DisableFlagParsing:         true,
+		SuggestionsMinimumDistance: 2,
+		RunE:                       client.ValidateCmd,
+	}
+
+	txCmd.AddCommand(
+		NewRegisterDomainCmd(),
+		NewAttestDomainCmd(),
+		NewBondDomainCmd(),
+		NewSetMailServersCmd(),
+		NewDeregisterDomainCmd(),
+	)
+	return txCmd
+}
+
+// NewRegisterDomainCmd returns a CLI command handler for claiming a domain
+func NewRegisterDomainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "register DOMAIN",
+		Short: "Claim a domain and receive a DNS challenge",
+		Long: `Claim a domain for the --from account. The transaction events contain the
+challenge that has to be published as
+
+  ` + types.ChallengeRecordPrefix + `DOMAIN. IN TXT "` + types.ChallengeValuePrefix + `CHALLENGE"
+
+before validators can attest it. Several accounts can claim a domain at the same
+time, the first claim verified wins. Use "query mail domains --owner ADDRESS" to
+look up the pending claims again.`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx, err := client.GetClientTxContext(cmd)
+			if err != nil {
+				return err
+			}
+
+			msg := &types.MsgRegisterDomain{
+				Owner: cliCtx.GetFromAddress().String(),
+				Name:  types.NormalizeDomain(args[0]),
+			}
+			if err := msg.ValidateBasic(); err != nil {
+				return err
+			}
+
+			return tx.GenerateOrBroadcastTxCLI(cliCtx, cmd.Flags(), msg)
+		},
+	}
+
+	flags.AddTxFlagsToCmd(cmd)
+	return cmd
+}
+
+// NewAttestDomainCmd returns a CLI command handler for validators attesting
+// the DNS challenge of a pending domain
+func NewAttestDomainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "attest DOMAIN [CHALLENGE]",
+		Short: "Attest the DNS challenge of a pending domain as a bonded validator",
+		Long: `Check that CHALLENGE is published in the ` + types.ChallengeRecordPrefix + `DOMAIN TXT record and
+attest it. If CHALLENGE is not given, the challenge published in the TXT record
+is attested. The --from account must be the operator account of a bonded
+validator.`,
+		Args: cobra.RangeArgs(1, 2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx, err := client.GetClientTxContext(cmd)
+			if err != nil {
+				return err
+			}
+			name := types.NormalizeDomain(args[0])
+
+			skip, err := cmd.Flags().GetBool(flagSkipDNSCheck)
+			if err != nil {
+				return err
+			}
+			var challenge string
+			switch {
+			case len(args) == 2 && skip:
+				challenge = args[1]
+			case len(args) == 2:
+				challenge = args[1]
+				if err := checkChallenge(name, challenge); err != nil {
+					return err
+				}
+			case skip:
+				return fmt.Errorf("CHALLENGE is required with --%s", flagSkipDNSCheck)
+			default:
+				challenge, err = publishedChallenge(name)
+				if err != nil {
+					return err
+				}
+			}
+
+			msg := &types.MsgAttestDomain{
+				Attestor:  cliCtx.GetFromAddress().String(),
+				Name:      name,
+				Challenge: challenge,
+			}
+			if err := msg.ValidateBasic(); err != nil {
+				return err
+			}
+
+			return tx.GenerateOrBroadcastTxCLI(cliCtx, cmd.Flags(), msg)
+		},
+	}
+
+	cmd.Flags().Bool(flagSkipDNSCheck, false, "Attest without looking up the TXT record")
+	flags.AddTxFlagsToCmd(cmd)
+	return cmd
+}
+
+func checkChallenge(domain, challenge string) error {
+	record := types.ChallengeRecordName(domain)
+	txts, err := net.LookupTXT(record)
+	if err != nil {
+		return fmt.Errorf("failed to look up %s: %w", record, err)
+	}
+	want := types.ChallengeRecordValue(challenge)
+	for _, txt := range txts {
+		if strings.TrimSpace(txt) == want {
+			return nil
+		}
+	}
+	return fmt.Errorf("%s does not contain %q", record, want)
+}
+
+// publishedChallenge returns the challenge published in the TXT record of the
+// domain.
+func publishedChallenge(domain string) (string, error) {
+	record := types.ChallengeRecordName(domain)
+	txts, err := net.LookupTXT(record)
+	if err != nil {
+		return "", fmt.Errorf("failed to look up %s: %w", record, err)
+	}
+	var challenges []string
+	for _, txt := range txts {
+		if challenge, ok := strings.CutPrefix(strings.TrimSpace(txt), types.ChallengeValuePrefix); ok {
+			challenges = append(challenges, challenge)
+		}
+	}
+	switch len(challenges) {
+	case 0:
+		return "", fmt.Errorf("%s contains no challenge", record)
+	case 1:
+		return challenges[0], nil
+	default:
+		return "", fmt.Errorf("%s contains several challenges, specify the one to attest", record)
+	}
+}
+
+// NewBondDomainCmd returns a CLI command handler for bonding stake for a
+// pending domain
+func NewBondDomainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "bond DOMAIN AMOUNT",
+		Short: "Bond at least the min_bond amount for a pending domain",
+		Long: `Escrow AMOUNT in the x/mail module account. A bonded domain is verified
+once a single bonded validator attests its DNS challenge. The bond is refunded
+when the domain is deregistered, its claim expires or another claim of the
+domain is verified first.`,
+		Args: cobra.ExactArgs(2),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx, err := client.GetClientTxContext(cmd)
+			if err != nil {
+				return err
+			}
+
+			amount, err := sdk.ParseCoinNormalized(args[1])
+			if err != nil {
+				return err
+			}
+
+			msg := &types.MsgBondDomain{
+				Owner:  cliCtx.GetFromAddress().String(),
+				Name:   types.NormalizeDomain(args[0]),
+				Amount: amount,
+			}
+			if err := msg.ValidateBasic(); err != nil {
+				return err
+			}
+
+			return tx.GenerateOrBroadcastTxCLI(cliCtx, cmd.Flags(), msg)
+		},
+	}
+
+	flags.AddTxFlagsToCmd(cmd)
+	return cmd
+}
+
+// NewSetMailServersCmd returns a CLI command handler for declaring the mail
+// servers of a verified domain
+func NewSetMailServersCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "set-servers DOMAIN",
+		Short: "Declare the MX hosts and DKIM/TLS key fingerprints of a verified domain",
+		Long: `Replace the mail server records of DOMAIN. Records not given are removed.
+
+Fingerprints are hex-encoded SHA-256 digests of the SubjectPublicKeyInfo, e.g.
+
+  openssl x509 -in cert.pem -pubkey -noout | openssl pkey -pubin -outform der | sha256sum`,
+		Example: `tx mail set-servers example.org --mx mx1.example.org:10 --mx mx2.example.org:20 \
+  --dkim default:3f1c...e9 --tls mx1.example.org:a4b2...07`,
+		Args: cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx, err := client.GetClientTxContext(cmd)
+			if err != nil {
+				return err
+			}
+
+			mxFlags, err := cmd.Flags().GetStringArray(flagMX)
+			if err != nil {
+				return err
+			}
+			mxHosts := make([]types.MXHost, 0, len(mxFlags))
+			for _, v := range mxFlags {
+				mx, err := parseMXHost(v)
+				if err != nil {
+					return err
+				}
+				mxHosts = append(mxHosts, mx)
+			}
+			dkimKeys, err := fingerprintsFlag(cmd, flagDKIM)
+			if err != nil {
+				return err
+			}
+			tlsKeys, err := fingerprintsFlag(cmd, flagTLS)
+			if err != nil {
+				return err
+			}
+
+			msg := &types.MsgSetMailServers{
+				Owner:    cliCtx.GetFromAddress().String(),
+				Name:     types.NormalizeDomain(args[0]),
+				MxHosts:  mxHosts,
+				DkimKeys: dkimKeys,
+				TlsKeys:  tlsKeys,
+			}
+			if err := msg.ValidateBasic(); err != nil {
+				return err
+			}
+
+			return tx.GenerateOrBroadcastTxCLI(cliCtx, cmd.Flags(), msg)
+		},
+	}
+
+	cmd.Flags().StringArray(flagMX, nil, "MX host as HOST[:PREFERENCE], can be repeated")
+	cmd.Flags().StringArray(flagDKIM, nil, "DKIM key as SELECTOR:SHA256, can be repeated")
+	cmd.Flags().StringArray(flagTLS, nil, "TLS key as HOST:SHA256, can be repeated")
+	flags.AddTxFlagsToCmd(cmd)
+	return cmd
+}
+
+func parseMXHost(v string) (types.MXHost, error) {
+	host, prefStr, hasPref := strings.Cut(v, ":")
+	mx := types.MXHost{Host: types.NormalizeDomain(host), Preference: 10}
+	if hasPref {
+		pref, err := strconv.ParseUint(prefStr, 10, 16)
+		if err != nil {
+			return types.MXHost{}, fmt.Errorf("invalid preference in %s: %w", v, err)
+		}
+		mx.Preference = uint32(pref)
+	}
+	return mx, nil
+}
+
+func fingerprintsFlag(cmd *cobra.Command, name string) ([]types.KeyFingerprint, error) {
+	values, err := cmd.Flags().GetStringArray(name)
+	if err != nil {
+		return nil, err
+	}
+	keys := make([]types.KeyFingerprint, 0, len(values))
+	for _, v := range values {
+		// Split at the first colon only, openssl prints digests with colons.
+		selector, fp, ok := strings.Cut(v, ":")
+		if !ok {
+			return nil, fmt.Errorf("--%s %s: expected SELECTOR:SHA256", name, v)
+		}
+		keys = append(keys, types.KeyFingerprint{
+			Selector:    strings.ToLower(selector),
+			Algorithm:   types.FingerprintSHA256,
+			Fingerprint: types.NormalizeFingerprint(fp),
+		})
+	}
+	return keys, nil
+}
+
+// NewDeregisterDomainCmd returns a CLI command handler for removing a domain
+// from the registry
+func NewDeregisterDomainCmd() *cobra.Command {
+	cmd := &cobra.Command{
+		Use:   "deregister DOMAIN",
+		Short: "Remove a domain from the registry and refund its bond",
+		Args:  cobra.ExactArgs(1),
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cliCtx, err := client.GetClientTxContext(cmd)
+			if err != nil {
+				return err
+			}
+
+			msg := &types.MsgDeregisterDomain{
+				Owner: cliCtx.GetFromAddress().String(),
+				Name:  types.NormalizeDomain(args[0]),
+			}
+			if err := msg.ValidateBasic(); err != nil {
+				return err
+			}
+
+			return tx.GenerateOrBroadcastTxCLI(cliCtx, cmd.Flags(), msg)
+		},
+	}
+
+	flags.AddTxFlagsToCmd(cmd)
+	return cmd
+}
@@ -0,0 +1,47 @@
+package keeper
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+// InitGenesis imports the x/mail state from the genesis file.
+func (k Keeper) InitGenesis(ctx context.Context, gs types.GenesisState) error {
+	if err := k.SetParams(ctx, gs.Params); err != nil {
+		return fmt.Errorf("failed to set x/mail params: %w", err)
+	}
+	for _, d := range gs.Domains {
+		var err error
+		if d.IsVerified() {
+			err = k.Domains.Set(ctx, d.Name, d)
+		} else {
+			err = k.setClaim(ctx, d)
+		}
+		if err != nil {
+			return fmt.Errorf("failed to import domain %s: %w", d.Name, err)
+		}
+	}
+	return nil
+}
+
+// ExportGenesis exports the x/mail state.
+func (k Keeper) ExportGenesis(ctx context.Context) (*types.GenesisState, error) {
+	params, err := k.GetParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	domains := []types.Domain{}
+	err = k.Domains.Walk(ctx, nil, func(_ string, d types.Domain) (bool, error) {
+		domains = append(domains, d)
+		return false, nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	gs := types.NewGenesisState(params, domains)
+	return &gs, nil
+}
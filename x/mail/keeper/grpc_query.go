@@ -0,0 +1,82 @@
+package keeper
+
+import (
+	"context"
+
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/status"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/types/query"
+
+	"github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+var _ types.QueryServer = queryServer{}
+
+type queryServer struct {
+	k Keeper
+}
+
+// NewQueryServerImpl returns an implementation of the x/mail QueryServer
+// interface for the provided Keeper.
+func NewQueryServerImpl(keeper Keeper) types.QueryServer {
+	return queryServer{k: keeper}
+}
+
+// Params returns the module parameters.
+func (s queryServer) Params(ctx context.Context, _ *types.QueryParamsRequest) (*types.QueryParamsResponse, error) {
+	params, err := s.k.GetParams(ctx)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &types.QueryParamsResponse{Params: params}, nil
+}
+
+// Domain returns the registry entry of a single verified domain.
+func (s queryServer) Domain(ctx context.Context, req *types.QueryDomainRequest) (*types.QueryDomainResponse, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "empty request")
+	}
+	d, ok, err := s.k.GetDomain(ctx, req.Name)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	if !ok {
+		return nil, status.Errorf(codes.NotFound, "domain %s is not registered", req.Name)
+	}
+	return &types.QueryDomainResponse{Domain: d}, nil
+}
+
+// Domains returns all verified domains and pending claims matching the
+// request filters.
+func (s queryServer) Domains(ctx context.Context, req *types.QueryDomainsRequest) (*types.QueryDomainsResponse, error) {
+	if req == nil {
+		return nil, status.Error(codes.InvalidArgument, "empty request")
+	}
+	if req.Owner != "" {
+		if _, err := sdk.AccAddressFromBech32(req.Owner); err != nil {
+			return nil, status.Errorf(codes.InvalidArgument, "invalid owner: %v", err)
+		}
+	}
+
+	domains, pageRes, err := query.CollectionFilteredPaginate(
+		ctx, s.k.Domains, req.Pagination,
+		func(_ string, d types.Domain) (bool, error) {
+			if req.Owner != "" && d.Owner != req.Owner {
+				return false, nil
+			}
+			if req.VerifiedOnly && !d.IsVerified() {
+				return false, nil
+			}
+			return true, nil
+		},
+		func(_ string, d types.Domain) (types.Domain, error) {
+			return d, nil
+		},
+	)
+	if err != nil {
+		return nil, status.Error(codes.Internal, err.Error())
+	}
+	return &types.QueryDomainsResponse{Domains: domains, Pagination: pageRes}, nil
+}
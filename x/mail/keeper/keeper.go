@@ -0,0 +1,208 @@
+package keeper
+
+import (
+	"context"
+	"errors"
+
+	"cosmossdk.io/collections"
+	corestore "cosmossdk.io/core/store"
+	errorsmod "cosmossdk.io/errors"
+	"cosmossdk.io/log"
+
+	"github.com/cosmos/cosmos-sdk/codec"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	errortypes "github.com/cosmos/cosmos-sdk/types/errors"
+
+	"github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+// Keeper of the x/mail store.
+type Keeper struct {
+	cdc          codec.BinaryCodec
+	storeService corestore.KVStoreService
+	// the address capable of executing a MsgUpdateParams message. Typically, this should be the x/gov module account.
+	authority string
+
+	bankKeeper    types.BankKeeper
+	stakingKeeper types.StakingKeeper
+
+	Schema collections.Schema
+	Params collections.Item[types.Params]
+	// Domains holds the verified domains keyed by name and the pending
+	// claims keyed by types.ClaimKey. Several accounts can claim a domain at
+	// the same time, the first verified claim wins.
+	Domains collections.Map[string, types.Domain]
+	// ClaimExpiry indexes the pending claims by the challenge expiry height
+	// so expired claims are pruned at the end of the block.
+	ClaimExpiry collections.KeySet[collections.Pair[int64, string]]
+}
+
+// NewKeeper creates a new x/mail Keeper instance.
+func NewKeeper(
+	cdc codec.BinaryCodec,
+	storeService corestore.KVStoreService,
+	authority sdk.AccAddress,
+	bankKeeper types.BankKeeper,
+	stakingKeeper types.StakingKeeper,
+) Keeper {
+	// ensure authority account is correctly formatted
+	if err := sdk.VerifyAddressFormat(authority); err != nil {
+		panic(err)
+	}
+
+	sb := collections.NewSchemaBuilder(storeService)
+	k := Keeper{
+		cdc:           cdc,
+		storeService:  storeService,
+		authority:     authority.String(),
+		bankKeeper:    bankKeeper,
+		stakingKeeper: stakingKeeper,
+		Params:        collections.NewItem(sb, types.ParamsKey, "params", codec.CollValue[types.Params](cdc)),
+		Domains:       collections.NewMap(sb, types.DomainsKey, "domains", collections.StringKey, codec.CollValue[types.Domain](cdc)),
+		ClaimExpiry:   collections.NewKeySet(sb, types.ClaimExpiryKey, "claim_expiry", collections.PairKeyCodec(collections.Int64Key, collections.StringKey)),
+	}
+
+	schema, err := sb.Build()
+	if err != nil {
+		panic(err)
+	}
+	k.Schema = schema
+
+	return k
+}
+
+// GetAuthority returns the x/mail module authority.
+func (k Keeper) GetAuthority() string {
+	return k.authority
+}
+
+// Logger returns a module-specific logger.
+func (k Keeper) Logger(ctx context.Context) log.Logger {
+	return sdk.UnwrapSDKContext(ctx).Logger().With("module", "x/"+types.ModuleName)
+}
+
+// GetParams returns the module parameters.
+func (k Keeper) GetParams(ctx context.Context) (types.Params, error) {
+	return k.Params.Get(ctx)
+}
+
+// SetParams validates and stores the module parameters.
+func (k Keeper) SetParams(ctx context.Context, params types.Params) error {
+	if err := params.Validate(); err != nil {
+		return err
+	}
+	return k.Params.Set(ctx, params)
+}
+
+// GetDomain returns the verified registry entry for name. The name is
+// normalized before the lookup.
+func (k Keeper) GetDomain(ctx context.Context, name string) (types.Domain, bool, error) {
+	d, err := k.Domains.Get(ctx, types.NormalizeDomain(name))
+	if err != nil {
+		if errors.Is(err, collections.ErrNotFound) {
+			return types.Domain{}, false, nil
+		}
+		return types.Domain{}, false, err
+	}
+	return d, true, nil
+}
+
+// IsVerified reports whether the domain is registered and verified.
+func (k Keeper) IsVerified(ctx context.Context, name string) (bool, error) {
+	d, ok, err := k.GetDomain(ctx, name)
+	if err != nil || !ok {
+		return false, err
+	}
+	return d.IsVerified(), nil
+}
+
+// GetClaim returns the pending claim of the domain by owner, including an
+// expired one that is not pruned yet.
+func (k Keeper) GetClaim(ctx context.Context, name, owner string) (types.Domain, bool, error) {
+	d, err := k.Domains.Get(ctx, types.ClaimKey(types.NormalizeDomain(name), owner))
+	if err != nil {
+		if errors.Is(err, collections.ErrNotFound) {
+			return types.Domain{}, false, nil
+		}
+		return types.Domain{}, false, err
+	}
+	return d, true, nil
+}
+
+// GetClaims returns all pending claims of the domain.
+func (k Keeper) GetClaims(ctx context.Context, name string) ([]types.Domain, error) {
+	var claims []types.Domain
+	prefix := types.ClaimPrefix(types.NormalizeDomain(name))
+	err := k.Domains.Walk(ctx, new(collections.Range[string]).Prefix(prefix), func(_ string, d types.Domain) (bool, error) {
+		claims = append(claims, d)
+		return false, nil
+	})
+	return claims, err
+}
+
+// setClaim stores the pending claim and indexes its expiry.
+func (k Keeper) setClaim(ctx context.Context, d types.Domain) error {
+	if err := k.Domains.Set(ctx, d.Key(), d); err != nil {
+		return err
+	}
+	return k.ClaimExpiry.Set(ctx, collections.Join(d.ChallengeExpiry, d.Key()))
+}
+
+// removeClaim deletes the pending claim and, if refund is set, returns its
+// bond to the owner.
+func (k Keeper) removeClaim(ctx context.Context, d types.Domain, refund bool) error {
+	if refund {
+		if err := k.refundBond(ctx, d); err != nil {
+			return err
+		}
+	}
+	if err := k.ClaimExpiry.Remove(ctx, collections.Join(d.ChallengeExpiry, d.Key())); err != nil {
+		return err
+	}
+	return k.Domains.Remove(ctx, d.Key())
+}
+
+// refundBond returns the bond of the domain to its owner.
+func (k Keeper) refundBond(ctx context.Context, d types.Domain) error {
+	if !d.Bond.IsPositive() {
+		return nil
+	}
+	owner, err := sdk.AccAddressFromBech32(d.Owner)
+	if err != nil {
+		return errorsmod.Wrap(errortypes.ErrInvalidAddress, err.Error())
+	}
+	return k.bankKeeper.SendCoinsFromModuleToAccount(ctx, types.ModuleName, owner, sdk.NewCoins(d.Bond))
+}
+
+// PruneClaims removes the claims with the challenge expired before the
+// current block and refunds their bonds.
+func (k Keeper) PruneClaims(ctx context.Context) error {
+	height := sdk.UnwrapSDKContext(ctx).BlockHeight()
+	var expired []collections.Pair[int64, string]
+	err := k.ClaimExpiry.Walk(ctx, new(collections.Range[collections.Pair[int64, string]]).EndExclusive(collections.Join(height, "")),
+		func(key collections.Pair[int64, string]) (bool, error) {
+			expired = append(expired, key)
+			return false, nil
+		})
+	if err != nil {
+		return err
+	}
+
+	for _, key := range expired {
+		d, err := k.Domains.Get(ctx, key.K2())
+		switch {
+		case errors.Is(err, collections.ErrNotFound):
+			// The index entry is stale, the claim was already removed.
+			if err := k.ClaimExpiry.Remove(ctx, key); err != nil {
+				return err
+			}
+			continue
+		case err != nil:
+			return err
+		}
+		if err := k.removeClaim(ctx, d, true); err != nil {
+			return err
+		}
+	}
+	return nil
+}
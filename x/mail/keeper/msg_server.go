@@ -0,0 +1,402 @@
+package keeper
+
+import (
+	"context"
+
+	errorsmod "cosmossdk.io/errors"
+	sdkmath "cosmossdk.io/math"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	errortypes "github.com/cosmos/cosmos-sdk/types/errors"
+	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
+
+	"github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+var _ types.MsgServer = msgServer{}
+
+type msgServer struct {
+	k Keeper
+}
+
+// NewMsgServerImpl returns an implementation of the x/mail MsgServer
+// interface for the provided Keeper.
+func NewMsgServerImpl(keeper Keeper) types.MsgServer {
+	return msgServer{k: keeper}
+}
+
+// RegisterDomain claims a domain and issues a new DNS challenge. Several
+// accounts can claim the same domain, the first claim verified wins and the
+// others are removed. A pending claim cannot be renewed before its challenge
+// expires, an expired one is replaced and its bond refunded.
+func (s msgServer) RegisterDomain(goCtx context.Context, msg *types.MsgRegisterDomain) (*types.MsgRegisterDomainResponse, error) {
+	ctx := sdk.UnwrapSDKContext(goCtx)
+
+	params, err := s.k.GetParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	verified, found, err := s.k.GetDomain(ctx, msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if verified.Owner == msg.Owner {
+			return nil, errorsmod.Wrapf(types.ErrNotPending, "%s is already verified", msg.Name)
+		}
+		return nil, errorsmod.Wrap(types.ErrDomainTaken, msg.Name)
+	}
+
+	existing, found, err := s.k.GetClaim(ctx, msg.Name, msg.Owner)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		if ctx.BlockHeight() <= existing.ChallengeExpiry {
+			return nil, errorsmod.Wrapf(types.ErrClaimPending, "%s has a pending challenge until height %d", msg.Name, existing.ChallengeExpiry)
+		}
+		if err := s.k.removeClaim(ctx, existing, true); err != nil {
+			return nil, err
+		}
+	}
+
+	d := types.Domain{
+		Name:            msg.Name,
+		Owner:           msg.Owner,
+		Status:          types.DOMAIN_STATUS_PENDING,
+		Challenge:       types.NewChallenge(msg.Name, msg.Owner, ctx.BlockHeight(), ctx.HeaderHash()),
+		ChallengeExpiry: ctx.BlockHeight() + params.ChallengeTtl,
+		Bond:            sdk.NewCoin(params.MinBond.Denom, sdkmath.ZeroInt()),
+	}
+	if err := s.k.setClaim(ctx, d); err != nil {
+		return nil, err
+	}
+
+	ctx.EventManager().EmitEvent(
+		sdk.NewEvent(
+			types.EventTypeRegisterDomain,
+			sdk.NewAttribute(types.AttributeKeyDomain, d.Name),
+			sdk.NewAttribute(types.AttributeKeyOwner, d.Owner),
+			sdk.NewAttribute(types.AttributeKeyChallenge, d.Challenge),
+		),
+	)
+
+	return &types.MsgRegisterDomainResponse{
+		Challenge:       d.Challenge,
+		ChallengeExpiry: d.ChallengeExpiry,
+	}, nil
+}
+
+// AttestDomain records that a bonded validator observed the DNS challenge.
+// The domain is verified once min_attestations distinct validators attested,
+// or a single one if the owner bonded min_bond.
+func (s msgServer) AttestDomain(goCtx context.Context, msg *types.MsgAttestDomain) (*types.MsgAttestDomainResponse, error) {
+	ctx := sdk.UnwrapSDKContext(goCtx)
+
+	attestor, err := sdk.AccAddressFromBech32(msg.Attestor)
+	if err != nil {
+		return nil, errorsmod.Wrap(errortypes.ErrInvalidAddress, err.Error())
+	}
+	valAddr := sdk.ValAddress(attestor)
+	validator, err := s.k.stakingKeeper.GetValidator(ctx, valAddr)
+	if err != nil || !validator.IsBonded() {
+		return nil, errorsmod.Wrap(types.ErrNotBondedValidator, valAddr.String())
+	}
+
+	d, err := s.claimByChallenge(ctx, msg.Name, msg.Challenge)
+	if err != nil {
+		return nil, err
+	}
+	if d.HasAttestation(valAddr.String()) {
+		return nil, errorsmod.Wrapf(types.ErrDuplicateAttest, "%s for %s", valAddr, msg.Name)
+	}
+
+	params, err := s.k.GetParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+
+	d.Attestations = append(d.Attestations, valAddr.String())
+	ctx.EventManager().EmitEvent(
+		sdk.NewEvent(
+			types.EventTypeAttestDomain,
+			sdk.NewAttribute(types.AttributeKeyDomain, d.Name),
+			sdk.NewAttribute(types.AttributeKeyValidator, valAddr.String()),
+		),
+	)
+
+	method := verificationMethod(d, params)
+	verified := method != types.VERIFICATION_METHOD_NONE
+	if verified {
+		err = s.markVerified(ctx, d, method)
+	} else {
+		err = s.k.Domains.Set(ctx, d.Key(), d)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.MsgAttestDomainResponse{Verified: verified}, nil
+}
+
+// BondDomain escrows at least min_bond in the module account for a pending
+// domain. The bond does not prove control over the domain by itself, it
+// lowers the number of attestations of the DNS challenge needed to a single
+// one. The bond is refunded when the domain is deregistered.
+func (s msgServer) BondDomain(goCtx context.Context, msg *types.MsgBondDomain) (*types.MsgBondDomainResponse, error) {
+	ctx := sdk.UnwrapSDKContext(goCtx)
+
+	params, err := s.k.GetParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if !params.BondEnabled() {
+		return nil, types.ErrBondDisabled
+	}
+	if msg.Amount.Denom != params.MinBond.Denom || msg.Amount.IsLT(params.MinBond) {
+		return nil, errorsmod.Wrapf(types.ErrInsufficientBond, "got %s, need at least %s", msg.Amount, params.MinBond)
+	}
+
+	d, err := s.ownClaim(ctx, msg.Owner, msg.Name)
+	if err != nil {
+		return nil, err
+	}
+	if d.Bond.IsPositive() {
+		return nil, errorsmod.Wrapf(types.ErrAlreadyBonded, "%s has a bond of %s", msg.Name, d.Bond)
+	}
+
+	owner, err := sdk.AccAddressFromBech32(msg.Owner)
+	if err != nil {
+		return nil, errorsmod.Wrap(errortypes.ErrInvalidAddress, err.Error())
+	}
+	if err := s.k.bankKeeper.SendCoinsFromAccountToModule(ctx, owner, types.ModuleName, sdk.NewCoins(msg.Amount)); err != nil {
+		return nil, err
+	}
+
+	d.Bond = msg.Amount
+	ctx.EventManager().EmitEvent(
+		sdk.NewEvent(
+			types.EventTypeBondDomain,
+			sdk.NewAttribute(types.AttributeKeyDomain, d.Name),
+			sdk.NewAttribute(types.AttributeKeyOwner, d.Owner),
+			sdk.NewAttribute(types.AttributeKeyAmount, msg.Amount.String()),
+		),
+	)
+	if method := verificationMethod(d, params); method != types.VERIFICATION_METHOD_NONE {
+		err = s.markVerified(ctx, d, method)
+	} else {
+		err = s.k.Domains.Set(ctx, d.Key(), d)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	return &types.MsgBondDomainResponse{}, nil
+}
+
+// SetMailServers replaces the MX hosts and key fingerprints of a verified
+// domain.
+func (s msgServer) SetMailServers(goCtx context.Context, msg *types.MsgSetMailServers) (*types.MsgSetMailServersResponse, error) {
+	ctx := sdk.UnwrapSDKContext(goCtx)
+
+	d, err := s.ownedDomain(ctx, msg.Owner, msg.Name)
+	if err != nil {
+		return nil, err
+	}
+
+	params, err := s.k.GetParams(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if len(msg.MxHosts) > int(params.MaxMxHosts) {
+		return nil, errorsmod.Wrapf(types.ErrTooManyRecords, "%d MX hosts, limit is %d", len(msg.MxHosts), params.MaxMxHosts)
+	}
+	if len(msg.DkimKeys) > int(params.MaxKeys) || len(msg.TlsKeys) > int(params.MaxKeys) {
+		return nil, errorsmod.Wrapf(types.ErrTooManyRecords, "key fingerprint limit is %d", params.MaxKeys)
+	}
+
+	d.MxHosts = msg.MxHosts
+	d.DkimKeys = msg.DkimKeys
+	d.TlsKeys = msg.TlsKeys
+	if err := s.k.Domains.Set(ctx, d.Name, d); err != nil {
+		return nil, err
+	}
+
+	ctx.EventManager().EmitEvent(
+		sdk.NewEvent(
+			types.EventTypeSetMailServers,
+			sdk.NewAttribute(types.AttributeKeyDomain, d.Name),
+			sdk.NewAttribute(types.AttributeKeyOwner, d.Owner),
+		),
+	)
+
+	return &types.MsgSetMailServersResponse{}, nil
+}
+
+// DeregisterDomain removes the verified domain or the pending claim of the
+// owner and refunds its bond.
+func (s msgServer) DeregisterDomain(goCtx context.Context, msg *types.MsgDeregisterDomain) (*types.MsgDeregisterDomainResponse, error) {
+	ctx := sdk.UnwrapSDKContext(goCtx)
+
+	d, found, err := s.k.GetClaim(ctx, msg.Name, msg.Owner)
+	if err != nil {
+		return nil, err
+	}
+	if found {
+		err = s.k.removeClaim(ctx, d, true)
+	} else {
+		d, err = s.ownedDomain(ctx, msg.Owner, msg.Name)
+		if err != nil {
+			return nil, err
+		}
+		if err := s.k.refundBond(ctx, d); err != nil {
+			return nil, err
+		}
+		err = s.k.Domains.Remove(ctx, d.Name)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	ctx.EventManager().EmitEvent(
+		sdk.NewEvent(
+			types.EventTypeDeregisterDomain,
+			sdk.NewAttribute(types.AttributeKeyDomain, d.Name),
+			sdk.NewAttribute(types.AttributeKeyOwner, d.Owner),
+			sdk.NewAttribute(types.AttributeKeyAmount, d.Bond.String()),
+		),
+	)
+
+	return &types.MsgDeregisterDomainResponse{}, nil
+}
+
+// UpdateParams updates the module parameters. Only the authority can do it.
+func (s msgServer) UpdateParams(goCtx context.Context, msg *types.MsgUpdateParams) (*types.MsgUpdateParamsResponse, error) {
+	if s.k.authority != msg.Authority {
+		return nil, errorsmod.Wrapf(govtypes.ErrInvalidSigner, "invalid authority; expected %s, got %s", s.k.authority, msg.Authority)
+	}
+
+	if err := s.k.SetParams(goCtx, msg.Params); err != nil {
+		return nil, err
+	}
+
+	return &types.MsgUpdateParamsResponse{}, nil
+}
+
+// claimByChallenge returns the live pending claim of the domain with the
+// challenge.
+func (s msgServer) claimByChallenge(ctx sdk.Context, name, challenge string) (types.Domain, error) {
+	if _, found, err := s.k.GetDomain(ctx, name); err != nil {
+		return types.Domain{}, err
+	} else if found {
+		return types.Domain{}, errorsmod.Wrap(types.ErrNotPending, name)
+	}
+
+	claims, err := s.k.GetClaims(ctx, name)
+	if err != nil {
+		return types.Domain{}, err
+	}
+	if len(claims) == 0 {
+		return types.Domain{}, errorsmod.Wrap(types.ErrDomainNotFound, name)
+	}
+	for _, d := range claims {
+		if d.Challenge != challenge {
+			continue
+		}
+		if ctx.BlockHeight() > d.ChallengeExpiry {
+			return types.Domain{}, errorsmod.Wrapf(types.ErrChallengeExpired, "%s expired at height %d", name, d.ChallengeExpiry)
+		}
+		return d, nil
+	}
+	return types.Domain{}, errorsmod.Wrap(types.ErrChallengeMismatch, name)
+}
+
+// ownClaim returns the live pending claim of the domain by owner.
+func (s msgServer) ownClaim(ctx sdk.Context, owner, name string) (types.Domain, error) {
+	if _, found, err := s.k.GetDomain(ctx, name); err != nil {
+		return types.Domain{}, err
+	} else if found {
+		return types.Domain{}, errorsmod.Wrap(types.ErrNotPending, name)
+	}
+
+	d, found, err := s.k.GetClaim(ctx, name, owner)
+	if err != nil {
+		return types.Domain{}, err
+	}
+	if !found {
+		return types.Domain{}, errorsmod.Wrap(types.ErrDomainNotFound, name)
+	}
+	if ctx.BlockHeight() > d.ChallengeExpiry {
+		return types.Domain{}, errorsmod.Wrapf(types.ErrChallengeExpired, "%s expired at height %d", name, d.ChallengeExpiry)
+	}
+	return d, nil
+}
+
+// ownedDomain returns the verified domain of owner.
+func (s msgServer) ownedDomain(ctx sdk.Context, owner, name string) (types.Domain, error) {
+	d, found, err := s.k.GetDomain(ctx, name)
+	if err != nil {
+		return types.Domain{}, err
+	}
+	if !found {
+		if _, pending, err := s.k.GetClaim(ctx, name, owner); err != nil {
+			return types.Domain{}, err
+		} else if pending {
+			return types.Domain{}, errorsmod.Wrap(types.ErrNotVerified, name)
+		}
+		return types.Domain{}, errorsmod.Wrap(types.ErrDomainNotFound, name)
+	}
+	if d.Owner != owner {
+		return types.Domain{}, errorsmod.Wrap(types.ErrNotOwner, name)
+	}
+	return d, nil
+}
+
+// verificationMethod returns how the pending domain is verified by its
+// attestations and bond or VERIFICATION_METHOD_NONE if they are not enough.
+// Every method requires at least one attestation of the DNS challenge.
+func verificationMethod(d types.Domain, params types.Params) types.VerificationMethod {
+	attestations := uint32(len(d.Attestations)) //nolint:gosec // bounded by the validator set
+	switch {
+	case attestations >= params.MinAttestations:
+		return types.VERIFICATION_METHOD_ATTESTATION
+	case attestations > 0 && params.BondEnabled() &&
+		d.Bond.Denom == params.MinBond.Denom && !d.Bond.IsLT(params.MinBond):
+		return types.VERIFICATION_METHOD_BOND
+	default:
+		return types.VERIFICATION_METHOD_NONE
+	}
+}
+
+// markVerified moves the claim to the verified registry entry of the domain.
+// Other claims of the domain are removed and their bonds refunded, the bond
+// of the verified claim stays escrowed until the domain is deregistered.
+func (s msgServer) markVerified(ctx sdk.Context, d types.Domain, method types.VerificationMethod) error {
+	claims, err := s.k.GetClaims(ctx, d.Name)
+	if err != nil {
+		return err
+	}
+	for _, c := range claims {
+		if err := s.k.removeClaim(ctx, c, c.Owner != d.Owner); err != nil {
+			return err
+		}
+	}
+
+	d.Status = types.DOMAIN_STATUS_VERIFIED
+	d.VerifiedBy = method
+	d.VerifiedHeight = ctx.BlockHeight()
+	if err := s.k.Domains.Set(ctx, d.Name, d); err != nil {
+		return err
+	}
+
+	ctx.EventManager().EmitEvent(
+		sdk.NewEvent(
+			types.EventTypeVerifyDomain,
+			sdk.NewAttribute(types.AttributeKeyDomain, d.Name),
+			sdk.NewAttribute(types.AttributeKeyOwner, d.Owner),
+			sdk.NewAttribute(types.AttributeKeyMethod, method.String()),
+		),
+	)
+	return nil
+}
@@ -0,0 +1,333 @@
+package keeper_test
+
+import (
+	"context"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"cosmossdk.io/collections"
+	sdkmath "cosmossdk.io/math"
+	storetypes "cosmossdk.io/store/types"
+
+	"github.com/cosmos/cosmos-sdk/runtime"
+	"github.com/cosmos/cosmos-sdk/testutil"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	moduletestutil "github.com/cosmos/cosmos-sdk/types/module/testutil"
+	authtypes "github.com/cosmos/cosmos-sdk/x/auth/types"
+	govtypes "github.com/cosmos/cosmos-sdk/x/gov/types"
+	stakingtypes "github.com/cosmos/cosmos-sdk/x/staking/types"
+
+	"github.com/mail-chat-chain/mailchatd/x/mail"
+	"github.com/mail-chat-chain/mailchatd/x/mail/keeper"
+	"github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+type fakeBank struct {
+	escrow sdk.Coins
+}
+
+func (b *fakeBank) SendCoinsFromAccountToModule(_ context.Context, _ sdk.AccAddress, _ string, amt sdk.Coins) error {
+	b.escrow = b.escrow.Add(amt...)
+	return nil
+}
+
+func (b *fakeBank) SendCoinsFromModuleToAccount(_ context.Context, _ string, _ sdk.AccAddress, amt sdk.Coins) error {
+	b.escrow = b.escrow.Sub(amt...)
+	return nil
+}
+
+type fakeStaking struct {
+	bonded map[string]bool
+}
+
+func (s fakeStaking) GetValidator(_ context.Context, addr sdk.ValAddress) (stakingtypes.Validator, error) {
+	if !s.bonded[addr.String()] {
+		return stakingtypes.Validator{}, stakingtypes.ErrNoValidatorFound
+	}
+	return stakingtypes.Validator{OperatorAddress: addr.String(), Status: stakingtypes.Bonded}, nil
+}
+
+type fixture struct {
+	ctx     sdk.Context
+	keeper  keeper.Keeper
+	server  types.MsgServer
+	bank    *fakeBank
+	owner   sdk.AccAddress
+	other   sdk.AccAddress
+	val     sdk.AccAddress
+	nonVal  sdk.AccAddress
+	authAcc string
+}
+
+func setup(t *testing.T) fixture {
+	t.Helper()
+
+	key := storetypes.NewKVStoreKey(types.StoreKey)
+	ctx := testutil.DefaultContextWithDB(t, key, storetypes.NewTransientStoreKey("transient_test")).Ctx.WithBlockHeight(100)
+	encCfg := moduletestutil.MakeTestEncodingConfig(mail.AppModuleBasic{})
+
+	f := fixture{
+		bank:   &fakeBank{},
+		owner:  sdk.AccAddress("owner_______________"),
+		other:  sdk.AccAddress("other_______________"),
+		val:    sdk.AccAddress("validator___________"),
+		nonVal: sdk.AccAddress("not_a_validator_____"),
+	}
+	authority := authtypes.NewModuleAddress(govtypes.ModuleName)
+	f.authAcc = authority.String()
+	staking := fakeStaking{bonded: map[string]bool{sdk.ValAddress(f.val).String(): true}}
+
+	f.keeper = keeper.NewKeeper(encCfg.Codec, runtime.NewKVStoreService(key), authority, f.bank, staking)
+	f.server = keeper.NewMsgServerImpl(f.keeper)
+	f.ctx = ctx
+
+	params := types.DefaultParams()
+	params.MinBond = sdk.NewInt64Coin("stake", 1000)
+	// the fixture has a single validator
+	params.MinAttestations = 1
+	require.NoError(t, f.keeper.SetParams(ctx, params))
+	return f
+}
+
+func (f fixture) register(t *testing.T, owner sdk.AccAddress) *types.MsgRegisterDomainResponse {
+	t.Helper()
+	res, err := f.server.RegisterDomain(f.ctx, &types.MsgRegisterDomain{Owner: owner.String(), Name: "example.org"})
+	require.NoError(t, err)
+	return res
+}
+
+func (f fixture) setMinAttestations(t *testing.T, n uint32) {
+	t.Helper()
+	params, err := f.keeper.GetParams(f.ctx)
+	require.NoError(t, err)
+	params.MinAttestations = n
+	require.NoError(t, f.keeper.SetParams(f.ctx, params))
+}
+
+func TestRegisterAndAttest(t *testing.T) {
+	f := setup(t)
+	res := f.register(t, f.owner)
+	require.Equal(t, int64(100+types.DefaultChallengeTTL), res.ChallengeExpiry)
+
+	// the pending claim cannot be renewed before expiry
+	_, err := f.server.RegisterDomain(f.ctx, &types.MsgRegisterDomain{Owner: f.owner.String(), Name: "example.org"})
+	require.ErrorIs(t, err, types.ErrClaimPending)
+
+	// but another account can claim the domain at the same time
+	otherRes := f.register(t, f.other)
+	require.NotEqual(t, res.Challenge, otherRes.Challenge)
+
+	_, err = f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.nonVal.String(), Name: "example.org", Challenge: res.Challenge})
+	require.ErrorIs(t, err, types.ErrNotBondedValidator)
+
+	_, err = f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.val.String(), Name: "example.org", Challenge: "wrong"})
+	require.ErrorIs(t, err, types.ErrChallengeMismatch)
+
+	attest, err := f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.val.String(), Name: "example.org", Challenge: res.Challenge})
+	require.NoError(t, err)
+	require.True(t, attest.Verified)
+
+	verified, err := f.keeper.IsVerified(f.ctx, "Example.org.")
+	require.NoError(t, err)
+	require.True(t, verified)
+
+	// the competing claim is removed
+	claims, err := f.keeper.GetClaims(f.ctx, "example.org")
+	require.NoError(t, err)
+	require.Empty(t, claims)
+	_, err = f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.val.String(), Name: "example.org", Challenge: otherRes.Challenge})
+	require.ErrorIs(t, err, types.ErrNotPending)
+
+	_, err = f.server.RegisterDomain(f.ctx, &types.MsgRegisterDomain{Owner: f.other.String(), Name: "example.org"})
+	require.ErrorIs(t, err, types.ErrDomainTaken)
+}
+
+func TestExpiredChallenge(t *testing.T) {
+	f := setup(t)
+	res := f.register(t, f.owner)
+
+	f.ctx = f.ctx.WithBlockHeight(res.ChallengeExpiry + 1)
+	_, err := f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.val.String(), Name: "example.org", Challenge: res.Challenge})
+	require.ErrorIs(t, err, types.ErrChallengeExpired)
+
+	// the expired claim can be renewed
+	renewed := f.register(t, f.owner)
+	require.NotEqual(t, res.Challenge, renewed.Challenge)
+	d, found, err := f.keeper.GetClaim(f.ctx, "example.org", f.owner.String())
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, renewed.ChallengeExpiry, d.ChallengeExpiry)
+}
+
+func TestPruneClaims(t *testing.T) {
+	f := setup(t)
+	res := f.register(t, f.owner)
+	_, err := f.server.BondDomain(f.ctx, &types.MsgBondDomain{Owner: f.owner.String(), Name: "example.org", Amount: sdk.NewInt64Coin("stake", 1000)})
+	require.NoError(t, err)
+
+	// the claim is kept until the challenge expires
+	f.ctx = f.ctx.WithBlockHeight(res.ChallengeExpiry)
+	require.NoError(t, f.keeper.PruneClaims(f.ctx))
+	_, found, err := f.keeper.GetClaim(f.ctx, "example.org", f.owner.String())
+	require.NoError(t, err)
+	require.True(t, found)
+
+	f.ctx = f.ctx.WithBlockHeight(res.ChallengeExpiry + 1)
+	require.NoError(t, f.keeper.PruneClaims(f.ctx))
+	_, found, err = f.keeper.GetClaim(f.ctx, "example.org", f.owner.String())
+	require.NoError(t, err)
+	require.False(t, found)
+	require.True(t, f.bank.escrow.IsZero(), "bond of the pruned claim should be refunded")
+
+	has, err := f.keeper.ClaimExpiry.Has(f.ctx, collections.Join(res.ChallengeExpiry, types.ClaimKey("example.org", f.owner.String())))
+	require.NoError(t, err)
+	require.False(t, has)
+}
+
+func TestBondAndDeregister(t *testing.T) {
+	f := setup(t)
+	f.setMinAttestations(t, 2)
+	res := f.register(t, f.owner)
+
+	_, err := f.server.BondDomain(f.ctx, &types.MsgBondDomain{Owner: f.owner.String(), Name: "example.org", Amount: sdk.NewInt64Coin("stake", 999)})
+	require.ErrorIs(t, err, types.ErrInsufficientBond)
+
+	_, err = f.server.BondDomain(f.ctx, &types.MsgBondDomain{Owner: f.other.String(), Name: "example.org", Amount: sdk.NewInt64Coin("stake", 1000)})
+	require.ErrorIs(t, err, types.ErrDomainNotFound)
+
+	_, err = f.server.BondDomain(f.ctx, &types.MsgBondDomain{Owner: f.owner.String(), Name: "example.org", Amount: sdk.NewInt64Coin("stake", 1000)})
+	require.NoError(t, err)
+	require.Equal(t, sdkmath.NewInt(1000), f.bank.escrow.AmountOf("stake"))
+
+	_, err = f.server.BondDomain(f.ctx, &types.MsgBondDomain{Owner: f.owner.String(), Name: "example.org", Amount: sdk.NewInt64Coin("stake", 1000)})
+	require.ErrorIs(t, err, types.ErrAlreadyBonded)
+
+	// the bond replaces the missing attestation
+	attest, err := f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.val.String(), Name: "example.org", Challenge: res.Challenge})
+	require.NoError(t, err)
+	require.True(t, attest.Verified)
+
+	d, _, err := f.keeper.GetDomain(f.ctx, "example.org")
+	require.NoError(t, err)
+	require.Equal(t, types.VERIFICATION_METHOD_BOND, d.VerifiedBy)
+
+	_, err = f.server.DeregisterDomain(f.ctx, &types.MsgDeregisterDomain{Owner: f.owner.String(), Name: "example.org"})
+	require.NoError(t, err)
+	require.True(t, f.bank.escrow.IsZero())
+
+	_, found, err := f.keeper.GetDomain(f.ctx, "example.org")
+	require.NoError(t, err)
+	require.False(t, found)
+}
+
+func TestBondAfterAttestation(t *testing.T) {
+	f := setup(t)
+	f.setMinAttestations(t, 2)
+	res := f.register(t, f.owner)
+
+	attest, err := f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.val.String(), Name: "example.org", Challenge: res.Challenge})
+	require.NoError(t, err)
+	require.False(t, attest.Verified)
+
+	_, err = f.server.BondDomain(f.ctx, &types.MsgBondDomain{Owner: f.owner.String(), Name: "example.org", Amount: sdk.NewInt64Coin("stake", 1000)})
+	require.NoError(t, err)
+
+	verified, err := f.keeper.IsVerified(f.ctx, "example.org")
+	require.NoError(t, err)
+	require.True(t, verified)
+}
+
+func TestBondHijack(t *testing.T) {
+	f := setup(t)
+
+	// other does not control the DNS of example.org but registers and
+	// bonds it first
+	res := f.register(t, f.other)
+	_, err := f.server.BondDomain(f.ctx, &types.MsgBondDomain{Owner: f.other.String(), Name: "example.org", Amount: sdk.NewInt64Coin("stake", 1000)})
+	require.NoError(t, err)
+
+	verified, err := f.keeper.IsVerified(f.ctx, "example.org")
+	require.NoError(t, err)
+	require.False(t, verified, "bond alone should not verify the domain")
+
+	_, err = f.server.SetMailServers(f.ctx, &types.MsgSetMailServers{
+		Owner:   f.other.String(),
+		Name:    "example.org",
+		MxHosts: []types.MXHost{{Host: "mx.attacker.example", Preference: 10}},
+	})
+	require.ErrorIs(t, err, types.ErrNotVerified)
+
+	// the real owner claims the domain without waiting for the expiry and
+	// the bond of the losing claim is refunded
+	res = f.register(t, f.owner)
+	_, err = f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.val.String(), Name: "example.org", Challenge: res.Challenge})
+	require.NoError(t, err)
+	require.True(t, f.bank.escrow.IsZero())
+	d, _, err := f.keeper.GetDomain(f.ctx, "example.org")
+	require.NoError(t, err)
+	require.Equal(t, f.owner.String(), d.Owner)
+	require.Equal(t, types.VERIFICATION_METHOD_ATTESTATION, d.VerifiedBy)
+}
+
+func TestSetMailServers(t *testing.T) {
+	f := setup(t)
+	res := f.register(t, f.owner)
+
+	msg := &types.MsgSetMailServers{
+		Owner:   f.owner.String(),
+		Name:    "example.org",
+		MxHosts: []types.MXHost{{Host: "mx.example.org", Preference: 10}},
+	}
+	_, err := f.server.SetMailServers(f.ctx, msg)
+	require.ErrorIs(t, err, types.ErrNotVerified)
+
+	_, err = f.server.AttestDomain(f.ctx, &types.MsgAttestDomain{Attestor: f.val.String(), Name: "example.org", Challenge: res.Challenge})
+	require.NoError(t, err)
+
+	_, err = f.server.SetMailServers(f.ctx, msg)
+	require.NoError(t, err)
+
+	d, _, err := f.keeper.GetDomain(f.ctx, "example.org")
+	require.NoError(t, err)
+	require.Equal(t, msg.MxHosts, d.MxHosts)
+
+	msg.Owner = f.other.String()
+	_, err = f.server.SetMailServers(f.ctx, msg)
+	require.ErrorIs(t, err, types.ErrNotOwner)
+}
+
+func TestUpdateParamsAuthority(t *testing.T) {
+	f := setup(t)
+
+	_, err := f.server.UpdateParams(f.ctx, &types.MsgUpdateParams{Authority: f.owner.String(), Params: types.DefaultParams()})
+	require.ErrorIs(t, err, govtypes.ErrInvalidSigner)
+
+	_, err = f.server.UpdateParams(f.ctx, &types.MsgUpdateParams{Authority: f.authAcc, Params: types.DefaultParams()})
+	require.NoError(t, err)
+}
+
+func TestGenesisRoundTrip(t *testing.T) {
+	f := setup(t)
+	res := f.register(t, f.owner)
+	f.register(t, f.other)
+
+	gs, err := f.keeper.ExportGenesis(f.ctx)
+	require.NoError(t, err)
+	require.NoError(t, gs.Validate())
+	require.Len(t, gs.Domains, 2)
+
+	g := setup(t)
+	require.NoError(t, g.keeper.InitGenesis(g.ctx, *gs))
+	d, found, err := g.keeper.GetClaim(g.ctx, "example.org", f.owner.String())
+	require.NoError(t, err)
+	require.True(t, found)
+	require.Equal(t, res.Challenge, d.Challenge)
+
+	// the expiry index is restored as well
+	g.ctx = g.ctx.WithBlockHeight(res.ChallengeExpiry + 1)
+	require.NoError(t, g.keeper.PruneClaims(g.ctx))
+	claims, err := g.keeper.GetClaims(g.ctx, "example.org")
+	require.NoError(t, err)
+	require.Empty(t, claims)
+}
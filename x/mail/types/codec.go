@@ -0,0 +1,62 @@
+package types
+
+import (
+	"github.com/cosmos/cosmos-sdk/codec"
+	codectypes "github.com/cosmos/cosmos-sdk/codec/types"
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	"github.com/cosmos/cosmos-sdk/types/msgservice"
+)
+
+var (
+	amino = codec.NewLegacyAmino()
+
+	// ModuleCdc references the global x/mail module codec. Note, the codec should
+	// ONLY be used in certain instances of tests and for JSON encoding.
+	ModuleCdc = codec.NewProtoCodec(codectypes.NewInterfaceRegistry())
+
+	// AminoCdc is a amino codec created to support amino JSON compatible msgs.
+	AminoCdc = codec.NewLegacyAmino()
+)
+
+const (
+	// Amino names
+	registerDomainName   = "mailchat/x/mail/MsgRegisterDomain"
+	attestDomainName     = "mailchat/x/mail/MsgAttestDomain"
+	bondDomainName       = "mailchat/x/mail/MsgBondDomain"
+	setMailServersName   = "mailchat/x/mail/MsgSetMailServers"
+	deregisterDomainName = "mailchat/x/mail/MsgDeregisterDomain"
+	updateParamsName     = "mailchat/x/mail/MsgUpdateParams"
+)
+
+// NOTE: This is required for the GetSignBytes function
+func init() {
+	RegisterLegacyAminoCodec(amino)
+	amino.Seal()
+}
+
+// RegisterInterfaces register implementations
+func RegisterInterfaces(registry codectypes.InterfaceRegistry) {
+	registry.RegisterImplementations(
+		(*sdk.Msg)(nil),
+		&MsgRegisterDomain{},
+		&MsgAttestDomain{},
+		&MsgBondDomain{},
+		&MsgSetMailServers{},
+		&MsgDeregisterDomain{},
+		&MsgUpdateParams{},
+	)
+
+	msgservice.RegisterMsgServiceDesc(registry, &_Msg_serviceDesc)
+}
+
+// RegisterLegacyAminoCodec registers the necessary x/mail interfaces and
+// concrete types on the provided LegacyAmino codec. These types are used for
+// Amino JSON serialization and EIP-712 compatibility.
+func RegisterLegacyAminoCodec(cdc *codec.LegacyAmino) {
+	cdc.RegisterConcrete(&MsgRegisterDomain{}, registerDomainName, nil)
+	cdc.RegisterConcrete(&MsgAttestDomain{}, attestDomainName, nil)
+	cdc.RegisterConcrete(&MsgBondDomain{}, bondDomainName, nil)
+	cdc.RegisterConcrete(&MsgSetMailServers{}, setMailServersName, nil)
+	cdc.RegisterConcrete(&MsgDeregisterDomain{}, deregisterDomainName, nil)
+	cdc.RegisterConcrete(&MsgUpdateParams{}, updateParamsName, nil)
+}
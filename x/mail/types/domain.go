@@ -0,0 +1,216 @@
+package types
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"strconv"
+	"strings"
+
+	errorsmod "cosmossdk.io/errors"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+const (
+	// ChallengeRecordPrefix is prepended to the domain name to get the name
+	// of the TXT record holding the challenge.
+	ChallengeRecordPrefix = "_mailchat-challenge."
+
+	// ChallengeValuePrefix is prepended to the challenge token in the TXT
+	// record value.
+	ChallengeValuePrefix = "mailchat-verification="
+
+	// FingerprintSHA256 is the only supported key fingerprint algorithm.
+	FingerprintSHA256 = "sha256"
+)
+
+// NormalizeDomain lower-cases the domain name and strips the trailing dot.
+func NormalizeDomain(name string) string {
+	return strings.TrimSuffix(strings.ToLower(strings.TrimSpace(name)), ".")
+}
+
+// ValidateDomainName checks that name is a normalized, fully qualified
+// host name with at least two labels.
+func ValidateDomainName(name string) error {
+	if name == "" || len(name) > 253 {
+		return errorsmod.Wrapf(ErrInvalidDomain, "'%s' has invalid length", name)
+	}
+	if name != NormalizeDomain(name) {
+		return errorsmod.Wrapf(ErrInvalidDomain, "'%s' is not normalized", name)
+	}
+	labels := strings.Split(name, ".")
+	if len(labels) < 2 {
+		return errorsmod.Wrapf(ErrInvalidDomain, "'%s' is not fully qualified", name)
+	}
+	for _, l := range labels {
+		if err := validateLabel(l); err != nil {
+			return errorsmod.Wrapf(ErrInvalidDomain, "'%s': %s", name, err)
+		}
+	}
+	return nil
+}
+
+func validateLabel(l string) error {
+	if l == "" || len(l) > 63 {
+		return fmt.Errorf("label '%s' has invalid length", l)
+	}
+	if l[0] == '-' || l[len(l)-1] == '-' {
+		return fmt.Errorf("label '%s' starts or ends with a hyphen", l)
+	}
+	for _, c := range l {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' {
+			return fmt.Errorf("label '%s' contains invalid character %q", l, c)
+		}
+	}
+	return nil
+}
+
+// ChallengeRecordName returns the name of the TXT record that has to contain
+// the challenge for the domain.
+func ChallengeRecordName(domain string) string {
+	return ChallengeRecordPrefix + domain
+}
+
+// ChallengeRecordValue returns the expected TXT record value for the
+// challenge.
+func ChallengeRecordValue(challenge string) string {
+	return ChallengeValuePrefix + challenge
+}
+
+// NewChallenge derives the DNS challenge token for a registration. seed
+// should be unpredictable before the registration is included in a block,
+// e.g. the block header hash.
+func NewChallenge(domain, owner string, height int64, seed []byte) string {
+	h := sha256.New()
+	h.Write([]byte(domain))
+	h.Write([]byte{0})
+	h.Write([]byte(owner))
+	h.Write([]byte{0})
+	h.Write([]byte(strconv.FormatInt(height, 10)))
+	h.Write([]byte{0})
+	h.Write(seed)
+	return hex.EncodeToString(h.Sum(nil)[:16])
+}
+
+// ClaimKey returns the registry store key of a pending claim of the domain by
+// owner. Domain names cannot contain '/', so claims never collide with the
+// verified entries that are keyed by the name alone.
+func ClaimKey(name, owner string) string {
+	return ClaimPrefix(name) + owner
+}
+
+// ClaimPrefix returns the common prefix of the store keys of all pending
+// claims of the domain.
+func ClaimPrefix(name string) string {
+	return name + "/"
+}
+
+// Key returns the registry store key of the entry.
+func (d Domain) Key() string {
+	if d.IsVerified() {
+		return d.Name
+	}
+	return ClaimKey(d.Name, d.Owner)
+}
+
+// IsVerified reports whether control over the domain was proven.
+func (d Domain) IsVerified() bool {
+	return d.Status == DOMAIN_STATUS_VERIFIED
+}
+
+// HasAttestation reports whether the validator already attested the domain.
+func (d Domain) HasAttestation(valoper string) bool {
+	for _, a := range d.Attestations {
+		if a == valoper {
+			return true
+		}
+	}
+	return false
+}
+
+// Validate performs a stateless validation of the domain entry.
+func (d Domain) Validate() error {
+	if err := ValidateDomainName(d.Name); err != nil {
+		return err
+	}
+	if _, err := sdk.AccAddressFromBech32(d.Owner); err != nil {
+		return errorsmod.Wrapf(err, "invalid owner of '%s'", d.Name)
+	}
+	switch d.Status {
+	case DOMAIN_STATUS_PENDING, DOMAIN_STATUS_VERIFIED:
+	default:
+		return fmt.Errorf("domain '%s' has invalid status %s", d.Name, d.Status)
+	}
+	if d.IsVerified() && d.VerifiedBy == VERIFICATION_METHOD_NONE {
+		return fmt.Errorf("verified domain '%s' has no verification method", d.Name)
+	}
+	if err := d.Bond.Validate(); err != nil {
+		return errorsmod.Wrapf(err, "invalid bond of '%s'", d.Name)
+	}
+	return ValidateMailServers(d.MxHosts, d.DkimKeys, d.TlsKeys)
+}
+
+// ValidateMailServers performs a stateless validation of mail server records.
+func ValidateMailServers(mxHosts []MXHost, dkimKeys, tlsKeys []KeyFingerprint) error {
+	seen := make(map[string]bool, len(mxHosts))
+	for _, mx := range mxHosts {
+		if err := mx.Validate(); err != nil {
+			return err
+		}
+		if seen[mx.Host] {
+			return errorsmod.Wrapf(ErrInvalidMXHost, "duplicate host '%s'", mx.Host)
+		}
+		seen[mx.Host] = true
+	}
+	for _, k := range dkimKeys {
+		if err := k.Validate(); err != nil {
+			return err
+		}
+	}
+	for _, k := range tlsKeys {
+		if err := k.Validate(); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Validate checks the MX host name and preference.
+func (mx MXHost) Validate() error {
+	if err := ValidateDomainName(mx.Host); err != nil {
+		return errorsmod.Wrap(ErrInvalidMXHost, err.Error())
+	}
+	if mx.Preference > 65535 {
+		return errorsmod.Wrapf(ErrInvalidMXHost, "preference %d of '%s' out of range", mx.Preference, mx.Host)
+	}
+	return nil
+}
+
+// NormalizeFingerprint lower-cases a hex fingerprint and removes the colon
+// separators commonly printed by openssl.
+func NormalizeFingerprint(fp string) string {
+	return strings.ToLower(strings.ReplaceAll(strings.TrimSpace(fp), ":", ""))
+}
+
+// Validate checks the selector, algorithm and digest of the fingerprint.
+func (k KeyFingerprint) Validate() error {
+	if k.Selector == "" || len(k.Selector) > 253 {
+		return errorsmod.Wrapf(ErrInvalidFingerprint, "invalid selector '%s'", k.Selector)
+	}
+	for _, c := range k.Selector {
+		if (c < 'a' || c > 'z') && (c < '0' || c > '9') && c != '-' && c != '.' && c != '_' {
+			return errorsmod.Wrapf(ErrInvalidFingerprint, "selector '%s' contains invalid character %q", k.Selector, c)
+		}
+	}
+	if k.Algorithm != FingerprintSHA256 {
+		return errorsmod.Wrapf(ErrInvalidFingerprint, "unsupported algorithm '%s'", k.Algorithm)
+	}
+	if k.Fingerprint != NormalizeFingerprint(k.Fingerprint) {
+		return errorsmod.Wrapf(ErrInvalidFingerprint, "fingerprint '%s' is not normalized", k.Fingerprint)
+	}
+	if b, err := hex.DecodeString(k.Fingerprint); err != nil || len(b) != sha256.Size {
+		return errorsmod.Wrapf(ErrInvalidFingerprint, "'%s' is not a hex-encoded sha256 digest", k.Fingerprint)
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package types_test
+
+import (
+	"strings"
+	"testing"
+
+	"github.com/stretchr/testify/require"
+
+	"github.com/mail-chat-chain/mailchatd/x/mail/types"
+)
+
+func TestValidateDomainName(t *testing.T) {
+	testCases := []struct {
+		name   string
+		domain string
+		valid  bool
+	}{
+		{"valid", "example.org", true},
+		{"valid subdomain", "mx-1.mail.example.org", true},
+		{"empty", "", false},
+		{"single label", "localhost", false},
+		{"upper case", "Example.org", false},
+		{"trailing dot", "example.org.", false},
+		{"leading hyphen", "-bad.example.org", false},
+		{"empty label", "bad..example.org", false},
+		{"invalid character", "b_d.example.org", false},
+		{"label too long", strings.Repeat("a", 64) + ".org", false},
+	}
+
+	for _, tc := range testCases {
+		t.Run(tc.name, func(t *testing.T) {
+			err := types.ValidateDomainName(tc.domain)
+			if tc.valid {
+				require.NoError(t, err)
+			} else {
+				require.ErrorIs(t, err, types.ErrInvalidDomain)
+			}
+		})
+	}
+}
+
+func TestNormalizeDomain(t *testing.T) {
+	require.Equal(t, "example.org", types.NormalizeDomain(" Example.ORG. "))
+}
+
+func TestNewChallenge(t *testing.T) {
+	c := types.NewChallenge("example.org", "owner", 10, []byte("seed"))
+	require.Len(t, c, 32)
+	require.Equal(t, c, types.NewChallenge("example.org", "owner", 10, []byte("seed")))
+	require.NotEqual(t, c, types.NewChallenge("example.org", "owner", 11, []byte("seed")))
+	require.NotEqual(t, c, types.NewChallenge("example.net", "owner", 10, []byte("seed")))
+}
+
+func TestKeyFingerprintValidate(t *testing.T) {
+	fp := strings.Repeat("ab", 32)
+
+	require.NoError(t, types.KeyFingerprint{Selector: "default", Algorithm: types.FingerprintSHA256, Fingerprint: fp}.Validate())
+	require.ErrorIs(t, types.KeyFingerprint{Selector: "", Algorithm: types.FingerprintSHA256, Fingerprint: fp}.Validate(), types.ErrInvalidFingerprint)
+	require.ErrorIs(t, types.KeyFingerprint{Selector: "default", Algorithm: "md5", Fingerprint: fp}.Validate(), types.ErrInvalidFingerprint)
+	require.ErrorIs(t, types.KeyFingerprint{Selector: "default", Algorithm: types.FingerprintSHA256, Fingerprint: strings.ToUpper(fp)}.Validate(), types.ErrInvalidFingerprint)
+	require.ErrorIs(t, types.KeyFingerprint{Selector: "default", Algorithm: types.FingerprintSHA256, Fingerprint: fp[:10]}.Validate(), types.ErrInvalidFingerprint)
+}
+
+func TestValidateMailServers(t *testing.T) {
+	mx := []types.MXHost{{Host: "mx1.example.org", Preference: 10}, {Host: "mx2.example.org", Preference: 20}}
+	require.NoError(t, types.ValidateMailServers(mx, nil, nil))
+
+	dup := append(mx, types.MXHost{Host: "mx1.example.org", Preference: 30})
+	require.ErrorIs(t, types.ValidateMailServers(dup, nil, nil), types.ErrInvalidMXHost)
+
+	require.ErrorIs(t, types.ValidateMailServers([]types.MXHost{{Host: "mx1.example.org", Preference: 70000}}, nil, nil), types.ErrInvalidMXHost)
+}
+
+func TestParamsValidate(t *testing.T) {
+	require.NoError(t, types.DefaultParams().Validate())
+	require.False(t, types.DefaultParams().BondEnabled())
+
+	p := types.DefaultParams()
+	p.ChallengeTtl = 0
+	require.Error(t, p.Validate())
+}
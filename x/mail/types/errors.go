@@ -0,0 +1,26 @@
+package types
+
+import (
+	errorsmod "cosmossdk.io/errors"
+)
+
+// errors
+var (
+	ErrInvalidDomain      = errorsmod.Register(ModuleName, 2, "invalid domain name")
+	ErrDomainNotFound     = errorsmod.Register(ModuleName, 3, "domain not found")
+	ErrDomainTaken        = errorsmod.Register(ModuleName, 4, "domain is registered by another account")
+	ErrNotOwner           = errorsmod.Register(ModuleName, 5, "signer is not the domain owner")
+	ErrNotPending         = errorsmod.Register(ModuleName, 6, "domain is not pending verification")
+	ErrNotVerified        = errorsmod.Register(ModuleName, 7, "domain is not verified")
+	ErrChallengeMismatch  = errorsmod.Register(ModuleName, 8, "challenge does not match")
+	ErrChallengeExpired   = errorsmod.Register(ModuleName, 9, "challenge expired")
+	ErrNotBondedValidator = errorsmod.Register(ModuleName, 10, "attestor is not a bonded validator")
+	ErrDuplicateAttest    = errorsmod.Register(ModuleName, 11, "validator already attested the domain")
+	ErrInsufficientBond   = errorsmod.Register(ModuleName, 12, "bond is below the minimum")
+	ErrBondDisabled       = errorsmod.Register(ModuleName, 13, "bond verification is disabled")
+	ErrInvalidMXHost      = errorsmod.Register(ModuleName, 14, "invalid MX host")
+	ErrInvalidFingerprint = errorsmod.Register(ModuleName, 15, "invalid key fingerprint")
+	ErrTooManyRecords     = errorsmod.Register(ModuleName, 16, "too many records")
+	ErrAlreadyBonded      = errorsmod.Register(ModuleName, 17, "domain is already bonded")
+	ErrClaimPending       = errorsmod.Register(ModuleName, 18, "account already has a pending claim of the domain")
+)
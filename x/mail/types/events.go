@@ -0,0 +1,18 @@
+package types
+
+// x/mail events
+const (
+	EventTypeRegisterDomain   = "register_domain"
+	EventTypeAttestDomain     = "attest_domain"
+	EventTypeBondDomain       = "bond_domain"
+	EventTypeVerifyDomain     = "verify_domain"
+	EventTypeSetMailServers   = "set_mail_servers"
+	EventTypeDeregisterDomain = "deregister_domain"
+
+	AttributeKeyDomain    = "domain"
+	AttributeKeyOwner     = "owner"
+	AttributeKeyChallenge = "challenge"
+	AttributeKeyValidator = "validator"
+	AttributeKeyMethod    = "method"
+	AttributeKeyAmount    = "amount"
+)
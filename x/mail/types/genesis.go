@@ -0,0 +1,40 @@
+package types
+
+import "fmt"
+
+// NewGenesisState creates a new genesis state.
+func NewGenesisState(params Params, domains []Domain) GenesisState {
+	return GenesisState{
+		Params:  params,
+		Domains: domains,
+	}
+}
+
+// DefaultGenesisState sets default x/mail genesis state with no registered
+// domains.
+func DefaultGenesisState() *GenesisState {
+	return &GenesisState{
+		Params:  DefaultParams(),
+		Domains: []Domain{},
+	}
+}
+
+// Validate performs basic genesis state validation returning an error upon any
+// failure.
+func (gs GenesisState) Validate() error {
+	if err := gs.Params.Validate(); err != nil {
+		return err
+	}
+
+	seen := make(map[string]bool, len(gs.Domains))
+	for _, d := range gs.Domains {
+		if seen[d.Key()] {
+			return fmt.Errorf("domain duplicated on genesis: '%s'", d.Key())
+		}
+		if err := d.Validate(); err != nil {
+			return err
+		}
+		seen[d.Key()] = true
+	}
+	return nil
+}
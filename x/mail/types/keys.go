@@ -0,0 +1,21 @@
+package types
+
+import "cosmossdk.io/collections"
+
+const (
+	// ModuleName defines the module name
+	ModuleName = "mail"
+
+	// StoreKey to be used when creating the KVStore
+	StoreKey = ModuleName
+
+	// RouterKey to be used for message routing
+	RouterKey = ModuleName
+)
+
+// prefixes for the module store
+var (
+	ParamsKey      = collections.NewPrefix(0)
+	DomainsKey     = collections.NewPrefix(1)
+	ClaimExpiryKey = collections.NewPrefix(2)
+)
@@ -0,0 +1,1790 @@
+// Code generated by protoc-gen-gogo. DO NOT EDIT.
+// source: mailchat/mail/v1/mail.proto
+
+package types
+
+import (
+	fmt "fmt"
+	_ "github.com/cosmos/cosmos-proto"
+	types "github.com/cosmos/cosmos-sdk/types"
+	_ "github.com/cosmos/cosmos-sdk/types/tx/amino"
+	_ "github.com/cosmos/gogoproto/gogoproto"
+	proto "github.com/cosmos/gogoproto/proto"
+	io "io"
+	math "math"
+	math_bits "math/bits"
+)
+
+// Reference imports to suppress errors if they are not otherwise used.
+var _ = proto.Marshal
+var _ = fmt.Errorf
+var _ = math.Inf
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the proto package it is being compiled against.
+// A compilation error at this line likely means your copy of the
+// proto package needs to be updated.
+const _ = proto.GoGoProtoPackageIsVersion3 // please upgrade the proto package
+
+// DomainStatus describes where a domain is in the verification process.
+type DomainStatus int32
+
+const (
+	// DOMAIN_STATUS_UNSPECIFIED is never stored.
+	DOMAIN_STATUS_UNSPECIFIED DomainStatus = 0
+	// DOMAIN_STATUS_PENDING means the DNS challenge has not been proven yet.
+	DOMAIN_STATUS_PENDING DomainStatus = 1
+	// DOMAIN_STATUS_VERIFIED means control over the domain was proven.
+	DOMAIN_STATUS_VERIFIED DomainStatus = 2
+)
+
+var DomainStatus_name = map[int32]string{
+	0: "DOMAIN_STATUS_UNSPECIFIED",
+	1: "DOMAIN_STATUS_PENDING",
+	2: "DOMAIN_STATUS_VERIFIED",
+}
+
+var DomainStatus_value = map[string]int32{
+	"DOMAIN_STATUS_UNSPECIFIED": 0,
+	"DOMAIN_STATUS_PENDING":     1,
+	"DOMAIN_STATUS_VERIFIED":    2,
+}
+
+func (x DomainStatus) String() string {
+	return proto.EnumName(DomainStatus_name, int32(x))
+}
+
+func (DomainStatus) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_a6002c82f922b360, []int{0}
+}
+
+// VerificationMethod describes how control over a domain was proven.
+type VerificationMethod int32
+
+const (
+	// VERIFICATION_METHOD_NONE is used for pending domains.
+	VERIFICATION_METHOD_NONE VerificationMethod = 0
+	// VERIFICATION_METHOD_ATTESTATION means enough bonded validators observed
+	// the DNS TXT challenge.
+	VERIFICATION_METHOD_ATTESTATION VerificationMethod = 1
+	// VERIFICATION_METHOD_BOND means the owner bonded at least min_bond and
+	// a bonded validator observed the DNS TXT challenge.
+	VERIFICATION_METHOD_BOND VerificationMethod = 2
+)
+
+var VerificationMethod_name = map[int32]string{
+	0: "VERIFICATION_METHOD_NONE",
+	1: "VERIFICATION_METHOD_ATTESTATION",
+	2: "VERIFICATION_METHOD_BOND",
+}
+
+var VerificationMethod_value = map[string]int32{
+	"VERIFICATION_METHOD_NONE":        0,
+	"VERIFICATION_METHOD_ATTESTATION": 1,
+	"VERIFICATION_METHOD_BOND":        2,
+}
+
+func (x VerificationMethod) String() string {
+	return proto.EnumName(VerificationMethod_name, int32(x))
+}
+
+func (VerificationMethod) EnumDescriptor() ([]byte, []int) {
+	return fileDescriptor_a6002c82f922b360, []int{1}
+}
+
+// Params defines the x/mail module parameters.
+type Params struct {
+	// challenge_ttl is the number of blocks a DNS challenge stays valid after
+	// the domain was registered.
+	ChallengeTtl int64 `protobuf:"varint,1,opt,name=challenge_ttl,json=challengeTtl,proto3" json:"challenge_ttl,omitempty"`
+	// min_attestations is the number of distinct bonded validators that must
+	// attest the DNS challenge before the domain is considered verified.
+	MinAttestations uint32 `protobuf:"varint,2,opt,name=min_attestations,json=minAttestations,proto3" json:"min_attestations,omitempty"`
+	// min_bond is the stake an owner can bond to need a single validator
+	// attestation instead of min_attestations. A zero amount disables bond-based
+	// verification.
+	MinBond types.Coin `protobuf:"bytes,3,opt,name=min_bond,json=minBond,proto3" json:"min_bond"`
+	// max_mx_hosts limits the number of MX hosts a domain can declare.
+	MaxMxHosts uint32 `protobuf:"varint,4,opt,name=max_mx_hosts,json=maxMxHosts,proto3" json:"max_mx_hosts,omitempty"`
+	// max_keys limits the number of DKIM and TLS key fingerprints (each) a
+	// domain can declare.
+	MaxKeys uint32 `protobuf:"varint,5,opt,name=max_keys,json=maxKeys,proto3" json:"max_keys,omitempty"`
+}
+
+func (m *Params) Reset()         { *m = Params{} }
+func (m *Params) String() string { return proto.CompactTextString(m) }
+func (*Params) ProtoMessage()    {}
+func (*Params) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a6002c82f922b360, []int{0}
+}
+func (m *Params) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Params) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Params.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Params) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Params.Merge(m, src)
+}
+func (m *Params) XXX_Size() int {
+	return m.Size()
+}
+func (m *Params) XXX_DiscardUnknown() {
+	xxx_messageInfo_Params.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Params proto.InternalMessageInfo
+
+func (m *Params) GetChallengeTtl() int64 {
+	if m != nil {
+		return m.ChallengeTtl
+	}
+	return 0
+}
+
+func (m *Params) GetMinAttestations() uint32 {
+	if m != nil {
+		return m.MinAttestations
+	}
+	return 0
+}
+
+func (m *Params) GetMinBond() types.Coin {
+	if m != nil {
+		return m.MinBond
+	}
+	return types.Coin{}
+}
+
+func (m *Params) GetMaxMxHosts() uint32 {
+	if m != nil {
+		return m.MaxMxHosts
+	}
+	return 0
+}
+
+func (m *Params) GetMaxKeys() uint32 {
+	if m != nil {
+		return m.MaxKeys
+	}
+	return 0
+}
+
+// MXHost is a mail exchanger serving a domain.
+type MXHost struct {
+	// host is the fully qualified host name of the mail server.
+	Host string `protobuf:"bytes,1,opt,name=host,proto3" json:"host,omitempty"`
+	// preference is the MX preference, lower values are tried first.
+	Preference uint32 `protobuf:"varint,2,opt,name=preference,proto3" json:"preference,omitempty"`
+}
+
+func (m *MXHost) Reset()         { *m = MXHost{} }
+func (m *MXHost) String() string { return proto.CompactTextString(m) }
+func (*MXHost) ProtoMessage()    {}
+func (*MXHost) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a6002c82f922b360, []int{1}
+}
+func (m *MXHost) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *MXHost) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_MXHost.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *MXHost) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_MXHost.Merge(m, src)
+}
+func (m *MXHost) XXX_Size() int {
+	return m.Size()
+}
+func (m *MXHost) XXX_DiscardUnknown() {
+	xxx_messageInfo_MXHost.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_MXHost proto.InternalMessageInfo
+
+func (m *MXHost) GetHost() string {
+	if m != nil {
+		return m.Host
+	}
+	return ""
+}
+
+func (m *MXHost) GetPreference() uint32 {
+	if m != nil {
+		return m.Preference
+	}
+	return 0
+}
+
+// KeyFingerprint identifies a DKIM or TLS public key by its digest.
+type KeyFingerprint struct {
+	// selector is the DKIM selector for DKIM keys and the host name the
+	// certificate is served for in case of TLS keys.
+	Selector string `protobuf:"bytes,1,opt,name=selector,proto3" json:"selector,omitempty"`
+	// algorithm is the digest algorithm, currently only "sha256".
+	Algorithm string `protobuf:"bytes,2,opt,name=algorithm,proto3" json:"algorithm,omitempty"`
+	// fingerprint is the hex-encoded digest of the SubjectPublicKeyInfo.
+	Fingerprint string `protobuf:"bytes,3,opt,name=fingerprint,proto3" json:"fingerprint,omitempty"`
+}
+
+func (m *KeyFingerprint) Reset()         { *m = KeyFingerprint{} }
+func (m *KeyFingerprint) String() string { return proto.CompactTextString(m) }
+func (*KeyFingerprint) ProtoMessage()    {}
+func (*KeyFingerprint) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a6002c82f922b360, []int{2}
+}
+func (m *KeyFingerprint) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *KeyFingerprint) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_KeyFingerprint.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *KeyFingerprint) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_KeyFingerprint.Merge(m, src)
+}
+func (m *KeyFingerprint) XXX_Size() int {
+	return m.Size()
+}
+func (m *KeyFingerprint) XXX_DiscardUnknown() {
+	xxx_messageInfo_KeyFingerprint.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_KeyFingerprint proto.InternalMessageInfo
+
+func (m *KeyFingerprint) GetSelector() string {
+	if m != nil {
+		return m.Selector
+	}
+	return ""
+}
+
+func (m *KeyFingerprint) GetAlgorithm() string {
+	if m != nil {
+		return m.Algorithm
+	}
+	return ""
+}
+
+func (m *KeyFingerprint) GetFingerprint() string {
+	if m != nil {
+		return m.Fingerprint
+	}
+	return ""
+}
+
+// Domain is the on-chain registry entry of a mail domain.
+type Domain struct {
+	// name is the lower-case domain name without the trailing dot.
+	Name string `protobuf:"bytes,1,opt,name=name,proto3" json:"name,omitempty"`
+	// owner is the account that registered the domain.
+	Owner string `protobuf:"bytes,2,opt,name=owner,proto3" json:"owner,omitempty"`
+	// status is the verification status of the domain.
+	Status DomainStatus `protobuf:"varint,3,opt,name=status,proto3,enum=mailchat.mail.v1.DomainStatus" json:"status,omitempty"`
+	// challenge is the token that has to be published in the
+	// _mailchat-challenge.<name> TXT record.
+	Challenge string `protobuf:"bytes,4,opt,name=challenge,proto3" json:"challenge,omitempty"`
+	// challenge_expiry is the block height after which the challenge can no
+	// longer be proven.
+	ChallengeExpiry int64 `protobuf:"varint,5,opt,name=challenge_expiry,json=challengeExpiry,proto3" json:"challenge_expiry,omitempty"`
+	// attestations lists the operator addresses of validators that observed the
+	// challenge.
+	Attestations []string `protobuf:"bytes,6,rep,name=attestations,proto3" json:"attestations,omitempty"`
+	// verified_by is the method used to prove control over the domain.
+	VerifiedBy VerificationMethod `protobuf:"varint,7,opt,name=verified_by,json=verifiedBy,proto3,enum=mailchat.mail.v1.VerificationMethod" json:"verified_by,omitempty"`
+	// verified_height is the block height the domain was verified at.
+	VerifiedHeight int64 `protobuf:"varint,8,opt,name=verified_height,json=verifiedHeight,proto3" json:"verified_height,omitempty"`
+	// bond is the stake escrowed by the owner, if any.
+	Bond types.Coin `protobuf:"bytes,9,opt,name=bond,proto3" json:"bond"`
+	// mx_hosts are the mail exchangers declared for the domain.
+	MxHosts []MXHost `protobuf:"bytes,10,rep,name=mx_hosts,json=mxHosts,proto3" json:"mx_hosts"`
+	// dkim_keys are the fingerprints of DKIM keys used to sign mail from the
+	// domain.
+	DkimKeys []KeyFingerprint `protobuf:"bytes,11,rep,name=dkim_keys,json=dkimKeys,proto3" json:"dkim_keys"`
+	// tls_keys are the fingerprints of TLS keys used by the MX hosts.
+	TlsKeys []KeyFingerprint `protobuf:"bytes,12,rep,name=tls_keys,json=tlsKeys,proto3" json:"tls_keys"`
+}
+
+func (m *Domain) Reset()         { *m = Domain{} }
+func (m *Domain) String() string { return proto.CompactTextString(m) }
+func (*Domain) ProtoMessage()    {}
+func (*Domain) Descriptor() ([]byte, []int) {
+	return fileDescriptor_a6002c82f922b360, []int{3}
+}
+func (m *Domain) XXX_Unmarshal(b []byte) error {
+	return m.Unmarshal(b)
+}
+func (m *Domain) XXX_Marshal(b []byte, deterministic bool) ([]byte, error) {
+	if deterministic {
+		return xxx_messageInfo_Domain.Marshal(b, m, deterministic)
+	} else {
+		b = b[:cap(b)]
+		n, err := m.MarshalToSizedBuffer(b)
+		if err != nil {
+			return nil, err
+		}
+		return b[:n], nil
+	}
+}
+func (m *Domain) XXX_Merge(src proto.Message) {
+	xxx_messageInfo_Domain.Merge(m, src)
+}
+func (m *Domain) XXX_Size() int {
+	return m.Size()
+}
+func (m *Domain) XXX_DiscardUnknown() {
+	xxx_messageInfo_Domain.DiscardUnknown(m)
+}
+
+var xxx_messageInfo_Domain proto.InternalMessageInfo
+
+func (m *Domain) GetName() string {
+	if m != nil {
+		return m.Name
+	}
+	return ""
+}
+
+func (m *Domain) GetOwner() string {
+	if m != nil {
+		return m.Owner
+	}
+	return ""
+}
+
+func (m *Domain) GetStatus() DomainStatus {
+	if m != nil {
+		return m.Status
+	}
+	return DOMAIN_STATUS_UNSPECIFIED
+}
+
+func (m *Domain) GetChallenge() string {
+	if m != nil {
+		return m.Challenge
+	}
+	return ""
+}
+
+func (m *Domain) GetChallengeExpiry() int64 {
+	if m != nil {
+		return m.ChallengeExpiry
+	}
+	return 0
+}
+
+func (m *Domain) GetAttestations() []string {
+	if m != nil {
+		return m.Attestations
+	}
+	return nil
+}
+
+func (m *Domain) GetVerifiedBy() VerificationMethod {
+	if m != nil {
+		return m.VerifiedBy
+	}
+	return VERIFICATION_METHOD_NONE
+}
+
+func (m *Domain) GetVerifiedHeight() int64 {
+	if m != nil {
+		return m.VerifiedHeight
+	}
+	return 0
+}
+
+func (m *Domain) GetBond() types.Coin {
+	if m != nil {
+		return m.Bond
+	}
+	return types.Coin{}
+}
+
+func (m *Domain) GetMxHosts() []MXHost {
+	if m != nil {
+		return m.MxHosts
+	}
+	return nil
+}
+
+func (m *Domain) GetDkimKeys() []KeyFingerprint {
+	if m != nil {
+		return m.DkimKeys
+	}
+	return nil
+}
+
+func (m *Domain) GetTlsKeys() []KeyFingerprint {
+	if m != nil {
+		return m.TlsKeys
+	}
+	return nil
+}
+
+func init() {
+	proto.RegisterEnum("mailchat.mail.v1.DomainStatus", DomainStatus_name, DomainStatus_value)
+	proto.RegisterEnum("mailchat.mail.v1.VerificationMethod", VerificationMethod_name, VerificationMethod_value)
+	proto.RegisterType((*Params)(nil), "mailchat.mail.v1.Params")
+	proto.RegisterType((*MXHost)(nil), "mailchat.mail.v1.MXHost")
+	proto.RegisterType((*KeyFingerprint)(nil), "mailchat.mail.v1.KeyFingerprint")
+	proto.RegisterType((*Domain)(nil), "mailchat.mail.v1.Domain")
+}
+
+func init() { proto.RegisterFile("mailchat/mail/v1/mail.proto", fileDescriptor_a6002c82f922b360) }
+
+var fileDescriptor_a6002c82f922b360 = []byte{
+	// 842 bytes of a gzipped FileDescriptorProto
+	0x1f, 0x8b, 0x08, 0x00, 0x00, 0x00, 0x00, 0x00, 0x02, 0xff, 0x94, 0x54, 0x4f, 0x6f, 0x1b, 0x45,
+	0x14, 0xf7, 0xd6, 0x8e, 0xff, 0x3c, 0xbb, 0x89, 0x19, 0x95, 0x6a, 0xed, 0xb6, 0x5b, 0x93, 0x22,
+	0x61, 0x22, 0x65, 0x2d, 0x07, 0x09, 0x01, 0x42, 0x42, 0x76, 0xbc, 0x25, 0x56, 0xe4, 0x75, 0xb4,
+	0x76, 0x23, 0xc4, 0x65, 0x35, 0xf6, 0x4e, 0xec, 0xa1, 0xbb, 0x33, 0xd6, 0xee, 0x34, 0xd8, 0xe2,
+	0xc4, 0x0d, 0x71, 0xe2, 0x3b, 0x70, 0xe1, 0xd8, 0x43, 0x3f, 0x44, 0x8f, 0x55, 0x4f, 0x9c, 0x10,
+	0x4a, 0x0e, 0xfd, 0x00, 0x7c, 0x01, 0x34, 0xb3, 0xeb, 0x4d, 0x4c, 0xe8, 0x21, 0x97, 0xf5, 0xcc,
+	0xef, 0xbd, 0xdf, 0x6f, 0xde, 0x7b, 0xf3, 0xf3, 0xc0, 0x83, 0x00, 0x53, 0x7f, 0x3a, 0xc7, 0xa2,
+	0x25, 0x17, 0xad, 0xf3, 0xb6, 0xfa, 0x35, 0x17, 0x21, 0x17, 0x1c, 0x55, 0xd7, 0x41, 0x53, 0x81,
+	0xe7, 0xed, 0xfa, 0x07, 0x38, 0xa0, 0x8c, 0xb7, 0xd4, 0x37, 0x4e, 0xaa, 0x1b, 0x53, 0x1e, 0x05,
+	0x3c, 0x6a, 0x4d, 0x70, 0x44, 0x5a, 0xe7, 0xed, 0x09, 0x11, 0xb8, 0xdd, 0x9a, 0x72, 0xca, 0x92,
+	0x78, 0x2d, 0x8e, 0xbb, 0x6a, 0xd7, 0x8a, 0x37, 0x49, 0xe8, 0xde, 0x8c, 0xcf, 0x78, 0x8c, 0xcb,
+	0x55, 0x8c, 0xee, 0xfe, 0xa3, 0x41, 0xfe, 0x04, 0x87, 0x38, 0x88, 0xd0, 0x13, 0xb8, 0x3b, 0x9d,
+	0x63, 0xdf, 0x27, 0x6c, 0x46, 0x5c, 0x21, 0x7c, 0x5d, 0x6b, 0x68, 0xcd, 0xac, 0x53, 0x49, 0xc1,
+	0xb1, 0xf0, 0xd1, 0xa7, 0x50, 0x0d, 0x28, 0x73, 0xb1, 0x10, 0x24, 0x12, 0x58, 0x50, 0xce, 0x22,
+	0xfd, 0x4e, 0x43, 0x6b, 0xde, 0x75, 0x76, 0x02, 0xca, 0x3a, 0xd7, 0x60, 0xf4, 0x0d, 0x14, 0x65,
+	0xea, 0x84, 0x33, 0x4f, 0xcf, 0x36, 0xb4, 0x66, 0xf9, 0xa0, 0x66, 0x26, 0x15, 0xc9, 0xf2, 0xcd,
+	0xa4, 0x7c, 0xf3, 0x90, 0x53, 0xd6, 0x2d, 0xbd, 0xfe, 0xeb, 0x71, 0xe6, 0x8f, 0x77, 0x2f, 0xf7,
+	0x34, 0xa7, 0x10, 0x50, 0xd6, 0xe5, 0xcc, 0x43, 0x0d, 0xa8, 0x04, 0x78, 0xe9, 0x06, 0x4b, 0x77,
+	0xce, 0x23, 0x11, 0xe9, 0x39, 0x75, 0x0e, 0x04, 0x78, 0x39, 0x58, 0x1e, 0x49, 0x04, 0xd5, 0xa0,
+	0x28, 0x33, 0x9e, 0x93, 0x55, 0xa4, 0x6f, 0xa9, 0x68, 0x21, 0xc0, 0xcb, 0x63, 0xb2, 0x8a, 0xbe,
+	0x7a, 0xf0, 0xeb, 0xbb, 0x97, 0x7b, 0xf7, 0xd3, 0x81, 0x2f, 0xe3, 0x91, 0xc7, 0xad, 0xee, 0x7e,
+	0x0d, 0xf9, 0xc1, 0x77, 0x52, 0x02, 0x21, 0xc8, 0x49, 0x71, 0xd5, 0x6b, 0xc9, 0x51, 0x6b, 0x64,
+	0x00, 0x2c, 0x42, 0x72, 0x46, 0x42, 0xc2, 0xa6, 0x24, 0xe9, 0xee, 0x1a, 0xb2, 0xeb, 0xc3, 0xf6,
+	0x31, 0x59, 0x3d, 0xa5, 0x6c, 0x46, 0xc2, 0x45, 0x48, 0x99, 0x40, 0x75, 0x28, 0x46, 0xc4, 0x27,
+	0x53, 0xc1, 0xc3, 0x44, 0x29, 0xdd, 0xa3, 0x87, 0x50, 0xc2, 0xfe, 0x8c, 0x87, 0x54, 0xcc, 0x03,
+	0x25, 0x56, 0x72, 0xae, 0x00, 0xd4, 0x80, 0xf2, 0xd9, 0x95, 0x90, 0x9a, 0x53, 0xc9, 0xb9, 0x0e,
+	0xed, 0xfe, 0xbc, 0x05, 0xf9, 0x1e, 0x0f, 0x30, 0x65, 0xb2, 0x58, 0x86, 0x03, 0xb2, 0x2e, 0x56,
+	0xae, 0x91, 0x09, 0x5b, 0xfc, 0x47, 0x46, 0xc2, 0x58, 0xba, 0xab, 0xbf, 0x7d, 0xb5, 0x7f, 0x2f,
+	0x99, 0x72, 0xc7, 0xf3, 0x42, 0x12, 0x45, 0x23, 0x11, 0x52, 0x36, 0x73, 0xe2, 0x34, 0xf4, 0x39,
+	0xe4, 0xe5, 0x0d, 0xbd, 0x88, 0xd4, 0x59, 0xdb, 0x07, 0x86, 0xf9, 0x5f, 0xdf, 0x99, 0xf1, 0x69,
+	0x23, 0x95, 0xe5, 0x24, 0xd9, 0xb2, 0x8d, 0xd4, 0x08, 0xea, 0x26, 0x4a, 0xce, 0x15, 0x20, 0x6d,
+	0x91, 0x6e, 0x5c, 0xb2, 0x5c, 0xd0, 0x70, 0xa5, 0x2e, 0x24, 0xeb, 0xec, 0xa4, 0xb8, 0xa5, 0x60,
+	0x64, 0x41, 0x65, 0xc3, 0x3d, 0xf9, 0x46, 0xb6, 0x59, 0xea, 0x7e, 0xf4, 0xf6, 0xd5, 0xfe, 0xa3,
+	0xa4, 0xee, 0x53, 0xec, 0x53, 0x0f, 0x0b, 0x1e, 0x6e, 0x36, 0xb0, 0x41, 0x43, 0x16, 0x94, 0xcf,
+	0x49, 0x48, 0xcf, 0x28, 0xf1, 0xdc, 0xc9, 0x4a, 0x2f, 0xa8, 0x66, 0x3e, 0xbe, 0xd9, 0xcc, 0xa9,
+	0x4a, 0x9a, 0x2a, 0xd6, 0x80, 0x88, 0x39, 0xf7, 0x1c, 0x58, 0x13, 0xbb, 0x2b, 0xf4, 0x09, 0xec,
+	0xa4, 0x32, 0x73, 0x42, 0x67, 0x73, 0xa1, 0x17, 0x55, 0xdd, 0xdb, 0x6b, 0xf8, 0x48, 0xa1, 0xe8,
+	0x0b, 0xc8, 0x29, 0x27, 0x97, 0x6e, 0xe1, 0x64, 0xc5, 0x40, 0x5f, 0x42, 0x31, 0xb5, 0x30, 0x34,
+	0xb2, 0xcd, 0xf2, 0x81, 0x7e, 0xb3, 0xcc, 0xd8, 0x8e, 0xdd, 0x9c, 0x24, 0x3b, 0x85, 0x20, 0xf1,
+	0xf7, 0x21, 0x94, 0xbc, 0xe7, 0x34, 0x88, 0x0d, 0x5e, 0x56, 0xdc, 0xc6, 0x4d, 0xee, 0xa6, 0x19,
+	0x13, 0x8d, 0xa2, 0x24, 0xca, 0x7f, 0x02, 0xea, 0x40, 0x51, 0xf8, 0x51, 0xac, 0x51, 0xb9, 0x95,
+	0x46, 0x41, 0xf8, 0x91, 0x94, 0xd8, 0xfb, 0x01, 0x2a, 0xd7, 0x4d, 0x81, 0x1e, 0x41, 0xad, 0x37,
+	0x1c, 0x74, 0xfa, 0xb6, 0x3b, 0x1a, 0x77, 0xc6, 0xcf, 0x46, 0xee, 0x33, 0x7b, 0x74, 0x62, 0x1d,
+	0xf6, 0x9f, 0xf6, 0xad, 0x5e, 0x35, 0x83, 0x6a, 0xf0, 0xe1, 0x66, 0xf8, 0xc4, 0xb2, 0x7b, 0x7d,
+	0xfb, 0xdb, 0xaa, 0x86, 0xea, 0x70, 0x7f, 0x33, 0x74, 0x6a, 0x39, 0x31, 0xed, 0x4e, 0x3d, 0xf7,
+	0xcb, 0xef, 0x46, 0x66, 0xef, 0x27, 0x40, 0x37, 0xef, 0x0c, 0x3d, 0x04, 0x3d, 0xce, 0x3c, 0xec,
+	0x8c, 0xfb, 0x43, 0xdb, 0x1d, 0x58, 0xe3, 0xa3, 0x61, 0xcf, 0xb5, 0x87, 0xb6, 0x55, 0xcd, 0xa0,
+	0x27, 0xf0, 0xf8, 0xff, 0xa2, 0x9d, 0xf1, 0xd8, 0x92, 0xc7, 0xf4, 0x87, 0x76, 0x55, 0x7b, 0x9f,
+	0x44, 0x77, 0x68, 0xa7, 0x87, 0x77, 0x8f, 0x5f, 0x5f, 0x18, 0xda, 0x9b, 0x0b, 0x43, 0xfb, 0xfb,
+	0xc2, 0xd0, 0x7e, 0xbb, 0x34, 0x32, 0x6f, 0x2e, 0x8d, 0xcc, 0x9f, 0x97, 0x46, 0xe6, 0xfb, 0xf6,
+	0x8c, 0x8a, 0xf9, 0x8b, 0x89, 0x39, 0xe5, 0x81, 0x7a, 0x4a, 0xf6, 0xe5, 0xf8, 0xe4, 0x87, 0xb2,
+	0xd6, 0x7a, 0x9a, 0xde, 0xfa, 0x99, 0x11, 0xab, 0x05, 0x89, 0x26, 0x79, 0xf5, 0xc4, 0x7e, 0xf6,
+	0xef, 0x00, 0x8d, 0x3e, 0x03, 0x3c, 0xf7, 0x05, 0x00, 0x00,
+}
+
+func (m *Params) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Params) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Params) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.MaxKeys != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.MaxKeys))
+		i--
+		dAtA[i] = 0x28
+	}
+	if m.MaxMxHosts != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.MaxMxHosts))
+		i--
+		dAtA[i] = 0x20
+	}
+	{
+		size, err := m.MinBond.MarshalToSizedBuffer(dAtA[:i])
+		if err != nil {
+			return 0, err
+		}
+		i -= size
+		i = encodeVarintMail(dAtA, i, uint64(size))
+	}
+	i--
+	dAtA[i] = 0x1a
+	if m.MinAttestations != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.MinAttestations))
+		i--
+		dAtA[i] = 0x10
+	}
+	if m.ChallengeTtl != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.ChallengeTtl))
+		i--
+		dAtA[i] = 0x8
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *MXHost) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *MXHost) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *MXHost) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if m.Preference != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.Preference))
+		i--
+		dAtA[i] = 0x10
+	}
+	if len(m.Host) > 0 {
+		i -= len(m.Host)
+		copy(dAtA[i:], m.Host)
+		i = encodeVarintMail(dAtA, i, uint64(len(m.Host)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *KeyFingerprint) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *KeyFingerprint) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *KeyFingerprint) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.Fingerprint) > 0 {
+		i -= len(m.Fingerprint)
+		copy(dAtA[i:], m.Fingerprint)
+		i = encodeVarintMail(dAtA, i, uint64(len(m.Fingerprint)))
+		i--
+		dAtA[i] = 0x1a
+	}
+	if len(m.Algorithm) > 0 {
+		i -= len(m.Algorithm)
+		copy(dAtA[i:], m.Algorithm)
+		i = encodeVarintMail(dAtA, i, uint64(len(m.Algorithm)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Selector) > 0 {
+		i -= len(m.Selector)
+		copy(dAtA[i:], m.Selector)
+		i = encodeVarintMail(dAtA, i, uint64(len(m.Selector)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func (m *Domain) Marshal() (dAtA []byte, err error) {
+	size := m.Size()
+	dAtA = make([]byte, size)
+	n, err := m.MarshalToSizedBuffer(dAtA[:size])
+	if err != nil {
+		return nil, err
+	}
+	return dAtA[:n], nil
+}
+
+func (m *Domain) MarshalTo(dAtA []byte) (int, error) {
+	size := m.Size()
+	return m.MarshalToSizedBuffer(dAtA[:size])
+}
+
+func (m *Domain) MarshalToSizedBuffer(dAtA []byte) (int, error) {
+	i := len(dAtA)
+	_ = i
+	var l int
+	_ = l
+	if len(m.TlsKeys) > 0 {
+		for iNdEx := len(m.TlsKeys) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.TlsKeys[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintMail(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x62
+		}
+	}
+	if len(m.DkimKeys) > 0 {
+		for iNdEx := len(m.DkimKeys) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.DkimKeys[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintMail(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x5a
+		}
+	}
+	if len(m.MxHosts) > 0 {
+		for iNdEx := len(m.MxHosts) - 1; iNdEx >= 0; iNdEx-- {
+			{
+				size, err := m.MxHosts[iNdEx].MarshalToSizedBuffer(dAtA[:i])
+				if err != nil {
+					return 0, err
+				}
+				i -= size
+				i = encodeVarintMail(dAtA, i, uint64(size))
+			}
+			i--
+			dAtA[i] = 0x52
+		}
+	}
+	{
+		size, err := m.Bond.MarshalToSizedBuffer(dAtA[:i])
+		if err != nil {
+			return 0, err
+		}
+		i -= size
+		i = encodeVarintMail(dAtA, i, uint64(size))
+	}
+	i--
+	dAtA[i] = 0x4a
+	if m.VerifiedHeight != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.VerifiedHeight))
+		i--
+		dAtA[i] = 0x40
+	}
+	if m.VerifiedBy != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.VerifiedBy))
+		i--
+		dAtA[i] = 0x38
+	}
+	if len(m.Attestations) > 0 {
+		for iNdEx := len(m.Attestations) - 1; iNdEx >= 0; iNdEx-- {
+			i -= len(m.Attestations[iNdEx])
+			copy(dAtA[i:], m.Attestations[iNdEx])
+			i = encodeVarintMail(dAtA, i, uint64(len(m.Attestations[iNdEx])))
+			i--
+			dAtA[i] = 0x32
+		}
+	}
+	if m.ChallengeExpiry != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.ChallengeExpiry))
+		i--
+		dAtA[i] = 0x28
+	}
+	if len(m.Challenge) > 0 {
+		i -= len(m.Challenge)
+		copy(dAtA[i:], m.Challenge)
+		i = encodeVarintMail(dAtA, i, uint64(len(m.Challenge)))
+		i--
+		dAtA[i] = 0x22
+	}
+	if m.Status != 0 {
+		i = encodeVarintMail(dAtA, i, uint64(m.Status))
+		i--
+		dAtA[i] = 0x18
+	}
+	if len(m.Owner) > 0 {
+		i -= len(m.Owner)
+		copy(dAtA[i:], m.Owner)
+		i = encodeVarintMail(dAtA, i, uint64(len(m.Owner)))
+		i--
+		dAtA[i] = 0x12
+	}
+	if len(m.Name) > 0 {
+		i -= len(m.Name)
+		copy(dAtA[i:], m.Name)
+		i = encodeVarintMail(dAtA, i, uint64(len(m.Name)))
+		i--
+		dAtA[i] = 0xa
+	}
+	return len(dAtA) - i, nil
+}
+
+func encodeVarintMail(dAtA []byte, offset int, v uint64) int {
+	offset -= sovMail(v)
+	base := offset
+	for v >= 1<<7 {
+		dAtA[offset] = uint8(v&0x7f | 0x80)
+		v >>= 7
+		offset++
+	}
+	dAtA[offset] = uint8(v)
+	return base
+}
+func (m *Params) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	if m.ChallengeTtl != 0 {
+		n += 1 + sovMail(uint64(m.ChallengeTtl))
+	}
+	if m.MinAttestations != 0 {
+		n += 1 + sovMail(uint64(m.MinAttestations))
+	}
+	l = m.MinBond.Size()
+	n += 1 + l + sovMail(uint64(l))
+	if m.MaxMxHosts != 0 {
+		n += 1 + sovMail(uint64(m.MaxMxHosts))
+	}
+	if m.MaxKeys != 0 {
+		n += 1 + sovMail(uint64(m.MaxKeys))
+	}
+	return n
+}
+
+func (m *MXHost) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Host)
+	if l > 0 {
+		n += 1 + l + sovMail(uint64(l))
+	}
+	if m.Preference != 0 {
+		n += 1 + sovMail(uint64(m.Preference))
+	}
+	return n
+}
+
+func (m *KeyFingerprint) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Selector)
+	if l > 0 {
+		n += 1 + l + sovMail(uint64(l))
+	}
+	l = len(m.Algorithm)
+	if l > 0 {
+		n += 1 + l + sovMail(uint64(l))
+	}
+	l = len(m.Fingerprint)
+	if l > 0 {
+		n += 1 + l + sovMail(uint64(l))
+	}
+	return n
+}
+
+func (m *Domain) Size() (n int) {
+	if m == nil {
+		return 0
+	}
+	var l int
+	_ = l
+	l = len(m.Name)
+	if l > 0 {
+		n += 1 + l + sovMail(uint64(l))
+	}
+	l = len(m.Owner)
+	if l > 0 {
+		n += 1 + l + sovMail(uint64(l))
+	}
+	if m.Status != 0 {
+		n += 1 + sovMail(uint64(m.Status))
+	}
+	l = len(m.Challenge)
+	if l > 0 {
+		n += 1 + l + sovMail(uint64(l))
+	}
+	if m.ChallengeExpiry != 0 {
+		n += 1 + sovMail(uint64(m.ChallengeExpiry))
+	}
+	if len(m.Attestations) > 0 {
+		for _, s := range m.Attestations {
+			l = len(s)
+			n += 1 + l + sovMail(uint64(l))
+		}
+	}
+	if m.VerifiedBy != 0 {
+		n += 1 + sovMail(uint64(m.VerifiedBy))
+	}
+	if m.VerifiedHeight != 0 {
+		n += 1 + sovMail(uint64(m.VerifiedHeight))
+	}
+	l = m.Bond.Size()
+	n += 1 + l + sovMail(uint64(l))
+	if len(m.MxHosts) > 0 {
+		for _, e := range m.MxHosts {
+			l = e.Size()
+			n += 1 + l + sovMail(uint64(l))
+		}
+	}
+	if len(m.DkimKeys) > 0 {
+		for _, e := range m.DkimKeys {
+			l = e.Size()
+			n += 1 + l + sovMail(uint64(l))
+		}
+	}
+	if len(m.TlsKeys) > 0 {
+		for _, e := range m.TlsKeys {
+			l = e.Size()
+			n += 1 + l + sovMail(uint64(l))
+		}
+	}
+	return n
+}
+
+func sovMail(x uint64) (n int) {
+	return (math_bits.Len64(x|1) + 6) / 7
+}
+func sozMail(x uint64) (n int) {
+	return sovMail(uint64((x << 1) ^ uint64((int64(x) >> 63))))
+}
+func (m *Params) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMail
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Params: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Params: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ChallengeTtl", wireType)
+			}
+			m.ChallengeTtl = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ChallengeTtl |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinAttestations", wireType)
+			}
+			m.MinAttestations = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MinAttestations |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MinBond", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.MinBond.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 4:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxMxHosts", wireType)
+			}
+			m.MaxMxHosts = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxMxHosts |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MaxKeys", wireType)
+			}
+			m.MaxKeys = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.MaxKeys |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMail(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthMail
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *MXHost) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMail
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: MXHost: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: MXHost: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Host", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Host = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Preference", wireType)
+			}
+			m.Preference = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Preference |= uint32(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMail(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthMail
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *KeyFingerprint) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMail
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: KeyFingerprint: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: KeyFingerprint: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Selector", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Selector = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Algorithm", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Algorithm = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Fingerprint", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Fingerprint = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMail(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthMail
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func (m *Domain) Unmarshal(dAtA []byte) error {
+	l := len(dAtA)
+	iNdEx := 0
+	for iNdEx < l {
+		preIndex := iNdEx
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return ErrIntOverflowMail
+			}
+			if iNdEx >= l {
+				return io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= uint64(b&0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		fieldNum := int32(wire >> 3)
+		wireType := int(wire & 0x7)
+		if wireType == 4 {
+			return fmt.Errorf("proto: Domain: wiretype end group for non-group")
+		}
+		if fieldNum <= 0 {
+			return fmt.Errorf("proto: Domain: illegal tag %d (wire type %d)", fieldNum, wire)
+		}
+		switch fieldNum {
+		case 1:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Name", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Name = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 2:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Owner", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Owner = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 3:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Status", wireType)
+			}
+			m.Status = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.Status |= DomainStatus(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 4:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Challenge", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Challenge = string(dAtA[iNdEx:postIndex])
+			iNdEx = postIndex
+		case 5:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field ChallengeExpiry", wireType)
+			}
+			m.ChallengeExpiry = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.ChallengeExpiry |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 6:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Attestations", wireType)
+			}
+			var stringLen uint64
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				stringLen |= uint64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			intStringLen := int(stringLen)
+			if intStringLen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + intStringLen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.Attestations = append(m.Attestations, string(dAtA[iNdEx:postIndex]))
+			iNdEx = postIndex
+		case 7:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field VerifiedBy", wireType)
+			}
+			m.VerifiedBy = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.VerifiedBy |= VerificationMethod(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 8:
+			if wireType != 0 {
+				return fmt.Errorf("proto: wrong wireType = %d for field VerifiedHeight", wireType)
+			}
+			m.VerifiedHeight = 0
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				m.VerifiedHeight |= int64(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+		case 9:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field Bond", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			if err := m.Bond.Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 10:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field MxHosts", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.MxHosts = append(m.MxHosts, MXHost{})
+			if err := m.MxHosts[len(m.MxHosts)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 11:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field DkimKeys", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.DkimKeys = append(m.DkimKeys, KeyFingerprint{})
+			if err := m.DkimKeys[len(m.DkimKeys)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		case 12:
+			if wireType != 2 {
+				return fmt.Errorf("proto: wrong wireType = %d for field TlsKeys", wireType)
+			}
+			var msglen int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				msglen |= int(b&0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if msglen < 0 {
+				return ErrInvalidLengthMail
+			}
+			postIndex := iNdEx + msglen
+			if postIndex < 0 {
+				return ErrInvalidLengthMail
+			}
+			if postIndex > l {
+				return io.ErrUnexpectedEOF
+			}
+			m.TlsKeys = append(m.TlsKeys, KeyFingerprint{})
+			if err := m.TlsKeys[len(m.TlsKeys)-1].Unmarshal(dAtA[iNdEx:postIndex]); err != nil {
+				return err
+			}
+			iNdEx = postIndex
+		default:
+			iNdEx = preIndex
+			skippy, err := skipMail(dAtA[iNdEx:])
+			if err != nil {
+				return err
+			}
+			if (skippy < 0) || (iNdEx+skippy) < 0 {
+				return ErrInvalidLengthMail
+			}
+			if (iNdEx + skippy) > l {
+				return io.ErrUnexpectedEOF
+			}
+			iNdEx += skippy
+		}
+	}
+
+	if iNdEx > l {
+		return io.ErrUnexpectedEOF
+	}
+	return nil
+}
+func skipMail(dAtA []byte) (n int, err error) {
+	l := len(dAtA)
+	iNdEx := 0
+	depth := 0
+	for iNdEx < l {
+		var wire uint64
+		for shift := uint(0); ; shift += 7 {
+			if shift >= 64 {
+				return 0, ErrIntOverflowMail
+			}
+			if iNdEx >= l {
+				return 0, io.ErrUnexpectedEOF
+			}
+			b := dAtA[iNdEx]
+			iNdEx++
+			wire |= (uint64(b) & 0x7F) << shift
+			if b < 0x80 {
+				break
+			}
+		}
+		wireType := int(wire & 0x7)
+		switch wireType {
+		case 0:
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				iNdEx++
+				if dAtA[iNdEx-1] < 0x80 {
+					break
+				}
+			}
+		case 1:
+			iNdEx += 8
+		case 2:
+			var length int
+			for shift := uint(0); ; shift += 7 {
+				if shift >= 64 {
+					return 0, ErrIntOverflowMail
+				}
+				if iNdEx >= l {
+					return 0, io.ErrUnexpectedEOF
+				}
+				b := dAtA[iNdEx]
+				iNdEx++
+				length |= (int(b) & 0x7F) << shift
+				if b < 0x80 {
+					break
+				}
+			}
+			if length < 0 {
+				return 0, ErrInvalidLengthMail
+			}
+			iNdEx += length
+		case 3:
+			depth++
+		case 4:
+			if depth == 0 {
+				return 0, ErrUnexpectedEndOfGroupMail
+			}
+			depth--
+		case 5:
+			iNdEx += 4
+		default:
+			return 0, fmt.Errorf("proto: illegal wireType %d", wireType)
+		}
+		if iNdEx < 0 {
+			return 0, ErrInvalidLengthMail
+		}
+		if depth == 0 {
+			return iNdEx, nil
+		}
+	}
+	return 0, io.ErrUnexpectedEOF
+}
+
+var (
+	ErrInvalidLengthMail        = fmt.Errorf("proto: negative length found during unmarshaling")
+	ErrIntOverflowMail          = fmt.Errorf("proto: integer overflow")
+	ErrUnexpectedEndOfGroupMail = fmt.Errorf("proto: unexpected end of group")
+)
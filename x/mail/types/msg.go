@@ -0,0 +1,87 @@
+package types
+
+import (
+	errorsmod "cosmossdk.io/errors"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+	errortypes "github.com/cosmos/cosmos-sdk/types/errors"
+)
+
+var (
+	_ sdk.Msg              = &MsgRegisterDomain{}
+	_ sdk.Msg              = &MsgAttestDomain{}
+	_ sdk.Msg              = &MsgBondDomain{}
+	_ sdk.Msg              = &MsgSetMailServers{}
+	_ sdk.Msg              = &MsgDeregisterDomain{}
+	_ sdk.Msg              = &MsgUpdateParams{}
+	_ sdk.HasValidateBasic = &MsgRegisterDomain{}
+	_ sdk.HasValidateBasic = &MsgAttestDomain{}
+	_ sdk.HasValidateBasic = &MsgBondDomain{}
+	_ sdk.HasValidateBasic = &MsgSetMailServers{}
+	_ sdk.HasValidateBasic = &MsgDeregisterDomain{}
+	_ sdk.HasValidateBasic = &MsgUpdateParams{}
+)
+
+func validateAccount(addr, field string) error {
+	if _, err := sdk.AccAddressFromBech32(addr); err != nil {
+		return errorsmod.Wrapf(errortypes.ErrInvalidAddress, "invalid %s address: %s", field, err)
+	}
+	return nil
+}
+
+// ValidateBasic runs stateless checks on the message
+func (msg MsgRegisterDomain) ValidateBasic() error {
+	if err := validateAccount(msg.Owner, "owner"); err != nil {
+		return err
+	}
+	return ValidateDomainName(msg.Name)
+}
+
+// ValidateBasic runs stateless checks on the message
+func (msg MsgAttestDomain) ValidateBasic() error {
+	if err := validateAccount(msg.Attestor, "attestor"); err != nil {
+		return err
+	}
+	if msg.Challenge == "" {
+		return errorsmod.Wrap(ErrChallengeMismatch, "empty challenge")
+	}
+	return ValidateDomainName(msg.Name)
+}
+
+// ValidateBasic runs stateless checks on the message
+func (msg MsgBondDomain) ValidateBasic() error {
+	if err := validateAccount(msg.Owner, "owner"); err != nil {
+		return err
+	}
+	if !msg.Amount.IsValid() || !msg.Amount.IsPositive() {
+		return errorsmod.Wrapf(errortypes.ErrInvalidCoins, "invalid bond %s", msg.Amount)
+	}
+	return ValidateDomainName(msg.Name)
+}
+
+// ValidateBasic runs stateless checks on the message
+func (msg MsgSetMailServers) ValidateBasic() error {
+	if err := validateAccount(msg.Owner, "owner"); err != nil {
+		return err
+	}
+	if err := ValidateDomainName(msg.Name); err != nil {
+		return err
+	}
+	return ValidateMailServers(msg.MxHosts, msg.DkimKeys, msg.TlsKeys)
+}
+
+// ValidateBasic runs stateless checks on the message
+func (msg MsgDeregisterDomain) ValidateBasic() error {
+	if err := validateAccount(msg.Owner, "owner"); err != nil {
+		return err
+	}
+	return ValidateDomainName(msg.Name)
+}
+
+// ValidateBasic does a sanity check for the params and the authority.
+func (msg MsgUpdateParams) ValidateBasic() error {
+	if err := validateAccount(msg.Authority, "authority"); err != nil {
+		return err
+	}
+	return msg.Params.Validate()
+}
@@ -0,0 +1,70 @@
+package types
+
+import (
+	"fmt"
+
+	sdkmath "cosmossdk.io/math"
+
+	sdk "github.com/cosmos/cosmos-sdk/types"
+)
+
+var (
+	// DefaultChallengeTTL is roughly one day of 6 second blocks.
+	DefaultChallengeTTL int64 = 14400
+	// DefaultMinAttestations requires two bonded validators to observe the
+	// challenge, so a single attestation is only enough for domains with a
+	// bond. Chains with a single validator have to lower it.
+	DefaultMinAttestations uint32 = 2
+	// DefaultMaxMXHosts is the default MX host limit per domain.
+	DefaultMaxMXHosts uint32 = 10
+	// DefaultMaxKeys is the default DKIM/TLS fingerprint limit per domain.
+	DefaultMaxKeys uint32 = 16
+)
+
+// NewParams creates a new Params object
+func NewParams(challengeTTL int64, minAttestations uint32, minBond sdk.Coin, maxMXHosts, maxKeys uint32) Params {
+	return Params{
+		ChallengeTtl:    challengeTTL,
+		MinAttestations: minAttestations,
+		MinBond:         minBond,
+		MaxMxHosts:      maxMXHosts,
+		MaxKeys:         maxKeys,
+	}
+}
+
+// DefaultParams returns default x/mail parameters. Bond verification is
+// disabled until the chain sets min_bond to a positive amount.
+func DefaultParams() Params {
+	return NewParams(
+		DefaultChallengeTTL,
+		DefaultMinAttestations,
+		sdk.NewCoin(sdk.DefaultBondDenom, sdkmath.ZeroInt()),
+		DefaultMaxMXHosts,
+		DefaultMaxKeys,
+	)
+}
+
+// BondEnabled reports whether domains can be verified by bonding stake.
+func (p Params) BondEnabled() bool {
+	return p.MinBond.Amount.IsPositive()
+}
+
+// Validate performs a stateless validation of the parameters.
+func (p Params) Validate() error {
+	if p.ChallengeTtl <= 0 {
+		return fmt.Errorf("challenge ttl must be positive: %d", p.ChallengeTtl)
+	}
+	if p.MinAttestations == 0 {
+		return fmt.Errorf("min attestations cannot be zero")
+	}
+	if err := p.MinBond.Validate(); err != nil {
+		return fmt.Errorf("invalid min bond: %w", err)
+	}
+	if p.MaxMxHosts == 0 {
+		return fmt.Errorf("max mx hosts cannot be zero")
+	}
+	if p.MaxKeys == 0 {
+		return fmt.Errorf("max keys cannot be zero")
+	}
+	return nil
+}